@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/aldor007/mort/pkg/config"
+)
+
+// configCommand is entry point of "mort config" subcommand
+func configCommand(args []string) int {
+	if len(args) == 0 || args[0] != "migrate" {
+		fmt.Println("Usage: mort config migrate [-config path] [-output path]")
+		return 1
+	}
+
+	return migrateConfig(args[1:])
+}
+
+// migrateConfig upgrades configuration file to current version of schema
+// Warnings about deprecated and unknown fields are printed to stderr
+func migrateConfig(args []string) int {
+	fs := flag.NewFlagSet("mort config migrate", flag.ExitOnError)
+	configPath := fs.String("config", "/etc/mort/mort.yml", "Path to configuration")
+	output := fs.String("output", "", "Path of upgraded configuration, it can be path of configuration (default stdout)")
+	fs.Parse(args)
+
+	data, err := ioutil.ReadFile(*configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Unable to read config", err)
+		return 1
+	}
+
+	result, warnings, err := config.Migrate(data)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Unable to migrate config", err)
+		return 1
+	}
+
+	for _, warning := range warnings {
+		fmt.Fprintln(os.Stderr, "Warning:", warning)
+	}
+
+	if *output == "" {
+		os.Stdout.Write(result)
+		return 0
+	}
+
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(*output); err == nil {
+		mode = info.Mode()
+	}
+
+	if err = ioutil.WriteFile(*output, result, mode); err != nil {
+		fmt.Fprintln(os.Stderr, "Unable to write config", err)
+		return 1
+	}
+
+	return 0
+}
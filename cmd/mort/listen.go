@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/aldor007/mort/pkg/config"
+)
+
+// systemdListenFdsStart first file descriptor passed by systemd socket activation
+const systemdListenFdsStart = 3
+
+// systemdListeners listeners passed by systemd socket activation by name of socket
+var systemdListeners = loadSystemdListeners()
+
+// loadSystemdListeners returns sockets passed by systemd (LISTEN_FDS), they are named by FileDescriptorName of socket unit
+// (name of socket unit by default), position of socket is used when names are missing
+// Environment is cleared, so processes started on restart don't take them again
+func loadSystemdListeners() map[string]net.Listener {
+	result := make(map[string]net.Listener)
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return result
+	}
+
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count <= 0 {
+		return result
+	}
+
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	for i := 0; i < count; i++ {
+		name := strconv.Itoa(i)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+
+		f := os.NewFile(uintptr(systemdListenFdsStart+i), name)
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			fmt.Println("Unable to use systemd socket", name, err)
+			continue
+		}
+
+		result[name] = ln
+	}
+
+	return result
+}
+
+// listenAddress returns network and address of listener from configuration
+// unix:/path/to/socket is unix socket, systemd:name is socket passed by systemd, other addresses are TCP
+func listenAddress(l string) (network, address string) {
+	switch {
+	case strings.HasPrefix(l, "unix:"):
+		return "unix", strings.TrimPrefix(l, "unix:")
+	case strings.HasPrefix(l, "systemd:"):
+		return "systemd", strings.TrimPrefix(l, "systemd:")
+	default:
+		return "tcp", l
+	}
+}
+
+// systemdListener returns listener passed by systemd under given name
+func systemdListener(name string) (net.Listener, error) {
+	ln, ok := systemdListeners[name]
+	if !ok {
+		return nil, fmt.Errorf("no systemd socket %s", name)
+	}
+
+	delete(systemdListeners, name)
+	return ln, nil
+}
+
+// listenUnix creates unix socket, socket file left by process which didn't exit cleanly is removed
+// Umask is set during creation, so socket isn't accessible with default permissions before they are changed
+func listenUnix(path string, socketCfg config.UnixSocketCfg) (net.Listener, error) {
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+
+	if socketCfg.Mode != "" {
+		oldMask := syscall.Umask(int(0777 &^ socketCfg.FileMode().Perm()))
+		defer syscall.Umask(oldMask)
+	}
+
+	return net.Listen("unix", path)
+}
+
+// removeStaleSocket removes socket file when no process accepts connections on it
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err == nil {
+		conn.Close()
+		return fmt.Errorf("socket %s is used by other process", path)
+	}
+
+	return os.Remove(path)
+}
+
+// setSocketPermissions changes mode and group of socket file, so other users (e.g. nginx) can connect to it
+func setSocketPermissions(path string, socketCfg config.UnixSocketCfg) error {
+	if socketCfg.Mode != "" {
+		if err := os.Chmod(path, socketCfg.FileMode()); err != nil {
+			return err
+		}
+	}
+
+	if socketCfg.Group == "" {
+		return nil
+	}
+
+	gid, err := strconv.Atoi(socketCfg.Group)
+	if err != nil {
+		group, err := user.LookupGroup(socketCfg.Group)
+		if err != nil {
+			return err
+		}
+
+		if gid, err = strconv.Atoi(group.Gid); err != nil {
+			return err
+		}
+	}
+
+	return os.Chown(path, -1, gid)
+}
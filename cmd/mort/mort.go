@@ -1,6 +1,9 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"flag"
 	"fmt"
 	mortMiddleware "github.com/aldor007/mort/pkg/middleware"
@@ -14,17 +17,22 @@ import (
 	"net"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"sync"
 	"syscall"
 
 	"github.com/aldor007/mort/pkg/config"
+	"github.com/aldor007/mort/pkg/lifecycle"
 	"github.com/aldor007/mort/pkg/lock"
 	"github.com/aldor007/mort/pkg/monitoring"
 	"github.com/aldor007/mort/pkg/object"
+	"github.com/aldor007/mort/pkg/presets"
 	"github.com/aldor007/mort/pkg/processor"
+	"github.com/aldor007/mort/pkg/proxyproto"
 	"github.com/aldor007/mort/pkg/response"
 	"github.com/aldor007/mort/pkg/throttler"
+	"github.com/aldor007/mort/pkg/trash"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.uber.org/zap/zapcore"
@@ -47,34 +55,100 @@ const (
 `
 )
 
-func debugListener(mortConfig *config.Config) (s *http.Server, ln net.Listener, socketPath string) {
+// internalRouter returns router of admin and metrics handlers of listener
+func internalRouter(mortConfig *config.Config, listenerCfg config.ListenerCfg, presetsAPI *presets.API, trashAPI *trash.API, janitor *lifecycle.Janitor) http.Handler {
 	router := chi.NewRouter()
-	router.Mount("/debug", middleware.Profiler())
-	router.Handle("/metrics", promhttp.Handler())
-	s = &http.Server{
-		ReadTimeout:  2 * time.Minute,
-		WriteTimeout: 2 * time.Minute,
-		Handler:      router,
+	if listenerCfg.Has(config.HandlerAdmin) {
+		adminAuth := mortMiddleware.NewAdminAuthMiddleware(mortConfig.Server.Admin)
+		if mortConfig.Server.Admin.Profiling {
+			debug := chi.NewRouter()
+			debug.Use(adminAuth.Handler)
+			debug.Handle("/runtime", monitoring.RuntimeHandler())
+			debug.Mount("/", middleware.Profiler())
+			router.Mount("/debug", debug)
+		}
+		reports := chi.NewRouter()
+		reports.Use(adminAuth.Handler)
+		reports.Handle("/presets", janitor.PresetsReportHandler())
+		reports.Handle("/orphans", janitor.OrphansReportHandler())
+		router.Mount("/reports", reports)
+		if presetsAPI.Enabled() {
+			router.Handle("/presets/*", presetsAPI)
+		}
+		if trashAPI.Enabled() {
+			router.Handle("/trash/*", trashAPI)
+		}
+	}
+
+	if listenerCfg.Has(config.HandlerMetrics) {
+		router.Handle("/metrics", promhttp.Handler())
 	}
 
-	network := "tcp"
-	address := mortConfig.Server.InternalListen
-	socketPath = ""
-	if strings.HasPrefix(address, "unix:") {
-		network = "unix"
-		socketPath = address
-		address = strings.Replace(address, "unix:", "", 1)
+	return router
+}
+
+// isInternalPath check if path belongs to admin or metrics handler of listener
+func isInternalPath(listenerCfg config.ListenerCfg, path string) bool {
+	if listenerCfg.Has(config.HandlerMetrics) && path == "/metrics" {
+		return true
 	}
 
-	ln, err := net.Listen(network, address)
+	if !listenerCfg.Has(config.HandlerAdmin) {
+		return false
+	}
+
+	for _, prefix := range []string{"/debug/", "/reports/", "/presets/", "/trash/"} {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// listenerHandler returns handler serving handler sets of listener
+// Listener serving both public or S3 API and admin or metrics handlers routes requests by path
+func listenerHandler(listenerCfg config.ListenerCfg, public http.Handler, internal http.Handler) http.Handler {
+	servesPublic := listenerCfg.Has(config.HandlerPublic) || listenerCfg.Has(config.HandlerS3)
+	servesInternal := listenerCfg.Has(config.HandlerAdmin) || listenerCfg.Has(config.HandlerMetrics)
+
+	handler := public
+	if servesPublic && servesInternal {
+		handler = http.HandlerFunc(func(resWriter http.ResponseWriter, req *http.Request) {
+			if isInternalPath(listenerCfg, req.URL.Path) {
+				internal.ServeHTTP(resWriter, req)
+				return
+			}
+
+			public.ServeHTTP(resWriter, req)
+		})
+	} else if servesInternal {
+		handler = internal
+	}
+
+	return mortMiddleware.NewListenerMiddleware(listenerCfg).Handler(handler)
+}
+
+// listenConfigured opens listener of address from configuration, it returns path of created unix socket
+func listenConfigured(serverCfg config.Server, l string) (net.Listener, string) {
+	network, address := listenAddress(l)
+	ln, err := listen(network, address, serverCfg.UnixSocket)
 	if err != nil {
 		panic(err)
 	}
 
-	return
+	if network != "unix" {
+		return ln, ""
+	}
+
+	if err = setSocketPermissions(address, serverCfg.UnixSocket); err != nil {
+		panic(fmt.Errorf("unable to set permissions of socket %s: %s", address, err))
+	}
+
+	return ln, address
 }
 
-func handleSignals(servers []*http.Server, socketPaths []string, wg *sync.WaitGroup) {
+func handleSignals(servers []*http.Server, socketPaths []string, drainTimeout time.Duration, wg *sync.WaitGroup) {
 	signalChan := make(chan os.Signal, 1)
 	signal.Notify(signalChan, syscall.SIGUSR2, syscall.SIGKILL, syscall.SIGINT, syscall.SIGTERM, os.Kill)
 	for {
@@ -89,6 +163,24 @@ func handleSignals(servers []*http.Server, socketPaths []string, wg *sync.WaitGr
 			for _, socketPath := range socketPaths {
 				os.Remove(socketPath)
 			}
+			monitoring.Drift().Save()
+			wg.Done()
+			return
+		case syscall.SIGUSR2:
+			// hand over listeners to new process and drain current connections
+			err := restart(drainTimeout)
+			if err != nil {
+				monitoring.Log().Error("Restart failed", zap.Error(err))
+				continue
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+			for _, s := range servers {
+				s.Shutdown(ctx)
+				wg.Done()
+			}
+			cancel()
+			monitoring.Drift().Save()
 			wg.Done()
 			return
 		default:
@@ -96,6 +188,12 @@ func handleSignals(servers []*http.Server, socketPaths []string, wg *sync.WaitGr
 	}
 }
 
+// newThrottler create memory throttler with budget from server configuration
+func newThrottler(serverCfg config.Server) *throttler.MemoryThrottler {
+	throttlerCfg := serverCfg.Throttler
+	return throttler.NewMemoryThrottler(throttlerCfg.MemoryBudgetMB<<20, throttlerCfg.Backlog, time.Duration(throttlerCfg.Timeout)*time.Second)
+}
+
 func configureMonitoring(mortConfig *config.Config) {
 	var logCfg zap.Config
 	if mortConfig.Server.LogLevel == "debug" {
@@ -129,11 +227,203 @@ func configureMonitoring(mortConfig *config.Config) {
 			[]string{"status"},
 		))
 
+		p.RegisterCounterVec("cache_count", prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "mort_cache_count",
+			Help: "mort count of hits, misses, sets, deletes and evictions of response cache per layer and bucket",
+		},
+			[]string{"layer", "bucket", "result"},
+		))
+
+		p.RegisterGaugeVec("cache_size", prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "mort_cache_size",
+			Help: "mort size in bytes of responses in cache layer",
+		},
+			[]string{"layer"},
+		))
+
+		p.RegisterCounterVec("slow_request_count", prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "mort_slow_request_count",
+			Help: "mort count of requests slower than slowLog threshold",
+		},
+			[]string{"method"},
+		))
+
+		p.RegisterCounterVec("access_denied_count", prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "mort_access_denied_count",
+			Help: "mort count of requests denied by access rules of bucket per reason (address, hotlink, hotlink_preset)",
+		},
+			[]string{"bucket", "reason"},
+		))
+
+		p.RegisterCounterVec("bandwidth_sent_bytes", prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "mort_bandwidth_sent_bytes",
+			Help: "mort count of bytes sent through bandwidth limiter (global or name of bucket)",
+		},
+			[]string{"limiter"},
+		))
+
+		p.RegisterCounterVec("bandwidth_throttled_seconds", prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "mort_bandwidth_throttled_seconds",
+			Help: "mort time in seconds responses waited for bandwidth limiter",
+		},
+			[]string{"limiter"},
+		))
+
+		p.RegisterGaugeVec("bandwidth_limit_bytes", prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "mort_bandwidth_limit_bytes",
+			Help: "mort configured bandwidth limit in bytes per second",
+		},
+			[]string{"limiter"},
+		))
+
+		p.RegisterCounterVec("precompressed_count", prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "mort_precompressed_count",
+			Help: "mort count of responses served from pre-compressed objects",
+		},
+			[]string{"bucket", "encoding"},
+		))
+
+		p.RegisterCounterVec("index_count", prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "mort_index_count",
+			Help: "mort count of requests of directories served with index document, autoindex or redirect",
+		},
+			[]string{"bucket", "result"},
+		))
+
+		p.RegisterCounterVec("error_document_count", prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "mort_error_document_count",
+			Help: "mort count of error responses served with error document of bucket",
+		},
+			[]string{"bucket", "status"},
+		))
+
+		p.RegisterCounterVec("website_redirect_count", prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "mort_website_redirect_count",
+			Help: "mort count of redirects of routing rules of websites",
+		},
+			[]string{"bucket", "code"},
+		))
+
+		p.RegisterCounterVec("rewrite_count", prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "mort_rewrite_count",
+			Help: "mort count of requests rewritten, redirected or proxied by rewrite rules",
+		},
+			[]string{"bucket", "action"},
+		))
+
+		p.RegisterCounterVec("host_route_count", prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "mort_host_route_count",
+			Help: "mort count of requests routed to bucket by host",
+		},
+			[]string{"bucket"},
+		))
+
+		p.RegisterCounter("acme_error_count", prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "mort_acme_error_count",
+			Help: "mort count of failed attempts to get certificate from ACME",
+		}))
+
+		p.RegisterCounter("proxy_protocol_error_count", prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "mort_proxy_protocol_error_count",
+			Help: "mort count of connections with invalid PROXY protocol header",
+		}))
+
 		p.RegisterCounter("throttled_count", prometheus.NewCounter(prometheus.CounterOpts{
 			Name: "mort_request_throttled_count",
 			Help: "mort count of throttled requests",
 		}))
 
+		p.RegisterGaugeVec("pool_queue_length", prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "mort_pool_queue_length",
+			Help: "mort number of transforms waiting in pool of bucket",
+		},
+			[]string{"bucket"},
+		))
+
+		p.RegisterCounterVec("pool_throttled_count", prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "mort_pool_throttled_count",
+			Help: "mort count of transforms rejected by pool of bucket",
+		},
+			[]string{"bucket"},
+		))
+
+		p.RegisterCounter("client_close_continued_count", prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "mort_client_close_continued_count",
+			Help: "mort count of requests processed in background after client closed connection",
+		}))
+
+		p.RegisterCounterVec("timeout_count", prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "mort_timeout_count",
+			Help: "mort count of requests which timed out per stage (storage, transform, request)",
+		},
+			[]string{"stage"},
+		))
+
+		p.RegisterCounterVec("engine_unsupported_count", prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "mort_engine_unsupported_count",
+			Help: "mort count of transforms rejected because image engine doesn't support them",
+		},
+			[]string{"engine"},
+		))
+
+		p.RegisterCounter("lifecycle_removed_count", prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "mort_lifecycle_removed_count",
+			Help: "mort count of transformed images removed by lifecycle janitor",
+		}))
+
+		p.RegisterCounter("lifecycle_orphan_count", prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "mort_lifecycle_orphan_count",
+			Help: "mort count of transformed images removed because their originals don't exist",
+		}))
+
+		p.RegisterCounterVec("trash_count", prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "mort_trash_count",
+			Help: "mort count of objects moved to trash, restored from it and expired in it",
+		},
+			[]string{"action"},
+		))
+
+		p.RegisterCounterVec("preset_request_count", prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "mort_preset_request_count",
+			Help: "mort count of requests for images transformed by preset",
+		},
+			[]string{"bucket", "preset"},
+		))
+
+		p.RegisterHistogramVec("preset_response_size", prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "mort_preset_response_size",
+			Help:    "mort size in bytes of images transformed by preset",
+			Buckets: prometheus.ExponentialBuckets(1024, 4, 8),
+		},
+			[]string{"bucket", "preset"},
+		))
+
+		p.RegisterCounterVec("cost_pixels", prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "mort_cost_pixels",
+			Help: "mort count of source pixels processed by transforms",
+		},
+			[]string{"bucket", "preset"},
+		))
+
+		p.RegisterCounterVec("cost_engine_ms", prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "mort_cost_engine_ms",
+			Help: "mort wall time in ms spent by engines on transforms",
+		},
+			[]string{"bucket", "preset"},
+		))
+
+		p.RegisterCounterVec("cost_bytes_out", prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "mort_cost_bytes_out",
+			Help: "mort count of bytes of transformed images",
+		},
+			[]string{"bucket", "preset"},
+		))
+
+		p.RegisterCounter("image_limit_count", prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "mort_image_limit_count",
+			Help: "mort count of source images rejected because they exceed image limits",
+		}))
+
 		p.RegisterGaugeVec("storage_throughput", prometheus.NewGaugeVec(prometheus.GaugeOpts{
 			Name: "mort_storage_throughput",
 			Help: "mort requests storage",
@@ -169,6 +459,74 @@ func configureMonitoring(mortConfig *config.Config) {
 			[]string{"method"},
 		))
 
+		p.RegisterCounterVec("storage_retry_count", prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "mort_storage_retry_count",
+			Help: "mort count of retried storage operations",
+		},
+			[]string{"method", "storage"},
+		))
+
+		p.RegisterCounterVec("storage_failover_count", prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "mort_storage_failover_count",
+			Help: "mort count of reads served by failover origins",
+		},
+			[]string{"storage"},
+		))
+
+		p.RegisterCounterVec("storage_mirror_count", prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "mort_storage_mirror_count",
+			Help: "mort count of objects copied from origin to mirror storage",
+		},
+			[]string{"storage"},
+		))
+
+		p.RegisterCounterVec("disk_cache_count", prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "mort_disk_cache_count",
+			Help: "mort count of hits and misses of local disk cache of storage",
+		},
+			[]string{"result"},
+		))
+
+		p.RegisterCounterVec("worker_count", prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "mort_worker_count",
+			Help: "mort count of started, crashed and recycled worker processes",
+		},
+			[]string{"event"},
+		))
+
+		p.RegisterCounterVec("profile_count", prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "mort_profile_count",
+			Help: "mort count of captured profiles of transforms",
+		},
+			[]string{"kind"},
+		))
+
+		p.RegisterCounter("engine_panic_count", prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "mort_engine_panic_count",
+			Help: "mort count of recovered panics of image engine",
+		}))
+
+		p.RegisterCounterVec("quarantine_count", prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "mort_quarantine_count",
+			Help: "mort count of quarantined source images and requests rejected because of quarantine",
+		},
+			[]string{"event"},
+		))
+
+		p.RegisterGaugeVec("storage_breaker_state", prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "mort_storage_breaker_state",
+			Help: "mort state of storage circuit breaker (0 - closed, 1 - open, 2 - half open)",
+		},
+			[]string{"storage"},
+		))
+
+		p.RegisterCounterVec("storage_conn_count", prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "mort_storage_conn_count",
+			Help: "mort count of connections to remote storage taken from pool",
+		},
+			[]string{"storage", "reused"},
+		))
+
 		p.RegisterCounterVec("request_type", prometheus.NewCounterVec(prometheus.CounterOpts{
 			Name: "mort_request_type_count",
 			Help: "mort count of given request type",
@@ -182,10 +540,80 @@ func configureMonitoring(mortConfig *config.Config) {
 			Buckets: []float64{10.0, 50.0, 100.0, 200.0, 300.0, 400.0, 500., 1000., 2000., 3000., 4000., 5000., 6000., 10000., 30000., 60000., 70000., 80000.},
 		}))
 
+		p.RegisterCounterVec("moderation_count", prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "mort_moderation_count",
+			Help: "mort count of uploads checked by moderation plugin",
+		},
+			[]string{"result"},
+		))
+
+		p.RegisterCounterVec("script_count", prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "mort_script_count",
+			Help: "mort count of requests handled by bucket scripts",
+		},
+			[]string{"result"},
+		))
+
+		p.RegisterCounterVec("plugin_panic_count", prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "mort_plugin_panic_count",
+			Help: "mort count of panics recovered in external plugins",
+		},
+			[]string{"plugin"},
+		))
+
+		p.RegisterCounterVec("antivirus_count", prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "mort_antivirus_count",
+			Help: "mort count of uploads scanned by antivirus",
+		},
+			[]string{"result"},
+		))
+
+		p.RegisterCounterVec("transform_drift_alert", prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "mort_transform_drift_alert_count",
+			Help: "mort count of alerts about drift of transform result size or duration",
+		},
+			[]string{"bucket", "preset", "metric"},
+		))
+
 		monitoring.RegisterReporter(p)
+	} else if mortConfig.Server.Monitoring == monitoring.PushFormatInflux || mortConfig.Server.Monitoring == monitoring.PushFormatOpenTSDB {
+		pushCfg := mortConfig.Server.MetricsPush
+		tags := map[string]string{"host": host}
+		for k, v := range pushCfg.Tags {
+			tags[k] = v
+		}
+
+		p, err := monitoring.NewPushReporter(monitoring.PushOptions{Format: mortConfig.Server.Monitoring, Address: pushCfg.Address,
+			FlushInterval: time.Duration(pushCfg.FlushInterval) * time.Second, Prefix: pushCfg.Prefix, Tags: tags})
+		if err != nil {
+			monitoring.Log().Error("Unable to create metrics reporter", zap.String("monitoring", mortConfig.Server.Monitoring), zap.Error(err))
+		} else {
+			monitoring.RegisterReporter(p)
+		}
+	}
+
+	if mortConfig.Server.Drift.BaselineFile != "" {
+		monitoring.RegisterDriftDetector(monitoring.NewDriftDetector(mortConfig.Server.Drift.BaselineFile,
+			mortConfig.Server.Drift.Threshold, mortConfig.Server.Drift.MinSamples, mortConfig.Server.Drift.ResetBaseline))
+		go func() {
+			for range time.Tick(time.Minute) {
+				if err := monitoring.Drift().Save(); err != nil {
+					monitoring.Log().Warn("Unable to save drift baseline", zap.Error(err))
+				}
+			}
+		}()
 	}
 }
 
+// proxyProtocolListener wraps listener which expects PROXY protocol header, only trusted proxies can send it when they are configured
+func proxyProtocolListener(proxyCfg config.ProxyCfg, address string, ln net.Listener) net.Listener {
+	if !proxyCfg.HasProtocol(address) {
+		return ln
+	}
+
+	return proxyproto.NewListener(ln, proxyCfg.IsTrusted, time.Duration(proxyCfg.HeaderTimeout)*time.Second)
+}
+
 func startServer(s *http.Server, ln net.Listener) {
 	err := s.Serve(ln)
 	if err != nil && err != http.ErrServerClosed {
@@ -194,6 +622,26 @@ func startServer(s *http.Server, ln net.Listener) {
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "warm" {
+		os.Exit(warm(os.Args[2:]))
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "sync" {
+		os.Exit(syncStorages(os.Args[2:]))
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "reshard" {
+		os.Exit(reshard(os.Args[2:]))
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "worker" {
+		os.Exit(runWorker(os.Args[2:]))
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		os.Exit(configCommand(os.Args[2:]))
+	}
+
 	configPath := flag.String("config", "/etc/mort/mort.yml", "Path to configuration")
 	version := flag.Bool("version", false, "get mort version")
 	flag.Parse()
@@ -212,49 +660,149 @@ func main() {
 		panic(err)
 	}
 
+	for _, warning := range imgConfig.Warnings() {
+		monitoring.Log().Warn("Config schema warning", zap.String("warning", warning))
+	}
+
 	fmt.Printf(BANNER, "v"+Version)
 	fmt.Printf("Config file %s listen addr %s montoring: and debug listen %s pid: %d \n", *configPath, imgConfig.Server.Listen, imgConfig.Server.InternalListen, os.Getpid())
 
-	rp := processor.NewRequestProcessor(imgConfig.Server, lock.NewMemoryLock(), throttler.NewBucketThrottler(10))
+	memoryThrottler := newThrottler(imgConfig.Server)
+	rp := processor.NewRequestProcessor(imgConfig.Server, lock.NewMemoryLock(), memoryThrottler)
+
+	if imgConfig.Server.Billing.Output != "" {
+		sink, err := monitoring.NewBillingSink(imgConfig.Server.Billing.Output)
+		if err != nil {
+			panic(err)
+		}
+
+		monitoring.RegisterBillingSink(sink)
+		defer sink.Close()
+	}
+
+	janitor := lifecycle.NewJanitor(imgConfig, memoryThrottler)
+	janitor.Start()
+
+	presetsAPI := presets.NewAPI(imgConfig)
+	if presetsAPI.Enabled() {
+		presetsAPI.Start()
+	}
+
+	trashCleaner := trash.NewCleaner(imgConfig)
+	trashCleaner.Start()
+	trashAPI := trash.NewAPI(imgConfig)
+
+	if imgConfig.Server.SecretsRefresh > 0 {
+		config.WatchSecrets(time.Duration(imgConfig.Server.SecretsRefresh) * time.Second)
+	}
+
+	trustedProxies := mortMiddleware.NewTrustedProxiesMiddleware(imgConfig)
+	router.Use(trustedProxies.Handler)
+
+	hostRouter := mortMiddleware.NewHostRouterMiddleware(imgConfig)
+	router.Use(hostRouter.Handler)
+	router.Use(mortMiddleware.HandlerSets)
 
 	cloudinaryUploadInterceptor := cloudinary.NewUploadInterceptorMiddleware(imgConfig)
 	router.Use(cloudinaryUploadInterceptor.Handler)
 
+	requestID := mortMiddleware.NewRequestIDMiddleware()
+	router.Use(requestID.Handler)
+
+	featureFlags := mortMiddleware.NewFeatureFlagsMiddleware(imgConfig)
+	router.Use(featureFlags.Handler)
+
 	s3Auth := mortMiddleware.NewS3AuthMiddleware(imgConfig)
 	router.Use(s3Auth.Handler)
 
+	rewrite := mortMiddleware.NewRewriteMiddleware(imgConfig)
+	router.Use(rewrite.Handler)
+
+	script := mortMiddleware.NewScriptMiddleware(imgConfig)
+	router.Use(script.Handler)
+
+	adminAuth := mortMiddleware.NewAdminAuthMiddleware(imgConfig.Server.Admin)
+
 	router.Use(func(_ http.Handler) http.Handler {
 		return http.HandlerFunc(func(resWriter http.ResponseWriter, req *http.Request) {
 			metric := "response_time;method:" + req.Method
 			t := monitoring.Report().Timer(metric)
 			defer t.Done()
-			debug := req.Header.Get("X-Mort-Debug") != ""
+			var stages *monitoring.Stages
+			if imgConfig.Server.SlowLog.Threshold > 0 {
+				stages = monitoring.NewStages()
+				req = req.WithContext(monitoring.WithStages(req.Context(), stages))
+			}
+			debug := req.Header.Get("X-Mort-Debug") != "" || mortMiddleware.FeatureFlagsFromContext(req.Context()).Has(mortMiddleware.FlagDebug)
+			parseStart := time.Now()
 			obj, err := object.NewFileObject(req.URL, imgConfig)
+			stages.Since(monitoring.StageParse, parseStart)
 			if err != nil {
-				monitoring.Logs().Errorf("Unable to create file object err = %s", err)
-				response.NewError(400, err).SetDebug(&object.FileObject{Debug: debug}).Send(resWriter)
+				monitoring.Log().Error("Unable to create file object", zap.String("requestId", mortMiddleware.RequestIDFromContext(req.Context())), zap.Error(err))
+				response.NewError(400, err).SetDebug(&object.FileObject{Debug: debug}).
+					FormatError(processor.ErrorFormat(req, nil), mortMiddleware.RequestIDFromContext(req.Context()), "").Send(resWriter)
 				return
 			}
 			obj.Debug = debug
+			if req.URL.Query().Get("debug") == "plan" {
+				// plan describes configuration of bucket, so it requires signed debug flag or admin token instead of header
+				obj.DebugAdmin = adminAuth.Authorized(req)
+				obj.DebugPlan = obj.DebugAdmin || mortMiddleware.FeatureFlagsFromContext(req.Context()).Has(mortMiddleware.FlagDebug)
+			}
+			obj.Meta = req.URL.Query().Get("meta") == "true"
+			obj.Similar = req.URL.Query().Get("similar") == "true"
+			if palette := req.URL.Query().Get("palette"); palette != "" {
+				obj.Palette, err = strconv.Atoi(palette)
+				if err != nil || obj.Palette < 1 {
+					response.NewError(400, errors.New("invalid palette size")).SetDebug(obj).
+						FormatError(processor.ErrorFormat(req, obj), mortMiddleware.RequestIDFromContext(req.Context()), obj.Key).Send(resWriter)
+					return
+				}
+			}
+			if profile := req.URL.Query().Get("profile"); profile != "" {
+				if !mortMiddleware.FeatureFlagsFromContext(req.Context()).Has(mortMiddleware.FlagProfile) {
+					response.NewError(403, errors.New("profiling requires profile feature flag")).SetDebug(obj).
+						FormatError(processor.ErrorFormat(req, obj), mortMiddleware.RequestIDFromContext(req.Context()), obj.Key).Send(resWriter)
+					return
+				}
+				if !processor.IsProfileKind(profile) {
+					response.NewError(400, errors.New("invalid profile kind")).SetDebug(obj).
+						FormatError(processor.ErrorFormat(req, obj), mortMiddleware.RequestIDFromContext(req.Context()), obj.Key).Send(resWriter)
+					return
+				}
+				obj.Profile = profile
+			}
+			obj.RequestID = mortMiddleware.RequestIDFromContext(req.Context())
 
 			res := rp.Process(req, obj)
 			defer res.Close()
 			res.SetDebug(obj)
+			res.FormatError(processor.ErrorFormat(req, obj), obj.RequestID, obj.Key)
 			if debug {
 				res.Set("X-Mort-Version", Version)
 			}
 
 			// FIXME
 			res.Set("Access-Control-Allow-Headers", "Content-Type, X-Amz-Public-Width, X-Amz-Public-Height")
-			res.Set("Access-Control-Expose-Headers", "Content-Type, X-Amz-Public-Width, X-Amz-Public-Height")
-			res.Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, HEAD")
+			res.Set("Access-Control-Expose-Headers", "Content-Type, X-Amz-Public-Width, X-Amz-Public-Height, X-Request-ID")
+			if allow := res.Headers.Get(processor.HeaderAllow); allow != "" {
+				res.Set("Access-Control-Allow-Methods", allow)
+			} else {
+				res.Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, HEAD")
+			}
 			res.Set("Access-Control-Allow-Origin", "*")
 			defer monitoring.Log().Sync() // flushes buffer, if any
 			if res.HasError() {
-				monitoring.Log().Error("Mort process error", zap.String("obj.Key", obj.Key), zap.Error(res.Error()))
+				monitoring.Log().Error("Mort process error", obj.LogData(zap.Error(res.Error()))...)
 			}
 
-			res.SendContent(req, resWriter)
+			writeStart := time.Now()
+			res.SendContent(req, rp.LimitBandwidth(resWriter, req, obj))
+			stages.Since(monitoring.StageWrite, writeStart)
+			if threshold := time.Duration(imgConfig.Server.SlowLog.Threshold) * time.Millisecond; stages != nil && stages.Elapsed() >= threshold {
+				monitoring.Log().Warn("Mort slow request", obj.LogData(append([]zap.Field{zap.String("method", req.Method), zap.Int("sc", res.StatusCode)}, stages.LogFields()...)...)...)
+				monitoring.Report().Inc("slow_request_count;method:" + req.Method)
+			}
 		})
 	})
 
@@ -263,49 +811,68 @@ func main() {
 		monitoring.Log().Warn("Mort error request shouldn't go here")
 	}))
 
-	serversCount := len(imgConfig.Server.Listen) + 1
-	servers := make([]*http.Server, serversCount)
-	netListeners := make([]net.Listener, serversCount)
+	// certificates of server are shared by TLS listeners without own certificate
+	var certificates *hostCertificates
+	listeners := imgConfig.Server.AllListeners()
+	for _, listenerCfg := range listeners {
+		if listenerCfg.TLS != nil && listenerCfg.TLS.CertFile == "" && certificates == nil {
+			certificates, err = newHostCertificates(imgConfig.Server)
+			if err != nil {
+				panic(err)
+			}
+		}
+	}
+
+	servers := make([]*http.Server, len(listeners))
+	netListeners := make([]net.Listener, len(listeners))
 	var socketPaths []string
 
-	for i, l := range imgConfig.Server.Listen {
+	for i, listenerCfg := range listeners {
+		var public http.Handler = router
+		if listenerCfg.TLS == nil && certificates != nil {
+			// plain listeners answer HTTP-01 challenges of ACME
+			public = certificates.HTTPHandler(router)
+		}
+
 		servers[i] = &http.Server{
 			ReadTimeout:  2 * time.Minute,
 			WriteTimeout: 2 * time.Minute,
-			Handler:      router,
+			Handler:      listenerHandler(listenerCfg, public, internalRouter(imgConfig, listenerCfg, presetsAPI, trashAPI, janitor)),
 		}
 
-		network := "tcp"
-		address := l
-		if strings.HasPrefix(l, "unix:") {
-			network = "unix"
-			address = strings.Replace(l, "unix:", "", 1)
-			socketPaths = append(socketPaths, address)
+		ln, socketPath := listenConfigured(imgConfig.Server, listenerCfg.Address)
+		if socketPath != "" {
+			socketPaths = append(socketPaths, socketPath)
 		}
 
-		ln, err := net.Listen(network, address)
-		if err != nil {
-			panic(err)
+		ln = proxyProtocolListener(imgConfig.Server.Proxy, listenerCfg.Address, ln)
+		if listenerCfg.TLS != nil {
+			listenerCerts, err := listenerCertificates(listenerCfg, certificates)
+			if err != nil {
+				panic(err)
+			}
+			// raw listener is registered for restart, new process wraps it again
+			ln = tls.NewListener(ln, listenerCerts.TLSConfig())
 		}
 		netListeners[i] = ln
 	}
 
-	var internalSocketPath string
-	servers[serversCount-1], netListeners[serversCount-1], internalSocketPath = debugListener(imgConfig)
-	if internalSocketPath != "" {
-		socketPaths = append(socketPaths, internalSocketPath)
-	}
-
 	var wg sync.WaitGroup
 
 	wg.Add(1)
-	go handleSignals(servers, socketPaths, &wg)
+	go handleSignals(servers, socketPaths, time.Duration(imgConfig.Server.DrainTimeout)*time.Second, &wg)
 
 	for i, s := range servers {
 		wg.Add(1)
 		go startServer(s, netListeners[i])
 	}
+	notifyParentReady()
 
 	wg.Wait()
+	janitor.Stop()
+	trashCleaner.Stop()
+	if presetsAPI.Enabled() {
+		presetsAPI.Stop()
+	}
 	fmt.Println("Bye...")
 }
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/aldor007/mort/pkg/config"
+	"github.com/aldor007/mort/pkg/migrate"
+)
+
+// reshard is entry point of "mort reshard" subcommand
+// It moves objects of sharded storage to shards which own them after change of shards
+func reshard(args []string) int {
+	fs := flag.NewFlagSet("mort reshard", flag.ExitOnError)
+	configPath := fs.String("config", "/etc/mort/mort.yml", "Path to configuration")
+	storageName := fs.String("storage", "", "Sharded storage in form bucket[/storage] (default storage basic)")
+	concurrency := fs.Int("concurrency", 4, "Number of objects moved at once")
+	dryRun := fs.Bool("dry-run", false, "Only list objects which would be moved")
+	fs.Parse(args)
+
+	mortConfig := config.GetInstance()
+	err := mortConfig.Load(*configPath)
+	configureMonitoring(mortConfig)
+	if err != nil {
+		fmt.Println("Invalid config", err)
+		return 1
+	}
+
+	endpoint, err := syncEndpoint(mortConfig, *storageName)
+	if err != nil {
+		fmt.Println(err)
+		fs.Usage()
+		return 1
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-signalChan
+		cancel()
+	}()
+
+	stats, err := migrate.Reshard(ctx, endpoint, *concurrency, *dryRun)
+	fmt.Printf("Reshard done listed: %d moved: %d in place: %d failed: %d bytes: %d\n", stats.Listed, stats.Copied, stats.Skipped, stats.Failed, stats.Bytes)
+	if err != nil {
+		fmt.Println("Reshard stopped", err)
+		return 1
+	}
+
+	if stats.Failed != 0 {
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aldor007/mort/pkg/config"
+	"github.com/aldor007/mort/pkg/monitoring"
+	"go.uber.org/zap"
+)
+
+const (
+	// envInheritedListeners contains comma separated list of listeners addresses passed from parent process
+	// listener file descriptors starts from 3 in order of that list
+	envInheritedListeners = "MORT_INHERITED_LISTENERS"
+	// envReadyFd contains descriptor of pipe on which child notifies parent that it is ready to serve traffic
+	envReadyFd = "MORT_READY_FD"
+)
+
+// inheritedListeners listeners received from parent process during restart
+var inheritedListeners = loadInheritedListeners()
+
+// activeListener is listener with address from configuration
+type activeListener struct {
+	key string
+	ln  net.Listener
+}
+
+// activeListeners list of listeners opened by process, they are passed to new process on restart
+var activeListeners []activeListener
+
+func listenerKey(network, address string) string {
+	return network + ":" + address
+}
+
+func loadInheritedListeners() map[string]net.Listener {
+	result := make(map[string]net.Listener)
+	env := os.Getenv(envInheritedListeners)
+	if env == "" {
+		return result
+	}
+
+	for i, key := range strings.Split(env, ",") {
+		f := os.NewFile(uintptr(3+i), key)
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			fmt.Println("Unable to inherit listener", key, err)
+			continue
+		}
+
+		result[key] = ln
+	}
+
+	os.Unsetenv(envInheritedListeners)
+	return result
+}
+
+// listen returns listener inherited from parent process, passed by systemd or creates new one
+func listen(network, address string, socketCfg config.UnixSocketCfg) (net.Listener, error) {
+	key := listenerKey(network, address)
+	ln, ok := inheritedListeners[key]
+	if ok {
+		delete(inheritedListeners, key)
+	} else if network == "systemd" {
+		var err error
+		ln, err = systemdListener(address)
+		if err != nil {
+			return nil, err
+		}
+	} else if network == "unix" {
+		var err error
+		ln, err = listenUnix(address, socketCfg)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+		ln, err = net.Listen(network, address)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	activeListeners = append(activeListeners, activeListener{key: key, ln: ln})
+	return ln, nil
+}
+
+// notifyParentReady informs parent process that it can start draining its connections
+func notifyParentReady() {
+	fdStr := os.Getenv(envReadyFd)
+	if fdStr == "" {
+		return
+	}
+	os.Unsetenv(envReadyFd)
+
+	fd, err := strconv.Atoi(fdStr)
+	if err != nil {
+		return
+	}
+
+	f := os.NewFile(uintptr(fd), "ready")
+	f.Write([]byte{1})
+	f.Close()
+}
+
+// restart starts new mort process with the same arguments and passes to it all listeners
+// it returns when new process is ready to accept connections
+func restart(timeout time.Duration) error {
+	files := make([]*os.File, 0, len(activeListeners)+1)
+	keys := make([]string, 0, len(activeListeners))
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	for _, active := range activeListeners {
+		var f *os.File
+		var err error
+		switch l := active.ln.(type) {
+		case *net.TCPListener:
+			f, err = l.File()
+		case *net.UnixListener:
+			// socket file have to stay on disk for new process
+			l.SetUnlinkOnClose(false)
+			f, err = l.File()
+		default:
+			err = fmt.Errorf("unsupported listener type %T", active.ln)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		files = append(files, f)
+		keys = append(keys, active.key)
+	}
+
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer readyR.Close()
+	files = append(files, readyW)
+
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = files
+	cmd.Env = append(os.Environ(),
+		envInheritedListeners+"="+strings.Join(keys, ","),
+		envReadyFd+"="+strconv.Itoa(3+len(files)-1),
+	)
+
+	if err = cmd.Start(); err != nil {
+		return err
+	}
+	monitoring.Log().Info("Restart new process started", zap.Int("child.pid", cmd.Process.Pid))
+
+	ready := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 1)
+		_, errRead := readyR.Read(buf)
+		ready <- errRead
+	}()
+	// close our copy of write end so read returns when child exits without notification
+	readyW.Close()
+	files = files[:len(files)-1]
+
+	select {
+	case err = <-ready:
+		if err != nil {
+			cmd.Process.Kill()
+			return errors.New("new process exited before it was ready")
+		}
+		return nil
+	case <-time.After(timeout):
+		cmd.Process.Kill()
+		return errors.New("timeout waiting for new process")
+	}
+}
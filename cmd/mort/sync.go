@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/aldor007/mort/pkg/config"
+	"github.com/aldor007/mort/pkg/migrate"
+)
+
+// syncStorages is entry point of "mort sync" subcommand
+// It copies objects between storages from configuration, for example originals from local disk to S3
+func syncStorages(args []string) int {
+	fs := flag.NewFlagSet("mort sync", flag.ExitOnError)
+	configPath := fs.String("config", "/etc/mort/mort.yml", "Path to configuration")
+	from := fs.String("from", "", "Source storage in form bucket[/storage] (default storage basic)")
+	to := fs.String("to", "", "Destination storage in form bucket[/storage] (default storage basic)")
+	prefix := fs.String("prefix", "", "Copy only objects which keys start with prefix")
+	after := fs.String("modified-after", "", "Copy only objects modified after date (RFC3339 or YYYY-MM-DD)")
+	before := fs.String("modified-before", "", "Copy only objects modified before date (RFC3339 or YYYY-MM-DD)")
+	concurrency := fs.Int("concurrency", 4, "Number of objects copied at once")
+	overwrite := fs.Bool("overwrite", false, "Copy objects which already exist in destination")
+	verify := fs.Bool("verify", false, "Read copied objects from destination and compare checksums")
+	dryRun := fs.Bool("dry-run", false, "Only list objects which would be copied")
+	stateFile := fs.String("state", "", "File with progress, sync started again with the same file continue from it")
+	fs.Parse(args)
+
+	mortConfig := config.GetInstance()
+	err := mortConfig.Load(*configPath)
+	configureMonitoring(mortConfig)
+	if err != nil {
+		fmt.Println("Invalid config", err)
+		return 1
+	}
+
+	opts := migrate.Options{
+		Prefix:      *prefix,
+		Concurrency: *concurrency,
+		Overwrite:   *overwrite,
+		Verify:      *verify,
+		DryRun:      *dryRun,
+		StateFile:   *stateFile,
+		Throttler:   newThrottler(mortConfig.Server),
+	}
+
+	if opts.Src, err = syncEndpoint(mortConfig, *from); err == nil {
+		opts.Dst, err = syncEndpoint(mortConfig, *to)
+	}
+	if err == nil {
+		opts.ModifiedAfter, err = syncDate(*after)
+	}
+	if err == nil {
+		opts.ModifiedBefore, err = syncDate(*before)
+	}
+	if err != nil {
+		fmt.Println(err)
+		fs.Usage()
+		return 1
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-signalChan
+		cancel()
+	}()
+
+	stats, err := migrate.Sync(ctx, opts)
+	fmt.Printf("Sync done listed: %d copied: %d skipped: %d failed: %d bytes: %d\n", stats.Listed, stats.Copied, stats.Skipped, stats.Failed, stats.Bytes)
+	if err != nil {
+		fmt.Println("Sync stopped", err)
+		return 1
+	}
+
+	if stats.Failed != 0 {
+		return 1
+	}
+
+	return 0
+}
+
+// syncEndpoint returns storage of bucket from value in form bucket[/storage]
+func syncEndpoint(mortConfig *config.Config, value string) (migrate.Endpoint, error) {
+	bucketName, storageName := value, "basic"
+	if i := strings.Index(value, "/"); i != -1 {
+		bucketName, storageName = value[:i], value[i+1:]
+	}
+
+	bucket, ok := mortConfig.Buckets[bucketName]
+	if !ok {
+		return migrate.Endpoint{}, fmt.Errorf("unknown bucket %q", bucketName)
+	}
+
+	storageCfg, ok := bucket.Storages[storageName]
+	if !ok {
+		return migrate.Endpoint{}, fmt.Errorf("unknown storage %q in bucket %s", storageName, bucketName)
+	}
+
+	return migrate.Endpoint{Bucket: bucketName, Storage: storageCfg}, nil
+}
+
+func syncDate(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+
+	return time.Parse("2006-01-02", value)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+
+	"github.com/aldor007/mort/pkg/config"
+	"github.com/aldor007/mort/pkg/monitoring"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// hostCertificates keeps certificates of HTTPS listeners, certificate is chosen by server name sent by client (SNI)
+// Certificate of host is preferred, then certificate issued by ACME and then default certificate
+type hostCertificates struct {
+	hosts       []config.HostCfg
+	certs       map[string]*tls.Certificate // certificates by host
+	defaultCert *tls.Certificate
+	acme        *autocert.Manager
+	acmeHosts   map[string]bool
+}
+
+func newHostCertificates(serverCfg config.Server) (*hostCertificates, error) {
+	h := &hostCertificates{certs: make(map[string]*tls.Certificate), acmeHosts: make(map[string]bool)}
+	for _, host := range serverCfg.Hosts {
+		if host.CertFile == "" {
+			continue
+		}
+
+		cert, err := tls.LoadX509KeyPair(host.CertFile, host.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load certificate of host %s: %s", host.Host, err)
+		}
+
+		h.hosts = append(h.hosts, host)
+		h.certs[host.Host] = &cert
+	}
+
+	if serverCfg.TLS.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(serverCfg.TLS.CertFile, serverCfg.TLS.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load tls certificate: %s", err)
+		}
+		h.defaultCert = &cert
+	}
+
+	if acmeCfg := serverCfg.TLS.ACME; acmeCfg != nil {
+		hosts := serverCfg.ACMEHosts()
+		for _, host := range hosts {
+			h.acmeHosts[host] = true
+		}
+
+		h.acme = &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			Cache:      autocert.DirCache(acmeCfg.CacheDir),
+			HostPolicy: autocert.HostWhitelist(hosts...),
+			Email:      acmeCfg.Email,
+		}
+		if acmeCfg.Directory != "" {
+			h.acme.Client = &acme.Client{DirectoryURL: acmeCfg.Directory}
+		}
+	}
+
+	return h, nil
+}
+
+// listenerCertificates returns certificates of TLS listener, listener without own certificate uses certificates of server
+func listenerCertificates(listenerCfg config.ListenerCfg, serverCerts *hostCertificates) (*hostCertificates, error) {
+	if listenerCfg.TLS.CertFile == "" {
+		return serverCerts, nil
+	}
+
+	return newHostCertificates(config.Server{TLS: config.TLSCfg{CertFile: listenerCfg.TLS.CertFile, KeyFile: listenerCfg.TLS.KeyFile}})
+}
+
+// isACMEChallenge check if client is ACME server verifying TLS-ALPN-01 challenge
+func isACMEChallenge(hello *tls.ClientHelloInfo) bool {
+	for _, proto := range hello.SupportedProtos {
+		if proto == acme.ALPNProto {
+			return true
+		}
+	}
+
+	return false
+}
+
+// GetCertificate returns certificate for server name of client
+// Clients without SNI and unknown hosts get default certificate or certificate of first host
+func (h *hostCertificates) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if host, _, ok := config.FindHost(h.hosts, hello.ServerName); ok {
+		return h.certs[host.Host], nil
+	}
+
+	if h.acme != nil && (h.acmeHosts[hello.ServerName] || isACMEChallenge(hello)) {
+		cert, err := h.acme.GetCertificate(hello)
+		if err != nil {
+			monitoring.Log().Warn("TLS unable to get acme certificate", zap.String("serverName", hello.ServerName), zap.Error(err))
+			monitoring.Report().Inc("acme_error_count")
+		}
+		return cert, err
+	}
+
+	if h.defaultCert != nil {
+		return h.defaultCert, nil
+	}
+
+	if len(h.hosts) == 0 {
+		return nil, fmt.Errorf("no certificate for %s", hello.ServerName)
+	}
+
+	return h.certs[h.hosts[0].Host], nil
+}
+
+// HTTPHandler returns handler of plain HTTP listeners, it answers HTTP-01 challenges when ACME is enabled
+func (h *hostCertificates) HTTPHandler(next http.Handler) http.Handler {
+	if h.acme == nil {
+		return next
+	}
+
+	return h.acme.HTTPHandler(next)
+}
+
+// TLSConfig returns configuration of HTTPS listeners
+func (h *hostCertificates) TLSConfig() *tls.Config {
+	protos := []string{"h2", "http/1.1"}
+	if h.acme != nil {
+		protos = append(protos, acme.ALPNProto)
+	}
+
+	return &tls.Config{
+		GetCertificate: h.GetCertificate,
+		MinVersion:     tls.VersionTLS12,
+		NextProtos:     protos,
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aldor007/mort/pkg/config"
+	"github.com/aldor007/mort/pkg/lock"
+	"github.com/aldor007/mort/pkg/monitoring"
+	"github.com/aldor007/mort/pkg/object"
+	"github.com/aldor007/mort/pkg/processor"
+	"github.com/aldor007/mort/pkg/storage"
+	"github.com/aldor007/mort/pkg/throttler"
+	"go.uber.org/zap"
+)
+
+// warmStats summary of warm run
+type warmStats struct {
+	total  int64
+	ok     int64
+	failed int64
+}
+
+// warm is entry point of "mort warm" subcommand
+// It drives request processor directly to generate transforms and fill result storage and response cache
+func warm(args []string) int {
+	fs := flag.NewFlagSet("mort warm", flag.ExitOnError)
+	configPath := fs.String("config", "/etc/mort/mort.yml", "Path to configuration")
+	urlsPath := fs.String("urls", "", "File with list of URLs or paths (one per line), - for stdin")
+	bucketName := fs.String("bucket", "", "Bucket which originals should be listed")
+	prefix := fs.String("prefix", "", "Prefix of listed originals")
+	presets := fs.String("presets", "", "Comma separated list of presets used with -bucket")
+	pattern := fs.String("pattern", "/{bucket}/{preset}/{key}", "Pattern of path created for listed originals")
+	concurrency := fs.Int("concurrency", 4, "Number of concurrent requests")
+	rate := fs.Int("rate", 0, "Max number of requests per second (0 - unlimited)")
+	fs.Parse(args)
+
+	// usage errors exit with 2, the same as errors of flag parsing
+	fromFile, fromBucket := *urlsPath != "", *bucketName != "" && *presets != ""
+	if fromFile == fromBucket || *concurrency < 1 || *rate < 0 {
+		fmt.Println("Either -urls or -bucket with -presets is required, -concurrency should be positive and -rate not negative")
+		fs.Usage()
+		return 2
+	}
+
+	if fromFile && *urlsPath != "-" {
+		if _, err := os.Stat(*urlsPath); err != nil {
+			fmt.Println(err)
+			return 2
+		}
+	}
+
+	mortConfig := config.GetInstance()
+	err := mortConfig.Load(*configPath)
+	configureMonitoring(mortConfig)
+	if err != nil {
+		fmt.Println("Invalid config", err)
+		return 1
+	}
+
+	if _, ok := mortConfig.Buckets[*bucketName]; fromBucket && !ok {
+		fmt.Println("Unknown bucket", *bucketName)
+		fs.Usage()
+		return 2
+	}
+
+	paths := make(chan string, *concurrency)
+	var errProduce error
+	go func() {
+		defer close(paths)
+		if fromFile {
+			errProduce = produceFromFile(*urlsPath, paths)
+		} else {
+			errProduce = produceFromBucket(mortConfig, *bucketName, *prefix, strings.Split(*presets, ","), *pattern, paths)
+		}
+
+		if errProduce != nil {
+			monitoring.Log().Error("Warm unable to read list of objects", zap.Error(errProduce))
+		}
+	}()
+
+	var limiter <-chan time.Time
+	if *rate > 0 {
+		ticker := time.NewTicker(time.Second / time.Duration(*rate))
+		defer ticker.Stop()
+		limiter = ticker.C
+	}
+
+	rp := processor.NewRequestProcessor(mortConfig.Server, lock.NewMemoryLock(), newThrottler(mortConfig.Server))
+	stats := warmStats{}
+	var wg sync.WaitGroup
+	for i := 0; i < *concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range paths {
+				if limiter != nil {
+					<-limiter
+				}
+				warmOne(&rp, mortConfig, p, &stats)
+			}
+		}()
+	}
+
+	wg.Wait()
+	processor.WaitPending()
+	fmt.Printf("Warm done total: %d ok: %d failed: %d\n", stats.total, stats.ok, stats.failed)
+	if errProduce != nil || stats.failed != 0 {
+		return 1
+	}
+
+	return 0
+}
+
+func warmOne(rp *processor.RequestProcessor, mortConfig *config.Config, p string, stats *warmStats) {
+	atomic.AddInt64(&stats.total, 1)
+	req, err := http.NewRequest("GET", p, nil)
+	if err != nil {
+		atomic.AddInt64(&stats.failed, 1)
+		monitoring.Log().Warn("Warm invalid url", zap.String("url", p), zap.Error(err))
+		return
+	}
+	req = req.WithContext(throttler.WithPriority(req.Context(), throttler.PriorityBatch))
+
+	obj, err := object.NewFileObject(req.URL, mortConfig)
+	if err != nil {
+		atomic.AddInt64(&stats.failed, 1)
+		monitoring.Log().Warn("Warm unable to create object", zap.String("url", p), zap.Error(err))
+		return
+	}
+
+	res := rp.Process(req, obj)
+	res.Close()
+
+	if res.StatusCode > 299 {
+		atomic.AddInt64(&stats.failed, 1)
+		monitoring.Log().Warn("Warm request failed", obj.LogData(zap.Int("statusCode", res.StatusCode))...)
+		return
+	}
+
+	atomic.AddInt64(&stats.ok, 1)
+}
+
+func produceFromFile(filePath string, paths chan<- string) error {
+	var reader io.Reader = os.Stdin
+	if filePath != "-" {
+		f, err := os.Open(filePath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		reader = f
+	}
+
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if u, err := url.Parse(line); err == nil && u.Host != "" {
+			line = u.RequestURI()
+		}
+		paths <- line
+	}
+
+	return scanner.Err()
+}
+
+func produceFromBucket(mortConfig *config.Config, bucketName, prefix string, presets []string, pattern string, paths chan<- string) error {
+	bucket, ok := mortConfig.Buckets[bucketName]
+	if !ok {
+		return fmt.Errorf("unknown bucket %s", bucketName)
+	}
+
+	listObj := &object.FileObject{Uri: &url.URL{Path: "/" + bucketName}, Bucket: bucketName, Storage: bucket.Storages.Basic()}
+	marker := ""
+	for {
+		keys, nextMarker, err := storage.ListKeys(listObj, prefix, marker, 1000)
+		if err != nil {
+			return err
+		}
+
+		for _, key := range keys {
+			for _, preset := range presets {
+				paths <- strings.NewReplacer("{bucket}", bucketName, "{preset}", preset, "{key}", key).Replace(pattern)
+			}
+		}
+
+		if nextMarker == "" || nextMarker == marker {
+			return nil
+		}
+		marker = nextMarker
+	}
+}
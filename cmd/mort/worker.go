@@ -0,0 +1,28 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/aldor007/mort/pkg/worker"
+)
+
+// runWorker is entry point of "mort worker" subcommand
+// Worker process is started by mort when server.workers is configured, it performs transforms sent over unix socket
+func runWorker(args []string) int {
+	fs := flag.NewFlagSet("mort worker", flag.ExitOnError)
+	socket := fs.String("socket", "", "Path of unix socket on which worker waits for jobs")
+	fs.Parse(args)
+
+	if *socket == "" {
+		fs.Usage()
+		return 1
+	}
+
+	if err := worker.Serve(*socket); err != nil {
+		fmt.Println("Worker stopped", err)
+		return 1
+	}
+
+	return 0
+}
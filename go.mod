@@ -20,10 +20,13 @@ require (
 	github.com/pquerna/ffjson v0.0.0-20190930134022-aa0246cd15f7 // indirect
 	github.com/prometheus/client_golang v1.9.0
 	github.com/prometheus/client_model v0.2.0
+	github.com/segmentio/kafka-go v0.3.5
 	github.com/spaolacci/murmur3 v1.1.0
 	github.com/stretchr/testify v1.7.0
+	github.com/traefik/yaegi v0.9.19
 	github.com/vmihailenco/msgpack v4.0.4+incompatible
 	go.uber.org/zap v1.16.0
+	golang.org/x/crypto v0.0.0-20200622213623-75b288015ac9
 	golang.org/x/net v0.0.0-20210119194325-5f4716e94777
 	gopkg.in/h2non/bimg.v1 v1.1.5
 	gopkg.in/h2non/gock.v1 v1.0.16
@@ -0,0 +1,142 @@
+// Package antivirus scans uploaded files with clamd
+package antivirus
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/aldor007/mort/pkg/config"
+)
+
+// chunkSize size of chunks in which stream is sent to clamd
+const chunkSize = 64 * 1024
+
+// Result of scan
+type Result struct {
+	Infected  bool   // file contains virus
+	Signature string // name of found virus
+}
+
+// Clamd scanner which sends files to clamd using INSTREAM command
+type Clamd struct {
+	network string
+	address string
+	timeout time.Duration
+	maxSize int64
+	bypass  map[string]bool
+	// FailOpen inform if uploads should be accepted when clamd is unavailable
+	FailOpen bool
+}
+
+// New create clamd scanner, it returns nil when antivirus is not configured
+func New(cfg config.AntivirusCfg) *Clamd {
+	if cfg.Address == "" {
+		return nil
+	}
+
+	c := &Clamd{network: "tcp", address: strings.TrimPrefix(cfg.Address, "tcp://"), timeout: time.Duration(cfg.Timeout) * time.Second,
+		maxSize: cfg.MaxSize, bypass: make(map[string]bool), FailOpen: cfg.FailOpen}
+	if strings.HasPrefix(cfg.Address, "unix:") {
+		c.network = "unix"
+		c.address = strings.TrimPrefix(cfg.Address, "unix:")
+	}
+
+	for _, t := range cfg.BypassContentTypes {
+		c.bypass[strings.ToLower(t)] = true
+	}
+
+	return c
+}
+
+// MaxSize returns max size of scanned upload in bytes, 0 when it isn't limited
+func (c *Clamd) MaxSize() int64 {
+	return c.maxSize
+}
+
+// ShouldScan check if upload with given content type and length should be scanned
+// length -1 means that size of upload is unknown, such upload is scanned and it has to fit in max size
+func (c *Clamd) ShouldScan(contentType string, length int64) bool {
+	if c.maxSize > 0 && length > c.maxSize {
+		return false
+	}
+
+	return !c.bypass[strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))]
+}
+
+// Scan stream body to clamd and returns verdict
+// Body is read until the end only when scan succeed
+func (c *Clamd) Scan(ctx context.Context, body io.Reader) (Result, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	dialer := net.Dialer{}
+	conn, err := dialer.DialContext(ctx, c.network, c.address)
+	if err != nil {
+		return Result{}, err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if _, err = conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return Result{}, err
+	}
+
+	chunk := make([]byte, chunkSize)
+	size := make([]byte, 4)
+	for {
+		n, readErr := body.Read(chunk)
+		if n > 0 {
+			binary.BigEndian.PutUint32(size, uint32(n))
+			if _, err = conn.Write(size); err != nil {
+				return Result{}, err
+			}
+			if _, err = conn.Write(chunk[:n]); err != nil {
+				return Result{}, err
+			}
+		}
+
+		if readErr == io.EOF {
+			break
+		} else if readErr != nil {
+			return Result{}, readErr
+		}
+	}
+
+	// zero length chunk ends stream
+	if _, err = conn.Write([]byte{0, 0, 0, 0}); err != nil {
+		return Result{}, err
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && err != io.EOF {
+		return Result{}, err
+	}
+
+	return parseReply(reply)
+}
+
+// parseReply parse clamd response e.g. "stream: OK" or "stream: Eicar-Signature FOUND"
+func parseReply(reply string) (Result, error) {
+	reply = strings.TrimSpace(strings.TrimRight(reply, "\x00"))
+	switch {
+	case strings.HasSuffix(reply, " OK"):
+		return Result{}, nil
+	case strings.HasSuffix(reply, " FOUND"):
+		signature := strings.TrimSuffix(reply, " FOUND")
+		if i := strings.Index(signature, ": "); i >= 0 {
+			signature = signature[i+2:]
+		}
+		return Result{Infected: true, Signature: signature}, nil
+	}
+
+	return Result{}, errors.New("clamd: " + reply)
+}
@@ -0,0 +1,113 @@
+package antivirus
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/aldor007/mort/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeClamd accept single INSTREAM connection and reply with FOUND when stream contains EICAR
+func fakeClamd(t *testing.T) (string, chan []byte) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+
+	received := make(chan []byte, 1)
+	go func() {
+		defer ln.Close()
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		cmd := make([]byte, len("zINSTREAM\x00"))
+		io.ReadFull(conn, cmd)
+		body := bytes.Buffer{}
+		size := make([]byte, 4)
+		for {
+			if _, err := io.ReadFull(conn, size); err != nil {
+				return
+			}
+			n := binary.BigEndian.Uint32(size)
+			if n == 0 {
+				break
+			}
+			io.CopyN(&body, conn, int64(n))
+		}
+
+		received <- body.Bytes()
+		if bytes.Contains(body.Bytes(), []byte("EICAR")) {
+			conn.Write([]byte("stream: Eicar-Test-Signature FOUND\x00"))
+		} else {
+			conn.Write([]byte("stream: OK\x00"))
+		}
+	}()
+
+	return "tcp://" + ln.Addr().String(), received
+}
+
+func TestNew(t *testing.T) {
+	assert.Nil(t, New(config.AntivirusCfg{}))
+
+	c := New(config.AntivirusCfg{Address: "unix:/var/run/clamd.ctl", Timeout: 5})
+	assert.Equal(t, "unix", c.network)
+	assert.Equal(t, "/var/run/clamd.ctl", c.address)
+
+	c = New(config.AntivirusCfg{Address: "tcp://127.0.0.1:3310", Timeout: 5})
+	assert.Equal(t, "tcp", c.network)
+	assert.Equal(t, "127.0.0.1:3310", c.address)
+}
+
+func TestShouldScan(t *testing.T) {
+	c := New(config.AntivirusCfg{Address: "tcp://127.0.0.1:3310", MaxSize: 100, BypassContentTypes: []string{"image/jpeg"}})
+
+	assert.True(t, c.ShouldScan("application/pdf", 10))
+	assert.True(t, c.ShouldScan("application/pdf", -1))
+	assert.False(t, c.ShouldScan("application/pdf", 101))
+	assert.False(t, c.ShouldScan("image/JPEG; charset=binary", 10))
+	assert.Equal(t, int64(100), c.MaxSize())
+}
+
+func TestScanClean(t *testing.T) {
+	address, received := fakeClamd(t)
+	c := New(config.AntivirusCfg{Address: address, Timeout: 5})
+
+	body := strings.Repeat("a", chunkSize*2+10)
+	result, err := c.Scan(context.Background(), strings.NewReader(body))
+
+	assert.Nil(t, err)
+	assert.False(t, result.Infected)
+	assert.Equal(t, body, string(<-received))
+}
+
+func TestScanInfected(t *testing.T) {
+	address, _ := fakeClamd(t)
+	c := New(config.AntivirusCfg{Address: address, Timeout: 5})
+
+	result, err := c.Scan(context.Background(), strings.NewReader("X5O!P%@AP EICAR"))
+
+	assert.Nil(t, err)
+	assert.True(t, result.Infected)
+	assert.Equal(t, "Eicar-Test-Signature", result.Signature)
+}
+
+func TestScanUnavailable(t *testing.T) {
+	c := New(config.AntivirusCfg{Address: "tcp://127.0.0.1:1", Timeout: 1})
+
+	_, err := c.Scan(context.Background(), strings.NewReader("data"))
+
+	assert.NotNil(t, err)
+}
+
+func TestParseReplyError(t *testing.T) {
+	_, err := parseReply("INSTREAM size limit exceeded. ERROR\x00")
+
+	assert.NotNil(t, err)
+}
@@ -14,13 +14,25 @@ type ResponseCache interface {
 }
 
 // Create returns instance of Response cache
+// Cache layer reports its metrics labeled with name of layer
+// When request headers vary responses, cache tracks variants of objects, redis keeps list of variants so it is shared by all instances
 func Create(cacheCfg config.CacheCfg) ResponseCache {
+	var instance ResponseCache
+	var index VariantIndex
 	switch cacheCfg.Type {
 	case "redis":
-		return NewRedis(cacheCfg.Address, cacheCfg.ClientConfig)
+		redis := NewRedis(cacheCfg.Address, cacheCfg.ClientConfig)
+		instance, index = NewInstrumentedCache(LayerRedis, redis), redis
 	case "redis-cluster":
-		return NewRedisCluster(cacheCfg.Address, cacheCfg.ClientConfig)
+		redis := NewRedisCluster(cacheCfg.Address, cacheCfg.ClientConfig)
+		instance, index = NewInstrumentedCache(LayerRedisCluster, redis), redis
 	default:
-		return NewMemoryCache(cacheCfg.CacheSize)
+		instance = NewInstrumentedCache(LayerMemory, NewMemoryCache(cacheCfg.CacheSize))
 	}
+
+	if len(cacheCfg.Vary) != 0 {
+		return NewVaryCache(instance, index)
+	}
+
+	return instance
 }
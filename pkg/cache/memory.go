@@ -2,6 +2,7 @@ package cache
 
 import (
 	"math"
+	"sync/atomic"
 	"time"
 	"unsafe"
 
@@ -22,6 +23,8 @@ type (
 	// responseSizeProvider adapts response.Response to how ccache size computation requirements.
 	responseSizeProvider struct {
 		*response.Response
+		bucket  string
+		removed *int32 // set when response is deleted or replaced by mort, so its removal isn't reported as eviction
 	}
 )
 
@@ -46,17 +49,42 @@ func (r responseSizeProvider) Size() int64 {
 
 // NewMemoryCache returns instance of memory cache
 func NewMemoryCache(maxSize int64) *MemoryCache {
-	return &MemoryCache{ccache.New(ccache.Configure().MaxSize(maxSize).ItemsToPrune(50))}
+	return &MemoryCache{ccache.New(ccache.Configure().MaxSize(maxSize).ItemsToPrune(50).OnDelete(onDelete))}
+}
+
+// onDelete updates size of cache after removal of item and reports items evicted by ccache
+func onDelete(item *ccache.Item) {
+	res, ok := item.Value().(responseSizeProvider)
+	if !ok {
+		return
+	}
+
+	monitoring.Report().Gauge("cache_size;layer:"+LayerMemory, -float64(res.Size()))
+	if atomic.LoadInt32(res.removed) == 0 {
+		monitoring.Report().Inc("cache_count;layer:" + LayerMemory + ",bucket:" + res.bucket + ",result:evict")
+	}
+}
+
+// markRemoved marks cached response so its removal by mort isn't counted as eviction
+func (c *MemoryCache) markRemoved(key string) {
+	if item := c.cache.Get(key); item != nil {
+		if res, ok := item.Value().(responseSizeProvider); ok {
+			atomic.StoreInt32(res.removed, 1)
+		}
+	}
 }
 
 // Set put response to cache
 func (c *MemoryCache) Set(obj *object.FileObject, res *response.Response) error {
-	cachedResp, err := res.Copy()
+	cachedResp, err := res.Share()
 	if err != nil {
 		return err
 	}
-	monitoring.Report().Inc("cache_ratio;status:set")
-	c.cache.Set(obj.GetResponseCacheKey(), responseSizeProvider{cachedResp}, time.Second*time.Duration(res.GetTTL()))
+	key := obj.GetResponseCacheKey()
+	c.markRemoved(key)
+	value := responseSizeProvider{cachedResp, obj.Bucket, new(int32)}
+	monitoring.Report().Gauge("cache_size;layer:"+LayerMemory, float64(value.Size()))
+	c.cache.Set(key, value, time.Second*time.Duration(res.GetTTL()))
 	return nil
 }
 
@@ -65,23 +93,21 @@ func (c *MemoryCache) Get(obj *object.FileObject) (*response.Response, error) {
 	cacheValue := c.cache.Get(obj.GetResponseCacheKey())
 	if cacheValue != nil {
 		monitoring.Log().Info("Handle Get cache", zap.String("cache", "hit"), zap.String("obj.Key", obj.Key))
-		monitoring.Report().Inc("cache_ratio;status:hit")
 		res := cacheValue.Value().(responseSizeProvider)
-		resCp, err := res.Copy()
+		resCp, err := res.Share()
 		if err != nil {
-			monitoring.Report().Inc("cache_ratio;status:miss")
 			return nil, errors.New("not found")
 		}
-		resCp.Set("x-mort-cache", "hit")
 		return resCp, nil
 	}
 
-	monitoring.Report().Inc("cache_ratio;status:miss")
 	return nil, errors.New("not found")
 }
 
 // Delete remove given response from cache
 func (c *MemoryCache) Delete(obj *object.FileObject) error {
-	c.cache.Delete(obj.GetResponseCacheKey())
+	key := obj.GetResponseCacheKey()
+	c.markRemoved(key)
+	c.cache.Delete(key)
 	return nil
 }
@@ -0,0 +1,68 @@
+package cache
+
+import (
+	"github.com/aldor007/mort/pkg/monitoring"
+	"github.com/aldor007/mort/pkg/object"
+	"github.com/aldor007/mort/pkg/response"
+)
+
+// HeaderCache name of header informing how response was served with regard to response cache
+const HeaderCache = "X-Mort-Cache"
+
+// values of HeaderCache
+const (
+	StatusHit       = "HIT"       // response served from cache
+	StatusMiss      = "MISS"      // response created for request
+	StatusStale     = "STALE"     // stale copy of object served because storage failed
+	StatusCollapsed = "COLLAPSED" // response created for other request for the same object
+)
+
+// names of cache layers used in metrics
+const (
+	LayerMemory       = "memory"
+	LayerRedis        = "redis"
+	LayerRedisCluster = "redis-cluster"
+)
+
+// instrumentedCache reports hit, miss, set and delete of wrapped cache layer per bucket
+type instrumentedCache struct {
+	layer string
+	cache ResponseCache
+}
+
+// NewInstrumentedCache wraps cache layer with metrics, cached responses get HeaderCache set to StatusHit
+func NewInstrumentedCache(layer string, cache ResponseCache) ResponseCache {
+	return &instrumentedCache{layer: layer, cache: cache}
+}
+
+func (c *instrumentedCache) report(obj *object.FileObject, result string) {
+	monitoring.Report().Inc("cache_count;layer:" + c.layer + ",bucket:" + obj.Bucket + ",result:" + result)
+}
+
+// Set put response to cache
+func (c *instrumentedCache) Set(obj *object.FileObject, res *response.Response) error {
+	monitoring.Report().Inc("cache_ratio;status:set")
+	c.report(obj, "set")
+	return c.cache.Set(obj, res)
+}
+
+// Get returns response from cache or error
+func (c *instrumentedCache) Get(obj *object.FileObject) (*response.Response, error) {
+	res, err := c.cache.Get(obj)
+	if err != nil || res == nil || res.Headers == nil {
+		monitoring.Report().Inc("cache_ratio;status:miss")
+		c.report(obj, "miss")
+		return res, err
+	}
+
+	monitoring.Report().Inc("cache_ratio;status:hit")
+	c.report(obj, "hit")
+	res.Set(HeaderCache, StatusHit)
+	return res, nil
+}
+
+// Delete remove response from cache
+func (c *instrumentedCache) Delete(obj *object.FileObject) error {
+	c.report(obj, "delete")
+	return c.cache.Delete(obj)
+}
@@ -0,0 +1,71 @@
+package cache
+
+import (
+	"testing"
+
+	"github.com/aldor007/mort/pkg/monitoring"
+	"github.com/aldor007/mort/pkg/object"
+	"github.com/aldor007/mort/pkg/response"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInstrumentedCache_Hit(t *testing.T) {
+	i := NewInstrumentedCache(LayerMemory, NewMemoryCache(1000))
+
+	obj := object.FileObject{}
+	obj.Bucket = "bucket"
+	obj.Key = "cacheKey"
+	res := response.NewString(200, "test")
+	res.Set(HeaderCache, StatusMiss)
+
+	assert.Nil(t, i.Set(&obj, res))
+	resCache, err := i.Get(&obj)
+	assert.Nil(t, err)
+	assert.Equal(t, StatusHit, resCache.Headers.Get(HeaderCache))
+	assert.Equal(t, StatusMiss, res.Headers.Get(HeaderCache))
+}
+
+func TestInstrumentedCache_Miss(t *testing.T) {
+	i := NewInstrumentedCache(LayerMemory, NewMemoryCache(1000))
+
+	obj := object.FileObject{}
+	obj.Key = "cacheKey"
+
+	_, err := i.Get(&obj)
+	assert.NotNil(t, err)
+
+	i.Set(&obj, response.NewString(200, "test"))
+	i.Delete(&obj)
+	_, err = i.Get(&obj)
+	assert.NotNil(t, err)
+}
+
+// recordingReporter remembers reported counters
+type recordingReporter struct {
+	monitoring.NopReporter
+	counts map[string]int
+}
+
+func (r *recordingReporter) Inc(label string) {
+	r.counts[label]++
+}
+
+func TestInstrumentedCache_Metrics(t *testing.T) {
+	reporter := &recordingReporter{counts: make(map[string]int)}
+	monitoring.RegisterReporter(reporter)
+	defer monitoring.RegisterReporter(&monitoring.NopReporter{})
+
+	i := NewInstrumentedCache(LayerMemory, NewMemoryCache(1000))
+	obj := object.FileObject{}
+	obj.Bucket = "bucket"
+	obj.Key = "metricsKey"
+
+	i.Get(&obj)
+	i.Set(&obj, response.NewString(200, "test"))
+	i.Get(&obj)
+
+	assert.Equal(t, 1, reporter.counts["cache_ratio;status:miss"])
+	assert.Equal(t, 1, reporter.counts["cache_ratio;status:set"])
+	assert.Equal(t, 1, reporter.counts["cache_ratio;status:hit"])
+	assert.Equal(t, 1, reporter.counts["cache_count;layer:memory,bucket:bucket,result:hit"])
+}
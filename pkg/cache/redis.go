@@ -5,7 +5,6 @@ import (
 	"strings"
 	"time"
 
-	"github.com/aldor007/mort/pkg/monitoring"
 	"github.com/aldor007/mort/pkg/object"
 	"github.com/aldor007/mort/pkg/response"
 	redisCache "github.com/go-redis/cache/v8"
@@ -27,6 +26,7 @@ func parseAddress(addrs []string) map[string]string {
 // RedisCache store response in redis
 type RedisCache struct {
 	client *redisCache.Cache
+	redis  goRedis.Cmdable
 }
 
 // NewRedis create connection to redis and update it config from clientConfig map
@@ -45,7 +45,7 @@ func NewRedis(redisAddress []string, clientConfig map[string]string) *RedisCache
 		}
 	}
 
-	return &RedisCache{cache}
+	return &RedisCache{cache, ring}
 }
 
 func NewRedisCluster(redisAddress []string, clientConfig map[string]string) *RedisCache {
@@ -63,7 +63,7 @@ func NewRedisCluster(redisAddress []string, clientConfig map[string]string) *Red
 		}
 	}
 
-	return &RedisCache{cache}
+	return &RedisCache{cache, ring}
 }
 func (c *RedisCache) getKey(obj *object.FileObject) string {
 	return "mort-v1:" + obj.GetResponseCacheKey()
@@ -71,7 +71,6 @@ func (c *RedisCache) getKey(obj *object.FileObject) string {
 
 // Set put response into cache
 func (c *RedisCache) Set(obj *object.FileObject, res *response.Response) error {
-	monitoring.Report().Inc("cache_ratio;status:set")
 	v, err := msgpack.Marshal(res)
 	if err != nil {
 		return err
@@ -89,14 +88,8 @@ func (c *RedisCache) Get(obj *object.FileObject) (*response.Response, error) {
 	var buf []byte
 	var res response.Response
 	err := c.client.Get(obj.Ctx, c.getKey(obj), &buf)
-	if err != nil {
-		monitoring.Report().Inc("cache_ratio;status:miss")
-	} else {
-		monitoring.Report().Inc("cache_ratio;status:hit")
+	if err == nil {
 		err = msgpack.Unmarshal(buf, &res)
-		if res.Headers != nil {
-			res.Set("x-mort-cache", "hit")
-		}
 	}
 
 	return &res, err
@@ -106,3 +99,31 @@ func (c *RedisCache) Get(obj *object.FileObject) (*response.Response, error) {
 func (c *RedisCache) Delete(obj *object.FileObject) error {
 	return c.client.Delete(obj.Ctx, c.getKey(obj))
 }
+
+func (c *RedisCache) variantsKey(key string) string {
+	return "mort-v1-vary:" + key
+}
+
+// AddVariant adds variant to set of variants of object, set expires with TTL of the last cached variant
+func (c *RedisCache) AddVariant(ctx context.Context, key, variant string, ttl time.Duration) error {
+	if ttl < time.Second {
+		// the same default TTL is used for responses by redis cache
+		ttl = time.Hour
+	}
+
+	pipe := c.redis.TxPipeline()
+	pipe.SAdd(ctx, c.variantsKey(key), variant)
+	pipe.Expire(ctx, c.variantsKey(key), ttl)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// Variants returns variants of object stored in redis
+func (c *RedisCache) Variants(ctx context.Context, key string) ([]string, error) {
+	return c.redis.SMembers(ctx, c.variantsKey(key)).Result()
+}
+
+// DeleteVariants removes set of variants of object
+func (c *RedisCache) DeleteVariants(ctx context.Context, key string) error {
+	return c.redis.Del(ctx, c.variantsKey(key)).Err()
+}
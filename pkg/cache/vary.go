@@ -0,0 +1,194 @@
+package cache
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aldor007/mort/pkg/monitoring"
+	"github.com/aldor007/mort/pkg/object"
+	"github.com/aldor007/mort/pkg/response"
+	"go.uber.org/zap"
+)
+
+// maxVaryIndexSize limits number of objects which variants are tracked in memory, index is cleared when it is exceeded
+// and not tracked variants expire with their TTL
+const maxVaryIndexSize = 100000
+
+// VaryKey returns normalized values of request headers which should be included in cache key
+// Values are normalized so equivalent requests share cached response (e.g. only image types are taken from Accept)
+func VaryKey(req *http.Request, headers []string) string {
+	if len(headers) == 0 {
+		return ""
+	}
+
+	parts := make([]string, 0, len(headers))
+	for _, name := range headers {
+		value := normalizeVaryValue(name, req.Header.Get(name))
+		if value != "" {
+			parts = append(parts, strings.ToLower(name)+"="+value)
+		}
+	}
+
+	return strings.Join(parts, "&")
+}
+
+// normalizeVaryValue reduces value of header to part which can change response
+func normalizeVaryValue(name, value string) string {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if !strings.EqualFold(name, "Accept") {
+		return value
+	}
+
+	types := make([]string, 0, 2)
+	for _, mediaRange := range strings.Split(value, ",") {
+		params := strings.Split(mediaRange, ";")
+		mediaType := strings.TrimSpace(params[0])
+		if !strings.HasPrefix(mediaType, "image/") || mediaType == "image/*" {
+			continue
+		}
+
+		rejected := false
+		for _, param := range params[1:] {
+			if q := strings.TrimSpace(param); q == "q=0" || q == "q=0.0" || q == "q=0.00" || q == "q=0.000" {
+				rejected = true
+			}
+		}
+
+		if !rejected {
+			types = append(types, mediaType)
+		}
+	}
+
+	sort.Strings(types)
+	return strings.Join(types, ",")
+}
+
+// AddVary adds headers to Vary of response, headers already present are skipped
+func AddVary(res *response.Response, headers []string) {
+	present := make(map[string]bool)
+	for _, value := range res.Headers.Values("Vary") {
+		for _, h := range strings.Split(value, ",") {
+			present[strings.ToLower(strings.TrimSpace(h))] = true
+		}
+	}
+
+	for _, h := range headers {
+		if !present[strings.ToLower(h)] {
+			present[strings.ToLower(h)] = true
+			res.Headers.Add("Vary", h)
+		}
+	}
+}
+
+// VariantIndex stores list of variants of cached objects
+// Index kept in shared cache backend lets every instance remove variants cached by other instances
+type VariantIndex interface {
+	AddVariant(ctx context.Context, key, variant string, ttl time.Duration) error
+	Variants(ctx context.Context, key string) ([]string, error)
+	DeleteVariants(ctx context.Context, key string) error
+}
+
+// localVariantIndex keeps variants in memory of process, it is used with cache which isn't shared
+type localVariantIndex struct {
+	lock     sync.Mutex
+	variants map[string]map[string]struct{} // cache key without variant -> variants
+}
+
+func newLocalVariantIndex() *localVariantIndex {
+	return &localVariantIndex{variants: make(map[string]map[string]struct{})}
+}
+
+// AddVariant remember variant of object
+func (l *localVariantIndex) AddVariant(_ context.Context, key, variant string, _ time.Duration) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if len(l.variants) >= maxVaryIndexSize {
+		l.variants = make(map[string]map[string]struct{})
+	}
+	if l.variants[key] == nil {
+		l.variants[key] = make(map[string]struct{})
+	}
+	l.variants[key][variant] = struct{}{}
+	return nil
+}
+
+// Variants returns known variants of object
+func (l *localVariantIndex) Variants(_ context.Context, key string) ([]string, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	variants := make([]string, 0, len(l.variants[key]))
+	for variant := range l.variants[key] {
+		variants = append(variants, variant)
+	}
+
+	return variants, nil
+}
+
+// DeleteVariants forget variants of object
+func (l *localVariantIndex) DeleteVariants(_ context.Context, key string) error {
+	l.lock.Lock()
+	delete(l.variants, key)
+	l.lock.Unlock()
+	return nil
+}
+
+// VaryCache tracks variants of cached objects so all of them are removed on Delete
+type VaryCache struct {
+	ResponseCache
+	index VariantIndex
+}
+
+// NewVaryCache wraps cache with tracking of variants in given index, nil index means index in memory of process
+func NewVaryCache(c ResponseCache, index VariantIndex) *VaryCache {
+	if index == nil {
+		index = newLocalVariantIndex()
+	}
+
+	return &VaryCache{ResponseCache: c, index: index}
+}
+
+func baseKey(obj *object.FileObject) string {
+	objCpy := *obj
+	objCpy.Vary = ""
+	return objCpy.GetResponseCacheKey()
+}
+
+// Set put response to cache and remember its variant
+func (c *VaryCache) Set(obj *object.FileObject, res *response.Response) error {
+	if obj.Vary != "" {
+		ttl := time.Second * time.Duration(res.GetTTL())
+		if err := c.index.AddVariant(obj.Ctx, baseKey(obj), obj.Vary, ttl); err != nil {
+			// variant which isn't tracked expires with its TTL
+			monitoring.Log().Warn("VaryCache/Set unable to store variant", obj.LogData(zap.String("variant", obj.Vary), zap.Error(err))...)
+		}
+	}
+
+	return c.ResponseCache.Set(obj, res)
+}
+
+// Delete remove all known variants of object from cache
+func (c *VaryCache) Delete(obj *object.FileObject) error {
+	key := baseKey(obj)
+	variants, err := c.index.Variants(obj.Ctx, key)
+	if err == nil {
+		err = c.index.DeleteVariants(obj.Ctx, key)
+	}
+
+	objCpy := *obj
+	objCpy.Vary = ""
+	if errDel := c.ResponseCache.Delete(&objCpy); errDel != nil {
+		err = errDel
+	}
+	for _, variant := range variants {
+		objCpy.Vary = variant
+		if errDel := c.ResponseCache.Delete(&objCpy); errDel != nil {
+			err = errDel
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,78 @@
+package cache
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/aldor007/mort/pkg/object"
+	"github.com/aldor007/mort/pkg/response"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVaryKey(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://mort/bucket/image.jpg", nil)
+	req.Header.Set("Accept", "text/html,image/webp;q=0.9,image/avif,image/*,*/*;q=0.8")
+	req.Header.Set("Save-Data", " On ")
+
+	assert.Equal(t, "accept=image/avif,image/webp&save-data=on", VaryKey(req, []string{"Accept", "DPR", "Save-Data"}))
+	assert.Equal(t, "", VaryKey(req, nil))
+
+	other, _ := http.NewRequest("GET", "http://mort/bucket/image.jpg", nil)
+	other.Header.Set("Accept", "image/avif,image/webp,image/png;q=0")
+	other.Header.Set("Save-Data", "on")
+	assert.Equal(t, VaryKey(req, []string{"Accept", "Save-Data"}), VaryKey(other, []string{"Accept", "Save-Data"}))
+}
+
+func TestAddVary(t *testing.T) {
+	res := response.NewNoContent(200)
+	res.Headers.Add("Vary", "Accept-Encoding, accept")
+
+	AddVary(res, []string{"Accept", "DPR"})
+	AddVary(res, []string{"DPR"})
+
+	assert.Equal(t, []string{"Accept-Encoding, accept", "DPR"}, res.Headers.Values("Vary"))
+}
+
+func TestVaryCache(t *testing.T) {
+	c := NewVaryCache(NewMemoryCache(10<<20), nil)
+
+	webp := object.FileObject{Key: "/image.jpg", Vary: "accept=image/webp"}
+	plain := object.FileObject{Key: "/image.jpg"}
+	assert.Nil(t, c.Set(&webp, response.NewString(200, "webp")))
+	assert.Nil(t, c.Set(&plain, response.NewString(200, "jpeg")))
+
+	res, err := c.Get(&webp)
+	assert.Nil(t, err)
+	body, _ := res.Body()
+	assert.Equal(t, "webp", string(body))
+
+	res, err = c.Get(&plain)
+	assert.Nil(t, err)
+	body, _ = res.Body()
+	assert.Equal(t, "jpeg", string(body))
+
+	avif := object.FileObject{Key: "/image.jpg", Vary: "accept=image/avif"}
+	assert.Nil(t, c.Delete(&avif))
+
+	_, err = c.Get(&webp)
+	assert.NotNil(t, err)
+	_, err = c.Get(&plain)
+	assert.NotNil(t, err)
+}
+
+func TestLocalVariantIndex(t *testing.T) {
+	ctx := context.Background()
+	index := newLocalVariantIndex()
+	assert.Nil(t, index.AddVariant(ctx, "key", "accept=image/webp", 0))
+	assert.Nil(t, index.AddVariant(ctx, "key", "accept=image/webp", 0))
+	assert.Nil(t, index.AddVariant(ctx, "key", "accept=image/avif", 0))
+
+	variants, err := index.Variants(ctx, "key")
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []string{"accept=image/webp", "accept=image/avif"}, variants)
+
+	assert.Nil(t, index.DeleteVariants(ctx, "key"))
+	variants, _ = index.Variants(ctx, "key")
+	assert.Len(t, variants, 0)
+}
@@ -0,0 +1,101 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// parse convert addresses of allow and deny lists to networks, single address is network with one host
+func (a *AccessCfg) parse() error {
+	var err error
+	if a.allow, err = parseNets(a.Allow); err != nil {
+		return err
+	}
+
+	a.deny, err = parseNets(a.Deny)
+	return err
+}
+
+func parseNets(values []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(values))
+	for _, value := range values {
+		if !strings.Contains(value, "/") {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %s", value)
+			}
+
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, ipNet, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %s", value)
+		}
+		nets = append(nets, ipNet)
+	}
+
+	return nets, nil
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AllowsIP check if client with given address can access bucket
+// Deny list is checked first, when allow list is empty all other clients are allowed
+func (a *AccessCfg) AllowsIP(ip net.IP) bool {
+	if len(a.allow) == 0 && len(a.deny) == 0 {
+		return true
+	}
+
+	if ip == nil || containsIP(a.deny, ip) {
+		return false
+	}
+
+	return len(a.allow) == 0 || containsIP(a.allow, ip)
+}
+
+// HotlinkFor returns hotlink protection of transforms with given preset, nil when transforms aren't protected
+func (a *AccessCfg) HotlinkFor(preset string) *HotlinkCfg {
+	hotlink := a.Hotlink
+	if h, ok := a.Presets[preset]; ok && preset != "" {
+		hotlink = &h
+	}
+
+	if hotlink == nil || len(hotlink.Referers) == 0 {
+		return nil
+	}
+
+	return hotlink
+}
+
+// AllowsHost check if page with given host can embed images, host is empty when request has no Referer and Origin
+func (h *HotlinkCfg) AllowsHost(host string) bool {
+	if host == "" {
+		return h.AllowEmpty
+	}
+
+	host = strings.ToLower(host)
+	for _, allowed := range h.Referers {
+		allowed = strings.ToLower(allowed)
+		if allowed == host || (strings.HasPrefix(allowed, "*.") && strings.HasSuffix(host, allowed[1:])) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -1,10 +1,13 @@
 package config
 
 import (
+	"encoding/base64"
 	"fmt"
 	"io/ioutil"
+	"net/url"
 	"os"
 	"regexp"
+	"strconv"
 	"strings"
 	"sync"
 
@@ -21,17 +24,26 @@ import (
 //
 // Config should be used like singleton
 type Config struct {
+	Version         int               `yaml:"version"` // version of schema, see CurrentVersion
 	Buckets         map[string]Bucket `yaml:"buckets"`
 	Headers         []HeaderYaml      `yaml:"headers"`
 	Server          Server            `yaml:"server"`
 	accessKeyBucket map[string][]string
+	accessKeyLock   sync.RWMutex
+	warnings        []string
 }
 
 var instance *Config
 var once sync.Once
 
 // storageKinds is list of available storage kinds
-var storageKinds = []string{"local", "local-meta", "s3", "s3-fixed", "http", "b2", "noop"}
+var storageKinds = []string{"local", "local-meta", "s3", "s3-fixed", "http", "b2", "ftp", "memory", "ipfs", "sharded", "noop"}
+
+// PrecompressedExtensions maps encodings of pre-compressed sidecar objects to suffixes of their keys
+var PrecompressedExtensions = map[string]string{"br": ".br", "zstd": ".zst", "gzip": ".gz"}
+
+// SupportedMethods is list of HTTP methods which can be allowed in bucket, OPTIONS is always allowed
+var SupportedMethods = []string{"GET", "HEAD", "PUT", "POST", "DELETE"}
 
 // transformKind is list of available kinds of transforms
 var transformKinds = []string{"query", "presets", "presets-query"}
@@ -78,7 +90,12 @@ func (c *Config) load(data []byte) error {
 		panic(errYaml)
 	}
 
-	c.accessKeyBucket = make(map[string][]string)
+	if c.Version < 0 || c.Version > CurrentVersion {
+		return configInvalidError(fmt.Sprintf("Config has unsupported version %d, supported version %d", c.Version, CurrentVersion))
+	}
+	c.warnings = c.schemaWarnings(data)
+
+	keyRefs := false
 	for name, bucket := range c.Buckets {
 		if bucket.Transform != nil {
 			if bucket.Transform.Path != "" {
@@ -88,10 +105,39 @@ func (c *Config) load(data []byte) error {
 			if bucket.Transform.ParentStorage == "" {
 				bucket.Transform.ParentStorage = "basic"
 			}
+
+			if bucket.Transform.ResultStorage == "" {
+				bucket.Transform.ResultStorage = "transform"
+			}
+
+			if bucket.Transform.Lifecycle != nil && bucket.Transform.Lifecycle.Interval == 0 {
+				bucket.Transform.Lifecycle.Interval = 3600
+			}
 		}
 
 		for sName, storage := range c.Buckets[name].Storages {
 			storage.Hash = name + sName + storage.Kind
+			for i := range storage.Failover {
+				origin := &storage.Failover[i]
+				origin.Hash = fmt.Sprintf("%s-failover%d", storage.Hash, i+1)
+				if origin.CircuitBreaker == nil {
+					// health of origins is tracked by circuit breaker
+					origin.CircuitBreaker = &CircuitBreakerCfg{}
+				}
+				storageDefaults(origin)
+			}
+
+			for i := range storage.Shards {
+				shard := &storage.Shards[i]
+				shard.Hash = fmt.Sprintf("%s-shard%d", storage.Hash, i+1)
+				storageDefaults(shard)
+			}
+
+			if len(storage.Failover) != 0 && storage.CircuitBreaker == nil {
+				storage.CircuitBreaker = &CircuitBreakerCfg{}
+			}
+			storageDefaults(&storage)
+
 			if sName == "transforms" {
 				if storage.PathPrefix == "" {
 					storage.PathPrefix = "transforms"
@@ -101,19 +147,126 @@ func (c *Config) load(data []byte) error {
 			bucket.Storages[sName] = storage
 		}
 
-		bucket.Name = name
-		c.Buckets[name] = bucket
+		for sName, storage := range bucket.Storages {
+			if mirror, ok := bucket.Storages[storage.Mirror]; ok && storage.Mirror != "" {
+				storage.MirrorStorage = &mirror
+				bucket.Storages[sName] = storage
+			}
+		}
+
+		// keys are kept as references so rotated secrets are used, see Bucket.ResolvedKeys
 		for _, key := range bucket.Keys {
-			c.accessKeyBucket[key.AccessKey] = append(c.accessKeyBucket[key.AccessKey], name)
+			for _, value := range []string{key.AccessKey, key.SecretAccessKey} {
+				if _, errSecret := Secret(value); errSecret != nil {
+					return errSecret
+				}
+				_, _, isRef := parseSecretRef(value)
+				keyRefs = keyRefs || isRef
+			}
 		}
+
+		bucket.Name = name
+		c.Buckets[name] = bucket
+	}
+
+	c.indexAccessKeys()
+	if keyRefs {
+		OnSecretsChange(c.indexAccessKeys)
 	}
 
 	return c.validate()
 }
 
+// indexAccessKeys builds index of buckets by resolved access keys
+func (c *Config) indexAccessKeys() {
+	index := make(map[string][]string)
+	for name, bucket := range c.Buckets {
+		for _, key := range bucket.ResolvedKeys() {
+			index[key.AccessKey] = append(index[key.AccessKey], name)
+		}
+	}
+
+	c.accessKeyLock.Lock()
+	c.accessKeyBucket = index
+	c.accessKeyLock.Unlock()
+}
+
+// storageDefaults fills not set options of storage with default values
+func storageDefaults(s *Storage) {
+	if p := s.ParallelGet; p != nil {
+		if p.ThresholdMB == 0 {
+			p.ThresholdMB = 32
+		}
+
+		if p.PartSizeMB == 0 {
+			p.PartSizeMB = 8
+		}
+
+		if p.Concurrency == 0 {
+			p.Concurrency = 4
+		}
+	}
+
+	if s.DiskCache != nil && s.DiskCache.MaxSizeMB == 0 {
+		s.DiskCache.MaxSizeMB = 1024
+	}
+
+	if t := s.Transport; t != nil {
+		if t.MaxIdleConns == 0 {
+			t.MaxIdleConns = 200
+		}
+
+		if t.MaxIdleConnsPerHost == 0 {
+			t.MaxIdleConnsPerHost = 50
+		}
+
+		if t.IdleConnTimeout == 0 {
+			t.IdleConnTimeout = 60
+		}
+
+		if t.DialTimeout == 0 {
+			t.DialTimeout = 4
+		}
+
+		if t.KeepAlive == 0 {
+			t.KeepAlive = 60
+		}
+
+		if t.TLSHandshakeTimeout == 0 {
+			t.TLSHandshakeTimeout = 10
+		}
+	}
+
+	if r := s.Retry; r != nil {
+		if r.Attempts == 0 {
+			r.Attempts = 3
+		}
+
+		if r.Backoff == 0 {
+			r.Backoff = 100
+		}
+
+		if r.MaxBackoff == 0 {
+			r.MaxBackoff = 2000
+		}
+	}
+
+	if cb := s.CircuitBreaker; cb != nil {
+		if cb.Threshold == 0 {
+			cb.Threshold = 5
+		}
+
+		if cb.Cooldown == 0 {
+			cb.Cooldown = 30
+		}
+	}
+}
+
 // BucketsByAccessKey return list of buckets that have given accessKey
 func (c *Config) BucketsByAccessKey(accessKey string) []Bucket {
+	c.accessKeyLock.RLock()
 	list := c.accessKeyBucket[accessKey]
+	c.accessKeyLock.RUnlock()
 	buckets := make([]Bucket, len(list))
 	for i, name := range list {
 		buckets[i] = c.Buckets[name]
@@ -127,7 +280,6 @@ func configInvalidError(msg string) error {
 }
 
 func (c *Config) validateStorage(bucketName string, storages StorageTypes) error {
-	var validStorageKind bool
 	var err error
 	basic := storages.Basic()
 	if basic.Kind == "" {
@@ -135,39 +287,157 @@ func (c *Config) validateStorage(bucketName string, storages StorageTypes) error
 	}
 
 	for storageName, storage := range storages {
-		for _, k := range storageKinds {
-			if k == storage.Kind {
-				validStorageKind = true
-				break
+		if errStorage := c.validateStorageEntry(bucketName, storageName, storage); errStorage != nil {
+			err = errStorage
+		}
+
+		if storage.DiskCache != nil && storage.DiskCache.Path == "" {
+			err = configInvalidError(fmt.Sprintf("%s has invalid config for storage %s - no diskCache path", bucketName, storageName))
+		}
+
+		if storage.Mirror != "" {
+			mirror, ok := storages[storage.Mirror]
+			if !ok || storage.Mirror == storageName {
+				err = configInvalidError(fmt.Sprintf("%s has invalid config for storage %s - unknown mirror storage %s", bucketName, storageName, storage.Mirror))
+			} else if mirror.Mirror != "" {
+				err = configInvalidError(fmt.Sprintf("%s has invalid config for storage %s - mirror storage %s can't have own mirror", bucketName, storageName, storage.Mirror))
 			}
 		}
-		errorMsgPrefix := fmt.Sprintf("%s has invalid config for storage %s kind %s", bucketName, storageName, storage.Kind)
-		if !validStorageKind {
-			err = configInvalidError(fmt.Sprintf("%s has invalid storage %s kind %s valid %s", bucketName, storageName,
-				storage.Kind, storageKinds))
+
+		if storage.Kind == "sharded" && len(storage.Shards) == 0 {
+			err = configInvalidError(fmt.Sprintf("%s has invalid config for storage %s - sharded storage requires shards", bucketName, storageName))
 		}
 
-		if storage.Kind == "local" || storage.Kind == "local-meta" {
-			if storage.RootPath == "" {
-				err = configInvalidError(fmt.Sprintf("%s - no rootPath", errorMsgPrefix))
+		for i, shard := range storage.Shards {
+			shardName := fmt.Sprintf("%s shard %d", storageName, i+1)
+			if storage.Kind != "sharded" {
+				err = configInvalidError(fmt.Sprintf("%s has invalid config for storage %s - shards are allowed only in sharded storage", bucketName, storageName))
+			} else if shard.Kind == "sharded" {
+				err = configInvalidError(fmt.Sprintf("%s has invalid config for storage %s - nested sharding is not allowed", bucketName, shardName))
+			}
+
+			if errStorage := c.validateStorageEntry(bucketName, shardName, shard); errStorage != nil {
+				err = errStorage
 			}
 		}
 
-		if storage.Kind == "http" {
-			if storage.Url == "" {
-				err = configInvalidError(fmt.Sprintf("%s - no url", errorMsgPrefix))
+		for i, origin := range storage.Failover {
+			originName := fmt.Sprintf("%s failover %d", storageName, i+1)
+			if len(origin.Failover) != 0 {
+				err = configInvalidError(fmt.Sprintf("%s has invalid config for storage %s - nested failover is not allowed", bucketName, originName))
+			}
+
+			if errStorage := c.validateStorageEntry(bucketName, originName, origin); errStorage != nil {
+				err = errStorage
 			}
 		}
+	}
+
+	return err
+}
+
+func (c *Config) validateStorageEntry(bucketName string, storageName string, storage Storage) error {
+	var validStorageKind bool
+	var err error
+	for _, k := range storageKinds {
+		if k == storage.Kind {
+			validStorageKind = true
+			break
+		}
+	}
+	errorMsgPrefix := fmt.Sprintf("%s has invalid config for storage %s kind %s", bucketName, storageName, storage.Kind)
+	if !validStorageKind {
+		err = configInvalidError(fmt.Sprintf("%s has invalid storage %s kind %s valid %s", bucketName, storageName,
+			storage.Kind, storageKinds))
+	}
 
-		if storage.Kind == "s3" || storage.Kind == "s3-fixed" {
-			if storage.AccessKey == "" {
-				err = configInvalidError(fmt.Sprintf("%s - no accessKey", errorMsgPrefix))
+	if storage.Kind == "local" || storage.Kind == "local-meta" {
+		if storage.RootPath == "" {
+			err = configInvalidError(fmt.Sprintf("%s - no rootPath", errorMsgPrefix))
+		}
+	}
+
+	if storage.Kind == "http" {
+		if storage.Url == "" {
+			err = configInvalidError(fmt.Sprintf("%s - no url", errorMsgPrefix))
+		}
+	}
+
+	if storage.Kind == "ftp" {
+		if storage.Address == "" {
+			err = configInvalidError(fmt.Sprintf("%s - no address", errorMsgPrefix))
+		}
+
+		if storage.TLS != "" && storage.TLS != "explicit" && storage.TLS != "implicit" {
+			err = configInvalidError(fmt.Sprintf("%s - invalid tls mode %s", errorMsgPrefix, storage.TLS))
+		}
+	}
+
+	if storage.Kind == "b2" {
+		if storage.Account == "" || storage.Key == "" {
+			err = configInvalidError(fmt.Sprintf("%s - no account or key", errorMsgPrefix))
+		}
+	}
+
+	if storage.Native && storage.Kind != "b2" {
+		err = configInvalidError(fmt.Sprintf("%s - native is supported only for b2 storage", errorMsgPrefix))
+	}
+
+	if storage.Kind == "ipfs" {
+		if storage.Url == "" && storage.Gateway == "" {
+			err = configInvalidError(fmt.Sprintf("%s - no url or gateway", errorMsgPrefix))
+		}
+
+		if storage.Pin && storage.Url == "" {
+			err = configInvalidError(fmt.Sprintf("%s - pin requires url", errorMsgPrefix))
+		}
+	}
+
+	if storage.Kind == "s3" || storage.Kind == "s3-fixed" {
+		if storage.AccessKey == "" {
+			err = configInvalidError(fmt.Sprintf("%s - no accessKey", errorMsgPrefix))
+		}
+
+		if storage.SecretAccessKey == "" {
+			err = configInvalidError(fmt.Sprintf("%s - no secretAccessKey", errorMsgPrefix))
+		}
+
+	}
+
+	resolved, errSecret := storage.WithSecrets()
+	if errSecret != nil {
+		err = configInvalidError(fmt.Sprintf("%s - %s", errorMsgPrefix, errSecret))
+	}
+
+	if resolved.Encryption != nil && errSecret == nil {
+		keys := resolved.Encryption.PreviousKeys
+		switch {
+		case resolved.Encryption.KMS != nil && resolved.Encryption.Key != "":
+			err = configInvalidError(fmt.Sprintf("%s - encryption can use key or kms, not both", errorMsgPrefix))
+		case resolved.Encryption.KMS != nil:
+			if resolved.Encryption.KMS.KeyID == "" {
+				err = configInvalidError(fmt.Sprintf("%s - encryption kms requires keyId", errorMsgPrefix))
 			}
+		default:
+			keys = append([]string{resolved.Encryption.Key}, keys...)
+		}
 
-			if storage.SecretAccessKey == "" {
-				err = configInvalidError(fmt.Sprintf("%s - no secretAccessKey", errorMsgPrefix))
+		for _, key := range keys {
+			if buf, errKey := base64.StdEncoding.DecodeString(key); errKey != nil || len(buf) != 32 {
+				err = configInvalidError(fmt.Sprintf("%s - encryption key has to be base64 encoded 32 bytes", errorMsgPrefix))
 			}
+		}
+	}
 
+	if storage.Transport != nil {
+		if storage.Kind != "http" && storage.Kind != "s3" && storage.Kind != "s3-fixed" {
+			err = configInvalidError(fmt.Sprintf("%s - transport is supported only for http, s3 and s3-fixed storage", errorMsgPrefix))
+		}
+
+		if storage.Transport.Proxy != "" {
+			if _, errURL := url.Parse(storage.Transport.Proxy); errURL != nil {
+				err = configInvalidError(fmt.Sprintf("%s - invalid transport proxy %s", errorMsgPrefix, errURL))
+			}
 		}
 	}
 
@@ -195,6 +465,10 @@ func (c *Config) validateTransform(bucketName string, bucket *Bucket) error {
 		err = configInvalidError(fmt.Sprintf("%s - no parentStorage of name %s", errorMsgPrefix, transform.ParentStorage))
 	}
 
+	if transform.ResultStorage != "transform" && bucket.Storages.Get(transform.ResultStorage).Kind == "" {
+		err = configInvalidError(fmt.Sprintf("%s - no resultStorage of name %s", errorMsgPrefix, transform.ResultStorage))
+	}
+
 	if transform.ParentBucket != "" {
 		if _, ok := c.Buckets[transform.ParentBucket]; !ok {
 			err = configInvalidError(fmt.Sprintf("%s - parentBucket %s doesn't exist", errorMsgPrefix, transform.ParentBucket))
@@ -211,29 +485,269 @@ func (c *Config) validateTransform(bucketName string, bucket *Bucket) error {
 		}
 	}
 
+	if transform.Pool != nil {
+		if transform.Pool.Size <= 0 {
+			err = configInvalidError(fmt.Sprintf("%s - pool size should be greater than 0", errorMsgPrefix))
+		}
+
+		if transform.Pool.Backlog == 0 {
+			transform.Pool.Backlog = 100
+		}
+
+		if transform.Pool.Timeout == 0 {
+			transform.Pool.Timeout = 60
+		}
+	}
+
+	if errPresets := resolvePresets(transform.Presets); errPresets != nil {
+		err = configInvalidError(fmt.Sprintf("%s - %s", errorMsgPrefix, errPresets))
+	}
+	transform.filePresets = transform.Presets
+
 	if transform.ResultKey == "" && (transform.Kind == "query" || transform.Kind == "presets-query") {
 		bucket.Transform.ResultKey = "hashParent"
 	}
 
+	if errKey := transform.parseResultKey(); errKey != nil {
+		err = configInvalidError(fmt.Sprintf("%s - %s", errorMsgPrefix, errKey))
+	} else if transform.ResultKeyTemplate() != nil && !strings.Contains(transform.ResultKey, ".Hash") {
+		// key without hash could be key of original
+		result := bucket.Storages.Result(transform.ResultStorage)
+		if result.Kind == "" || result.SameLocation(bucket.Storages.Get(transform.ParentStorage)) {
+			err = configInvalidError(fmt.Sprintf("%s - resultKey template without .Hash requires resultStorage other than parentStorage", errorMsgPrefix))
+		}
+	}
+
 	return err
 
 }
 
+func (c *Config) validateIndex(bucketName string, bucket *Bucket, index *IndexCfg) error {
+	errorMsgPrefix := fmt.Sprintf("Bucket %s has invalid index configuration", bucketName)
+
+	for _, document := range index.Documents {
+		if document == "" || strings.Contains(document, "/") {
+			return configInvalidError(fmt.Sprintf("%s - invalid document %q", errorMsgPrefix, document))
+		}
+	}
+
+	switch index.Autoindex {
+	case "":
+	case "html", "json":
+		// listing of directories is supported only by file system storages
+		if kind := bucket.Storages.Basic().Kind; kind != "local" && kind != "local-meta" {
+			return configInvalidError(fmt.Sprintf("%s - autoindex requires basic storage of kind local or local-meta, not %s", errorMsgPrefix, kind))
+		}
+	default:
+		return configInvalidError(fmt.Sprintf("%s - unknown autoindex %s, allowed html, json", errorMsgPrefix, index.Autoindex))
+	}
+
+	if index.MaxKeys < 0 {
+		return configInvalidError(fmt.Sprintf("%s - maxKeys can't be negative", errorMsgPrefix))
+	} else if index.MaxKeys == 0 {
+		index.MaxKeys = 1000
+	}
+
+	if index.RedirectCode == 0 {
+		index.RedirectCode = 301
+	} else if index.RedirectCode < 300 || index.RedirectCode > 399 {
+		return configInvalidError(fmt.Sprintf("%s - redirectCode %d isn't redirect", errorMsgPrefix, index.RedirectCode))
+	}
+
+	return nil
+}
+
+func (c *Config) validateRewrite(rule *RewriteRule) error {
+	if _, err := regexp.Compile(rule.Match); err != nil || rule.Match == "" {
+		return fmt.Errorf("invalid match %q", rule.Match)
+	}
+
+	if (rule.Rewrite == "") == (rule.Redirect == "") {
+		return errors.New("rule should have either rewrite or redirect")
+	}
+
+	if rule.Bucket != "" {
+		if rule.Redirect != "" {
+			return errors.New("bucket can be used only with rewrite")
+		}
+
+		if _, ok := c.Buckets[rule.Bucket]; !ok {
+			return fmt.Errorf("unknown bucket %s", rule.Bucket)
+		}
+	}
+
+	if rule.Status == 0 {
+		rule.Status = 301
+	} else if rule.Status < 300 || rule.Status > 399 {
+		return fmt.Errorf("status %d isn't redirect", rule.Status)
+	}
+
+	return nil
+}
+
+func (c *Config) validateHosts() error {
+	hasCert := false
+	for i := range c.Server.Hosts {
+		host := &c.Server.Hosts[i]
+		host.Host = strings.ToLower(host.Host)
+		name := strings.TrimPrefix(host.Host, "*.")
+		if name == "" || strings.Contains(name, "*") {
+			return configInvalidError(fmt.Sprintf("Server has invalid host %q - wildcard is allowed only as first label", host.Host))
+		}
+
+		if host.Bucket == "" && !host.IsWildcard() {
+			return configInvalidError(fmt.Sprintf("Server has invalid host %s - bucket is required", host.Host))
+		}
+
+		if _, ok := c.Buckets[host.Bucket]; host.Bucket != "" && !ok {
+			return configInvalidError(fmt.Sprintf("Server has invalid host %s - unknown bucket %s", host.Host, host.Bucket))
+		}
+
+		if (host.CertFile == "") != (host.KeyFile == "") {
+			return configInvalidError(fmt.Sprintf("Server has invalid host %s - certFile and keyFile have to be set together", host.Host))
+		}
+		hasCert = hasCert || host.CertFile != ""
+	}
+
+	tlsCfg := &c.Server.TLS
+	if (tlsCfg.CertFile == "") != (tlsCfg.KeyFile == "") {
+		return configInvalidError("Server has invalid tls configuration - certFile and keyFile have to be set together")
+	}
+
+	if acme := tlsCfg.ACME; acme != nil {
+		if acme.CacheDir == "" {
+			acme.CacheDir = "/var/lib/mort/acme"
+		}
+
+		if len(c.Server.ACMEHosts()) == 0 {
+			return configInvalidError("Server has invalid tls configuration - acme has no hosts")
+		}
+	}
+
+	if c.Server.usesServerCertificates() && !hasCert && tlsCfg.CertFile == "" && tlsCfg.ACME == nil {
+		return configInvalidError("Server has invalid TLS listeners - no certificate of hosts, tls certificate or acme")
+	}
+
+	return nil
+}
+
+func (c *Config) validateProxy() error {
+	proxy := &c.Server.Proxy
+	var err error
+	if proxy.trusted, err = parseNets(proxy.TrustedProxies); err != nil {
+		return configInvalidError(fmt.Sprintf("Server has invalid proxy configuration - %s", err))
+	}
+
+	if proxy.HeaderTimeout < 0 {
+		return configInvalidError("Server has invalid proxy configuration - negative headerTimeout")
+	} else if proxy.HeaderTimeout == 0 {
+		proxy.HeaderTimeout = 5
+	}
+
+	listeners := c.Server.AllListeners()
+	for _, address := range proxy.Protocol {
+		found := false
+		for _, l := range listeners {
+			found = found || l.Address == address
+		}
+
+		if !found {
+			return configInvalidError(fmt.Sprintf("Server has invalid proxy configuration - no listener %s", address))
+		}
+
+		// any client could send header with address of its choice
+		if len(proxy.TrustedProxies) == 0 && !strings.HasPrefix(address, "unix:") {
+			return configInvalidError(fmt.Sprintf("Server has invalid proxy configuration - listener %s expects PROXY protocol without trustedProxies", address))
+		}
+	}
+
+	return nil
+}
+
+func (c *Config) validateListeners() error {
+	for i := range c.Server.Listeners {
+		listener := &c.Server.Listeners[i]
+		if listener.Address == "" {
+			return configInvalidError("Server has invalid listener - missing address")
+		}
+
+		if len(listener.Handlers) == 0 {
+			return configInvalidError(fmt.Sprintf("Server has invalid listener %s - no handlers", listener.Address))
+		}
+
+		for _, handler := range listener.Handlers {
+			known := false
+			for _, h := range handlerSets {
+				known = known || h == handler
+			}
+
+			if !known {
+				return configInvalidError(fmt.Sprintf("Server has invalid listener %s - unknown handler %s", listener.Address, handler))
+			}
+		}
+
+		if tlsCfg := listener.TLS; tlsCfg != nil {
+			if tlsCfg.ACME != nil {
+				return configInvalidError(fmt.Sprintf("Server has invalid listener %s - acme can be configured only in tls of server", listener.Address))
+			}
+
+			if (tlsCfg.CertFile == "") != (tlsCfg.KeyFile == "") {
+				return configInvalidError(fmt.Sprintf("Server has invalid listener %s - certFile and keyFile have to be set together", listener.Address))
+			}
+		}
+
+		if listener.Auth.Token != "" && listener.Has(HandlerS3) {
+			return configInvalidError(fmt.Sprintf("Server has invalid listener %s - token can't be used with s3 handler, S3 API uses Authorization header", listener.Address))
+		}
+
+		var err error
+		if listener.Auth.allow, err = parseNets(listener.Auth.Allow); err != nil {
+			return configInvalidError(fmt.Sprintf("Server has invalid listener %s - %s", listener.Address, err))
+		}
+	}
+
+	addresses := make(map[string]bool)
+	for _, listener := range c.Server.AllListeners() {
+		l := listener.Address
+		if l == "systemd:" || l == "unix:" {
+			return configInvalidError(fmt.Sprintf("Server has invalid listener %q - missing name of socket", l))
+		}
+
+		if addresses[l] {
+			return configInvalidError(fmt.Sprintf("Server has invalid listener %s - address is used by other listener", l))
+		}
+		addresses[l] = true
+	}
+
+	if c.Server.UnixSocket.Mode != "" {
+		mode, err := strconv.ParseUint(c.Server.UnixSocket.Mode, 8, 32)
+		if err != nil || mode > 0777 {
+			return configInvalidError(fmt.Sprintf("Server has invalid unixSocket configuration - invalid mode %s", c.Server.UnixSocket.Mode))
+		}
+		c.Server.UnixSocket.mode = os.FileMode(mode)
+	}
+
+	return nil
+}
+
 func (c *Config) validateServer() error {
 	if c.Server.LogLevel == "" {
 		c.Server.LogLevel = "prod"
 	}
 
-	if c.Server.SingleListen == "" {
-		c.Server.SingleListen = ":8080"
-	}
+	// configured listeners replace default ones
+	if len(c.Server.Listeners) == 0 {
+		if c.Server.SingleListen == "" {
+			c.Server.SingleListen = ":8080"
+		}
 
-	if len(c.Server.Listen) == 0 {
-		c.Server.Listen = append(c.Server.Listen, c.Server.SingleListen)
+		if c.Server.InternalListen == "" {
+			c.Server.InternalListen = ":8081"
+		}
 	}
 
-	if c.Server.InternalListen == "" {
-		c.Server.InternalListen = ":8081"
+	if len(c.Server.Listen) == 0 && c.Server.SingleListen != "" {
+		c.Server.Listen = append(c.Server.Listen, c.Server.SingleListen)
 	}
 
 	for _, l := range c.Server.Listen {
@@ -242,6 +756,10 @@ func (c *Config) validateServer() error {
 		}
 	}
 
+	if err := c.validateListeners(); err != nil {
+		return err
+	}
+
 	if c.Server.Cache.CacheSize == 0 {
 		c.Server.Cache.CacheSize = 10
 	}
@@ -254,6 +772,20 @@ func (c *Config) validateServer() error {
 		c.Server.LockTimeout = 30
 	}
 
+	if c.Server.Timeouts.Storage < 0 || c.Server.Timeouts.Transform < 0 {
+		return configInvalidError("Server has invalid timeouts configuration - timeouts can't be negative")
+	}
+
+	if c.Server.ClientClose.StatusCode == 0 {
+		c.Server.ClientClose.StatusCode = 499
+	} else if c.Server.ClientClose.StatusCode < 100 || c.Server.ClientClose.StatusCode > 599 {
+		return configInvalidError(fmt.Sprintf("Server has invalid clientClose configuration - invalid status code %d", c.Server.ClientClose.StatusCode))
+	}
+
+	if c.Server.DrainTimeout == 0 {
+		c.Server.DrainTimeout = 30
+	}
+
 	if c.Server.QueueLen == 0 {
 		c.Server.QueueLen = 5
 	}
@@ -268,6 +800,141 @@ func (c *Config) validateServer() error {
 		c.Server.Cache.Type = "memory"
 	}
 
+	if c.Server.FeatureFlags.Header == "" {
+		c.Server.FeatureFlags.Header = "X-Mort-Flags"
+	}
+
+	if c.Server.FeatureFlags.MaxAge == 0 {
+		c.Server.FeatureFlags.MaxAge = 300
+	}
+
+	if c.Server.Drift.Threshold == 0 {
+		c.Server.Drift.Threshold = 20
+	}
+
+	if c.Server.Drift.MinSamples == 0 {
+		c.Server.Drift.MinSamples = 100
+	}
+
+	if c.Server.Antivirus.Timeout == 0 {
+		c.Server.Antivirus.Timeout = 30
+	}
+
+	if c.Server.Antivirus.MaxSize <= 0 {
+		// scanned uploads are buffered, so their size is always limited
+		c.Server.Antivirus.MaxSize = 25 << 20
+	}
+
+	if c.Server.ImageLimits.MaxMegapixels == 0 {
+		c.Server.ImageLimits.MaxMegapixels = 100
+	}
+
+	if c.Server.ImageLimits.MaxMemoryMB == 0 {
+		c.Server.ImageLimits.MaxMemoryMB = 1024
+	}
+
+	for contentType := range c.Server.ImageLimits.ContentTypes {
+		if !strings.Contains(contentType, "/") {
+			return configInvalidError(fmt.Sprintf("Server has invalid image limits - %q is not content type", contentType))
+		}
+	}
+
+	if c.Server.Workers.Count < 0 || c.Server.Workers.MaxJobs < 0 {
+		return configInvalidError("Server has invalid workers configuration - count and maxJobs can't be negative")
+	}
+
+	if c.Server.Workers.SocketDir == "" {
+		c.Server.Workers.SocketDir = os.TempDir()
+	}
+
+	if c.Server.Workers.Timeout == 0 {
+		c.Server.Workers.Timeout = 60
+	}
+
+	if c.Server.Monitoring == "influx" || c.Server.Monitoring == "opentsdb" {
+		if c.Server.MetricsPush.Address == "" {
+			return configInvalidError("Server has invalid metricsPush configuration - missing address")
+		}
+
+		if c.Server.MetricsPush.FlushInterval == 0 {
+			c.Server.MetricsPush.FlushInterval = 10
+		}
+
+		if c.Server.MetricsPush.Prefix == "" {
+			c.Server.MetricsPush.Prefix = "mort_"
+		}
+	}
+
+	if c.Server.Bandwidth.Rate < 0 || c.Server.Bandwidth.Burst < 0 {
+		return configInvalidError("Server has invalid bandwidth configuration - negative rate or burst")
+	}
+
+	if c.Server.SlowLog.Threshold < 0 {
+		return configInvalidError("Server has invalid slowLog configuration - negative threshold")
+	}
+
+	if err := c.validateHosts(); err != nil {
+		return err
+	}
+
+	if err := c.validateProxy(); err != nil {
+		return err
+	}
+
+	if c.Server.Admin.Profiling && c.Server.Admin.Token == "" {
+		return configInvalidError("Server has invalid admin configuration - profiling requires token")
+	}
+
+	if c.Server.Quarantine.Threshold == 0 {
+		c.Server.Quarantine.Threshold = 3
+	}
+
+	if c.Server.Quarantine.TTL == 0 {
+		c.Server.Quarantine.TTL = 3600
+	}
+
+	if c.Server.Throttler.MemoryBudgetMB == 0 {
+		c.Server.Throttler.MemoryBudgetMB = 2048
+	}
+
+	if c.Server.Throttler.Backlog == 0 {
+		c.Server.Throttler.Backlog = 100
+	}
+
+	if c.Server.Throttler.Timeout == 0 {
+		c.Server.Throttler.Timeout = 60
+	}
+
+	if c.Server.Throttler.RetryAfter == 0 {
+		c.Server.Throttler.RetryAfter = 5
+	}
+
+	if c.Server.Throttler.StatusCode == 0 {
+		c.Server.Throttler.StatusCode = 503
+	} else if c.Server.Throttler.StatusCode != 429 && c.Server.Throttler.StatusCode != 503 {
+		return configInvalidError(fmt.Sprintf("Server has invalid throttler configuration - status code %d, allowed 429 or 503", c.Server.Throttler.StatusCode))
+	}
+
+	if c.Server.PresetsAPI.Token != "" {
+		if _, ok := c.Buckets[c.Server.PresetsAPI.Bucket]; !ok {
+			return configInvalidError(fmt.Sprintf("Server has invalid presetsAPI configuration - no bucket of name %s", c.Server.PresetsAPI.Bucket))
+		}
+
+		if c.Server.PresetsAPI.Key == "" {
+			c.Server.PresetsAPI.Key = "/presets.yml"
+		} else if !strings.HasPrefix(c.Server.PresetsAPI.Key, "/") {
+			c.Server.PresetsAPI.Key = "/" + c.Server.PresetsAPI.Key
+		}
+
+		if c.Server.PresetsAPI.Refresh == 0 {
+			c.Server.PresetsAPI.Refresh = 30
+		}
+	}
+
+	if c.Server.Trash.Interval == 0 {
+		c.Server.Trash.Interval = 3600
+	}
+
 	if c.Server.PlaceholderStr != "" {
 		buf, err := helpers.FetchObject(c.Server.PlaceholderStr)
 		if err != nil {
@@ -294,6 +961,113 @@ func (c *Config) validate() error {
 				return err
 			}
 		}
+
+		if bucket.TransformVersion < 0 {
+			return configInvalidError(fmt.Sprintf("Bucket %s has invalid transformVersion %d - it can't be negative", name, bucket.TransformVersion))
+		}
+
+		if bucket.ErrorFormat != "" && bucket.ErrorFormat != "json" {
+			return configInvalidError(fmt.Sprintf("Bucket %s has invalid errorFormat %s, allowed json", name, bucket.ErrorFormat))
+		}
+
+		for statusCode, key := range bucket.ErrorDocuments {
+			if statusCode < 400 || statusCode > 599 || strings.Trim(key, "/") == "" {
+				return configInvalidError(fmt.Sprintf("Bucket %s has invalid error document %d: %s", name, statusCode, key))
+			}
+			bucket.ErrorDocuments[statusCode] = "/" + strings.TrimPrefix(key, "/")
+		}
+
+		for i, method := range bucket.Methods {
+			bucket.Methods[i] = strings.ToUpper(method)
+			valid := false
+			for _, m := range SupportedMethods {
+				if m == bucket.Methods[i] {
+					valid = true
+					break
+				}
+			}
+
+			if !valid {
+				return configInvalidError(fmt.Sprintf("Bucket %s has invalid method %s, allowed %s", name, method, strings.Join(SupportedMethods, ", ")))
+			}
+		}
+
+		if softDelete := bucket.SoftDelete; softDelete != nil {
+			softDelete.Prefix = "/" + strings.Trim(softDelete.Prefix, "/")
+			if softDelete.Prefix == "/" {
+				softDelete.Prefix = "/.trash"
+			}
+
+			if softDelete.TTL < 0 {
+				return configInvalidError(fmt.Sprintf("Bucket %s has invalid softDelete configuration - ttl %d", name, softDelete.TTL))
+			} else if softDelete.TTL == 0 {
+				softDelete.TTL = 604800
+			}
+
+			if softDelete.Derivatives && (bucket.Transform == nil || bucket.Transform.ResultKey != "hashParent") {
+				return configInvalidError(fmt.Sprintf("Bucket %s has invalid softDelete configuration - derivatives require transform with resultKey hashParent", name))
+			}
+		}
+
+		for _, encoding := range bucket.Precompressed {
+			if _, ok := PrecompressedExtensions[encoding]; !ok {
+				return configInvalidError(fmt.Sprintf("Bucket %s has invalid precompressed encoding %s, allowed br, zstd, gzip", name, encoding))
+			}
+		}
+
+		if index := bucket.Index; index != nil {
+			if err := c.validateIndex(name, &bucket, index); err != nil {
+				return err
+			}
+		}
+
+		for i := range bucket.Rewrites {
+			if err := c.validateRewrite(&bucket.Rewrites[i]); err != nil {
+				return configInvalidError(fmt.Sprintf("Bucket %s has invalid rewrite rule %d - %s", name, i, err))
+			}
+		}
+
+		if website := bucket.Website; website != nil {
+			if err := website.parse(); err != nil {
+				return configInvalidError(fmt.Sprintf("Bucket %s has invalid website configuration - %s", name, err))
+			}
+
+			if err := c.validateIndex(name, &bucket, website.index); err != nil {
+				return err
+			}
+		}
+
+		if bandwidth := bucket.Bandwidth; bandwidth != nil && (bandwidth.Rate <= 0 || bandwidth.Burst < 0) {
+			return configInvalidError(fmt.Sprintf("Bucket %s has invalid bandwidth configuration - rate should be greater than 0 and burst can't be negative", name))
+		}
+
+		if access := bucket.Access; access != nil {
+			if err := access.parse(); err != nil {
+				return configInvalidError(fmt.Sprintf("Bucket %s has invalid access configuration - %s", name, err))
+			}
+
+			hotlinks := make(map[string]HotlinkCfg, len(access.Presets)+1)
+			for preset, hotlink := range access.Presets {
+				hotlinks["preset "+preset] = hotlink
+			}
+			if access.Hotlink != nil {
+				hotlinks["hotlink"] = *access.Hotlink
+			}
+
+			for where, hotlink := range hotlinks {
+				if hotlink.Preset == "" {
+					continue
+				}
+
+				if bucket.Transform == nil {
+					return configInvalidError(fmt.Sprintf("Bucket %s has invalid access configuration - %s uses preset without transform", name, where))
+				}
+
+				if _, ok := bucket.Transform.Preset(hotlink.Preset); !ok {
+					return configInvalidError(fmt.Sprintf("Bucket %s has invalid access configuration - %s uses unknown preset %s", name, where, hotlink.Preset))
+				}
+			}
+		}
 	}
 	return c.validateServer()
 }
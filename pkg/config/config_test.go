@@ -1,6 +1,8 @@
 package config
 
 import (
+	"net"
+	"os"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -24,6 +26,12 @@ func TestInvalidParentStorageInTransform(t *testing.T) {
 	assert.NotNil(t, err)
 }
 
+func TestInvalidResultStorageInTransform(t *testing.T) {
+	c := Config{}
+	err := c.Load("testdata/invalid-result-storage.yml")
+	assert.NotNil(t, err)
+}
+
 func TestNoBasicStorage(t *testing.T) {
 	c := Config{}
 	err := c.Load("testdata/no-basic-storage.yml")
@@ -60,3 +68,912 @@ func TestConfig_Load(t *testing.T) {
 	bucket := c.Buckets["media"]
 	assert.Equal(t, bucket.Storages.Transform().Kind, "local-meta")
 }
+
+func TestStorageFailover(t *testing.T) {
+	c := Config{}
+	err := c.Load("testdata/storage-failover.yml")
+	assert.Nil(t, err)
+
+	bucket := c.Buckets["bucket"]
+	basic := bucket.Storages.Basic()
+	assert.Equal(t, len(basic.Failover), 2)
+	assert.Equal(t, basic.Failover[0].Hash, "bucketbasichttp-failover1")
+	assert.NotNil(t, basic.CircuitBreaker, "health of primary origin should be tracked")
+	assert.Equal(t, basic.Failover[1].CircuitBreaker.Threshold, 5)
+}
+
+func TestInvalidStorageFailover(t *testing.T) {
+	c := Config{}
+	err := c.Load("testdata/invalid-storage-failover.yml")
+	assert.NotNil(t, err)
+}
+
+func TestPresetChain(t *testing.T) {
+	c := Config{}
+	err := c.Load("testdata/preset-chain.yml")
+	assert.Nil(t, err)
+
+	presets := c.Buckets["bucket"].Transform.Presets
+	small := presets["small"]
+	assert.Equal(t, 70, small.Quality)
+	assert.Equal(t, "webp", small.Format)
+	assert.True(t, small.Filters.Strip)
+	assert.Equal(t, 50, small.Filters.Thumbnail.Height)
+	assert.Equal(t, 0.8, small.Filters.Sharpen.Sigma)
+	assert.Equal(t, "base", small.Extends)
+
+	tiny := presets["tiny"]
+	assert.True(t, tiny.Filters.Grayscale)
+	assert.Equal(t, 70, tiny.Quality)
+	assert.Equal(t, 50, tiny.Filters.Thumbnail.Width)
+
+	assert.Equal(t, 100, presets["base"].Filters.Thumbnail.Width, "base preset shouldn't be changed")
+}
+
+func TestInvalidPresetChain(t *testing.T) {
+	c := Config{}
+	err := c.Load("testdata/invalid-preset-chain.yml")
+	assert.NotNil(t, err)
+
+	presets := map[string]Preset{"a": {Extends: "missing"}}
+	assert.NotNil(t, resolvePresets(presets))
+}
+
+func TestMergePresets(t *testing.T) {
+	c := Config{}
+	err := c.Load("testdata/preset-chain.yml")
+	assert.Nil(t, err)
+
+	transform := c.Buckets["bucket"].Transform
+	medium := Preset{Extends: "base"}
+	medium.Filters.Grayscale = true
+	merged, err := transform.MergePresets(map[string]Preset{"medium": medium, "small": {Quality: 40}})
+	assert.Nil(t, err)
+	assert.Equal(t, 100, merged["medium"].Filters.Thumbnail.Width)
+	assert.True(t, merged["medium"].Filters.Grayscale)
+	assert.Equal(t, 40, merged["small"].Quality)
+	assert.Nil(t, merged["small"].Filters.Thumbnail, "runtime preset should replace preset from file")
+
+	transform.SetPresets(merged)
+	_, ok := transform.Preset("medium")
+	assert.True(t, ok)
+
+	merged, err = transform.MergePresets(nil)
+	assert.Nil(t, err)
+	_, ok = merged["medium"]
+	assert.False(t, ok, "merge should start from presets of configuration file")
+
+	_, err = transform.MergePresets(map[string]Preset{"broken": {Extends: "missing"}})
+	assert.NotNil(t, err)
+}
+
+func TestStorageShards(t *testing.T) {
+	c := Config{}
+	err := c.LoadFromString(`
+buckets:
+    bucket:
+        storages:
+            basic:
+                kind: "local"
+                rootPath: "/tmp/mort"
+            transform:
+                kind: "sharded"
+                shards:
+                    - kind: "local"
+                      rootPath: "/tmp/mort-1"
+                    - kind: "local"
+                      rootPath: "/tmp/mort-2"
+`)
+	assert.Nil(t, err)
+	bucket := c.Buckets["bucket"]
+	assert.Equal(t, "buckettransformsharded-shard2", bucket.Storages.Transform().Shards[1].Hash)
+
+	c = Config{}
+	err = c.LoadFromString(`
+buckets:
+    bucket:
+        storages:
+            basic:
+                kind: "sharded"
+`)
+	assert.NotNil(t, err, "sharded storage without shards should be invalid")
+}
+
+func TestImageLimitsForContentType(t *testing.T) {
+	c := Config{}
+	err := c.LoadFromString(`
+server:
+    imageLimits:
+        maxWidth: 10000
+        contentTypes:
+            image/gif:
+                maxFrames: 100
+                maxMegapixels: 20
+            image/*:
+                maxWidth: 5000
+            application/pdf:
+                maxPages: 50
+buckets:
+    bucket:
+        storages:
+            basic:
+                kind: "local"
+                rootPath: "/tmp/mort"
+`)
+	assert.Nil(t, err)
+
+	limits := c.Server.ImageLimits.ForContentType("image/gif")
+	assert.Equal(t, 100, limits.MaxFrames)
+	assert.Equal(t, float64(20), limits.MaxMegapixels)
+	assert.Equal(t, 10000, limits.MaxWidth, "limits missing for exact content type should be inherited")
+
+	assert.Equal(t, 5000, c.Server.ImageLimits.ForContentType("image/PNG; charset=binary").MaxWidth)
+	assert.Equal(t, 50, c.Server.ImageLimits.ForContentType("application/pdf").MaxPages)
+	assert.Equal(t, 0, c.Server.ImageLimits.ForContentType("image/jpeg").MaxPages)
+
+	c = Config{}
+	err = c.LoadFromString(`
+server:
+    imageLimits:
+        contentTypes:
+            gif:
+                maxFrames: 100
+buckets:
+    bucket:
+        storages:
+            basic:
+                kind: "local"
+                rootPath: "/tmp/mort"
+`)
+	assert.NotNil(t, err, "image limits of invalid content type should be rejected")
+}
+
+func TestBucketAccess(t *testing.T) {
+	c := Config{}
+	err := c.LoadFromString(`
+buckets:
+    bucket:
+        access:
+            allow:
+                - "10.0.0.0/8"
+                - "192.168.1.1"
+            deny:
+                - "10.1.0.0/16"
+            hotlink:
+                referers:
+                    - "example.com"
+                    - "*.example.org"
+            presets:
+                open: {}
+                small:
+                    referers:
+                        - "cdn.example.net"
+                    allowEmpty: true
+        storages:
+            basic:
+                kind: "local"
+                rootPath: "/tmp/mort"
+`)
+	assert.Nil(t, err)
+
+	access := c.Buckets["bucket"].Access
+	assert.True(t, access.AllowsIP(net.ParseIP("10.2.3.4")))
+	assert.True(t, access.AllowsIP(net.ParseIP("192.168.1.1")))
+	assert.False(t, access.AllowsIP(net.ParseIP("10.1.3.4")), "denied network should be checked before allowed")
+	assert.False(t, access.AllowsIP(net.ParseIP("172.16.0.1")))
+	assert.False(t, access.AllowsIP(nil))
+
+	hotlink := access.HotlinkFor("medium")
+	assert.NotNil(t, hotlink)
+	assert.True(t, hotlink.AllowsHost("example.com"))
+	assert.True(t, hotlink.AllowsHost("www.Example.org"))
+	assert.False(t, hotlink.AllowsHost("example.org"))
+	assert.False(t, hotlink.AllowsHost("evil.com"))
+	assert.False(t, hotlink.AllowsHost(""))
+
+	assert.Nil(t, access.HotlinkFor("open"), "preset without referers should disable protection")
+	assert.True(t, access.HotlinkFor("small").AllowsHost(""))
+	assert.False(t, access.HotlinkFor("small").AllowsHost("example.com"))
+
+	c = Config{}
+	err = c.LoadFromString(`
+buckets:
+    bucket:
+        access:
+            deny:
+                - "10.0.0.300/8"
+        storages:
+            basic:
+                kind: "local"
+                rootPath: "/tmp/mort"
+`)
+	assert.NotNil(t, err, "invalid network should be rejected")
+
+	c = Config{}
+	err = c.LoadFromString(`
+buckets:
+    bucket:
+        access:
+            hotlink:
+                referers:
+                    - "example.com"
+                preset: "watermarked"
+        storages:
+            basic:
+                kind: "local"
+                rootPath: "/tmp/mort"
+`)
+	assert.NotNil(t, err, "hotlink preset requires transform with the preset")
+}
+
+func TestBucketPrecompressed(t *testing.T) {
+	c := Config{}
+	err := c.LoadFromString(`
+buckets:
+    bucket:
+        precompressed: ["br", "gzip"]
+        storages:
+            basic:
+                kind: "local"
+                rootPath: "/tmp/mort"
+`)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"br", "gzip"}, c.Buckets["bucket"].Precompressed)
+
+	c = Config{}
+	err = c.LoadFromString(`
+buckets:
+    bucket:
+        precompressed: ["deflate"]
+        storages:
+            basic:
+                kind: "local"
+                rootPath: "/tmp/mort"
+`)
+	assert.NotNil(t, err, "unsupported encoding should be rejected")
+}
+
+func TestBucketIndex(t *testing.T) {
+	c := Config{}
+	err := c.LoadFromString(`
+buckets:
+    bucket:
+        index:
+            documents: ["index.html"]
+            autoindex: "html"
+        storages:
+            basic:
+                kind: "local-meta"
+                rootPath: "/tmp/mort"
+`)
+	assert.Nil(t, err)
+	assert.Equal(t, 1000, c.Buckets["bucket"].Index.MaxKeys)
+
+	c = Config{}
+	err = c.LoadFromString(`
+buckets:
+    bucket:
+        index:
+            autoindex: "json"
+        storages:
+            basic:
+                kind: "http"
+                url: "http://example.com/<item>"
+`)
+	assert.NotNil(t, err, "autoindex requires file system storage")
+
+	c = Config{}
+	err = c.LoadFromString(`
+buckets:
+    bucket:
+        index:
+            documents: ["docs/index.html"]
+        storages:
+            basic:
+                kind: "local"
+                rootPath: "/tmp/mort"
+`)
+	assert.NotNil(t, err, "index document should be name of object in directory")
+}
+
+func TestBucketErrorDocuments(t *testing.T) {
+	c := Config{}
+	err := c.LoadFromString(`
+buckets:
+    bucket:
+        errorDocuments:
+            404: "errors/404.html"
+            503: "/errors/5xx.html"
+        storages:
+            basic:
+                kind: "local"
+                rootPath: "/tmp/mort"
+`)
+	assert.Nil(t, err)
+	assert.Equal(t, map[int]string{404: "/errors/404.html", 503: "/errors/5xx.html"}, c.Buckets["bucket"].ErrorDocuments)
+
+	c = Config{}
+	err = c.LoadFromString(`
+buckets:
+    bucket:
+        errorDocuments:
+            200: "ok.html"
+        storages:
+            basic:
+                kind: "local"
+                rootPath: "/tmp/mort"
+`)
+	assert.NotNil(t, err, "error documents should be configured only for error status codes")
+}
+
+func TestBucketTransformVersion(t *testing.T) {
+	c := Config{}
+	err := c.LoadFromString(`
+buckets:
+    bucket:
+        transformVersion: 3
+        storages:
+            basic:
+                kind: "local"
+                rootPath: "/tmp/mort"
+`)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, c.Buckets["bucket"].TransformVersion)
+
+	c = Config{}
+	err = c.LoadFromString(`
+buckets:
+    bucket:
+        transformVersion: -1
+        storages:
+            basic:
+                kind: "local"
+                rootPath: "/tmp/mort"
+`)
+	assert.NotNil(t, err, "transformVersion can't be negative")
+}
+
+func TestBucketWebsite(t *testing.T) {
+	c := Config{}
+	err := c.LoadFromString(`
+buckets:
+    bucket:
+        website:
+            indexDocument: "index.html"
+            errorDocument: "errors/404.html"
+            routingRules:
+                - condition:
+                      keyPrefixEquals: "docs/"
+                  redirect:
+                      replaceKeyPrefixWith: "documents/"
+        storages:
+            basic:
+                kind: "local"
+                rootPath: "/tmp/mort"
+`)
+	assert.Nil(t, err)
+
+	website := c.Buckets["bucket"].Website
+	assert.Equal(t, "/errors/404.html", website.ErrorDocument)
+	assert.Equal(t, []string{"index.html"}, website.Index().Documents)
+	assert.Equal(t, 302, website.Index().RedirectCode)
+
+	rule := website.RoutingRules[0]
+	assert.Equal(t, 301, rule.Redirect.HTTPRedirectCode)
+	assert.True(t, rule.Matches("docs/guide.html", 0))
+	assert.False(t, rule.Matches("docs/guide.html", 404))
+	assert.False(t, rule.Matches("images/photo.jpg", 0))
+	assert.Equal(t, "documents/guide.html", rule.RedirectKey("docs/guide.html"))
+
+	c = Config{}
+	err = c.LoadFromString(`
+buckets:
+    bucket:
+        website:
+            errorDocument: "404.html"
+        storages:
+            basic:
+                kind: "local"
+                rootPath: "/tmp/mort"
+`)
+	assert.NotNil(t, err, "index document is required")
+
+	c = Config{}
+	err = c.LoadFromString(`
+buckets:
+    bucket:
+        website:
+            indexDocument: "index.html"
+            routingRules:
+                - redirect:
+                      replaceKeyWith: "a.html"
+                      replaceKeyPrefixWith: "b/"
+        storages:
+            basic:
+                kind: "local"
+                rootPath: "/tmp/mort"
+`)
+	assert.NotNil(t, err, "replaceKeyWith and replaceKeyPrefixWith are exclusive")
+}
+
+func TestBucketRewrites(t *testing.T) {
+	c := Config{}
+	err := c.LoadFromString(`
+buckets:
+    bucket:
+        rewrites:
+            - match: "^/legacy/(.*)$"
+              redirect: "/bucket/$1"
+            - match: "^/old/(.*)$"
+              rewrite: "/$1"
+              bucket: "archive"
+        storages:
+            basic:
+                kind: "local"
+                rootPath: "/tmp/mort"
+    archive:
+        storages:
+            basic:
+                kind: "local"
+                rootPath: "/tmp/mort"
+`)
+	assert.Nil(t, err)
+	assert.Equal(t, 301, c.Buckets["bucket"].Rewrites[0].Status)
+
+	c = Config{}
+	err = c.LoadFromString(`
+buckets:
+    bucket:
+        rewrites:
+            - match: "^/old/(.*)$"
+              rewrite: "/$1"
+              bucket: "missing"
+        storages:
+            basic:
+                kind: "local"
+                rootPath: "/tmp/mort"
+`)
+	assert.NotNil(t, err, "proxy to unknown bucket should be rejected")
+
+	c = Config{}
+	err = c.LoadFromString(`
+buckets:
+    bucket:
+        rewrites:
+            - match: "^/old/(.*$"
+              rewrite: "/$1"
+        storages:
+            basic:
+                kind: "local"
+                rootPath: "/tmp/mort"
+`)
+	assert.NotNil(t, err, "invalid regexp should be rejected")
+}
+
+func TestServerHosts(t *testing.T) {
+	c := Config{}
+	err := c.LoadFromString(`
+server:
+    hosts:
+        - host: "IMG.example.com"
+          bucket: "media"
+        - host: "*.example.com"
+          bucket: "site"
+        - host: "*.s3.example.com"
+buckets:
+    media:
+        storages:
+            basic:
+                kind: "local"
+                rootPath: "/tmp/mort"
+    site:
+        storages:
+            basic:
+                kind: "local"
+                rootPath: "/tmp/mort"
+`)
+	assert.Nil(t, err)
+
+	hosts := c.Server.Hosts
+	_, bucket, ok := FindHost(hosts, "img.example.com:8080")
+	assert.True(t, ok)
+	assert.Equal(t, "media", bucket, "exact host should be preferred over wildcard")
+
+	_, bucket, _ = FindHost(hosts, "www.example.com")
+	assert.Equal(t, "site", bucket)
+
+	_, bucket, _ = FindHost(hosts, "photos.s3.example.com")
+	assert.Equal(t, "photos", bucket, "longer wildcard should be preferred and subdomain should be bucket")
+
+	_, _, ok = FindHost(hosts, "example.com")
+	assert.False(t, ok, "wildcard should not match domain itself")
+
+	c = Config{}
+	err = c.LoadFromString(`
+server:
+    hosts:
+        - host: "img.example.com"
+buckets:
+    media:
+        storages:
+            basic:
+                kind: "local"
+                rootPath: "/tmp/mort"
+`)
+	assert.NotNil(t, err, "host without wildcard requires bucket")
+
+	c = Config{}
+	err = c.LoadFromString(`
+server:
+    tlsListens: [":443"]
+    hosts:
+        - host: "img.example.com"
+          bucket: "media"
+buckets:
+    media:
+        storages:
+            basic:
+                kind: "local"
+                rootPath: "/tmp/mort"
+`)
+	assert.NotNil(t, err, "tls listener requires certificate")
+}
+
+func TestServerTLS(t *testing.T) {
+	c := Config{}
+	err := c.LoadFromString(`
+server:
+    tlsListens: [":443"]
+    tls:
+        acme:
+            hosts: ["Static.example.com"]
+    hosts:
+        - host: "img.example.com"
+          bucket: "media"
+        - host: "*.example.com"
+          bucket: "media"
+        - host: "own.example.com"
+          bucket: "media"
+          certFile: "/etc/mort/own.crt"
+          keyFile: "/etc/mort/own.key"
+buckets:
+    media:
+        storages:
+            basic:
+                kind: "local"
+                rootPath: "/tmp/mort"
+`)
+	assert.Nil(t, err)
+	assert.Equal(t, "/var/lib/mort/acme", c.Server.TLS.ACME.CacheDir)
+	assert.Equal(t, []string{"static.example.com", "img.example.com"}, c.Server.ACMEHosts(), "wildcard hosts and hosts with certificate should be skipped")
+
+	c = Config{}
+	err = c.LoadFromString(`
+server:
+    tlsListens: [":443"]
+    tls:
+        certFile: "/etc/mort/default.crt"
+buckets:
+    media:
+        storages:
+            basic:
+                kind: "local"
+                rootPath: "/tmp/mort"
+`)
+	assert.NotNil(t, err, "certFile requires keyFile")
+}
+
+func TestServerProxy(t *testing.T) {
+	c := Config{}
+	err := c.LoadFromString(`
+server:
+    listens: [":8080", ":8090"]
+    proxy:
+        protocol: [":8090"]
+        trustedProxies: ["10.0.0.0/8", "192.168.1.1"]
+buckets:
+    media:
+        storages:
+            basic:
+                kind: "local"
+                rootPath: "/tmp/mort"
+`)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, c.Server.Proxy.HeaderTimeout)
+	assert.True(t, c.Server.Proxy.HasProtocol(":8090"))
+	assert.False(t, c.Server.Proxy.HasProtocol(":8080"))
+	assert.True(t, c.Server.Proxy.IsTrusted(net.ParseIP("10.1.2.3")))
+	assert.True(t, c.Server.Proxy.IsTrusted(net.ParseIP("192.168.1.1")))
+	assert.False(t, c.Server.Proxy.IsTrusted(net.ParseIP("192.168.1.2")))
+
+	c = Config{}
+	err = c.LoadFromString(`
+server:
+    listens: [":8080"]
+    proxy:
+        protocol: [":8090"]
+buckets:
+    media:
+        storages:
+            basic:
+                kind: "local"
+                rootPath: "/tmp/mort"
+`)
+	assert.NotNil(t, err, "protocol requires existing listener")
+
+	c = Config{}
+	err = c.LoadFromString(`
+server:
+    listens: [":8080", "unix:/run/mort/proxy.sock"]
+    proxy:
+        protocol: [":8080"]
+buckets:
+    media:
+        storages:
+            basic:
+                kind: "local"
+                rootPath: "/tmp/mort"
+`)
+	assert.NotNil(t, err, "protocol requires trusted proxies")
+
+	c = Config{}
+	err = c.LoadFromString(`
+server:
+    listens: [":8080", "unix:/run/mort/proxy.sock"]
+    proxy:
+        protocol: ["unix:/run/mort/proxy.sock"]
+buckets:
+    media:
+        storages:
+            basic:
+                kind: "local"
+                rootPath: "/tmp/mort"
+`)
+	assert.Nil(t, err, "clients of unix socket are limited by its permissions")
+
+	c = Config{}
+	err = c.LoadFromString(`
+server:
+    proxy:
+        trustedProxies: ["10.0.0.0/33"]
+buckets:
+    media:
+        storages:
+            basic:
+                kind: "local"
+                rootPath: "/tmp/mort"
+`)
+	assert.NotNil(t, err, "invalid network of trusted proxy")
+}
+
+func TestServerUnixSocket(t *testing.T) {
+	c := Config{}
+	err := c.LoadFromString(`
+server:
+    listens: ["unix:/run/mort/mort.sock", "systemd:mort.socket"]
+    unixSocket:
+        mode: "0660"
+        group: "www-data"
+buckets:
+    media:
+        storages:
+            basic:
+                kind: "local"
+                rootPath: "/tmp/mort"
+`)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0660), c.Server.UnixSocket.FileMode())
+
+	c = Config{}
+	err = c.LoadFromString(`
+server:
+    unixSocket:
+        mode: "0999"
+buckets:
+    media:
+        storages:
+            basic:
+                kind: "local"
+                rootPath: "/tmp/mort"
+`)
+	assert.NotNil(t, err, "mode should be octal")
+
+	c = Config{}
+	err = c.LoadFromString(`
+server:
+    listens: ["systemd:"]
+buckets:
+    media:
+        storages:
+            basic:
+                kind: "local"
+                rootPath: "/tmp/mort"
+`)
+	assert.NotNil(t, err, "systemd listener requires name")
+}
+
+func TestServerListeners(t *testing.T) {
+	c := Config{}
+	err := c.LoadFromString(`
+server:
+    listeners:
+        - address: ":8080"
+          handlers: ["public"]
+        - address: ":9443"
+          handlers: ["s3"]
+          tls:
+              certFile: "/etc/mort/s3.crt"
+              keyFile: "/etc/mort/s3.key"
+        - address: "127.0.0.1:9090"
+          handlers: ["metrics"]
+          auth:
+              allow: ["127.0.0.1"]
+buckets:
+    media:
+        storages:
+            basic:
+                kind: "local"
+                rootPath: "/tmp/mort"
+`)
+	assert.Nil(t, err)
+	assert.Equal(t, "", c.Server.InternalListen, "default listeners should be replaced")
+	listeners := c.Server.AllListeners()
+	assert.Len(t, listeners, 3)
+	assert.True(t, listeners[0].Has(HandlerPublic))
+	assert.False(t, listeners[0].Has(HandlerS3))
+	assert.True(t, listeners[2].Auth.AllowsIP(net.ParseIP("127.0.0.1")))
+	assert.False(t, listeners[2].Auth.AllowsIP(net.ParseIP("10.0.0.1")))
+
+	c = Config{}
+	err = c.LoadFromString(`
+server:
+    listens: [":8080"]
+    tlsListens: [":8443"]
+    tls:
+        certFile: "/etc/mort/default.crt"
+        keyFile: "/etc/mort/default.key"
+buckets:
+    media:
+        storages:
+            basic:
+                kind: "local"
+                rootPath: "/tmp/mort"
+`)
+	assert.Nil(t, err)
+	listeners = c.Server.AllListeners()
+	assert.Len(t, listeners, 3)
+	assert.Nil(t, listeners[0].TLS)
+	assert.NotNil(t, listeners[1].TLS)
+	assert.Equal(t, ":8081", listeners[2].Address)
+	assert.True(t, listeners[2].Has(HandlerAdmin))
+
+	c = Config{}
+	err = c.LoadFromString(`
+server:
+    listeners:
+        - address: ":8080"
+          handlers: ["images"]
+buckets:
+    media:
+        storages:
+            basic:
+                kind: "local"
+                rootPath: "/tmp/mort"
+`)
+	assert.NotNil(t, err, "unknown handler")
+
+	c = Config{}
+	err = c.LoadFromString(`
+server:
+    listeners:
+        - address: ":9000"
+          handlers: ["s3"]
+          auth:
+              token: "secret"
+buckets:
+    media:
+        storages:
+            basic:
+                kind: "local"
+                rootPath: "/tmp/mort"
+`)
+	assert.NotNil(t, err, "token can't be used with s3 handler")
+
+	c = Config{}
+	err = c.LoadFromString(`
+server:
+    listens: [":8080"]
+    listeners:
+        - address: ":8080"
+          handlers: ["metrics"]
+buckets:
+    media:
+        storages:
+            basic:
+                kind: "local"
+                rootPath: "/tmp/mort"
+`)
+	assert.NotNil(t, err, "address used by two listeners")
+
+	c = Config{}
+	err = c.LoadFromString(`
+server:
+    listeners:
+        - address: ":8443"
+          handlers: ["public"]
+          tls: {}
+buckets:
+    media:
+        storages:
+            basic:
+                kind: "local"
+                rootPath: "/tmp/mort"
+`)
+	assert.NotNil(t, err, "TLS listener without certificate")
+}
+
+func TestTransformResultKeyTemplate(t *testing.T) {
+	c := Config{}
+	err := c.LoadFromString(`
+buckets:
+    media:
+        transform:
+            kind: "query"
+            resultKey: "/{{.Preset}}/{{.ParentKey}}-{{.Hash}}.{{.Format}}"
+        storages:
+            basic:
+                kind: "local"
+                rootPath: "/tmp/mort"
+`)
+	assert.Nil(t, err)
+	assert.NotNil(t, c.Buckets["media"].Transform.ResultKeyTemplate())
+
+	c = Config{}
+	err = c.LoadFromString(`
+buckets:
+    media:
+        transform:
+            kind: "query"
+            resultKey: "/{{.Preset}}/{{.ParentKey}}.{{.Format}}"
+        storages:
+            basic:
+                kind: "local"
+                rootPath: "/tmp/mort"
+`)
+	assert.NotNil(t, err, "query transforms need hash in key")
+
+	c = Config{}
+	err = c.LoadFromString(`
+buckets:
+    media:
+        transform:
+            kind: "query"
+            resultKey: "/{{.Unknown}}/{{.Hash}}"
+        storages:
+            basic:
+                kind: "local"
+                rootPath: "/tmp/mort"
+`)
+	assert.NotNil(t, err, "unknown field of template")
+
+	c = Config{}
+	err = c.LoadFromString(`
+buckets:
+    media:
+        transform:
+            kind: "presets"
+            path: "\\/(?P<presetName>[a-z0-9_]+)\\/(?P<parent>.*)"
+            resultKey: "{{.Preset}}/{{.ParentKey}}"
+            presets:
+                small:
+                    quality: 75
+        storages:
+            basic:
+                kind: "local"
+                rootPath: "/tmp/mort"
+            transform:
+                kind: "local"
+                rootPath: "/tmp/mort"
+`)
+	assert.NotNil(t, err, "key without hash could overwrite original")
+}
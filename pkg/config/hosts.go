@@ -0,0 +1,80 @@
+package config
+
+import (
+	"net"
+	"strings"
+)
+
+// IsWildcard check if host matches subdomains (*.example.com)
+func (h HostCfg) IsWildcard() bool {
+	return strings.HasPrefix(h.Host, "*.")
+}
+
+// Match check if host of request matches configured host, it returns name of bucket serving request
+// Wildcard host matches any subdomain, but not domain itself
+func (h HostCfg) Match(host string) (bucket string, ok bool) {
+	host = strings.ToLower(host)
+	if !h.IsWildcard() {
+		return h.Bucket, host == h.Host
+	}
+
+	subdomain := strings.TrimSuffix(host, h.Host[1:])
+	if subdomain == host || subdomain == "" {
+		return "", false
+	}
+
+	if h.Bucket != "" {
+		return h.Bucket, true
+	}
+
+	return subdomain, true
+}
+
+// FindHost returns configuration of host of request, exact hosts are preferred over wildcards and longer wildcards over shorter
+// Port of host is ignored
+func FindHost(hosts []HostCfg, host string) (HostCfg, string, bool) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	var found HostCfg
+	var foundBucket string
+	ok := false
+	for _, h := range hosts {
+		bucket, matched := h.Match(host)
+		if !matched {
+			continue
+		}
+
+		if !h.IsWildcard() {
+			return h, bucket, true
+		}
+
+		if !ok || len(h.Host) > len(found.Host) {
+			found, foundBucket, ok = h, bucket, true
+		}
+	}
+
+	return found, foundBucket, ok
+}
+
+// ACMEHosts returns host names for which certificates are issued by ACME
+// Wildcard hosts are skipped, as they can't be verified by HTTP-01 and TLS-ALPN-01 challenges
+func (s Server) ACMEHosts() []string {
+	if s.TLS.ACME == nil {
+		return nil
+	}
+
+	hosts := make([]string, 0, len(s.TLS.ACME.Hosts)+len(s.Hosts))
+	for _, host := range s.TLS.ACME.Hosts {
+		hosts = append(hosts, strings.ToLower(host))
+	}
+
+	for _, host := range s.Hosts {
+		if !host.IsWildcard() && host.CertFile == "" {
+			hosts = append(hosts, host.Host)
+		}
+	}
+
+	return hosts
+}
@@ -0,0 +1,65 @@
+package config
+
+import (
+	"net"
+)
+
+// Handler sets which can be served by listener
+const (
+	HandlerPublic  = "public"  // serving of objects and transforms
+	HandlerS3      = "s3"      // S3 API, requests signed by S3 credentials
+	HandlerAdmin   = "admin"   // reports, presets and trash API and debug endpoints
+	HandlerMetrics = "metrics" // prometheus metrics
+)
+
+var handlerSets = []string{HandlerPublic, HandlerS3, HandlerAdmin, HandlerMetrics}
+
+// Has check if listener serves handler set
+func (l ListenerCfg) Has(handler string) bool {
+	for _, h := range l.Handlers {
+		if h == handler {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AllowsIP check if client with given address can use listener
+func (a ListenerAuthCfg) AllowsIP(ip net.IP) bool {
+	if len(a.allow) == 0 {
+		return true
+	}
+
+	return ip != nil && containsIP(a.allow, ip)
+}
+
+// AllListeners returns all listeners of server
+// listens and tlsListens serve public and S3 API handlers, internalListen serves admin and metrics handlers
+func (s Server) AllListeners() []ListenerCfg {
+	listeners := make([]ListenerCfg, 0, len(s.Listen)+len(s.TLSListen)+len(s.Listeners)+1)
+	for _, address := range s.Listen {
+		listeners = append(listeners, ListenerCfg{Address: address, Handlers: []string{HandlerPublic, HandlerS3}})
+	}
+
+	for _, address := range s.TLSListen {
+		listeners = append(listeners, ListenerCfg{Address: address, Handlers: []string{HandlerPublic, HandlerS3}, TLS: &TLSCfg{}})
+	}
+
+	if s.InternalListen != "" {
+		listeners = append(listeners, ListenerCfg{Address: s.InternalListen, Handlers: []string{HandlerAdmin, HandlerMetrics}})
+	}
+
+	return append(listeners, s.Listeners...)
+}
+
+// usesServerCertificates check if any listener terminates TLS with certificates of server
+func (s Server) usesServerCertificates() bool {
+	for _, listener := range s.AllListeners() {
+		if listener.TLS != nil && listener.TLS.CertFile == "" {
+			return true
+		}
+	}
+
+	return false
+}
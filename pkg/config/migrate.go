@@ -0,0 +1,181 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// CurrentVersion is version of schema of configuration, configuration without version has version 1
+const CurrentVersion = 2
+
+// migration upgrades configuration to next version of schema, it returns warnings about changed and removed fields
+type migration func(doc yaml.MapSlice) (yaml.MapSlice, []string)
+
+// migrations by version of schema which they upgrade
+var migrations = map[int]migration{
+	1: migrateV1,
+}
+
+func mapGet(m yaml.MapSlice, key string) (interface{}, bool) {
+	for _, item := range m {
+		if item.Key == key {
+			return item.Value, true
+		}
+	}
+
+	return nil, false
+}
+
+func mapSet(m yaml.MapSlice, key string, value interface{}) yaml.MapSlice {
+	for i, item := range m {
+		if item.Key == key {
+			m[i].Value = value
+			return m
+		}
+	}
+
+	return append(m, yaml.MapItem{Key: key, Value: value})
+}
+
+func mapDel(m yaml.MapSlice, key string) yaml.MapSlice {
+	result := make(yaml.MapSlice, 0, len(m))
+	for _, item := range m {
+		if item.Key != key {
+			result = append(result, item)
+		}
+	}
+
+	return result
+}
+
+// migrateV1 moves server.listen to server.listens and removes unused server.queueLen
+func migrateV1(doc yaml.MapSlice) (yaml.MapSlice, []string) {
+	value, _ := mapGet(doc, "server")
+	server, ok := value.(yaml.MapSlice)
+	if !ok {
+		return doc, nil
+	}
+
+	var warnings []string
+	if listen, ok := mapGet(server, "listen"); ok {
+		server = mapDel(server, "listen")
+		if _, ok := mapGet(server, "listens"); ok {
+			warnings = append(warnings, "server.listen is ignored when server.listens is set, it was removed")
+		} else {
+			server = mapSet(server, "listens", []interface{}{listen})
+			warnings = append(warnings, "server.listen is deprecated, it was moved to server.listens")
+		}
+	}
+
+	if _, ok := mapGet(server, "queueLen"); ok {
+		server = mapDel(server, "queueLen")
+		warnings = append(warnings, "server.queueLen is unused, it was removed")
+	}
+
+	return mapSet(doc, "server", server), warnings
+}
+
+// schemaVersion returns version of schema of configuration
+func schemaVersion(doc yaml.MapSlice) (int, error) {
+	value, ok := mapGet(doc, "version")
+	if !ok {
+		return 1, nil
+	}
+
+	version, ok := value.(int)
+	if !ok || version < 1 {
+		return 0, fmt.Errorf("invalid version %v", value)
+	}
+
+	if version > CurrentVersion {
+		return 0, fmt.Errorf("version %d is newer than supported version %d", version, CurrentVersion)
+	}
+
+	return version, nil
+}
+
+// unknownFields returns warnings about fields which aren't part of schema, they are ignored by mort
+func unknownFields(data []byte) []string {
+	err := yaml.UnmarshalStrict(data, &Config{})
+	typeErr, ok := err.(*yaml.TypeError)
+	if !ok {
+		return nil
+	}
+
+	warnings := make([]string, 0, len(typeErr.Errors))
+	for _, e := range typeErr.Errors {
+		if strings.Contains(e, "not found in type") {
+			warnings = append(warnings, e)
+		}
+	}
+
+	return warnings
+}
+
+// Migrate upgrades YAML configuration to current version of schema
+// It returns upgraded configuration and warnings about changed, removed and unknown fields
+// Configuration in current version is returned unchanged, otherwise comments are not preserved
+func Migrate(data []byte) ([]byte, []string, error) {
+	var doc yaml.MapSlice
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, nil, err
+	}
+
+	version, err := schemaVersion(doc)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if version == CurrentVersion {
+		return data, unknownFields(data), nil
+	}
+
+	var warnings []string
+	for ; version < CurrentVersion; version++ {
+		var w []string
+		doc, w = migrations[version](doc)
+		warnings = append(warnings, w...)
+	}
+
+	doc = append(yaml.MapSlice{{Key: "version", Value: CurrentVersion}}, mapDel(doc, "version")...)
+	result, err := yaml.Marshal(doc)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return result, append(warnings, unknownFields(result)...), nil
+}
+
+// schemaWarnings returns warnings about outdated version of schema, deprecated and unknown fields of configuration
+func (c *Config) schemaWarnings(data []byte) []string {
+	var warnings []string
+	if c.Version < CurrentVersion {
+		warnings = append(warnings, fmt.Sprintf("version %d of schema is outdated, run mort config migrate", c.schemaVersion()))
+	}
+
+	if c.Server.SingleListen != "" {
+		warnings = append(warnings, "server.listen is deprecated, use server.listens")
+	}
+
+	if c.Server.QueueLen != 0 {
+		warnings = append(warnings, "server.queueLen is unused and deprecated")
+	}
+
+	return append(warnings, unknownFields(data)...)
+}
+
+// schemaVersion returns version of schema of loaded configuration
+func (c *Config) schemaVersion() int {
+	if c.Version == 0 {
+		return 1
+	}
+
+	return c.Version
+}
+
+// Warnings returns warnings about schema of loaded configuration
+func (c *Config) Warnings() []string {
+	return c.warnings
+}
@@ -0,0 +1,106 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+func TestMigrate(t *testing.T) {
+	data := []byte(`
+server:
+    listen: ":8080"
+    queueLen: 5
+    internalListen: ":8081"
+buckets:
+    media:
+        storages:
+            basic:
+                kind: "local"
+                rootPath: "/tmp/mort"
+`)
+
+	result, warnings, err := Migrate(data)
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"server.listen is deprecated, it was moved to server.listens", "server.queueLen is unused, it was removed"}, warnings)
+
+	c := Config{}
+	assert.Nil(t, yaml.Unmarshal(result, &c))
+	assert.Equal(t, CurrentVersion, c.Version)
+	assert.Equal(t, []string{":8080"}, c.Server.Listen)
+	assert.Equal(t, "", c.Server.SingleListen)
+	assert.Equal(t, 0, c.Server.QueueLen)
+	assert.Equal(t, ":8081", c.Server.InternalListen)
+	assert.Equal(t, "/tmp/mort", c.Buckets["media"].Storages["basic"].RootPath)
+}
+
+func TestMigrateListenIgnored(t *testing.T) {
+	result, warnings, err := Migrate([]byte(`
+server:
+    listen: ":8080"
+    listens: [":9090"]
+`))
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"server.listen is ignored when server.listens is set, it was removed"}, warnings)
+
+	c := Config{}
+	assert.Nil(t, yaml.Unmarshal(result, &c))
+	assert.Equal(t, []string{":9090"}, c.Server.Listen)
+}
+
+func TestMigrateCurrentVersion(t *testing.T) {
+	data := []byte(`version: 2
+server:
+    listens: [":8080"]
+    unknownField: true
+`)
+
+	result, warnings, err := Migrate(data)
+
+	assert.Nil(t, err)
+	assert.Equal(t, data, result, "configuration in current version should be unchanged")
+	assert.Len(t, warnings, 1)
+	assert.Contains(t, warnings[0], "unknownField")
+}
+
+func TestMigrateNewerVersion(t *testing.T) {
+	_, _, err := Migrate([]byte("version: 99\n"))
+
+	assert.NotNil(t, err)
+}
+
+func TestConfigWarnings(t *testing.T) {
+	c := Config{}
+	err := c.LoadFromString(`
+server:
+    listen: ":8080"
+    unknownField: true
+buckets:
+    media:
+        storages:
+            basic:
+                kind: "local"
+                rootPath: "/tmp/mort"
+`)
+
+	assert.Nil(t, err)
+	assert.Len(t, c.Warnings(), 3)
+	assert.Contains(t, c.Warnings()[0], "run mort config migrate")
+	assert.Equal(t, "server.listen is deprecated, use server.listens", c.Warnings()[1])
+	assert.Contains(t, c.Warnings()[2], "unknownField")
+
+	c = Config{}
+	err = c.LoadFromString(`
+version: 3
+buckets:
+    media:
+        storages:
+            basic:
+                kind: "local"
+                rootPath: "/tmp/mort"
+`)
+	assert.NotNil(t, err)
+}
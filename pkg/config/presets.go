@@ -0,0 +1,132 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// presetsLock protects presets of transforms which can be changed at runtime
+var presetsLock sync.RWMutex
+
+// Preset returns preset of transform with given name
+func (t *Transform) Preset(name string) (Preset, bool) {
+	presetsLock.RLock()
+	defer presetsLock.RUnlock()
+	preset, ok := t.Presets[name]
+	return preset, ok
+}
+
+// PresetNames returns sorted names of presets of transform
+func (t *Transform) PresetNames() []string {
+	presetsLock.RLock()
+	defer presetsLock.RUnlock()
+	names := make([]string, 0, len(t.Presets))
+	for name := range t.Presets {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// MergePresets returns presets from configuration file with given runtime presets, runtime preset overrides preset with the same name
+// Returned presets are resolved and can be passed to SetPresets
+func (t *Transform) MergePresets(runtime map[string]Preset) (map[string]Preset, error) {
+	merged := make(map[string]Preset, len(t.filePresets)+len(runtime))
+	for name, preset := range t.filePresets {
+		merged[name] = preset
+	}
+
+	for name, preset := range runtime {
+		merged[name] = preset
+	}
+
+	if err := resolvePresets(merged); err != nil {
+		return nil, err
+	}
+
+	return merged, nil
+}
+
+// SetPresets replace presets of transform used by requests
+func (t *Transform) SetPresets(presets map[string]Preset) {
+	presetsLock.Lock()
+	defer presetsLock.Unlock()
+	t.Presets = presets
+}
+
+// resolvePresets merge each preset with presets it is based on
+// Bases are applied in order, extends first and then presets from chain, settings of preset itself are applied last
+// Only settings with non zero value override earlier ones, filters are overridden one by one
+func resolvePresets(presets map[string]Preset) error {
+	resolved := make(map[string]Preset, len(presets))
+	for name := range presets {
+		if _, err := resolvePreset(name, presets, resolved, nil); err != nil {
+			return err
+		}
+	}
+
+	for name, preset := range resolved {
+		presets[name] = preset
+	}
+
+	return nil
+}
+
+// resolvePreset returns preset merged with its bases, path contains names of presets which are being resolved
+func resolvePreset(name string, presets, resolved map[string]Preset, path []string) (Preset, error) {
+	if preset, ok := resolved[name]; ok {
+		return preset, nil
+	}
+
+	for _, p := range path {
+		if p == name {
+			return Preset{}, fmt.Errorf("presets form a cycle %s", strings.Join(append(path, name), " -> "))
+		}
+	}
+
+	preset, ok := presets[name]
+	if !ok {
+		return Preset{}, fmt.Errorf("unknown preset %s", name)
+	}
+
+	bases := preset.Chain
+	if preset.Extends != "" {
+		bases = append([]string{preset.Extends}, bases...)
+	}
+
+	if len(bases) == 0 {
+		resolved[name] = preset
+		return preset, nil
+	}
+
+	result := Preset{}
+	for _, baseName := range bases {
+		base, err := resolvePreset(baseName, presets, resolved, append(path, name))
+		if err != nil {
+			return Preset{}, err
+		}
+
+		mergeSettings(reflect.ValueOf(&result).Elem(), reflect.ValueOf(base))
+	}
+
+	mergeSettings(reflect.ValueOf(&result).Elem(), reflect.ValueOf(preset))
+	result.Extends, result.Chain = preset.Extends, preset.Chain
+	resolved[name] = result
+	return result, nil
+}
+
+// mergeSettings copy fields of src with non zero value to dst, nested structs are merged field by field
+func mergeSettings(dst, src reflect.Value) {
+	for i := 0; i < src.NumField(); i++ {
+		field := src.Field(i)
+		if field.Kind() == reflect.Struct {
+			mergeSettings(dst.Field(i), field)
+		} else if !field.IsZero() {
+			dst.Field(i).Set(field)
+		}
+	}
+}
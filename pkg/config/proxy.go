@@ -0,0 +1,21 @@
+package config
+
+import (
+	"net"
+)
+
+// IsTrusted check if address is address of trusted proxy
+func (p ProxyCfg) IsTrusted(ip net.IP) bool {
+	return ip != nil && containsIP(p.trusted, ip)
+}
+
+// HasProtocol check if listener with given address expects PROXY protocol header
+func (p ProxyCfg) HasProtocol(address string) bool {
+	for _, l := range p.Protocol {
+		if l == address {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,54 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// ResultKeyData is data of template of result key, template is executed for each transformed image
+type ResultKeyData struct {
+	Bucket    string // name of bucket
+	Preset    string // name of preset, query for query transforms
+	ParentKey string // key of original without leading /
+	Format    string // format of transformed image, extension of original when transform doesn't change format
+	Hash      string // hash of transforms
+	Version   int    // transformVersion of bucket
+}
+
+// parseResultKey parses resultKey which is Go template, resultKey can be also hash, hashParent or empty
+// Template without .Hash is allowed only for presets transforms, because other transforms of the same preset would get the same key
+func (t *Transform) parseResultKey() error {
+	switch t.ResultKey {
+	case "", "hash", "hashParent":
+		return nil
+	}
+
+	if !strings.Contains(t.ResultKey, "{{") {
+		return fmt.Errorf("unknown resultKey %s", t.ResultKey)
+	}
+
+	tmpl, err := template.New("resultKey").Parse(t.ResultKey)
+	if err != nil {
+		return fmt.Errorf("invalid resultKey template %s", err)
+	}
+
+	sample := ResultKeyData{Bucket: "bucket", Preset: "preset", ParentKey: "dir/parent.jpg", Format: "jpg", Hash: "0123456789abcdef", Version: 1}
+	if err = tmpl.Execute(&bytes.Buffer{}, sample); err != nil {
+		return fmt.Errorf("invalid resultKey template %s", err)
+	}
+
+	if t.Kind != "presets" && !strings.Contains(t.ResultKey, ".Hash") {
+		return errors.New("resultKey template without .Hash is allowed only for presets transforms")
+	}
+
+	t.resultKey = tmpl
+	return nil
+}
+
+// ResultKeyTemplate returns template of result key, nil when resultKey isn't template
+func (t *Transform) ResultKeyTemplate() *template.Template {
+	return t.resultKey
+}
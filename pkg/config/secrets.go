@@ -0,0 +1,313 @@
+package config
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aldor007/mort/pkg/monitoring"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// SecretResolver returns value of secret for reference given without scheme (e.g. "secret/data/mort#key" for "vault:secret/data/mort#key")
+type SecretResolver func(ref string) (string, error)
+
+// secretResolvers is map of scheme to resolver of secret references
+var secretResolvers = map[string]SecretResolver{
+	"env":    resolveEnvSecret,
+	"file":   resolveFileSecret,
+	"vault":  resolveVaultSecret,
+	"awskms": resolveAWSKMSSecret,
+}
+
+// secretsLock protects secretResolvers, secretValues and secretListeners
+var secretsLock sync.RWMutex
+
+// secretValues is cache of resolved references
+var secretValues = make(map[string]string)
+
+// secretListeners are notified when value of any secret changed
+var secretListeners []func()
+
+// RegisterSecretResolver adds resolver of secret references with given scheme
+func RegisterSecretResolver(scheme string, resolver SecretResolver) {
+	secretsLock.Lock()
+	defer secretsLock.Unlock()
+	secretResolvers[scheme] = resolver
+}
+
+// OnSecretsChange registers function called when value of any secret changed after refresh
+func OnSecretsChange(fn func()) {
+	secretsLock.Lock()
+	defer secretsLock.Unlock()
+	secretListeners = append(secretListeners, fn)
+}
+
+// parseSecretRef returns resolver and reference when value is in form scheme:reference
+func parseSecretRef(value string) (SecretResolver, string, bool) {
+	i := strings.Index(value, ":")
+	if i <= 0 {
+		return nil, "", false
+	}
+
+	secretsLock.RLock()
+	resolver, ok := secretResolvers[value[:i]]
+	secretsLock.RUnlock()
+	return resolver, value[i+1:], ok
+}
+
+// Secret returns value of secret reference, values which aren't references are returned unchanged
+// Resolved values are cached until next RefreshSecrets
+func Secret(value string) (string, error) {
+	resolver, ref, ok := parseSecretRef(value)
+	if !ok {
+		return value, nil
+	}
+
+	secretsLock.RLock()
+	resolved, ok := secretValues[value]
+	secretsLock.RUnlock()
+	if ok {
+		return resolved, nil
+	}
+
+	resolved, err := resolver(ref)
+	if err != nil {
+		return "", errors.Wrapf(err, "unable to resolve secret %s", value)
+	}
+
+	secretsLock.Lock()
+	secretValues[value] = resolved
+	secretsLock.Unlock()
+	return resolved, nil
+}
+
+// RefreshSecrets resolves again all used secret references, listeners are notified when any value changed
+// Previous value is kept when reference can't be resolved
+func RefreshSecrets() error {
+	secretsLock.RLock()
+	refs := make([]string, 0, len(secretValues))
+	for value := range secretValues {
+		refs = append(refs, value)
+	}
+	secretsLock.RUnlock()
+
+	var lastErr error
+	var changed bool
+	for _, value := range refs {
+		resolver, ref, _ := parseSecretRef(value)
+		resolved, err := resolver(ref)
+		if err != nil {
+			lastErr = errors.Wrapf(err, "unable to resolve secret %s", value)
+			continue
+		}
+
+		secretsLock.Lock()
+		if secretValues[value] != resolved {
+			secretValues[value] = resolved
+			changed = true
+		}
+		secretsLock.Unlock()
+	}
+
+	if changed {
+		secretsLock.RLock()
+		listeners := secretListeners
+		secretsLock.RUnlock()
+		for _, fn := range listeners {
+			fn()
+		}
+	}
+
+	return lastErr
+}
+
+// WatchSecrets refreshes secrets in given interval, returned function stops refreshing
+func WatchSecrets(interval time.Duration) func() {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				if err := RefreshSecrets(); err != nil {
+					monitoring.Log().Warn("Config/WatchSecrets unable to refresh secret", zap.Error(err))
+				}
+			case <-done:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+	}
+}
+
+// WithSecrets returns copy of storage configuration with resolved secret references
+func (s Storage) WithSecrets() (Storage, error) {
+	var err error
+	for _, field := range []*string{&s.AccessKey, &s.SecretAccessKey, &s.Username, &s.Password, &s.Account, &s.Key} {
+		if *field, err = Secret(*field); err != nil {
+			return s, err
+		}
+	}
+
+	if len(s.Headers) != 0 {
+		headers := make(map[string]string, len(s.Headers))
+		for k, v := range s.Headers {
+			if headers[k], err = Secret(v); err != nil {
+				return s, err
+			}
+		}
+		s.Headers = headers
+	}
+
+	if s.Encryption != nil {
+		encryption := *s.Encryption
+		if encryption.Key, err = Secret(encryption.Key); err != nil {
+			return s, err
+		}
+
+		encryption.PreviousKeys = make([]string, len(s.Encryption.PreviousKeys))
+		for i, key := range s.Encryption.PreviousKeys {
+			if encryption.PreviousKeys[i], err = Secret(key); err != nil {
+				return s, err
+			}
+		}
+		s.Encryption = &encryption
+	}
+
+	return s, nil
+}
+
+// ResolvedKeys returns keys of bucket with resolved secret references, keys which can't be resolved are skipped
+// References are resolved on each call (from cache) so keys rotated by RefreshSecrets are used
+func (b Bucket) ResolvedKeys() []S3Key {
+	keys := make([]S3Key, 0, len(b.Keys))
+	for _, key := range b.Keys {
+		accessKey, err := Secret(key.AccessKey)
+		if err != nil {
+			monitoring.Log().Warn("Config/ResolvedKeys unable to resolve access key", zap.String("bucket", b.Name), zap.Error(err))
+			continue
+		}
+
+		secretAccessKey, err := Secret(key.SecretAccessKey)
+		if err != nil {
+			monitoring.Log().Warn("Config/ResolvedKeys unable to resolve secret access key", zap.String("bucket", b.Name), zap.Error(err))
+			continue
+		}
+
+		keys = append(keys, S3Key{AccessKey: accessKey, SecretAccessKey: secretAccessKey})
+	}
+
+	return keys
+}
+
+// resolveEnvSecret reads secret from environment variable
+func resolveEnvSecret(ref string) (string, error) {
+	value, ok := os.LookupEnv(ref)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", ref)
+	}
+
+	return value, nil
+}
+
+// resolveFileSecret reads secret from file (e.g. mounted by kubernetes), trailing new line is removed
+func resolveFileSecret(ref string) (string, error) {
+	buf, err := ioutil.ReadFile(ref)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(string(buf), "\r\n"), nil
+}
+
+// vaultClient is used for reading secrets from Vault
+var vaultClient = &http.Client{Timeout: 10 * time.Second}
+
+// resolveVaultSecret reads key of secret from Vault in form path#key, address and token are taken from VAULT_ADDR and VAULT_TOKEN
+// both KV version 1 and 2 are supported, for version 2 path has to contain "data" (e.g. secret/data/mort#key)
+func resolveVaultSecret(ref string) (string, error) {
+	parts := strings.SplitN(ref, "#", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", errors.New("invalid vault reference, expected path#key")
+	}
+
+	addr := os.Getenv("VAULT_ADDR")
+	if addr == "" {
+		return "", errors.New("VAULT_ADDR is not set")
+	}
+
+	req, err := http.NewRequest("GET", strings.TrimSuffix(addr, "/")+"/v1/"+strings.TrimPrefix(parts[0], "/"), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", os.Getenv("VAULT_TOKEN"))
+	if namespace := os.Getenv("VAULT_NAMESPACE"); namespace != "" {
+		req.Header.Set("X-Vault-Namespace", namespace)
+	}
+
+	res, err := vaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("vault responded with status %d", res.StatusCode)
+	}
+
+	var secret struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err = json.NewDecoder(res.Body).Decode(&secret); err != nil {
+		return "", err
+	}
+
+	data := secret.Data
+	// KV version 2 nests values in data.data
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		if _, hasMetadata := data["metadata"]; hasMetadata {
+			data = nested
+		}
+	}
+
+	value, ok := data[parts[1]].(string)
+	if !ok {
+		return "", fmt.Errorf("key %s not found in vault secret", parts[1])
+	}
+
+	return value, nil
+}
+
+// resolveAWSKMSSecret decrypts base64 encoded ciphertext with AWS KMS, credentials are taken from default AWS chain
+func resolveAWSKMSSecret(ref string) (string, error) {
+	ciphertext, err := base64.StdEncoding.DecodeString(ref)
+	if err != nil {
+		return "", errors.Wrap(err, "invalid awskms ciphertext")
+	}
+
+	sess, err := session.NewSession()
+	if err != nil {
+		return "", err
+	}
+
+	out, err := kms.New(sess).Decrypt(&kms.DecryptInput{CiphertextBlob: ciphertext})
+	if err != nil {
+		return "", err
+	}
+
+	return string(out.Plaintext), nil
+}
@@ -0,0 +1,167 @@
+package config
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSecret(t *testing.T) {
+	os.Setenv("MORT_TEST_SECRET", "from-env")
+	defer os.Unsetenv("MORT_TEST_SECRET")
+
+	value, err := Secret("env:MORT_TEST_SECRET")
+	assert.Nil(t, err)
+	assert.Equal(t, "from-env", value)
+
+	value, err = Secret("plain:value")
+	assert.Nil(t, err)
+	assert.Equal(t, "plain:value", value, "unknown scheme should be treated as value")
+
+	_, err = Secret("env:MORT_TEST_MISSING")
+	assert.NotNil(t, err)
+
+	file, _ := ioutil.TempFile("", "mort-secret")
+	defer os.Remove(file.Name())
+	file.Write([]byte("from-file\n"))
+	file.Close()
+
+	value, err = Secret("file:" + file.Name())
+	assert.Nil(t, err)
+	assert.Equal(t, "from-file", value)
+}
+
+func TestVaultSecret(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "token" {
+			w.WriteHeader(403)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/v1/secret/data/mort":
+			w.Write([]byte(`{"data": {"data": {"key": "kv2"}, "metadata": {"version": 1}}}`))
+		case "/v1/kv/mort":
+			w.Write([]byte(`{"data": {"key": "kv1"}}`))
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	defer server.Close()
+	os.Setenv("VAULT_ADDR", server.URL)
+	os.Setenv("VAULT_TOKEN", "token")
+	defer os.Unsetenv("VAULT_ADDR")
+	defer os.Unsetenv("VAULT_TOKEN")
+
+	value, err := Secret("vault:secret/data/mort#key")
+	assert.Nil(t, err)
+	assert.Equal(t, "kv2", value)
+
+	value, err = Secret("vault:kv/mort#key")
+	assert.Nil(t, err)
+	assert.Equal(t, "kv1", value)
+
+	_, err = Secret("vault:kv/mort#missing")
+	assert.NotNil(t, err)
+
+	_, err = Secret("vault:kv/other#key")
+	assert.NotNil(t, err)
+}
+
+func TestRefreshSecrets(t *testing.T) {
+	// drop references resolved by other tests
+	secretValues = make(map[string]string)
+	current := "first"
+	RegisterSecretResolver("test", func(ref string) (string, error) {
+		return current + "-" + ref, nil
+	})
+
+	var notified int
+	OnSecretsChange(func() {
+		notified++
+	})
+
+	s, err := Storage{Kind: "s3", AccessKey: "test:access", SecretAccessKey: "secret", Headers: map[string]string{"Authorization": "test:header"}}.WithSecrets()
+	assert.Nil(t, err)
+	assert.Equal(t, "first-access", s.AccessKey)
+	assert.Equal(t, "secret", s.SecretAccessKey)
+	assert.Equal(t, "first-header", s.Headers["Authorization"])
+
+	assert.Nil(t, RefreshSecrets())
+	assert.Equal(t, 0, notified)
+
+	current = "second"
+	assert.Nil(t, RefreshSecrets())
+	assert.Equal(t, 1, notified)
+	value, _ := Secret("test:access")
+	assert.Equal(t, "second-access", value)
+}
+
+func TestStorageSecrets(t *testing.T) {
+	os.Setenv("MORT_TEST_KEY", "access")
+	defer os.Unsetenv("MORT_TEST_KEY")
+
+	c := Config{}
+	err := c.LoadFromString(`
+buckets:
+    secret:
+        keys:
+            - accessKey: "env:MORT_TEST_KEY"
+              secretAccessKey: "secret"
+        storages:
+            basic:
+                kind: "s3"
+                accessKey: "env:MORT_TEST_KEY"
+                secretAccessKey: "secret"
+`)
+	assert.Nil(t, err)
+	bucket := c.Buckets["secret"]
+	assert.Equal(t, "access", bucket.ResolvedKeys()[0].AccessKey)
+	assert.Equal(t, "env:MORT_TEST_KEY", bucket.Storages.Basic().AccessKey, "storage secrets should be resolved when client is created")
+
+	err = c.LoadFromString(`
+buckets:
+    secret:
+        storages:
+            basic:
+                kind: "s3"
+                accessKey: "env:MORT_TEST_MISSING"
+                secretAccessKey: "secret"
+`)
+	assert.NotNil(t, err)
+}
+
+func TestBucketKeysRotation(t *testing.T) {
+	secretValues = make(map[string]string)
+	current := "first"
+	RegisterSecretResolver("rotate", func(ref string) (string, error) {
+		return current + "-" + ref, nil
+	})
+
+	c := Config{}
+	err := c.LoadFromString(`
+buckets:
+    secret:
+        keys:
+            - accessKey: "rotate:access"
+              secretAccessKey: "rotate:secret"
+        storages:
+            basic:
+                kind: "local-meta"
+                rootPath: "/tmp"
+`)
+	assert.Nil(t, err)
+	assert.Len(t, c.BucketsByAccessKey("first-access"), 1)
+
+	current = "second"
+	assert.Nil(t, RefreshSecrets())
+
+	assert.Len(t, c.BucketsByAccessKey("first-access"), 0, "rotated access key shouldn't be accepted")
+	buckets := c.BucketsByAccessKey("second-access")
+	assert.Len(t, buckets, 1)
+	assert.Equal(t, []S3Key{{AccessKey: "second-access", SecretAccessKey: "second-secret"}}, buckets[0].ResolvedKeys())
+}
@@ -0,0 +1,10 @@
+package config
+
+import (
+	"os"
+)
+
+// FileMode returns permissions of socket file, it is zero when mode isn't configured
+func (u UnixSocketCfg) FileMode() os.FileMode {
+	return u.mode
+}
@@ -1,16 +1,34 @@
 package config
 
-import "regexp"
+import (
+	"net"
+	"os"
+	"regexp"
+	"strings"
+	"text/template"
+)
 
 // Preset describe properties of transform preset
 type Preset struct {
-	Quality int    `yaml:"quality"`
-	Format  string `yaml:"format"`
-	Filters struct {
+	Extends            string   `yaml:"extends"` // name of preset which settings are used as defaults
+	Chain              []string `yaml:"chain"`   // names of presets applied in order after extends, later ones override earlier
+	Quality            int      `yaml:"quality"`
+	Format             string   `yaml:"format"`
+	ColorProfile       string   `yaml:"colorProfile"`       // srgb - convert to sRGB, keep - preserve ICC profile of source
+	AutoQuality        bool     `yaml:"autoQuality"`        // choose the lowest quality meeting QualityTarget
+	QualityTarget      float64  `yaml:"qualityTarget"`      // minimal SSIM score for autoQuality (default 0.985)
+	MaxBytes           int      `yaml:"maxBytes"`           // max size of result in bytes
+	Compression        int      `yaml:"compression"`        // zlib compression level of PNG, overrides bucket encoder default
+	Speed              int      `yaml:"speed"`              // encoder speed of HEIF/AVIF, overrides bucket encoder default
+	ChromaSubsampling  string   `yaml:"chromaSubsampling"`  // chroma subsampling of JPEG (4:4:4 or 4:2:0), overrides bucket encoder default
+	Effort             int      `yaml:"effort"`             // encoder effort of WebP (0-6), overrides bucket encoder default
+	WithoutEnlargement *bool    `yaml:"withoutEnlargement"` // don't upscale images smaller than requested size, overrides bucket default
+	Filters            struct {
 		Thumbnail *struct {
-			Width  int    `yaml:"width"`
-			Height int    `yaml:"height"`
-			Mode   string `yaml:"mode"`
+			Width   int    `yaml:"width"`
+			Height  int    `yaml:"height"`
+			Mode    string `yaml:"mode"`
+			Gravity string `yaml:"gravity"` // when set thumbnail fills width x height and is cropped according to gravity
 		} `yaml:"thumbnail,omitempty"`
 		Interlace bool `yaml:"interlace"`
 		Crop      *struct {
@@ -30,13 +48,41 @@ type Preset struct {
 			Width  int `yaml:"width"`
 			Height int `yaml:"height"`
 		} `yaml:"resizeCropAuto,omitempty"`
-		AutoRotate bool `yaml:"auto_rotate"`
-		Grayscale  bool `yaml:"grayscale"`
-		Strip      bool `yaml:"strip"`
+		AutoRotate bool   `yaml:"auto_rotate"`
+		Grayscale  bool   `yaml:"grayscale"`
+		Strip      bool   `yaml:"strip"`
+		StripMode  string `yaml:"stripMode"` // all, gps or keepCopyright, implies strip
+		KeepICC    bool   `yaml:"keepICC"`   // keep ICC profile when stripping metadata
 		Blur       *struct {
 			Sigma   float64 `yaml:"sigma"`
 			MinAmpl float64 `yaml:"minAmpl"`
 		} `yaml:"blur,omitempty"`
+		Sharpen *struct {
+			Radius    float64 `yaml:"radius"`    // radius of mask in px, derived from sigma when not set
+			Sigma     float64 `yaml:"sigma"`     // default 1
+			Amount    float64 `yaml:"amount"`    // default 1
+			Threshold float64 `yaml:"threshold"` // 0-1
+		} `yaml:"sharpen,omitempty"`
+		Duotone *struct {
+			Shadow    string `yaml:"shadow"`    // hex color of darkest pixels
+			Highlight string `yaml:"highlight"` // hex color of lightest pixels
+		} `yaml:"duotone,omitempty"`
+		Overlay *struct {
+			Color   string  `yaml:"color"`   // hex color
+			Opacity float64 `yaml:"opacity"` // 0-1, default 1
+			Mode    string  `yaml:"mode"`    // blend mode, default normal
+		} `yaml:"overlay,omitempty"`
+		Composite *struct {
+			Image    string  `yaml:"image"`    // key of image in the same bucket
+			Position string  `yaml:"position"` // e.g. bottom-right or "left,top" in px
+			Width    int     `yaml:"width"`    // width of placed image, original size when not set
+			Opacity  float64 `yaml:"opacity"`  // 0-1, default 1
+			Mode     string  `yaml:"mode"`     // blend mode, default normal
+		} `yaml:"composite,omitempty"`
+		Pixelate *struct {
+			BlockSize int      `yaml:"blockSize"` // size of block in px, default 16
+			Regions   []string `yaml:"regions"`   // "x,y,width,height" in px of source or "faces", whole image when empty
+		} `yaml:"pixelate,omitempty"`
 		Watermark *struct {
 			Image    string  `yaml:"image"`
 			Position string  `yaml:"position"`
@@ -45,36 +91,153 @@ type Preset struct {
 		Rotate *struct {
 			Angle int `yaml:"angle"`
 		} `yaml:"rotate,omitempty"`
+		Trim *struct {
+			Tolerance  *int   `yaml:"tolerance"`  // max difference of color channel (0-255) from background, default 10
+			Background string `yaml:"background"` // hex color of border, detected from top left pixel when empty
+		} `yaml:"trim,omitempty"`
 	} `yaml:"filters"`
 }
 
 // Transform describe transform for bucket
 type Transform struct {
-	Path          string `yaml:"path"`
-	ParentStorage string `yaml:"parentStorage"`
-	ParentBucket  string `yaml:"parentBucket"`
-	PathRegexp    *regexp.Regexp
-	Kind          string            `yaml:"kind"`
-	Presets       map[string]Preset `yaml:"presets"`
-	CheckParent   bool              `yaml:"checkParent"`
-	ResultKey     string            `yaml:"resultKey"`
+	Path               string `yaml:"path"`
+	ParentStorage      string `yaml:"parentStorage"`
+	ParentBucket       string `yaml:"parentBucket"`
+	ResultStorage      string `yaml:"resultStorage"` // name of storage for transformed images (default transform)
+	PathRegexp         *regexp.Regexp
+	Kind               string            `yaml:"kind"`
+	Presets            map[string]Preset `yaml:"presets"`
+	CheckParent        bool              `yaml:"checkParent"`
+	ResultKey          string            `yaml:"resultKey"`          // hash, hashParent or Go template of key, e.g. /{{.Preset}}/{{.ParentKey}}.{{.Format}}
+	Encoder            *EncoderCfg       `yaml:"encoder"`            // encoder defaults applied to all transforms
+	Engine             string            `yaml:"engine"`             // name of image engine used for transforms (default libvips)
+	Engines            map[string]string `yaml:"engines"`            // image engine per content type of parent, overrides Engine
+	Lifecycle          *LifecycleCfg     `yaml:"lifecycle"`          // expiration of transformed images in result storage
+	Pool               *PoolCfg          `yaml:"pool"`               // separate pool of transforms of bucket, applied on top of global throttler
+	WithoutEnlargement bool              `yaml:"withoutEnlargement"` // default for transforms, images smaller than requested size are served without upscaling
+	filePresets        map[string]Preset // presets from configuration file, base for presets changed at runtime
+	resultKey          *template.Template
+}
+
+// PoolCfg configure pool of concurrent transforms of bucket, so single tenant can't monopolize image engine
+type PoolCfg struct {
+	Size    int `yaml:"size"`    // max number of concurrent transforms of bucket
+	Backlog int `yaml:"backlog"` // max number of transforms waiting in pool (default 100)
+	Timeout int `yaml:"timeout"` // max time in seconds transform waits in pool (default 60)
+}
+
+// LifecycleCfg configure expiration of transformed images
+type LifecycleCfg struct {
+	TTL       int   `yaml:"ttl"`       // time in seconds after last access after which transformed image is removed, 0 means no limit
+	MaxSizeMB int64 `yaml:"maxSizeMB"` // max size of result storage in MB, least recently used images are removed above it
+	Interval  int   `yaml:"interval"`  // time in seconds between runs of janitor (default 3600)
+	Orphans   bool  `yaml:"orphans"`   // remove transformed images which originals don't exist anymore
+}
+
+// EncoderCfg default encoder options for transforms of bucket, presets and query can only enable more options
+type EncoderCfg struct {
+	Progressive       bool   `yaml:"progressive"`       // progressive JPEG and interlaced PNG
+	PNGCompression    int    `yaml:"pngCompression"`    // zlib compression level of PNG (1-9)
+	Speed             int    `yaml:"speed"`             // encoder speed of HEIF/AVIF (0-9), lower is slower and gives smaller files
+	ChromaSubsampling string `yaml:"chromaSubsampling"` // chroma subsampling of JPEG, 4:4:4 (none) or 4:2:0
+	Effort            int    `yaml:"effort"`            // encoder effort of WebP (0-6), higher is slower and gives smaller files
 }
 
 // Storage contains information about kind of used storage
 type Storage struct {
-	RootPath        string            `yaml:"rootPath,omitempty"`        // root path for local-* storage
-	Kind            string            `yaml:"kind"`                      // type of storage from list ("local", "local-meta", "s3", "http", "b2","noop")
-	Url             string            `yaml:"url,omitempty"`             // Url for http storage
-	Headers         map[string]string `yaml:"headers,omitempty"`         // request headers for http storage
-	AccessKey       string            `yaml:"accessKey,omitempty"`       // access key for s3 storage
-	SecretAccessKey string            `yaml:"secretAccessKey,omitempty"` // SecretAccessKey for s3 storage
-	Region          string            `yaml:"region,omitempty"`          // region for s3 storage
-	Endpoint        string            `yaml:"endpoint,omitempty"`        // endpoint for s3 storage
-	PathPrefix      string            `yaml:"pathPrefix,omitempty"`      // prefix in path for all storage
-	Bucket          string            `yaml:"bucket"`
-	Account         string            `yaml:"account"` // account name for b2
-	Key             string            `yaml:"key"`     // key for b2
-	Hash            string            // unique hash for given storage
+	RootPath           string             `yaml:"rootPath,omitempty"`        // root path for local-* storage, MFS directory for ipfs storage
+	Kind               string             `yaml:"kind"`                      // type of storage from list ("local", "local-meta", "s3", "http", "b2", "ftp", "memory", "ipfs", "sharded", "noop")
+	Url                string             `yaml:"url,omitempty"`             // Url for http storage or API of ipfs storage
+	Headers            map[string]string  `yaml:"headers,omitempty"`         // request headers for http storage
+	AccessKey          string             `yaml:"accessKey,omitempty"`       // access key for s3 storage
+	SecretAccessKey    string             `yaml:"secretAccessKey,omitempty"` // SecretAccessKey for s3 storage
+	Region             string             `yaml:"region,omitempty"`          // region for s3 storage
+	Endpoint           string             `yaml:"endpoint,omitempty"`        // endpoint for s3 storage, authorization url for native b2 storage
+	PathPrefix         string             `yaml:"pathPrefix,omitempty"`      // prefix in path for all storage
+	Bucket             string             `yaml:"bucket"`
+	Address            string             `yaml:"address,omitempty"`            // host:port of ftp server
+	Username           string             `yaml:"username,omitempty"`           // user name for ftp storage
+	Password           string             `yaml:"password,omitempty"`           // password for ftp storage
+	TLS                string             `yaml:"tls,omitempty"`                // FTPS mode for ftp storage ("explicit" or "implicit")
+	InsecureSkipVerify bool               `yaml:"insecureSkipVerify,omitempty"` // disable verification of FTPS server certificate
+	Seed               map[string]string  `yaml:"seed,omitempty"`               // object key to local file path map, files are loaded to memory storage on start
+	Gateway            string             `yaml:"gateway,omitempty"`            // gateway used by ipfs storage for reading objects addressed by CID
+	Pin                bool               `yaml:"pin,omitempty"`                // pin objects stored in ipfs storage
+	Account            string             `yaml:"account"`                      // account name for b2
+	Key                string             `yaml:"key"`                          // key for b2
+	Native             bool               `yaml:"native,omitempty"`             // use native B2 API adapter with large file uploads and retries of b2 storage instead of stow adapter
+	Transport          *TransportCfg      `yaml:"transport,omitempty"`          // connection pool settings for http, s3 and s3-fixed storage
+	Retry              *RetryCfg          `yaml:"retry,omitempty"`              // retrying of failed reads from storage
+	CircuitBreaker     *CircuitBreakerCfg `yaml:"circuitBreaker,omitempty"`     // fast failing of requests to not working storage
+	Failover           []Storage          `yaml:"failover,omitempty"`           // origins used in given order when storage fails to return object
+	Shards             []Storage          `yaml:"shards,omitempty"`             // storages between which objects of sharded storage are distributed by consistent hash of key
+	Encryption         *EncryptionCfg     `yaml:"encryption,omitempty"`         // client side encryption of objects
+	Mirror             string             `yaml:"mirror,omitempty"`             // name of storage to which objects fetched from this storage are copied
+	MirrorStorage      *Storage           `yaml:"-"`                            // configuration of mirror storage
+	DiskCache          *DiskCacheCfg      `yaml:"diskCache,omitempty"`          // local disk cache of objects fetched from storage
+	ParallelGet        *ParallelGetCfg    `yaml:"parallelGet,omitempty"`        // fetching of big objects with concurrent ranged requests
+	Hash               string             // unique hash for given storage
+}
+
+// TransportCfg contains settings of HTTP connection pool used for communication with remote storage
+type TransportCfg struct {
+	MaxIdleConns          int    `yaml:"maxIdleConns"`          // max number of idle connections
+	MaxIdleConnsPerHost   int    `yaml:"maxIdleConnsPerHost"`   // max number of idle connections to single host
+	MaxConnsPerHost       int    `yaml:"maxConnsPerHost"`       // max number of connections to single host (0 - no limit)
+	IdleConnTimeout       int    `yaml:"idleConnTimeout"`       // time in seconds after which idle connection is closed
+	DialTimeout           int    `yaml:"dialTimeout"`           // connect timeout in seconds
+	KeepAlive             int    `yaml:"keepAlive"`             // interval of TCP keep-alive probes in seconds
+	TLSHandshakeTimeout   int    `yaml:"tlsHandshakeTimeout"`   // TLS handshake timeout in seconds
+	ResponseHeaderTimeout int    `yaml:"responseHeaderTimeout"` // time in seconds to wait for response headers
+	InsecureSkipVerify    bool   `yaml:"insecureSkipVerify"`    // disable verification of server certificate
+	Proxy                 string `yaml:"proxy"`                 // url of proxy server
+}
+
+// RetryCfg contains settings of retrying idempotent storage operations (get, head)
+type RetryCfg struct {
+	Attempts   int `yaml:"attempts"`   // max number of attempts
+	Backoff    int `yaml:"backoff"`    // delay in milliseconds before first retry, it is doubled after each attempt
+	MaxBackoff int `yaml:"maxBackoff"` // max delay in milliseconds between attempts
+}
+
+// CircuitBreakerCfg contains settings of storage circuit breaker
+type CircuitBreakerCfg struct {
+	Threshold int `yaml:"threshold"` // number of consecutive failures after which breaker is opened
+	Cooldown  int `yaml:"cooldown"`  // time in seconds after which breaker lets single request through to check storage
+}
+
+// DiskCacheCfg contains settings of local disk cache of objects fetched from remote storage
+type DiskCacheCfg struct {
+	Path       string `yaml:"path"`       // directory in which objects are cached
+	MaxSizeMB  int64  `yaml:"maxSizeMB"`  // max size of cached objects in MB, least recently used objects are removed above it (default 1024)
+	Revalidate bool   `yaml:"revalidate"` // check validators of cached object with HEAD request to storage before it is used
+}
+
+// ParallelGetCfg contains settings of fetching big objects with concurrent ranged requests
+type ParallelGetCfg struct {
+	ThresholdMB int64 `yaml:"thresholdMB"` // min size of object in MB fetched in parts (default 32)
+	PartSizeMB  int64 `yaml:"partSizeMB"`  // size of single part in MB (default 8)
+	Concurrency int   `yaml:"concurrency"` // max number of parts fetched or buffered at once (default 4)
+}
+
+// EncryptionCfg contains keys used for client side encryption of objects in storage
+type EncryptionCfg struct {
+	Key              string   `yaml:"key"`              // base64 encoded 256 bit key used for wrapping data keys of new objects
+	KMS              *KMSCfg  `yaml:"kms,omitempty"`    // AWS KMS key generating data keys of new objects, used instead of key
+	PreviousKeys     []string `yaml:"previousKeys"`     // keys used only for unwrapping data keys of objects stored before rotation
+	AllowUnencrypted bool     `yaml:"allowUnencrypted"` // return objects stored without encryption instead of failing
+}
+
+// KMSCfg points to AWS KMS key, credentials are taken from default AWS chain
+type KMSCfg struct {
+	KeyID  string `yaml:"keyId"`  // id, ARN or alias of key
+	Region string `yaml:"region"` // region of key, default region of AWS chain when empty
+}
+
+// SameLocation check if both storages point to the same place
+func (s Storage) SameLocation(other Storage) bool {
+	return s.Kind == other.Kind && s.RootPath == other.RootPath && s.Url == other.Url && s.Bucket == other.Bucket &&
+		s.Endpoint == other.Endpoint && strings.Trim(s.PathPrefix, "/") == strings.Trim(other.PathPrefix, "/")
 }
 
 // StorageTypes contains map of storage for bucket
@@ -90,6 +253,15 @@ func (s *StorageTypes) Transform() Storage {
 	return s.Get("transform")
 }
 
+// Result return storage of given name in which processed objects are stored, transform storage is used when name is empty
+func (s *StorageTypes) Result(name string) Storage {
+	if name == "" {
+		return s.Transform()
+	}
+
+	return s.Get(name)
+}
+
 func (s *StorageTypes) Noop() Storage {
 	return Storage{Kind: "noop"}
 }
@@ -107,11 +279,114 @@ type S3Key struct {
 
 // Bucket describe single bucket entry in config
 type Bucket struct {
-	Transform *Transform        `yaml:"transform,omitempty"`
-	Storages  StorageTypes      `yaml:"storages"`
-	Keys      []S3Key           `yaml:"keys"`
-	Headers   map[string]string `yaml:"headers"`
-	Name      string
+	Transform         *Transform        `yaml:"transform,omitempty"`
+	Storages          StorageTypes      `yaml:"storages"`
+	Keys              []S3Key           `yaml:"keys"`
+	Headers           map[string]string `yaml:"headers"`
+	ExposeGPS         bool              `yaml:"exposeGPS"`         // include GPS location in metadata responses
+	PHash             bool              `yaml:"phash"`             // compute perceptual hash of uploaded images
+	Upload            *UploadPolicy     `yaml:"upload"`            // validation of uploaded objects
+	NegativeCacheTTL  int               `yaml:"negativeCacheTTL"`  // time in seconds for which 404 and 403 responses are cached, 0 disables
+	Script            string            `yaml:"script"`            // text/template script run for each request of bucket, it can rewrite key, set headers or reject request
+	ErrorFormat       string            `yaml:"errorFormat"`       // format of body of error responses, json or empty (default) for body only in debug mode
+	Methods           []string          `yaml:"methods"`           // HTTP methods allowed in bucket, all supported when empty
+	PropagateMetadata bool              `yaml:"propagateMetadata"` // copy user metadata (x-amz-meta-*) of original to transformed images
+	SoftDelete        *SoftDeleteCfg    `yaml:"softDelete"`        // move deleted objects to trash from which they can be restored
+	Access            *AccessCfg        `yaml:"access"`            // client address restrictions and hotlink protection
+	Bandwidth         *BandwidthCfg     `yaml:"bandwidth"`         // limit of bandwidth used for sending responses of bucket
+	Precompressed     []string          `yaml:"precompressed"`     // encodings (br, zstd, gzip) of sidecar objects served instead of originals, in order of preference
+	Index             *IndexCfg         `yaml:"index"`             // index documents and autoindex of directories
+	ErrorDocuments    map[int]string    `yaml:"errorDocuments"`    // keys of objects of bucket served as body of error responses with given status code
+	Website           *WebsiteCfg       `yaml:"website"`           // static website hosting with index and error documents and routing rules
+	Rewrites          []RewriteRule     `yaml:"rewrites"`          // rules rewriting or redirecting GET and HEAD requests before they are parsed
+	TransformVersion  int               `yaml:"transformVersion"`  // version of transforms, bumping it changes keys of transformed images, so they are generated again
+	Name              string
+}
+
+// AccessCfg restrict clients which can access bucket
+type AccessCfg struct {
+	Allow   []string              `yaml:"allow"`   // CIDRs or addresses of clients allowed to access bucket, all when empty
+	Deny    []string              `yaml:"deny"`    // CIDRs or addresses of clients denied access, checked before allow
+	Hotlink *HotlinkCfg           `yaml:"hotlink"` // hotlink protection of transformed images
+	Presets map[string]HotlinkCfg `yaml:"presets"` // hotlink protection per preset, overrides hotlink of bucket
+	allow   []*net.IPNet
+	deny    []*net.IPNet
+}
+
+// IndexCfg configure responses for directories (keys ending with /) like web server
+type IndexCfg struct {
+	Documents    []string `yaml:"documents"`    // names of objects served for directory, e.g. index.html, first existing is used
+	Autoindex    string   `yaml:"autoindex"`    // format of listing of directory without index document - html or json, empty disables
+	MaxKeys      int      `yaml:"maxKeys"`      // max number of objects in listing (default 1000)
+	RedirectCode int      `yaml:"redirectCode"` // status code of redirect of directory without trailing / (default 301)
+}
+
+// RewriteRule rewrite or redirect requests which keys match regexp
+// Rewrite and redirect are expanded like regexp.Expand, so they can use groups of match ($1, ${name})
+type RewriteRule struct {
+	Match    string `yaml:"match"`    // regexp matched against key of request (with leading /)
+	Rewrite  string `yaml:"rewrite"`  // new key of request, it is served as if client requested it
+	Bucket   string `yaml:"bucket"`   // bucket serving rewritten key, bucket of request when empty
+	Redirect string `yaml:"redirect"` // location to which client is redirected
+	Status   int    `yaml:"status"`   // status code of redirect (default 301)
+}
+
+// WebsiteCfg configure bucket as static website, like S3 website hosting
+type WebsiteCfg struct {
+	IndexDocument string        `yaml:"indexDocument"` // name of object served for directories, e.g. index.html
+	ErrorDocument string        `yaml:"errorDocument"` // key of object served as body of 4xx responses
+	RoutingRules  []RoutingRule `yaml:"routingRules"`  // redirects of requests, first matching rule is used
+	index         *IndexCfg
+}
+
+// RoutingRule redirect requests matching condition, like routing rule of S3 website hosting
+type RoutingRule struct {
+	Condition RoutingCondition `yaml:"condition"`
+	Redirect  RoutingRedirect  `yaml:"redirect"`
+}
+
+// RoutingCondition describe requests redirected by routing rule, all set fields have to match
+type RoutingCondition struct {
+	KeyPrefixEquals             string `yaml:"keyPrefixEquals"`             // prefix of key without leading /
+	HTTPErrorCodeReturnedEquals int    `yaml:"httpErrorCodeReturnedEquals"` // status code of error response, rule is then checked after request is processed
+}
+
+// RoutingRedirect describe location to which requests are redirected
+type RoutingRedirect struct {
+	Protocol             string `yaml:"protocol"`             // http or https, protocol of request when empty
+	HostName             string `yaml:"hostName"`             // host of location, host of request when empty
+	ReplaceKeyPrefixWith string `yaml:"replaceKeyPrefixWith"` // replacement of keyPrefixEquals in key
+	ReplaceKeyWith       string `yaml:"replaceKeyWith"`       // replacement of whole key
+	HTTPRedirectCode     int    `yaml:"httpRedirectCode"`     // status code of redirect (default 301)
+}
+
+// BandwidthCfg configure token-bucket limit of bytes sent to clients
+type BandwidthCfg struct {
+	Rate  int64 `yaml:"rate"`  // max number of bytes sent per second, 0 - unlimited
+	Burst int64 `yaml:"burst"` // max number of bytes sent at once after idle period (default rate)
+}
+
+// HotlinkCfg restrict sites which can embed transformed images
+type HotlinkCfg struct {
+	Referers   []string `yaml:"referers"`   // hosts allowed in Referer or Origin, *.example.com matches subdomains, empty - protection disabled
+	AllowEmpty bool     `yaml:"allowEmpty"` // allow requests without Referer and Origin, e.g. opened directly in browser
+	Preset     string   `yaml:"preset"`     // preset served to hotlinking requests instead of 403, e.g. with watermark
+}
+
+// UploadPolicy restrict objects which can be uploaded to bucket
+type UploadPolicy struct {
+	AllowedContentTypes []string `yaml:"allowedContentTypes"` // list of allowed content types, wildcards like image/* are supported
+	MaxSize             int64    `yaml:"maxSize"`             // max size of upload in bytes
+	MaxWidth            int      `yaml:"maxWidth"`            // max width of uploaded image
+	MaxHeight           int      `yaml:"maxHeight"`           // max height of uploaded image
+	MaxMegapixels       float64  `yaml:"maxMegapixels"`       // max number of pixels of uploaded image in millions
+}
+
+// SoftDeleteCfg configure soft delete of bucket, DELETE moves objects to trash prefix instead of removing them
+type SoftDeleteCfg struct {
+	Prefix      string `yaml:"prefix"`      // prefix of keys of objects in trash (default /.trash)
+	TTL         int    `yaml:"ttl"`         // time in seconds after which objects are removed from trash (default 604800)
+	Derivatives bool   `yaml:"derivatives"` // move also transformed images of object to trash, requires resultKey hashParent
 }
 
 // HeaderYaml allow you to override response headers
@@ -128,22 +403,262 @@ type CacheCfg struct {
 	MaxCacheItemSize int64             `yaml:"maxCacheItemSizeMB"`
 	CacheSize        int64             `yaml:"cacheSize"`
 	ClientConfig     map[string]string `yaml:"clientConfig"`
+	Vary             []string          `yaml:"vary"` // request headers (e.g. Accept, DPR, Save-Data) which values are part of cache key, they are added to Vary of responses
+}
+
+// FeatureFlagsCfg configure trusted header carrying per-request feature flags
+type FeatureFlagsCfg struct {
+	Header  string   `yaml:"header"`  // name of header with flags (default X-Mort-Flags)
+	Secret  string   `yaml:"secret"`  // HMAC secret used for signing header, flags are disabled when empty
+	Allowed []string `yaml:"allowed"` // list of flags that can be enabled (default all built in flags)
+	MaxAge  int      `yaml:"maxAge"`  // max lifetime of signed header in seconds
+}
+
+// DriftCfg configure alerts about changes of transforms results between deploys
+type DriftCfg struct {
+	BaselineFile  string  `yaml:"baselineFile"`  // file in which averages are stored, detection is disabled when empty
+	Threshold     float64 `yaml:"threshold"`     // allowed difference in percent (default 20)
+	MinSamples    int     `yaml:"minSamples"`    // number of transforms of preset required before comparing (default 100)
+	ResetBaseline bool    `yaml:"resetBaseline"` // replace existing baseline with averages of this run
+}
+
+// AntivirusCfg configure scanning of uploads with clamd
+type AntivirusCfg struct {
+	Address            string   `yaml:"address"`            // clamd address tcp://host:port or unix:/path, scanning is disabled when empty
+	Timeout            int      `yaml:"timeout"`            // scan timeout in seconds (default 30)
+	MaxSize            int64    `yaml:"maxSize"`            // uploads bigger than this (in bytes) are not scanned (default 25 MB)
+	BypassContentTypes []string `yaml:"bypassContentTypes"` // content types which are not scanned
+	FailOpen           bool     `yaml:"failOpen"`           // accept uploads when clamd is unavailable instead of returning 503
+}
+
+// ImageLimitsCfg hard limits of source images checked before they are decoded by engine
+type ImageLimitsCfg struct {
+	MaxWidth      int     `yaml:"maxWidth"`      // max width of source image, 0 means no limit
+	MaxHeight     int     `yaml:"maxHeight"`     // max height of source image, 0 means no limit
+	MaxMegapixels float64 `yaml:"maxMegapixels"` // max number of pixels in millions (default 100), negative disables limit
+	MaxMemoryMB   int64   `yaml:"maxMemoryMB"`   // max memory of decoded image in MB (default 1024), negative disables limit
+	MaxFrames     int     `yaml:"maxFrames"`     // max number of frames of animated gif or webp, 0 means no limit
+	MaxPages      int     `yaml:"maxPages"`      // max number of pages of pdf document, 0 means no limit
+
+	ContentTypes map[string]ImageLimitsCfg `yaml:"contentTypes"` // limits overriding above for content type (e.g. image/gif or image/*)
+}
+
+// ForContentType returns limits for source of given content type
+// Limits of exact content type are used before wildcard ones (image/*), limits not set there are inherited
+func (l ImageLimitsCfg) ForContentType(contentType string) ImageLimitsCfg {
+	if i := strings.Index(contentType, ";"); i != -1 {
+		contentType = contentType[:i]
+	}
+	contentType = strings.ToLower(strings.TrimSpace(contentType))
+
+	override, ok := l.ContentTypes[contentType]
+	if !ok {
+		if i := strings.Index(contentType, "/"); i != -1 {
+			override, ok = l.ContentTypes[contentType[:i]+"/*"]
+		}
+	}
+
+	limits := l
+	limits.ContentTypes = nil
+	if !ok {
+		return limits
+	}
+
+	if override.MaxWidth != 0 {
+		limits.MaxWidth = override.MaxWidth
+	}
+	if override.MaxHeight != 0 {
+		limits.MaxHeight = override.MaxHeight
+	}
+	if override.MaxMegapixels != 0 {
+		limits.MaxMegapixels = override.MaxMegapixels
+	}
+	if override.MaxMemoryMB != 0 {
+		limits.MaxMemoryMB = override.MaxMemoryMB
+	}
+	if override.MaxFrames != 0 {
+		limits.MaxFrames = override.MaxFrames
+	}
+	if override.MaxPages != 0 {
+		limits.MaxPages = override.MaxPages
+	}
+
+	return limits
+}
+
+// ThrottlerCfg configure admission control of concurrent transforms
+type ThrottlerCfg struct {
+	MemoryBudgetMB int64 `yaml:"memoryBudgetMB"` // memory available for concurrent transforms in MB (default 2048)
+	Backlog        int   `yaml:"backlog"`        // max number of transforms waiting for memory (default 100)
+	Timeout        int   `yaml:"timeout"`        // max time in seconds transform waits for memory (default 60)
+	RetryAfter     int   `yaml:"retryAfter"`     // base value of Retry-After header of throttled responses in seconds (default 5)
+	StatusCode     int   `yaml:"statusCode"`     // status code of throttled responses, 429 or 503 (default 503)
+}
+
+// PresetsAPICfg configure API for changing presets at runtime
+type PresetsAPICfg struct {
+	Token   string `yaml:"token"`   // bearer token required by API, API is disabled when empty, secret references are allowed
+	Bucket  string `yaml:"bucket"`  // bucket in which presets are persisted, it is shared by all instances
+	Key     string `yaml:"key"`     // key of object with presets (default /presets.yml)
+	Refresh int    `yaml:"refresh"` // interval in seconds of loading presets changed by other instances (default 30)
+}
+
+// TrashCfg configure API for restoring objects from trash of buckets with soft delete
+type TrashCfg struct {
+	Token    string `yaml:"token"`    // bearer token required by API, API is disabled when empty, secret references are allowed
+	Interval int    `yaml:"interval"` // time in seconds between removals of expired objects from trash (default 3600)
+}
+
+// BillingCfg configure emitting of cost records of transforms
+type BillingCfg struct {
+	Output string `yaml:"output"` // destination of records in form scheme:target (e.g. file:/var/log/mort/billing.jsonl), disabled when empty
+}
+
+// TimeoutsCfg configure timeouts of stages of request, whole request is limited by requestTimeout
+type TimeoutsCfg struct {
+	Storage   int `yaml:"storage"`   // max time in seconds of single storage operation, 0 - limited only by requestTimeout
+	Transform int `yaml:"transform"` // max time in seconds of processing image by engine, 0 - limited only by requestTimeout
+}
+
+// ClientCloseCfg configure handling of requests which client closed before response was ready
+type ClientCloseCfg struct {
+	StatusCode int  `yaml:"statusCode"` // status code of response (default 499)
+	Continue   bool `yaml:"continue"`   // continue processing in background, so result is stored and cached for next requests
+}
+
+// CollapseCfg configure collapsing of concurrent requests
+type CollapseCfg struct {
+	Originals bool  `yaml:"originals"` // collapse GET requests for original objects, transformed objects are always collapsed
+	MinSize   int64 `yaml:"minSize"`   // only originals bigger than this (in bytes) are collapsed, 0 collapse all
+}
+
+// WorkersCfg configure running of transforms in separate worker processes, transforms run in mort process when count is 0
+type WorkersCfg struct {
+	Count     int    `yaml:"count"`     // number of worker processes
+	MaxJobs   int    `yaml:"maxJobs"`   // worker is replaced by new process after this number of transforms, 0 means never
+	SocketDir string `yaml:"socketDir"` // directory of unix sockets of workers (default system temporary directory)
+	Timeout   int    `yaml:"timeout"`   // max time in seconds of single transform, worker is killed after it (default 60)
+}
+
+// QuarantineCfg configure quarantine of source images which repeatedly crash or time out engine
+type QuarantineCfg struct {
+	Threshold int `yaml:"threshold"` // number of failures after which image is quarantined (default 3), negative disables quarantine
+	TTL       int `yaml:"ttl"`       // time in seconds for which failures are counted and image stays quarantined (default 3600)
+}
+
+// AdminCfg configure admin endpoints of internal listener
+type AdminCfg struct {
+	Token     string `yaml:"token"`     // bearer token required by admin endpoints, secret references are allowed
+	Profiling bool   `yaml:"profiling"` // expose /debug/pprof, expvar (/debug/vars) and Go runtime stats (/debug/runtime)
+}
+
+// SlowLogCfg configure logging of stages of slow requests
+type SlowLogCfg struct {
+	Threshold int `yaml:"threshold"` // duration of request in milliseconds above which it is logged, 0 - disabled
+}
+
+// MetricsPushCfg configure sending metrics in Influx or OpenTSDB line protocol, used when monitoring is influx or opentsdb
+type MetricsPushCfg struct {
+	Address       string            `yaml:"address"`       // udp://host:port, tcp://host:port or http(s) URL (e.g. http://influx:8086/write?db=mort)
+	FlushInterval int               `yaml:"flushInterval"` // time in seconds between sending batches of metrics (default 10)
+	Prefix        string            `yaml:"prefix"`        // prefix of names of metrics (default mort_)
+	Tags          map[string]string `yaml:"tags"`          // tags added to all metrics, host tag is added by default
+}
+
+// HostCfg map requests of host to bucket (virtual-host style)
+type HostCfg struct {
+	Host     string `yaml:"host"`     // host name, *.example.com matches any subdomain
+	Bucket   string `yaml:"bucket"`   // bucket serving host, empty for wildcard host means that subdomain is name of bucket
+	CertFile string `yaml:"certFile"` // certificate of host used by TLS listeners
+	KeyFile  string `yaml:"keyFile"`  // private key of certificate
+}
+
+// TLSCfg configure certificates of HTTPS listeners, certificates of hosts are preferred over them
+type TLSCfg struct {
+	CertFile string   `yaml:"certFile"` // certificate used for hosts without own certificate
+	KeyFile  string   `yaml:"keyFile"`  // private key of certificate
+	ACME     *ACMECfg `yaml:"acme"`     // automatic issuance and renewal of certificates
+}
+
+// ACMECfg configure issuance of certificates by ACME (e.g. Let's Encrypt) with HTTP-01 and TLS-ALPN-01 challenges
+type ACMECfg struct {
+	Email     string   `yaml:"email"`     // contact of account, used for notifications about expiring certificates
+	CacheDir  string   `yaml:"cacheDir"`  // directory in which account key and certificates are stored (default /var/lib/mort/acme)
+	Directory string   `yaml:"directory"` // URL of ACME directory (default Let's Encrypt production)
+	Hosts     []string `yaml:"hosts"`     // host names of certificates, hosts of server without wildcard and certificate are added to them
+}
+
+// ProxyCfg configure handling of requests which come through load balancers and reverse proxies
+type ProxyCfg struct {
+	Protocol       []string `yaml:"protocol"`       // addresses of listeners which expect PROXY protocol header (v1 or v2)
+	HeaderTimeout  int      `yaml:"headerTimeout"`  // time in seconds for receiving PROXY protocol header (default 5)
+	TrustedProxies []string `yaml:"trustedProxies"` // addresses or networks of proxies allowed to send PROXY protocol header and X-Forwarded-* headers
+	trusted        []*net.IPNet
+}
+
+// UnixSocketCfg configure permissions of unix sockets created for listeners
+type UnixSocketCfg struct {
+	Mode  string `yaml:"mode"`  // octal permissions of socket file, e.g. "0660"
+	Group string `yaml:"group"` // name or id of group owning socket file
+	mode  os.FileMode
+}
+
+// ListenerCfg configure listener serving chosen handler sets with own TLS and auth settings
+type ListenerCfg struct {
+	Address  string          `yaml:"address"`  // host:port, unix:/path/to/socket or systemd:name
+	Handlers []string        `yaml:"handlers"` // public (serving of objects), s3 (S3 API), admin (reports, presets and trash API, debug), metrics
+	TLS      *TLSCfg         `yaml:"tls"`      // terminate TLS, certificates of server are used when certFile is empty
+	Auth     ListenerAuthCfg `yaml:"auth"`
+}
+
+// ListenerAuthCfg configure clients allowed to use listener
+type ListenerAuthCfg struct {
+	Token string   `yaml:"token"` // bearer token required by all requests, secret references are allowed
+	Allow []string `yaml:"allow"` // addresses or networks of allowed clients, empty - all clients
+	allow []*net.IPNet
 }
 
 // Server configure HTTP server
 type Server struct {
-	LogLevel       string                 `yaml:"logLevel"`
-	InternalListen string                 `yaml:"internalListen"`
-	SingleListen   string                 `yaml:"listen"`
-	RequestTimeout int                    `yaml:"requestTimeout"`
-	LockTimeout    int                    `yaml:"lockTimeout"`
+	LogLevel       string `yaml:"logLevel"`
+	InternalListen string `yaml:"internalListen"`
+	SingleListen   string `yaml:"listen"`
+	RequestTimeout int    `yaml:"requestTimeout"`
+	LockTimeout    int    `yaml:"lockTimeout"`
 	// Unused, intention unknown
 	QueueLen       int                    `yaml:"queueLen"`
+	DrainTimeout   int                    `yaml:"drainTimeout"` // time in seconds for draining connections during restart
 	Listen         []string               `yaml:"listens"`
 	Monitoring     string                 `yaml:"monitoring"`
+	MetricsPush    MetricsPushCfg         `yaml:"metricsPush"`
 	PlaceholderStr string                 `yaml:"placeholder"`
 	Plugins        map[string]interface{} `yaml:"plugins,omitempty"`
 	Cache          CacheCfg               `yaml:"cache"`
+	FeatureFlags   FeatureFlagsCfg        `yaml:"featureFlags"`
+	Drift          DriftCfg               `yaml:"drift"`
+	PHashIndexFile string                 `yaml:"phashIndexFile"` // file in which index of perceptual hashes is saved, when empty index is kept only in memory
+	Antivirus      AntivirusCfg           `yaml:"antivirus"`
+	ImageLimits    ImageLimitsCfg         `yaml:"imageLimits"`
+	Throttler      ThrottlerCfg           `yaml:"throttler"`
+	Collapse       CollapseCfg            `yaml:"collapse"`
+	SecretsRefresh int                    `yaml:"secretsRefresh"` // interval in seconds of resolving secret references again, 0 - only at start
+	PresetsAPI     PresetsAPICfg          `yaml:"presetsAPI"`
+	Billing        BillingCfg             `yaml:"billing"`
+	Timeouts       TimeoutsCfg            `yaml:"timeouts"`
+	ClientClose    ClientCloseCfg         `yaml:"clientClose"`
+	Trash          TrashCfg               `yaml:"trash"`
+	Workers        WorkersCfg             `yaml:"workers"`
+	Quarantine     QuarantineCfg          `yaml:"quarantine"`
+	Admin          AdminCfg               `yaml:"admin"`
+	ProfileDir     string                 `yaml:"profileDir"` // directory in which profiles of transforms are stored, when empty they are returned in response
+	SlowLog        SlowLogCfg             `yaml:"slowLog"`
+	Bandwidth      BandwidthCfg           `yaml:"bandwidth"`  // global limit of bandwidth used for sending responses
+	Hosts          []HostCfg              `yaml:"hosts"`      // buckets served in virtual-host style by host of request
+	TLSListen      []string               `yaml:"tlsListens"` // addresses of HTTPS listeners, they use certificates of hosts
+	TLS            TLSCfg                 `yaml:"tls"`        // default certificate and ACME issuance for HTTPS listeners
+	Proxy          ProxyCfg               `yaml:"proxy"`      // PROXY protocol and trusted proxies
+	UnixSocket     UnixSocketCfg          `yaml:"unixSocket"` // permissions of unix sockets of listeners
+	Listeners      []ListenerCfg          `yaml:"listeners"`  // listeners with chosen handler sets, they replace default listen and internalListen
 	Placeholder    struct {
 		Buf         []byte
 		ContentType string
@@ -0,0 +1,71 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// parse validate website configuration and create index configuration of website
+func (w *WebsiteCfg) parse() error {
+	if w.IndexDocument == "" {
+		return errors.New("indexDocument is required")
+	}
+
+	if w.ErrorDocument != "" {
+		w.ErrorDocument = "/" + strings.TrimPrefix(w.ErrorDocument, "/")
+	}
+
+	for i := range w.RoutingRules {
+		rule := &w.RoutingRules[i]
+		if code := rule.Condition.HTTPErrorCodeReturnedEquals; code != 0 && (code < 400 || code > 599) {
+			return fmt.Errorf("routing rule %d has invalid httpErrorCodeReturnedEquals %d", i, code)
+		}
+
+		redirect := &rule.Redirect
+		if redirect.ReplaceKeyWith != "" && redirect.ReplaceKeyPrefixWith != "" {
+			return fmt.Errorf("routing rule %d can't have both replaceKeyWith and replaceKeyPrefixWith", i)
+		}
+
+		if redirect.Protocol != "" && redirect.Protocol != "http" && redirect.Protocol != "https" {
+			return fmt.Errorf("routing rule %d has invalid protocol %s, allowed http, https", i, redirect.Protocol)
+		}
+
+		if redirect.HTTPRedirectCode == 0 {
+			redirect.HTTPRedirectCode = 301
+		} else if redirect.HTTPRedirectCode < 300 || redirect.HTTPRedirectCode > 399 {
+			return fmt.Errorf("routing rule %d has invalid httpRedirectCode %d", i, redirect.HTTPRedirectCode)
+		}
+	}
+
+	// S3 redirects directories without trailing / with 302
+	w.index = &IndexCfg{Documents: []string{w.IndexDocument}, RedirectCode: 302}
+	return nil
+}
+
+// Index returns configuration of index documents of website
+func (w *WebsiteCfg) Index() *IndexCfg {
+	return w.index
+}
+
+// Matches check if rule applies to key (without leading /) and status code of response, statusCode is 0 before request is processed
+func (r RoutingRule) Matches(key string, statusCode int) bool {
+	if r.Condition.HTTPErrorCodeReturnedEquals != statusCode {
+		return false
+	}
+
+	return strings.HasPrefix(key, r.Condition.KeyPrefixEquals)
+}
+
+// RedirectKey returns key (without leading /) to which key is redirected
+func (r RoutingRule) RedirectKey(key string) string {
+	if r.Redirect.ReplaceKeyWith != "" {
+		return r.Redirect.ReplaceKeyWith
+	}
+
+	if r.Redirect.ReplaceKeyPrefixWith != "" {
+		return r.Redirect.ReplaceKeyPrefixWith + strings.TrimPrefix(key, r.Condition.KeyPrefixEquals)
+	}
+
+	return key
+}
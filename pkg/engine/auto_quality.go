@@ -0,0 +1,156 @@
+//go:build cgo
+// +build cgo
+
+package engine
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+
+	"gopkg.in/h2non/bimg.v1"
+)
+
+const (
+	autoQualityMin = 30 // lowest quality which can be selected
+	autoQualityMax = 90 // highest quality which can be selected
+	ssimWindow     = 8  // size of window used for computing SSIM
+)
+
+// autoQualityTypes formats for which quality can be tuned
+var autoQualityTypes = map[bimg.ImageType]bool{
+	bimg.JPEG: true,
+	bimg.WEBP: true,
+	bimg.HEIF: true,
+	bimg.AVIF: true,
+}
+
+// autoQuality re-encode reference image with the lowest quality for which SSIM score is at least target
+// It returns reference image when none of qualities meets target or format is lossless
+func autoQuality(ref []byte, target float64) ([]byte, int, error) {
+	imageType := bimg.DetermineImageType(ref)
+	if !autoQualityTypes[imageType] {
+		return ref, 0, nil
+	}
+
+	refLuma, err := decodeLuma(ref)
+	if err != nil {
+		return ref, 0, err
+	}
+
+	best := ref
+	bestQuality := 0
+	low, high := autoQualityMin, autoQualityMax
+	for low <= high {
+		q := (low + high) / 2
+		candidate, err := bimg.NewImage(ref).Process(bimg.Options{Quality: q, Type: imageType, NoAutoRotate: true})
+		if err != nil {
+			return ref, 0, err
+		}
+
+		luma, err := decodeLuma(candidate)
+		if err != nil {
+			return ref, 0, err
+		}
+
+		score, err := ssim(refLuma, luma)
+		if err != nil {
+			return ref, 0, err
+		}
+
+		if score >= target {
+			best, bestQuality = candidate, q
+			high = q - 1
+		} else {
+			low = q + 1
+		}
+	}
+
+	if len(best) >= len(ref) {
+		return ref, 0, nil
+	}
+
+	return best, bestQuality, nil
+}
+
+// decodeLuma decode image and returns its luma channel, formats unsupported by Go are converted to png by libvips
+func decodeLuma(buf []byte) (*image.Gray, error) {
+	switch bimg.DetermineImageType(buf) {
+	case bimg.JPEG, bimg.PNG, bimg.GIF:
+	default:
+		converted, err := bimg.NewImage(buf).Convert(bimg.PNG)
+		if err != nil {
+			return nil, err
+		}
+		buf = converted
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(buf))
+	if err != nil {
+		return nil, err
+	}
+
+	if ycc, ok := img.(*image.YCbCr); ok {
+		b := ycc.Bounds()
+		gray := image.NewGray(image.Rect(0, 0, b.Dx(), b.Dy()))
+		for y := 0; y < b.Dy(); y++ {
+			copy(gray.Pix[y*gray.Stride:y*gray.Stride+b.Dx()], ycc.Y[ycc.YOffset(b.Min.X, b.Min.Y+y):])
+		}
+		return gray, nil
+	}
+
+	b := img.Bounds()
+	gray := image.NewGray(image.Rect(0, 0, b.Dx(), b.Dy()))
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			gray.SetGray(x, y, color.GrayModel.Convert(img.At(b.Min.X+x, b.Min.Y+y)).(color.Gray))
+		}
+	}
+
+	return gray, nil
+}
+
+// ssim computes mean structural similarity of two grayscale images using non overlapping windows
+func ssim(a, b *image.Gray) (float64, error) {
+	if a.Bounds().Dx() != b.Bounds().Dx() || a.Bounds().Dy() != b.Bounds().Dy() {
+		return 0, errors.New("ssim: images have different size")
+	}
+
+	const c1 = (0.01 * 255) * (0.01 * 255)
+	const c2 = (0.03 * 255) * (0.03 * 255)
+	width, height := a.Bounds().Dx(), a.Bounds().Dy()
+	total := 0.0
+	windows := 0
+	for wy := 0; wy < height; wy += ssimWindow {
+		for wx := 0; wx < width; wx += ssimWindow {
+			var sumA, sumB, sumAA, sumBB, sumAB float64
+			n := 0.0
+			for y := wy; y < wy+ssimWindow && y < height; y++ {
+				for x := wx; x < wx+ssimWindow && x < width; x++ {
+					va := float64(a.Pix[y*a.Stride+x])
+					vb := float64(b.Pix[y*b.Stride+x])
+					sumA += va
+					sumB += vb
+					sumAA += va * va
+					sumBB += vb * vb
+					sumAB += va * vb
+					n++
+				}
+			}
+
+			meanA, meanB := sumA/n, sumB/n
+			varA := sumAA/n - meanA*meanA
+			varB := sumBB/n - meanB*meanB
+			cov := sumAB/n - meanA*meanB
+			total += ((2*meanA*meanB + c1) * (2*cov + c2)) / ((meanA*meanA + meanB*meanB + c1) * (varA + varB + c2))
+			windows++
+		}
+	}
+
+	if windows == 0 {
+		return 0, errors.New("ssim: empty image")
+	}
+
+	return total / float64(windows), nil
+}
@@ -0,0 +1,43 @@
+//go:build cgo
+// +build cgo
+
+package engine
+
+import (
+	"image"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func grayImage(width, height int, fn func(x, y int) uint8) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Pix[y*img.Stride+x] = fn(x, y)
+		}
+	}
+
+	return img
+}
+
+func TestSSIM(t *testing.T) {
+	a := grayImage(20, 20, func(x, y int) uint8 { return uint8(x * 10) })
+	b := grayImage(20, 20, func(x, y int) uint8 { return uint8(x*10) + uint8(y%2) })
+	c := grayImage(20, 20, func(x, y int) uint8 { return uint8(255 - y*10) })
+
+	same, err := ssim(a, a)
+	assert.Nil(t, err)
+	assert.InDelta(t, 1.0, same, 0.0001)
+
+	similar, _ := ssim(a, b)
+	different, _ := ssim(a, c)
+	assert.True(t, similar > 0.95)
+	assert.True(t, different < similar)
+}
+
+func TestSSIM_DifferentSize(t *testing.T) {
+	_, err := ssim(image.NewGray(image.Rect(0, 0, 10, 10)), image.NewGray(image.Rect(0, 0, 10, 11)))
+
+	assert.NotNil(t, err)
+}
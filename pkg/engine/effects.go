@@ -0,0 +1,109 @@
+package engine
+
+import (
+	"image"
+	"image/draw"
+	"math"
+
+	"github.com/aldor007/mort/pkg/transforms"
+)
+
+// applyEffects perform color effects and composite of transform on image, effects which aren't set are skipped
+func applyEffects(img image.Image, p transforms.Params) image.Image {
+	if p.Duotone == nil && p.Overlay == nil && p.Composite == nil {
+		return img
+	}
+
+	b := img.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Src)
+
+	for i := 0; i < len(dst.Pix); i += 4 {
+		px := dst.Pix[i : i+3 : i+3]
+		if d := p.Duotone; d != nil {
+			// luminance according to ITU-R BT.601
+			l := (0.299*float64(px[0]) + 0.587*float64(px[1]) + 0.114*float64(px[2])) / 255
+			px[0] = mix(d.Shadow.R, d.Highlight.R, l)
+			px[1] = mix(d.Shadow.G, d.Highlight.G, l)
+			px[2] = mix(d.Shadow.B, d.Highlight.B, l)
+		}
+
+		if o := p.Overlay; o != nil {
+			opacity := o.Opacity * float64(o.Color.A) / 255
+			top := [3]uint8{o.Color.R, o.Color.G, o.Color.B}
+			for c := 0; c < 3; c++ {
+				base := float64(px[c]) / 255
+				v := base + (blend(o.Mode, base, float64(top[c])/255)-base)*opacity
+				px[c] = uint8(math.Round(math.Max(0, math.Min(1, v)) * 255))
+			}
+		}
+	}
+
+	if c := p.Composite; c != nil && c.Image != nil {
+		composite(dst, *c)
+	}
+
+	return dst
+}
+
+// composite place image over dst, colors are blended according to mode and alpha is composed using source-over
+func composite(dst *image.NRGBA, c transforms.CompositeParams) {
+	placed := c.Image
+	if b := placed.Bounds(); c.Width != 0 && b.Dx() != 0 {
+		placed = imagingResize(placed, c.Width, b.Dy()*c.Width/b.Dx())
+	}
+
+	pb := placed.Bounds()
+	src := image.NewNRGBA(image.Rect(0, 0, pb.Dx(), pb.Dy()))
+	draw.Draw(src, src.Bounds(), placed, pb.Min, draw.Src)
+
+	left, top := c.Offset(dst.Bounds().Dx(), dst.Bounds().Dy(), pb.Dx(), pb.Dy())
+	area := image.Rect(left, top, left+pb.Dx(), top+pb.Dy()).Intersect(dst.Bounds())
+	for y := area.Min.Y; y < area.Max.Y; y++ {
+		for x := area.Min.X; x < area.Max.X; x++ {
+			d, s := dst.PixOffset(x, y), src.PixOffset(x-left, y-top)
+			as := float64(src.Pix[s+3]) / 255 * c.Opacity
+			if as == 0 {
+				continue
+			}
+
+			ab := float64(dst.Pix[d+3]) / 255
+			ao := as + ab*(1-as)
+			for ch := 0; ch < 3; ch++ {
+				cs, cb := float64(src.Pix[s+ch])/255, float64(dst.Pix[d+ch])/255
+				// blend mode is used only where image below is opaque
+				mixed := (1-ab)*cs + ab*blend(c.Mode, cb, cs)
+				dst.Pix[d+ch] = uint8(math.Round((as*mixed + (1-as)*ab*cb) / ao * 255))
+			}
+			dst.Pix[d+3] = uint8(math.Round(ao * 255))
+		}
+	}
+}
+
+// mix returns value between a and b, t is in range [0, 1]
+func mix(a, b uint8, t float64) uint8 {
+	return uint8(math.Round(float64(a)*(1-t) + float64(b)*t))
+}
+
+// blend returns result of blending top with base according to mode, values are in range [0, 1]
+func blend(mode string, base, top float64) float64 {
+	switch mode {
+	case transforms.BlendMultiply:
+		return base * top
+	case transforms.BlendScreen:
+		return 1 - (1-base)*(1-top)
+	case transforms.BlendOverlay:
+		if base < 0.5 {
+			return 2 * base * top
+		}
+		return 1 - 2*(1-base)*(1-top)
+	case transforms.BlendSoftLight:
+		return (1-2*top)*base*base + 2*top*base
+	case transforms.BlendDarken:
+		return math.Min(base, top)
+	case transforms.BlendLighten:
+		return math.Max(base, top)
+	default:
+		return top
+	}
+}
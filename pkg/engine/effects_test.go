@@ -0,0 +1,78 @@
+package engine
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/aldor007/mort/pkg/transforms"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyEffectsDuotone(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 2, 1))
+	img.SetGray(1, 0, color.Gray{Y: 255})
+
+	var trans transforms.Transforms
+	assert.Nil(t, trans.Duotone("#102030", "#f0e0d0"))
+
+	res := applyEffects(img, trans.Params())
+	assert.Equal(t, color.NRGBA{R: 0x10, G: 0x20, B: 0x30, A: 0xff}, res.At(0, 0))
+	assert.Equal(t, color.NRGBA{R: 0xf0, G: 0xe0, B: 0xd0, A: 0xff}, res.At(1, 0))
+}
+
+func TestApplyEffectsOverlay(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 1, 1))
+	img.Set(0, 0, color.NRGBA{R: 200, G: 100, B: 0, A: 128})
+
+	var trans transforms.Transforms
+	assert.Nil(t, trans.Overlay("#808080", 0.5, transforms.BlendMultiply))
+
+	res := applyEffects(img, trans.Params())
+	// half of difference between pixel and pixel multiplied by 0.5 gray, alpha is not changed
+	assert.Equal(t, color.NRGBA{R: 150, G: 75, B: 0, A: 128}, res.At(0, 0))
+
+	var none transforms.Transforms
+	assert.Equal(t, image.Image(img), applyEffects(img, none.Params()))
+}
+
+func TestBlend(t *testing.T) {
+	assert.Equal(t, 0.25, blend(transforms.BlendMultiply, 0.5, 0.5))
+	assert.Equal(t, 0.75, blend(transforms.BlendScreen, 0.5, 0.5))
+	assert.Equal(t, 0.25, blend(transforms.BlendOverlay, 0.25, 0.5))
+	assert.Equal(t, 0.2, blend(transforms.BlendDarken, 0.2, 0.7))
+	assert.Equal(t, 0.7, blend(transforms.BlendLighten, 0.2, 0.7))
+	assert.Equal(t, 0.5, blend(transforms.BlendSoftLight, 0.5, 0.5))
+	assert.Equal(t, 0.7, blend(transforms.BlendNormal, 0.2, 0.7))
+}
+
+func TestApplyEffectsComposite(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 10, 10))
+	for i := range img.Pix {
+		img.Pix[i] = 255
+	}
+
+	badge := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	for i := 0; i < len(badge.Pix); i += 4 {
+		copy(badge.Pix[i:i+4], []uint8{255, 0, 0, 255})
+	}
+
+	var trans transforms.Transforms
+	assert.Nil(t, trans.Composite("badge.png", "bottom-right", 2, 1, ""))
+	trans.SetCompositeImage(badge)
+
+	res := applyEffects(img, trans.Params())
+	assert.Equal(t, color.NRGBA{R: 255, A: 255}, res.At(9, 9))
+	assert.Equal(t, color.NRGBA{R: 255, A: 255}, res.At(8, 8))
+	assert.Equal(t, color.NRGBA{R: 255, G: 255, B: 255, A: 255}, res.At(7, 7))
+
+	var half transforms.Transforms
+	half.Composite("badge.png", "1,1", 0, 0.5, transforms.BlendMultiply)
+	half.SetCompositeImage(badge)
+
+	res = applyEffects(img, half.Params())
+	assert.Equal(t, color.NRGBA{R: 255, G: 128, B: 128, A: 255}, res.At(1, 1))
+	assert.Equal(t, color.NRGBA{R: 255, G: 255, B: 255, A: 255}, res.At(0, 0))
+	assert.Equal(t, color.NRGBA{R: 255, G: 128, B: 128, A: 255}, res.At(4, 4))
+	assert.Equal(t, color.NRGBA{R: 255, G: 255, B: 255, A: 255}, res.At(5, 5))
+}
@@ -0,0 +1,99 @@
+//go:build cgo
+// +build cgo
+
+package engine
+
+/*
+#cgo pkg-config: vips
+#include <stdlib.h>
+#include <vips/vips.h>
+
+static int mort_jpeg_save(void *buf, size_t len, int quality, int interlace, int subsample, void **out, size_t *outLen) {
+	VipsImage *image;
+	int err;
+
+	if (!(image = vips_image_new_from_buffer(buf, len, "", NULL))) {
+		return -1;
+	}
+
+#if VIPS_MAJOR_VERSION > 8 || (VIPS_MAJOR_VERSION == 8 && VIPS_MINOR_VERSION >= 11)
+	err = vips_jpegsave_buffer(image, out, outLen, "Q", quality, "interlace", interlace,
+		"subsample_mode", subsample ? VIPS_FOREIGN_SUBSAMPLE_ON : VIPS_FOREIGN_SUBSAMPLE_OFF, NULL);
+#else
+	// older libvips can only disable subsampling
+	err = vips_jpegsave_buffer(image, out, outLen, "Q", quality, "interlace", interlace, "no_subsample", !subsample, NULL);
+#endif
+	g_object_unref(image);
+	return err;
+}
+
+static int mort_webp_save(void *buf, size_t len, int quality, int effort, void **out, size_t *outLen) {
+	VipsImage *image;
+	int err;
+
+	if (!(image = vips_image_new_from_buffer(buf, len, "", NULL))) {
+		return -1;
+	}
+
+#if VIPS_MAJOR_VERSION > 8 || (VIPS_MAJOR_VERSION == 8 && VIPS_MINOR_VERSION >= 12)
+	err = vips_webpsave_buffer(image, out, outLen, "Q", quality, "effort", effort, NULL);
+#else
+	err = vips_webpsave_buffer(image, out, outLen, "Q", quality, "reduction_effort", effort, NULL);
+#endif
+	g_object_unref(image);
+	return err;
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"strings"
+	"unsafe"
+
+	"github.com/aldor007/mort/pkg/transforms"
+	"gopkg.in/h2non/bimg.v1"
+)
+
+// vipsEncode encode lossless image with given options
+// Chroma subsampling of JPEG and effort of WebP aren't supported by bimg, so such images are saved by libvips directly
+func vipsEncode(buf []byte, encode bimg.Options, p transforms.Params) ([]byte, error) {
+	if len(buf) == 0 {
+		return nil, errors.New("empty image")
+	}
+
+	quality := encode.Quality
+	if quality == 0 {
+		quality = bimg.Quality
+	}
+
+	var out unsafe.Pointer
+	var outLen C.size_t
+	var ret C.int
+	switch {
+	case encode.Type == bimg.JPEG && p.Chroma != "":
+		ret = C.mort_jpeg_save(unsafe.Pointer(&buf[0]), C.size_t(len(buf)), C.int(quality), cBool(encode.Interlace),
+			cBool(p.Chroma == transforms.ChromaSubsampling420), &out, &outLen)
+	case encode.Type == bimg.WEBP && p.Effort != 0:
+		ret = C.mort_webp_save(unsafe.Pointer(&buf[0]), C.size_t(len(buf)), C.int(quality), C.int(p.Effort), &out, &outLen)
+	default:
+		return bimg.NewImage(buf).Process(encode)
+	}
+
+	if ret != 0 {
+		msg := strings.TrimSpace(C.GoString(C.vips_error_buffer()))
+		C.vips_error_clear()
+		return nil, errors.New("unable to encode image: " + msg)
+	}
+
+	defer C.g_free(C.gpointer(out))
+	return C.GoBytes(out, C.int(outLen)), nil
+}
+
+func cBool(v bool) C.int {
+	if v {
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,151 @@
+package engine
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aldor007/mort/pkg/object"
+	"github.com/aldor007/mort/pkg/response"
+	"github.com/aldor007/mort/pkg/transforms"
+)
+
+// ErrUnknownEngine returned when requested engine is not registered (or not available on host)
+var ErrUnknownEngine = errors.New("unknown image engine")
+
+// ErrUnsupportedTransform returned when engine is not able to perform requested transform
+var ErrUnsupportedTransform = errors.New("transform not supported by image engine")
+
+// Engine is interface for image processing backends
+type Engine interface {
+	// Process perform transforms on parent and return new image
+	Process(obj *object.FileObject, trans []transforms.Transforms) (*response.Response, error)
+}
+
+// Factory create instance of engine for given source file
+type Factory func(parent *response.Response) Engine
+
+// Capabilities describe what engine is able to do
+type Capabilities struct {
+	Operations []string // names of supported operations (the same as returned by transforms.Operations)
+	Formats    []string // supported output formats
+	// FormatsFunc discovers supported output formats on first use, it is used instead of Formats when discovery is expensive
+	FormatsFunc func() []string
+}
+
+// Supports check if all transforms can be performed by engine
+func (c Capabilities) Supports(trans []transforms.Transforms) error {
+	formats := c.Formats
+	if c.FormatsFunc != nil {
+		formats = c.FormatsFunc()
+	}
+
+	for _, t := range trans {
+		for _, op := range t.Operations() {
+			if !contains(c.Operations, op) {
+				return errors.New(ErrUnsupportedTransform.Error() + ": " + op)
+			}
+		}
+
+		if t.FormatStr != "" && !contains(formats, t.FormatStr) {
+			return errors.New(ErrUnsupportedTransform.Error() + ": format " + t.FormatStr)
+		}
+	}
+
+	return nil
+}
+
+type registeredEngine struct {
+	factory      Factory
+	capabilities Capabilities
+}
+
+var enginesLock sync.RWMutex
+var engines = make(map[string]registeredEngine)
+
+// RegisterEngine add engine to list of available engines
+func RegisterEngine(name string, capabilities Capabilities, factory Factory) {
+	enginesLock.Lock()
+	defer enginesLock.Unlock()
+	engines[name] = registeredEngine{factory: factory, capabilities: capabilities}
+}
+
+// GetCapabilities return capabilities of engine with given name
+func GetCapabilities(name string) (Capabilities, bool) {
+	enginesLock.RLock()
+	defer enginesLock.RUnlock()
+	e, ok := engines[engineName(name)]
+	return e.capabilities, ok
+}
+
+// Check returns error if engine doesn't exist or is not able to perform transforms
+// It allows to fail fast before source image is fetched and decoded
+func Check(name string, trans []transforms.Transforms) error {
+	c, ok := GetCapabilities(name)
+	if !ok {
+		return errors.New(ErrUnknownEngine.Error() + ": " + engineName(name))
+	}
+
+	if err := c.Supports(trans); err != nil {
+		return err
+	}
+
+	for _, t := range trans {
+		if p := t.Params().Pixelate; p != nil && p.Faces && getFaceDetector() == nil {
+			return ErrNoFaceDetector
+		}
+	}
+
+	return nil
+}
+
+// New create instance of engine with given name, empty name means DefaultEngine
+func New(name string, parent *response.Response) (Engine, error) {
+	enginesLock.RLock()
+	e, ok := engines[engineName(name)]
+	enginesLock.RUnlock()
+	if !ok {
+		return nil, errors.New(ErrUnknownEngine.Error() + ": " + engineName(name))
+	}
+
+	return e.factory(parent), nil
+}
+
+func engineName(name string) string {
+	if name == "" {
+		return DefaultEngine
+	}
+
+	return name
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// newImageResponse create response for processed image with headers set in the same way for all engines
+func newImageResponse(buf []byte, contentType string, width, height int) *response.Response {
+	bodyHash := md5.New()
+	bodyHash.Write(buf)
+
+	res := response.NewBuf(200, buf)
+	res.SetContentType(contentType)
+	res.Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+	res.Set("ETag", hex.EncodeToString(bodyHash.Sum(nil)))
+	if width != 0 && height != 0 {
+		res.Set("x-amz-meta-public-width", strconv.Itoa(width))
+		res.Set("x-amz-meta-public-height", strconv.Itoa(height))
+	}
+
+	return res
+}
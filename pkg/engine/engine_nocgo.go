@@ -0,0 +1,8 @@
+//go:build !cgo
+// +build !cgo
+
+package engine
+
+// DefaultEngine name of engine used when bucket doesn't select any
+// libvips engine requires cgo, so binaries built without it use pure Go engine
+const DefaultEngine = ImagingEngineName
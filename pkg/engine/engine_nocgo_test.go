@@ -0,0 +1,27 @@
+//go:build !cgo
+// +build !cgo
+
+package engine
+
+import (
+	"testing"
+
+	"github.com/aldor007/mort/pkg/response"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNew_DefaultEngine(t *testing.T) {
+	e, err := New("", response.NewNoContent(200))
+
+	assert.Nil(t, err)
+	assert.IsType(t, &ImagingEngine{}, e)
+
+	_, err = New("libvips", response.NewNoContent(200))
+	assert.NotNil(t, err, "libvips engine shouldn't be registered without cgo")
+}
+
+func TestIsImage(t *testing.T) {
+	assert.True(t, IsImage([]byte("II*\x00\x08\x00\x00\x00")))
+	assert.True(t, IsImage([]byte("\x89PNG\r\n\x1a\n")))
+	assert.False(t, IsImage([]byte("plain text")))
+}
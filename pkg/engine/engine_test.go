@@ -0,0 +1,89 @@
+package engine
+
+import (
+	"context"
+	"image"
+	"os"
+	"testing"
+
+	"github.com/aldor007/mort/pkg/object"
+	"github.com/aldor007/mort/pkg/response"
+	"github.com/aldor007/mort/pkg/transforms"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNew_UnknownEngine(t *testing.T) {
+	e, err := New("not-existing", response.NewNoContent(200))
+
+	assert.Nil(t, e)
+	assert.NotNil(t, err)
+}
+
+func TestCheck_UnsupportedFormat(t *testing.T) {
+	tr := transforms.New()
+	tr.Format("webp")
+
+	assert.NotNil(t, Check(ImagingEngineName, []transforms.Transforms{tr}))
+}
+
+func TestImagingEngine_Process(t *testing.T) {
+	f, err := os.Open("testdata/small.jpg")
+	if err != nil {
+		panic(err)
+	}
+
+	tr := transforms.New()
+	tr.Resize(150, 0, false, false, false)
+	tr.Format("png")
+
+	e, err := New(ImagingEngineName, response.New(200, f))
+	assert.Nil(t, err)
+
+	res, err := e.Process(&object.FileObject{}, []transforms.Transforms{tr})
+
+	assert.Nil(t, err)
+	assert.Equal(t, res.StatusCode, 200)
+	assert.Equal(t, res.Headers.Get("content-type"), "image/png")
+	assert.Equal(t, res.Headers.Get("x-amz-meta-public-width"), "150")
+	assert.Equal(t, res.Headers.Get("x-amz-meta-public-height"), "100")
+}
+
+func TestImagingEngine_Crop(t *testing.T) {
+	f, err := os.Open("testdata/small.jpg")
+	if err != nil {
+		panic(err)
+	}
+
+	tr := transforms.New()
+	tr.Crop(50, 50, "north", false, false)
+
+	res, err := NewImagingEngine(response.New(200, f)).Process(&object.FileObject{}, []transforms.Transforms{tr})
+
+	assert.Nil(t, err)
+	assert.Equal(t, res.Headers.Get("content-type"), "image/jpeg")
+	assert.Equal(t, res.Headers.Get("x-amz-meta-public-width"), "50")
+	assert.Equal(t, res.Headers.Get("x-amz-meta-public-height"), "50")
+}
+
+func TestImagingEngine_TransformHook(t *testing.T) {
+	f, err := os.Open("testdata/small.jpg")
+	if err != nil {
+		panic(err)
+	}
+
+	tr := transforms.New()
+	tr.Resize(150, 0, false, false, false)
+	tr.Format("png")
+
+	var hooked image.Rectangle
+	obj := &object.FileObject{Ctx: WithTransformHook(context.Background(), func(img image.Image) image.Image {
+		hooked = img.Bounds()
+		return image.NewGray(image.Rect(0, 0, 10, 20))
+	})}
+	res, err := NewImagingEngine(response.New(200, f)).Process(obj, []transforms.Transforms{tr})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 150, hooked.Dx(), "hook should get transformed image")
+	assert.Equal(t, res.Headers.Get("x-amz-meta-public-width"), "10", "image returned by hook should be encoded")
+	assert.Equal(t, res.Headers.Get("x-amz-meta-public-height"), "20")
+}
@@ -0,0 +1,74 @@
+package engine
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// entropyPreviewSize is max dimension of preview in which area with the highest entropy is searched
+const entropyPreviewSize = 256
+
+// entropySteps is number of positions of area checked along axis
+const entropySteps = 32
+
+// entropyOffset returns top left corner of area of given size with the highest entropy of luminance
+// Area of crop covers whole image in one dimension, so it is moved only along the other one
+func entropyOffset(img image.Image, areaWidth, areaHeight int) (int, int) {
+	b := img.Bounds()
+	width, height := b.Dx(), b.Dy()
+	if areaWidth >= width && areaHeight >= height {
+		return 0, 0
+	}
+
+	gray := make([]uint8, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			gray[y*width+x] = color.GrayModel.Convert(img.At(b.Min.X+x, b.Min.Y+y)).(color.Gray).Y
+		}
+	}
+
+	maxLeft, maxTop := width-areaWidth, height-areaHeight
+	if maxLeft < 0 {
+		maxLeft = 0
+	}
+	if maxTop < 0 {
+		maxTop = 0
+	}
+
+	bestLeft, bestTop, best := 0, 0, -1.0
+	for i := 0; i <= entropySteps; i++ {
+		left, top := maxLeft*i/entropySteps, maxTop*i/entropySteps
+		e := areaEntropy(gray, width, image.Rect(left, top, left+areaWidth, top+areaHeight).Intersect(image.Rect(0, 0, width, height)))
+		if e > best {
+			bestLeft, bestTop, best = left, top, e
+		}
+	}
+
+	return bestLeft, bestTop
+}
+
+// areaEntropy returns Shannon entropy of histogram of luminance in area
+func areaEntropy(gray []uint8, width int, area image.Rectangle) float64 {
+	var histogram [256]int
+	for y := area.Min.Y; y < area.Max.Y; y++ {
+		for _, v := range gray[y*width+area.Min.X : y*width+area.Max.X] {
+			histogram[v]++
+		}
+	}
+
+	total := float64(area.Dx() * area.Dy())
+	if total == 0 {
+		return 0
+	}
+
+	entropy := 0.0
+	for _, count := range histogram {
+		if count != 0 {
+			p := float64(count) / total
+			entropy -= p * math.Log2(p)
+		}
+	}
+
+	return entropy
+}
@@ -0,0 +1,37 @@
+package engine
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEntropyOffset(t *testing.T) {
+	// flat image with noisy square in right part
+	img := image.NewGray(image.Rect(0, 0, 200, 50))
+	for y := 0; y < 50; y++ {
+		for x := 150; x < 200; x++ {
+			img.SetGray(x, y, color.Gray{Y: uint8((x*31 + y*17) % 256)})
+		}
+	}
+
+	left, top := entropyOffset(img, 50, 50)
+	assert.Equal(t, 150, left)
+	assert.Equal(t, 0, top)
+
+	left, top = entropyOffset(img, 200, 50)
+	assert.Equal(t, 0, left)
+	assert.Equal(t, 0, top)
+}
+
+func TestImagingFillGravity(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 200, 100))
+	img.Set(199, 0, color.RGBA{R: 255, A: 255})
+
+	res := imagingFill(img, 100, 100, "northeast", false)
+	assert.Equal(t, image.Rect(0, 0, 100, 100), res.Bounds())
+	r, _, _, _ := res.At(99, 0).RGBA()
+	assert.Equal(t, uint32(0xffff), r)
+}
@@ -0,0 +1,312 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// EXIF tags read from image
+const (
+	tagMake             = 0x010F
+	tagModel            = 0x0110
+	tagOrientation      = 0x0112
+	tagSoftware         = 0x0131
+	tagDateTime         = 0x0132
+	tagArtist           = 0x013B
+	tagCopyright        = 0x8298
+	tagExifIFD          = 0x8769
+	tagGPSIFD           = 0x8825
+	tagExposureTime     = 0x829A
+	tagFNumber          = 0x829D
+	tagISO              = 0x8827
+	tagDateTimeOriginal = 0x9003
+	tagFocalLength      = 0x920A
+	tagLensModel        = 0xA434
+	tagGPSLatitudeRef   = 0x0001
+	tagGPSLatitude      = 0x0002
+	tagGPSLongitudeRef  = 0x0003
+	tagGPSLongitude     = 0x0004
+	tagGPSAltitudeRef   = 0x0005
+	tagGPSAltitude      = 0x0006
+)
+
+// tiffTypeSize size in bytes of single value of TIFF field type
+var tiffTypeSize = map[uint16]int{
+	1:  1, // BYTE
+	2:  1, // ASCII
+	3:  2, // SHORT
+	4:  4, // LONG
+	5:  8, // RATIONAL
+	6:  1, // SBYTE
+	7:  1, // UNDEFINED
+	8:  2, // SSHORT
+	9:  4, // SLONG
+	10: 8, // SRATIONAL
+}
+
+var errNoEXIF = errors.New("exif: not found")
+
+// EXIF camera data stored in image
+type EXIF struct {
+	Make         string   `json:"make,omitempty"`
+	Model        string   `json:"model,omitempty"`
+	LensModel    string   `json:"lensModel,omitempty"`
+	Software     string   `json:"software,omitempty"`
+	DateTime     string   `json:"dateTime,omitempty"`
+	Artist       string   `json:"artist,omitempty"`
+	Copyright    string   `json:"copyright,omitempty"`
+	Orientation  int      `json:"orientation,omitempty"`
+	ExposureTime string   `json:"exposureTime,omitempty"`
+	FNumber      float64  `json:"fNumber,omitempty"`
+	ISO          int      `json:"iso,omitempty"`
+	FocalLength  float64  `json:"focalLength,omitempty"`
+	GPS          *GPSInfo `json:"gps,omitempty"`
+}
+
+// GPSInfo location where image was taken
+type GPSInfo struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Altitude  float64 `json:"altitude,omitempty"`
+}
+
+// tiffEntry single field of TIFF image file directory
+type tiffEntry struct {
+	typ   uint16
+	count int
+	value []byte
+}
+
+// tiffReader reads image file directories of TIFF structure
+type tiffReader struct {
+	buf   []byte
+	order binary.ByteOrder
+}
+
+// exifData returns TIFF structure with EXIF embedded in JPEG (APP1 segment), PNG (eXIf chunk) or TIFF based file
+func exifData(buf []byte) []byte {
+	switch {
+	case bytes.HasPrefix(buf, []byte("II*\x00")), bytes.HasPrefix(buf, []byte("MM\x00*")):
+		return buf
+	case bytes.HasPrefix(buf, []byte{0xFF, 0xD8}):
+		pos := 2
+		for pos+4 <= len(buf) && buf[pos] == 0xFF {
+			marker := buf[pos+1]
+			if marker == 0xDA || marker == 0xD9 {
+				return nil
+			}
+
+			segLen := int(binary.BigEndian.Uint16(buf[pos+2 : pos+4]))
+			end := pos + 2 + segLen
+			if segLen < 2 || end > len(buf) {
+				return nil
+			}
+
+			if marker == 0xE1 && bytes.HasPrefix(buf[pos+4:end], []byte("Exif\x00\x00")) {
+				return buf[pos+10 : end]
+			}
+			pos = end
+		}
+	case bytes.HasPrefix(buf, []byte("\x89PNG\r\n\x1a\n")):
+		pos := 8
+		for pos+8 <= len(buf) {
+			chunkLen := int(binary.BigEndian.Uint32(buf[pos : pos+4]))
+			end := pos + 8 + chunkLen
+			if end > len(buf) {
+				return nil
+			}
+
+			if string(buf[pos+4:pos+8]) == "eXIf" {
+				return buf[pos+8 : end]
+			}
+			pos = end + 4
+		}
+	}
+
+	return nil
+}
+
+// newTiffReader check TIFF header and returns reader with offset of first image file directory
+func newTiffReader(buf []byte) (tiffReader, int, error) {
+	r := tiffReader{buf: buf}
+	if len(buf) < 8 {
+		return r, 0, errNoEXIF
+	}
+
+	switch string(buf[:2]) {
+	case "II":
+		r.order = binary.LittleEndian
+	case "MM":
+		r.order = binary.BigEndian
+	default:
+		return r, 0, errors.New("exif: invalid byte order")
+	}
+
+	if r.order.Uint16(buf[2:4]) != 42 {
+		return r, 0, errors.New("exif: invalid tiff header")
+	}
+
+	return r, int(r.order.Uint32(buf[4:8])), nil
+}
+
+// ifd read entries of image file directory at given offset
+func (r tiffReader) ifd(offset int) (map[uint16]tiffEntry, error) {
+	if offset < 8 || offset+2 > len(r.buf) {
+		return nil, errors.New("exif: invalid ifd offset")
+	}
+
+	count := int(r.order.Uint16(r.buf[offset : offset+2]))
+	entries := make(map[uint16]tiffEntry, count)
+	for i := 0; i < count; i++ {
+		pos := offset + 2 + i*12
+		if pos+12 > len(r.buf) {
+			return entries, errors.New("exif: truncated ifd")
+		}
+
+		tag := r.order.Uint16(r.buf[pos : pos+2])
+		typ := r.order.Uint16(r.buf[pos+2 : pos+4])
+		n := int(r.order.Uint32(r.buf[pos+4 : pos+8]))
+		size, ok := tiffTypeSize[typ]
+		if !ok || n < 0 || n > len(r.buf) {
+			continue
+		}
+
+		valuePos := pos + 8
+		if size*n > 4 {
+			valuePos = int(r.order.Uint32(r.buf[pos+8 : pos+12]))
+		}
+
+		if valuePos < 0 || valuePos+size*n > len(r.buf) {
+			continue
+		}
+
+		entries[tag] = tiffEntry{typ: typ, count: n, value: r.buf[valuePos : valuePos+size*n]}
+	}
+
+	return entries, nil
+}
+
+func (r tiffReader) str(e tiffEntry) string {
+	return strings.TrimSpace(strings.TrimRight(string(e.value), "\x00"))
+}
+
+func (r tiffReader) uint(e tiffEntry) int {
+	if len(e.value) == 0 {
+		return 0
+	}
+
+	switch e.typ {
+	case 1, 7:
+		return int(e.value[0])
+	case 3:
+		return int(r.order.Uint16(e.value))
+	case 4:
+		return int(r.order.Uint32(e.value))
+	}
+
+	return 0
+}
+
+func (r tiffReader) rationals(e tiffEntry) [][2]uint32 {
+	if e.typ != 5 && e.typ != 10 {
+		return nil
+	}
+
+	result := make([][2]uint32, e.count)
+	for i := range result {
+		result[i] = [2]uint32{r.order.Uint32(e.value[i*8:]), r.order.Uint32(e.value[i*8+4:])}
+	}
+
+	return result
+}
+
+func (r tiffReader) float(e tiffEntry) float64 {
+	values := r.rationals(e)
+	if len(values) == 0 || values[0][1] == 0 {
+		return 0
+	}
+
+	return float64(values[0][0]) / float64(values[0][1])
+}
+
+// degrees converts GPS coordinate stored as degrees, minutes and seconds
+func (r tiffReader) degrees(e tiffEntry, ref string) float64 {
+	values := r.rationals(e)
+	result := 0.0
+	for i, v := range values {
+		if i > 2 || v[1] == 0 {
+			break
+		}
+		result += float64(v[0]) / float64(v[1]) / math.Pow(60, float64(i))
+	}
+
+	if ref == "S" || ref == "W" {
+		result = -result
+	}
+
+	return result
+}
+
+// parseEXIF read camera data from EXIF embedded in image
+func parseEXIF(buf []byte) (*EXIF, error) {
+	data := exifData(buf)
+	if data == nil {
+		return nil, errNoEXIF
+	}
+
+	r, offset, err := newTiffReader(data)
+	if err != nil {
+		return nil, err
+	}
+
+	ifd0, err := r.ifd(offset)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &EXIF{
+		Make:        r.str(ifd0[tagMake]),
+		Model:       r.str(ifd0[tagModel]),
+		Software:    r.str(ifd0[tagSoftware]),
+		DateTime:    r.str(ifd0[tagDateTime]),
+		Artist:      r.str(ifd0[tagArtist]),
+		Copyright:   r.str(ifd0[tagCopyright]),
+		Orientation: r.uint(ifd0[tagOrientation]),
+	}
+
+	if e, ok := ifd0[tagExifIFD]; ok {
+		if sub, err := r.ifd(r.uint(e)); err == nil {
+			if v := r.str(sub[tagDateTimeOriginal]); v != "" {
+				result.DateTime = v
+			}
+			result.LensModel = r.str(sub[tagLensModel])
+			result.FNumber = r.float(sub[tagFNumber])
+			result.FocalLength = r.float(sub[tagFocalLength])
+			result.ISO = r.uint(sub[tagISO])
+			if exposure := r.rationals(sub[tagExposureTime]); len(exposure) > 0 && exposure[0][1] != 0 {
+				result.ExposureTime = strconv.FormatUint(uint64(exposure[0][0]), 10) + "/" + strconv.FormatUint(uint64(exposure[0][1]), 10)
+			}
+		}
+	}
+
+	if e, ok := ifd0[tagGPSIFD]; ok {
+		if gps, err := r.ifd(r.uint(e)); err == nil {
+			if _, ok := gps[tagGPSLatitude]; ok {
+				result.GPS = &GPSInfo{
+					Latitude:  r.degrees(gps[tagGPSLatitude], r.str(gps[tagGPSLatitudeRef])),
+					Longitude: r.degrees(gps[tagGPSLongitude], r.str(gps[tagGPSLongitudeRef])),
+					Altitude:  r.float(gps[tagGPSAltitude]),
+				}
+				if ref := gps[tagGPSAltitudeRef]; len(ref.value) > 0 && ref.value[0] == 1 {
+					result.GPS.Altitude = -result.GPS.Altitude
+				}
+			}
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,128 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testTiffEntry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	value []byte
+}
+
+// buildTiff create little endian TIFF with IFD0 and GPS IFD
+func buildTiff(ifd0 []testTiffEntry, gps []testTiffEntry) []byte {
+	buf := &bytes.Buffer{}
+	buf.WriteString("II*\x00")
+	binary.Write(buf, binary.LittleEndian, uint32(8))
+
+	ifdSize := func(entries []testTiffEntry) int {
+		return 2 + len(entries)*12 + 4
+	}
+
+	gpsOffset := 8 + ifdSize(ifd0) + 12
+	if gps != nil {
+		ifd0 = append(ifd0, testTiffEntry{tagGPSIFD, 4, 1, []byte{byte(gpsOffset), 0, 0, 0}})
+	}
+
+	dataOffset := 8 + ifdSize(ifd0)
+	if gps != nil {
+		dataOffset += ifdSize(gps)
+	}
+
+	data := &bytes.Buffer{}
+	writeIFD := func(entries []testTiffEntry) {
+		binary.Write(buf, binary.LittleEndian, uint16(len(entries)))
+		for _, e := range entries {
+			binary.Write(buf, binary.LittleEndian, e.tag)
+			binary.Write(buf, binary.LittleEndian, e.typ)
+			binary.Write(buf, binary.LittleEndian, e.count)
+			if len(e.value) <= 4 {
+				buf.Write(append(e.value, make([]byte, 4-len(e.value))...))
+			} else {
+				binary.Write(buf, binary.LittleEndian, uint32(dataOffset+data.Len()))
+				data.Write(e.value)
+			}
+		}
+		binary.Write(buf, binary.LittleEndian, uint32(0))
+	}
+
+	writeIFD(ifd0)
+	if gps != nil {
+		writeIFD(gps)
+	}
+	buf.Write(data.Bytes())
+
+	return buf.Bytes()
+}
+
+func rationals(values ...uint32) []byte {
+	buf := &bytes.Buffer{}
+	for _, v := range values {
+		binary.Write(buf, binary.LittleEndian, v)
+		binary.Write(buf, binary.LittleEndian, uint32(1))
+	}
+
+	return buf.Bytes()
+}
+
+func testEXIF() []byte {
+	return buildTiff([]testTiffEntry{
+		{tagMake, 2, 6, []byte("Canon\x00")},
+		{tagOrientation, 3, 1, []byte{6, 0}},
+		{tagCopyright, 2, 9, []byte("John Doe\x00")},
+	}, []testTiffEntry{
+		{tagGPSLatitudeRef, 2, 2, []byte("N\x00")},
+		{tagGPSLatitude, 5, 3, rationals(50, 30, 0)},
+		{tagGPSLongitudeRef, 2, 2, []byte("W\x00")},
+		{tagGPSLongitude, 5, 3, rationals(19, 0, 0)},
+	})
+}
+
+func wrapInJPEG(tiff []byte) []byte {
+	buf := &bytes.Buffer{}
+	buf.Write([]byte{0xFF, 0xD8, 0xFF, 0xE1})
+	binary.Write(buf, binary.BigEndian, uint16(len(tiff)+8))
+	buf.WriteString("Exif\x00\x00")
+	buf.Write(tiff)
+	buf.Write([]byte{0xFF, 0xDA, 0x00, 0x02, 0xFF, 0xD9})
+
+	return buf.Bytes()
+}
+
+func TestParseEXIF(t *testing.T) {
+	exif, err := parseEXIF(wrapInJPEG(testEXIF()))
+
+	assert.Nil(t, err)
+	assert.Equal(t, "Canon", exif.Make)
+	assert.Equal(t, "John Doe", exif.Copyright)
+	assert.Equal(t, 6, exif.Orientation)
+	assert.NotNil(t, exif.GPS)
+	assert.InDelta(t, 50.5, exif.GPS.Latitude, 0.0001)
+	assert.InDelta(t, -19, exif.GPS.Longitude, 0.0001)
+}
+
+func TestParseEXIFTiff(t *testing.T) {
+	exif, err := parseEXIF(testEXIF())
+
+	assert.Nil(t, err)
+	assert.Equal(t, "Canon", exif.Make)
+}
+
+func TestParseEXIFMissing(t *testing.T) {
+	_, err := parseEXIF([]byte{0xFF, 0xD8, 0xFF, 0xDA, 0x00, 0x02, 0xFF, 0xD9})
+
+	assert.Equal(t, errNoEXIF, err)
+}
+
+func TestParseEXIFTruncated(t *testing.T) {
+	tiff := testEXIF()
+	_, err := parseEXIF(tiff[:20])
+
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,48 @@
+package engine
+
+import (
+	"context"
+	"image"
+
+	"github.com/aldor007/mort/pkg/object"
+)
+
+// TransformHook is run on decoded result of transforms before it is encoded, returned image replaces result
+type TransformHook func(img image.Image) image.Image
+
+type transformHookContext string
+
+// transformHookCtxKey key under which TransformHook is stored in request context
+var transformHookCtxKey transformHookContext = "transformHook"
+
+// WithTransformHook returns context with hook which engine runs before result is encoded
+func WithTransformHook(ctx context.Context, hook TransformHook) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	return context.WithValue(ctx, transformHookCtxKey, hook)
+}
+
+// transformHook returns hook of object or nil when it isn't set
+func transformHook(obj *object.FileObject) TransformHook {
+	if obj == nil || obj.Ctx == nil {
+		return nil
+	}
+
+	hook, _ := obj.Ctx.Value(transformHookCtxKey).(TransformHook)
+	return hook
+}
+
+// run runs hook on image, image is kept when hook returns nil
+func (h TransformHook) run(img image.Image) image.Image {
+	if h == nil {
+		return img
+	}
+
+	if result := h(img); result != nil {
+		return result
+	}
+
+	return img
+}
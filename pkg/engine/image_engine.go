@@ -1,6 +1,11 @@
+//go:build cgo
+// +build cgo
+
 package engine
 
 import (
+	"context"
+	"errors"
 	"net/http"
 	"strconv"
 	"time"
@@ -17,11 +22,82 @@ import (
 	"go.uber.org/zap"
 )
 
-// ImageEngine is main struct that is responding for image processing
+// DefaultEngine name of engine used when bucket doesn't select any
+const DefaultEngine = "libvips"
+
+func init() {
+	RegisterEngine(DefaultEngine, Capabilities{
+		Operations: []string{"crop", "resize", "extract", "resizeCropAuto", "gravity", "quality", "format", "interlace",
+			"strip", "blur", "watermark", "grayscale", "rotate", "page", "colorProfile", "autoQuality", "maxBytes",
+			"compression", "speed", "chromaSubsampling", "effort", "trim", "sharpen", "duotone", "overlay", "composite", "pixelate"},
+		Formats: vipsFormats(),
+	}, func(parent *response.Response) Engine {
+		return NewImageEngine(parent)
+	})
+}
+
+// vipsSaveTypes formats which can be used as output of libvips engine
+var vipsSaveTypes = map[string]bimg.ImageType{
+	"jpeg": bimg.JPEG,
+	"jpg":  bimg.JPEG,
+	"webp": bimg.WEBP,
+	"png":  bimg.PNG,
+	"gif":  bimg.GIF,
+	"svg":  bimg.SVG,
+	"pdf":  bimg.PDF,
+	"heif": bimg.HEIF,
+	"heic": bimg.HEIF,
+	"avif": bimg.AVIF,
+}
+
+// vipsFormats returns output formats supported by installed libvips
+func vipsFormats() []string {
+	formats := make([]string, 0, len(vipsSaveTypes))
+	for name, t := range vipsSaveTypes {
+		if bimg.IsTypeSupportedSave(t) {
+			formats = append(formats, name)
+		}
+	}
+
+	return formats
+}
+
+// ImageEngine is main struct that is responding for image processing using libvips
 type ImageEngine struct {
 	parent *response.Response // source file
 }
 
+// autoQualityTarget returns SSIM target of last transform with auto quality enabled or 0
+func autoQualityTarget(trans []transforms.Transforms) float64 {
+	target := 0.0
+	for _, t := range trans {
+		if q := t.Params().AutoQuality; q != 0 {
+			target = q
+		}
+	}
+
+	return target
+}
+
+// maxBytesLimit returns size limit and quality of last transform which limits size of result
+func maxBytesLimit(trans []transforms.Transforms) (int, int) {
+	maxBytes, quality := 0, 0
+	for _, t := range trans {
+		p := t.Params()
+		if p.Quality != 0 {
+			quality = p.Quality
+		}
+		if p.AutoQuality != 0 {
+			quality = 0
+		}
+		if p.MaxBytes != 0 {
+			maxBytes = p.MaxBytes
+		}
+	}
+
+	return maxBytes, quality
+}
+
 // NewImageEngine create instance of ImageEngine with source file that should be processed
 func NewImageEngine(res *response.Response) *ImageEngine {
 	return &ImageEngine{parent: res}
@@ -38,18 +114,127 @@ func (c *ImageEngine) Process(obj *object.FileObject, trans []transforms.Transfo
 		return response.NewError(500, err), err
 	}
 
-	for _, tran := range trans {
+	if IsRAW(c.parent.Headers.Get(response.HeaderContentType)) {
+		ctx := obj.Ctx
+		if ctx == nil {
+			ctx = context.Background()
+		}
+
+		buf, err = rawPreview(ctx, buf)
+		if err != nil {
+			monitoring.Log().Error("ImageEngine unable to decode raw image", obj.LogData(zap.Error(err))...)
+			return response.NewError(500, err), err
+		}
+	}
+
+	if bimg.DetermineImageTypeName(buf) == "pdf" {
+		page := 1
+		if len(trans) > 0 && trans[0].Params().Page != 0 {
+			page = trans[0].Params().Page
+		}
+
+		buf, err = renderPDFPage(buf, page-1)
+		if err != nil {
+			monitoring.Log().Error("ImageEngine unable to render pdf", obj.LogData(zap.Int("page", page), zap.Error(err))...)
+			return response.NewError(500, err), err
+		}
+	}
+
+	isSVG := bimg.DetermineImageTypeName(buf) == "svg" || IsSVG(buf)
+	isHEIF := bimg.DetermineImageTypeName(buf) == "heif"
+	if isHEIF && !bimg.IsTypeSupported(bimg.HEIF) {
+		err = errors.New("libvips is built without heif support")
+		monitoring.Log().Error("ImageEngine unable to decode image", obj.LogData(zap.Error(err))...)
+		return response.NewError(500, err), err
+	}
+
+	if isSVG && len(trans) > 0 {
+		// rasterize svg at requested size instead of scaling bitmap
+		p := trans[0].Params()
+		if p.Width != 0 || p.Height != 0 {
+			buf = svgWithSize(buf, p.Width, p.Height, p.Crop)
+		}
+	}
+
+	hook := transformHook(obj)
+	for i, tran := range trans {
+		// hook gets decoded result of the last transform, so it is processed to lossless image
+		var tranHook TransformHook
+		if i == len(trans)-1 {
+			tranHook = hook
+		}
+
+		if i == 0 && isSVG && tran.FormatStr == "" {
+			// libvips can't save svg, png keeps transparency
+			tran.Format("png")
+		}
+
+		if i == 0 && isHEIF && tran.FormatStr == "" {
+			// most of browsers are not able to display heif
+			tran.Format("jpeg")
+		}
+
+		if p := tran.Params(); p.Pixelate != nil {
+			// pixelated source is png, result keeps format of source
+			if tran.FormatStr == "" {
+				tran.Format(bimg.DetermineImageTypeName(buf))
+			}
+
+			buf, err = vipsPixelate(buf, *p.Pixelate)
+			if err != nil {
+				monitoring.Log().Error("ImageEngine unable to pixelate image", obj.LogData(zap.Error(err))...)
+				return response.NewError(500, err), err
+			}
+		}
+
 		image := bimg.NewImage(buf)
 		meta, err := image.Metadata()
 		if err != nil {
 			return response.NewError(500, err), err
 		}
 
-		optsArr, err := tran.BimgOptions(transforms.NewImageInfo(meta, bimg.DetermineImageTypeName(buf)))
+		if p := tran.Params(); p.Trim {
+			area, err := trimArea(buf, meta.Size.Width, meta.Size.Height, p.TrimTolerance, p.TrimBackground)
+			if err != nil {
+				monitoring.Log().Warn("ImageEngine unable to find border of image", obj.LogData(zap.Error(err))...)
+			} else if area.Dx() != meta.Size.Width || area.Dy() != meta.Size.Height {
+				// border is removed first, so all other operations use trimmed image
+				buf, err = image.Process(bimg.Options{Top: area.Min.Y, Left: area.Min.X, AreaWidth: area.Dx(), AreaHeight: area.Dy(), Quality: 100})
+				if err != nil {
+					monitoring.Log().Error("ImageEngine unable to trim image", obj.LogData(zap.Error(err))...)
+					return response.NewError(500, err), err
+				}
+
+				image = bimg.NewImage(buf)
+				if meta, err = image.Metadata(); err != nil {
+					return response.NewError(500, err), err
+				}
+			}
+		}
+
+		info := transforms.NewImageInfo(meta, bimg.DetermineImageTypeName(buf))
+		if tran.NeedsGravityOffset() {
+			if area, ok := tran.GravityArea(info); ok {
+				left, top, err := entropyCrop(buf, meta.Size.Width, meta.Size.Height, area.Dx(), area.Dy())
+				if err != nil {
+					monitoring.Log().Warn("ImageEngine unable to find area with the highest entropy", obj.LogData(zap.Error(err))...)
+				} else {
+					tran.SetGravityOffset(left, top)
+				}
+			}
+		}
+
+		optsArr, err := tran.BimgOptions(info)
 		if err != nil {
 			monitoring.Log().Error("ImageEngine unable to create opts array age", obj.LogData(zap.Any("transforms", trans), zap.Any("currentTrans", tran), zap.Error(err))...)
 			return response.NewError(500, err), err
 		}
+		if tranHook != nil {
+			for j := range optsArr {
+				optsArr[j].Type = bimg.PNG
+			}
+		}
+
 		optsLen := len(optsArr)
 		for i, opts := range optsArr {
 			buf, err = image.Process(opts)
@@ -62,6 +247,57 @@ func (c *ImageEngine) Process(obj *object.FileObject, trans []transforms.Transfo
 				image = bimg.NewImage(buf)
 			}
 		}
+
+		if tran.HasEffects() || tranHook != nil {
+			buf, err = vipsEffects(buf, tran.Params(), tran.EncodeOptions(info), tranHook)
+			if err != nil {
+				monitoring.Log().Error("ImageEngine unable to apply effects", obj.LogData(zap.Error(err))...)
+				return response.NewError(500, err), err
+			}
+		} else if tran.NeedsEncoder(info) {
+			buf, err = vipsEncode(buf, tran.EncodeOptions(info), tran.Params())
+			if err != nil {
+				monitoring.Log().Error("ImageEngine unable to encode image", obj.LogData(zap.Error(err))...)
+				return response.NewError(500, err), err
+			}
+		}
+	}
+
+	// libvips encodes result of every operation, so only tuning of final image is measured as encoding
+	encodeStart := time.Now()
+	defer monitoring.StagesFromContext(obj.Ctx).Since(monitoring.StageEncode, encodeStart)
+
+	if target := autoQualityTarget(trans); target != 0 {
+		tuned, quality, err := autoQuality(buf, target)
+		if err != nil {
+			monitoring.Log().Warn("ImageEngine unable to choose quality", obj.LogData(zap.Error(err))...)
+		} else {
+			monitoring.Log().Info("ImageEngine quality chosen", obj.LogData(zap.Int("quality", quality), zap.Int("size", len(tuned)))...)
+			buf = tuned
+		}
+	}
+
+	if maxBytes, quality := maxBytesLimit(trans); maxBytes != 0 {
+		fitted, fittedQuality, err := fitMaxBytes(buf, maxBytes, quality)
+		if err != nil {
+			monitoring.Log().Warn("ImageEngine unable to fit in max bytes", obj.LogData(zap.Int("maxBytes", maxBytes), zap.Int("size", len(buf)), zap.Error(err))...)
+			return response.NewError(500, err), err
+		}
+
+		if len(fitted) != len(buf) {
+			monitoring.Log().Info("ImageEngine re-encoded to fit max bytes", obj.LogData(zap.Int("quality", fittedQuality), zap.Int("size", len(fitted)))...)
+		}
+		buf = fitted
+	}
+
+	if p, ok := stripParams(trans); ok {
+		stripped, err := stripMetadata(buf, p.StripMode, p.KeepICC)
+		if err != nil {
+			// formats other than jpeg and png are stripped completely by libvips
+			monitoring.Log().Debug("ImageEngine selective strip skipped", obj.LogData(zap.Error(err))...)
+		} else {
+			buf = stripped
+		}
 	}
 
 	bodyHash := md5.New()
@@ -1,3 +1,6 @@
+//go:build cgo
+// +build cgo
+
 package engine
 
 import (
@@ -10,6 +13,21 @@ import (
 	"testing"
 )
 
+func TestNew_DefaultEngine(t *testing.T) {
+	e, err := New("", response.NewNoContent(200))
+
+	assert.Nil(t, err)
+	assert.IsType(t, &ImageEngine{}, e)
+}
+
+func TestCheck_Unsupported(t *testing.T) {
+	tr := transforms.New()
+	tr.Blur(1, 0)
+
+	assert.Nil(t, Check(DefaultEngine, []transforms.Transforms{tr}))
+	assert.NotNil(t, Check(ImagingEngineName, []transforms.Transforms{tr}))
+}
+
 func TestImageEngine_Process_Error(t *testing.T) {
 	image := response.NewNoContent(500)
 	mortConfig := config.Config{}
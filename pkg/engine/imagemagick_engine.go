@@ -0,0 +1,383 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aldor007/mort/pkg/monitoring"
+	"github.com/aldor007/mort/pkg/object"
+	"github.com/aldor007/mort/pkg/response"
+	"github.com/aldor007/mort/pkg/transforms"
+	"go.uber.org/zap"
+)
+
+// ImageMagickEngineName name under which imagemagick engine is registered
+const ImageMagickEngineName = "imagemagick"
+
+// magickGravity maps mort gravity names to imagemagick ones
+var magickGravity = map[string]string{
+	"center":    "Center",
+	"smart":     "Center",
+	"entropy":   "Center",
+	"north":     "North",
+	"south":     "South",
+	"east":      "East",
+	"west":      "West",
+	"northeast": "NorthEast",
+	"northwest": "NorthWest",
+	"southeast": "SouthEast",
+	"southwest": "SouthWest",
+}
+
+// errMagickInputFormat returned when source image isn't in one of formats allowed for imagemagick
+var errMagickInputFormat = errors.New("imagemagick engine doesn't accept format of source image")
+
+// magickInputSignatures signatures of formats which imagemagick engine reads
+// Format of source is always given to imagemagick, so it doesn't detect it by itself and scripted formats (MVG, MSL, SVG, url:) are never read
+var magickInputSignatures = []struct {
+	format    string
+	signature []byte
+}{
+	{"jpeg", []byte{0xFF, 0xD8, 0xFF}},
+	{"png", []byte("\x89PNG\r\n\x1a\n")},
+	{"gif", []byte("GIF87a")},
+	{"gif", []byte("GIF89a")},
+	{"tiff", []byte("II*\x00")},
+	{"tiff", []byte("MM\x00*")},
+	{"bmp", []byte("BM")},
+	{"psd", []byte("8BPS")},
+}
+
+func init() {
+	binary := magickBinary()
+	if binary == "" {
+		return
+	}
+
+	var formatsOnce sync.Once
+	var formats []string
+	RegisterEngine(ImageMagickEngineName, Capabilities{
+		Operations: []string{"crop", "resize", "extract", "gravity", "quality", "format", "interlace", "strip", "blur",
+			"grayscale", "rotate", "page", "compression", "speed", "chromaSubsampling", "effort", "trim", "sharpen", "duotone"},
+		// listing formats executes imagemagick, so it is done when engine is used for the first time
+		FormatsFunc: func() []string {
+			formatsOnce.Do(func() {
+				formats = magickFormats(binary)
+			})
+			return formats
+		},
+	}, func(parent *response.Response) Engine {
+		return NewImageMagickEngine(parent, binary)
+	})
+}
+
+// magickBinary returns path to imagemagick binary or empty string when it is not installed
+func magickBinary() string {
+	for _, name := range []string{"magick", "convert"} {
+		if p, err := exec.LookPath(name); err == nil {
+			return p
+		}
+	}
+
+	return ""
+}
+
+// magickFormats discover formats that imagemagick is able to write
+func magickFormats(binary string) []string {
+	out, err := exec.Command(binary, "-list", "format").Output()
+	if err != nil {
+		return []string{"jpeg", "jpg", "png", "gif", "webp", "tiff"}
+	}
+
+	formats := []string{}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		// format of line: "     JPEG* JPEG      rw-   Joint Photographic Experts Group JFIF format"
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || !strings.Contains(fields[2], "w") {
+			continue
+		}
+
+		formats = append(formats, strings.ToLower(strings.TrimRight(fields[0], "*+")))
+	}
+
+	return formats
+}
+
+// magickInputFormat returns imagemagick name of format of source image detected from its content
+func magickInputFormat(buf []byte) (string, error) {
+	for _, s := range magickInputSignatures {
+		if bytes.HasPrefix(buf, s.signature) {
+			return s.format, nil
+		}
+	}
+
+	if len(buf) >= 12 && string(buf[0:4]) == "RIFF" && string(buf[8:12]) == "WEBP" {
+		return "webp", nil
+	}
+
+	if len(buf) >= 12 && string(buf[4:8]) == "ftyp" {
+		switch string(buf[8:12]) {
+		case "avif", "avis":
+			return "avif", nil
+		case "heic", "heix", "hevc", "hevx", "mif1", "msf1":
+			return "heic", nil
+		}
+	}
+
+	return "", errMagickInputFormat
+}
+
+// ImageMagickEngine process images by executing imagemagick binary
+// It is used for formats which are not supported by libvips
+type ImageMagickEngine struct {
+	parent *response.Response // source file
+	binary string             // path to imagemagick binary
+}
+
+// NewImageMagickEngine create instance of ImageMagickEngine with source file that should be processed
+func NewImageMagickEngine(res *response.Response, binary string) *ImageMagickEngine {
+	return &ImageMagickEngine{parent: res, binary: binary}
+}
+
+// Process pipe source image through imagemagick and return result
+func (e *ImageMagickEngine) Process(obj *object.FileObject, trans []transforms.Transforms) (*response.Response, error) {
+	t := monitoring.Report().Timer("generation_time")
+	defer t.Done()
+
+	buf, err := e.parent.Body()
+	if err != nil {
+		return response.NewError(500, err), err
+	}
+
+	ctx := obj.Ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	input, err := magickInputFormat(buf)
+	if err != nil {
+		monitoring.Log().Warn("ImageMagickEngine refused source image", obj.LogData(zap.String("contentType", e.parent.Headers.Get(response.HeaderContentType)))...)
+		return response.NewError(500, err), err
+	}
+
+	args, format := magickArgs(input, trans)
+	hook := transformHook(obj)
+	if hook != nil {
+		// hook gets decoded result, so operations are written as lossless image which is encoded by second run
+		var encode []string
+		args, encode, format = magickPipeline(input, trans)
+		if format == "" {
+			if _, source, err := image.DecodeConfig(bytes.NewReader(buf)); err == nil {
+				format = source
+			}
+		}
+
+		if buf, err = e.run(ctx, obj, append(args, "png:-"), buf); err != nil {
+			return response.NewError(500, err), err
+		}
+
+		img, _, err := image.Decode(bytes.NewReader(buf))
+		if err != nil {
+			monitoring.Log().Error("ImageMagickEngine unable to decode image", obj.LogData(zap.Error(err))...)
+			return response.NewError(500, err), err
+		}
+
+		lossless := bytes.Buffer{}
+		encoder := png.Encoder{CompressionLevel: png.BestSpeed}
+		if err = encoder.Encode(&lossless, hook.run(img)); err != nil {
+			return response.NewError(500, err), err
+		}
+
+		buf = lossless.Bytes()
+		args = append(append([]string{"png:-"}, encode...), magickOutput(format))
+	}
+
+	out, err := e.run(ctx, obj, args, buf)
+	if err != nil {
+		return response.NewError(500, err), err
+	}
+
+	if p, ok := stripParams(trans); ok {
+		if stripped, err := stripMetadata(out, p.StripMode, p.KeepICC); err == nil {
+			out = stripped
+		} else {
+			monitoring.Log().Warn("ImageMagickEngine unable to strip metadata selectively", obj.LogData(zap.Error(err))...)
+		}
+	}
+
+	contentType := e.parent.Headers.Get(response.HeaderContentType)
+	if format != "" {
+		contentType = "image/" + format
+	}
+
+	width, height := 0, 0
+	if cfg, decoded, err := image.DecodeConfig(bytes.NewReader(out)); err == nil {
+		width, height = cfg.Width, cfg.Height
+		contentType = "image/" + decoded
+	}
+
+	return newImageResponse(out, contentType, width, height), nil
+}
+
+// run pipes image through imagemagick with given arguments
+func (e *ImageMagickEngine) run(ctx context.Context, obj *object.FileObject, args []string, buf []byte) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, e.binary, args...)
+	cmd.Stdin = bytes.NewReader(buf)
+	stderr := bytes.Buffer{}
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		monitoring.Log().Error("ImageMagickEngine unable to process image", obj.LogData(zap.Strings("args", args),
+			zap.String("stderr", stderr.String()), zap.Error(err))...)
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// magickArgs build arguments for imagemagick, source in input format is read from stdin and result is written to stdout
+func magickArgs(input string, trans []transforms.Transforms) ([]string, string) {
+	args, encode, format := magickPipeline(input, trans)
+	return append(append(args, encode...), magickOutput(format)), format
+}
+
+// magickPipeline build arguments of operations and arguments of encoder for imagemagick
+func magickPipeline(input string, trans []transforms.Transforms) ([]string, []string, string) {
+	args := []string{input + ":-"}
+	var encode []string
+	format := ""
+	for i, tran := range trans {
+		p := tran.Params()
+		if i == 0 && p.Page != 0 {
+			// select page (frame) of source, imagemagick counts from 0
+			args[0] = input + ":-[" + strconv.Itoa(p.Page-1) + "]"
+		}
+
+		if p.Trim {
+			if c := p.TrimBackground; c != nil {
+				// imagemagick detects border from corners, frame of given color forces background
+				args = append(args, "-bordercolor", magickColor(*c), "-border", "1")
+			}
+			args = append(args, "-fuzz", strconv.FormatFloat(float64(p.TrimTolerance)*100/255, 'f', 2, 64)+"%", "-trim", "+repage")
+		}
+
+		if p.Extract != nil {
+			args = append(args, "-crop", magickGeometry(p.Extract.Dx(), p.Extract.Dy())+"+"+strconv.Itoa(p.Extract.Min.X)+"+"+strconv.Itoa(p.Extract.Min.Y), "+repage")
+		}
+
+		if p.Width != 0 || p.Height != 0 {
+			if p.Crop {
+				g, ok := magickGravity[p.Gravity]
+				if !ok {
+					g = "Center"
+				}
+				args = append(args, "-resize", magickGeometry(p.Width, p.Height)+"^", "-gravity", g,
+					"-extent", magickGeometry(p.Width, p.Height), "+repage")
+			} else {
+				geometry := magickGeometry(p.Width, p.Height)
+				if !p.Enlarge {
+					geometry += ">"
+				}
+				args = append(args, "-resize", geometry)
+			}
+		}
+
+		if p.Rotate != 0 {
+			args = append(args, "-rotate", strconv.Itoa(p.Rotate))
+		}
+
+		if p.Grayscale {
+			args = append(args, "-colorspace", "Gray")
+		}
+
+		if d := p.Duotone; d != nil {
+			args = append(args, "-colorspace", "Gray", "+level-colors", magickColor(d.Shadow)+","+magickColor(d.Highlight), "-colorspace", "sRGB")
+		}
+
+		if p.Blur != 0 {
+			args = append(args, "-blur", "0x"+strconv.FormatFloat(p.Blur, 'f', -1, 64))
+		}
+
+		if sp := p.Sharpen; sp != nil {
+			f := func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+			args = append(args, "-unsharp", f(sp.Radius)+"x"+f(sp.Sigma)+"+"+f(sp.Amount)+"+"+f(sp.Threshold))
+		}
+
+		if p.Strip && (p.StripMode == "" || !selectiveStripFormats[p.Format]) {
+			// selective strip is done after encoding only for known output formats
+			encode = append(encode, "-strip")
+		}
+
+		if p.Interlace {
+			encode = append(encode, "-interlace", "Plane")
+		}
+
+		if p.Quality != 0 {
+			encode = append(encode, "-quality", strconv.Itoa(p.Quality))
+		}
+
+		if p.Compression != 0 {
+			encode = append(encode, "-define", "png:compression-level="+strconv.Itoa(p.Compression))
+		}
+
+		if p.Speed != 0 {
+			encode = append(encode, "-define", "heic:speed="+strconv.Itoa(p.Speed))
+		}
+
+		if p.Chroma != "" {
+			encode = append(encode, "-sampling-factor", p.Chroma)
+		}
+
+		if p.Effort != 0 {
+			encode = append(encode, "-define", "webp:method="+strconv.Itoa(p.Effort))
+		}
+
+		if p.Format != "" {
+			format = p.Format
+		}
+	}
+
+	if format == "jpg" {
+		format = "jpeg"
+	}
+
+	return args, encode, format
+}
+
+// magickOutput returns argument for writing result in given format to stdout, empty format keeps format of source
+func magickOutput(format string) string {
+	if format != "" {
+		return format + ":-"
+	}
+
+	return "-"
+}
+
+// magickColor returns color in imagemagick rgba() notation
+func magickColor(c color.RGBA) string {
+	return "rgba(" + strconv.Itoa(int(c.R)) + "," + strconv.Itoa(int(c.G)) + "," + strconv.Itoa(int(c.B)) + "," +
+		strconv.FormatFloat(float64(c.A)/255, 'f', 3, 64) + ")"
+}
+
+func magickGeometry(width, height int) string {
+	g := ""
+	if width != 0 {
+		g += strconv.Itoa(width)
+	}
+	g += "x"
+	if height != 0 {
+		g += strconv.Itoa(height)
+	}
+
+	return g
+}
@@ -0,0 +1,49 @@
+package engine
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/aldor007/mort/pkg/transforms"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMagickInputFormat(t *testing.T) {
+	buf, err := ioutil.ReadFile("testdata/small.jpg")
+	assert.Nil(t, err)
+
+	format, err := magickInputFormat(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "jpeg", format)
+
+	format, _ = magickInputFormat([]byte("RIFF\x00\x00\x00\x00WEBPVP8 "))
+	assert.Equal(t, "webp", format)
+
+	format, _ = magickInputFormat([]byte("\x00\x00\x00\x1cftypavif\x00\x00\x00\x00"))
+	assert.Equal(t, "avif", format)
+
+	for _, source := range []string{
+		"push graphic-context\nviewbox 0 0 640 480\nimage over 0,0 0,0 'url:https://example.com/x.png'\npop graphic-context",
+		"<?xml version=\"1.0\"?><svg xmlns=\"http://www.w3.org/2000/svg\"/>",
+		"<?xml version=\"1.0\"?><image><read filename=\"/etc/passwd\"/></image>",
+		"",
+	} {
+		_, err = magickInputFormat([]byte(source))
+		assert.Equal(t, errMagickInputFormat, err, source)
+	}
+}
+
+func TestMagickArgsInputFormat(t *testing.T) {
+	tr := transforms.New()
+	assert.Nil(t, tr.Format("webp"))
+	args, format := magickArgs("png", []transforms.Transforms{tr})
+	assert.Equal(t, "png:-", args[0])
+	assert.Equal(t, "webp:-", args[len(args)-1])
+	assert.Equal(t, "webp", format)
+
+	tr = transforms.New()
+	assert.Nil(t, tr.Page(2))
+	args, _ = magickArgs("tiff", []transforms.Transforms{tr})
+	assert.Equal(t, "tiff:-[1]", args[0])
+	assert.Equal(t, "-", args[len(args)-1])
+}
@@ -0,0 +1,390 @@
+package engine
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"strings"
+
+	"github.com/aldor007/mort/pkg/monitoring"
+	"github.com/aldor007/mort/pkg/object"
+	"github.com/aldor007/mort/pkg/response"
+	"github.com/aldor007/mort/pkg/transforms"
+	"go.uber.org/zap"
+)
+
+// ImagingEngineName name under which pure Go engine is registered
+const ImagingEngineName = "imaging"
+
+func init() {
+	RegisterEngine(ImagingEngineName, Capabilities{
+		// speed and effort are accepted but ignored because engine doesn't encode heif/avif and webp
+		Operations: []string{"crop", "resize", "extract", "gravity", "quality", "format", "strip", "grayscale", "rotate",
+			"compression", "speed", "effort", "trim", "sharpen", "duotone", "overlay", "composite", "pixelate"},
+		Formats: []string{"jpeg", "jpg", "png", "gif"},
+	}, func(parent *response.Response) Engine {
+		return NewImagingEngine(parent)
+	})
+}
+
+// ImagingEngine process images using only Go standard library
+// It is slower than libvips and supports limited set of operations and formats (jpeg, png, gif)
+type ImagingEngine struct {
+	parent *response.Response // source file
+}
+
+// NewImagingEngine create instance of ImagingEngine with source file that should be processed
+func NewImagingEngine(res *response.Response) *ImagingEngine {
+	return &ImagingEngine{parent: res}
+}
+
+// Process decode source image, perform transforms and encode result
+func (e *ImagingEngine) Process(obj *object.FileObject, trans []transforms.Transforms) (*response.Response, error) {
+	t := monitoring.Report().Timer("generation_time")
+	defer t.Done()
+
+	buf, err := e.parent.Body()
+	if err != nil {
+		return response.NewError(500, err), err
+	}
+
+	img, format, err := image.Decode(bytes.NewReader(buf))
+	if err != nil {
+		monitoring.Log().Error("ImagingEngine unable to decode image", obj.LogData(zap.Error(err))...)
+		return response.NewError(500, err), err
+	}
+
+	quality := jpeg.DefaultQuality
+	compression := png.DefaultCompression
+	for _, tran := range trans {
+		p := tran.Params()
+		img = imagingApply(img, p)
+		if p.Quality != 0 {
+			quality = p.Quality
+		}
+		if p.Compression != 0 {
+			compression = pngCompression(p.Compression)
+		}
+		if p.Format != "" {
+			format = p.Format
+		}
+	}
+
+	img = transformHook(obj).run(img)
+	out := bytes.Buffer{}
+	switch format {
+	case "jpeg", "jpg":
+		format = "jpeg"
+		err = jpeg.Encode(&out, img, &jpeg.Options{Quality: quality})
+	case "png":
+		encoder := png.Encoder{CompressionLevel: compression}
+		err = encoder.Encode(&out, img)
+	case "gif":
+		err = gif.Encode(&out, img, nil)
+	default:
+		err = errors.New("imaging: unsupported output format " + format)
+	}
+
+	if err != nil {
+		monitoring.Log().Error("ImagingEngine unable to encode image", obj.LogData(zap.String("format", format), zap.Error(err))...)
+		return response.NewError(500, err), err
+	}
+
+	bounds := img.Bounds()
+	return newImageResponse(out.Bytes(), "image/"+format, bounds.Dx(), bounds.Dy()), nil
+}
+
+// pngCompression maps zlib compression level to one supported by Go png encoder
+func pngCompression(level int) png.CompressionLevel {
+	switch {
+	case level <= 3:
+		return png.BestSpeed
+	case level >= 8:
+		return png.BestCompression
+	default:
+		return png.DefaultCompression
+	}
+}
+
+// imagingApply perform single transform on image
+func imagingApply(img image.Image, p transforms.Params) image.Image {
+	if p.Pixelate != nil {
+		img = pixelate(img, pixelateAreas(img, *p.Pixelate), p.Pixelate.BlockSize)
+	}
+
+	if p.Trim {
+		img = imagingCrop(img, trimBounds(img, p.TrimTolerance, p.TrimBackground).Sub(img.Bounds().Min))
+	}
+
+	if p.Extract != nil {
+		img = imagingCrop(img, p.Extract.Intersect(img.Bounds().Sub(img.Bounds().Min)))
+	}
+
+	if p.Width != 0 || p.Height != 0 {
+		if p.Crop {
+			img = imagingFill(img, p.Width, p.Height, p.Gravity, p.Enlarge)
+		} else {
+			img = imagingFit(img, p.Width, p.Height, p.Enlarge)
+		}
+	}
+
+	if p.Rotate != 0 {
+		img = imagingRotate(img, p.Rotate)
+	}
+
+	if p.Sharpen != nil {
+		img = imagingSharpen(img, *p.Sharpen)
+	}
+
+	if p.Grayscale {
+		gray := image.NewGray(img.Bounds())
+		draw.Draw(gray, gray.Bounds(), img, img.Bounds().Min, draw.Src)
+		img = gray
+	}
+
+	return applyEffects(img, p)
+}
+
+// imagingFit resize image to fit in given box keeping aspect ratio
+func imagingFit(img image.Image, width, height int, enlarge bool) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if width == 0 {
+		width = w * height / h
+	} else if height == 0 {
+		height = h * width / w
+	} else if w*height > h*width {
+		height = h * width / w
+	} else {
+		width = w * height / h
+	}
+
+	if !enlarge && (width > w || height > h) {
+		return img
+	}
+
+	return imagingResize(img, width, height)
+}
+
+// imagingFill resize image to cover given box and crop it according to gravity
+func imagingFill(img image.Image, width, height int, gravity string, enlarge bool) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if width == 0 {
+		width = w
+	}
+	if height == 0 {
+		height = h
+	}
+
+	scaledW, scaledH := width, h*width/w
+	if scaledH < height {
+		scaledW, scaledH = w*height/h, height
+	}
+
+	if enlarge || (scaledW <= w && scaledH <= h) {
+		img = imagingResize(img, scaledW, scaledH)
+	} else {
+		scaledW, scaledH = w, h
+	}
+
+	if width > scaledW {
+		width = scaledW
+	}
+	if height > scaledH {
+		height = scaledH
+	}
+
+	left, top := (scaledW-width)/2, (scaledH-height)/2
+	if strings.HasPrefix(gravity, "north") {
+		top = 0
+	}
+	if strings.HasPrefix(gravity, "south") {
+		top = scaledH - height
+	}
+	if strings.HasSuffix(gravity, "west") {
+		left = 0
+	}
+	if strings.HasSuffix(gravity, "east") {
+		left = scaledW - width
+	}
+	if gravity == transforms.GravityEntropy {
+		left, top = entropyOffset(img, width, height)
+	}
+
+	return imagingCrop(img, image.Rect(left, top, left+width, top+height))
+}
+
+// imagingSharpen sharpen image using unsharp mask, channels are changed only when they differ from blurred image more than threshold
+func imagingSharpen(img image.Image, p transforms.SharpenParams) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	src := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+
+	radius := int(math.Ceil(p.Radius))
+	if radius == 0 {
+		radius = int(math.Ceil(p.Sigma * 3))
+	}
+
+	kernel := make([]float64, 2*radius+1)
+	sum := 0.0
+	for i := range kernel {
+		d := float64(i - radius)
+		kernel[i] = math.Exp(-d * d / (2 * p.Sigma * p.Sigma))
+		sum += kernel[i]
+	}
+	for i := range kernel {
+		kernel[i] /= sum
+	}
+
+	// separable gaussian blur, horizontal pass followed by vertical one
+	tmp := make([]float64, w*h*3)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			for k, weight := range kernel {
+				sx := clampInt(x+k-radius, w)
+				o := src.PixOffset(sx, y)
+				for c := 0; c < 3; c++ {
+					tmp[(y*w+x)*3+c] += weight * float64(src.Pix[o+c])
+				}
+			}
+		}
+	}
+
+	threshold := p.Threshold * 255
+	dst := image.NewNRGBA(src.Bounds())
+	copy(dst.Pix, src.Pix)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			o := src.PixOffset(x, y)
+			for c := 0; c < 3; c++ {
+				blurred := 0.0
+				for k, weight := range kernel {
+					blurred += weight * tmp[(clampInt(y+k-radius, h)*w+x)*3+c]
+				}
+
+				v := float64(src.Pix[o+c])
+				if diff := v - blurred; math.Abs(diff) > threshold {
+					dst.Pix[o+c] = uint8(math.Max(0, math.Min(255, math.Round(v+p.Amount*diff))))
+				}
+			}
+		}
+	}
+
+	return dst
+}
+
+// imagingCrop returns part of image, rect is relative to image origin
+func imagingCrop(img image.Image, rect image.Rectangle) image.Image {
+	dst := image.NewRGBA(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, img.Bounds().Min.Add(rect.Min), draw.Src)
+	return dst
+}
+
+// imagingResize scale image using bilinear interpolation
+func imagingResize(img image.Image, width, height int) image.Image {
+	if width <= 0 {
+		width = 1
+	}
+	if height <= 0 {
+		height = 1
+	}
+
+	src := image.NewRGBA(image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()))
+	draw.Draw(src, src.Bounds(), img, img.Bounds().Min, draw.Src)
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	sw, sh := src.Bounds().Dx(), src.Bounds().Dy()
+	xRatio := float64(sw) / float64(width)
+	yRatio := float64(sh) / float64(height)
+
+	for y := 0; y < height; y++ {
+		sy := (float64(y)+0.5)*yRatio - 0.5
+		y0, fy := clampFloor(sy, sh)
+		y1 := minInt(y0+1, sh-1)
+		for x := 0; x < width; x++ {
+			sx := (float64(x)+0.5)*xRatio - 0.5
+			x0, fx := clampFloor(sx, sw)
+			x1 := minInt(x0+1, sw-1)
+
+			var c [4]float64
+			for i := 0; i < 4; i++ {
+				top := float64(src.Pix[src.PixOffset(x0, y0)+i])*(1-fx) + float64(src.Pix[src.PixOffset(x1, y0)+i])*fx
+				bottom := float64(src.Pix[src.PixOffset(x0, y1)+i])*(1-fx) + float64(src.Pix[src.PixOffset(x1, y1)+i])*fx
+				c[i] = top*(1-fy) + bottom*fy
+			}
+			dst.SetRGBA(x, y, color.RGBA{R: uint8(c[0] + 0.5), G: uint8(c[1] + 0.5), B: uint8(c[2] + 0.5), A: uint8(c[3] + 0.5)})
+		}
+	}
+
+	return dst
+}
+
+// imagingRotate rotate image clockwise by multiple of 90 degrees
+func imagingRotate(img image.Image, angle int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	var dst *image.RGBA
+	switch angle % 360 {
+	case 90, 270:
+		dst = image.NewRGBA(image.Rect(0, 0, h, w))
+	case 180:
+		dst = image.NewRGBA(image.Rect(0, 0, w, h))
+	default:
+		return img
+	}
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := img.At(b.Min.X+x, b.Min.Y+y)
+			switch angle % 360 {
+			case 90:
+				dst.Set(h-1-y, x, c)
+			case 180:
+				dst.Set(w-1-x, h-1-y, c)
+			case 270:
+				dst.Set(y, w-1-x, c)
+			}
+		}
+	}
+
+	return dst
+}
+
+// clampInt limit v to range [0, size)
+func clampInt(v, size int) int {
+	if v < 0 {
+		return 0
+	}
+	if v >= size {
+		return size - 1
+	}
+	return v
+}
+
+func clampFloor(v float64, size int) (int, float64) {
+	if v < 0 {
+		return 0, 0
+	}
+
+	i := int(v)
+	if i >= size-1 {
+		return size - 1, 0
+	}
+
+	return i, v - float64(i)
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+
+	return b
+}
@@ -0,0 +1,37 @@
+package engine
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/aldor007/mort/pkg/transforms"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImagingSharpen(t *testing.T) {
+	// vertical edge between dark and light gray
+	img := image.NewGray(image.Rect(0, 0, 20, 10))
+	for y := 0; y < 10; y++ {
+		for x := 0; x < 20; x++ {
+			v := uint8(100)
+			if x >= 10 {
+				v = 150
+			}
+			img.SetGray(x, y, color.Gray{Y: v})
+		}
+	}
+
+	res := imagingSharpen(img, transforms.SharpenParams{Sigma: 1, Amount: 1})
+	assert.Equal(t, img.Bounds(), res.Bounds())
+
+	at := func(img image.Image, x int) uint8 {
+		return color.GrayModel.Convert(img.At(x, 5)).(color.Gray).Y
+	}
+	assert.True(t, at(res, 9) < 100, "dark side of edge should be darker")
+	assert.True(t, at(res, 10) > 150, "light side of edge should be lighter")
+	assert.Equal(t, uint8(100), at(res, 0), "flat area shouldn't change")
+
+	res = imagingSharpen(img, transforms.SharpenParams{Sigma: 1, Amount: 1, Threshold: 0.5})
+	assert.Equal(t, uint8(100), at(res, 9), "difference below threshold shouldn't be sharpened")
+}
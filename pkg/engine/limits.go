@@ -0,0 +1,207 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+
+	"github.com/aldor007/mort/pkg/config"
+	"github.com/aldor007/mort/pkg/helpers"
+)
+
+// PDFContentType content type of pdf documents
+const PDFContentType = "application/pdf"
+
+// LimitError returned when source image exceeds configured limits
+type LimitError struct {
+	StatusCode int // 413 when decoded image doesn't fit in memory limit, 422 for dimensions
+	msg        string
+}
+
+func (e LimitError) Error() string {
+	return e.msg
+}
+
+// imageHeader read dimensions and number of bands of image without decoding its pixels
+func imageHeader(buf []byte) (int, int, int, error) {
+	if width, height, _, err := helpers.ImageDimensions(bytes.NewReader(buf)); err == nil {
+		// pure Go header parser doesn't know number of bands, so worst case is assumed
+		return width, height, 4, nil
+	}
+
+	return decodeHeader(buf)
+}
+
+// ImageSize returns width and height of image read from its header
+func ImageSize(buf []byte) (int, int, error) {
+	width, height, _, err := imageHeader(buf)
+	return width, height, err
+}
+
+// CheckLimits verify that source image can be safely decoded
+// Limits are chosen by content type detected from content of image
+// Only header of image is read, images which header can't be read are passed to engine
+func CheckLimits(buf []byte, limits config.ImageLimitsCfg) error {
+	contentType := http.DetectContentType(buf)
+	limits = limits.ForContentType(contentType)
+
+	if limits.MaxPages > 0 && contentType == PDFContentType {
+		if pages := countPDFPages(buf); pages > limits.MaxPages {
+			return LimitError{422, fmt.Sprintf("pdf document has %d pages, limit is %d", pages, limits.MaxPages)}
+		}
+	}
+
+	if limits.MaxFrames > 0 {
+		if frames := countFrames(buf, contentType); frames > limits.MaxFrames {
+			return LimitError{422, fmt.Sprintf("animated %s has %d frames, limit is %d", contentType, frames, limits.MaxFrames)}
+		}
+	}
+
+	width, height, bands, err := imageHeader(buf)
+	if err != nil {
+		return nil
+	}
+
+	if limits.MaxWidth > 0 && width > limits.MaxWidth {
+		return LimitError{422, fmt.Sprintf("%s width %d exceeds limit %d", contentType, width, limits.MaxWidth)}
+	}
+
+	if limits.MaxHeight > 0 && height > limits.MaxHeight {
+		return LimitError{422, fmt.Sprintf("%s height %d exceeds limit %d", contentType, height, limits.MaxHeight)}
+	}
+
+	pixels := float64(width) * float64(height)
+	if limits.MaxMegapixels > 0 && pixels > limits.MaxMegapixels*1e6 {
+		return LimitError{422, fmt.Sprintf("%s has %.1f megapixels, limit is %.1f", contentType, pixels/1e6, limits.MaxMegapixels)}
+	}
+
+	if bands < 1 {
+		bands = 4
+	}
+
+	if limits.MaxMemoryMB > 0 && pixels*float64(bands) > float64(limits.MaxMemoryMB<<20) {
+		return LimitError{413, fmt.Sprintf("decoded %s exceeds memory limit of %d MB", contentType, limits.MaxMemoryMB)}
+	}
+
+	return nil
+}
+
+// countFrames returns number of frames of gif or webp image, 1 for other images
+func countFrames(buf []byte, contentType string) int {
+	switch contentType {
+	case "image/gif":
+		return countGIFFrames(buf)
+	case "image/webp":
+		return countWebPFrames(buf)
+	}
+
+	return 1
+}
+
+// countGIFFrames count image descriptors of gif, blocks are skipped without decoding
+func countGIFFrames(buf []byte) int {
+	// header (6 bytes) and logical screen descriptor (7 bytes)
+	if len(buf) < 13 {
+		return 0
+	}
+	pos := 13
+	if buf[10]&0x80 != 0 {
+		pos += 3 << (uint(buf[10]&0x07) + 1)
+	}
+
+	frames := 0
+	for pos < len(buf) {
+		switch buf[pos] {
+		case 0x21:
+			// extension introducer and label precede data sub-blocks
+			pos = skipGIFSubBlocks(buf, pos+2)
+		case 0x2c:
+			frames++
+			if pos+10 > len(buf) {
+				return frames
+			}
+			flags := buf[pos+9]
+			pos += 10
+			if flags&0x80 != 0 {
+				pos += 3 << (uint(flags&0x07) + 1)
+			}
+			// LZW minimum code size precede image data sub-blocks
+			pos = skipGIFSubBlocks(buf, pos+1)
+		default:
+			// trailer or corrupted data
+			return frames
+		}
+	}
+
+	return frames
+}
+
+func skipGIFSubBlocks(buf []byte, pos int) int {
+	for pos < len(buf) {
+		size := int(buf[pos])
+		pos++
+		if size == 0 {
+			return pos
+		}
+		pos += size
+	}
+
+	return pos
+}
+
+// countWebPFrames count ANMF chunks of webp, image without animation has one frame
+func countWebPFrames(buf []byte) int {
+	frames := 0
+	for pos := 12; pos+8 <= len(buf); {
+		size := int(binary.LittleEndian.Uint32(buf[pos+4 : pos+8]))
+		if string(buf[pos:pos+4]) == "ANMF" {
+			frames++
+		}
+		// chunks are padded to even size
+		pos += 8 + size + size&1
+	}
+
+	if frames == 0 {
+		return 1
+	}
+
+	return frames
+}
+
+var pdfPageObject = regexp.MustCompile(`/Type\s*/Page[^s]`)
+var pdfPageCount = regexp.MustCompile(`/Type\s*/Pages[^>]*/Count\s+(\d+)|/Count\s+(\d+)[^>]*/Type\s*/Pages`)
+
+// countPDFPages returns number of pages of pdf document read from its page tree without rendering it
+// Page objects hidden in compressed object streams aren't visible, so the biggest count of page tree node is also checked
+func countPDFPages(buf []byte) int {
+	pages := len(pdfPageObject.FindAllIndex(buf, -1))
+	for _, m := range pdfPageCount.FindAllSubmatch(buf, -1) {
+		value := m[1]
+		if len(value) == 0 {
+			value = m[2]
+		}
+		if count, err := strconv.Atoi(string(value)); err == nil && count > pages {
+			pages = count
+		}
+	}
+
+	return pages
+}
+
+// EstimateMemory returns estimated memory in bytes needed to transform image, 0 when unknown
+// Decoded source and result of transformation are kept in memory at once
+func EstimateMemory(buf []byte) int64 {
+	width, height, bands, err := imageHeader(buf)
+	if err != nil {
+		return 0
+	}
+
+	if bands < 1 {
+		bands = 4
+	}
+
+	return 2 * int64(width) * int64(height) * int64(bands)
+}
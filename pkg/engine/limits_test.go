@@ -0,0 +1,105 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/gif"
+	"testing"
+
+	"github.com/aldor007/mort/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+// pngHeader create png with IHDR chunk only, which is enough for reading dimensions
+func pngHeader(width, height uint32) []byte {
+	ihdr := &bytes.Buffer{}
+	binary.Write(ihdr, binary.BigEndian, width)
+	binary.Write(ihdr, binary.BigEndian, height)
+	// 8 bit RGBA, default compression, filter and no interlace
+	ihdr.Write([]byte{8, 6, 0, 0, 0})
+
+	buf := &bytes.Buffer{}
+	buf.Write(pngSignature)
+	writePNGChunk(buf, "IHDR", ihdr.Bytes())
+	return buf.Bytes()
+}
+
+func TestCheckLimitsPixelBomb(t *testing.T) {
+	err := CheckLimits(pngHeader(50000, 50000), config.ImageLimitsCfg{MaxMegapixels: 100, MaxMemoryMB: 1024})
+
+	assert.NotNil(t, err)
+	assert.Equal(t, 422, err.(LimitError).StatusCode)
+}
+
+func TestCheckLimitsMemory(t *testing.T) {
+	err := CheckLimits(pngHeader(10000, 10000), config.ImageLimitsCfg{MaxMegapixels: 200, MaxMemoryMB: 128})
+
+	assert.NotNil(t, err)
+	assert.Equal(t, 413, err.(LimitError).StatusCode)
+}
+
+func TestCheckLimitsDimensions(t *testing.T) {
+	limits := config.ImageLimitsCfg{MaxWidth: 1000, MaxHeight: 1000}
+
+	assert.Nil(t, CheckLimits(pngHeader(800, 600), limits))
+	assert.Equal(t, 422, CheckLimits(pngHeader(1200, 600), limits).(LimitError).StatusCode)
+	assert.Equal(t, 422, CheckLimits(pngHeader(600, 1200), limits).(LimitError).StatusCode)
+}
+
+func TestEstimateMemory(t *testing.T) {
+	assert.Equal(t, int64(2*100*50*4), EstimateMemory(pngHeader(100, 50)))
+	assert.Equal(t, int64(0), EstimateMemory([]byte("not an image")))
+}
+
+// animatedGIF create gif with given number of 1x1 frames
+func animatedGIF(frames int) []byte {
+	anim := &gif.GIF{}
+	for i := 0; i < frames; i++ {
+		anim.Image = append(anim.Image, image.NewPaletted(image.Rect(0, 0, 1, 1), color.Palette{color.Black, color.White}))
+		anim.Delay = append(anim.Delay, 10)
+	}
+
+	buf := &bytes.Buffer{}
+	gif.EncodeAll(buf, anim)
+	return buf.Bytes()
+}
+
+func TestCheckLimitsFrames(t *testing.T) {
+	limits := config.ImageLimitsCfg{MaxFrames: 5}
+
+	assert.Equal(t, 10, countGIFFrames(animatedGIF(10)))
+	assert.Nil(t, CheckLimits(animatedGIF(5), limits))
+
+	err := CheckLimits(animatedGIF(6), limits)
+	assert.NotNil(t, err)
+	assert.Equal(t, 422, err.(LimitError).StatusCode)
+	assert.Contains(t, err.Error(), "6 frames, limit is 5")
+}
+
+func TestCheckLimitsPages(t *testing.T) {
+	pdf := []byte("%PDF-1.4\n1 0 obj << /Type /Catalog /Pages 2 0 R >> endobj\n2 0 obj << /Type /Pages /Kids [3 0 R 4 0 R 5 0 R] /Count 3 >> endobj\n" +
+		"3 0 obj << /Type /Page /Parent 2 0 R >> endobj\n4 0 obj << /Type /Page /Parent 2 0 R >> endobj\n5 0 obj << /Type /Page /Parent 2 0 R >> endobj\n%%EOF")
+
+	assert.Equal(t, 3, countPDFPages(pdf))
+	assert.Nil(t, CheckLimits(pdf, config.ImageLimitsCfg{MaxPages: 3}))
+
+	err := CheckLimits(pdf, config.ImageLimitsCfg{MaxPages: 2})
+	assert.NotNil(t, err)
+	assert.Equal(t, 422, err.(LimitError).StatusCode)
+	assert.Equal(t, "pdf document has 3 pages, limit is 2", err.Error())
+}
+
+func TestCheckLimitsContentType(t *testing.T) {
+	limits := config.ImageLimitsCfg{MaxWidth: 1000, ContentTypes: map[string]config.ImageLimitsCfg{
+		"image/png": {MaxWidth: 500},
+		"image/*":   {MaxWidth: 2000},
+	}}
+
+	err := CheckLimits(pngHeader(800, 600), limits)
+	assert.NotNil(t, err)
+	assert.Equal(t, "image/png width 800 exceeds limit 500", err.Error())
+
+	assert.Nil(t, CheckLimits(animatedGIF(1), limits))
+}
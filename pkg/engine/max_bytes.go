@@ -0,0 +1,60 @@
+//go:build cgo
+// +build cgo
+
+package engine
+
+import (
+	"errors"
+	"strconv"
+
+	"gopkg.in/h2non/bimg.v1"
+)
+
+const (
+	maxBytesMinQuality     = 20 // quality below which image is not re-encoded
+	maxBytesDefaultQuality = 80 // quality assumed when transform doesn't set it
+)
+
+// errMaxBytes returned when image can't fit in size budget
+var errMaxBytes = errors.New("unable to fit image in max bytes")
+
+// fitMaxBytes re-encode image with the highest quality for which it fits in maxBytes
+// quality is quality of given image (0 when unknown)
+func fitMaxBytes(buf []byte, maxBytes int, quality int) ([]byte, int, error) {
+	if len(buf) <= maxBytes {
+		return buf, quality, nil
+	}
+
+	imageType := bimg.DetermineImageType(buf)
+	if !autoQualityTypes[imageType] {
+		return nil, 0, errors.New(errMaxBytes.Error() + ": lossless format " + bimg.ImageTypeName(imageType))
+	}
+
+	if quality == 0 {
+		quality = maxBytesDefaultQuality
+	}
+
+	var best []byte
+	bestQuality := 0
+	low, high := maxBytesMinQuality, quality-1
+	for low <= high {
+		q := (low + high) / 2
+		candidate, err := bimg.NewImage(buf).Process(bimg.Options{Quality: q, Type: imageType, NoAutoRotate: true})
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if len(candidate) <= maxBytes {
+			best, bestQuality = candidate, q
+			low = q + 1
+		} else {
+			high = q - 1
+		}
+	}
+
+	if best == nil {
+		return nil, 0, errors.New(errMaxBytes.Error() + ": " + strconv.Itoa(maxBytes))
+	}
+
+	return best, bestQuality, nil
+}
@@ -0,0 +1,60 @@
+package engine
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/aldor007/mort/pkg/response"
+)
+
+// MetadataContentType content type of metadata response
+const MetadataContentType = "application/json"
+
+// ImageMetadata describe image returned for metadata requests
+type ImageMetadata struct {
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+	Format      string `json:"format"`
+	ColorSpace  string `json:"colorSpace,omitempty"`
+	Alpha       bool   `json:"alpha"`
+	Orientation int    `json:"orientation,omitempty"`
+	HasICC      bool   `json:"hasICC"`
+	EXIF        *EXIF  `json:"exif,omitempty"`
+}
+
+// Metadata read dimensions, format, EXIF and ICC presence of image
+// GPS location is removed from result unless withGPS is true
+func Metadata(buf []byte, withGPS bool) (ImageMetadata, error) {
+	result, err := readMetadata(buf)
+	if err != nil {
+		return ImageMetadata{}, err
+	}
+
+	if exif, err := parseEXIF(buf); err == nil {
+		if !withGPS {
+			exif.GPS = nil
+		}
+		result.EXIF = exif
+	}
+
+	return result, nil
+}
+
+// NewMetadataResponse create JSON response with metadata of image
+func NewMetadataResponse(buf []byte, withGPS bool) (*response.Response, error) {
+	meta, err := Metadata(buf, withGPS)
+	if err != nil {
+		return response.NewError(400, err), err
+	}
+
+	body, err := json.Marshal(meta)
+	if err != nil {
+		return response.NewError(500, err), err
+	}
+
+	res := response.NewBuf(200, body)
+	res.SetContentType(MetadataContentType)
+	res.Set("x-amz-meta-public-width", strconv.Itoa(meta.Width))
+	res.Set("x-amz-meta-public-height", strconv.Itoa(meta.Height))
+	return res, nil
+}
@@ -0,0 +1,173 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"sort"
+
+	"github.com/aldor007/mort/pkg/response"
+)
+
+// MaxPaletteColors max number of colors which can be requested in palette
+const MaxPaletteColors = 32
+
+// paletteSampleSize image is downscaled to this size before colors are counted
+const paletteSampleSize = 100
+
+// PaletteColor single dominant color of image
+type PaletteColor struct {
+	Hex        string  `json:"hex"`
+	Population float64 `json:"population"` // fraction of pixels represented by color
+}
+
+// colorBox group of pixels used by median cut
+type colorBox struct {
+	pixels [][3]uint8
+}
+
+// channelRange returns channel with the biggest spread of values and size of spread
+func (b colorBox) channelRange() (int, int) {
+	min := [3]int{255, 255, 255}
+	max := [3]int{}
+	for _, p := range b.pixels {
+		for c := 0; c < 3; c++ {
+			v := int(p[c])
+			if v < min[c] {
+				min[c] = v
+			}
+			if v > max[c] {
+				max[c] = v
+			}
+		}
+	}
+
+	channel := 0
+	for c := 1; c < 3; c++ {
+		if max[c]-min[c] > max[channel]-min[channel] {
+			channel = c
+		}
+	}
+
+	return channel, max[channel] - min[channel]
+}
+
+func (b colorBox) average() [3]uint8 {
+	var sum [3]int
+	for _, p := range b.pixels {
+		for c := 0; c < 3; c++ {
+			sum[c] += int(p[c])
+		}
+	}
+
+	n := len(b.pixels)
+	return [3]uint8{uint8((sum[0] + n/2) / n), uint8((sum[1] + n/2) / n), uint8((sum[2] + n/2) / n)}
+}
+
+// medianCut quantize pixels to at most n colors sorted by population
+// Boxes are split in the middle of the widest channel range
+func medianCut(pixels [][3]uint8, n int) []PaletteColor {
+	if len(pixels) == 0 || n < 1 {
+		return []PaletteColor{}
+	}
+
+	boxes := []colorBox{{pixels: pixels}}
+	for len(boxes) < n {
+		// split box with the widest channel range
+		idx, channel, spread := -1, 0, 0
+		for i, b := range boxes {
+			c, s := b.channelRange()
+			if len(b.pixels) > 1 && s > spread {
+				idx, channel, spread = i, c, s
+			}
+		}
+
+		if idx < 0 {
+			break
+		}
+
+		box := boxes[idx].pixels
+		sort.Slice(box, func(i, j int) bool {
+			return box[i][channel] < box[j][channel]
+		})
+
+		// split in the middle of channel range, so distinct colors are not mixed
+		threshold := (int(box[0][channel]) + int(box[len(box)-1][channel])) / 2
+		mid := sort.Search(len(box), func(i int) bool {
+			return int(box[i][channel]) > threshold
+		})
+		boxes[idx] = colorBox{pixels: box[:mid]}
+		boxes = append(boxes, colorBox{pixels: box[mid:]})
+	}
+
+	sort.SliceStable(boxes, func(i, j int) bool {
+		return len(boxes[i].pixels) > len(boxes[j].pixels)
+	})
+
+	result := make([]PaletteColor, 0, len(boxes))
+	for _, b := range boxes {
+		avg := b.average()
+		result = append(result, PaletteColor{
+			Hex:        fmt.Sprintf("#%02x%02x%02x", avg[0], avg[1], avg[2]),
+			Population: float64(len(b.pixels)) / float64(len(pixels)),
+		})
+	}
+
+	return result
+}
+
+// opaquePixels returns colors of pixels which are not transparent
+func opaquePixels(img image.Image) [][3]uint8 {
+	bounds := img.Bounds()
+	pixels := make([][3]uint8, 0, bounds.Dx()*bounds.Dy())
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, a := img.At(x, y).RGBA()
+			if a < 0x8000 {
+				continue
+			}
+			// colors are premultiplied by alpha
+			pixels = append(pixels, [3]uint8{uint8(r * 0xffff / a >> 8), uint8(g * 0xffff / a >> 8), uint8(b * 0xffff / a >> 8)})
+		}
+	}
+
+	return pixels
+}
+
+// Palette returns n dominant colors of image
+// Image is downscaled before colors are counted
+func Palette(buf []byte, n int) ([]PaletteColor, error) {
+	if n < 1 || n > MaxPaletteColors {
+		return nil, fmt.Errorf("palette size should be between 1 and %d", MaxPaletteColors)
+	}
+
+	img, err := decodeSample(buf, paletteSampleSize, paletteSampleSize, false, false)
+	if err != nil {
+		return nil, err
+	}
+
+	pixels := opaquePixels(img)
+	if len(pixels) == 0 {
+		return nil, errors.New("image is fully transparent")
+	}
+
+	return medianCut(pixels, n), nil
+}
+
+// NewPaletteResponse create JSON response with dominant colors of image
+func NewPaletteResponse(buf []byte, n int) (*response.Response, error) {
+	colors, err := Palette(buf, n)
+	if err != nil {
+		return response.NewError(400, err), err
+	}
+
+	body, err := json.Marshal(map[string]interface{}{"colors": colors})
+	if err != nil {
+		return response.NewError(500, err), err
+	}
+
+	res := response.NewBuf(200, body)
+	res.SetContentType(MetadataContentType)
+	return res, nil
+}
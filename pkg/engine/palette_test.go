@@ -0,0 +1,53 @@
+package engine
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMedianCut(t *testing.T) {
+	pixels := make([][3]uint8, 0, 100)
+	for i := 0; i < 75; i++ {
+		pixels = append(pixels, [3]uint8{255, 0, 0})
+	}
+	for i := 0; i < 25; i++ {
+		pixels = append(pixels, [3]uint8{0, 0, 255})
+	}
+
+	colors := medianCut(pixels, 2)
+
+	assert.Equal(t, 2, len(colors))
+	assert.Equal(t, "#ff0000", colors[0].Hex)
+	assert.InDelta(t, 0.75, colors[0].Population, 0.0001)
+	assert.Equal(t, "#0000ff", colors[1].Hex)
+	assert.InDelta(t, 0.25, colors[1].Population, 0.0001)
+}
+
+func TestMedianCutSingleColor(t *testing.T) {
+	pixels := [][3]uint8{{10, 20, 30}, {10, 20, 30}, {10, 20, 30}}
+
+	colors := medianCut(pixels, 5)
+
+	assert.Equal(t, 1, len(colors))
+	assert.Equal(t, "#0a141e", colors[0].Hex)
+	assert.InDelta(t, 1, colors[0].Population, 0.0001)
+}
+
+func TestOpaquePixels(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	img.Set(0, 0, color.NRGBA{R: 200, G: 100, B: 50, A: 255})
+	img.Set(1, 0, color.NRGBA{R: 10, G: 10, B: 10, A: 0})
+
+	pixels := opaquePixels(img)
+
+	assert.Equal(t, [][3]uint8{{200, 100, 50}}, pixels)
+}
+
+func TestPaletteInvalidSize(t *testing.T) {
+	_, err := Palette([]byte{}, MaxPaletteColors+1)
+
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,51 @@
+//go:build cgo
+// +build cgo
+
+package engine
+
+/*
+#cgo pkg-config: vips
+#include <stdlib.h>
+#include <vips/vips.h>
+
+static int mort_pdf_page(void *buf, size_t len, int page, double dpi, void **out, size_t *outLen) {
+	VipsImage *image;
+	int err;
+
+	if (vips_pdfload_buffer(buf, len, &image, "page", page, "dpi", dpi, NULL)) {
+		return -1;
+	}
+
+	err = vips_pngsave_buffer(image, out, outLen, NULL);
+	g_object_unref(image);
+	return err;
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"strings"
+	"unsafe"
+)
+
+// pdfDPI resolution in which pdf pages are rendered
+const pdfDPI = 150
+
+// renderPDFPage render given page (counted from 0) of pdf document to png using libvips (poppler or pdfium)
+func renderPDFPage(buf []byte, page int) ([]byte, error) {
+	if len(buf) == 0 {
+		return nil, errors.New("empty pdf")
+	}
+
+	var out unsafe.Pointer
+	var outLen C.size_t
+	if C.mort_pdf_page(unsafe.Pointer(&buf[0]), C.size_t(len(buf)), C.int(page), C.double(pdfDPI), &out, &outLen) != 0 {
+		msg := strings.TrimSpace(C.GoString(C.vips_error_buffer()))
+		C.vips_error_clear()
+		return nil, errors.New("unable to render pdf page: " + msg)
+	}
+
+	defer C.g_free(C.gpointer(out))
+	return C.GoBytes(out, C.int(outLen)), nil
+}
@@ -0,0 +1,16 @@
+package engine
+
+import (
+	"github.com/aldor007/mort/pkg/phash"
+)
+
+// PHash compute perceptual hash of image
+// Image is downscaled and converted to grayscale before hashing
+func PHash(buf []byte) (uint64, error) {
+	img, err := decodeSample(buf, phash.Size, phash.Size, true, true)
+	if err != nil {
+		return 0, err
+	}
+
+	return phash.FromImage(img), nil
+}
@@ -0,0 +1,106 @@
+package engine
+
+import (
+	"errors"
+	"image"
+	"image/draw"
+	"sync"
+
+	"github.com/aldor007/mort/pkg/monitoring"
+	"github.com/aldor007/mort/pkg/transforms"
+	"go.uber.org/zap"
+)
+
+// FaceDetector returns areas of faces found in image
+type FaceDetector func(img image.Image) ([]image.Rectangle, error)
+
+// ErrNoFaceDetector returned when faces should be pixelated but no detector is registered
+var ErrNoFaceDetector = errors.New("face detector is not registered")
+
+var faceDetectorLock sync.RWMutex
+var faceDetector FaceDetector
+
+// RegisterFaceDetector set detector used for pixelating of faces
+// It can be called by external plugin or custom build of mort, mort doesn't ship any detector
+func RegisterFaceDetector(detector FaceDetector) {
+	faceDetectorLock.Lock()
+	defer faceDetectorLock.Unlock()
+	faceDetector = detector
+}
+
+func getFaceDetector() FaceDetector {
+	faceDetectorLock.RLock()
+	defer faceDetectorLock.RUnlock()
+	return faceDetector
+}
+
+// pixelateAreas returns areas of image which should be pixelated, whole image is returned when no regions are given
+// When faces can't be detected whole image is pixelated, so nothing is revealed by failure of detector
+func pixelateAreas(img image.Image, p transforms.PixelateParams) []image.Rectangle {
+	b := img.Bounds()
+	if len(p.Regions) == 0 && !p.Faces {
+		return []image.Rectangle{b}
+	}
+
+	areas := make([]image.Rectangle, 0, len(p.Regions))
+	for _, r := range p.Regions {
+		areas = append(areas, r.Add(b.Min).Intersect(b))
+	}
+
+	if p.Faces {
+		detector := getFaceDetector()
+		if detector == nil {
+			monitoring.Log().Warn("Engine unable to pixelate faces", zap.Error(ErrNoFaceDetector))
+			return []image.Rectangle{b}
+		}
+
+		faces, err := detector(img)
+		if err != nil {
+			monitoring.Log().Warn("Engine unable to detect faces", zap.Error(err))
+			return []image.Rectangle{b}
+		}
+
+		for _, f := range faces {
+			areas = append(areas, f.Intersect(b))
+		}
+	}
+
+	return areas
+}
+
+// pixelate replace each block of areas with its average color, blocks are aligned to top left corner of area
+func pixelate(img image.Image, areas []image.Rectangle, blockSize int) *image.NRGBA {
+	b := img.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Src)
+
+	for _, area := range areas {
+		area = area.Sub(b.Min).Intersect(dst.Bounds())
+		for y := area.Min.Y; y < area.Max.Y; y += blockSize {
+			for x := area.Min.X; x < area.Max.X; x += blockSize {
+				block := image.Rect(x, y, x+blockSize, y+blockSize).Intersect(area)
+				var sum [4]int
+				for by := block.Min.Y; by < block.Max.Y; by++ {
+					for bx := block.Min.X; bx < block.Max.X; bx++ {
+						o := dst.PixOffset(bx, by)
+						for c := 0; c < 4; c++ {
+							sum[c] += int(dst.Pix[o+c])
+						}
+					}
+				}
+
+				count := block.Dx() * block.Dy()
+				for by := block.Min.Y; by < block.Max.Y; by++ {
+					for bx := block.Min.X; bx < block.Max.X; bx++ {
+						o := dst.PixOffset(bx, by)
+						for c := 0; c < 4; c++ {
+							dst.Pix[o+c] = uint8((sum[c] + count/2) / count)
+						}
+					}
+				}
+			}
+		}
+	}
+
+	return dst
+}
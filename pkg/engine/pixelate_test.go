@@ -0,0 +1,57 @@
+package engine
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/aldor007/mort/pkg/transforms"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPixelate(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 4, 2))
+	img.SetGray(0, 0, color.Gray{Y: 100})
+	img.SetGray(1, 1, color.Gray{Y: 200})
+	img.SetGray(3, 0, color.Gray{Y: 80})
+
+	res := pixelate(img, []image.Rectangle{image.Rect(0, 0, 2, 2)}, 2)
+	assert.Equal(t, color.NRGBA{R: 75, G: 75, B: 75, A: 255}, res.At(0, 0))
+	assert.Equal(t, color.NRGBA{R: 75, G: 75, B: 75, A: 255}, res.At(1, 1))
+	assert.Equal(t, color.NRGBA{R: 80, G: 80, B: 80, A: 255}, res.At(3, 0), "pixels outside of area shouldn't change")
+
+	res = pixelate(img, []image.Rectangle{image.Rect(1, 0, 4, 1)}, 2)
+	assert.Equal(t, color.NRGBA{R: 100, G: 100, B: 100, A: 255}, res.At(0, 0))
+	assert.Equal(t, color.NRGBA{R: 0, G: 0, B: 0, A: 255}, res.At(1, 0))
+	assert.Equal(t, color.NRGBA{R: 80, G: 80, B: 80, A: 255}, res.At(3, 0), "last block is cut to area")
+}
+
+func TestPixelateAreas(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 100, 50))
+
+	var whole transforms.Transforms
+	whole.Pixelate(8, nil)
+	assert.Equal(t, []image.Rectangle{img.Bounds()}, pixelateAreas(img, *whole.Params().Pixelate))
+
+	var regions transforms.Transforms
+	regions.Pixelate(8, []string{"10,10,20,20", "90,40,20,20"})
+	assert.Equal(t, []image.Rectangle{image.Rect(10, 10, 30, 30), image.Rect(90, 40, 100, 50)}, pixelateAreas(img, *regions.Params().Pixelate))
+
+	var faces transforms.Transforms
+	faces.Pixelate(8, []string{transforms.PixelateFaces})
+	assert.Equal(t, ErrNoFaceDetector, Check(DefaultEngine, []transforms.Transforms{faces}))
+
+	RegisterFaceDetector(func(img image.Image) ([]image.Rectangle, error) {
+		return []image.Rectangle{image.Rect(40, 0, 60, 20)}, nil
+	})
+	defer RegisterFaceDetector(nil)
+
+	assert.Nil(t, Check(DefaultEngine, []transforms.Transforms{faces}))
+	assert.Equal(t, []image.Rectangle{image.Rect(40, 0, 60, 20)}, pixelateAreas(img, *faces.Params().Pixelate))
+
+	RegisterFaceDetector(func(img image.Image) ([]image.Rectangle, error) {
+		return nil, errors.New("detector failed")
+	})
+	assert.Equal(t, []image.Rectangle{img.Bounds()}, pixelateAreas(img, *faces.Params().Pixelate), "whole image should be pixelated when detector fails")
+}
@@ -0,0 +1,144 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// rawContentTypes content types of camera RAW formats
+var rawContentTypes = map[string]bool{
+	"image/x-adobe-dng":     true,
+	"image/x-canon-cr2":     true,
+	"image/x-nikon-nef":     true,
+	"image/x-sony-arw":      true,
+	"image/x-olympus-orf":   true,
+	"image/x-panasonic-rw2": true,
+	"image/x-fuji-raf":      true,
+}
+
+// minRawPreviewSize embedded previews smaller than this are only thumbnails and libraw is preferred for them
+const minRawPreviewSize = 64 * 1024
+
+// IsRAW check if content type is one of camera RAW formats
+func IsRAW(contentType string) bool {
+	return rawContentTypes[strings.TrimSpace(strings.Split(contentType, ";")[0])]
+}
+
+// rawPreview returns image that can be processed by libvips for RAW file
+// It uses the biggest embedded JPEG preview and falls back to libraw (dcraw_emu) when preview is missing or too small
+func rawPreview(ctx context.Context, buf []byte) ([]byte, error) {
+	preview := largestEmbeddedJPEG(buf)
+	if len(preview) >= minRawPreviewSize {
+		return preview, nil
+	}
+
+	if decoded, err := decodeWithLibraw(ctx, buf); err == nil {
+		return decoded, nil
+	}
+
+	if preview != nil {
+		return preview, nil
+	}
+
+	return nil, errors.New("unable to find preview in raw file")
+}
+
+// largestEmbeddedJPEG scan buffer for embedded JPEG streams and returns the biggest one
+func largestEmbeddedJPEG(buf []byte) []byte {
+	var best []byte
+	soi := []byte{0xFF, 0xD8, 0xFF}
+	offset := 0
+	for {
+		i := bytes.Index(buf[offset:], soi)
+		if i < 0 {
+			return best
+		}
+
+		start := offset + i
+		end := jpegEnd(buf, start)
+		if end > 0 {
+			if end-start > len(best) {
+				best = buf[start:end]
+			}
+			offset = end
+		} else {
+			offset = start + len(soi)
+		}
+	}
+}
+
+// jpegEnd walks through JPEG segments starting at SOI and returns offset after EOI marker or -1
+func jpegEnd(buf []byte, start int) int {
+	pos := start + 2
+	for pos+4 <= len(buf) {
+		if buf[pos] != 0xFF {
+			return -1
+		}
+
+		marker := buf[pos+1]
+		switch {
+		case marker == 0xD9:
+			return pos + 2
+		case marker == 0xFF:
+			// fill bytes
+			pos++
+			continue
+		case marker >= 0xD0 && marker <= 0xD7, marker == 0x01:
+			// markers without length
+			pos += 2
+			continue
+		}
+
+		segLen := int(binary.BigEndian.Uint16(buf[pos+2 : pos+4]))
+		if segLen < 2 {
+			return -1
+		}
+		pos += 2 + segLen
+
+		if marker == 0xDA {
+			// entropy coded data ends at first marker other than RSTn and stuffed 0xFF00
+			for pos+1 < len(buf) {
+				if buf[pos] == 0xFF && buf[pos+1] != 0x00 && (buf[pos+1] < 0xD0 || buf[pos+1] > 0xD7) {
+					break
+				}
+				pos++
+			}
+		}
+	}
+
+	return -1
+}
+
+// decodeWithLibraw convert RAW file to TIFF using libraw dcraw_emu (or dcraw) binary when it is installed
+func decodeWithLibraw(ctx context.Context, buf []byte) ([]byte, error) {
+	var args []string
+	tool, err := exec.LookPath("dcraw_emu")
+	if err == nil {
+		args = []string{"-w", "-T", "-Z", "-"}
+	} else if tool, err = exec.LookPath("dcraw"); err == nil {
+		args = []string{"-c", "-w", "-T"}
+	} else {
+		return nil, errors.New("libraw is not installed")
+	}
+
+	// both tools read only from files
+	f, err := ioutil.TempFile("", "mort-raw-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.Write(buf)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return exec.CommandContext(ctx, tool, append(args, f.Name())...).Output()
+}
@@ -0,0 +1,35 @@
+package engine
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func fakeJPEG(dataLen int) []byte {
+	buf := []byte{0xFF, 0xD8, 0xFF, 0xE0, 0x00, 0x04, 0xFF, 0xD9, 0xFF, 0xDA, 0x00, 0x03, 0x01}
+	buf = append(buf, bytes.Repeat([]byte{0x10, 0xFF, 0x00, 0xFF, 0xD0}, dataLen)...)
+	return append(buf, 0xFF, 0xD9)
+}
+
+func TestLargestEmbeddedJPEG(t *testing.T) {
+	small := fakeJPEG(2)
+	big := fakeJPEG(20)
+	raw := append([]byte("II*\x00garbage\xFF\xD8\xFF"), small...)
+	raw = append(raw, []byte("more garbage")...)
+	raw = append(raw, big...)
+	raw = append(raw, []byte("end")...)
+
+	assert.Equal(t, big, largestEmbeddedJPEG(raw))
+}
+
+func TestLargestEmbeddedJPEG_NoPreview(t *testing.T) {
+	assert.Nil(t, largestEmbeddedJPEG([]byte("II*\x00\xFF\xD8\xFF\xE0")))
+}
+
+func TestIsRAW(t *testing.T) {
+	assert.True(t, IsRAW("image/x-adobe-dng"))
+	assert.True(t, IsRAW("image/x-canon-cr2; charset=binary"))
+	assert.False(t, IsRAW("image/tiff"))
+}
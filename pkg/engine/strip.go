@@ -0,0 +1,270 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"sort"
+
+	"github.com/aldor007/mort/pkg/transforms"
+)
+
+var (
+	exifHeader   = []byte("Exif\x00\x00")
+	iccHeader    = []byte("ICC_PROFILE\x00")
+	pngSignature = []byte("\x89PNG\r\n\x1a\n")
+	gpsMarker    = []byte("GPS")
+)
+
+// selectiveStripFormats output formats for which metadata can be stripped selectively
+var selectiveStripFormats = map[string]bool{
+	"jpeg": true,
+	"jpg":  true,
+	"png":  true,
+}
+
+// keepCopyrightTags EXIF tags preserved by StripKeepCopyright mode
+var keepCopyrightTags = []uint16{tagOrientation, tagArtist, tagCopyright}
+
+// stripParams returns parameters of last transform which strips metadata selectively
+func stripParams(trans []transforms.Transforms) (transforms.Params, bool) {
+	var result transforms.Params
+	found := false
+	for _, t := range trans {
+		p := t.Params()
+		if p.Strip {
+			result, found = p, p.StripMode != ""
+		}
+	}
+
+	return result, found
+}
+
+// stripMetadata remove metadata from encoded JPEG or PNG according to strip mode
+// In StripGPS mode only GPS location is removed, StripKeepCopyright keeps orientation, artist and copyright,
+// ICC profile is kept for StripGPS or when keepICC is set
+func stripMetadata(buf []byte, mode string, keepICC bool) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(buf, []byte{0xFF, 0xD8}):
+		return stripJPEG(buf, mode, keepICC)
+	case bytes.HasPrefix(buf, pngSignature):
+		return stripPNG(buf, mode, keepICC)
+	}
+
+	return buf, errors.New("strip: selective strip is supported only for jpeg and png")
+}
+
+func stripJPEG(buf []byte, mode string, keepICC bool) ([]byte, error) {
+	out := bytes.NewBuffer(make([]byte, 0, len(buf)))
+	out.Write(buf[:2])
+	pos := 2
+	for pos+4 <= len(buf) {
+		if buf[pos] != 0xFF {
+			return buf, errors.New("strip: invalid jpeg marker")
+		}
+
+		marker := buf[pos+1]
+		if marker == 0xFF {
+			pos++
+			continue
+		}
+
+		if marker == 0xDA {
+			// metadata segments are placed before image data
+			out.Write(buf[pos:])
+			return out.Bytes(), nil
+		}
+
+		segLen := int(binary.BigEndian.Uint16(buf[pos+2 : pos+4]))
+		end := pos + 2 + segLen
+		if segLen < 2 || end > len(buf) {
+			return buf, errors.New("strip: truncated jpeg segment")
+		}
+
+		payload, keep := filterSegment(marker, buf[pos+4:end], mode, keepICC)
+		if keep {
+			out.Write([]byte{0xFF, marker})
+			binary.Write(out, binary.BigEndian, uint16(len(payload)+2))
+			out.Write(payload)
+		}
+		pos = end
+	}
+
+	return buf, errors.New("strip: missing jpeg image data")
+}
+
+// filterSegment decide if JPEG segment should be kept and returns its new payload
+func filterSegment(marker byte, payload []byte, mode string, keepICC bool) ([]byte, bool) {
+	switch {
+	case marker == 0xE1 && bytes.HasPrefix(payload, exifHeader):
+		tiff := filterEXIF(payload[len(exifHeader):], mode)
+		if tiff == nil {
+			return nil, false
+		}
+		return append(append([]byte{}, exifHeader...), tiff...), true
+	case marker == 0xE2 && bytes.HasPrefix(payload, iccHeader):
+		return payload, mode == transforms.StripGPS || keepICC
+	case marker == 0xE0, marker == 0xEE:
+		// JFIF and Adobe segments are needed for decoding
+		return payload, true
+	case marker > 0xE0 && marker <= 0xEF, marker == 0xFE:
+		// XMP, IPTC and comments may contain location as well
+		return payload, mode == transforms.StripGPS && !bytes.Contains(payload, gpsMarker)
+	}
+
+	return payload, true
+}
+
+func stripPNG(buf []byte, mode string, keepICC bool) ([]byte, error) {
+	out := bytes.NewBuffer(make([]byte, 0, len(buf)))
+	out.Write(pngSignature)
+	pos := len(pngSignature)
+	for pos+12 <= len(buf) {
+		chunkLen := int(binary.BigEndian.Uint32(buf[pos : pos+4]))
+		end := pos + 12 + chunkLen
+		if end > len(buf) {
+			return buf, errors.New("strip: truncated png chunk")
+		}
+
+		typ := string(buf[pos+4 : pos+8])
+		data := buf[pos+8 : pos+8+chunkLen]
+		switch typ {
+		case "eXIf":
+			if tiff := filterEXIF(data, mode); tiff != nil {
+				writePNGChunk(out, typ, tiff)
+			}
+		case "iCCP":
+			if mode == transforms.StripGPS || keepICC {
+				out.Write(buf[pos:end])
+			}
+		case "tEXt", "iTXt", "zTXt":
+			// compressed text can't be checked for location
+			if mode == transforms.StripGPS && typ != "zTXt" && !bytes.Contains(data, gpsMarker) {
+				out.Write(buf[pos:end])
+			}
+		default:
+			out.Write(buf[pos:end])
+		}
+
+		if typ == "IEND" {
+			return out.Bytes(), nil
+		}
+		pos = end
+	}
+
+	return buf, errors.New("strip: missing png end")
+}
+
+func writePNGChunk(out *bytes.Buffer, typ string, data []byte) {
+	binary.Write(out, binary.BigEndian, uint32(len(data)))
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(typ))
+	crc.Write(data)
+	out.WriteString(typ)
+	out.Write(data)
+	binary.Write(out, binary.BigEndian, crc.Sum32())
+}
+
+// filterEXIF returns EXIF TIFF structure with removed data or nil when whole EXIF should be dropped
+func filterEXIF(tiff []byte, mode string) []byte {
+	r, offset, err := newTiffReader(append([]byte{}, tiff...))
+	if err != nil {
+		return nil
+	}
+
+	ifd0, err := r.ifd(offset)
+	if err != nil {
+		return nil
+	}
+
+	switch mode {
+	case transforms.StripGPS:
+		gps, ok := ifd0[tagGPSIFD]
+		if !ok {
+			return r.buf
+		}
+
+		// GPS directory is cleared in place, so offsets of other data stay valid
+		gpsOffset := r.uint(gps)
+		entries, err := r.ifd(gpsOffset)
+		if err != nil {
+			return nil
+		}
+
+		for _, e := range entries {
+			for i := range e.value {
+				e.value[i] = 0
+			}
+		}
+
+		count := int(r.order.Uint16(r.buf[gpsOffset:]))
+		end := gpsOffset + 2 + count*12 + 4
+		if end > len(r.buf) {
+			end = len(r.buf)
+		}
+
+		for i := gpsOffset; i < end; i++ {
+			r.buf[i] = 0
+		}
+
+		return r.buf
+	case transforms.StripKeepCopyright:
+		kept := make(map[uint16]tiffEntry)
+		for _, tag := range keepCopyrightTags {
+			if e, ok := ifd0[tag]; ok {
+				kept[tag] = e
+			}
+		}
+
+		if len(kept) == 0 {
+			return nil
+		}
+
+		return writeTiff(r.order, kept)
+	}
+
+	return nil
+}
+
+// writeTiff create TIFF structure with single image file directory
+func writeTiff(order binary.ByteOrder, entries map[uint16]tiffEntry) []byte {
+	tags := make([]int, 0, len(entries))
+	for tag := range entries {
+		tags = append(tags, int(tag))
+	}
+	sort.Ints(tags)
+
+	out := &bytes.Buffer{}
+	if order == binary.LittleEndian {
+		out.WriteString("II*\x00")
+	} else {
+		out.WriteString("MM\x00*")
+	}
+	binary.Write(out, order, uint32(8))
+	binary.Write(out, order, uint16(len(tags)))
+
+	data := &bytes.Buffer{}
+	dataOffset := 8 + 2 + len(tags)*12 + 4
+	for _, tag := range tags {
+		e := entries[uint16(tag)]
+		binary.Write(out, order, uint16(tag))
+		binary.Write(out, order, e.typ)
+		binary.Write(out, order, uint32(e.count))
+		if len(e.value) <= 4 {
+			out.Write(e.value)
+			out.Write(make([]byte, 4-len(e.value)))
+		} else {
+			binary.Write(out, order, uint32(dataOffset+data.Len()))
+			data.Write(e.value)
+			if data.Len()%2 == 1 {
+				// values must start on word boundary
+				data.WriteByte(0)
+			}
+		}
+	}
+	binary.Write(out, order, uint32(0))
+	out.Write(data.Bytes())
+
+	return out.Bytes()
+}
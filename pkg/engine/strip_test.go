@@ -0,0 +1,111 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"testing"
+
+	"github.com/aldor007/mort/pkg/transforms"
+	"github.com/stretchr/testify/assert"
+)
+
+func jpegSegment(marker byte, payload []byte) []byte {
+	buf := &bytes.Buffer{}
+	buf.Write([]byte{0xFF, marker})
+	binary.Write(buf, binary.BigEndian, uint16(len(payload)+2))
+	buf.Write(payload)
+	return buf.Bytes()
+}
+
+func testJPEGWithMetadata() []byte {
+	buf := &bytes.Buffer{}
+	buf.Write([]byte{0xFF, 0xD8})
+	buf.Write(jpegSegment(0xE0, []byte("JFIF\x00\x01\x01")))
+	buf.Write(jpegSegment(0xE1, append([]byte("Exif\x00\x00"), testEXIF()...)))
+	buf.Write(jpegSegment(0xE1, []byte("http://ns.adobe.com/xap/1.0/\x00<exif:GPSLatitude>50,30N</exif:GPSLatitude>")))
+	buf.Write(jpegSegment(0xE2, []byte("ICC_PROFILE\x00\x01\x01profile")))
+	buf.Write([]byte{0xFF, 0xDA, 0x00, 0x02, 0x01, 0x02, 0xFF, 0xD9})
+	return buf.Bytes()
+}
+
+func TestStripGPS(t *testing.T) {
+	buf, err := stripMetadata(testJPEGWithMetadata(), transforms.StripGPS, false)
+	assert.Nil(t, err)
+
+	exif, err := parseEXIF(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "Canon", exif.Make)
+	assert.Equal(t, "John Doe", exif.Copyright)
+	assert.Nil(t, exif.GPS)
+
+	assert.True(t, bytes.Contains(buf, []byte("ICC_PROFILE")))
+	assert.True(t, bytes.Contains(buf, []byte("JFIF")))
+	assert.False(t, bytes.Contains(buf, []byte("GPSLatitude")))
+	assert.True(t, bytes.HasSuffix(buf, []byte{0xFF, 0xDA, 0x00, 0x02, 0x01, 0x02, 0xFF, 0xD9}))
+}
+
+func TestStripKeepCopyright(t *testing.T) {
+	buf, err := stripMetadata(testJPEGWithMetadata(), transforms.StripKeepCopyright, false)
+	assert.Nil(t, err)
+
+	exif, err := parseEXIF(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "", exif.Make)
+	assert.Equal(t, "John Doe", exif.Copyright)
+	assert.Equal(t, 6, exif.Orientation)
+	assert.Nil(t, exif.GPS)
+
+	assert.False(t, bytes.Contains(buf, []byte("ICC_PROFILE")))
+	assert.False(t, bytes.Contains(buf, []byte("GPSLatitude")))
+}
+
+func TestStripAllKeepICC(t *testing.T) {
+	buf, err := stripMetadata(testJPEGWithMetadata(), transforms.StripAll, true)
+	assert.Nil(t, err)
+
+	_, err = parseEXIF(buf)
+	assert.Equal(t, errNoEXIF, err)
+	assert.True(t, bytes.Contains(buf, []byte("ICC_PROFILE")))
+}
+
+func TestStripPNG(t *testing.T) {
+	chunk := func(typ string, data []byte) []byte {
+		buf := &bytes.Buffer{}
+		writePNGChunk(buf, typ, data)
+		return buf.Bytes()
+	}
+
+	buf := &bytes.Buffer{}
+	buf.Write(pngSignature)
+	buf.Write(chunk("IHDR", make([]byte, 13)))
+	buf.Write(chunk("iCCP", []byte("icc\x00\x00")))
+	buf.Write(chunk("eXIf", testEXIF()))
+	buf.Write(chunk("tEXt", []byte("Comment\x00hello")))
+	buf.Write(chunk("IEND", nil))
+
+	result, err := stripMetadata(buf.Bytes(), transforms.StripGPS, false)
+	assert.Nil(t, err)
+
+	exif, err := parseEXIF(result)
+	assert.Nil(t, err)
+	assert.Equal(t, "Canon", exif.Make)
+	assert.Nil(t, exif.GPS)
+	assert.True(t, bytes.Contains(result, []byte("iCCP")))
+	assert.True(t, bytes.Contains(result, []byte("hello")))
+
+	exifChunk := result[bytes.Index(result, []byte("eXIf"))-4:]
+	length := int(binary.BigEndian.Uint32(exifChunk))
+	assert.Equal(t, crc32.ChecksumIEEE(exifChunk[4:8+length]), binary.BigEndian.Uint32(exifChunk[8+length:]))
+
+	result, err = stripMetadata(buf.Bytes(), transforms.StripAll, false)
+	assert.Nil(t, err)
+	assert.False(t, bytes.Contains(result, []byte("iCCP")))
+	assert.False(t, bytes.Contains(result, []byte("eXIf")))
+	assert.False(t, bytes.Contains(result, []byte("hello")))
+}
+
+func TestStripUnsupportedFormat(t *testing.T) {
+	_, err := stripMetadata([]byte("RIFF\x00\x00\x00\x00WEBP"), transforms.StripGPS, false)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,279 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// SVGContentType content type of SVG images
+const SVGContentType = "image/svg+xml"
+
+// SVGSanitizerVersion is changed with rules of SanitizeSVG, it is part of ETag of sanitized images
+// so clients and caches don't keep output of older sanitizer
+const SVGSanitizerVersion = "2"
+
+var (
+	// cssEscape matches CSS escape sequences, they are decoded so they can't hide keywords
+	cssEscape = regexp.MustCompile(`\\([0-9a-fA-F]{1,6}\s?|.)`)
+	// cssImport matches @import rules which load external stylesheets
+	cssImport = regexp.MustCompile(`(?i)@import[^;]*;?`)
+	// cssURL matches url() references
+	cssURL = regexp.MustCompile(`(?i)url\s*\(([^)]*)\)`)
+	// cssUnsafe matches declarations and functions executing code in old browsers
+	cssUnsafe = regexp.MustCompile(`(?i)(-moz-binding|behavior)\s*:[^;}]*;?|expression\s*\(`)
+)
+
+// svgForbiddenElements elements removed (with content) from SVG during sanitization
+var svgForbiddenElements = map[string]bool{
+	"script":        true,
+	"foreignobject": true,
+	"iframe":        true,
+	"embed":         true,
+	"object":        true,
+	"handler":       true,
+	"listener":      true,
+}
+
+// IsSVG check if buffer contains SVG document
+func IsSVG(buf []byte) bool {
+	if len(buf) > 1024 {
+		buf = buf[:1024]
+	}
+
+	return bytes.Contains(bytes.ToLower(buf), []byte("<svg"))
+}
+
+// SanitizeSVG removes from SVG document scripts, event handlers, external javascript links and DTD
+// declarations so it can be safely served to browsers. Stylesheets and style attributes are sanitized by sanitizeCSS
+func SanitizeSVG(buf []byte) ([]byte, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(buf))
+	decoder.Strict = false
+	out := bytes.Buffer{}
+	out.Grow(len(buf))
+	skipDepth := 0
+	styleDepth := 0
+
+	for {
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			if skipDepth > 0 || svgForbiddenElements[strings.ToLower(t.Name.Local)] {
+				skipDepth++
+				continue
+			}
+
+			if strings.ToLower(t.Name.Local) == "style" {
+				styleDepth++
+			}
+
+			out.WriteString("<" + svgName(t.Name))
+			for _, attr := range t.Attr {
+				if !isSafeSVGAttr(attr) {
+					continue
+				}
+				value := attr.Value
+				if strings.ToLower(attr.Name.Local) == "style" || strings.Contains(strings.ToLower(value), "url") || strings.Contains(value, "\\") {
+					// presentation attributes (fill, filter, mask) can reference resources like style does
+					value = sanitizeCSS(value)
+				}
+				out.WriteString(" " + svgName(attr.Name) + "=\"")
+				xml.EscapeText(&out, []byte(value))
+				out.WriteString("\"")
+			}
+			out.WriteString(">")
+		case xml.EndElement:
+			if skipDepth > 0 {
+				skipDepth--
+				continue
+			}
+			if styleDepth > 0 && strings.ToLower(t.Name.Local) == "style" {
+				styleDepth--
+			}
+			out.WriteString("</" + svgName(t.Name) + ">")
+		case xml.CharData:
+			if skipDepth == 0 && styleDepth > 0 {
+				xml.EscapeText(&out, []byte(sanitizeCSS(string(t))))
+			} else if skipDepth == 0 {
+				xml.EscapeText(&out, t)
+			}
+		case xml.Comment:
+			// comments are dropped
+		case xml.ProcInst:
+			if t.Target == "xml" {
+				out.WriteString("<?xml " + string(t.Inst) + "?>")
+			}
+		case xml.Directive:
+			// DOCTYPE can define entities, dropping it protects against entity expansion
+		}
+	}
+
+	return out.Bytes(), nil
+}
+
+// svgWithSize set width and height of root svg element so image is rasterized at requested size
+// instead of being rasterized at its natural size and scaled afterwards
+// When cover is set image will cover whole requested area (it is used for crop)
+func svgWithSize(buf []byte, width, height int, cover bool) []byte {
+	decoder := xml.NewDecoder(bytes.NewReader(buf))
+	decoder.Strict = false
+	for {
+		offset := decoder.InputOffset()
+		token, err := decoder.RawToken()
+		if err != nil {
+			return buf
+		}
+
+		root, ok := token.(xml.StartElement)
+		if !ok || strings.ToLower(root.Name.Local) != "svg" {
+			continue
+		}
+
+		origWidth, origHeight := 0.0, 0.0
+		hasViewBox := false
+		attrs := make([]xml.Attr, 0, len(root.Attr)+1)
+		for _, attr := range root.Attr {
+			switch attr.Name.Local {
+			case "width":
+				origWidth = svgLength(attr.Value)
+				continue
+			case "height":
+				origHeight = svgLength(attr.Value)
+				continue
+			case "viewBox":
+				hasViewBox = true
+			}
+			attrs = append(attrs, attr)
+		}
+
+		if !hasViewBox && origWidth > 0 && origHeight > 0 {
+			attrs = append(attrs, xml.Attr{Name: xml.Name{Local: "viewBox"},
+				Value: "0 0 " + strconv.FormatFloat(origWidth, 'f', -1, 64) + " " + strconv.FormatFloat(origHeight, 'f', -1, 64)})
+		}
+
+		if origWidth > 0 && origHeight > 0 {
+			scaleW, scaleH := float64(width)/origWidth, float64(height)/origHeight
+			scale := math.Min(scaleW, scaleH)
+			if width == 0 || height == 0 || cover {
+				scale = math.Max(scaleW, scaleH)
+			}
+			width, height = int(math.Round(origWidth*scale)), int(math.Round(origHeight*scale))
+		}
+
+		if width != 0 {
+			attrs = append(attrs, xml.Attr{Name: xml.Name{Local: "width"}, Value: strconv.Itoa(width)})
+		}
+		if height != 0 {
+			attrs = append(attrs, xml.Attr{Name: xml.Name{Local: "height"}, Value: strconv.Itoa(height)})
+		}
+
+		end := decoder.InputOffset()
+		// self closing root tag is reported by decoder as start element followed by end element
+		selfClosing := bytes.HasSuffix(bytes.TrimSpace(buf[offset:end]), []byte("/>"))
+		out := bytes.Buffer{}
+		out.Write(buf[:offset])
+		out.WriteString("<" + svgName(root.Name))
+		for _, attr := range attrs {
+			out.WriteString(" " + svgName(attr.Name) + "=\"")
+			xml.EscapeText(&out, []byte(attr.Value))
+			out.WriteString("\"")
+		}
+		if selfClosing {
+			out.WriteString("/>")
+		} else {
+			out.WriteString(">")
+		}
+		out.Write(buf[end:])
+		return out.Bytes()
+	}
+}
+
+func isSafeSVGAttr(attr xml.Attr) bool {
+	name := strings.ToLower(attr.Name.Local)
+	if strings.HasPrefix(name, "on") {
+		return false
+	}
+
+	// animation elements (set, animate) can change href to javascript using to/values attributes
+	value := strings.ToLower(strings.Join(strings.Fields(attr.Value), ""))
+	if strings.Contains(value, "javascript:") || strings.Contains(value, "vbscript:") {
+		return false
+	}
+
+	if name == "href" || name == "src" {
+		// embedded svg documents can contain scripts
+		if strings.HasPrefix(value, "data:") && (!strings.HasPrefix(value, "data:image/") || strings.HasPrefix(value, "data:image/svg")) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// sanitizeCSS removes from stylesheet imports, code executed by old browsers and references to resources
+// other than fragments of document and raster data URIs, so styles can't load scripts or track viewers
+func sanitizeCSS(css string) string {
+	css = cssEscape.ReplaceAllStringFunc(css, unescapeCSS)
+	css = cssImport.ReplaceAllString(css, "")
+	css = cssUnsafe.ReplaceAllStringFunc(css, func(match string) string {
+		if strings.HasSuffix(match, "(") {
+			// expression(...) becomes plain parentheses which browsers ignore
+			return "("
+		}
+		return ""
+	})
+	return cssURL.ReplaceAllStringFunc(css, func(ref string) string {
+		target := strings.ToLower(strings.Trim(cssURL.FindStringSubmatch(ref)[1], " \t\n\r'\""))
+		if strings.HasPrefix(target, "#") || (strings.HasPrefix(target, "data:image/") && !strings.HasPrefix(target, "data:image/svg")) {
+			return ref
+		}
+
+		return "none"
+	})
+}
+
+// unescapeCSS returns character of CSS escape sequence
+func unescapeCSS(escape string) string {
+	code := strings.TrimSpace(escape[1:])
+	if r, err := strconv.ParseUint(code, 16, 32); err == nil && len(code) > 0 {
+		return string(rune(r))
+	}
+
+	return escape[1:]
+}
+
+func svgName(name xml.Name) string {
+	if name.Space != "" {
+		return name.Space + ":" + name.Local
+	}
+
+	return name.Local
+}
+
+// svgLength parse length attribute ignoring unit (px, pt...), percentage values are ignored
+func svgLength(value string) float64 {
+	value = strings.TrimSpace(value)
+	if strings.HasSuffix(value, "%") {
+		return 0
+	}
+
+	value = strings.TrimRight(value, "abcdefghijklmnopqrstuvwxyz")
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0
+	}
+
+	return v
+}
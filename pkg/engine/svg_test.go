@@ -0,0 +1,65 @@
+package engine
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSanitizeSVG(t *testing.T) {
+	src := `<?xml version="1.0"?><!DOCTYPE svg [<!ENTITY a "b">]><svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" onload="alert(1)">` +
+		`<script>alert(2)</script><a xlink:href="javascript:alert(3)"><rect width="10" height="10"/></a>` +
+		`<foreignObject><div>x</div></foreignObject><text>a &amp; b</text></svg>`
+
+	res, err := SanitizeSVG([]byte(src))
+	out := string(res)
+
+	assert.Nil(t, err)
+	assert.False(t, strings.Contains(out, "alert"))
+	assert.False(t, strings.Contains(out, "ENTITY"))
+	assert.False(t, strings.Contains(out, "div"))
+	assert.True(t, strings.Contains(out, `<rect width="10" height="10">`))
+	assert.True(t, strings.Contains(out, "a &amp; b"))
+	assert.True(t, strings.Contains(out, `xmlns:xlink="http://www.w3.org/1999/xlink"`))
+}
+
+func TestSanitizeSVG_Style(t *testing.T) {
+	src := `<svg xmlns="http://www.w3.org/2000/svg"><style>@import url(http://evil/a.css); .a { background: url("javascript:alert(1)"); fill: url(#grad) }` +
+		` .b { behavior: url(x.htc); width: expression(alert(2)) } .c { background: u\72l(http://evil/track.png) }</style>` +
+		`<rect style="background-image: url(https://evil/pixel.gif); fill: red" filter="url(http://evil/f.svg#f)" fill="url(#grad)"/></svg>`
+
+	res, err := SanitizeSVG([]byte(src))
+	out := string(res)
+
+	assert.Nil(t, err)
+	assert.False(t, strings.Contains(out, "evil"))
+	assert.False(t, strings.Contains(out, "javascript"))
+	assert.False(t, strings.Contains(out, "behavior"))
+	assert.False(t, strings.Contains(out, "expression"))
+	assert.True(t, strings.Contains(out, "fill: url(#grad)"), "references to fragments of document should be kept")
+	assert.True(t, strings.Contains(out, `fill="url(#grad)"`))
+	assert.True(t, strings.Contains(out, "fill: red"))
+}
+
+func TestSanitizeSVG_Invalid(t *testing.T) {
+	_, err := SanitizeSVG([]byte(`<svg><rect`))
+
+	assert.NotNil(t, err)
+}
+
+func TestSvgWithSize(t *testing.T) {
+	src := `<svg xmlns="http://www.w3.org/2000/svg" width="100px" height="50px"><rect/></svg>`
+
+	out := string(svgWithSize([]byte(src), 400, 0, false))
+
+	assert.Equal(t, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 100 50" width="400" height="200"><rect/></svg>`, out)
+}
+
+func TestSvgWithSize_Cover(t *testing.T) {
+	src := `<svg viewBox="0 0 100 50" width="100" height="50"/>`
+
+	out := string(svgWithSize([]byte(src), 100, 100, true))
+
+	assert.Equal(t, `<svg viewBox="0 0 100 50" width="200" height="100"/>`, out)
+}
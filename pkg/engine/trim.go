@@ -0,0 +1,81 @@
+package engine
+
+import (
+	"image"
+	"image/color"
+)
+
+// trimPreviewSize is max dimension of preview in which border of image is searched
+const trimPreviewSize = 1024
+
+// trimBounds returns area of image without uniform border
+// Pixel belongs to border when each of its channels differs from background by no more than tolerance
+// When background is nil color of top left pixel is used, when whole image is border its bounds are returned
+func trimBounds(img image.Image, tolerance int, background *color.RGBA) image.Rectangle {
+	b := img.Bounds()
+	if b.Empty() {
+		return b
+	}
+
+	// background is given without premultiplied alpha, the same as hex color
+	var bg color.NRGBA
+	if background != nil {
+		bg = color.NRGBA(*background)
+	} else {
+		bg = color.NRGBAModel.Convert(img.At(b.Min.X, b.Min.Y)).(color.NRGBA)
+	}
+
+	isBorder := func(x, y int) bool {
+		c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+		return channelDiff(c.R, bg.R) <= tolerance && channelDiff(c.G, bg.G) <= tolerance &&
+			channelDiff(c.B, bg.B) <= tolerance && channelDiff(c.A, bg.A) <= tolerance
+	}
+
+	rowIsBorder := func(y, minX, maxX int) bool {
+		for x := minX; x < maxX; x++ {
+			if !isBorder(x, y) {
+				return false
+			}
+		}
+		return true
+	}
+
+	colIsBorder := func(x, minY, maxY int) bool {
+		for y := minY; y < maxY; y++ {
+			if !isBorder(x, y) {
+				return false
+			}
+		}
+		return true
+	}
+
+	r := b
+	for r.Min.Y < r.Max.Y && rowIsBorder(r.Min.Y, r.Min.X, r.Max.X) {
+		r.Min.Y++
+	}
+
+	if r.Min.Y == r.Max.Y {
+		return b
+	}
+
+	for rowIsBorder(r.Max.Y-1, r.Min.X, r.Max.X) {
+		r.Max.Y--
+	}
+
+	for colIsBorder(r.Min.X, r.Min.Y, r.Max.Y) {
+		r.Min.X++
+	}
+
+	for colIsBorder(r.Max.X-1, r.Min.Y, r.Max.Y) {
+		r.Max.X--
+	}
+
+	return r
+}
+
+func channelDiff(a, b uint8) int {
+	if a > b {
+		return int(a - b)
+	}
+	return int(b - a)
+}
@@ -0,0 +1,52 @@
+package engine
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/aldor007/mort/pkg/transforms"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrimBounds(t *testing.T) {
+	// white image with product in the middle and slightly off white noise near border
+	img := image.NewRGBA(image.Rect(0, 0, 100, 80))
+	for y := 0; y < 80; y++ {
+		for x := 0; x < 100; x++ {
+			img.Set(x, y, color.RGBA{R: 255, G: 255, B: 255, A: 255})
+		}
+	}
+	img.Set(5, 5, color.RGBA{R: 250, G: 250, B: 250, A: 255})
+	for y := 20; y < 50; y++ {
+		for x := 30; x < 60; x++ {
+			img.Set(x, y, color.RGBA{R: 200, A: 255})
+		}
+	}
+
+	assert.Equal(t, image.Rect(30, 20, 60, 50), trimBounds(img, 10, nil))
+	assert.Equal(t, image.Rect(5, 5, 60, 50), trimBounds(img, 0, nil))
+
+	red := color.RGBA{R: 200, A: 255}
+	assert.Equal(t, img.Bounds(), trimBounds(img, 10, &red))
+
+	white := color.RGBA{R: 255, G: 255, B: 255, A: 255}
+	assert.Equal(t, image.Rect(30, 20, 60, 50), trimBounds(img, 10, &white))
+}
+
+func TestTrimBoundsUniform(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 20, 20))
+	assert.Equal(t, img.Bounds(), trimBounds(img, 0, nil))
+}
+
+func TestImagingApplyTrim(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 50, 50))
+	img.Set(10, 20, color.RGBA{G: 255, A: 255})
+	img.Set(15, 30, color.RGBA{G: 255, A: 255})
+
+	var trans transforms.Transforms
+	trans.Trim(0, "")
+
+	res := imagingApply(img, trans.Params())
+	assert.Equal(t, image.Rect(0, 0, 6, 11), res.Bounds())
+}
@@ -0,0 +1,162 @@
+//go:build cgo
+// +build cgo
+
+package engine
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+
+	"github.com/aldor007/mort/pkg/transforms"
+	"gopkg.in/h2non/bimg.v1"
+)
+
+// IsImage check if buffer starts with signature of image format known to libvips
+func IsImage(buf []byte) bool {
+	return bimg.DetermineImageType(buf) != bimg.UNKNOWN
+}
+
+// readMetadata read properties of image from its header using libvips
+func readMetadata(buf []byte) (ImageMetadata, error) {
+	meta, err := bimg.Metadata(buf)
+	if err != nil {
+		return ImageMetadata{}, err
+	}
+
+	return ImageMetadata{
+		Width:       meta.Size.Width,
+		Height:      meta.Size.Height,
+		Format:      meta.Type,
+		ColorSpace:  meta.Space,
+		Alpha:       meta.Alpha,
+		Orientation: meta.Orientation,
+		HasICC:      meta.Profile,
+	}, nil
+}
+
+// decodeHeader read dimensions and number of bands of image using libvips
+func decodeHeader(buf []byte) (int, int, int, error) {
+	meta, err := bimg.Metadata(buf)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	return meta.Size.Width, meta.Size.Height, meta.Channels, nil
+}
+
+// decodeSample decode image downscaled by libvips to fit in width x height (or exactly that size when force is true)
+func decodeSample(buf []byte, width, height int, force bool, gray bool) (image.Image, error) {
+	opts := bimg.Options{Width: width, Height: height, Force: force, Type: bimg.PNG, Interpretation: bimg.InterpretationSRGB}
+	if gray {
+		opts.Interpretation = bimg.InterpretationBW
+	}
+
+	sample, err := bimg.NewImage(buf).Process(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(sample))
+	return img, err
+}
+
+// vipsEffects perform effects and hook on lossless image returned by libvips and encode result with given options
+func vipsEffects(buf []byte, p transforms.Params, encode bimg.Options, hook TransformHook) ([]byte, error) {
+	img, _, err := image.Decode(bytes.NewReader(buf))
+	if err != nil {
+		return nil, err
+	}
+
+	out := bytes.Buffer{}
+	encoder := png.Encoder{CompressionLevel: png.BestSpeed}
+	if err = encoder.Encode(&out, hook.run(applyEffects(img, p))); err != nil {
+		return nil, err
+	}
+
+	return vipsEncode(out.Bytes(), encode, p)
+}
+
+// entropyCrop returns top left corner of area with the highest entropy in source image, preview of image is used for search
+func entropyCrop(buf []byte, width, height, areaWidth, areaHeight int) (int, int, error) {
+	opts := bimg.Options{Type: bimg.JPEG, Interpretation: bimg.InterpretationBW, Quality: 90}
+	if width >= height {
+		opts.Width = entropyPreviewSize
+	} else {
+		opts.Height = entropyPreviewSize
+	}
+
+	preview, err := bimg.NewImage(buf).Process(opts)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(preview))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	pw, ph := img.Bounds().Dx(), img.Bounds().Dy()
+	left, top := entropyOffset(img, areaWidth*pw/width, areaHeight*ph/height)
+	return left * width / pw, top * height / ph, nil
+}
+
+// vipsPixelate pixelate source image, result is encoded as png which is used as input of libvips operations
+func vipsPixelate(buf []byte, p transforms.PixelateParams) ([]byte, error) {
+	decoded, err := bimg.NewImage(buf).Process(bimg.Options{Type: bimg.PNG})
+	if err != nil {
+		return nil, err
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(decoded))
+	if err != nil {
+		return nil, err
+	}
+
+	out := bytes.Buffer{}
+	encoder := png.Encoder{CompressionLevel: png.BestSpeed}
+	if err = encoder.Encode(&out, pixelate(img, pixelateAreas(img, p), p.BlockSize)); err != nil {
+		return nil, err
+	}
+
+	return out.Bytes(), nil
+}
+
+// trimArea returns area of source image without uniform border, border is searched in lossless preview of image
+// Area is extended by one pixel of preview, so scaling never cuts content of image
+func trimArea(buf []byte, width, height, tolerance int, background *color.RGBA) (image.Rectangle, error) {
+	full := image.Rect(0, 0, width, height)
+	opts := bimg.Options{Type: bimg.PNG}
+	if width > trimPreviewSize || height > trimPreviewSize {
+		if width >= height {
+			opts.Width = trimPreviewSize
+		} else {
+			opts.Height = trimPreviewSize
+		}
+	}
+
+	preview, err := bimg.NewImage(buf).Process(opts)
+	if err != nil {
+		return full, err
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(preview))
+	if err != nil {
+		return full, err
+	}
+
+	pb := img.Bounds()
+	pw, ph := pb.Dx(), pb.Dy()
+	r := trimBounds(img, tolerance, background).Sub(pb.Min)
+	if r == pb.Sub(pb.Min) {
+		return full, nil
+	}
+
+	if pw == width && ph == height {
+		return r, nil
+	}
+
+	area := image.Rect((r.Min.X-1)*width/pw, (r.Min.Y-1)*height/ph, ((r.Max.X+1)*width+pw-1)/pw, ((r.Max.Y+1)*height+ph-1)/ph)
+	return area.Intersect(full), nil
+}
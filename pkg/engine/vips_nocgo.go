@@ -0,0 +1,86 @@
+//go:build !cgo
+// +build !cgo
+
+package engine
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"net/http"
+	"strings"
+)
+
+// tiffSignatures signatures of little and big endian TIFF files which Go standard library doesn't detect
+var tiffSignatures = [][]byte{[]byte("II*\x00"), []byte("MM\x00*")}
+
+// IsImage check if buffer starts with signature of image format
+func IsImage(buf []byte) bool {
+	for _, signature := range tiffSignatures {
+		if bytes.HasPrefix(buf, signature) {
+			return true
+		}
+	}
+
+	return strings.HasPrefix(http.DetectContentType(buf), "image/")
+}
+
+// readMetadata read properties of image from its header, without libvips only formats supported by Go decoders can be read
+func readMetadata(buf []byte) (ImageMetadata, error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(buf))
+	if err != nil {
+		return ImageMetadata{}, err
+	}
+
+	meta := ImageMetadata{Width: cfg.Width, Height: cfg.Height, Format: format, ColorSpace: "srgb"}
+	switch model := cfg.ColorModel.(type) {
+	case color.Palette:
+		for _, c := range model {
+			if _, _, _, a := c.RGBA(); a != 0xffff {
+				meta.Alpha = true
+			}
+		}
+	default:
+		switch model {
+		case color.GrayModel, color.Gray16Model:
+			meta.ColorSpace = "b-w"
+		case color.NRGBAModel, color.NRGBA64Model:
+			meta.Alpha = true
+		}
+	}
+
+	return meta, nil
+}
+
+// decodeHeader read dimensions of image using Go decoders, number of bands isn't known so worst case is assumed
+func decodeHeader(buf []byte) (int, int, int, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(buf))
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	return cfg.Width, cfg.Height, 4, nil
+}
+
+// decodeSample decode image and downscale it to fit in width x height (or exactly that size when force is true)
+func decodeSample(buf []byte, width, height int, force bool, gray bool) (image.Image, error) {
+	img, _, err := image.Decode(bytes.NewReader(buf))
+	if err != nil {
+		return nil, err
+	}
+
+	if force {
+		img = imagingResize(img, width, height)
+	} else {
+		img = imagingFit(img, width, height, false)
+	}
+
+	if gray {
+		dst := image.NewGray(img.Bounds())
+		draw.Draw(dst, dst.Bounds(), img, img.Bounds().Min, draw.Src)
+		img = dst
+	}
+
+	return img, nil
+}
@@ -0,0 +1,55 @@
+package helpers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	_ "image/gif"  // register gif decoder
+	_ "image/jpeg" // register jpeg decoder
+	_ "image/png"  // register png decoder
+	"io"
+)
+
+// ErrUnknownImageFormat returned when dimensions of image can't be read from its header
+var ErrUnknownImageFormat = errors.New("unknown image format")
+
+// ImageDimensions read width, height and format of image from its header without decoding pixels
+// Supported formats are jpeg, png, gif and webp
+func ImageDimensions(r io.Reader) (int, int, string, error) {
+	br := bufio.NewReader(r)
+	head, _ := br.Peek(30)
+	if len(head) >= 30 && bytes.HasPrefix(head, []byte("RIFF")) && string(head[8:12]) == "WEBP" {
+		return webpDimensions(head)
+	}
+
+	cfg, format, err := image.DecodeConfig(br)
+	if err == image.ErrFormat {
+		return 0, 0, "", ErrUnknownImageFormat
+	} else if err != nil {
+		return 0, 0, "", err
+	}
+
+	return cfg.Width, cfg.Height, format, nil
+}
+
+// webpDimensions parse header of first chunk of webp image (VP8, VP8L or VP8X)
+func webpDimensions(head []byte) (int, int, string, error) {
+	switch string(head[12:16]) {
+	case "VP8 ":
+		// frame tag (3 bytes) and start code (3 bytes) precede 14 bit dimensions
+		return int(binary.LittleEndian.Uint16(head[26:28]) & 0x3fff), int(binary.LittleEndian.Uint16(head[28:30]) & 0x3fff), "webp", nil
+	case "VP8L":
+		// signature byte precedes 14 bit width-1 and 14 bit height-1
+		bits := binary.LittleEndian.Uint32(head[21:25])
+		return int(bits&0x3fff) + 1, int((bits>>14)&0x3fff) + 1, "webp", nil
+	case "VP8X":
+		// flags (4 bytes) precede 24 bit width-1 and 24 bit height-1
+		width := int(head[24]) | int(head[25])<<8 | int(head[26])<<16
+		height := int(head[27]) | int(head[28])<<8 | int(head[29])<<16
+		return width + 1, height + 1, "webp", nil
+	}
+
+	return 0, 0, "", ErrUnknownImageFormat
+}
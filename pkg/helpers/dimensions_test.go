@@ -0,0 +1,43 @@
+package helpers
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImageDimensionsPNG(t *testing.T) {
+	buf := bytes.Buffer{}
+	png.Encode(&buf, image.NewGray(image.Rect(0, 0, 120, 80)))
+
+	width, height, format, err := ImageDimensions(&buf)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 120, width)
+	assert.Equal(t, 80, height)
+	assert.Equal(t, "png", format)
+}
+
+func TestImageDimensionsWebP(t *testing.T) {
+	head := make([]byte, 30)
+	copy(head, "RIFF\x00\x00\x00\x00WEBPVP8X")
+	// width 50000, height 40000 stored as value - 1
+	head[24], head[25], head[26] = 0x4f, 0xc3, 0x00
+	head[27], head[28], head[29] = 0x3f, 0x9c, 0x00
+
+	width, height, format, err := ImageDimensions(bytes.NewReader(head))
+
+	assert.Nil(t, err)
+	assert.Equal(t, 50000, width)
+	assert.Equal(t, 40000, height)
+	assert.Equal(t, "webp", format)
+}
+
+func TestImageDimensionsUnknown(t *testing.T) {
+	_, _, _, err := ImageDimensions(bytes.NewReader([]byte("not an image at all")))
+
+	assert.Equal(t, ErrUnknownImageFormat, err)
+}
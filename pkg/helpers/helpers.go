@@ -5,6 +5,7 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -74,3 +75,33 @@ func IsRangeOrCondition(req *http.Request) bool {
 
 	return false
 }
+
+// AcceptsEncoding check if Accept-Encoding allows given encoding, encodings with q=0 are rejected
+func AcceptsEncoding(acceptEnc, encoding string) bool {
+	accepted := false
+	for _, part := range strings.Split(acceptEnc, ",") {
+		params := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(params[0]))
+		if name != encoding && name != "*" {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+
+		if name == encoding {
+			// explicit encoding overrides wildcard
+			return q > 0
+		}
+		accepted = q > 0
+	}
+
+	return accepted
+}
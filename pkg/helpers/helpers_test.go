@@ -73,3 +73,13 @@ func TestFetchObject(t *testing.T) {
 
 	assert.Nil(t, err)
 }
+
+func TestAcceptsEncoding(t *testing.T) {
+	assert.True(t, AcceptsEncoding("gzip, br", "br"))
+	assert.True(t, AcceptsEncoding("GZIP;q=0.5", "gzip"))
+	assert.False(t, AcceptsEncoding("gzip, br;q=0", "br"))
+	assert.True(t, AcceptsEncoding("*", "zstd"))
+	assert.False(t, AcceptsEncoding("*, zstd;q=0", "zstd"))
+	assert.False(t, AcceptsEncoding("identity", "gzip"))
+	assert.False(t, AcceptsEncoding("", "gzip"))
+}
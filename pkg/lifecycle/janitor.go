@@ -0,0 +1,278 @@
+package lifecycle
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aldor007/mort/pkg/config"
+	"github.com/aldor007/mort/pkg/monitoring"
+	"github.com/aldor007/mort/pkg/object"
+	"github.com/aldor007/mort/pkg/response"
+	"github.com/aldor007/mort/pkg/storage"
+	"github.com/aldor007/mort/pkg/throttler"
+	"go.uber.org/zap"
+)
+
+// listPageSize number of objects fetched from storage in single list request
+const listPageSize = 1000
+
+// errSharedStorage returned when result storage is the same as storage of originals
+var errSharedStorage = errors.New("result storage is the same as parent storage")
+
+// maxAccessLogSize max number of images which access times are kept, times of least recently accessed images are dropped first
+const maxAccessLogSize = 1 << 18
+
+// accessEntry last access time of transformed image
+type accessEntry struct {
+	key  string
+	time time.Time
+}
+
+// accessLog last access time of transformed images used for LRU expiration
+var accessLog = struct {
+	sync.Mutex
+	lru     *list.List // front is most recently accessed
+	entries map[string]*list.Element
+}{lru: list.New(), entries: make(map[string]*list.Element)}
+
+func accessKey(bucket, key string) string {
+	return bucket + "/" + strings.TrimPrefix(key, "/")
+}
+
+// Touch record access to transformed image
+func Touch(obj *object.FileObject) {
+	if !obj.HasTransform() {
+		return
+	}
+
+	recordAccess(accessKey(obj.Bucket, obj.Key), time.Now())
+}
+
+// recordAccess set access time of image, when log is full image accessed least recently is dropped
+func recordAccess(key string, t time.Time) {
+	accessLog.Lock()
+	defer accessLog.Unlock()
+	if elem, ok := accessLog.entries[key]; ok {
+		elem.Value.(*accessEntry).time = t
+		accessLog.lru.MoveToFront(elem)
+		return
+	}
+
+	accessLog.entries[key] = accessLog.lru.PushFront(&accessEntry{key: key, time: t})
+	if accessLog.lru.Len() > maxAccessLogSize {
+		removeAccess(accessLog.lru.Back())
+	}
+}
+
+// removeAccess remove entry from access log, lock of log has to be held
+func removeAccess(elem *list.Element) {
+	accessLog.lru.Remove(elem)
+	delete(accessLog.entries, elem.Value.(*accessEntry).key)
+}
+
+// lastAccess returns time of last access to image, time of modification is used when image wasn't accessed
+func lastAccess(bucket string, item storage.Item) time.Time {
+	accessLog.Lock()
+	elem, ok := accessLog.entries[accessKey(bucket, item.Key)]
+	var t time.Time
+	if ok {
+		t = elem.Value.(*accessEntry).time
+	}
+	accessLog.Unlock()
+	if ok && t.After(item.LastModified) {
+		return t
+	}
+
+	return item.LastModified
+}
+
+// forget remove from access log images of bucket which are not in storage anymore
+func forget(bucket string, existing map[string]bool) {
+	prefix := bucket + "/"
+	accessLog.Lock()
+	defer accessLog.Unlock()
+	for key, elem := range accessLog.entries {
+		if strings.HasPrefix(key, prefix) && !existing[strings.TrimPrefix(key, prefix)] {
+			removeAccess(elem)
+		}
+	}
+}
+
+// Janitor periodically removes transformed images from result storage
+// Images are removed when they weren't accessed for TTL or when result storage exceeds its size budget
+type Janitor struct {
+	config    *config.Config
+	throttler throttler.Throttler // removals are throttled with batch priority, so they don't compete with requests
+	ctx       context.Context
+	cancel    context.CancelFunc
+	stop      chan struct{}
+	wg        sync.WaitGroup
+	now       func() time.Time
+
+	orphansLock     sync.Mutex    // protects orphansScanning and lastOrphans
+	orphansScanning bool          // report of orphans is being generated, only one scan of storages runs at a time
+	lastOrphans     orphansReport // last generated report of orphans
+}
+
+// NewJanitor create janitor for buckets with lifecycle configuration
+func NewJanitor(cfg *config.Config, t throttler.Throttler) *Janitor {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Janitor{
+		config:    cfg,
+		throttler: t,
+		ctx:       ctx,
+		cancel:    cancel,
+		stop:      make(chan struct{}),
+		now:       time.Now,
+	}
+}
+
+// Start run janitor for every bucket with lifecycle configuration in background
+func (j *Janitor) Start() {
+	for name, bucket := range j.config.Buckets {
+		if bucket.Transform == nil || bucket.Transform.Lifecycle == nil {
+			continue
+		}
+
+		j.wg.Add(1)
+		go j.loop(name, time.Duration(bucket.Transform.Lifecycle.Interval)*time.Second)
+	}
+}
+
+// Stop wait for janitor to finish
+func (j *Janitor) Stop() {
+	close(j.stop)
+	j.cancel()
+	j.wg.Wait()
+}
+
+// remove delete transformed image from storage when throttler admits it
+func (j *Janitor) remove(obj *object.FileObject) *response.Response {
+	if !throttler.TakeBatch(j.ctx, j.throttler) {
+		return response.NewError(503, j.ctx.Err())
+	}
+	defer j.throttler.Release()
+
+	return storage.Delete(obj)
+}
+
+func (j *Janitor) loop(bucketName string, interval time.Duration) {
+	defer j.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-j.stop:
+			return
+		case <-ticker.C:
+			removed, err := j.Clean(bucketName)
+			if err != nil {
+				monitoring.Log().Warn("Lifecycle/Janitor unable to clean bucket", zap.String("bucket", bucketName), zap.Error(err))
+			} else {
+				monitoring.Log().Info("Lifecycle/Janitor bucket cleaned", zap.String("bucket", bucketName), zap.Int("removed", removed))
+			}
+
+			if j.config.Buckets[bucketName].Transform.Lifecycle.Orphans {
+				orphans, err := j.CollectOrphans(bucketName, false)
+				if err != nil {
+					monitoring.Log().Warn("Lifecycle/Janitor unable to remove orphans", zap.String("bucket", bucketName), zap.Error(err))
+				} else {
+					monitoring.Log().Info("Lifecycle/Janitor orphans removed", zap.String("bucket", bucketName), zap.Int("removed", len(orphans)))
+				}
+			}
+		}
+	}
+}
+
+type entry struct {
+	storage.Item
+	access time.Time
+}
+
+// listEntries returns all transformed images of bucket from result storage with time of their last access and their total size
+func listEntries(bucketName string, resultStorage config.Storage) ([]entry, int64, error) {
+	listObj := &object.FileObject{Uri: &url.URL{Path: "/" + bucketName}, Bucket: bucketName, Storage: resultStorage}
+	var entries []entry
+	var total int64
+	marker := ""
+	for {
+		items, nextMarker, err := storage.ListItems(listObj, "", marker, listPageSize)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		for _, item := range items {
+			entries = append(entries, entry{item, lastAccess(bucketName, item)})
+			total += item.Size
+		}
+
+		if nextMarker == "" || nextMarker == marker {
+			break
+		}
+		marker = nextMarker
+	}
+
+	return entries, total, nil
+}
+
+// Clean remove expired transformed images of bucket and returns number of removed images
+func (j *Janitor) Clean(bucketName string) (int, error) {
+	bucket, ok := j.config.Buckets[bucketName]
+	if !ok || bucket.Transform == nil || bucket.Transform.Lifecycle == nil {
+		return 0, nil
+	}
+
+	lifecycle := bucket.Transform.Lifecycle
+	resultStorage := bucket.Storages.Result(bucket.Transform.ResultStorage)
+	if resultStorage.SameLocation(bucket.Storages.Get(bucket.Transform.ParentStorage)) {
+		// originals would be removed as well
+		return 0, errSharedStorage
+	}
+
+	entries, total, err := listEntries(bucketName, resultStorage)
+	if err != nil {
+		return 0, err
+	}
+
+	// least recently used images are removed first
+	sort.Slice(entries, func(a, b int) bool {
+		return entries[a].access.Before(entries[b].access)
+	})
+
+	now := j.now()
+	ttl := time.Duration(lifecycle.TTL) * time.Second
+	budget := lifecycle.MaxSizeMB << 20
+	removed := 0
+	existing := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		expired := lifecycle.TTL > 0 && now.Sub(e.access) > ttl
+		overBudget := lifecycle.MaxSizeMB > 0 && total > budget
+		if !expired && !overBudget {
+			existing[e.Key] = true
+			continue
+		}
+
+		obj := &object.FileObject{Uri: &url.URL{Path: "/" + bucketName + "/" + e.Key}, Bucket: bucketName, Key: "/" + e.Key, Storage: resultStorage}
+		res := j.remove(obj)
+		res.Close()
+		if res.StatusCode != 200 {
+			monitoring.Log().Warn("Lifecycle/Janitor unable to remove image", obj.LogData(zap.Int("statusCode", res.StatusCode), zap.Error(res.Error()))...)
+			existing[e.Key] = true
+			continue
+		}
+
+		total -= e.Size
+		removed++
+		monitoring.Report().Inc("lifecycle_removed_count")
+	}
+
+	forget(bucketName, existing)
+	return removed, nil
+}
@@ -0,0 +1,246 @@
+package lifecycle
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aldor007/mort/pkg/config"
+	"github.com/aldor007/mort/pkg/object"
+	"github.com/aldor007/mort/pkg/storage"
+	"github.com/aldor007/mort/pkg/throttler"
+	"github.com/stretchr/testify/assert"
+)
+
+const testConfig = `
+buckets:
+    %s:
+        transform:
+            path: "\\/(?P<presetName>[a-z0-9_]+)\\/(?P<parent>.*)"
+            kind: "presets"
+            lifecycle:
+                ttl: 3600
+                maxSizeMB: %d
+            presets:
+                small:
+                    quality: 75
+                    filters:
+                        thumbnail:
+                            width: 150
+                medium:
+                    filters:
+                        thumbnail:
+                            width: 600
+                large:
+                    filters:
+                        thumbnail:
+                            width: 1200
+        storages:
+            basic:
+                kind: "local-meta"
+                rootPath: "%s/originals"
+            transform:
+                kind: "local-meta"
+                rootPath: "%s/derivatives"
+`
+
+// testJanitor create janitor for bucket with derivatives in temporary directory
+// Storage clients are cached by bucket name, so every test should use other bucket
+func testJanitor(t *testing.T, bucketName string, maxSizeMB int) (*Janitor, config.Storage) {
+	dir, err := ioutil.TempDir("", "mort-lifecycle")
+	assert.Nil(t, err)
+	t.Cleanup(func() {
+		os.RemoveAll(dir)
+	})
+	os.MkdirAll(dir+"/originals", 0755)
+	os.MkdirAll(dir+"/derivatives", 0755)
+
+	mortConfig := &config.Config{}
+	err = mortConfig.LoadFromString(fmt.Sprintf(testConfig, bucketName, maxSizeMB, dir, dir))
+	assert.Nil(t, err)
+
+	bucket := mortConfig.Buckets[bucketName]
+	return NewJanitor(mortConfig, throttler.NewNopThrottler()), bucket.Storages.Transform()
+}
+
+func storeDerivative(t *testing.T, storageCfg config.Storage, bucketName, key string, size int, access time.Time) *object.FileObject {
+	obj := &object.FileObject{Uri: &url.URL{Path: "/" + bucketName + key}, Bucket: bucketName, Key: key, Storage: storageCfg}
+	res := storage.Set(obj, http.Header{}, int64(size), bytes.NewReader(make([]byte, size)))
+	assert.Equal(t, 200, res.StatusCode)
+
+	recordAccess(accessKey(bucketName, key), access)
+	return obj
+}
+
+func TestAccessLogBounded(t *testing.T) {
+	now := time.Now()
+	item := storage.Item{Key: "first.jpg", LastModified: now.Add(-time.Hour)}
+	recordAccess(accessKey("bounded", "/first.jpg"), now)
+	assert.Equal(t, now, lastAccess("bounded", item))
+
+	for i := 0; i < maxAccessLogSize; i++ {
+		recordAccess(accessKey("bounded", fmt.Sprintf("/%d.jpg", i)), now)
+	}
+
+	assert.Equal(t, maxAccessLogSize, accessLog.lru.Len())
+	assert.Equal(t, item.LastModified, lastAccess("bounded", item), "least recently accessed image should be dropped")
+	forget("bounded", nil)
+}
+
+func TestJanitorTTL(t *testing.T) {
+	janitor, storageCfg := testJanitor(t, "ttl", 0)
+	now := time.Now()
+	janitor.now = func() time.Time {
+		return now.Add(2 * time.Hour)
+	}
+
+	old := storeDerivative(t, storageCfg, "ttl", "/old.jpg", 10, now)
+	recent := storeDerivative(t, storageCfg, "ttl", "/recent.jpg", 10, now.Add(90*time.Minute))
+
+	removed, err := janitor.Clean("ttl")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, removed)
+
+	assert.Equal(t, 404, storage.Head(old).StatusCode)
+	assert.Equal(t, 200, storage.Head(recent).StatusCode)
+}
+
+func TestJanitorSizeBudget(t *testing.T) {
+	janitor, storageCfg := testJanitor(t, "budget", 1)
+	now := time.Now()
+
+	first := storeDerivative(t, storageCfg, "budget", "/first.jpg", 600<<10, now.Add(-3*time.Minute))
+	second := storeDerivative(t, storageCfg, "budget", "/second.jpg", 600<<10, now.Add(-2*time.Minute))
+	third := storeDerivative(t, storageCfg, "budget", "/third.jpg", 600<<10, now.Add(-time.Minute))
+
+	removed, err := janitor.Clean("budget")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, removed)
+
+	assert.Equal(t, 404, storage.Head(first).StatusCode)
+	assert.Equal(t, 404, storage.Head(second).StatusCode)
+	assert.Equal(t, 200, storage.Head(third).StatusCode)
+}
+
+func TestJanitorSharedStorage(t *testing.T) {
+	basic := config.Storage{Kind: "local-meta", RootPath: "/data"}
+	mortConfig := &config.Config{Buckets: map[string]config.Bucket{
+		"media": {
+			Transform: &config.Transform{ParentStorage: "basic", ResultStorage: "transform", Lifecycle: &config.LifecycleCfg{TTL: 10}},
+			Storages:  config.StorageTypes{"basic": basic, "transform": basic},
+		},
+	}}
+
+	_, err := NewJanitor(mortConfig, throttler.NewNopThrottler()).Clean("media")
+	assert.Equal(t, errSharedStorage, err)
+}
+
+func TestJanitorUnusedPresets(t *testing.T) {
+	janitor, storageCfg := testJanitor(t, "report", 0)
+	now := time.Now()
+	janitor.now = func() time.Time {
+		return now.Add(60 * 24 * time.Hour)
+	}
+
+	storeDerivative(t, storageCfg, "report", "/small/first.jpg", 10, now)
+	storeDerivative(t, storageCfg, "report", "/small/second.jpg", 20, now)
+	storeDerivative(t, storageCfg, "report", "/large/recent.jpg", 10, now.Add(59*24*time.Hour))
+
+	unused, err := janitor.UnusedPresets("report", 30*24*time.Hour)
+	assert.Nil(t, err)
+	assert.Len(t, unused, 2)
+	assert.Equal(t, "medium", unused[0].Preset)
+	assert.Equal(t, 0, unused[0].Images)
+	assert.True(t, unused[0].LastUsed.IsZero())
+	assert.Equal(t, "small", unused[1].Preset)
+	assert.Equal(t, 2, unused[1].Images)
+	assert.Equal(t, int64(30), unused[1].Size)
+
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://mort/reports/presets?bucket=report&days=30", nil)
+	janitor.PresetsReportHandler().ServeHTTP(recorder, req)
+	assert.Equal(t, 200, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), `"preset":"medium"`)
+	assert.NotContains(t, recorder.Body.String(), `"preset":"large"`)
+
+	recorder = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "http://mort/reports/presets?days=abc", nil)
+	janitor.PresetsReportHandler().ServeHTTP(recorder, req)
+	assert.Equal(t, 400, recorder.Code)
+
+	bucket := janitor.config.Buckets["report"]
+	bucket.TransformVersion = 2
+	janitor.config.Buckets["report"] = bucket
+	storeDerivative(t, storageCfg, "report", "/v2/small/first.jpg", 10, now.Add(59*24*time.Hour))
+	unused, err = janitor.UnusedPresets("report", 30*24*time.Hour)
+	assert.Nil(t, err)
+	assert.Len(t, unused, 2, "only images of current transformVersion should be counted")
+	assert.Equal(t, "large", unused[0].Preset)
+	assert.Equal(t, "medium", unused[1].Preset)
+
+	transform := *bucket.Transform
+	transform.ResultKey = "hash"
+	bucket.Transform = &transform
+	janitor.config.Buckets["report"] = bucket
+	_, err = janitor.UnusedPresets("report", 30*24*time.Hour)
+	assert.Equal(t, errPresetNotInKey, err)
+}
+
+func TestJanitorCollectOrphans(t *testing.T) {
+	janitor, storageCfg := testJanitor(t, "orphans", 0)
+	bucket := janitor.config.Buckets["orphans"]
+	basic := bucket.Storages.Basic()
+	now := time.Now()
+
+	storeDerivative(t, basic, "orphans", "/photo.jpg", 10, now)
+	kept := storeDerivative(t, storageCfg, "orphans", "/small/photo.jpg", 10, now)
+	orphan := storeDerivative(t, storageCfg, "orphans", "/small/removed.jpg", 10, now)
+
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://mort/reports/orphans?bucket=orphans", nil)
+	janitor.OrphansReportHandler().ServeHTTP(recorder, req)
+	assert.Equal(t, 200, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), `"key":"/small/removed.jpg","parent":"/removed.jpg"`)
+	assert.Equal(t, 200, storage.Head(orphan).StatusCode, "report shouldn't remove images")
+
+	storeDerivative(t, storageCfg, "orphans", "/small/other.jpg", 10, now)
+	recorder = httptest.NewRecorder()
+	janitor.OrphansReportHandler().ServeHTTP(recorder, req)
+	assert.Equal(t, 200, recorder.Code)
+	assert.NotContains(t, recorder.Body.String(), "/small/other.jpg", "report should be cached")
+
+	janitor.lastOrphans = orphansReport{}
+	janitor.orphansScanning = true
+	recorder = httptest.NewRecorder()
+	janitor.OrphansReportHandler().ServeHTTP(recorder, req)
+	assert.Equal(t, 429, recorder.Code, "only one report should be generated at a time")
+	janitor.orphansScanning = false
+
+	orphans, err := janitor.CollectOrphans("orphans", false)
+	assert.Nil(t, err)
+	assert.Len(t, orphans, 2)
+	assert.Equal(t, 404, storage.Head(orphan).StatusCode)
+	assert.Equal(t, 200, storage.Head(kept).StatusCode)
+}
+
+func TestJanitorOrphansResolver(t *testing.T) {
+	janitor := NewJanitor(&config.Config{}, throttler.NewNopThrottler())
+	resolve, ref := janitor.resolver("media", &config.Transform{ResultKey: "hashParent"})
+	parent, ok := resolve("/dir-photo.jpg/2e805241bb54d7f7a200a56572d63805")
+	assert.True(t, ok)
+	assert.Equal(t, ref("/dir/photo.jpg"), parent)
+
+	resolve, ref = janitor.resolver("media", &config.Transform{ResultKey: "hash"})
+	parent, ok = resolve("/2e8/dir/dir-photo.jpg-2e805241bb54d7f7")
+	assert.True(t, ok)
+	assert.Equal(t, ref("/dir/photo.jpg"), parent)
+
+	_, ok = resolve("/invalid")
+	assert.False(t, ok)
+}
@@ -0,0 +1,238 @@
+package lifecycle
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aldor007/mort/pkg/config"
+	"github.com/aldor007/mort/pkg/monitoring"
+	"github.com/aldor007/mort/pkg/object"
+	"github.com/aldor007/mort/pkg/response"
+	"github.com/aldor007/mort/pkg/storage"
+	"go.uber.org/zap"
+)
+
+// orphansReportTTL time for which report of orphans is served without listing storages again
+const orphansReportTTL = 10 * time.Minute
+
+// errOrphansScanInProgress returned when report of orphans is requested while other one is generated
+var errOrphansScanInProgress = errors.New("report of orphans is being generated")
+
+// orphansReport report of orphans generated for bucket (empty for all buckets)
+type orphansReport struct {
+	bucket string
+	at     time.Time
+	buf    []byte
+}
+
+// Orphan describe transformed image which original doesn't exist anymore
+type Orphan struct {
+	Bucket string `json:"bucket"`
+	Key    string `json:"key"`    // key of transformed image in result storage
+	Parent string `json:"parent"` // key or safe path (for hash result keys) of missing original
+	Size   int64  `json:"size"`
+}
+
+// parentResolver returns reference to original of transformed image with given key, false when key can't be resolved
+type parentResolver func(key string) (string, bool)
+
+// CollectOrphans finds transformed images of bucket which originals were removed and removes them unless dryRun is set
+// Transformed images are resolved to originals according to resultKey of bucket, images which can't be resolved are kept
+func (j *Janitor) CollectOrphans(bucketName string, dryRun bool) ([]Orphan, error) {
+	bucket, ok := j.config.Buckets[bucketName]
+	if !ok || bucket.Transform == nil {
+		return nil, nil
+	}
+
+	transform := bucket.Transform
+	resultStorage := bucket.Storages.Result(transform.ResultStorage)
+	parentStorage := bucket.Storages.Get(transform.ParentStorage)
+	if resultStorage.SameLocation(parentStorage) {
+		// originals would be reported as orphans
+		return nil, errSharedStorage
+	}
+
+	resolve, ref := j.resolver(bucketName, transform)
+	parents, err := listParents(bucketName, parentStorage, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, _, err := listEntries(bucketName, resultStorage)
+	if err != nil {
+		return nil, err
+	}
+
+	orphans := make([]Orphan, 0)
+	for _, e := range entries {
+		parent, ok := resolve("/" + e.Key)
+		if !ok || parents[parent] {
+			continue
+		}
+
+		orphan := Orphan{Bucket: bucketName, Key: "/" + e.Key, Parent: parent, Size: e.Size}
+		if dryRun {
+			orphans = append(orphans, orphan)
+			continue
+		}
+
+		obj := &object.FileObject{Uri: &url.URL{Path: "/" + bucketName + orphan.Key}, Bucket: bucketName, Key: orphan.Key, Storage: resultStorage}
+		res := j.remove(obj)
+		res.Close()
+		if res.StatusCode != 200 {
+			monitoring.Log().Warn("Lifecycle/CollectOrphans unable to remove image", obj.LogData(zap.Int("statusCode", res.StatusCode), zap.Error(res.Error()))...)
+			continue
+		}
+
+		orphans = append(orphans, orphan)
+		monitoring.Report().Inc("lifecycle_orphan_count")
+	}
+
+	return orphans, nil
+}
+
+// resolver returns function resolving transformed image to its original and function which maps key of original to the same reference
+func (j *Janitor) resolver(bucketName string, transform *config.Transform) (parentResolver, func(string) string) {
+	safePath := func(key string) string {
+		return strings.Trim(object.DerivativesPrefix(key), "/")
+	}
+
+	if transform.ResultKeyTemplate() != nil {
+		// template of key can't be reversed, transformed images are kept
+		return func(key string) (string, bool) {
+			return "", false
+		}, safePath
+	}
+
+	switch transform.ResultKey {
+	case "hashParent":
+		// /<safe path of original>/<hash>
+		return func(key string) (string, bool) {
+			elements := strings.SplitN(strings.TrimPrefix(key, "/"), "/", 2)
+			if len(elements) != 2 {
+				return "", false
+			}
+			return elements[0], true
+		}, safePath
+	case "hash":
+		// /<3 chars of hash>/<3 chars of safe path>/<safe path of original>-<hash>
+		return func(key string) (string, bool) {
+			elements := strings.Split(strings.TrimPrefix(key, "/"), "/")
+			if len(elements) != 3 {
+				return "", false
+			}
+			i := strings.LastIndex(elements[2], "-")
+			if i == -1 {
+				return "", false
+			}
+			return elements[2][:i], true
+		}, safePath
+	default:
+		// key of transformed image is path of request (with prefix of transformVersion), so original is parsed from it
+		prefix := object.VersionPrefix(j.config.Buckets[bucketName].TransformVersion)
+		resolve := func(key string) (string, bool) {
+			key = strings.TrimPrefix(key, prefix)
+			obj, err := object.NewFileObject(&url.URL{Path: "/" + bucketName + key}, j.config)
+			if err != nil || !obj.HasParent() {
+				return "", false
+			}
+			return obj.Parent.Key, true
+		}
+		return resolve, func(key string) string {
+			return key
+		}
+	}
+}
+
+// listParents returns set of references of all originals from parent storage
+func listParents(bucketName string, parentStorage config.Storage, ref func(string) string) (map[string]bool, error) {
+	listObj := &object.FileObject{Uri: &url.URL{Path: "/" + bucketName}, Bucket: bucketName, Storage: parentStorage}
+	parents := make(map[string]bool)
+	marker := ""
+	for {
+		keys, nextMarker, err := storage.ListKeys(listObj, "", marker, listPageSize)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, key := range keys {
+			parents[ref("/"+key)] = true
+		}
+
+		if nextMarker == "" || nextMarker == marker {
+			return parents, nil
+		}
+		marker = nextMarker
+	}
+}
+
+// OrphansReportHandler returns JSON list of transformed images which originals don't exist, nothing is removed
+// Report can be limited to single bucket with query parameter bucket
+// Last report is cached for orphansReportTTL and only one report is generated at a time, others get 429
+func (j *Janitor) OrphansReportHandler() http.Handler {
+	return http.HandlerFunc(func(resWriter http.ResponseWriter, req *http.Request) {
+		name := req.URL.Query().Get("bucket")
+		j.orphansLock.Lock()
+		if last := j.lastOrphans; last.buf != nil && last.bucket == name && j.now().Sub(last.at) < orphansReportTTL {
+			j.orphansLock.Unlock()
+			response.NewBuf(200, last.buf).SetContentType("application/json").Send(resWriter)
+			return
+		}
+
+		if j.orphansScanning {
+			j.orphansLock.Unlock()
+			res := response.NewError(429, errOrphansScanInProgress)
+			res.Set("Retry-After", strconv.Itoa(int(orphansReportTTL.Seconds())))
+			res.Send(resWriter)
+			return
+		}
+		j.orphansScanning = true
+		j.orphansLock.Unlock()
+
+		buf, err := j.buildOrphansReport(name)
+		j.orphansLock.Lock()
+		j.orphansScanning = false
+		if err == nil {
+			j.lastOrphans = orphansReport{bucket: name, at: j.now(), buf: buf}
+		}
+		j.orphansLock.Unlock()
+
+		if err != nil {
+			response.NewError(500, err).Send(resWriter)
+			return
+		}
+
+		response.NewBuf(200, buf).SetContentType("application/json").Send(resWriter)
+	})
+}
+
+// buildOrphansReport returns JSON list of orphans of bucket with given name or of all buckets when name is empty
+func (j *Janitor) buildOrphansReport(name string) ([]byte, error) {
+	bucketNames := make([]string, 0, len(j.config.Buckets))
+	if name != "" {
+		bucketNames = append(bucketNames, name)
+	} else {
+		for bucketName := range j.config.Buckets {
+			bucketNames = append(bucketNames, bucketName)
+		}
+		sort.Strings(bucketNames)
+	}
+
+	report := make([]Orphan, 0)
+	for _, bucketName := range bucketNames {
+		orphans, err := j.CollectOrphans(bucketName, true)
+		if err != nil {
+			monitoring.Log().Warn("Lifecycle/OrphansReport unable to check bucket", zap.String("bucket", bucketName), zap.Error(err))
+			continue
+		}
+		report = append(report, orphans...)
+	}
+
+	return json.Marshal(report)
+}
@@ -0,0 +1,147 @@
+package lifecycle
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aldor007/mort/pkg/monitoring"
+	"github.com/aldor007/mort/pkg/object"
+	"github.com/aldor007/mort/pkg/response"
+	"go.uber.org/zap"
+)
+
+// defaultUnusedDays number of days without usage after which preset is reported as unused
+const defaultUnusedDays = 30
+
+// errPresetNotInKey returned when preset of transformed image can't be read from its key
+var errPresetNotInKey = errors.New("keys of transformed images don't contain path of request")
+
+// PresetUsage describe usage of preset based on its transformed images in result storage
+type PresetUsage struct {
+	Bucket   string    `json:"bucket"`
+	Preset   string    `json:"preset"`
+	Images   int       `json:"images"`   // number of transformed images in result storage
+	Size     int64     `json:"size"`     // size of transformed images in bytes
+	LastUsed time.Time `json:"lastUsed"` // last access to any image of preset, zero when there are no images
+}
+
+// UnusedPresets returns presets of bucket which weren't used for given time with storage occupied by their images
+// Image is used when it was accessed or generated, accesses are recorded only in memory, so after restart time of generation is used
+func (j *Janitor) UnusedPresets(bucketName string, unusedFor time.Duration) ([]PresetUsage, error) {
+	bucket, ok := j.config.Buckets[bucketName]
+	if !ok || bucket.Transform == nil || bucket.Transform.PathRegexp == nil {
+		return nil, nil
+	}
+
+	transform := bucket.Transform
+	if transform.Kind != "presets" && transform.Kind != "presets-query" {
+		return nil, nil
+	}
+
+	if transform.ResultKey != "" || transform.ResultKeyTemplate() != nil {
+		// hash and template keys can't be matched with path regexp
+		return nil, errPresetNotInKey
+	}
+
+	resultStorage := bucket.Storages.Result(transform.ResultStorage)
+	if resultStorage.SameLocation(bucket.Storages.Get(transform.ParentStorage)) {
+		// originals can't be distinguished from transformed images
+		return nil, errSharedStorage
+	}
+
+	entries, _, err := listEntries(bucketName, resultStorage)
+	if err != nil {
+		return nil, err
+	}
+
+	names := transform.PresetNames()
+	usage := make(map[string]*PresetUsage, len(names))
+	for _, name := range names {
+		usage[name] = &PresetUsage{Bucket: bucketName, Preset: name}
+	}
+
+	presetIndex := transform.PathRegexp.SubexpIndex("presetName")
+	prefix := object.VersionPrefix(bucket.TransformVersion)
+	for _, e := range entries {
+		key := "/" + e.Key
+		if !strings.HasPrefix(key, prefix+"/") {
+			// images of previous transformVersion
+			continue
+		}
+
+		matches := transform.PathRegexp.FindStringSubmatch(strings.TrimPrefix(key, prefix))
+		if matches == nil {
+			continue
+		}
+
+		u, ok := usage[matches[presetIndex]]
+		if !ok {
+			continue
+		}
+
+		u.Images++
+		u.Size += e.Size
+		if e.access.After(u.LastUsed) {
+			u.LastUsed = e.access
+		}
+	}
+
+	now := j.now()
+	var unused []PresetUsage
+	for _, name := range names {
+		if u := usage[name]; now.Sub(u.LastUsed) > unusedFor {
+			unused = append(unused, *u)
+		}
+	}
+
+	return unused, nil
+}
+
+// PresetsReportHandler returns JSON list of presets which weren't used for number of days given in query parameter days (default 30)
+// Report can be limited to single bucket with query parameter bucket
+func (j *Janitor) PresetsReportHandler() http.Handler {
+	return http.HandlerFunc(func(resWriter http.ResponseWriter, req *http.Request) {
+		days := defaultUnusedDays
+		if value := req.URL.Query().Get("days"); value != "" {
+			var err error
+			days, err = strconv.Atoi(value)
+			if err != nil || days < 0 {
+				response.NewString(400, "invalid days").Send(resWriter)
+				return
+			}
+		}
+
+		bucketNames := make([]string, 0, len(j.config.Buckets))
+		if name := req.URL.Query().Get("bucket"); name != "" {
+			bucketNames = append(bucketNames, name)
+		} else {
+			for name := range j.config.Buckets {
+				bucketNames = append(bucketNames, name)
+			}
+			sort.Strings(bucketNames)
+		}
+
+		report := make([]PresetUsage, 0)
+		for _, name := range bucketNames {
+			unused, err := j.UnusedPresets(name, time.Duration(days)*24*time.Hour)
+			if err != nil {
+				monitoring.Log().Warn("Lifecycle/PresetsReport unable to check bucket", zap.String("bucket", name), zap.Error(err))
+				continue
+			}
+			report = append(report, unused...)
+		}
+
+		buf, err := json.Marshal(report)
+		if err != nil {
+			response.NewError(500, err).Send(resWriter)
+			return
+		}
+
+		response.NewBuf(200, buf).SetContentType("application/json").Send(resWriter)
+	})
+}
@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/aldor007/mort/pkg/config"
+	"github.com/aldor007/mort/pkg/monitoring"
+	"github.com/aldor007/mort/pkg/response"
+	"go.uber.org/zap"
+)
+
+// AdminAuth middleware which allows only requests with admin bearer token
+type AdminAuth struct {
+	token string // token or secret reference, it is resolved on every request so rotated secrets are used
+}
+
+// NewAdminAuthMiddleware create instance of AdminAuth middleware
+func NewAdminAuthMiddleware(cfg config.AdminCfg) *AdminAuth {
+	return &AdminAuth{token: cfg.Token}
+}
+
+// Authorized check if request has valid bearer token
+func (a *AdminAuth) Authorized(req *http.Request) bool {
+	token, err := config.Secret(a.token)
+	if err != nil {
+		monitoring.Log().Warn("AdminAuth unable to resolve token", zap.Error(err))
+		return false
+	}
+
+	given := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	return token != "" && subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1
+}
+
+// Handler reject requests without admin token with 401
+func (a *AdminAuth) Handler(next http.Handler) http.Handler {
+	fn := func(resWriter http.ResponseWriter, req *http.Request) {
+		if !a.Authorized(req) {
+			monitoring.Log().Warn("AdminAuth unauthorized request", zap.String("path", req.URL.Path), zap.String("remoteAddr", req.RemoteAddr))
+			response.NewNoContent(401).Send(resWriter)
+			return
+		}
+
+		next.ServeHTTP(resWriter, req)
+	}
+
+	return http.HandlerFunc(fn)
+}
@@ -0,0 +1,44 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aldor007/mort/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdminAuth_Handler(t *testing.T) {
+	admin := NewAdminAuthMiddleware(config.AdminCfg{Token: "admin-token", Profiling: true})
+	next := &flagsHandler{}
+	handler := admin.Handler(next)
+
+	req := httptest.NewRequest("GET", "http://mort/debug/pprof/", nil)
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	assert.Equal(t, 401, recorder.Code)
+	assert.False(t, next.called)
+
+	req.Header.Set("Authorization", "Bearer wrong")
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	assert.Equal(t, 401, recorder.Code)
+
+	req.Header.Set("Authorization", "Bearer admin-token")
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.True(t, next.called)
+}
+
+func TestAdminAuth_HandlerEmptyToken(t *testing.T) {
+	next := &flagsHandler{}
+	handler := NewAdminAuthMiddleware(config.AdminCfg{}).Handler(next)
+
+	req := httptest.NewRequest("GET", "http://mort/debug/vars", nil)
+	req.Header.Set("Authorization", "Bearer ")
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	assert.Equal(t, 401, recorder.Code, "admin endpoints shouldn't be open without token")
+}
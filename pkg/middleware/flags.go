@@ -0,0 +1,172 @@
+package middleware
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aldor007/mort/pkg/config"
+	"github.com/aldor007/mort/pkg/monitoring"
+	"github.com/aldor007/mort/pkg/response"
+	"go.uber.org/zap"
+)
+
+type flagsContext string
+
+// FeatureFlagsCtxKey key under which validated feature flags are stored in request context
+var FeatureFlagsCtxKey flagsContext = "feature-flags"
+
+const (
+	// FlagBypassCache skip response cache for request
+	FlagBypassCache = "bypass-cache"
+	// FlagForceRender generate transformed object even if it exists in storage
+	FlagForceRender = "force-render"
+	// FlagDebug enable debug response for request
+	FlagDebug = "debug"
+	// FlagProfile allow capturing pprof profile of transform of request
+	FlagProfile = "profile"
+)
+
+// builtinFlags list of flags interpreted by mort itself
+var builtinFlags = []string{FlagBypassCache, FlagForceRender, FlagDebug, FlagProfile}
+
+// FeatureFlags is set of flags enabled for single request
+type FeatureFlags map[string]bool
+
+// Has check if given flag is enabled
+func (f FeatureFlags) Has(name string) bool {
+	return f[name]
+}
+
+// FeatureFlagsFromContext returns flags stored in context by FeatureFlagsMiddleware
+func FeatureFlagsFromContext(ctx context.Context) FeatureFlags {
+	if ctx == nil {
+		return nil
+	}
+
+	if flags, ok := ctx.Value(FeatureFlagsCtxKey).(FeatureFlags); ok {
+		return flags
+	}
+
+	return nil
+}
+
+// FeatureFlagsMiddleware validates signed header with feature flags
+// Header format: flag1,flag2;exp=<unix timestamp>;sig=<hex hmac-sha256 of "flag1,flag2;exp=<unix timestamp>">
+type FeatureFlagsMiddleware struct {
+	cfg     config.FeatureFlagsCfg
+	allowed map[string]bool
+}
+
+// NewFeatureFlagsMiddleware create instance of FeatureFlagsMiddleware
+func NewFeatureFlagsMiddleware(mortConfig *config.Config) *FeatureFlagsMiddleware {
+	f := &FeatureFlagsMiddleware{cfg: mortConfig.Server.FeatureFlags}
+	f.allowed = make(map[string]bool)
+	allowed := f.cfg.Allowed
+	if len(allowed) == 0 {
+		allowed = builtinFlags
+	}
+
+	for _, name := range allowed {
+		f.allowed[name] = true
+	}
+
+	return f
+}
+
+// Handler check if request has flags header. When header is valid flags are stored in request context
+// otherwise request is rejected with 403
+func (f *FeatureFlagsMiddleware) Handler(next http.Handler) http.Handler {
+	fn := func(resWriter http.ResponseWriter, req *http.Request) {
+		value := req.Header.Get(f.cfg.Header)
+		if value == "" || f.cfg.Secret == "" {
+			req.Header.Del(f.cfg.Header)
+			next.ServeHTTP(resWriter, req)
+			return
+		}
+
+		flags, err := f.parse(value, time.Now())
+		if err != nil {
+			monitoring.Log().Warn("FeatureFlags invalid header", zap.Error(err), zap.String("req.path", req.URL.Path),
+				zap.String("req.remoteAddr", req.RemoteAddr), zap.String("header", value), zap.String("requestId", RequestIDFromContext(req.Context())))
+			response.NewNoContent(403).Send(resWriter)
+			return
+		}
+
+		names := make([]string, 0, len(flags))
+		for name := range flags {
+			names = append(names, name)
+		}
+		monitoring.Log().Info("FeatureFlags enabled", zap.Strings("flags", names), zap.String("req.path", req.URL.Path),
+			zap.String("req.method", req.Method), zap.String("req.remoteAddr", req.RemoteAddr), zap.String("requestId", RequestIDFromContext(req.Context())))
+
+		req.Header.Del(f.cfg.Header)
+		ctx := context.WithValue(req.Context(), FeatureFlagsCtxKey, flags)
+		next.ServeHTTP(resWriter, req.WithContext(ctx))
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+func (f *FeatureFlagsMiddleware) parse(value string, now time.Time) (FeatureFlags, error) {
+	sigIndex := strings.LastIndex(value, ";sig=")
+	if sigIndex == -1 {
+		return nil, errors.New("missing signature")
+	}
+
+	payload := value[:sigIndex]
+	signature, errHex := hex.DecodeString(value[sigIndex+len(";sig="):])
+	if errHex != nil {
+		return nil, errors.New("malformed signature")
+	}
+
+	if !hmac.Equal(signature, f.sign(payload)) {
+		return nil, errors.New("signature mismatch")
+	}
+
+	parts := strings.Split(payload, ";")
+	if len(parts) != 2 || !strings.HasPrefix(parts[1], "exp=") {
+		return nil, errors.New("missing expiration")
+	}
+
+	exp, errExp := strconv.ParseInt(strings.TrimPrefix(parts[1], "exp="), 10, 64)
+	if errExp != nil {
+		return nil, errors.New("malformed expiration")
+	}
+
+	expTime := time.Unix(exp, 0)
+	if now.After(expTime) {
+		return nil, errors.New("header expired")
+	}
+
+	if expTime.Sub(now) > time.Duration(f.cfg.MaxAge)*time.Second {
+		return nil, errors.New("expiration too far in future")
+	}
+
+	flags := make(FeatureFlags)
+	for _, name := range strings.Split(parts[0], ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		if !f.allowed[name] {
+			return nil, errors.New("flag not allowed " + name)
+		}
+		flags[name] = true
+	}
+
+	return flags, nil
+}
+
+func (f *FeatureFlagsMiddleware) sign(payload string) []byte {
+	mac := hmac.New(sha256.New, []byte(f.cfg.Secret))
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
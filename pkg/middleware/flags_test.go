@@ -0,0 +1,104 @@
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aldor007/mort/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+type flagsHandler struct {
+	called bool
+	flags  FeatureFlags
+}
+
+func (f *flagsHandler) ServeHTTP(_ http.ResponseWriter, req *http.Request) {
+	f.called = true
+	f.flags = FeatureFlagsFromContext(req.Context())
+}
+
+func flagsConfig() *config.Config {
+	c := &config.Config{}
+	c.Server.FeatureFlags = config.FeatureFlagsCfg{Header: "X-Mort-Flags", Secret: "secret", MaxAge: 300}
+	return c
+}
+
+func signFlags(secret, flags string, exp time.Time) string {
+	payload := flags + ";exp=" + strconv.FormatInt(exp.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return payload + ";sig=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestFeatureFlags_HandlerNoHeader(t *testing.T) {
+	f := NewFeatureFlagsMiddleware(flagsConfig())
+	next := flagsHandler{}
+
+	req, _ := http.NewRequest("GET", "http://mort/local/test.jpg", nil)
+	recorder := httptest.NewRecorder()
+	f.Handler(&next).ServeHTTP(recorder, req)
+
+	assert.True(t, next.called)
+	assert.False(t, next.flags.Has(FlagBypassCache))
+}
+
+func TestFeatureFlags_HandlerValid(t *testing.T) {
+	f := NewFeatureFlagsMiddleware(flagsConfig())
+	next := flagsHandler{}
+
+	req, _ := http.NewRequest("GET", "http://mort/local/test.jpg", nil)
+	req.Header.Set("X-Mort-Flags", signFlags("secret", "bypass-cache,force-render", time.Now().Add(time.Minute)))
+	recorder := httptest.NewRecorder()
+	f.Handler(&next).ServeHTTP(recorder, req)
+
+	assert.True(t, next.called)
+	assert.True(t, next.flags.Has(FlagBypassCache))
+	assert.True(t, next.flags.Has(FlagForceRender))
+	assert.False(t, next.flags.Has(FlagDebug))
+	assert.Equal(t, req.Header.Get("X-Mort-Flags"), "")
+}
+
+func TestFeatureFlags_HandlerInvalid(t *testing.T) {
+	cases := []string{
+		"bypass-cache",
+		signFlags("other", "bypass-cache", time.Now().Add(time.Minute)),
+		signFlags("secret", "bypass-cache", time.Now().Add(-time.Minute)),
+		signFlags("secret", "bypass-cache", time.Now().Add(time.Hour)),
+		signFlags("secret", "unknown", time.Now().Add(time.Minute)),
+	}
+
+	for _, header := range cases {
+		f := NewFeatureFlagsMiddleware(flagsConfig())
+		next := flagsHandler{}
+
+		req, _ := http.NewRequest("GET", "http://mort/local/test.jpg", nil)
+		req.Header.Set("X-Mort-Flags", header)
+		recorder := httptest.NewRecorder()
+		f.Handler(&next).ServeHTTP(recorder, req)
+
+		assert.False(t, next.called, header)
+		assert.Equal(t, recorder.Code, 403, header)
+	}
+}
+
+func TestFeatureFlags_HandlerDisabled(t *testing.T) {
+	c := flagsConfig()
+	c.Server.FeatureFlags.Secret = ""
+	f := NewFeatureFlagsMiddleware(c)
+	next := flagsHandler{}
+
+	req, _ := http.NewRequest("GET", "http://mort/local/test.jpg", nil)
+	req.Header.Set("X-Mort-Flags", signFlags("secret", "bypass-cache", time.Now().Add(time.Minute)))
+	recorder := httptest.NewRecorder()
+	f.Handler(&next).ServeHTTP(recorder, req)
+
+	assert.True(t, next.called)
+	assert.False(t, next.flags.Has(FlagBypassCache))
+}
@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"github.com/aldor007/mort/pkg/config"
+	"github.com/aldor007/mort/pkg/monitoring"
+)
+
+type hostContext string
+
+// hostURLCtxKey key under which URL of request routed by host is stored, as it was sent by client
+var hostURLCtxKey hostContext = "host-url"
+
+// HostRouter middleware maps host of request to bucket, so buckets can be served in virtual-host style
+// Path of request is prefixed with bucket, so next handlers see path-style request
+type HostRouter struct {
+	hosts []config.HostCfg
+}
+
+// NewHostRouterMiddleware create instance of HostRouter middleware for hosts of server
+func NewHostRouterMiddleware(mortConfig *config.Config) *HostRouter {
+	return &HostRouter{hosts: mortConfig.Server.Hosts}
+}
+
+// Handler routes request of configured host to its bucket, requests of other hosts are passed unchanged (path-style)
+func (h *HostRouter) Handler(next http.Handler) http.Handler {
+	fn := func(resWriter http.ResponseWriter, req *http.Request) {
+		if len(h.hosts) == 0 {
+			next.ServeHTTP(resWriter, req)
+			return
+		}
+
+		_, bucket, ok := config.FindHost(h.hosts, req.Host)
+		if !ok {
+			next.ServeHTTP(resWriter, req)
+			return
+		}
+
+		monitoring.Report().Inc("host_route_count;bucket:" + bucket)
+		original := *req.URL
+		u := *req.URL
+		u.Path = "/" + bucket + req.URL.Path
+		u.RawPath = ""
+		if req.URL.RawPath != "" {
+			u.RawPath = "/" + bucket + req.URL.RawPath
+		}
+
+		req = req.WithContext(context.WithValue(req.Context(), hostURLCtxKey, &original))
+		req.URL = &u
+		req.RequestURI = u.RequestURI()
+		next.ServeHTTP(resWriter, req)
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+// OriginalURL returns URL of request as it was sent by client, before routing by host
+func OriginalURL(req *http.Request) *url.URL {
+	if u, ok := req.Context().Value(hostURLCtxKey).(*url.URL); ok {
+		return u
+	}
+
+	return req.URL
+}
+
+// BucketPath returns path under which client sees bucket, it is empty for requests routed by host
+func BucketPath(req *http.Request, bucket string) string {
+	if _, ok := req.Context().Value(hostURLCtxKey).(*url.URL); ok {
+		return ""
+	}
+
+	return "/" + bucket
+}
@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aldor007/mort/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+type hostHandler struct {
+	req *http.Request
+}
+
+func (h *hostHandler) ServeHTTP(_ http.ResponseWriter, req *http.Request) {
+	h.req = req
+}
+
+func hostsConfig() *config.Config {
+	c := &config.Config{}
+	c.Server.Hosts = []config.HostCfg{{Host: "img.example.com", Bucket: "media"}, {Host: "*.s3.example.com"}}
+	return c
+}
+
+func TestHostRouter_Handler(t *testing.T) {
+	h := NewHostRouterMiddleware(hostsConfig())
+	next := &hostHandler{}
+	req := httptest.NewRequest("GET", "http://img.example.com/photo.jpg?width=100", nil)
+
+	h.Handler(next).ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.Equal(t, "/media/photo.jpg", next.req.URL.Path)
+	assert.Equal(t, "width=100", next.req.URL.RawQuery)
+	assert.Equal(t, "/photo.jpg", OriginalURL(next.req).Path)
+	assert.Equal(t, "", BucketPath(next.req, "media"))
+}
+
+func TestHostRouter_HandlerWildcard(t *testing.T) {
+	h := NewHostRouterMiddleware(hostsConfig())
+	next := &hostHandler{}
+	req := httptest.NewRequest("GET", "http://photos.s3.example.com/2020/photo.jpg", nil)
+
+	h.Handler(next).ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.Equal(t, "/photos/2020/photo.jpg", next.req.URL.Path)
+}
+
+func TestHostRouter_HandlerPathStyle(t *testing.T) {
+	h := NewHostRouterMiddleware(hostsConfig())
+	next := &hostHandler{}
+	req := httptest.NewRequest("GET", "http://mort.example.com/media/photo.jpg", nil)
+
+	h.Handler(next).ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.Equal(t, "/media/photo.jpg", next.req.URL.Path)
+	assert.Equal(t, req.URL, OriginalURL(next.req))
+	assert.Equal(t, "/media", BucketPath(next.req, "media"))
+}
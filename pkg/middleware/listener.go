@@ -0,0 +1,80 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/aldor007/mort/pkg/config"
+	"github.com/aldor007/mort/pkg/monitoring"
+	"github.com/aldor007/mort/pkg/response"
+	"go.uber.org/zap"
+)
+
+type listenerContext string
+
+// listenerCtxKey key under which configuration of listener which received request is stored
+var listenerCtxKey listenerContext = "listener"
+
+// Listener middleware applies auth settings of listener to its requests
+type Listener struct {
+	cfg   config.ListenerCfg
+	token *AdminAuth
+}
+
+// NewListenerMiddleware create instance of Listener middleware for listener
+func NewListenerMiddleware(cfg config.ListenerCfg) *Listener {
+	l := &Listener{cfg: cfg}
+	if cfg.Auth.Token != "" {
+		l.token = NewAdminAuthMiddleware(config.AdminCfg{Token: cfg.Auth.Token})
+	}
+
+	return l
+}
+
+// Handler rejects requests of not allowed clients with 403 and requests without token of listener with 401
+// Configuration of listener is stored in context of request, so HandlerSets can check it after routing by host
+func (l *Listener) Handler(next http.Handler) http.Handler {
+	fn := func(resWriter http.ResponseWriter, req *http.Request) {
+		if !l.cfg.Auth.AllowsIP(remoteIP(req)) {
+			monitoring.Log().Warn("Listener client address denied", zap.String("listener", l.cfg.Address), zap.String("remoteAddr", req.RemoteAddr))
+			response.NewNoContent(403).Send(resWriter)
+			return
+		}
+
+		if l.token != nil && !l.token.Authorized(req) {
+			monitoring.Log().Warn("Listener unauthorized request", zap.String("listener", l.cfg.Address), zap.String("path", req.URL.Path), zap.String("remoteAddr", req.RemoteAddr))
+			response.NewNoContent(401).Send(resWriter)
+			return
+		}
+
+		next.ServeHTTP(resWriter, req.WithContext(context.WithValue(req.Context(), listenerCtxKey, l.cfg)))
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+// HandlerSets rejects requests of S3 API received by listener without s3 handler and other requests received
+// by listener without public handler with 403, requests which didn't come through Listener middleware are passed unchanged
+func HandlerSets(next http.Handler) http.Handler {
+	fn := func(resWriter http.ResponseWriter, req *http.Request) {
+		cfg, ok := req.Context().Value(listenerCtxKey).(config.ListenerCfg)
+		if !ok {
+			next.ServeHTTP(resWriter, req)
+			return
+		}
+
+		if isAuthRequired(req, req.Header.Get("Authorization"), req.URL.Path) {
+			if !cfg.Has(config.HandlerS3) {
+				s3Error(resWriter, req, 403, "AccessDenied", "S3 API is not served by this listener")
+				return
+			}
+		} else if !cfg.Has(config.HandlerPublic) {
+			response.NewNoContent(403).Send(resWriter)
+			return
+		}
+
+		next.ServeHTTP(resWriter, req)
+	}
+
+	return http.HandlerFunc(fn)
+}
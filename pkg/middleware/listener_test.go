@@ -0,0 +1,92 @@
+package middleware
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aldor007/mort/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func listenersConfig(t *testing.T) *config.Config {
+	c := &config.Config{}
+	err := c.LoadFromString(`
+server:
+    listeners:
+        - address: ":8080"
+          handlers: ["public"]
+        - address: ":9000"
+          handlers: ["s3"]
+          auth:
+              allow: ["10.0.0.0/8"]
+        - address: ":8081"
+          handlers: ["admin", "metrics"]
+          auth:
+              token: "secret"
+buckets:
+    media:
+        storages:
+            basic:
+                kind: "local"
+                rootPath: "/tmp/mort"
+`)
+	assert.Nil(t, err)
+	return c
+}
+
+func TestListener_HandlerSets(t *testing.T) {
+	c := listenersConfig(t)
+	next := &hostHandler{}
+	public := NewListenerMiddleware(c.Server.Listeners[0]).Handler(HandlerSets(next))
+
+	req := httptest.NewRequest("GET", "http://mort/media/photo.jpg", nil)
+	recorder := httptest.NewRecorder()
+	public.ServeHTTP(recorder, req)
+	assert.Equal(t, 200, recorder.Code)
+	assert.NotNil(t, next.req)
+
+	next.req = nil
+	req = httptest.NewRequest("PUT", "http://mort/media/photo.jpg", nil)
+	recorder = httptest.NewRecorder()
+	public.ServeHTTP(recorder, req)
+	assert.Equal(t, 403, recorder.Code, "S3 API should be rejected by public listener")
+	assert.Nil(t, next.req)
+}
+
+func TestListener_HandlerAllow(t *testing.T) {
+	c := listenersConfig(t)
+	next := &hostHandler{}
+	s3 := NewListenerMiddleware(c.Server.Listeners[1]).Handler(HandlerSets(next))
+
+	req := httptest.NewRequest("PUT", "http://mort/media/photo.jpg", nil)
+	req.RemoteAddr = "8.8.8.8:1234"
+	recorder := httptest.NewRecorder()
+	s3.ServeHTTP(recorder, req)
+	assert.Equal(t, 403, recorder.Code)
+	assert.Nil(t, next.req)
+
+	req = httptest.NewRequest("GET", "http://mort/media/photo.jpg", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	recorder = httptest.NewRecorder()
+	s3.ServeHTTP(recorder, req)
+	assert.Equal(t, 403, recorder.Code, "public requests should be rejected by S3 listener")
+	assert.Nil(t, next.req)
+}
+
+func TestListener_HandlerToken(t *testing.T) {
+	c := listenersConfig(t)
+	next := &hostHandler{}
+	admin := NewListenerMiddleware(c.Server.Listeners[2]).Handler(next)
+
+	req := httptest.NewRequest("GET", "http://mort/metrics", nil)
+	recorder := httptest.NewRecorder()
+	admin.ServeHTTP(recorder, req)
+	assert.Equal(t, 401, recorder.Code)
+
+	req = httptest.NewRequest("GET", "http://mort/metrics", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	recorder = httptest.NewRecorder()
+	admin.ServeHTTP(recorder, req)
+	assert.Equal(t, 200, recorder.Code)
+	assert.NotNil(t, next.req)
+}
@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/aldor007/mort/pkg/config"
+)
+
+// TrustedProxies middleware replaces address of request with address of client sent by trusted proxy
+// in X-Forwarded-For or X-Real-IP, so logs, access rules and scripts see client instead of load balancer
+// X-Forwarded-Proto is removed from requests of other clients, so it can't be spoofed
+type TrustedProxies struct {
+	proxy config.ProxyCfg
+}
+
+// NewTrustedProxiesMiddleware create instance of TrustedProxies middleware
+func NewTrustedProxiesMiddleware(mortConfig *config.Config) *TrustedProxies {
+	return &TrustedProxies{proxy: mortConfig.Server.Proxy}
+}
+
+// remoteIP returns address of connection of request
+func remoteIP(req *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+
+	return net.ParseIP(host)
+}
+
+// forwardedIP returns address of client from headers of trusted proxy
+// X-Forwarded-For is read from right, first address which isn't trusted proxy is address of client
+func (t *TrustedProxies) forwardedIP(req *http.Request) net.IP {
+	var addresses []string
+	for _, value := range req.Header.Values("X-Forwarded-For") {
+		addresses = append(addresses, strings.Split(value, ",")...)
+	}
+
+	var client net.IP
+	for i := len(addresses) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(addresses[i]))
+		if ip == nil {
+			break
+		}
+
+		client = ip
+		if !t.proxy.IsTrusted(ip) {
+			break
+		}
+	}
+
+	if client != nil {
+		return client
+	}
+
+	return net.ParseIP(strings.TrimSpace(req.Header.Get("X-Real-IP")))
+}
+
+// Handler sets address of client of requests sent by trusted proxies
+func (t *TrustedProxies) Handler(next http.Handler) http.Handler {
+	fn := func(resWriter http.ResponseWriter, req *http.Request) {
+		if !t.proxy.IsTrusted(remoteIP(req)) {
+			req.Header.Del("X-Forwarded-Proto")
+			next.ServeHTTP(resWriter, req)
+			return
+		}
+
+		if client := t.forwardedIP(req); client != nil {
+			req.RemoteAddr = client.String()
+		}
+
+		next.ServeHTTP(resWriter, req)
+	}
+
+	return http.HandlerFunc(fn)
+}
@@ -0,0 +1,67 @@
+package middleware
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aldor007/mort/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func proxyConfig(t *testing.T) *config.Config {
+	c := &config.Config{}
+	err := c.LoadFromString(`
+server:
+    proxy:
+        trustedProxies: ["10.0.0.0/8"]
+buckets:
+    media:
+        storages:
+            basic:
+                kind: "local"
+                rootPath: "/tmp/mort"
+`)
+	assert.Nil(t, err)
+	return c
+}
+
+func TestTrustedProxies_Handler(t *testing.T) {
+	h := NewTrustedProxiesMiddleware(proxyConfig(t))
+	next := &hostHandler{}
+	req := httptest.NewRequest("GET", "http://mort/media/photo.jpg", nil)
+	req.RemoteAddr = "10.0.0.1:4567"
+	req.Header.Add("X-Forwarded-For", "1.1.1.1, 2.2.2.2")
+	req.Header.Add("X-Forwarded-For", "10.0.0.2")
+	req.Header.Set("X-Forwarded-Proto", "https")
+
+	h.Handler(next).ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.Equal(t, "2.2.2.2", next.req.RemoteAddr, "first address from right which isn't trusted proxy should be used")
+	assert.Equal(t, "https", next.req.Header.Get("X-Forwarded-Proto"))
+}
+
+func TestTrustedProxies_HandlerRealIP(t *testing.T) {
+	h := NewTrustedProxiesMiddleware(proxyConfig(t))
+	next := &hostHandler{}
+	req := httptest.NewRequest("GET", "http://mort/media/photo.jpg", nil)
+	req.RemoteAddr = "10.0.0.1:4567"
+	req.Header.Set("X-Real-IP", "2001:db8::1")
+
+	h.Handler(next).ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.Equal(t, "2001:db8::1", next.req.RemoteAddr)
+}
+
+func TestTrustedProxies_HandlerUntrusted(t *testing.T) {
+	h := NewTrustedProxiesMiddleware(proxyConfig(t))
+	next := &hostHandler{}
+	req := httptest.NewRequest("GET", "http://mort/media/photo.jpg", nil)
+	req.RemoteAddr = "3.3.3.3:4567"
+	req.Header.Set("X-Forwarded-For", "1.1.1.1")
+	req.Header.Set("X-Forwarded-Proto", "https")
+
+	h.Handler(next).ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.Equal(t, "3.3.3.3:4567", next.req.RemoteAddr)
+	assert.Equal(t, "", next.req.Header.Get("X-Forwarded-Proto"))
+}
@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/aldor007/mort/pkg/object"
+)
+
+type requestIDContext string
+
+// RequestIDCtxKey key under which request id is stored in request context
+var RequestIDCtxKey requestIDContext = "request-id"
+
+// maxRequestIDLen limit of length of request id accepted from client
+const maxRequestIDLen = 128
+
+// RequestIDFromContext returns request id stored in context by RequestID middleware
+func RequestIDFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+
+	if id, ok := ctx.Value(RequestIDCtxKey).(string); ok {
+		return id
+	}
+
+	return ""
+}
+
+// RequestID middleware that accepts or generates X-Request-ID for each request
+// Id is stored in request header and context and returned to client in response header
+type RequestID struct {
+}
+
+// NewRequestIDMiddleware create instance of RequestID middleware
+func NewRequestIDMiddleware() *RequestID {
+	return &RequestID{}
+}
+
+// Handler assign request id to request and response
+func (RequestID) Handler(next http.Handler) http.Handler {
+	fn := func(resWriter http.ResponseWriter, req *http.Request) {
+		id := req.Header.Get(object.HeaderRequestID)
+		if !isValidRequestID(id) {
+			id = generateRequestID()
+			req.Header.Set(object.HeaderRequestID, id)
+		}
+
+		resWriter.Header().Set(object.HeaderRequestID, id)
+		ctx := context.WithValue(req.Context(), RequestIDCtxKey, id)
+		next.ServeHTTP(resWriter, req.WithContext(ctx))
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+func isValidRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+
+	for _, c := range id {
+		if c < 0x21 || c > 0x7e {
+			return false
+		}
+	}
+
+	return true
+}
+
+func generateRequestID() string {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return ""
+	}
+
+	return hex.EncodeToString(buf)
+}
@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type requestIDHandler struct {
+	id string
+}
+
+func (r *requestIDHandler) ServeHTTP(_ http.ResponseWriter, req *http.Request) {
+	r.id = RequestIDFromContext(req.Context())
+}
+
+func TestRequestID_HandlerGenerate(t *testing.T) {
+	next := requestIDHandler{}
+	fn := NewRequestIDMiddleware().Handler(&next)
+
+	req, _ := http.NewRequest("GET", "http://mort/local/test.jpg", nil)
+	recorder := httptest.NewRecorder()
+	fn.ServeHTTP(recorder, req)
+
+	assert.Len(t, next.id, 32)
+	assert.Equal(t, recorder.Header().Get("X-Request-ID"), next.id)
+	assert.Equal(t, req.Header.Get("X-Request-ID"), next.id)
+}
+
+func TestRequestID_HandlerAccept(t *testing.T) {
+	next := requestIDHandler{}
+	fn := NewRequestIDMiddleware().Handler(&next)
+
+	req, _ := http.NewRequest("GET", "http://mort/local/test.jpg", nil)
+	req.Header.Set("X-Request-ID", "edge-123")
+	recorder := httptest.NewRecorder()
+	fn.ServeHTTP(recorder, req)
+
+	assert.Equal(t, next.id, "edge-123")
+	assert.Equal(t, recorder.Header().Get("X-Request-ID"), "edge-123")
+}
+
+func TestRequestID_HandlerInvalid(t *testing.T) {
+	for _, id := range []string{"with space", strings.Repeat("a", 129)} {
+		next := requestIDHandler{}
+		fn := NewRequestIDMiddleware().Handler(&next)
+
+		req, _ := http.NewRequest("GET", "http://mort/local/test.jpg", nil)
+		req.Header.Set("X-Request-ID", id)
+		recorder := httptest.NewRecorder()
+		fn.ServeHTTP(recorder, req)
+
+		assert.NotEqual(t, next.id, id)
+		assert.Len(t, next.id, 32)
+	}
+}
@@ -0,0 +1,111 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/aldor007/mort/pkg/config"
+	"github.com/aldor007/mort/pkg/monitoring"
+	"github.com/aldor007/mort/pkg/response"
+	"go.uber.org/zap"
+)
+
+// rewriteRule is rewrite rule of bucket with compiled regexp
+type rewriteRule struct {
+	config.RewriteRule
+	re *regexp.Regexp
+}
+
+// Rewrite middleware rewrites keys of requests, redirects them or serves them from other bucket
+// Rules are evaluated before request is parsed, so legacy URLs can be mapped to current keys and presets
+type Rewrite struct {
+	rules map[string][]rewriteRule
+}
+
+// NewRewriteMiddleware create instance of Rewrite middleware, it panics when rule of any bucket is invalid
+func NewRewriteMiddleware(mortConfig *config.Config) *Rewrite {
+	r := &Rewrite{rules: make(map[string][]rewriteRule)}
+	for name, bucket := range mortConfig.Buckets {
+		for _, rule := range bucket.Rewrites {
+			re, err := regexp.Compile(rule.Match)
+			if err != nil {
+				panic(fmt.Errorf("invalid rewrite rule of bucket %s %s", name, err))
+			}
+			if rule.Status == 0 {
+				rule.Status = 301
+			}
+			r.rules[name] = append(r.rules[name], rewriteRule{RewriteRule: rule, re: re})
+		}
+	}
+
+	return r
+}
+
+// Handler applies first rule of requested bucket which matches key of GET or HEAD request
+// Requests of S3 API are passed unchanged
+func (r *Rewrite) Handler(next http.Handler) http.Handler {
+	fn := func(resWriter http.ResponseWriter, req *http.Request) {
+		if len(r.rules) == 0 || (req.Method != "GET" && req.Method != "HEAD") || req.Context().Value(S3AuthCtxKey) != nil {
+			next.ServeHTTP(resWriter, req)
+			return
+		}
+
+		parts := strings.SplitN(strings.TrimPrefix(req.URL.Path, "/"), "/", 2)
+		rules, ok := r.rules[parts[0]]
+		if !ok {
+			next.ServeHTTP(resWriter, req)
+			return
+		}
+
+		key := ""
+		if len(parts) == 2 {
+			key = "/" + parts[1]
+		}
+
+		for _, rule := range rules {
+			match := rule.re.FindStringSubmatchIndex(key)
+			if match == nil {
+				continue
+			}
+
+			if rule.Redirect != "" {
+				location := string(rule.re.ExpandString(nil, rule.Redirect, key, match))
+				if req.URL.RawQuery != "" && !strings.Contains(location, "?") {
+					location += "?" + req.URL.RawQuery
+				}
+				monitoring.Report().Inc("rewrite_count;bucket:" + parts[0] + ",action:redirect")
+				res := response.NewNoContent(rule.Status)
+				res.Set("Location", location)
+				res.Send(resWriter)
+				return
+			}
+
+			bucket := parts[0]
+			action := "rewrite"
+			if rule.Bucket != "" && rule.Bucket != bucket {
+				bucket = rule.Bucket
+				action = "proxy"
+			}
+			newKey := string(rule.re.ExpandString(nil, rule.Rewrite, key, match))
+			if !strings.HasPrefix(newKey, "/") {
+				newKey = "/" + newKey
+			}
+
+			monitoring.Log().Debug("Rewrite request", zap.String("bucket", parts[0]), zap.String("req.path", req.URL.Path),
+				zap.String("path", "/"+bucket+newKey), zap.String("requestId", RequestIDFromContext(req.Context())))
+			monitoring.Report().Inc("rewrite_count;bucket:" + parts[0] + ",action:" + action)
+			u := *req.URL
+			u.Path = "/" + bucket + newKey
+			u.RawPath = ""
+			req.URL = &u
+			req.RequestURI = u.RequestURI()
+			break
+		}
+
+		next.ServeHTTP(resWriter, req)
+	}
+
+	return http.HandlerFunc(fn)
+}
@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aldor007/mort/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func rewriteConfig(rules ...config.RewriteRule) *config.Config {
+	c := &config.Config{}
+	c.Buckets = map[string]config.Bucket{"media": {Rewrites: rules}, "archive": {}}
+	return c
+}
+
+func TestRewrite_HandlerRewrite(t *testing.T) {
+	r := NewRewriteMiddleware(rewriteConfig(
+		config.RewriteRule{Match: `^/images/(?P<size>\w+)/(.+)$`, Rewrite: "/$2-${size}"},
+		config.RewriteRule{Match: `^/images/`, Rewrite: "/never"},
+	))
+	next := &scriptHandler{}
+	req := httptest.NewRequest("GET", "http://mort/media/images/small/photo.jpg?v=2", nil)
+
+	r.Handler(next).ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.True(t, next.called)
+	assert.Equal(t, "/media/photo.jpg-small", next.path, "first matching rule should be applied")
+	assert.Equal(t, "v=2", next.query)
+}
+
+func TestRewrite_HandlerProxy(t *testing.T) {
+	r := NewRewriteMiddleware(rewriteConfig(config.RewriteRule{Match: `^/old/(.*)$`, Rewrite: "$1", Bucket: "archive"}))
+	next := &scriptHandler{}
+	req := httptest.NewRequest("GET", "http://mort/media/old/2015/photo.jpg", nil)
+
+	r.Handler(next).ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.True(t, next.called)
+	assert.Equal(t, "/archive/2015/photo.jpg", next.path)
+}
+
+func TestRewrite_HandlerRedirect(t *testing.T) {
+	r := NewRewriteMiddleware(rewriteConfig(config.RewriteRule{Match: `^/legacy/(.*)$`, Redirect: "/media/$1", Status: 302}))
+	next := &scriptHandler{}
+	req := httptest.NewRequest("GET", "http://mort/media/legacy/photo.jpg?v=2", nil)
+	recorder := httptest.NewRecorder()
+
+	r.Handler(next).ServeHTTP(recorder, req)
+
+	assert.False(t, next.called)
+	assert.Equal(t, 302, recorder.Code)
+	assert.Equal(t, "/media/photo.jpg?v=2", recorder.Header().Get("Location"))
+}
+
+func TestRewrite_HandlerSkipsWrites(t *testing.T) {
+	r := NewRewriteMiddleware(rewriteConfig(config.RewriteRule{Match: `^/legacy/(.*)$`, Redirect: "/media/$1"}))
+	next := &scriptHandler{}
+	req := httptest.NewRequest("PUT", "http://mort/media/legacy/photo.jpg", nil)
+
+	r.Handler(next).ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.True(t, next.called)
+	assert.Equal(t, "/media/legacy/photo.jpg", next.path)
+}
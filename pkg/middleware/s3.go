@@ -44,7 +44,14 @@ func isAuthRequired(req *http.Request, auth string, path string) bool {
 		}
 
 		return false
-	case "POST", "PUT", "DELETE", "PATCH":
+	case "POST":
+		// form uploads are authorized by policy signed in form, batch delete always needs signature
+		if _, batchDelete := req.URL.Query()["delete"]; auth == "" && !batchDelete && strings.HasPrefix(req.Header.Get("Content-Type"), "multipart/form-data") {
+			return false
+		}
+
+		return true
+	case "PUT", "DELETE", "PATCH":
 		return true
 	}
 
@@ -79,8 +86,7 @@ func (s *S3Auth) Handler(next http.Handler) http.Handler {
 		pathSliceLen := len(pathSlice)
 		if pathSliceLen < 2 {
 			monitoring.Log().Warn("S3Auth invalid path")
-			res := response.NewString(400, "invalid path")
-			res.Send(resWriter)
+			s3Error(resWriter, req, 400, "InvalidURI", "invalid path")
 			return
 		}
 
@@ -109,16 +115,15 @@ func (s *S3Auth) Handler(next http.Handler) http.Handler {
 			return
 		}
 
-		credential, ok := s.getCredentials(bucketName, accessKey, resWriter)
+		credential, ok := s.getCredentials(req, bucketName, accessKey, resWriter)
 		if !ok {
 			return
 		}
 
 		validiatonReq, err := http.NewRequest(req.Method, req.RequestURI, req.Body)
 		if err != nil {
-			res := response.NewString(401, "")
 			monitoring.Log().Error("S3Auth unable to create validation req", zap.Error(err))
-			res.Send(resWriter)
+			s3Error(resWriter, req, 401, "AccessDenied", "unable to validate request")
 			return
 		}
 
@@ -139,6 +144,10 @@ func (s *S3Auth) Handler(next http.Handler) http.Handler {
 
 		// FIXME: there will be problem with escaped paths
 		validiatonReq.URL = req.URL
+		if authAlg != "s3" {
+			// v4 signature covers path sent by client, v2 covers path with bucket also in virtual-host style
+			validiatonReq.URL = OriginalURL(req)
+		}
 		validiatonReq.Method = req.Method
 		validiatonReq.Body = req.Body
 		validiatonReq.Host = req.Host
@@ -164,28 +173,34 @@ func (s *S3Auth) Handler(next http.Handler) http.Handler {
 		}
 
 		monitoring.Log().Warn("S3Auth signature mismatch", zap.String("req.path", req.URL.Path), zap.String("req.method", req.Method))
-		response.NewNoContent(403).Send(resWriter)
+		s3Error(resWriter, req, 403, "SignatureDoesNotMatch", "request signature does not match")
 		return
 	}
 
 	return http.HandlerFunc(fn)
 }
-func (s *S3Auth) getCredentials(bucketName, accessKey string, w http.ResponseWriter) (awsauth.Credentials, bool) {
+
+// s3Error send error in S3 XML format, so S3 clients can handle it
+func s3Error(w http.ResponseWriter, req *http.Request, statusCode int, code, message string) {
+	response.NewError(statusCode, response.NewCodedError(code, message)).
+		FormatError(response.ErrorFormatS3, RequestIDFromContext(req.Context()), "").Send(w)
+}
+
+func (s *S3Auth) getCredentials(req *http.Request, bucketName, accessKey string, w http.ResponseWriter) (awsauth.Credentials, bool) {
 	var credential awsauth.Credentials
 	bucket, ok := s.mortConfig.Buckets[bucketName]
 	if !ok {
 		buckets := s.mortConfig.BucketsByAccessKey(accessKey)
 		if len(buckets) == 0 {
 			monitoring.Log().Warn("S3Auth no bucket for access key")
-			res := response.NewString(403, "")
-			res.Send(w)
+			s3Error(w, req, 403, "InvalidAccessKeyId", "access key does not exist")
 			return credential, false
 		}
 
 		bucket = buckets[0]
 	}
 
-	keys := bucket.Keys
+	keys := bucket.ResolvedKeys()
 	for _, key := range keys {
 		if accessKey == key.AccessKey {
 			credential.AccessKeyID = accessKey
@@ -195,9 +210,8 @@ func (s *S3Auth) getCredentials(bucketName, accessKey string, w http.ResponseWri
 
 	}
 	if credential.AccessKeyID == "" {
-		res := response.NewString(401, "")
 		monitoring.Log().Warn("S3Auth invalid bucket config no access key or invalid", zap.String("bucket", bucketName))
-		res.Send(w)
+		s3Error(w, req, 401, "InvalidAccessKeyId", "access key does not exist")
 		return credential, false
 	}
 
@@ -234,8 +248,7 @@ func (s *S3Auth) listAllMyBuckets(resWriter http.ResponseWriter, accessKey strin
 
 	b, err := xml.Marshal(listAllBucketsXML)
 	if err != nil {
-		res := response.NewError(500, err)
-		res.Send(resWriter)
+		response.NewError(500, err).FormatError(response.ErrorFormatS3, "", "").Send(resWriter)
 		return
 	}
 
@@ -246,6 +259,7 @@ func (s *S3Auth) listAllMyBuckets(resWriter http.ResponseWriter, accessKey strin
 
 func (s *S3Auth) authByQuery(resWriter http.ResponseWriter, r *http.Request, bucketName string, next http.Handler) {
 	validationReq := *r
+	validationReq.URL = OriginalURL(r)
 	mortConfig := s.mortConfig
 
 	validationReq.URL.Query().Del("X-Amz-Signature")
@@ -257,8 +271,7 @@ func (s *S3Auth) authByQuery(resWriter http.ResponseWriter, r *http.Request, buc
 		buckets := mortConfig.BucketsByAccessKey(accessKey)
 		if len(buckets) == 0 {
 			monitoring.Log().Warn("S3Auth no bucket for access key")
-			res := response.NewString(403, "")
-			res.Send(resWriter)
+			s3Error(resWriter, r, 403, "InvalidAccessKeyId", "access key does not exist")
 			return
 		}
 
@@ -266,13 +279,12 @@ func (s *S3Auth) authByQuery(resWriter http.ResponseWriter, r *http.Request, buc
 	}
 
 	if r.URL.Query().Get("X-Amz-Credential") == "" || r.URL.Query().Get("X-Amz-Date") == "" {
-		res := response.NewString(401, "")
 		monitoring.Log().Warn("S3Auth invalid request no x-amz-credential in query string", zap.String("bucket", bucketName))
-		res.Send(resWriter)
+		s3Error(resWriter, r, 401, "AuthorizationQueryParametersError", "missing X-Amz-Credential or X-Amz-Date")
 		return
 	}
 
-	keys := bucket.Keys
+	keys := bucket.ResolvedKeys()
 	for _, key := range keys {
 		if accessKey == key.AccessKey {
 			credential.AccessKeyID = accessKey
@@ -283,9 +295,8 @@ func (s *S3Auth) authByQuery(resWriter http.ResponseWriter, r *http.Request, buc
 	}
 
 	if credential.AccessKeyID == "" {
-		res := response.NewString(401, "")
 		monitoring.Log().Warn("S3Auth invalid bucket config no access key or invalid", zap.String("bucket", bucketName))
-		res.Send(resWriter)
+		s3Error(resWriter, r, 401, "InvalidAccessKeyId", "access key does not exist")
 		return
 	}
 
@@ -299,7 +310,7 @@ func (s *S3Auth) authByQuery(resWriter http.ResponseWriter, r *http.Request, buc
 	}
 
 	monitoring.Log().Warn("S3Auth signature mismatch", zap.String("req.path", r.URL.Path))
-	response.NewNoContent(403).Send(resWriter)
+	s3Error(resWriter, r, 403, "SignatureDoesNotMatch", "request signature does not match")
 	return
 
 }
@@ -51,6 +51,32 @@ func TestS3Auth_Handler401(t *testing.T) {
 	assert.Equal(t, recorder.Code, 401)
 }
 
+func TestS3Auth_Handler401FormBatchDelete(t *testing.T) {
+	configData := config.GetInstance()
+	configData.Load("./config.yml")
+
+	s3 := NewS3AuthMiddleware(configData)
+
+	next := nextHandler{}
+	fn := s3.Handler(&next)
+
+	req, _ := http.NewRequest("POST", "http://mort/local?delete", nil)
+	req.Header.Set("Content-Type", "multipart/form-data; boundary=x")
+	recorder := httptest.NewRecorder()
+	fn.ServeHTTP(recorder, req)
+
+	assert.False(t, next.called, "batch delete shouldn't be treated as form upload")
+	assert.Equal(t, recorder.Code, 401)
+
+	next = nextHandler{}
+	fn = s3.Handler(&next)
+	req, _ = http.NewRequest("POST", "http://mort/local", nil)
+	req.Header.Set("Content-Type", "multipart/form-data; boundary=x")
+	fn.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.True(t, next.called, "form upload is authorized by policy")
+}
+
 func TestS3Auth_Handler200S3(t *testing.T) {
 	configData := config.GetInstance()
 	configData.Load("./config.yml")
@@ -113,6 +139,8 @@ func TestS3Auth_Handler403S3Put(t *testing.T) {
 
 	assert.False(t, next.called)
 	assert.Equal(t, recorder.Code, 403)
+	assert.Equal(t, "application/xml", recorder.Header().Get("Content-Type"))
+	assert.Contains(t, recorder.Body.String(), "<Code>SignatureDoesNotMatch</Code>")
 }
 
 func TestS3Auth_Handler401S3Put_2(t *testing.T) {
@@ -135,6 +163,7 @@ func TestS3Auth_Handler401S3Put_2(t *testing.T) {
 
 	assert.False(t, next.called)
 	assert.Equal(t, recorder.Code, 401)
+	assert.Contains(t, recorder.Body.String(), "<Code>InvalidAccessKeyId</Code>")
 }
 
 func TestS3Auth_HandlerS3LitBucket(t *testing.T) {
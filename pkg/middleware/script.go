@@ -0,0 +1,178 @@
+package middleware
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/aldor007/mort/pkg/config"
+	"github.com/aldor007/mort/pkg/monitoring"
+	"github.com/aldor007/mort/pkg/response"
+	"go.uber.org/zap"
+)
+
+// scriptFuncs are helper functions available in request scripts
+var scriptFuncs = template.FuncMap{
+	"hasPrefix":  strings.HasPrefix,
+	"hasSuffix":  strings.HasSuffix,
+	"trimPrefix": strings.TrimPrefix,
+	"trimSuffix": strings.TrimSuffix,
+	"contains":   strings.Contains,
+	"replace":    strings.ReplaceAll,
+	"lower":      strings.ToLower,
+	"upper":      strings.ToUpper,
+	"split":      strings.Split,
+	"match": func(pattern, s string) (bool, error) {
+		return regexp.MatchString(pattern, s)
+	},
+	"submatch": func(pattern, s string) ([]string, error) {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		return re.FindStringSubmatch(s), nil
+	},
+}
+
+// ScriptRequest is data passed to request script, its methods are used to modify request
+type ScriptRequest struct {
+	Method     string      // Method of request
+	Bucket     string      // Bucket is name of requested bucket
+	Key        string      // Key of requested object, it starts with /
+	Query      url.Values  // Query parameters of request
+	Header     http.Header // Header of request
+	RemoteAddr string      // RemoteAddr of client
+
+	headers map[string]string
+	status  int
+	message string
+}
+
+// SetKey replaces key of requested object, presets are chosen by setting key matching preset path of bucket
+func (s *ScriptRequest) SetKey(key string) string {
+	if !strings.HasPrefix(key, "/") {
+		key = "/" + key
+	}
+	s.Key = key
+	return ""
+}
+
+// SetQuery replaces value of query parameter, empty value removes it
+func (s *ScriptRequest) SetQuery(name, value string) string {
+	if value == "" {
+		s.Query.Del(name)
+	} else {
+		s.Query.Set(name, value)
+	}
+	return ""
+}
+
+// SetHeader adds header to response
+func (s *ScriptRequest) SetHeader(name, value string) string {
+	s.headers[name] = value
+	return ""
+}
+
+// Reject stops processing of request and returns response with given status code
+func (s *ScriptRequest) Reject(status int, message string) string {
+	s.status = status
+	s.message = message
+	return ""
+}
+
+// Script middleware runs per bucket scripts which can rewrite, annotate or reject requests
+// Scripts are text/template templates executed with ScriptRequest, their output is ignored
+type Script struct {
+	scripts map[string]*template.Template
+}
+
+// NewScriptMiddleware create instance of Script middleware, it panics when script of any bucket is invalid
+func NewScriptMiddleware(mortConfig *config.Config) *Script {
+	s := &Script{scripts: make(map[string]*template.Template)}
+	for name, bucket := range mortConfig.Buckets {
+		if bucket.Script == "" {
+			continue
+		}
+
+		tmpl, err := template.New(name).Funcs(scriptFuncs).Option("missingkey=zero").Parse(bucket.Script)
+		if err != nil {
+			panic(fmt.Errorf("invalid script of bucket %s %s", name, err))
+		}
+		s.scripts[name] = tmpl
+	}
+
+	return s
+}
+
+// Handler runs script of requested bucket. Request is passed to next handler with rewritten URL
+// or rejected when script called Reject
+func (s *Script) Handler(next http.Handler) http.Handler {
+	fn := func(resWriter http.ResponseWriter, req *http.Request) {
+		if len(s.scripts) == 0 {
+			next.ServeHTTP(resWriter, req)
+			return
+		}
+
+		parts := strings.SplitN(strings.TrimPrefix(req.URL.Path, "/"), "/", 2)
+		tmpl, ok := s.scripts[parts[0]]
+		if !ok {
+			next.ServeHTTP(resWriter, req)
+			return
+		}
+
+		sr := &ScriptRequest{
+			Method:     req.Method,
+			Bucket:     parts[0],
+			Query:      req.URL.Query(),
+			Header:     req.Header,
+			RemoteAddr: req.RemoteAddr,
+			headers:    make(map[string]string),
+		}
+		if len(parts) == 2 {
+			sr.Key = "/" + parts[1]
+		}
+		key := sr.Key
+
+		if err := tmpl.Execute(ioutil.Discard, sr); err != nil {
+			monitoring.Log().Error("Script execution error", zap.String("bucket", sr.Bucket), zap.String("req.path", req.URL.Path),
+				zap.String("requestId", RequestIDFromContext(req.Context())), zap.Error(err))
+			monitoring.Report().Inc("script_count;result:error")
+			response.NewError(500, err).Send(resWriter)
+			return
+		}
+
+		for name, value := range sr.headers {
+			resWriter.Header().Set(name, value)
+		}
+
+		if sr.status != 0 {
+			monitoring.Report().Inc("script_count;result:rejected")
+			if sr.message != "" {
+				response.NewString(sr.status, sr.message).Send(resWriter)
+			} else {
+				response.NewNoContent(sr.status).Send(resWriter)
+			}
+			return
+		}
+
+		if sr.Key != key || sr.Query.Encode() != req.URL.Query().Encode() {
+			monitoring.Report().Inc("script_count;result:rewritten")
+			u := *req.URL
+			u.Path = "/" + sr.Bucket + sr.Key
+			u.RawPath = ""
+			u.RawQuery = sr.Query.Encode()
+			req.URL = &u
+			req.RequestURI = u.RequestURI()
+		} else {
+			monitoring.Report().Inc("script_count;result:passed")
+		}
+
+		next.ServeHTTP(resWriter, req)
+	}
+
+	return http.HandlerFunc(fn)
+}
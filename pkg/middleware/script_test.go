@@ -0,0 +1,92 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aldor007/mort/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+type scriptHandler struct {
+	called bool
+	path   string
+	query  string
+}
+
+func (s *scriptHandler) ServeHTTP(_ http.ResponseWriter, req *http.Request) {
+	s.called = true
+	s.path = req.URL.Path
+	s.query = req.URL.RawQuery
+}
+
+func scriptConfig(script string) *config.Config {
+	c := &config.Config{}
+	c.Buckets = map[string]config.Bucket{"media": {Script: script}}
+	return c
+}
+
+func TestScript_HandlerRewrite(t *testing.T) {
+	s := NewScriptMiddleware(scriptConfig(`
+{{- if hasPrefix .Key "/legacy/" }}{{ .SetKey (trimPrefix .Key "/legacy") }}{{ end -}}
+{{- if eq (.Query.Get "size") "small" }}{{ .SetKey (printf "/small%s" .Key) }}{{ .SetQuery "size" "" }}{{ end -}}
+`))
+	next := &scriptHandler{}
+	req := httptest.NewRequest("GET", "http://mort/media/legacy/image.jpg?size=small", nil)
+
+	s.Handler(next).ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.True(t, next.called)
+	assert.Equal(t, "/media/small/image.jpg", next.path)
+	assert.Equal(t, "", next.query)
+}
+
+func TestScript_HandlerHeaderAndReject(t *testing.T) {
+	s := NewScriptMiddleware(scriptConfig(`
+{{- .SetHeader "X-Script" "1" -}}
+{{- if not (match "\\.(jpg|png)$" .Key) }}{{ .Reject 403 "forbidden" }}{{ end -}}
+`))
+
+	next := &scriptHandler{}
+	rec := httptest.NewRecorder()
+	s.Handler(next).ServeHTTP(rec, httptest.NewRequest("GET", "http://mort/media/image.jpg", nil))
+
+	assert.True(t, next.called)
+	assert.Equal(t, "/media/image.jpg", next.path)
+	assert.Equal(t, "1", rec.Header().Get("X-Script"))
+
+	next = &scriptHandler{}
+	rec = httptest.NewRecorder()
+	s.Handler(next).ServeHTTP(rec, httptest.NewRequest("GET", "http://mort/media/doc.pdf", nil))
+
+	assert.False(t, next.called)
+	assert.Equal(t, 403, rec.Code)
+	assert.Equal(t, "forbidden", rec.Body.String())
+}
+
+func TestScript_HandlerOtherBucket(t *testing.T) {
+	s := NewScriptMiddleware(scriptConfig(`{{ .Reject 403 "" }}`))
+	next := &scriptHandler{}
+
+	s.Handler(next).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://mort/other/image.jpg", nil))
+
+	assert.True(t, next.called)
+}
+
+func TestScript_HandlerError(t *testing.T) {
+	s := NewScriptMiddleware(scriptConfig(`{{ submatch "(" .Key }}`))
+	next := &scriptHandler{}
+	rec := httptest.NewRecorder()
+
+	s.Handler(next).ServeHTTP(rec, httptest.NewRequest("GET", "http://mort/media/image.jpg", nil))
+
+	assert.False(t, next.called)
+	assert.Equal(t, 500, rec.Code)
+}
+
+func TestNewScriptMiddlewareInvalid(t *testing.T) {
+	assert.Panics(t, func() {
+		NewScriptMiddleware(scriptConfig(`{{ if }}`))
+	})
+}
@@ -0,0 +1,393 @@
+// Package migrate copies objects between storages of mort
+// It is used by "mort sync" subcommand, for example when originals are moved from local disk to S3
+package migrate
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aldor007/mort/pkg/config"
+	"github.com/aldor007/mort/pkg/monitoring"
+	"github.com/aldor007/mort/pkg/object"
+	"github.com/aldor007/mort/pkg/storage"
+	"github.com/aldor007/mort/pkg/throttler"
+	"go.uber.org/zap"
+)
+
+// listPageSize number of objects fetched from storage in single list request
+const listPageSize = 1000
+
+// headerMetaContentMD5 metadata under which checksum of copied object is stored, the same as for verified uploads
+const headerMetaContentMD5 = "X-Amz-Meta-Content-Md5"
+
+var errChecksum = errors.New("checksum of copied object doesn't match source")
+
+// Endpoint is storage of bucket from or to which objects are copied
+type Endpoint struct {
+	Bucket  string
+	Storage config.Storage
+}
+
+// Options of sync
+type Options struct {
+	Src            Endpoint
+	Dst            Endpoint
+	Prefix         string              // copy only objects which keys start with prefix
+	ModifiedAfter  time.Time           // copy only objects modified after given time, zero value disables filter
+	ModifiedBefore time.Time           // copy only objects modified before given time, zero value disables filter
+	Concurrency    int                 // number of objects copied at once (default 1)
+	Overwrite      bool                // copy objects which already exist in destination with the same size and checksum
+	Verify         bool                // read copied object from destination and compare its checksum with source
+	DryRun         bool                // only report objects which would be copied
+	Throttler      throttler.Throttler // copies are throttled with batch priority, nil disables throttling
+	StateFile      string              // file in which progress is saved, sync started again with the same file continue from it
+}
+
+// Stats summary of sync
+type Stats struct {
+	Listed  int64 `json:"listed"`
+	Copied  int64 `json:"copied"`
+	Skipped int64 `json:"skipped"`
+	Failed  int64 `json:"failed"`
+	Bytes   int64 `json:"bytes"`
+}
+
+// state is progress of sync saved in state file
+type state struct {
+	Src    string `json:"src"`
+	Dst    string `json:"dst"`
+	Prefix string `json:"prefix"`
+	Marker string `json:"marker"` // marker of first page of listing which wasn't copied
+	Stats  Stats  `json:"stats"`
+}
+
+// Sync copies objects from source to destination storage
+// Objects are listed page by page, progress is saved to state file after whole page is copied
+// Marker in state file isn't moved past page with failed copies, so next run copies them again
+// Sync returns error when listing fails or ctx is cancelled, failed copies of single objects are only counted in stats
+func Sync(ctx context.Context, opts Options) (Stats, error) {
+	if opts.Concurrency < 1 {
+		opts.Concurrency = 1
+	}
+
+	current := state{Src: endpointName(opts.Src), Dst: endpointName(opts.Dst), Prefix: opts.Prefix}
+	if opts.StateFile != "" {
+		if err := loadState(opts.StateFile, &current); err != nil {
+			return Stats{}, err
+		}
+	}
+
+	stats := &current.Stats
+	// objects failed in previous run are copied again
+	stats.Failed = 0
+	listObj := newObject(opts.Src, "")
+	marker := current.Marker
+	for {
+		if err := ctx.Err(); err != nil {
+			return *stats, err
+		}
+
+		items, nextMarker, err := storage.ListItems(listObj, strings.TrimPrefix(opts.Prefix, "/"), marker, listPageSize)
+		if err != nil {
+			return *stats, err
+		}
+
+		copyPage(ctx, opts, items, stats)
+		if err := ctx.Err(); err != nil {
+			return *stats, err
+		}
+
+		if nextMarker == "" || nextMarker == marker {
+			break
+		}
+
+		marker = nextMarker
+		if atomic.LoadInt64(&stats.Failed) == 0 {
+			current.Marker = nextMarker
+		}
+		if opts.StateFile != "" && !opts.DryRun {
+			if err := saveState(opts.StateFile, current); err != nil {
+				return *stats, err
+			}
+		}
+	}
+
+	// state is kept when some objects failed, so they are copied again in next run
+	if opts.StateFile != "" && stats.Failed == 0 && !opts.DryRun {
+		os.Remove(opts.StateFile)
+	}
+
+	return *stats, nil
+}
+
+// copyPage copies listed items with concurrency given in options
+func copyPage(ctx context.Context, opts Options, items []storage.Item, stats *Stats) {
+	keys := make(chan storage.Item)
+	var wg sync.WaitGroup
+	for i := 0; i < opts.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range keys {
+				copyItem(ctx, opts, item, stats)
+			}
+		}()
+	}
+
+	for _, item := range items {
+		if !match(opts, item) {
+			continue
+		}
+
+		select {
+		case keys <- item:
+		case <-ctx.Done():
+		}
+
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	close(keys)
+	wg.Wait()
+}
+
+// match check if item should be copied according to filters from options
+func match(opts Options, item storage.Item) bool {
+	if !strings.HasPrefix("/"+item.Key, "/"+strings.TrimPrefix(opts.Prefix, "/")) {
+		return false
+	}
+
+	if !opts.ModifiedAfter.IsZero() && !item.LastModified.After(opts.ModifiedAfter) {
+		return false
+	}
+
+	if !opts.ModifiedBefore.IsZero() && !item.LastModified.Before(opts.ModifiedBefore) {
+		return false
+	}
+
+	return true
+}
+
+func copyItem(ctx context.Context, opts Options, item storage.Item, stats *Stats) {
+	if opts.Throttler != nil {
+		if !throttler.TakeBatch(ctx, opts.Throttler) {
+			return
+		}
+		defer opts.Throttler.Release()
+	}
+
+	atomic.AddInt64(&stats.Listed, 1)
+	key := "/" + item.Key
+	src := newObject(opts.Src, key)
+	dst := newObject(opts.Dst, key)
+
+	if !opts.Overwrite && exists(src, dst) {
+		atomic.AddInt64(&stats.Skipped, 1)
+		return
+	}
+
+	if opts.DryRun {
+		monitoring.Log().Info("Migrate/Sync would copy object", zap.String("key", key), zap.Int64("size", item.Size))
+		atomic.AddInt64(&stats.Copied, 1)
+		return
+	}
+
+	size, err := copyObject(src, dst, opts.Verify)
+	if err != nil {
+		atomic.AddInt64(&stats.Failed, 1)
+		monitoring.Log().Warn("Migrate/Sync unable to copy object", zap.String("key", key), zap.Error(err))
+		return
+	}
+
+	atomic.AddInt64(&stats.Copied, 1)
+	atomic.AddInt64(&stats.Bytes, size)
+}
+
+// exists check if destination has object with the same size and checksum as source
+// Checksums are compared only when both storages return MD5 as ETag
+func exists(src, dst *object.FileObject) bool {
+	dstRes := storage.Head(dst)
+	dstRes.Close()
+	if dstRes.StatusCode != 200 {
+		return false
+	}
+
+	srcRes := storage.Head(src)
+	srcRes.Close()
+	if srcRes.StatusCode != 200 || srcRes.ContentLength != dstRes.ContentLength {
+		return false
+	}
+
+	srcSum, dstSum := etagMD5(srcRes.Headers.Get("ETag")), etagMD5(dstRes.Headers.Get("ETag"))
+	return srcSum == "" || dstSum == "" || srcSum == dstSum
+}
+
+// md5Reader computes MD5 of read data and returns errChecksum at the end of data when it doesn't match expected sum
+// Error of body aborts write to storage, so checksum stored in metadata is never kept with other content
+type md5Reader struct {
+	reader   io.Reader
+	hash     hash.Hash
+	expected []byte // empty when source has no MD5
+	mismatch bool
+}
+
+func newMD5Reader(body io.Reader, expected []byte) *md5Reader {
+	m := &md5Reader{hash: md5.New(), expected: expected}
+	m.reader = io.TeeReader(body, m.hash)
+	return m
+}
+
+func (m *md5Reader) Read(p []byte) (int, error) {
+	n, err := m.reader.Read(p)
+	if err == io.EOF && len(m.expected) != 0 && !bytes.Equal(m.hash.Sum(nil), m.expected) {
+		m.mismatch = true
+		return n, errChecksum
+	}
+
+	return n, err
+}
+
+// copyObject copies object with its metadata and returns number of copied bytes
+// MD5 of content is verified during copy with ETag of source when it is MD5, only verified MD5 is stored in metadata
+// Object which fails verification is removed from destination
+func copyObject(src, dst *object.FileObject, verify bool) (int64, error) {
+	res := storage.Get(src)
+	defer res.Close()
+	if res.StatusCode != 200 {
+		return 0, fmt.Errorf("unable to read source, status code %d", res.StatusCode)
+	}
+
+	headers := res.Headers.Clone()
+	headers.Del(headerMetaContentMD5)
+	expected, _ := hex.DecodeString(etagMD5(res.Headers.Get("ETag")))
+	if len(expected) != 0 {
+		headers.Set(headerMetaContentMD5, base64.StdEncoding.EncodeToString(expected))
+	}
+
+	body := newMD5Reader(res.Stream(), expected)
+	setRes := storage.Set(dst, headers, res.ContentLength, body)
+	setRes.Close()
+	if body.mismatch {
+		if setRes.StatusCode == 200 {
+			// storage ignored error of body
+			storage.Delete(dst).Close()
+		}
+		return 0, errChecksum
+	}
+
+	if setRes.StatusCode != 200 {
+		return 0, fmt.Errorf("unable to write destination, status code %d", setRes.StatusCode)
+	}
+
+	if verify {
+		if err := verifyObject(dst, body.hash.Sum(nil)); err != nil {
+			storage.Delete(dst).Close()
+			return 0, err
+		}
+	}
+
+	return res.ContentLength, nil
+}
+
+// verifyObject reads object from destination and compare its MD5 with sum
+func verifyObject(dst *object.FileObject, sum []byte) error {
+	res := storage.Get(dst)
+	defer res.Close()
+	if res.StatusCode != 200 {
+		return fmt.Errorf("unable to read destination, status code %d", res.StatusCode)
+	}
+
+	hash := md5.New()
+	if _, err := io.Copy(hash, res.Stream()); err != nil {
+		return err
+	}
+
+	if !bytes.Equal(hash.Sum(nil), sum) {
+		return errChecksum
+	}
+
+	return nil
+}
+
+// etagMD5 returns MD5 from ETag, empty string when ETag isn't MD5 (for example ETag of S3 multipart upload)
+func etagMD5(etag string) string {
+	etag = strings.Trim(strings.TrimPrefix(etag, "W/"), "\"")
+	if len(etag) != md5.Size*2 {
+		return ""
+	}
+
+	if _, err := hex.DecodeString(etag); err != nil {
+		return ""
+	}
+
+	return strings.ToLower(etag)
+}
+
+// loadState reads progress from state file, state of other sync is an error
+func loadState(filePath string, current *state) error {
+	data, err := ioutil.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	saved := state{}
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return fmt.Errorf("invalid state file %s: %s", filePath, err)
+	}
+
+	if saved.Src != current.Src || saved.Dst != current.Dst || saved.Prefix != current.Prefix {
+		return fmt.Errorf("state file %s was created by sync from %s to %s with prefix %q", filePath, saved.Src, saved.Dst, saved.Prefix)
+	}
+
+	*current = saved
+	return nil
+}
+
+// saveState writes progress to state file
+func saveState(filePath string, current state) error {
+	current.Stats = Stats{
+		Listed:  atomic.LoadInt64(&current.Stats.Listed),
+		Copied:  atomic.LoadInt64(&current.Stats.Copied),
+		Skipped: atomic.LoadInt64(&current.Stats.Skipped),
+		Failed:  atomic.LoadInt64(&current.Stats.Failed),
+		Bytes:   atomic.LoadInt64(&current.Stats.Bytes),
+	}
+	data, err := json.Marshal(current)
+	if err != nil {
+		return err
+	}
+
+	tmpPath := filePath + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, filePath)
+}
+
+func endpointName(e Endpoint) string {
+	return e.Bucket + "/" + e.Storage.Hash
+}
+
+func newObject(e Endpoint, key string) *object.FileObject {
+	return &object.FileObject{Uri: &url.URL{Path: "/" + e.Bucket + key}, Bucket: e.Bucket, Key: key, Storage: e.Storage}
+}
@@ -0,0 +1,151 @@
+package migrate
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/aldor007/mort/pkg/config"
+	"github.com/aldor007/mort/pkg/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+const testConfig = `
+buckets:
+    %s:
+        storages:
+            basic:
+                kind: "local-meta"
+                rootPath: "%s/src"
+            target:
+                kind: "local-meta"
+                rootPath: "%s/dst"
+`
+
+// testEndpoints create bucket with two storages in temporary directory
+// Storage clients are cached by bucket name, so every test should use other bucket
+func testEndpoints(t *testing.T, bucketName string) (Endpoint, Endpoint, string) {
+	dir, err := ioutil.TempDir("", "mort-migrate")
+	assert.Nil(t, err)
+	t.Cleanup(func() {
+		os.RemoveAll(dir)
+	})
+	os.MkdirAll(dir+"/src", 0755)
+	os.MkdirAll(dir+"/dst", 0755)
+
+	mortConfig := &config.Config{}
+	err = mortConfig.LoadFromString(fmt.Sprintf(testConfig, bucketName, dir, dir))
+	assert.Nil(t, err)
+	bucket := mortConfig.Buckets[bucketName]
+	return Endpoint{Bucket: bucketName, Storage: bucket.Storages.Basic()}, Endpoint{Bucket: bucketName, Storage: bucket.Storages.Get("target")}, dir
+}
+
+func store(t *testing.T, e Endpoint, key, body string) {
+	res := storage.Set(newObject(e, key), http.Header{"Content-Type": []string{"image/jpeg"}}, int64(len(body)), bytes.NewReader([]byte(body)))
+	assert.Equal(t, 200, res.StatusCode)
+}
+
+func read(e Endpoint, key string) (int, string) {
+	res := storage.Get(newObject(e, key))
+	defer res.Close()
+	body, _ := res.Body()
+	return res.StatusCode, string(body)
+}
+
+func TestSync(t *testing.T) {
+	src, dst, _ := testEndpoints(t, "sync")
+	store(t, src, "/photos/a.jpg", "aaaa")
+	store(t, src, "/photos/b.jpg", "bbbb")
+	store(t, src, "/other/c.jpg", "cccc")
+
+	stats, err := Sync(context.Background(), Options{Src: src, Dst: dst, Prefix: "/photos", Concurrency: 2, Verify: true})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), stats.Copied)
+	assert.Equal(t, int64(8), stats.Bytes)
+	assert.Equal(t, int64(0), stats.Failed)
+
+	status, body := read(dst, "/photos/a.jpg")
+	assert.Equal(t, 200, status)
+	assert.Equal(t, "aaaa", body)
+	status, _ = read(dst, "/other/c.jpg")
+	assert.Equal(t, 404, status)
+
+	stats, err = Sync(context.Background(), Options{Src: src, Dst: dst, Prefix: "/photos"})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), stats.Copied)
+	assert.Equal(t, int64(2), stats.Skipped, "objects existing in destination should be skipped")
+
+	stats, err = Sync(context.Background(), Options{Src: src, Dst: dst, Prefix: "/photos", Overwrite: true})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), stats.Copied)
+}
+
+func TestSyncFilters(t *testing.T) {
+	src, dst, _ := testEndpoints(t, "sync-filters")
+	store(t, src, "/a.jpg", "aaaa")
+
+	stats, err := Sync(context.Background(), Options{Src: src, Dst: dst, ModifiedAfter: time.Now().Add(time.Hour)})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), stats.Listed)
+
+	stats, err = Sync(context.Background(), Options{Src: src, Dst: dst, ModifiedBefore: time.Now().Add(time.Hour), DryRun: true})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), stats.Copied)
+	status, _ := read(dst, "/a.jpg")
+	assert.Equal(t, 404, status, "dry run shouldn't copy objects")
+}
+
+func TestSyncState(t *testing.T) {
+	src, dst, dir := testEndpoints(t, "sync-state")
+	store(t, src, "/a.jpg", "aaaa")
+	stateFile := path.Join(dir, "state.json")
+
+	err := saveState(stateFile, state{Src: "other", Dst: endpointName(dst)})
+	assert.Nil(t, err)
+	_, err = Sync(context.Background(), Options{Src: src, Dst: dst, StateFile: stateFile})
+	assert.NotNil(t, err, "state of other sync should be rejected")
+
+	err = saveState(stateFile, state{Src: endpointName(src), Dst: endpointName(dst), Stats: Stats{Copied: 10, Failed: 1}})
+	assert.Nil(t, err)
+	stats, err := Sync(context.Background(), Options{Src: src, Dst: dst, StateFile: stateFile})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(11), stats.Copied, "stats should be continued from state file")
+	_, err = os.Stat(stateFile)
+	assert.True(t, os.IsNotExist(err), "state file should be removed after successful sync")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = Sync(ctx, Options{Src: src, Dst: dst})
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestMD5Reader(t *testing.T) {
+	sum, _ := hex.DecodeString("74b87337454200d4d33f80c4663dc5e5")
+	body := newMD5Reader(bytes.NewReader([]byte("aaaa")), sum)
+	_, err := ioutil.ReadAll(body)
+	assert.Nil(t, err)
+	assert.False(t, body.mismatch)
+
+	body = newMD5Reader(bytes.NewReader([]byte("bbbb")), sum)
+	_, err = ioutil.ReadAll(body)
+	assert.Equal(t, errChecksum, err)
+	assert.True(t, body.mismatch)
+
+	body = newMD5Reader(bytes.NewReader([]byte("bbbb")), nil)
+	_, err = ioutil.ReadAll(body)
+	assert.Nil(t, err, "body without expected sum should be only hashed")
+}
+
+func TestEtagMD5(t *testing.T) {
+	assert.Equal(t, "d41d8cd98f00b204e9800998ecf8427e", etagMD5("\"d41d8cd98f00b204e9800998ecf8427e\""))
+	assert.Equal(t, "d41d8cd98f00b204e9800998ecf8427e", etagMD5("W/\"D41D8CD98F00B204E9800998ECF8427E\""))
+	assert.Equal(t, "", etagMD5("\"d41d8cd98f00b204e9800998ecf8427e-2\""))
+	assert.Equal(t, "", etagMD5("abc"))
+}
@@ -0,0 +1,111 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/aldor007/mort/pkg/monitoring"
+	"github.com/aldor007/mort/pkg/storage"
+	"go.uber.org/zap"
+)
+
+var errNotSharded = errors.New("storage isn't sharded")
+
+// Reshard moves objects of sharded storage to shards which own them after shards were added or removed
+// Objects are copied to owning shard and removed from previous one, Stats.Copied is number of moved objects
+func Reshard(ctx context.Context, e Endpoint, concurrency int, dryRun bool) (Stats, error) {
+	if e.Storage.Kind != "sharded" {
+		return Stats{}, errNotSharded
+	}
+
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	stats := &Stats{}
+	for i, shard := range e.Storage.Shards {
+		shardEndpoint := Endpoint{Bucket: e.Bucket, Storage: shard}
+		listObj := newObject(shardEndpoint, "")
+		marker := ""
+		for {
+			if err := ctx.Err(); err != nil {
+				return *stats, err
+			}
+
+			items, nextMarker, err := storage.ListItems(listObj, "", marker, listPageSize)
+			if err != nil {
+				return *stats, err
+			}
+
+			reshardPage(e, i, items, concurrency, dryRun, stats)
+			if nextMarker == "" || nextMarker == marker {
+				break
+			}
+			marker = nextMarker
+		}
+	}
+
+	return *stats, nil
+}
+
+// reshardPage moves objects from shard with given index which are owned by other shards
+func reshardPage(e Endpoint, shard int, items []storage.Item, concurrency int, dryRun bool, stats *Stats) {
+	keys := make(chan storage.Item)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range keys {
+				moveItem(e, shard, item, dryRun, stats)
+			}
+		}()
+	}
+
+	for _, item := range items {
+		keys <- item
+	}
+
+	close(keys)
+	wg.Wait()
+}
+
+func moveItem(e Endpoint, shard int, item storage.Item, dryRun bool, stats *Stats) {
+	atomic.AddInt64(&stats.Listed, 1)
+	key := "/" + strings.TrimPrefix(item.Key, "/")
+	owner := storage.ShardIndex(e.Storage, key)
+	if owner == shard {
+		atomic.AddInt64(&stats.Skipped, 1)
+		return
+	}
+
+	if dryRun {
+		monitoring.Log().Info("Migrate/Reshard would move object", zap.String("key", key), zap.Int("from", shard), zap.Int("to", owner))
+		atomic.AddInt64(&stats.Copied, 1)
+		return
+	}
+
+	src := newObject(Endpoint{Bucket: e.Bucket, Storage: e.Storage.Shards[shard]}, key)
+	dst := newObject(Endpoint{Bucket: e.Bucket, Storage: e.Storage.Shards[owner]}, key)
+	size, err := copyObject(src, dst, false)
+	if err == nil {
+		res := storage.Delete(src)
+		res.Close()
+		if res.StatusCode != 200 {
+			err = fmt.Errorf("unable to remove object from previous shard, status code %d", res.StatusCode)
+		}
+	}
+
+	if err != nil {
+		atomic.AddInt64(&stats.Failed, 1)
+		monitoring.Log().Warn("Migrate/Reshard unable to move object", zap.String("key", key), zap.Error(err))
+		return
+	}
+
+	atomic.AddInt64(&stats.Copied, 1)
+	atomic.AddInt64(&stats.Bytes, size)
+}
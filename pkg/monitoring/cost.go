@@ -0,0 +1,128 @@
+package monitoring
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// CostRecord describe resources used by single transform, it is used for chargeback of tenants
+type CostRecord struct {
+	Time       time.Time `json:"time"`
+	RequestID  string    `json:"requestId,omitempty"`
+	Bucket     string    `json:"bucket"`
+	Key        string    `json:"key"`
+	Preset     string    `json:"preset"` // name of preset or "query" for transforms from query string
+	Operations []string  `json:"operations"`
+	Engine     string    `json:"engine"`
+	Pixels     int64     `json:"pixels"`   // number of pixels of source image
+	EngineMs   float64   `json:"engineMs"` // wall time of processing by engine in ms
+	BytesOut   int64     `json:"bytesOut"` // size of result in bytes
+}
+
+// BillingSink receives cost records, implementation has to be safe for concurrent use
+// Emit is called during request, so sinks sending records over network should buffer them
+type BillingSink interface {
+	Emit(record CostRecord) error
+	Close() error
+}
+
+// BillingSinkFactory create sink for target given without scheme (e.g. "/var/log/billing.jsonl" for "file:/var/log/billing.jsonl")
+type BillingSinkFactory func(target string) (BillingSink, error)
+
+// billingLock protects billingFactories and billingSink
+var billingLock sync.RWMutex
+
+// billingFactories is map of scheme to factory of billing sinks
+var billingFactories = map[string]BillingSinkFactory{
+	"file":  newFileBillingSink,
+	"kafka": newKafkaBillingSink,
+}
+
+// billingSink receives cost records, nil means that billing records are disabled
+var billingSink BillingSink
+
+// RegisterBillingSinkFactory adds factory of billing sinks with given scheme (e.g. other message broker in custom build of mort)
+func RegisterBillingSinkFactory(scheme string, factory BillingSinkFactory) {
+	billingLock.Lock()
+	defer billingLock.Unlock()
+	billingFactories[scheme] = factory
+}
+
+// NewBillingSink create billing sink for output in form scheme:target
+func NewBillingSink(output string) (BillingSink, error) {
+	i := strings.Index(output, ":")
+	if i <= 0 {
+		return nil, fmt.Errorf("invalid billing output %s, expected scheme:target", output)
+	}
+
+	billingLock.RLock()
+	factory, ok := billingFactories[output[:i]]
+	billingLock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown billing output scheme %s", output[:i])
+	}
+
+	return factory(output[i+1:])
+}
+
+// RegisterBillingSink set sink which receives cost records of all transforms
+func RegisterBillingSink(sink BillingSink) {
+	billingLock.Lock()
+	defer billingLock.Unlock()
+	billingSink = sink
+}
+
+// ReportCost report cost of transform per bucket and preset to reporter and emit billing record when sink is registered
+func ReportCost(record CostRecord) {
+	labels := "bucket:" + record.Bucket + ",preset:" + record.Preset
+	Report().Counter("cost_pixels;"+labels, float64(record.Pixels))
+	Report().Counter("cost_engine_ms;"+labels, record.EngineMs)
+	Report().Counter("cost_bytes_out;"+labels, float64(record.BytesOut))
+
+	billingLock.RLock()
+	sink := billingSink
+	billingLock.RUnlock()
+	if sink == nil {
+		return
+	}
+
+	if err := sink.Emit(record); err != nil {
+		Log().Warn("Billing unable to emit cost record", zap.String("bucket", record.Bucket), zap.String("key", record.Key), zap.Error(err))
+	}
+}
+
+// fileBillingSink appends records to file as JSON lines
+type fileBillingSink struct {
+	lock sync.Mutex
+	file *os.File
+	enc  *json.Encoder
+}
+
+func newFileBillingSink(path string) (BillingSink, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &fileBillingSink{file: file, enc: json.NewEncoder(file)}, nil
+}
+
+// Emit write record as single line
+func (f *fileBillingSink) Emit(record CostRecord) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.enc.Encode(record)
+}
+
+// Close closes file
+func (f *fileBillingSink) Close() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.file.Close()
+}
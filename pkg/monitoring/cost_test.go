@@ -0,0 +1,79 @@
+package monitoring
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type memoryBillingSink struct {
+	records []CostRecord
+}
+
+func (m *memoryBillingSink) Emit(record CostRecord) error {
+	m.records = append(m.records, record)
+	return nil
+}
+
+func (m *memoryBillingSink) Close() error {
+	return nil
+}
+
+func TestNewBillingSink_Invalid(t *testing.T) {
+	_, err := NewBillingSink("/var/log/billing.jsonl")
+	assert.NotNil(t, err)
+
+	_, err = NewBillingSink("unknown:target")
+	assert.NotNil(t, err)
+
+	_, err = NewBillingSink("kafka:localhost:9092")
+	assert.NotNil(t, err, "kafka target without topic should be rejected")
+}
+
+func TestFileBillingSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mort-billing")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	billingPath := path.Join(dir, "billing.jsonl")
+
+	sink, err := NewBillingSink("file:" + billingPath)
+	assert.Nil(t, err)
+	assert.Nil(t, sink.Emit(CostRecord{Bucket: "media", Preset: "small", Pixels: 100}))
+	assert.Nil(t, sink.Emit(CostRecord{Bucket: "media", Preset: "query", BytesOut: 10}))
+	assert.Nil(t, sink.Close())
+
+	data, err := ioutil.ReadFile(billingPath)
+	assert.Nil(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	assert.Len(t, lines, 2)
+
+	record := CostRecord{}
+	assert.Nil(t, json.Unmarshal([]byte(lines[0]), &record))
+	assert.Equal(t, "small", record.Preset)
+	assert.Equal(t, int64(100), record.Pixels)
+}
+
+func TestReportCost(t *testing.T) {
+	assert.NotPanics(t, func() {
+		ReportCost(CostRecord{Bucket: "media", Preset: "small"})
+	})
+
+	sink := &memoryBillingSink{}
+	RegisterBillingSinkFactory("memory", func(_ string) (BillingSink, error) {
+		return sink, nil
+	})
+
+	registered, err := NewBillingSink("memory:")
+	assert.Nil(t, err)
+	RegisterBillingSink(registered)
+	defer RegisterBillingSink(nil)
+
+	ReportCost(CostRecord{Bucket: "media", Preset: "small", EngineMs: 12})
+	assert.Len(t, sink.records, 1)
+	assert.Equal(t, 12., sink.records[0].EngineMs)
+}
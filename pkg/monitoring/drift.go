@@ -0,0 +1,184 @@
+package monitoring
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// driftStat holds averages of transform results for single key (bucket and preset)
+type driftStat struct {
+	Count    int64   `json:"count"`
+	Size     float64 `json:"size"`     // average size of result in bytes
+	Duration float64 `json:"duration"` // average duration of transform in ms
+}
+
+// DriftDetector tracks average size and duration of transforms per bucket and preset and compares them with
+// baseline stored by previous deploy. When difference is greater than threshold it reports alert
+// Baseline is written only when it doesn't exist yet or when reset was requested, so later deploys are compared with the same reference
+type DriftDetector struct {
+	lock         sync.Mutex
+	threshold    float64 // allowed drift in percent
+	minSamples   int64   // number of samples required before comparing with baseline
+	baselinePath string
+	record       bool // averages of this run are saved as new baseline
+	baseline     map[string]driftStat
+	current      map[string]*driftStat
+	alerted      map[string]bool
+}
+
+// NewDriftDetector create instance of DriftDetector and loads baseline from given file if it exists
+// When baseline doesn't exist or reset is true, averages of this run are recorded as new baseline
+func NewDriftDetector(baselinePath string, threshold float64, minSamples int, reset bool) *DriftDetector {
+	d := &DriftDetector{
+		threshold:    threshold,
+		minSamples:   int64(minSamples),
+		baselinePath: baselinePath,
+		baseline:     make(map[string]driftStat),
+		current:      make(map[string]*driftStat),
+		alerted:      make(map[string]bool),
+	}
+
+	if baselinePath == "" {
+		return d
+	}
+
+	if reset {
+		Log().Info("DriftDetector recording new baseline", zap.String("path", baselinePath))
+		d.record = true
+		return d
+	}
+
+	data, err := ioutil.ReadFile(baselinePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			d.record = true
+		} else {
+			Log().Warn("DriftDetector unable to read baseline", zap.String("path", baselinePath), zap.Error(err))
+		}
+		return d
+	}
+
+	if err = json.Unmarshal(data, &d.baseline); err != nil {
+		Log().Warn("DriftDetector invalid baseline", zap.String("path", baselinePath), zap.Error(err))
+	}
+
+	for key := range d.baseline {
+		if !strings.Contains(key, "/") {
+			// baseline of older version is keyed by preset only, it can't be compared so new one is recorded
+			Log().Info("DriftDetector baseline without buckets, recording new baseline", zap.String("path", baselinePath))
+			d.baseline = make(map[string]driftStat)
+			d.record = true
+			break
+		}
+	}
+
+	return d
+}
+
+// driftKey returns key of statistics of preset in bucket, presets with the same name in other buckets have own statistics
+func driftKey(bucket, preset string) string {
+	return bucket + "/" + preset
+}
+
+// Observe add result of single transform of preset in bucket to statistics
+func (d *DriftDetector) Observe(bucket, preset string, size int64, duration time.Duration) {
+	if d == nil {
+		return
+	}
+
+	key := driftKey(bucket, preset)
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	s, ok := d.current[key]
+	if !ok {
+		s = &driftStat{}
+		d.current[key] = s
+	}
+
+	s.Count++
+	s.Size += (float64(size) - s.Size) / float64(s.Count)
+	s.Duration += (float64(duration)/float64(time.Millisecond) - s.Duration) / float64(s.Count)
+
+	if s.Count < d.minSamples {
+		return
+	}
+
+	base, ok := d.baseline[key]
+	if !ok || base.Count < d.minSamples {
+		return
+	}
+
+	d.check(bucket, preset, "size", s.Size, base.Size)
+	d.check(bucket, preset, "duration", s.Duration, base.Duration)
+}
+
+func (d *DriftDetector) check(bucket, preset, metric string, current, base float64) {
+	if base <= 0 {
+		return
+	}
+
+	alertKey := driftKey(bucket, preset) + ";" + metric
+	drift := (current - base) / base * 100
+	if math.Abs(drift) <= d.threshold {
+		d.alerted[alertKey] = false
+		return
+	}
+
+	if d.alerted[alertKey] {
+		return
+	}
+
+	d.alerted[alertKey] = true
+	Log().Warn("DriftDetector transform result drift", zap.String("bucket", bucket), zap.String("preset", preset), zap.String("metric", metric),
+		zap.Float64("current", current), zap.Float64("baseline", base), zap.Float64("driftPercent", drift))
+	Report().Inc("transform_drift_alert;bucket:" + bucket + ",preset:" + preset + ",metric:" + metric)
+}
+
+// Save write current statistics to baseline file when this run records baseline
+// Only keys with enough samples are written, nothing is written until some key has them
+func (d *DriftDetector) Save() error {
+	if d == nil || d.baselinePath == "" || !d.record {
+		return nil
+	}
+
+	d.lock.Lock()
+	result := make(map[string]driftStat, len(d.current))
+	for k, v := range d.current {
+		if v.Count >= d.minSamples {
+			result[k] = *v
+		}
+	}
+	d.lock.Unlock()
+
+	if len(result) == 0 {
+		// empty baseline would stop recording after restart
+		return nil
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(d.baselinePath, data, 0644)
+}
+
+// driftDetector instance for use as singleton, nil means that detection is disabled
+var driftDetector *DriftDetector
+
+// Drift returns registered DriftDetector
+func Drift() *DriftDetector {
+	return driftDetector
+}
+
+// RegisterDriftDetector set DriftDetector used by mort
+func RegisterDriftDetector(d *DriftDetector) {
+	driftDetector = d
+}
@@ -0,0 +1,93 @@
+package monitoring
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDriftDetector_NilSafe(t *testing.T) {
+	var d *DriftDetector
+
+	assert.NotPanics(t, func() {
+		d.Observe("media", "small", 100, time.Millisecond)
+		assert.Nil(t, d.Save())
+	})
+}
+
+func TestDriftDetector_Alert(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mort-drift")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	baselinePath := path.Join(dir, "baseline.json")
+
+	d := NewDriftDetector(baselinePath, 20, 2, false)
+	d.Observe("media", "small", 100, time.Millisecond*10)
+	d.Observe("media", "small", 100, time.Millisecond*10)
+	assert.Nil(t, d.Save())
+
+	d = NewDriftDetector(baselinePath, 20, 2, false)
+	assert.Equal(t, d.baseline["media/small"].Size, 100.)
+
+	d.Observe("media", "small", 110, time.Millisecond*10)
+	d.Observe("media", "small", 110, time.Millisecond*10)
+	assert.False(t, d.alerted["media/small;size"])
+
+	d.Observe("media", "small", 400, time.Millisecond*10)
+	assert.True(t, d.alerted["media/small;size"])
+	assert.False(t, d.alerted["media/small;duration"])
+}
+
+func TestDriftDetector_KeepBaseline(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mort-drift")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	baselinePath := path.Join(dir, "baseline.json")
+
+	d := NewDriftDetector(baselinePath, 20, 1, false)
+	d.Observe("media", "small", 100, time.Millisecond*10)
+	assert.Nil(t, d.Save())
+
+	d = NewDriftDetector(baselinePath, 20, 1, false)
+	d.Observe("media", "small", 400, time.Millisecond*10)
+	assert.Nil(t, d.Save())
+
+	d = NewDriftDetector(baselinePath, 20, 1, false)
+	assert.Equal(t, 100., d.baseline["media/small"].Size, "existing baseline shouldn't be overwritten")
+
+	d = NewDriftDetector(baselinePath, 20, 1, true)
+	assert.Empty(t, d.baseline)
+	d.Observe("media", "small", 400, time.Millisecond*10)
+	assert.False(t, d.alerted["media/small;size"])
+	assert.Nil(t, d.Save())
+
+	d = NewDriftDetector(baselinePath, 20, 1, false)
+	assert.Equal(t, 400., d.baseline["media/small"].Size)
+}
+
+func TestDriftDetector_Buckets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mort-drift")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	baselinePath := path.Join(dir, "baseline.json")
+
+	d := NewDriftDetector(baselinePath, 20, 1, false)
+	d.Observe("media", "small", 100, time.Millisecond*10)
+	d.Observe("avatars", "small", 400, time.Millisecond*10)
+	assert.Nil(t, d.Save())
+
+	d = NewDriftDetector(baselinePath, 20, 1, false)
+	assert.Equal(t, 100., d.baseline["media/small"].Size, "presets with the same name in other buckets should be tracked separately")
+	assert.Equal(t, 400., d.baseline["avatars/small"].Size)
+	d.Observe("avatars", "small", 400, time.Millisecond*10)
+	assert.False(t, d.alerted["avatars/small;size"])
+
+	assert.Nil(t, ioutil.WriteFile(baselinePath, []byte(`{"small":{"count":1,"size":100,"duration":10}}`), 0644))
+	d = NewDriftDetector(baselinePath, 20, 1, false)
+	assert.Empty(t, d.baseline, "baseline keyed by preset only should be recorded again")
+	assert.True(t, d.record)
+}
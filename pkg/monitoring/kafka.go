@@ -0,0 +1,57 @@
+package monitoring
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	kafka "github.com/segmentio/kafka-go"
+	"go.uber.org/zap"
+)
+
+// kafkaEnqueueTimeout is max time of waiting for place in queue of writer, record is dropped after it so requests aren't blocked by Kafka
+const kafkaEnqueueTimeout = 100 * time.Millisecond
+
+// kafkaBillingSink sends records as JSON messages to Kafka topic, messages are keyed by bucket so records of tenant stay in one partition
+type kafkaBillingSink struct {
+	writer *kafka.Writer
+}
+
+// newKafkaBillingSink create sink for target in form broker1:9092,broker2:9092/topic
+func newKafkaBillingSink(target string) (BillingSink, error) {
+	i := strings.LastIndex(target, "/")
+	if i <= 0 || i == len(target)-1 {
+		return nil, fmt.Errorf("invalid kafka billing target %s, expected brokers/topic", target)
+	}
+
+	writer := kafka.NewWriter(kafka.WriterConfig{
+		Brokers:  strings.Split(target[:i], ","),
+		Topic:    target[i+1:],
+		Balancer: &kafka.Hash{},
+		Async:    true,
+		ErrorLogger: kafka.LoggerFunc(func(msg string, args ...interface{}) {
+			Log().Warn("Billing kafka error", zap.String("error", fmt.Sprintf(msg, args...)))
+		}),
+	})
+
+	return &kafkaBillingSink{writer: writer}, nil
+}
+
+// Emit queues record, it is sent in background
+func (k *kafkaBillingSink) Emit(record CostRecord) error {
+	value, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), kafkaEnqueueTimeout)
+	defer cancel()
+	return k.writer.WriteMessages(ctx, kafka.Message{Key: []byte(record.Bucket), Value: value})
+}
+
+// Close flushes queued records and closes connections
+func (k *kafkaBillingSink) Close() error {
+	return k.writer.Close()
+}
@@ -0,0 +1,296 @@
+package monitoring
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// formats of lines sent by PushReporter
+const (
+	PushFormatInflux   = "influx"   // Influx line protocol
+	PushFormatOpenTSDB = "opentsdb" // OpenTSDB telnet put command
+)
+
+// maxPacketSize max size of single UDP packet with metrics
+const maxPacketSize = 1400
+
+// PushOptions configure PushReporter
+type PushOptions struct {
+	Format        string            // PushFormatInflux or PushFormatOpenTSDB
+	Address       string            // udp://host:port, tcp://host:port or http(s) URL to which lines are POSTed
+	FlushInterval time.Duration     // time between sending batches of metrics
+	Prefix        string            // prefix of names of metrics
+	Tags          map[string]string // tags added to all metrics
+}
+
+// pushSeries is single metric with its tags
+type pushSeries struct {
+	name string
+	tags string // sorted tags in form ,key=value,key1=value1
+}
+
+// pushHistogram aggregated observations of histogram between flushes
+type pushHistogram struct {
+	count    int64
+	sum      float64
+	min, max float64
+}
+
+// PushReporter is a reporter which batches metrics and sends them in Influx or OpenTSDB line protocol
+// It is for setups without Prometheus scraping
+// metric has format: metric_name;label:value,label1:value2
+type PushReporter struct {
+	opts   PushOptions
+	client *http.Client
+
+	lock       sync.Mutex
+	counters   map[pushSeries]float64 // cumulative values
+	gauges     map[pushSeries]float64
+	histograms map[pushSeries]*pushHistogram // reset on every flush
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewPushReporter create reporter which sends metrics every flush interval until Close is called
+func NewPushReporter(opts PushOptions) (*PushReporter, error) {
+	if opts.Format != PushFormatInflux && opts.Format != PushFormatOpenTSDB {
+		return nil, errors.New("unknown format of metrics " + opts.Format)
+	}
+
+	u, err := url.Parse(opts.Address)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "udp", "tcp", "http", "https":
+	default:
+		return nil, errors.New("unsupported address of metrics " + opts.Address)
+	}
+
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = 10 * time.Second
+	}
+
+	p := &PushReporter{opts: opts, client: &http.Client{Timeout: opts.FlushInterval},
+		counters: make(map[pushSeries]float64), gauges: make(map[pushSeries]float64), histograms: make(map[pushSeries]*pushHistogram),
+		done: make(chan struct{})}
+	p.wg.Add(1)
+	go p.loop()
+	return p, nil
+}
+
+func (p *PushReporter) series(metric string) pushSeries {
+	parts := strings.SplitN(metric, ";", 2)
+	tags := make(map[string]string, len(p.opts.Tags))
+	for k, v := range p.opts.Tags {
+		tags[k] = v
+	}
+	if len(parts) == 2 {
+		for k, v := range getLabels(parts[1]) {
+			tags[k] = v
+		}
+	}
+
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	buf := strings.Builder{}
+	for _, k := range keys {
+		buf.WriteString("," + escapeTag(k) + "=" + escapeTag(tags[k]))
+	}
+
+	return pushSeries{name: p.opts.Prefix + parts[0], tags: buf.String()}
+}
+
+// Inc increment value of counter
+func (p *PushReporter) Inc(metric string) {
+	p.Counter(metric, 1)
+}
+
+// Counter increment value of counter by val
+func (p *PushReporter) Counter(metric string, val float64) {
+	s := p.series(metric)
+	p.lock.Lock()
+	p.counters[s] += val
+	p.lock.Unlock()
+}
+
+// Gauge change value of gauge by val
+func (p *PushReporter) Gauge(metric string, val float64) {
+	s := p.series(metric)
+	p.lock.Lock()
+	p.gauges[s] += val
+	p.lock.Unlock()
+}
+
+// Histogram record observation, count, sum, min and max of observations are sent on flush
+func (p *PushReporter) Histogram(metric string, val float64) {
+	s := p.series(metric)
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	h, ok := p.histograms[s]
+	if !ok {
+		p.histograms[s] = &pushHistogram{count: 1, sum: val, min: val, max: val}
+		return
+	}
+
+	h.count++
+	h.sum += val
+	if val < h.min {
+		h.min = val
+	}
+	if val > h.max {
+		h.max = val
+	}
+}
+
+// Timer allows you to measure time in microseconds, the same as PrometheusReporter
+func (p *PushReporter) Timer(metric string) Timer {
+	return Timer{time.Now(), metric, func(start time.Time, metricName string) {
+		p.Histogram(metricName, float64(time.Since(start).Nanoseconds())/1000.0)
+	}}
+}
+
+func (p *PushReporter) loop() {
+	defer p.wg.Done()
+	ticker := time.NewTicker(p.opts.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.flush()
+		case <-p.done:
+			p.flush()
+			return
+		}
+	}
+}
+
+// Close sends remaining metrics and stops reporter
+func (p *PushReporter) Close() {
+	close(p.done)
+	p.wg.Wait()
+}
+
+// flush sends current values of metrics
+func (p *PushReporter) flush() {
+	lines := p.lines(time.Now())
+	if len(lines) == 0 {
+		return
+	}
+
+	if err := p.send(lines); err != nil {
+		Log().Warn("Monitoring/PushReporter unable to send metrics", zap.String("address", p.opts.Address), zap.Int("lines", len(lines)), zap.Error(err))
+	}
+}
+
+// lines returns metrics formatted in configured protocol
+func (p *PushReporter) lines(now time.Time) []string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	lines := make([]string, 0, len(p.counters)+len(p.gauges)+len(p.histograms))
+	for s, v := range p.counters {
+		lines = append(lines, p.format(s, now, []string{"value"}, []float64{v})...)
+	}
+	for s, v := range p.gauges {
+		lines = append(lines, p.format(s, now, []string{"value"}, []float64{v})...)
+	}
+	for s, h := range p.histograms {
+		lines = append(lines, p.format(s, now, []string{"count", "sum", "min", "max"}, []float64{float64(h.count), h.sum, h.min, h.max})...)
+	}
+	p.histograms = make(map[pushSeries]*pushHistogram)
+
+	sort.Strings(lines)
+	return lines
+}
+
+func (p *PushReporter) format(s pushSeries, now time.Time, fields []string, values []float64) []string {
+	if p.opts.Format == PushFormatOpenTSDB {
+		// put <metric> <timestamp> <value> <tagk1=tagv1 ...>, every field is separate metric
+		tags := strings.Replace(s.tags, ",", " ", -1)
+		lines := make([]string, len(fields))
+		for i, field := range fields {
+			name := s.name
+			if field != "value" {
+				name += "." + field
+			}
+			lines[i] = fmt.Sprintf("put %s %d %s%s", name, now.Unix(), strconv.FormatFloat(values[i], 'f', -1, 64), tags)
+		}
+		return lines
+	}
+
+	// <measurement>[,<tag_key>=<tag_value>...] <field_key>=<field_value>[,...] <timestamp>
+	buf := strings.Builder{}
+	buf.WriteString(escapeMeasurement(s.name) + s.tags + " ")
+	for i, field := range fields {
+		if i != 0 {
+			buf.WriteString(",")
+		}
+		buf.WriteString(field + "=" + strconv.FormatFloat(values[i], 'f', -1, 64))
+	}
+	buf.WriteString(" " + strconv.FormatInt(now.UnixNano(), 10))
+	return []string{buf.String()}
+}
+
+// send write lines to address, UDP packets are split so they fit in MTU
+func (p *PushReporter) send(lines []string) error {
+	u, _ := url.Parse(p.opts.Address)
+	if u.Scheme == "http" || u.Scheme == "https" {
+		res, err := p.client.Post(p.opts.Address, "text/plain; charset=utf-8", strings.NewReader(strings.Join(lines, "\n")+"\n"))
+		if err != nil {
+			return err
+		}
+		res.Body.Close()
+		if res.StatusCode >= 300 {
+			return fmt.Errorf("unexpected status code %d", res.StatusCode)
+		}
+		return nil
+	}
+
+	conn, err := net.DialTimeout(u.Scheme, u.Host, p.opts.FlushInterval)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetWriteDeadline(time.Now().Add(p.opts.FlushInterval))
+
+	packet := bytes.Buffer{}
+	for _, line := range lines {
+		if u.Scheme == "udp" && packet.Len() > 0 && packet.Len()+len(line)+1 > maxPacketSize {
+			if _, err := conn.Write(packet.Bytes()); err != nil {
+				return err
+			}
+			packet.Reset()
+		}
+		packet.WriteString(line + "\n")
+	}
+
+	_, err = conn.Write(packet.Bytes())
+	return err
+}
+
+var tagEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+var measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+
+func escapeTag(value string) string {
+	return tagEscaper.Replace(value)
+}
+
+func escapeMeasurement(value string) string {
+	return measurementEscaper.Replace(value)
+}
@@ -0,0 +1,83 @@
+package monitoring
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPushReporterInflux(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	p, err := NewPushReporter(PushOptions{Format: PushFormatInflux, Address: "udp://" + conn.LocalAddr().String(), FlushInterval: time.Hour,
+		Prefix: "mort_", Tags: map[string]string{"host": "web 1"}})
+	assert.Nil(t, err)
+
+	p.Inc("cache_ratio;status:hit")
+	p.Inc("cache_ratio;status:hit")
+	p.Gauge("storage_throughput;storage:s3", 3)
+	p.Histogram("generation_time", 10)
+	p.Histogram("generation_time", 30)
+	p.Close()
+
+	buf := make([]byte, maxPacketSize)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	assert.Nil(t, err)
+
+	lines := strings.Split(strings.TrimSpace(string(buf[:n])), "\n")
+	assert.Len(t, lines, 3)
+	assert.True(t, strings.HasPrefix(lines[0], `mort_cache_ratio,host=web\ 1,status=hit value=2 `))
+	assert.True(t, strings.HasPrefix(lines[1], `mort_generation_time,host=web\ 1 count=2,sum=40,min=10,max=30 `))
+	assert.True(t, strings.HasPrefix(lines[2], `mort_storage_throughput,host=web\ 1,storage=s3 value=3 `))
+}
+
+func TestPushReporterOpenTSDB(t *testing.T) {
+	p, err := NewPushReporter(PushOptions{Format: PushFormatOpenTSDB, Address: "tcp://127.0.0.1:4242", FlushInterval: time.Hour,
+		Tags: map[string]string{"host": "web1"}})
+	assert.Nil(t, err)
+	defer p.Close()
+
+	p.Inc("throttled_count")
+	p.Histogram("response_time;method:GET", 5)
+
+	lines := p.lines(time.Unix(1500000000, 0))
+	assert.Equal(t, []string{
+		"put response_time.count 1500000000 1 host=web1 method=GET",
+		"put response_time.max 1500000000 5 host=web1 method=GET",
+		"put response_time.min 1500000000 5 host=web1 method=GET",
+		"put response_time.sum 1500000000 5 host=web1 method=GET",
+		"put throttled_count 1500000000 1 host=web1",
+	}, lines)
+	assert.Len(t, p.lines(time.Now()), 1, "histograms should be reset after flush")
+}
+
+func TestPushReporterHTTP(t *testing.T) {
+	bodies := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		bodies <- string(body)
+		w.WriteHeader(204)
+	}))
+	defer server.Close()
+
+	p, err := NewPushReporter(PushOptions{Format: PushFormatInflux, Address: server.URL + "/write?db=mort", FlushInterval: time.Hour})
+	assert.Nil(t, err)
+	p.Counter("transform_bytes;bucket:media,preset:small", 1024)
+	p.Close()
+
+	assert.True(t, strings.HasPrefix(<-bodies, "transform_bytes,bucket=media,preset=small value=1024 "))
+
+	_, err = NewPushReporter(PushOptions{Format: "graphite", Address: "udp://127.0.0.1:2003"})
+	assert.NotNil(t, err)
+	_, err = NewPushReporter(PushOptions{Format: PushFormatInflux, Address: "ftp://127.0.0.1"})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,69 @@
+package monitoring
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"time"
+)
+
+// recentPauses number of last GC pauses returned by RuntimeHandler
+const recentPauses = 16
+
+// RuntimeStats describe state of Go runtime of process
+type RuntimeStats struct {
+	Goroutines   int       `json:"goroutines"`
+	CPUs         int       `json:"cpus"`
+	HeapAlloc    uint64    `json:"heapAlloc"`    // bytes of allocated heap objects
+	HeapInuse    uint64    `json:"heapInuse"`    // bytes in in-use heap spans
+	HeapObjects  uint64    `json:"heapObjects"`  // number of allocated heap objects
+	Sys          uint64    `json:"sys"`          // bytes of memory obtained from OS
+	NumGC        uint32    `json:"numGC"`        // number of completed GC cycles
+	PauseTotalMs float64   `json:"pauseTotalMs"` // total time of GC pauses
+	LastPausesMs []float64 `json:"lastPausesMs"` // most recent GC pauses, the latest first
+	LastGC       time.Time `json:"lastGC"`
+}
+
+// ReadRuntimeStats returns current stats of Go runtime
+// It stops the world for short time, so it shouldn't be called on every request
+func ReadRuntimeStats() RuntimeStats {
+	mem := runtime.MemStats{}
+	runtime.ReadMemStats(&mem)
+
+	stats := RuntimeStats{
+		Goroutines:   runtime.NumGoroutine(),
+		CPUs:         runtime.NumCPU(),
+		HeapAlloc:    mem.HeapAlloc,
+		HeapInuse:    mem.HeapInuse,
+		HeapObjects:  mem.HeapObjects,
+		Sys:          mem.Sys,
+		NumGC:        mem.NumGC,
+		PauseTotalMs: float64(mem.PauseTotalNs) / 1e6,
+		LastPausesMs: make([]float64, 0, recentPauses),
+	}
+
+	if mem.NumGC > 0 {
+		stats.LastGC = time.Unix(0, int64(mem.LastGC))
+	}
+
+	// PauseNs is circular buffer, the latest pause is at (NumGC+255)%256
+	for i := uint32(0); i < recentPauses && i < mem.NumGC; i++ {
+		stats.LastPausesMs = append(stats.LastPausesMs, float64(mem.PauseNs[(mem.NumGC+255-i)%256])/1e6)
+	}
+
+	return stats
+}
+
+// RuntimeHandler returns JSON with stats of Go runtime (goroutines, heap and GC pauses)
+func RuntimeHandler() http.Handler {
+	return http.HandlerFunc(func(resWriter http.ResponseWriter, req *http.Request) {
+		buf, err := json.Marshal(ReadRuntimeStats())
+		if err != nil {
+			resWriter.WriteHeader(500)
+			return
+		}
+
+		resWriter.Header().Set("Content-Type", "application/json")
+		resWriter.Write(buf)
+	})
+}
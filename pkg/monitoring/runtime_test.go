@@ -0,0 +1,26 @@
+package monitoring
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRuntimeHandler(t *testing.T) {
+	runtime.GC()
+	recorder := httptest.NewRecorder()
+	RuntimeHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "http://mort/debug/runtime", nil))
+
+	assert.Equal(t, 200, recorder.Code)
+	assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+
+	stats := RuntimeStats{}
+	assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &stats))
+	assert.True(t, stats.Goroutines > 0)
+	assert.True(t, stats.NumGC > 0)
+	assert.NotEmpty(t, stats.LastPausesMs)
+	assert.True(t, len(stats.LastPausesMs) <= recentPauses)
+}
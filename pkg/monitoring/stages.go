@@ -0,0 +1,112 @@
+package monitoring
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// stages of request measured by Stages, in order in which they are logged
+const (
+	StageParse       = "parse"       // creating object from URL
+	StageCacheLookup = "cacheLookup" // reading response cache
+	StageLockWait    = "lockWait"    // waiting for result of collapsed request
+	StageStorage     = "storage"     // storage operations
+	StageTransform   = "transform"   // engine processing, without encoding
+	StageEncode      = "encode"      // encoding and re-encoding of result image
+	StageWrite       = "write"       // sending response to client
+)
+
+var stageNames = []string{StageParse, StageCacheLookup, StageLockWait, StageStorage, StageTransform, StageEncode, StageWrite}
+
+type stagesContext string
+
+// stagesCtxKey key under which Stages are stored in request context
+var stagesCtxKey stagesContext = "stages"
+
+// Stages collects time spent in stages of single request
+// Time of stages run in parallel (e.g. storage operations) is summed
+// All methods are safe to call on nil Stages, so request without tracking needs no checks
+type Stages struct {
+	start     time.Time
+	lock      sync.Mutex
+	durations map[string]time.Duration
+}
+
+// NewStages create Stages of request started now
+func NewStages() *Stages {
+	return &Stages{start: time.Now(), durations: make(map[string]time.Duration)}
+}
+
+// WithStages returns context with stages of request
+func WithStages(ctx context.Context, s *Stages) context.Context {
+	return context.WithValue(ctx, stagesCtxKey, s)
+}
+
+// StagesFromContext returns stages of request or nil when request isn't tracked
+func StagesFromContext(ctx context.Context) *Stages {
+	if ctx == nil {
+		return nil
+	}
+
+	s, _ := ctx.Value(stagesCtxKey).(*Stages)
+	return s
+}
+
+// Add add d to time spent in stage
+func (s *Stages) Add(stage string, d time.Duration) {
+	if s == nil {
+		return
+	}
+
+	s.lock.Lock()
+	s.durations[stage] += d
+	s.lock.Unlock()
+}
+
+// Since add time elapsed from start to time spent in stage
+func (s *Stages) Since(stage string, start time.Time) {
+	s.Add(stage, time.Since(start))
+}
+
+// Get returns time spent in stage
+func (s *Stages) Get(stage string) time.Duration {
+	if s == nil {
+		return 0
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.durations[stage]
+}
+
+// Elapsed returns time since start of request
+func (s *Stages) Elapsed() time.Duration {
+	if s == nil {
+		return 0
+	}
+
+	return time.Since(s.start)
+}
+
+// LogFields returns duration of request and time of every stage in milliseconds
+func (s *Stages) LogFields() []zap.Field {
+	if s == nil {
+		return nil
+	}
+
+	fields := []zap.Field{zap.Float64("duration", milliseconds(s.Elapsed()))}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, stage := range stageNames {
+		fields = append(fields, zap.Float64("stage."+stage, milliseconds(s.durations[stage])))
+	}
+
+	return fields
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Nanoseconds()) / float64(time.Millisecond)
+}
@@ -0,0 +1,38 @@
+package monitoring
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStages(t *testing.T) {
+	s := NewStages()
+	ctx := WithStages(context.Background(), s)
+
+	StagesFromContext(ctx).Add(StageStorage, time.Millisecond)
+	StagesFromContext(ctx).Add(StageStorage, 2*time.Millisecond)
+	StagesFromContext(ctx).Since(StageTransform, time.Now().Add(-time.Second))
+
+	assert.Equal(t, 3*time.Millisecond, s.Get(StageStorage))
+	assert.True(t, s.Get(StageTransform) >= time.Second)
+	assert.Equal(t, time.Duration(0), s.Get(StageEncode))
+
+	fields := s.LogFields()
+	assert.Len(t, fields, len(stageNames)+1)
+	assert.Equal(t, "duration", fields[0].Key)
+	assert.Equal(t, "stage.parse", fields[1].Key)
+}
+
+func TestStagesNil(t *testing.T) {
+	s := StagesFromContext(context.Background())
+	assert.Nil(t, s)
+
+	s.Add(StageStorage, time.Second)
+	s.Since(StageWrite, time.Now())
+	assert.Equal(t, time.Duration(0), s.Get(StageStorage))
+	assert.Equal(t, time.Duration(0), s.Elapsed())
+	assert.Nil(t, s.LogFields())
+}
@@ -118,7 +118,8 @@ func (u UploadInterceptorMiddleware) Handler(next http.Handler) http.Handler {
 			next.ServeHTTP(resWriter, req)
 			return
 		}
-		if len(bucket.Keys) != 1 {
+		keys := bucket.ResolvedKeys()
+		if len(keys) != 1 {
 			monitoring.Log().Error(
 				"CloudinaryUploadInterceptorMiddleware - missing api key and secret",
 			)
@@ -132,13 +133,13 @@ func (u UploadInterceptorMiddleware) Handler(next http.Handler) http.Handler {
 			res.Send(resWriter)
 			return
 		}
-		if err := verifySignature(req.MultipartForm.Value, bucket.Keys[0].AccessKey, bucket.Keys[0].SecretAccessKey); err != nil {
+		if err := verifySignature(req.MultipartForm.Value, keys[0].AccessKey, keys[0].SecretAccessKey); err != nil {
 			values, _ := json.Marshal(req.MultipartForm.Value)
 			monitoring.Log().Info(
 				"CloudinaryUploadInterceptorMiddleware",
 				zap.Error(err),
 				zap.String("bucket", bucketName),
-				zap.String("accessKey", bucket.Keys[0].AccessKey),
+				zap.String("accessKey", keys[0].AccessKey),
 				zap.ByteString("values", values),
 			)
 			res := response.NewString(403, "signature does not match")
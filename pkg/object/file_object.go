@@ -15,6 +15,9 @@ import (
 	"go.uber.org/zap/zapcore"
 )
 
+// HeaderRequestID name of header which carry request id
+const HeaderRequestID = "X-Request-ID"
+
 // FileObject is representing parsed request for image or file
 type FileObject struct {
 	Uri            *url.URL `json:"uri"`    // original request path
@@ -27,8 +30,17 @@ type FileObject struct {
 	CheckParent    bool                  // boolean if we should always check if parent exists
 	allowChangeKey bool                  // parser can allow or not changing key by this flag
 	Debug          bool                  // flag for debug requests
+	DebugPlan      bool                  // flag for debug requests that should return transform plan instead of image
+	DebugAdmin     bool                  // debug request authorized with admin token, its plan contains storage location
+	Meta           bool                  // flag for requests that should return metadata of image instead of image
+	Palette        int                   // number of dominant colors that should be returned instead of image, 0 when disabled
+	Similar        bool                  // flag for requests that should return images similar to object
+	Profile        string                // kind of pprof profile (cpu, heap) of transform returned instead of image, empty when disabled
 	Ctx            context.Context       // context of request
 	Range          string                // HTTP range in request
+	RequestID      string                // id of request used for logs correlation
+	Preset         string                // name of preset used for transform
+	Vary           string                // normalized values of request headers varying response, part of response cache key
 }
 
 // NewFileObjectFromPath create new instance of FileObject
@@ -113,10 +125,24 @@ func (o *FileObject) UpdateKey(str string) {
 func (o *FileObject) FillWithRequest(req *http.Request, ctx context.Context) {
 	o.Ctx = ctx
 	o.Range = req.Header.Get("Range")
+	o.RequestID = req.Header.Get(HeaderRequestID)
 }
 
 func (o *FileObject) GetResponseCacheKey() string {
-	return o.Bucket + o.Key + o.Range
+	if o.Meta {
+		return o.Bucket + o.Key + "?meta"
+	}
+
+	if o.Palette != 0 {
+		return o.Bucket + o.Key + "?palette=" + strconv.Itoa(o.Palette)
+	}
+
+	key := o.Bucket + o.Key + o.Range
+	if o.Vary != "" {
+		key += "#" + o.Vary
+	}
+
+	return key
 }
 
 func (o *FileObject) Copy() *FileObject {
@@ -131,8 +157,17 @@ func (o *FileObject) Copy() *FileObject {
 		CheckParent:    o.CheckParent,
 		allowChangeKey: o.allowChangeKey,
 		Debug:          o.Debug,
+		DebugPlan:      o.DebugPlan,
+		DebugAdmin:     o.DebugAdmin,
+		Meta:           o.Meta,
+		Palette:        o.Palette,
+		Similar:        o.Similar,
+		Profile:        o.Profile,
 		Ctx:            context.Background(),
 		Range:          o.Range,
+		RequestID:      o.RequestID,
+		Preset:         o.Preset,
+		Vary:           o.Vary,
 	}
 
 	return &copy
@@ -147,6 +182,10 @@ func (obj *FileObject) LogData(fields ...zapcore.Field) []zapcore.Field {
 		result = append(result, zap.String("parent.Key", obj.Parent.Key), zap.String("parent.Path", obj.Parent.Uri.Path))
 	}
 
+	if obj.RequestID != "" {
+		result = append(result, zap.String("requestId", obj.RequestID))
+	}
+
 	return append(result, fields...)
 
 }
@@ -1,8 +1,12 @@
+//go:build cgo
+// +build cgo
+
 package object
 
 import (
 	"net/http"
 	"testing"
+	"text/template"
 
 	"github.com/stretchr/testify/assert"
 
@@ -118,6 +122,19 @@ func TestNewFileObjectTransformParentStorage(t *testing.T) {
 
 }
 
+func TestNewFileObjectTransformResultStorage(t *testing.T) {
+	mortConfig := config.GetInstance()
+	err := mortConfig.Load("testdata/bucket-transform-result-storage.yml")
+	assert.Nil(t, err, "Unexpected to have error when parsing config")
+	obj, err := NewFileObject(pathToURL("/bucket/blog_small/thumb_2334.jpg"), mortConfig)
+
+	assert.Nil(t, err, "Unexpected to have error when parsing path")
+
+	assert.Equal(t, "local-meta", obj.Storage.Kind, "invalid result storage")
+
+	assert.Equal(t, "local", obj.Parent.Storage.Kind, "invalid parent storage")
+}
+
 func TestNewFileObjectTransformOnlyWitdh(t *testing.T) {
 	mortConfig := config.GetInstance()
 	mortConfig.Load("testdata/bucket-transform.yml")
@@ -198,6 +215,8 @@ func TestNewFileObjecWithNestedParentHashParent(t *testing.T) {
 	assert.True(t, parent.HasParent(), "parent should have parent")
 
 	assert.Equal(t, "/parent.jpg/2e805241bb54d7f7a200a56572d63805", obj.Key)
+	assert.Equal(t, "/parent.jpg/", DerivativesPrefix("/parent.jpg"))
+	assert.Equal(t, "/dir-parent.jpg/", DerivativesPrefix("/dir/parent.jpg"))
 }
 
 func TestNewFileObjectQueryResize(t *testing.T) {
@@ -224,6 +243,18 @@ func TestNewFileObjectQueryResize(t *testing.T) {
 	assert.Equal(t, 0, transCfg.Height, "invalid width for transform")
 }
 
+func TestNewFileObjectQueryCanonicalKey(t *testing.T) {
+	mortConfig := &config.Config{}
+	mortConfig.Load("testdata/bucket-transform-query-parent-storage.yml")
+	obj, err := NewFileObject(pathToURL("/bucket/parent.jpg?operation=crop&operation=rotate&width=100&height=50&angle=90&gravity=north&format=webp"), mortConfig)
+	assert.Nil(t, err, "Unexpected to have error when parsing path")
+
+	reordered, err := NewFileObject(pathToURL("/bucket/parent.jpg?format=WEBP&gravity=North&angle=90&height=50&width=100&operation=rotate&operation=crop&operation=rotate"), mortConfig)
+	assert.Nil(t, err, "Unexpected to have error when parsing path")
+
+	assert.Equal(t, obj.Key, reordered.Key, "reordered query should have the same key")
+}
+
 func TestNewFileObjectQueryResizeDef(t *testing.T) {
 	mortConfig := &config.Config{}
 	mortConfig.Load("testdata/bucket-transform-query-parent-storage.yml")
@@ -360,6 +391,98 @@ func TestNewFileObjectPresetQueryRotate(t *testing.T) {
 	assert.Equal(t, bimg.D90, transCfg.Rotate)
 }
 
+func TestNewFileObjectQueryTrim(t *testing.T) {
+	mortConfig := &config.Config{}
+	mortConfig.Load("testdata/bucket-transform-query-parent-storage.yml")
+	obj, err := NewFileObject(pathToURL("/bucket/parent.jpg?width=100&operation=trim&tolerance=20&background=ffffff"), mortConfig)
+
+	assert.Nil(t, err, "Unexpected to have error when parsing path")
+	assert.True(t, obj.HasTransform(), "obj should have transforms")
+
+	p := obj.Transforms.Params()
+	assert.True(t, p.Trim)
+	assert.Equal(t, 20, p.TrimTolerance)
+	assert.Equal(t, uint8(255), p.TrimBackground.R)
+
+	obj, err = NewFileObject(pathToURL("/bucket/parent.jpg?operation=trim"), mortConfig)
+	assert.Nil(t, err)
+	assert.Equal(t, transforms.DefaultTrimTolerance, obj.Transforms.Params().TrimTolerance)
+	assert.Nil(t, obj.Transforms.Params().TrimBackground)
+
+	_, err = NewFileObject(pathToURL("/bucket/parent.jpg?operation=trim&background=white"), mortConfig)
+	assert.NotNil(t, err)
+}
+
+func TestNewFileObjectQuerySharpen(t *testing.T) {
+	mortConfig := &config.Config{}
+	mortConfig.Load("testdata/bucket-transform-query-parent-storage.yml")
+	obj, err := NewFileObject(pathToURL("/bucket/parent.jpg?width=100&operation=sharpen&sigma=0.5&threshold=0.1"), mortConfig)
+
+	assert.Nil(t, err, "Unexpected to have error when parsing path")
+	assert.True(t, obj.HasTransform(), "obj should have transforms")
+	assert.Equal(t, &transforms.SharpenParams{Sigma: 0.5, Amount: transforms.DefaultSharpenAmount, Threshold: 0.1}, obj.Transforms.Params().Sharpen)
+
+	_, err = NewFileObject(pathToURL("/bucket/parent.jpg?operation=sharpen&amount=x"), mortConfig)
+	assert.NotNil(t, err)
+}
+
+func TestNewFileObjectQueryEffects(t *testing.T) {
+	mortConfig := &config.Config{}
+	mortConfig.Load("testdata/bucket-transform-query-parent-storage.yml")
+	obj, err := NewFileObject(pathToURL("/bucket/parent.jpg?width=100&operation=duotone&operation=overlay&shadow=000&highlight=ff8800&color=fff&mode=screen"), mortConfig)
+
+	assert.Nil(t, err, "Unexpected to have error when parsing path")
+	p := obj.Transforms.Params()
+	assert.Equal(t, uint8(0x88), p.Duotone.Highlight.G)
+	assert.Equal(t, 1., p.Overlay.Opacity)
+	assert.Equal(t, transforms.BlendScreen, p.Overlay.Mode)
+
+	_, err = NewFileObject(pathToURL("/bucket/parent.jpg?operation=overlay&color=fff&opacity=2"), mortConfig)
+	assert.NotNil(t, err)
+}
+
+func TestNewFileObjectQueryComposite(t *testing.T) {
+	mortConfig := &config.Config{}
+	mortConfig.Load("testdata/bucket-transform-query-parent-storage.yml")
+	obj, err := NewFileObject(pathToURL("/bucket/parent.jpg?width=100&operation=composite&image=/badges/new.png&position=top-right&compositeWidth=30"), mortConfig)
+
+	assert.Nil(t, err, "Unexpected to have error when parsing path")
+	assert.Equal(t, "/badges/new.png", obj.Transforms.CompositeKey())
+	assert.Equal(t, 30, obj.Transforms.Params().Composite.Width)
+	assert.Equal(t, 1., obj.Transforms.Params().Composite.Opacity)
+
+	_, err = NewFileObject(pathToURL("/bucket/parent.jpg?operation=composite&image=/badges/new.png"), mortConfig)
+	assert.NotNil(t, err)
+}
+
+func TestNewFileObjectQueryPixelate(t *testing.T) {
+	mortConfig := &config.Config{}
+	mortConfig.Load("testdata/bucket-transform-query-parent-storage.yml")
+	obj, err := NewFileObject(pathToURL("/bucket/parent.jpg?width=100&operation=pixelate&regions=0,0,10,10;faces"), mortConfig)
+
+	assert.Nil(t, err, "Unexpected to have error when parsing path")
+	p := obj.Transforms.Params().Pixelate
+	assert.Equal(t, transforms.DefaultPixelateBlockSize, p.BlockSize)
+	assert.Equal(t, 1, len(p.Regions))
+	assert.True(t, p.Faces)
+
+	_, err = NewFileObject(pathToURL("/bucket/parent.jpg?operation=pixelate&blockSize=1"), mortConfig)
+	assert.NotNil(t, err)
+}
+
+func TestNewFileObjectQueryPage(t *testing.T) {
+	mortConfig := &config.Config{}
+	mortConfig.Load("testdata/bucket-transform-query-parent-storage.yml")
+	obj, err := NewFileObject(pathToURL("/bucket/doc.pdf?width=100&page=3"), mortConfig)
+
+	assert.Nil(t, err, "Unexpected to have error when parsing path")
+	assert.True(t, obj.HasTransform(), "obj should have transforms")
+	assert.Equal(t, 3, obj.Transforms.Params().Page)
+
+	_, err = NewFileObject(pathToURL("/bucket/doc.pdf?page=0"), mortConfig)
+	assert.NotNil(t, err)
+}
+
 func TestNewFileObjectPresetQueryWatermarkErr(t *testing.T) {
 	mortConfig := &config.Config{}
 	mortConfig.Load("testdata/bucket-transform-query-parent-storage.yml")
@@ -479,6 +602,15 @@ func TestObjectCacheKeyPreset(t *testing.T) {
 	obj.Range = "bytes=1-10"
 
 	assert.Equal(t, "bucket/6ca/hei/height-bucket-parent.jpg-6ca0dabe9909875abytes=1-10", obj.GetResponseCacheKey())
+
+	obj.Meta = true
+
+	assert.Equal(t, "bucket/6ca/hei/height-bucket-parent.jpg-6ca0dabe9909875a?meta", obj.GetResponseCacheKey())
+
+	obj.Meta = false
+	obj.Palette = 5
+
+	assert.Equal(t, "bucket/6ca/hei/height-bucket-parent.jpg-6ca0dabe9909875a?palette=5", obj.GetResponseCacheKey())
 }
 
 func BenchmarkNewFileObject(b *testing.B) {
@@ -508,3 +640,75 @@ func BenchmarkNewFileObject(b *testing.B) {
 	}
 
 }
+
+func TestFileObjectSwapPreset(t *testing.T) {
+	mortConfig := config.GetInstance()
+	mortConfig.Load("testdata/bucket-transform.yml")
+	obj, err := NewFileObject(pathToURL("/bucket/blog_small/bucket/parent.jpg"), mortConfig)
+	assert.Nil(t, err)
+
+	assert.Nil(t, obj.SwapPreset("width"))
+	assert.Equal(t, "width", obj.Preset)
+	assert.Equal(t, 0, obj.Transforms.Params().Height)
+
+	assert.NotNil(t, obj.SwapPreset("unknown"))
+}
+
+func TestFileObjectResponseCacheKeyVary(t *testing.T) {
+	obj := FileObject{Bucket: "bucket", Key: "/image.jpg"}
+	assert.Equal(t, "bucket/image.jpg", obj.GetResponseCacheKey())
+
+	obj.Vary = "accept=image/webp"
+	assert.Equal(t, "bucket/image.jpg#accept=image/webp", obj.GetResponseCacheKey())
+	assert.Equal(t, obj.Vary, obj.Copy().Vary)
+}
+
+func TestNewFileObjectResultKeyTemplate(t *testing.T) {
+	mortConfig := &config.Config{}
+	err := mortConfig.Load("testdata/bucket-transform-template.yml")
+	assert.Nil(t, err)
+
+	obj, err := NewFileObject(pathToURL("/bucket/width/dir/parent.jpg"), mortConfig)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "/bucket/width/dir/parent.jpg.jpg", obj.Key, "format should be taken from extension of original")
+
+	obj, err = NewFileObject(pathToURL("/bucket/width_webp/dir/parent.jpg"), mortConfig)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "/bucket/width_webp/dir/parent.jpg.webp", obj.Key)
+	assert.Equal(t, "/dir/parent.jpg", obj.Parent.Key)
+
+	obj = &FileObject{Bucket: "bucket", Preset: "width", Parent: &FileObject{Key: "/dir/parent.jpg"}}
+	key, err := templateKey(obj, template.Must(template.New("").Parse("{{.Preset}}//{{.ParentKey}}")), 0)
+	assert.Nil(t, err)
+	assert.Equal(t, "/width/dir/parent.jpg", key, "key should be cleaned")
+
+	_, err = templateKey(obj, template.Must(template.New("").Parse("../{{.ParentKey}}")), 0)
+	assert.NotNil(t, err, "key can't point outside of bucket")
+
+	_, err = templateKey(obj, template.Must(template.New("").Parse("{{.ParentKey}}")), 0)
+	assert.NotNil(t, err, "key of original can't be overwritten")
+}
+
+func TestNewFileObjectTransformVersion(t *testing.T) {
+	mortConfig := &config.Config{}
+	err := mortConfig.Load("testdata/bucket-transform-version.yml")
+	assert.Nil(t, err)
+
+	obj, err := NewFileObject(pathToURL("/bucket/width/parent.jpg"), mortConfig)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "/v2/width/parent.jpg", obj.Key, "key should be prefixed with version")
+	assert.Equal(t, "/parent.jpg", obj.Parent.Key)
+
+	obj, err = NewFileObject(pathToURL("/hash/width/parent.jpg"), mortConfig)
+
+	assert.Nil(t, err)
+	assert.NotContains(t, obj.Key, "/v2/", "hash key shouldn't be prefixed with version")
+
+	unversioned := obj.Transforms
+	unversioned.SetVersion(0)
+	assert.NotEqual(t, unversioned.Hash().Sum64(), obj.Transforms.Hash().Sum64(), "version should change hash")
+	assert.Equal(t, "", VersionPrefix(0))
+}
@@ -45,20 +45,22 @@ func decodePreset(_ *url.URL, bucketConfig config.Bucket, obj *FileObject) (stri
 	presetName := subMatchMap["presetName"]
 	parent := subMatchMap["parent"]
 
-	if _, ok := trans.Presets[presetName]; !ok {
+	preset, ok := trans.Preset(presetName)
+	if !ok {
 		monitoring.Log().Warn("FileObject decodePreset unknown preset", zap.String("obj.path", obj.Uri.Path), zap.String("obj.Key", obj.Key), zap.String("parent", parent), zap.String("presetName", presetName),
 			zap.String("regexp", trans.Path))
 		return "", errors.New("unknown preset " + presetName)
 	}
 
 	var err error
+	obj.Preset = presetName
 	presetCacheLock.RLock()
 	if t, ok := presetCache[presetName]; ok {
 		obj.Transforms = t
 		presetCacheLock.RUnlock()
 	} else {
 		presetCacheLock.RUnlock()
-		obj.Transforms, err = presetToTransform(trans.Presets[presetName])
+		obj.Transforms, err = presetToTransform(preset)
 		if err != nil {
 			return parent, err
 		}
@@ -77,6 +79,41 @@ func decodePreset(_ *url.URL, bucketConfig config.Bucket, obj *FileObject) (stri
 	return parent, err
 }
 
+// ResetPresetCache remove transforms of all presets from cache, it has to be called when presets are changed
+func ResetPresetCache() {
+	presetCacheLock.Lock()
+	presetCache = make(map[string]transforms.Transforms)
+	presetCacheLock.Unlock()
+}
+
+// ValidatePreset check if preset can be converted to transforms
+func ValidatePreset(preset config.Preset) error {
+	_, err := presetToTransform(preset)
+	return err
+}
+
+// SwapPreset replace transforms of object with transforms of other preset of its bucket
+func (o *FileObject) SwapPreset(presetName string) error {
+	bucket, ok := config.GetInstance().Buckets[o.Bucket]
+	if !ok || bucket.Transform == nil {
+		return errors.New("bucket without presets " + o.Bucket)
+	}
+
+	preset, ok := bucket.Transform.Preset(presetName)
+	if !ok {
+		return errors.New("unknown preset " + presetName)
+	}
+
+	trans, err := presetToTransform(preset)
+	if err != nil {
+		return err
+	}
+
+	o.Transforms = trans
+	o.Preset = presetName
+	return nil
+}
+
 // presetToTransform convert preset config to transform
 // nolint: gocyclo
 func presetToTransform(preset config.Preset) (transforms.Transforms, error) {
@@ -84,7 +121,13 @@ func presetToTransform(preset config.Preset) (transforms.Transforms, error) {
 	filters := preset.Filters
 
 	if filters.Thumbnail != nil {
-		err := trans.Resize(filters.Thumbnail.Width, filters.Thumbnail.Height, filters.Thumbnail.Mode == "outbound", false, false)
+		var err error
+		thumbnail := filters.Thumbnail
+		if thumbnail.Gravity != "" && thumbnail.Width != 0 && thumbnail.Height != 0 {
+			err = trans.Crop(thumbnail.Width, thumbnail.Height, thumbnail.Gravity, thumbnail.Mode == "outbound", false)
+		} else {
+			err = trans.Resize(thumbnail.Width, thumbnail.Height, thumbnail.Mode == "outbound", false, false)
+		}
 		if err != nil {
 			return trans, err
 		}
@@ -111,6 +154,51 @@ func presetToTransform(preset config.Preset) (transforms.Transforms, error) {
 		}
 	}
 	trans.Quality(preset.Quality)
+	if preset.AutoQuality {
+		err := trans.AutoQuality(preset.QualityTarget)
+		if err != nil {
+			return trans, err
+		}
+	}
+
+	if preset.MaxBytes != 0 {
+		err := trans.MaxBytes(preset.MaxBytes)
+		if err != nil {
+			return trans, err
+		}
+	}
+
+	if preset.Compression != 0 {
+		err := trans.Compression(preset.Compression)
+		if err != nil {
+			return trans, err
+		}
+	}
+
+	if preset.Speed != 0 {
+		err := trans.Speed(preset.Speed)
+		if err != nil {
+			return trans, err
+		}
+	}
+
+	if preset.ChromaSubsampling != "" {
+		err := trans.ChromaSubsampling(preset.ChromaSubsampling)
+		if err != nil {
+			return trans, err
+		}
+	}
+
+	if preset.Effort != 0 {
+		err := trans.Effort(preset.Effort)
+		if err != nil {
+			return trans, err
+		}
+	}
+
+	if preset.WithoutEnlargement != nil {
+		trans.WithoutEnlargement(*preset.WithoutEnlargement)
+	}
 
 	if filters.Interlace == true {
 		err := trans.Interlace()
@@ -119,7 +207,17 @@ func presetToTransform(preset config.Preset) (transforms.Transforms, error) {
 		}
 	}
 
-	if filters.Strip == true {
+	if filters.StripMode != "" || filters.KeepICC {
+		mode := filters.StripMode
+		if mode == "" {
+			mode = transforms.StripAll
+		}
+
+		err := trans.StripMetadataMode(mode, filters.KeepICC)
+		if err != nil {
+			return trans, err
+		}
+	} else if filters.Strip == true {
 		err := trans.StripMetadata()
 		if err != nil {
 			return trans, err
@@ -133,6 +231,25 @@ func presetToTransform(preset config.Preset) (transforms.Transforms, error) {
 		}
 	}
 
+	if preset.ColorProfile != "" {
+		err := trans.ColorProfile(preset.ColorProfile)
+		if err != nil {
+			return trans, err
+		}
+	}
+
+	if filters.Trim != nil {
+		tolerance := transforms.DefaultTrimTolerance
+		if filters.Trim.Tolerance != nil {
+			tolerance = *filters.Trim.Tolerance
+		}
+
+		err := trans.Trim(tolerance, filters.Trim.Background)
+		if err != nil {
+			return trans, err
+		}
+	}
+
 	if filters.Blur != nil {
 		err := trans.Blur(filters.Blur.Sigma, filters.Blur.MinAmpl)
 		if err != nil {
@@ -140,6 +257,64 @@ func presetToTransform(preset config.Preset) (transforms.Transforms, error) {
 		}
 	}
 
+	if filters.Sharpen != nil {
+		sigma, amount := filters.Sharpen.Sigma, filters.Sharpen.Amount
+		if sigma == 0 {
+			sigma = transforms.DefaultSharpenSigma
+		}
+		if amount == 0 {
+			amount = transforms.DefaultSharpenAmount
+		}
+
+		err := trans.Sharpen(filters.Sharpen.Radius, sigma, amount, filters.Sharpen.Threshold)
+		if err != nil {
+			return trans, err
+		}
+	}
+
+	if filters.Duotone != nil {
+		err := trans.Duotone(filters.Duotone.Shadow, filters.Duotone.Highlight)
+		if err != nil {
+			return trans, err
+		}
+	}
+
+	if filters.Overlay != nil {
+		opacity := filters.Overlay.Opacity
+		if opacity == 0 {
+			opacity = 1
+		}
+
+		err := trans.Overlay(filters.Overlay.Color, opacity, filters.Overlay.Mode)
+		if err != nil {
+			return trans, err
+		}
+	}
+
+	if filters.Composite != nil {
+		opacity := filters.Composite.Opacity
+		if opacity == 0 {
+			opacity = 1
+		}
+
+		err := trans.Composite(filters.Composite.Image, filters.Composite.Position, filters.Composite.Width, opacity, filters.Composite.Mode)
+		if err != nil {
+			return trans, err
+		}
+	}
+
+	if filters.Pixelate != nil {
+		blockSize := filters.Pixelate.BlockSize
+		if blockSize == 0 {
+			blockSize = transforms.DefaultPixelateBlockSize
+		}
+
+		err := trans.Pixelate(blockSize, filters.Pixelate.Regions)
+		if err != nil {
+			return trans, err
+		}
+	}
+
 	if filters.Watermark != nil {
 		err := trans.Watermark(filters.Watermark.Image, filters.Watermark.Position, filters.Watermark.Opacity)
 		if err != nil {
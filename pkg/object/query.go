@@ -3,7 +3,9 @@ package object
 import (
 	"net/url"
 	"path"
+	"sort"
 	"strconv"
+	"strings"
 
 	"github.com/aldor007/mort/pkg/config"
 	"github.com/aldor007/mort/pkg/transforms"
@@ -44,13 +46,26 @@ func queryToTransform(query url.Values) (transforms.Transforms, error) {
 	}
 
 	var q int
-	if _, ok := query["quality"]; ok {
+	if query.Get("quality") == "auto" {
+		var target float64
+		if _, ok := query["qualityTarget"]; ok {
+			target, err = strconv.ParseFloat(query.Get("qualityTarget"), 64)
+			if err != nil {
+				return trans, err
+			}
+		}
+
+		err = trans.AutoQuality(target)
+		if err != nil {
+			return trans, err
+		}
+	} else if _, ok := query["quality"]; ok {
 		q, _ = queryToInt(query, "quality")
 		trans.Quality(q)
 	}
 
 	if format, ok := query["format"]; ok {
-		err = trans.Format(format[0])
+		err = trans.Format(normalizeValue(format[0]))
 		if err != nil {
 			return trans, err
 		}
@@ -60,15 +75,92 @@ func queryToTransform(query url.Values) (transforms.Transforms, error) {
 		trans.Grayscale()
 	}
 
+	if _, ok := query["maxBytes"]; ok {
+		var maxBytes int
+		maxBytes, err = queryToInt(query, "maxBytes")
+		if err != nil {
+			return trans, err
+		}
+
+		err = trans.MaxBytes(maxBytes)
+		if err != nil {
+			return trans, err
+		}
+	}
+
+	if profile, ok := query["colorProfile"]; ok {
+		err = trans.ColorProfile(normalizeValue(profile[0]))
+		if err != nil {
+			return trans, err
+		}
+	}
+
+	if _, ok := query["withoutEnlargement"]; ok {
+		var withoutEnlargement bool
+		withoutEnlargement, err = strconv.ParseBool(query.Get("withoutEnlargement"))
+		if err != nil {
+			return trans, err
+		}
+
+		trans.WithoutEnlargement(withoutEnlargement)
+	}
+
+	if _, ok := query["page"]; ok {
+		var page int
+		page, err = queryToInt(query, "page")
+		if err != nil {
+			return trans, err
+		}
+
+		err = trans.Page(page)
+	}
+
 	return trans, err
 }
 
+// canonicalOperations returns sorted operations without duplicates
+// Operations are applied in the same order regardless of order in query, so the same transform has always the same key
+func canonicalOperations(operations []string) []string {
+	seen := make(map[string]bool, len(operations))
+	result := make([]string, 0, len(operations))
+	for _, o := range operations {
+		if !seen[o] {
+			seen[o] = true
+			result = append(result, o)
+		}
+	}
+
+	sort.Strings(result)
+	return result
+}
+
+// normalizeValue lowercase and trim value of query parameter
+func normalizeValue(v string) string {
+	return strings.ToLower(strings.TrimSpace(v))
+}
+
 func queryToInt(q url.Values, k string) (int, error) {
 	r, err := strconv.ParseInt(q.Get(k), 10, 32)
 	return int(r), err
 
 }
 
+// parseSharpen apply sharpen with params from query, missing params have default values
+func parseSharpen(trans *transforms.Transforms, query url.Values) error {
+	params := map[string]float64{"radius": 0, "sigma": transforms.DefaultSharpenSigma, "amount": transforms.DefaultSharpenAmount, "threshold": 0}
+	for name := range params {
+		if v := query.Get(name); v != "" {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return err
+			}
+			params[name] = f
+		}
+	}
+
+	return trans.Sharpen(params["radius"], params["sigma"], params["amount"], params["threshold"])
+}
+
 func parseOperation(query url.Values) (transforms.Transforms, error) {
 	trans := transforms.New()
 	var err error
@@ -85,87 +177,160 @@ func parseOperation(query url.Values) (transforms.Transforms, error) {
 		return trans, err
 	}
 
-	for qsKey, values := range query {
-		if qsKey == "operation" {
-			for _, o := range values {
-				switch o {
-				case "resize":
-					var w, h int
-					w, _ = queryToInt(query, "width")
-					h, _ = queryToInt(query, "height")
-
-					err = trans.Resize(w, h, false, false, false)
-					if err != nil {
-						return trans, err
-					}
-				case "crop":
-					var w, h int
-					w, _ = queryToInt(query, "width")
-					h, _ = queryToInt(query, "height")
-
-					err = trans.Crop(w, h, query.Get("gravity"), false, query.Get("embed") != "")
-					if err != nil {
-						return trans, err
-					}
-				case "resizeCropAuto":
-					var w, h int
-					w, _ = queryToInt(query, "width")
-					h, _ = queryToInt(query, "height")
-
-					err = trans.ResizeCropAuto(w, h)
-					if err != nil {
-						return trans, err
-					}
-				case "extract":
-					var w, h, t, l int
-					w, _ = queryToInt(query, "areaWith")
-					h, _ = queryToInt(query, "areaHeight")
-					t, _ = queryToInt(query, "top")
-					l, _ = queryToInt(query, "left")
-
-					err = trans.Extract(t, l, w, h)
-					if err != nil {
-						return trans, err
-					}
-				case "watermark":
-					var opacity float64
-					opacity, err = strconv.ParseFloat(query.Get("opacity"), 32)
-					if err != nil {
-						return trans, err
-					}
-					err = trans.Watermark(query.Get("image"), query.Get("position"), float32(opacity))
-					if err != nil {
-						return trans, err
-					}
-				case "blur":
-					var sigma, minAmpl float64
-					sigma, err = strconv.ParseFloat(query.Get("sigma"), 32)
-					if err != nil {
-						return trans, err
-					}
-
-					minAmpl, _ = strconv.ParseFloat(query.Get("minAmpl"), 32)
-					err = trans.Blur(sigma, minAmpl)
-					if err != nil {
-						return trans, err
-					}
-				case "rotate":
-					var a int
-					a, err = queryToInt(query, "angle")
-					if err != nil {
-						return trans, err
-					}
-					err = trans.Rotate(a)
-					if err != nil {
-						return trans, err
-					}
+	for _, o := range canonicalOperations(query["operation"]) {
+		switch o {
+		case "resize":
+			var w, h int
+			w, _ = queryToInt(query, "width")
+			h, _ = queryToInt(query, "height")
+
+			if gravity := normalizeValue(query.Get("gravity")); gravity != "" && w != 0 && h != 0 {
+				// resize with gravity fills given box
+				err = trans.Crop(w, h, gravity, false, false)
+			} else {
+				err = trans.Resize(w, h, false, false, false)
+			}
+			if err != nil {
+				return trans, err
+			}
+		case "crop":
+			var w, h int
+			w, _ = queryToInt(query, "width")
+			h, _ = queryToInt(query, "height")
+
+			err = trans.Crop(w, h, normalizeValue(query.Get("gravity")), false, query.Get("embed") != "")
+			if err != nil {
+				return trans, err
+			}
+		case "resizeCropAuto":
+			var w, h int
+			w, _ = queryToInt(query, "width")
+			h, _ = queryToInt(query, "height")
+
+			err = trans.ResizeCropAuto(w, h)
+			if err != nil {
+				return trans, err
+			}
+		case "extract":
+			var w, h, t, l int
+			w, _ = queryToInt(query, "areaWith")
+			h, _ = queryToInt(query, "areaHeight")
+			t, _ = queryToInt(query, "top")
+			l, _ = queryToInt(query, "left")
+
+			err = trans.Extract(t, l, w, h)
+			if err != nil {
+				return trans, err
+			}
+		case "watermark":
+			var opacity float64
+			opacity, err = strconv.ParseFloat(query.Get("opacity"), 32)
+			if err != nil {
+				return trans, err
+			}
+			err = trans.Watermark(query.Get("image"), query.Get("position"), float32(opacity))
+			if err != nil {
+				return trans, err
+			}
+		case "blur":
+			var sigma, minAmpl float64
+			sigma, err = strconv.ParseFloat(query.Get("sigma"), 32)
+			if err != nil {
+				return trans, err
+			}
+
+			minAmpl, _ = strconv.ParseFloat(query.Get("minAmpl"), 32)
+			err = trans.Blur(sigma, minAmpl)
+			if err != nil {
+				return trans, err
+			}
+		case "trim":
+			tolerance := transforms.DefaultTrimTolerance
+			if _, ok := query["tolerance"]; ok {
+				tolerance, err = queryToInt(query, "tolerance")
+				if err != nil {
+					return trans, err
 				}
+			}
 
+			err = trans.Trim(tolerance, query.Get("background"))
+			if err != nil {
+				return trans, err
+			}
+		case "sharpen":
+			err = parseSharpen(&trans, query)
+			if err != nil {
+				return trans, err
+			}
+		case "duotone":
+			err = trans.Duotone(query.Get("shadow"), query.Get("highlight"))
+			if err != nil {
+				return trans, err
+			}
+		case "overlay":
+			opacity := 1.0
+			if v := query.Get("opacity"); v != "" {
+				opacity, err = strconv.ParseFloat(v, 64)
+				if err != nil {
+					return trans, err
+				}
 			}
-			break
-		}
 
+			err = trans.Overlay(query.Get("color"), opacity, query.Get("mode"))
+			if err != nil {
+				return trans, err
+			}
+		case "composite":
+			var width int
+			if v := query.Get("compositeWidth"); v != "" {
+				width, err = strconv.Atoi(v)
+				if err != nil {
+					return trans, err
+				}
+			}
+
+			opacity := 1.0
+			if v := query.Get("opacity"); v != "" {
+				opacity, err = strconv.ParseFloat(v, 64)
+				if err != nil {
+					return trans, err
+				}
+			}
+
+			err = trans.Composite(query.Get("image"), query.Get("position"), width, opacity, query.Get("mode"))
+			if err != nil {
+				return trans, err
+			}
+		case "pixelate":
+			blockSize := transforms.DefaultPixelateBlockSize
+			if _, ok := query["blockSize"]; ok {
+				blockSize, err = queryToInt(query, "blockSize")
+				if err != nil {
+					return trans, err
+				}
+			}
+
+			var regions []string
+			if v := query.Get("regions"); v != "" {
+				regions = strings.Split(v, ";")
+			}
+
+			err = trans.Pixelate(blockSize, regions)
+			if err != nil {
+				return trans, err
+			}
+		case "rotate":
+			var a int
+			a, err = queryToInt(query, "angle")
+			if err != nil {
+				return trans, err
+			}
+			err = trans.Rotate(a)
+			if err != nil {
+				return trans, err
+			}
+		}
 	}
-	return trans, nil
 
+	return trans, nil
 }
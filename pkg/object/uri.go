@@ -6,9 +6,11 @@ import (
 	"errors"
 	"fmt"
 	"net/url"
+	"path"
 	"strconv"
 	"strings"
 	"sync"
+	"text/template"
 
 	"github.com/aldor007/mort/pkg/config"
 	"github.com/spaolacci/murmur3"
@@ -81,13 +83,28 @@ func Parse(url *url.URL, mortConfig *config.Config, obj *FileObject) error {
 	// without creating the duplicate in the transform storage.
 	obj.Storage = bucketConfig.Storages.Noop()
 	if obj.Transforms.NotEmpty {
-		obj.Storage = bucketConfig.Storages.Transform()
+		if enc := bucketConfig.Transform.Encoder; enc != nil {
+			obj.Transforms.EncoderDefaults(enc.Progressive, enc.PNGCompression, enc.Speed, enc.ChromaSubsampling, enc.Effort)
+		}
+		obj.Transforms.EnlargementDefault(bucketConfig.Transform.WithoutEnlargement)
+		obj.Transforms.SetVersion(bucketConfig.TransformVersion)
+		obj.Storage = bucketConfig.Storages.Result(bucketConfig.Transform.ResultStorage)
 		if obj.allowChangeKey {
+			if tmpl := bucketConfig.Transform.ResultKeyTemplate(); tmpl != nil {
+				if obj.Key, err = templateKey(obj, tmpl, bucketConfig.TransformVersion); err != nil {
+					return fmt.Errorf("unable to create result key: %w", err)
+				}
+				return nil
+			}
+
 			switch bucketConfig.Transform.ResultKey {
 			case "hash":
 				obj.Key = hashKey(obj)
 			case "hashParent":
 				obj.Key = hashKeyParent(obj)
+			case "":
+				// request path doesn't contain hash, which changes with version
+				obj.Key = VersionPrefix(bucketConfig.TransformVersion) + obj.Key
 			}
 		}
 	}
@@ -148,6 +165,59 @@ func hashKeyParent(obj *FileObject) string {
 	return bufKey.String()
 }
 
+// templateKey returns key of transformed image created by template of result key
+func templateKey(obj *FileObject, tmpl *template.Template, version int) (string, error) {
+	data := config.ResultKeyData{
+		Bucket:    obj.Bucket,
+		Preset:    obj.Preset,
+		ParentKey: strings.TrimPrefix(obj.Parent.Key, "/"),
+		Format:    obj.Transforms.FormatStr,
+		Hash:      strconv.FormatUint(obj.Transforms.Hash().Sum64(), 16),
+		Version:   version,
+	}
+	if data.Preset == "" {
+		data.Preset = "query"
+	}
+	if data.Format == "" {
+		data.Format = strings.TrimPrefix(path.Ext(obj.Parent.Key), ".")
+	}
+
+	buf := bufPool.Get().(*bytes.Buffer)
+	defer bufPool.Put(buf)
+	buf.Reset()
+	if err := tmpl.Execute(buf, data); err != nil {
+		return "", err
+	}
+
+	key := buf.String()
+	if strings.Contains("/"+key+"/", "/../") {
+		return "", errors.New("result key can't contain ..")
+	}
+
+	key = path.Clean("/" + key)
+	if key == obj.Parent.Key {
+		return "", errors.New("result key can't be key of original")
+	}
+
+	return key, nil
+}
+
+// VersionPrefix returns prefix of keys of transformed images, which key is path of request, for transformVersion of bucket
+// Keys created by hash change with version, so they don't need prefix
+func VersionPrefix(version int) string {
+	if version == 0 {
+		return ""
+	}
+
+	return "/v" + strconv.Itoa(version)
+}
+
+// DerivativesPrefix returns prefix of keys of transformed images of original with given key
+// Only images with result key hashParent are grouped under it
+func DerivativesPrefix(key string) string {
+	return "/" + strings.Replace(strings.TrimPrefix(key, "/"), "/", "-", -1) + "/"
+}
+
 // RegisterParser add new kind of function to map of decoders and for config validator
 func RegisterParser(kind string, fn ParseFnc) {
 	parsers[kind] = fn
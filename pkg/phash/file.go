@@ -0,0 +1,102 @@
+package phash
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/aldor007/mort/pkg/monitoring"
+	"go.uber.org/zap"
+)
+
+// fileIndexSaveInterval how often changes of FileIndex are written to file
+const fileIndexSaveInterval = 10 * time.Second
+
+// FileIndex is MemoryIndex which is saved to file, so hashes survive restart
+// Changes are written periodically, file contains hashes in the same format as x-amz-meta-phash
+type FileIndex struct {
+	*MemoryIndex
+	path  string
+	dirty int32
+}
+
+// NewFileIndex load index from file, missing file gives empty index
+// Changes are saved in background every fileIndexSaveInterval
+func NewFileIndex(path string) (*FileIndex, error) {
+	f := &FileIndex{MemoryIndex: NewMemoryIndex(), path: path}
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if err == nil {
+		var buckets map[string]map[string]string
+		if err = json.Unmarshal(data, &buckets); err != nil {
+			return nil, err
+		}
+
+		for bucket, keys := range buckets {
+			for key, h := range keys {
+				if hash, err := Parse(h); err == nil {
+					f.MemoryIndex.Add(bucket, key, hash)
+				}
+			}
+		}
+	}
+
+	go func() {
+		for range time.Tick(fileIndexSaveInterval) {
+			if err := f.Save(); err != nil {
+				monitoring.Log().Warn("FileIndex unable to save perceptual hashes", zap.String("path", path), zap.Error(err))
+			}
+		}
+	}()
+
+	return f, nil
+}
+
+// Add store hash of image
+func (f *FileIndex) Add(bucket, key string, hash uint64) {
+	f.MemoryIndex.Add(bucket, key, hash)
+	atomic.StoreInt32(&f.dirty, 1)
+}
+
+// Delete remove image from index
+func (f *FileIndex) Delete(bucket, key string) {
+	f.MemoryIndex.Delete(bucket, key)
+	atomic.StoreInt32(&f.dirty, 1)
+}
+
+// Save write index to file if it was changed, file is replaced atomically
+func (f *FileIndex) Save() error {
+	if !atomic.CompareAndSwapInt32(&f.dirty, 1, 0) {
+		return nil
+	}
+
+	f.lock.RLock()
+	buckets := make(map[string]map[string]string, len(f.buckets))
+	for bucket, keys := range f.buckets {
+		buckets[bucket] = make(map[string]string, len(keys))
+		for key, hash := range keys {
+			buckets[bucket][key] = Format(hash)
+		}
+	}
+	f.lock.RUnlock()
+
+	data, err := json.Marshal(buckets)
+	if err == nil {
+		tmp := f.path + ".tmp"
+		if err = ioutil.WriteFile(tmp, data, 0644); err == nil {
+			err = os.Rename(tmp, f.path)
+		}
+	}
+
+	if err != nil {
+		// changes are written again with next save
+		atomic.StoreInt32(&f.dirty, 1)
+	}
+
+	return err
+}
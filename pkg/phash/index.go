@@ -0,0 +1,75 @@
+package phash
+
+import (
+	"sort"
+	"sync"
+)
+
+// Match image found in index
+type Match struct {
+	Key      string `json:"key"`
+	Hash     string `json:"hash"`
+	Distance int    `json:"distance"`
+}
+
+// Index stores perceptual hashes of originals per bucket
+type Index interface {
+	Add(bucket, key string, hash uint64)
+	Delete(bucket, key string)
+	// Nearest returns up to limit images with distance lower or equal maxDistance sorted by distance
+	Nearest(bucket string, hash uint64, maxDistance, limit int) []Match
+}
+
+// MemoryIndex keeps hashes in memory
+type MemoryIndex struct {
+	lock    sync.RWMutex
+	buckets map[string]map[string]uint64
+}
+
+// NewMemoryIndex create empty in memory index
+func NewMemoryIndex() *MemoryIndex {
+	return &MemoryIndex{buckets: make(map[string]map[string]uint64)}
+}
+
+// Add store hash of image
+func (m *MemoryIndex) Add(bucket, key string, hash uint64) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, ok := m.buckets[bucket]; !ok {
+		m.buckets[bucket] = make(map[string]uint64)
+	}
+
+	m.buckets[bucket][key] = hash
+}
+
+// Delete remove image from index
+func (m *MemoryIndex) Delete(bucket, key string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	delete(m.buckets[bucket], key)
+}
+
+// Nearest returns the most similar images in bucket
+func (m *MemoryIndex) Nearest(bucket string, hash uint64, maxDistance, limit int) []Match {
+	m.lock.RLock()
+	matches := make([]Match, 0)
+	for key, h := range m.buckets[bucket] {
+		if d := Distance(hash, h); d <= maxDistance {
+			matches = append(matches, Match{Key: key, Hash: Format(h), Distance: d})
+		}
+	}
+	m.lock.RUnlock()
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Distance == matches[j].Distance {
+			return matches[i].Key < matches[j].Key
+		}
+		return matches[i].Distance < matches[j].Distance
+	})
+
+	if limit > 0 && len(matches) > limit {
+		matches = matches[:limit]
+	}
+
+	return matches
+}
@@ -0,0 +1,116 @@
+// Package phash computes perceptual hashes of images and finds similar images
+package phash
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"math/bits"
+	"sort"
+	"strconv"
+)
+
+// HeaderPHash name of header in which perceptual hash of original is stored
+const HeaderPHash = "x-amz-meta-phash"
+
+// Size images should be downscaled to Size x Size before computing hash
+const Size = 32
+
+// lowFrequencies size of block of DCT coefficients used for hash
+const lowFrequencies = 8
+
+// FromImage compute 64 bit perceptual hash (DCT based) of image
+// For good results image should be already downscaled to Size x Size
+func FromImage(img image.Image) uint64 {
+	bounds := img.Bounds()
+	pixels := make([][]float64, Size)
+	for y := 0; y < Size; y++ {
+		pixels[y] = make([]float64, Size)
+		for x := 0; x < Size; x++ {
+			// nearest neighbour sampling when image is not downscaled yet
+			px := bounds.Min.X + x*bounds.Dx()/Size
+			py := bounds.Min.Y + y*bounds.Dy()/Size
+			pixels[y][x] = float64(color.GrayModel.Convert(img.At(px, py)).(color.Gray).Y)
+		}
+	}
+
+	coefficients := dct2d(pixels)
+	values := make([]float64, 0, lowFrequencies*lowFrequencies)
+	for y := 0; y < lowFrequencies; y++ {
+		for x := 0; x < lowFrequencies; x++ {
+			values = append(values, coefficients[y][x])
+		}
+	}
+
+	// DC coefficient describes only average brightness
+	sorted := append([]float64{}, values[1:]...)
+	sort.Float64s(sorted)
+	median := (sorted[len(sorted)/2-1] + sorted[len(sorted)/2]) / 2
+
+	var hash uint64
+	for i, v := range values {
+		if v > median {
+			hash |= 1 << uint(i)
+		}
+	}
+
+	return hash
+}
+
+// dct2d compute type II discrete cosine transform of square matrix
+func dct2d(m [][]float64) [][]float64 {
+	n := len(m)
+	cos := make([][]float64, n)
+	for u := 0; u < n; u++ {
+		cos[u] = make([]float64, n)
+		for x := 0; x < n; x++ {
+			cos[u][x] = math.Cos(float64(2*x+1) * float64(u) * math.Pi / float64(2*n))
+		}
+	}
+
+	rows := make([][]float64, n)
+	for y := 0; y < n; y++ {
+		rows[y] = make([]float64, n)
+		for u := 0; u < n; u++ {
+			sum := 0.0
+			for x := 0; x < n; x++ {
+				sum += m[y][x] * cos[u][x]
+			}
+			rows[y][u] = sum
+		}
+	}
+
+	result := make([][]float64, n)
+	for v := 0; v < n; v++ {
+		result[v] = make([]float64, n)
+		for u := 0; u < n; u++ {
+			sum := 0.0
+			for y := 0; y < n; y++ {
+				sum += rows[y][u] * cos[v][y]
+			}
+			result[v][u] = sum
+		}
+	}
+
+	return result
+}
+
+// Distance returns hamming distance between two hashes
+func Distance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// Format returns hex representation of hash
+func Format(hash uint64) string {
+	s := strconv.FormatUint(hash, 16)
+	for len(s) < 16 {
+		s = "0" + s
+	}
+
+	return s
+}
+
+// Parse read hash in hex representation
+func Parse(s string) (uint64, error) {
+	return strconv.ParseUint(s, 16, 64)
+}
@@ -0,0 +1,101 @@
+package phash
+
+import (
+	"image"
+	"image/color"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func gradient(size int, invert bool) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			v := uint8((x*255/size + y*128/size) / 2)
+			if invert {
+				v = 255 - v
+			}
+			img.SetGray(x, y, color.Gray{Y: v})
+		}
+	}
+
+	return img
+}
+
+func TestFromImageSameImage(t *testing.T) {
+	assert.Equal(t, FromImage(gradient(Size, false)), FromImage(gradient(Size, false)))
+}
+
+func TestFromImageScaled(t *testing.T) {
+	d := Distance(FromImage(gradient(Size, false)), FromImage(gradient(Size*4, false)))
+
+	assert.True(t, d <= 4, "distance %d", d)
+}
+
+func TestFromImageDifferent(t *testing.T) {
+	d := Distance(FromImage(gradient(Size, false)), FromImage(gradient(Size, true)))
+
+	assert.True(t, d > 20, "distance %d", d)
+}
+
+func TestFormatParse(t *testing.T) {
+	s := Format(0xff)
+	assert.Equal(t, "00000000000000ff", s)
+
+	h, err := Parse(s)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0xff), h)
+
+	_, err = Parse("")
+	assert.NotNil(t, err)
+}
+
+func TestMemoryIndexNearest(t *testing.T) {
+	index := NewMemoryIndex()
+	index.Add("bucket", "/a.jpg", 0x0)
+	index.Add("bucket", "/b.jpg", 0x3)
+	index.Add("bucket", "/c.jpg", 0xffff)
+	index.Add("other", "/d.jpg", 0x1)
+
+	matches := index.Nearest("bucket", 0x1, 10, 10)
+
+	assert.Equal(t, 2, len(matches))
+	assert.Equal(t, "/a.jpg", matches[0].Key)
+	assert.Equal(t, 1, matches[0].Distance)
+	assert.Equal(t, "/b.jpg", matches[1].Key)
+
+	assert.Equal(t, 1, len(index.Nearest("bucket", 0x1, 10, 1)))
+
+	index.Delete("bucket", "/a.jpg")
+	matches = index.Nearest("bucket", 0x1, 10, 10)
+	assert.Equal(t, 1, len(matches))
+	assert.Equal(t, "/b.jpg", matches[0].Key)
+}
+
+func TestFileIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mort-phash")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	indexPath := path.Join(dir, "phash.json")
+	index, err := NewFileIndex(indexPath)
+	assert.Nil(t, err)
+	index.Add("bucket", "/a.jpg", 0x1)
+	index.Add("bucket", "/b.jpg", 0x2)
+	index.Delete("bucket", "/b.jpg")
+	assert.Nil(t, index.Save())
+
+	loaded, err := NewFileIndex(indexPath)
+	assert.Nil(t, err)
+	matches := loaded.Nearest("bucket", 0x1, 10, 10)
+	assert.Equal(t, 1, len(matches))
+	assert.Equal(t, "/a.jpg", matches[0].Key)
+
+	assert.Nil(t, ioutil.WriteFile(indexPath, []byte("{"), 0644))
+	_, err = NewFileIndex(indexPath)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,425 @@
+package presets
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aldor007/mort/pkg/config"
+	"github.com/aldor007/mort/pkg/middleware"
+	"github.com/aldor007/mort/pkg/monitoring"
+	"github.com/aldor007/mort/pkg/object"
+	"github.com/aldor007/mort/pkg/response"
+	"github.com/aldor007/mort/pkg/storage"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v2"
+)
+
+// maxPresetSize max size of preset definition in request body
+const maxPresetSize = 1 << 20
+
+// errPresetChanged is returned when preset was changed after client read it
+var errPresetChanged = errors.New("preset doesn't match If-Match")
+
+// document is content of store, runtime presets are grouped by bucket name
+type document map[string]map[string]config.Preset
+
+// copy returns deep copy of document which can be changed without affecting original
+func (d document) copy() document {
+	c := make(document, len(d))
+	for bucket, presets := range d {
+		c[bucket] = make(map[string]config.Preset, len(presets))
+		for name, preset := range presets {
+			c[bucket][name] = preset
+		}
+	}
+
+	return c
+}
+
+// invalidError is returned when change of presets is rejected because of invalid input
+type invalidError struct {
+	StatusCode int
+	error
+}
+
+// API allows to create, update and remove presets at runtime
+// Presets are persisted in store object shared by all instances, each instance reloads it periodically
+// Presets from store are merged with presets from configuration file and can override them
+type API struct {
+	config  *config.Config
+	cfg     config.PresetsAPICfg
+	auth    *middleware.AdminAuth
+	lock    sync.Mutex // serializes changes of presets
+	presets document
+	version string // checksum of last applied store content
+	stop    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// NewAPI create presets API for given configuration
+func NewAPI(mortConfig *config.Config) *API {
+	return &API{
+		config:  mortConfig,
+		cfg:     mortConfig.Server.PresetsAPI,
+		auth:    middleware.NewAdminAuthMiddleware(config.AdminCfg{Token: mortConfig.Server.PresetsAPI.Token}),
+		presets: make(document),
+		stop:    make(chan struct{}),
+	}
+}
+
+// Enabled check if API is configured
+func (a *API) Enabled() bool {
+	return a.cfg.Token != ""
+}
+
+// Start load presets from store and reload them in background
+func (a *API) Start() {
+	if err := a.Load(); err != nil {
+		monitoring.Log().Warn("Presets/API unable to load presets", zap.Error(err))
+	}
+
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		ticker := time.NewTicker(time.Duration(a.cfg.Refresh) * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-a.stop:
+				return
+			case <-ticker.C:
+				if err := a.Load(); err != nil {
+					monitoring.Log().Warn("Presets/API unable to reload presets", zap.Error(err))
+				}
+			}
+		}
+	}()
+}
+
+// Stop finish reloading of presets
+func (a *API) Stop() {
+	close(a.stop)
+	a.wg.Wait()
+}
+
+// Load fetch presets from store and apply them when store was changed
+func (a *API) Load() error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.load()
+}
+
+func (a *API) load() error {
+	buf, err := a.fetch()
+	if err != nil {
+		return err
+	}
+
+	version := checksum(buf)
+	if version == a.version {
+		return nil
+	}
+
+	doc := make(document)
+	if err = yaml.Unmarshal(buf, &doc); err != nil {
+		return err
+	}
+
+	merged, err := a.merge(doc)
+	if err != nil {
+		return err
+	}
+
+	a.apply(merged)
+	a.presets, a.version = doc, version
+	monitoring.Log().Info("Presets/API presets loaded", zap.String("version", version))
+	return nil
+}
+
+// change set or remove (when preset is nil) runtime preset of bucket, result is persisted and applied
+// With ifMatch change is done only when ETag of current preset matches it, so concurrent changes aren't lost
+func (a *API) change(bucketName, presetName string, preset *config.Preset, ifMatch string) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	// start from presets changed by other instances
+	if err := a.load(); err != nil {
+		return err
+	}
+
+	bucket, ok := a.config.Buckets[bucketName]
+	if !ok {
+		return invalidError{404, fmt.Errorf("unknown bucket %s", bucketName)}
+	}
+
+	if bucket.Transform == nil || (bucket.Transform.Kind != "presets" && bucket.Transform.Kind != "presets-query") {
+		return invalidError{400, fmt.Errorf("bucket %s doesn't use presets", bucketName)}
+	}
+
+	if ifMatch != "" && !matchesETag(ifMatch, presetETag(bucket, presetName)) {
+		return invalidError{412, errPresetChanged}
+	}
+
+	doc := a.presets.copy()
+	if preset == nil {
+		if _, ok := doc[bucketName][presetName]; !ok {
+			return invalidError{404, fmt.Errorf("unknown runtime preset %s", presetName)}
+		}
+		delete(doc[bucketName], presetName)
+	} else {
+		if doc[bucketName] == nil {
+			doc[bucketName] = make(map[string]config.Preset)
+		}
+		doc[bucketName][presetName] = *preset
+	}
+
+	merged, err := a.merge(doc)
+	if err != nil {
+		return invalidError{400, err}
+	}
+
+	buf, err := yaml.Marshal(doc)
+	if err != nil {
+		return err
+	}
+
+	if err = a.save(buf); err != nil {
+		return err
+	}
+
+	a.apply(merged)
+	a.presets, a.version = doc, checksum(buf)
+	return nil
+}
+
+// merge returns presets of each bucket with transform merged with runtime presets from document
+func (a *API) merge(doc document) (map[string]map[string]config.Preset, error) {
+	for bucketName := range doc {
+		if bucket, ok := a.config.Buckets[bucketName]; !ok || bucket.Transform == nil {
+			return nil, fmt.Errorf("bucket %s doesn't have transform", bucketName)
+		}
+	}
+
+	merged := make(map[string]map[string]config.Preset)
+	for bucketName, bucket := range a.config.Buckets {
+		if bucket.Transform == nil {
+			continue
+		}
+
+		presets, err := bucket.Transform.MergePresets(doc[bucketName])
+		if err != nil {
+			return nil, fmt.Errorf("bucket %s - %s", bucketName, err)
+		}
+
+		for presetName := range doc[bucketName] {
+			if err = object.ValidatePreset(presets[presetName]); err != nil {
+				return nil, fmt.Errorf("bucket %s preset %s - %s", bucketName, presetName, err)
+			}
+		}
+
+		merged[bucketName] = presets
+	}
+
+	return merged, nil
+}
+
+// apply replace presets of buckets, cached transforms of presets are dropped
+func (a *API) apply(merged map[string]map[string]config.Preset) {
+	for bucketName, presets := range merged {
+		a.config.Buckets[bucketName].Transform.SetPresets(presets)
+	}
+
+	object.ResetPresetCache()
+}
+
+func (a *API) storeObject() (*object.FileObject, error) {
+	return object.NewFileObjectFromPath("/"+a.cfg.Bucket+a.cfg.Key, a.config)
+}
+
+// fetch returns content of store, missing store is treated as empty
+func (a *API) fetch() ([]byte, error) {
+	obj, err := a.storeObject()
+	if err != nil {
+		return nil, err
+	}
+
+	res := storage.Get(obj)
+	defer res.Close()
+	if res.StatusCode == 404 {
+		return []byte{}, nil
+	}
+
+	if res.StatusCode != 200 {
+		return nil, fmt.Errorf("unable to fetch presets store, status code %d", res.StatusCode)
+	}
+
+	return res.Body()
+}
+
+func (a *API) save(buf []byte) error {
+	obj, err := a.storeObject()
+	if err != nil {
+		return err
+	}
+
+	headers := make(http.Header)
+	headers.Set("Content-Type", "application/yaml")
+	res := storage.Set(obj, headers, int64(len(buf)), bytes.NewReader(buf))
+	defer res.Close()
+	if res.HasError() {
+		return res.Error()
+	}
+
+	return nil
+}
+
+func checksum(buf []byte) string {
+	sum := sha256.Sum256(buf)
+	return hex.EncodeToString(sum[:])
+}
+
+// presetETag returns ETag of preset used by requests, empty string is returned when bucket doesn't have preset
+func presetETag(bucket config.Bucket, presetName string) string {
+	if bucket.Transform == nil {
+		return ""
+	}
+
+	preset, ok := bucket.Transform.Preset(presetName)
+	if !ok {
+		return ""
+	}
+
+	buf, err := yaml.Marshal(preset)
+	if err != nil {
+		return ""
+	}
+
+	return `"` + checksum(buf) + `"`
+}
+
+// matchesETag check if value of If-Match header matches ETag, "*" matches any existing preset
+func matchesETag(ifMatch, etag string) bool {
+	if etag == "" {
+		return false
+	}
+
+	for _, value := range strings.Split(ifMatch, ",") {
+		value = strings.TrimSpace(value)
+		if value == "*" || value == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ServeHTTP handle requests of API
+// GET /presets/{bucket} returns runtime presets of bucket, GET /presets/{bucket}/{name} returns preset used by requests
+// PUT /presets/{bucket}/{name} create or update runtime preset from YAML or JSON body, DELETE /presets/{bucket}/{name} remove it
+// GET returns ETag of preset, PUT and DELETE with If-Match are rejected with 412 when preset was changed in the meantime
+func (a *API) ServeHTTP(resWriter http.ResponseWriter, req *http.Request) {
+	if !a.auth.Authorized(req) {
+		response.NewNoContent(401).Send(resWriter)
+		return
+	}
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(req.URL.Path, "/presets"), "/"), "/")
+	bucketName := parts[0]
+	if bucketName == "" || len(parts) > 2 {
+		response.NewNoContent(404).Send(resWriter)
+		return
+	}
+
+	if len(parts) == 1 {
+		if req.Method != http.MethodGet {
+			response.NewNoContent(405).Send(resWriter)
+			return
+		}
+
+		a.lock.Lock()
+		buf, err := yaml.Marshal(a.presets[bucketName])
+		a.lock.Unlock()
+		sendYAML(resWriter, buf, err)
+		return
+	}
+
+	presetName := parts[1]
+	var err error
+	switch req.Method {
+	case http.MethodGet:
+		bucket, ok := a.config.Buckets[bucketName]
+		if !ok || bucket.Transform == nil {
+			response.NewNoContent(404).Send(resWriter)
+			return
+		}
+
+		preset, ok := bucket.Transform.Preset(presetName)
+		if !ok {
+			response.NewNoContent(404).Send(resWriter)
+			return
+		}
+
+		buf, errYAML := yaml.Marshal(preset)
+		if errYAML == nil {
+			resWriter.Header().Set("ETag", `"`+checksum(buf)+`"`)
+		}
+		sendYAML(resWriter, buf, errYAML)
+		return
+	case http.MethodPut:
+		var body []byte
+		body, err = ioutil.ReadAll(http.MaxBytesReader(resWriter, req.Body, maxPresetSize))
+		if err != nil {
+			response.NewString(400, err.Error()).Send(resWriter)
+			return
+		}
+
+		preset := config.Preset{}
+		if err = yaml.UnmarshalStrict(body, &preset); err != nil {
+			response.NewString(400, err.Error()).Send(resWriter)
+			return
+		}
+
+		err = a.change(bucketName, presetName, &preset, req.Header.Get("If-Match"))
+	case http.MethodDelete:
+		err = a.change(bucketName, presetName, nil, req.Header.Get("If-Match"))
+	default:
+		response.NewNoContent(405).Send(resWriter)
+		return
+	}
+
+	if err != nil {
+		if invalid, ok := err.(invalidError); ok {
+			response.NewString(invalid.StatusCode, invalid.Error()).Send(resWriter)
+			return
+		}
+
+		monitoring.Log().Error("Presets/API unable to change preset", zap.String("bucket", bucketName), zap.String("preset", presetName), zap.Error(err))
+		response.NewError(503, err).Send(resWriter)
+		return
+	}
+
+	monitoring.Log().Info("Presets/API preset changed", zap.String("bucket", bucketName), zap.String("preset", presetName),
+		zap.String("req.method", req.Method), zap.String("req.remoteAddr", req.RemoteAddr))
+	res := response.NewNoContent(200)
+	if etag := presetETag(a.config.Buckets[bucketName], presetName); etag != "" {
+		res.Set("ETag", etag)
+	}
+	res.Send(resWriter)
+}
+
+func sendYAML(resWriter http.ResponseWriter, buf []byte, err error) {
+	if err != nil {
+		response.NewError(500, err).Send(resWriter)
+		return
+	}
+
+	response.NewBuf(200, buf).SetContentType("application/yaml").Send(resWriter)
+}
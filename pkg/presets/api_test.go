@@ -0,0 +1,154 @@
+package presets
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/aldor007/mort/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+const testConfig = `
+server:
+    presetsAPI:
+        token: "secret"
+        bucket: "%s-config"
+buckets:
+    %s:
+        transform:
+            path: "\\/(?P<presetName>[a-z0-9_]+)\\/(?P<parent>.*)"
+            kind: "presets"
+            presets:
+                small:
+                    quality: 75
+                    filters:
+                        thumbnail:
+                            width: 150
+        storages:
+            basic:
+                kind: "local-meta"
+                rootPath: "%s/originals"
+            transform:
+                kind: "local-meta"
+                rootPath: "%s/derivatives"
+    %s-config:
+        storages:
+            basic:
+                kind: "local-meta"
+                rootPath: "%s/config"
+`
+
+// testAPI create API with store in temporary directory
+// Storage clients are cached by bucket name, so every test should use other bucket
+func testAPI(t *testing.T, bucketName string) *API {
+	dir, err := ioutil.TempDir("", "mort-presets")
+	assert.Nil(t, err)
+	t.Cleanup(func() {
+		os.RemoveAll(dir)
+	})
+	os.MkdirAll(dir+"/config", 0755)
+
+	mortConfig := &config.Config{}
+	err = mortConfig.LoadFromString(fmt.Sprintf(testConfig, bucketName, bucketName, dir, dir, bucketName, dir))
+	assert.Nil(t, err)
+
+	return NewAPI(mortConfig)
+}
+
+func doRequest(api *API, method, path, token, body string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, "http://mort"+path, bytes.NewBufferString(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	recorder := httptest.NewRecorder()
+	api.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestAPI_Unauthorized(t *testing.T) {
+	api := testAPI(t, "unauthorized")
+
+	assert.Equal(t, 401, doRequest(api, "GET", "/presets/unauthorized/small", "", "").Code)
+	assert.Equal(t, 401, doRequest(api, "GET", "/presets/unauthorized/small", "invalid", "").Code)
+	assert.Equal(t, 200, doRequest(api, "GET", "/presets/unauthorized/small", "secret", "").Code)
+}
+
+func TestAPI_PutAndDelete(t *testing.T) {
+	api := testAPI(t, "put")
+	transform := api.config.Buckets["put"].Transform
+
+	res := doRequest(api, "PUT", "/presets/put/medium", "secret", "extends: small\nfilters:\n  grayscale: true\n")
+	assert.Equal(t, 200, res.Code)
+
+	preset, ok := transform.Preset("medium")
+	assert.True(t, ok)
+	assert.Equal(t, 75, preset.Quality)
+	assert.True(t, preset.Filters.Grayscale)
+
+	res = doRequest(api, "GET", "/presets/put", "secret", "")
+	assert.Equal(t, 200, res.Code)
+	assert.Contains(t, res.Body.String(), "medium")
+
+	other := NewAPI(api.config)
+	other.config.Buckets["put"].Transform.SetPresets(nil)
+	assert.Nil(t, other.Load())
+	_, ok = transform.Preset("medium")
+	assert.True(t, ok, "presets should be loaded from store")
+
+	assert.Equal(t, 200, doRequest(api, "DELETE", "/presets/put/medium", "secret", "").Code)
+	_, ok = transform.Preset("medium")
+	assert.False(t, ok)
+	_, ok = transform.Preset("small")
+	assert.True(t, ok, "presets from configuration file should be kept")
+
+	assert.Equal(t, 404, doRequest(api, "DELETE", "/presets/put/small", "secret", "").Code)
+}
+
+func TestAPI_PutInvalid(t *testing.T) {
+	api := testAPI(t, "invalid")
+
+	assert.Equal(t, 400, doRequest(api, "PUT", "/presets/invalid/medium", "secret", "extends: missing\n").Code)
+	assert.Equal(t, 400, doRequest(api, "PUT", "/presets/invalid/medium", "secret", "qualty: 80\n").Code)
+	assert.Equal(t, 400, doRequest(api, "PUT", "/presets/invalid/medium", "secret", "format: unknown\n").Code)
+	assert.Equal(t, 400, doRequest(api, "PUT", "/presets/invalid-config/medium", "secret", "quality: 80\n").Code)
+	assert.Equal(t, 404, doRequest(api, "PUT", "/presets/missing/medium", "secret", "quality: 80\n").Code)
+
+	_, ok := api.config.Buckets["invalid"].Transform.Preset("medium")
+	assert.False(t, ok)
+}
+
+func TestAPI_IfMatch(t *testing.T) {
+	api := testAPI(t, "ifmatch")
+	send := func(method, body, ifMatch string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, "http://mort/presets/ifmatch/small", bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+
+		recorder := httptest.NewRecorder()
+		api.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	etag := send("GET", "", "").Header().Get("ETag")
+	assert.NotEqual(t, "", etag)
+
+	res := send("PUT", "quality: 60\n", etag)
+	assert.Equal(t, 200, res.Code)
+	assert.NotEqual(t, etag, res.Header().Get("ETag"))
+	assert.Equal(t, res.Header().Get("ETag"), send("GET", "", "").Header().Get("ETag"))
+
+	assert.Equal(t, 412, send("PUT", "quality: 50\n", etag).Code, "change based on stale preset should be rejected")
+	preset, _ := api.config.Buckets["ifmatch"].Transform.Preset("small")
+	assert.Equal(t, 60, preset.Quality)
+
+	assert.Equal(t, 412, send("DELETE", "", etag).Code)
+	assert.Equal(t, 200, send("DELETE", "", "*").Code)
+}
@@ -0,0 +1,104 @@
+package processor
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+
+	"github.com/aldor007/mort/pkg/cache"
+	"github.com/aldor007/mort/pkg/config"
+	"github.com/aldor007/mort/pkg/monitoring"
+	"github.com/aldor007/mort/pkg/object"
+	"github.com/aldor007/mort/pkg/response"
+	"go.uber.org/zap"
+)
+
+// hotlinkKeySuffix suffix of key of images served to hotlinking requests, so they are stored and cached separately
+const hotlinkKeySuffix = "-hotlink"
+
+// hotlinkVary request headers which change response of transform with hotlink protection
+var hotlinkVary = []string{"Origin", "Referer"}
+
+var (
+	errAddressDenied = errors.New("client address is not allowed") // error when client address isn't allowed in bucket
+	errHotlink       = errors.New("hotlinking is not allowed")     // error when image is embedded by not allowed site
+)
+
+// clientIP returns address of client which sent request
+func clientIP(req *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+
+	return net.ParseIP(host)
+}
+
+// refererHost returns host of page which embeds image taken from Referer or Origin
+// ok is false when header is present but it isn't valid URL
+func refererHost(req *http.Request) (host string, ok bool) {
+	value := req.Header.Get("Referer")
+	if value == "" {
+		value = req.Header.Get("Origin")
+	}
+
+	if value == "" {
+		return "", true
+	}
+
+	u, err := url.Parse(value)
+	if err != nil || u.Hostname() == "" {
+		return "", false
+	}
+
+	return u.Hostname(), true
+}
+
+// hotlinkRule returns hotlink protection of transform of object, nil when object isn't protected
+func hotlinkRule(obj *object.FileObject) *config.HotlinkCfg {
+	bucket, ok := config.GetInstance().Buckets[obj.Bucket]
+	if !ok || bucket.Access == nil || !obj.HasTransform() {
+		return nil
+	}
+
+	return bucket.Access.HotlinkFor(obj.Preset)
+}
+
+// checkAccess returns error response when client address or referer of request isn't allowed in bucket
+// Hotlinking request is served with hotlink preset, when it is configured
+func checkAccess(req *http.Request, obj *object.FileObject, hotlink *config.HotlinkCfg) *response.Response {
+	bucket, ok := config.GetInstance().Buckets[obj.Bucket]
+	if !ok || bucket.Access == nil {
+		return nil
+	}
+
+	if !bucket.Access.AllowsIP(clientIP(req)) {
+		monitoring.Log().Warn("Processor/checkAccess client address denied", obj.LogData(zap.String("remoteAddr", req.RemoteAddr))...)
+		monitoring.Report().Inc("access_denied_count;bucket:" + obj.Bucket + ",reason:address")
+		return response.NewError(403, errAddressDenied)
+	}
+
+	if hotlink == nil || (req.Method != "GET" && req.Method != "HEAD") {
+		return nil
+	}
+
+	if host, ok := refererHost(req); ok && hotlink.AllowsHost(host) {
+		return nil
+	}
+
+	if hotlink.Preset != "" {
+		err := obj.SwapPreset(hotlink.Preset)
+		if err == nil {
+			obj.UpdateKey(hotlinkKeySuffix)
+			monitoring.Report().Inc("access_denied_count;bucket:" + obj.Bucket + ",reason:hotlink_preset")
+			return nil
+		}
+		monitoring.Log().Warn("Processor/checkAccess unable to swap hotlink preset", obj.LogData(zap.String("preset", hotlink.Preset), zap.Error(err))...)
+	}
+
+	monitoring.Report().Inc("access_denied_count;bucket:" + obj.Bucket + ",reason:hotlink")
+	res := response.NewError(403, errHotlink)
+	cache.AddVary(res, hotlinkVary)
+	return res
+}
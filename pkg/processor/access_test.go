@@ -0,0 +1,73 @@
+package processor
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/aldor007/mort/pkg/config"
+	"github.com/aldor007/mort/pkg/object"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRefererHost(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://mort/local/small.jpg-small", nil)
+	host, ok := refererHost(req)
+	assert.True(t, ok)
+	assert.Equal(t, "", host)
+
+	req.Header.Set("Origin", "https://origin.example.com")
+	host, _ = refererHost(req)
+	assert.Equal(t, "origin.example.com", host)
+
+	req.Header.Set("Referer", "https://www.example.com:8080/page.html")
+	host, _ = refererHost(req)
+	assert.Equal(t, "www.example.com", host, "referer should be preferred over origin")
+
+	req.Header.Set("Referer", "not a url")
+	_, ok = refererHost(req)
+	assert.False(t, ok)
+}
+
+func TestCheckAccessHotlink(t *testing.T) {
+	mortConfig := config.GetInstance()
+	err := mortConfig.Load("./benchmark/small.yml")
+	assert.Nil(t, err)
+
+	bucket := mortConfig.Buckets["local"]
+	bucket.Access = &config.AccessCfg{Hotlink: &config.HotlinkCfg{Referers: []string{"example.com"}},
+		Presets: map[string]config.HotlinkCfg{"m": {Referers: []string{"example.com"}, Preset: "mm"}}}
+	mortConfig.Buckets["local"] = bucket
+	defer func() {
+		bucket.Access = nil
+		mortConfig.Buckets["local"] = bucket
+	}()
+
+	req, _ := http.NewRequest("GET", "http://mort/local/small.jpg-small", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("Referer", "https://example.com/gallery")
+	obj, err := object.NewFileObject(req.URL, mortConfig)
+	assert.Nil(t, err)
+	hotlink := hotlinkRule(obj)
+	assert.NotNil(t, hotlink)
+	assert.Nil(t, checkAccess(req, obj, hotlink))
+
+	req.Header.Set("Referer", "https://evil.com/")
+	res := checkAccess(req, obj, hotlink)
+	assert.NotNil(t, res)
+	assert.Equal(t, 403, res.StatusCode)
+	assert.Equal(t, []string{"Origin", "Referer"}, res.Headers.Values("Vary"))
+
+	req, _ = http.NewRequest("GET", "http://mort/local/small.jpg-m", nil)
+	req.Header.Set("Referer", "https://evil.com/")
+	obj, err = object.NewFileObject(req.URL, mortConfig)
+	assert.Nil(t, err)
+	key := obj.Key
+	assert.Nil(t, checkAccess(req, obj, hotlinkRule(obj)), "hotlinking request should be served with hotlink preset")
+	assert.Equal(t, "mm", obj.Preset)
+	assert.Equal(t, key+hotlinkKeySuffix, obj.Key)
+
+	req, _ = http.NewRequest("GET", "http://mort/local/small.jpg", nil)
+	obj, err = object.NewFileObject(req.URL, mortConfig)
+	assert.Nil(t, err)
+	assert.Nil(t, hotlinkRule(obj), "originals should not be protected")
+}
@@ -0,0 +1,133 @@
+package processor
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/aldor007/mort/pkg/config"
+	"github.com/aldor007/mort/pkg/monitoring"
+	"github.com/aldor007/mort/pkg/object"
+	"github.com/aldor007/mort/pkg/throttler"
+)
+
+// maxBandwidthChunk max number of bytes written to client at once when bandwidth is limited, smaller chunks make rate smoother
+const maxBandwidthChunk = 32 << 10
+
+// globalLimiter name of global limiter in metrics
+const globalLimiter = "global"
+
+// bandwidthLimiters keeps global limiter and limiters of buckets with bandwidth configuration
+// Limiters of buckets are created on first use, so they follow configuration of buckets loaded after creation of processor
+type bandwidthLimiters struct {
+	global  *throttler.Bandwidth
+	lock    sync.Mutex
+	buckets map[string]*throttler.Bandwidth
+}
+
+func newBandwidthLimiters(cfg config.BandwidthCfg) *bandwidthLimiters {
+	b := &bandwidthLimiters{global: throttler.NewBandwidth(cfg.Rate, cfg.Burst), buckets: make(map[string]*throttler.Bandwidth)}
+	if b.global != nil {
+		monitoring.Report().Gauge("bandwidth_limit_bytes;limiter:"+globalLimiter, float64(cfg.Rate))
+	}
+
+	return b
+}
+
+// get returns limiter of bucket, nil is returned when bucket isn't limited
+func (b *bandwidthLimiters) get(bucketName string) *throttler.Bandwidth {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if limiter, ok := b.buckets[bucketName]; ok {
+		return limiter
+	}
+
+	var limiter *throttler.Bandwidth
+	if bucket, ok := config.GetInstance().Buckets[bucketName]; ok && bucket.Bandwidth != nil {
+		limiter = throttler.NewBandwidth(bucket.Bandwidth.Rate, bucket.Bandwidth.Burst)
+		monitoring.Report().Gauge("bandwidth_limit_bytes;limiter:"+bucketName, float64(bucket.Bandwidth.Rate))
+	}
+
+	b.buckets[bucketName] = limiter
+	return limiter
+}
+
+// LimitBandwidth returns writer which sends response body to client within global bandwidth and bandwidth of bucket of object
+// Writer is returned unchanged when neither is limited, waiting for bandwidth stops when client closes request
+func (r *RequestProcessor) LimitBandwidth(w http.ResponseWriter, req *http.Request, obj *object.FileObject) http.ResponseWriter {
+	if r.bandwidth == nil {
+		return w
+	}
+
+	bucketLimiter := r.bandwidth.get(obj.Bucket)
+	if r.bandwidth.global == nil && bucketLimiter == nil {
+		return w
+	}
+
+	lw := &limitedWriter{ResponseWriter: w, ctx: req.Context(), chunk: maxBandwidthChunk}
+	if r.bandwidth.global != nil {
+		lw.limiters = append(lw.limiters, r.bandwidth.global)
+		lw.names = append(lw.names, globalLimiter)
+	}
+	if bucketLimiter != nil {
+		lw.limiters = append(lw.limiters, bucketLimiter)
+		lw.names = append(lw.names, obj.Bucket)
+	}
+
+	for _, limiter := range lw.limiters {
+		if burst := limiter.Burst(); burst < lw.chunk {
+			lw.chunk = burst
+		}
+	}
+
+	return lw
+}
+
+// limitedWriter writes body in chunks, each chunk waits for tokens of all limiters
+// It doesn't implement io.ReaderFrom, so files are copied through it instead of sendfile
+type limitedWriter struct {
+	http.ResponseWriter
+	ctx      context.Context
+	limiters []*throttler.Bandwidth
+	names    []string // names of limiters used in metrics
+	chunk    int
+}
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := len(p)
+		if n > w.chunk {
+			n = w.chunk
+		}
+
+		for i, limiter := range w.limiters {
+			waited, err := limiter.Wait(w.ctx, n)
+			if err != nil {
+				return written, err
+			}
+			if waited > 0 {
+				monitoring.Report().Counter("bandwidth_throttled_seconds;limiter:"+w.names[i], waited.Seconds())
+			}
+		}
+
+		m, err := w.ResponseWriter.Write(p[:n])
+		written += m
+		for _, name := range w.names {
+			monitoring.Report().Counter("bandwidth_sent_bytes;limiter:"+name, float64(m))
+		}
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+
+	return written, nil
+}
+
+// Flush sends buffered data to client, when underlying writer supports it
+func (w *limitedWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
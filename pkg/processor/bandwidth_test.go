@@ -0,0 +1,37 @@
+package processor
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aldor007/mort/pkg/config"
+	"github.com/aldor007/mort/pkg/object"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimitBandwidthDisabled(t *testing.T) {
+	rp := RequestProcessor{bandwidth: newBandwidthLimiters(config.BandwidthCfg{})}
+	req := httptest.NewRequest("GET", "http://mort/unknown/file.jpg", nil)
+	w := httptest.NewRecorder()
+
+	assert.Equal(t, http.ResponseWriter(w), rp.LimitBandwidth(w, req, &object.FileObject{Bucket: "unknown"}))
+}
+
+func TestLimitBandwidth(t *testing.T) {
+	rp := RequestProcessor{bandwidth: newBandwidthLimiters(config.BandwidthCfg{Rate: 10000, Burst: 1000})}
+	req := httptest.NewRequest("GET", "http://mort/unknown/file.jpg", nil)
+	rec := httptest.NewRecorder()
+
+	w := rp.LimitBandwidth(rec, req, &object.FileObject{Bucket: "unknown"})
+	assert.Equal(t, 1000, w.(*limitedWriter).chunk, "chunk should not exceed burst")
+
+	body := make([]byte, 3000)
+	start := time.Now()
+	n, err := w.Write(body)
+	assert.Nil(t, err)
+	assert.Equal(t, 3000, n)
+	assert.Equal(t, 3000, rec.Body.Len())
+	assert.True(t, time.Since(start) >= 150*time.Millisecond, "bytes above burst should wait for bandwidth")
+}
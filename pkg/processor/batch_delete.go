@@ -0,0 +1,147 @@
+package processor
+
+import (
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/aldor007/mort/pkg/config"
+	"github.com/aldor007/mort/pkg/middleware"
+	"github.com/aldor007/mort/pkg/monitoring"
+	"github.com/aldor007/mort/pkg/object"
+	"github.com/aldor007/mort/pkg/response"
+	"go.uber.org/zap"
+)
+
+const (
+	// maxDeleteObjects max number of keys in single batch delete, the same as in S3
+	maxDeleteObjects = 1000
+	// maxDeleteBodySize max size of body of batch delete
+	maxDeleteBodySize = 2 << 20
+	// deleteConcurrency number of objects deleted concurrently by single batch delete
+	deleteConcurrency = 16
+)
+
+// deleteRequest is body of S3 DeleteObjects request
+type deleteRequest struct {
+	XMLName xml.Name `xml:"Delete"`
+	Quiet   bool     `xml:"Quiet"`
+	Objects []struct {
+		Key string `xml:"Key"`
+	} `xml:"Object"`
+}
+
+// deletedObject is entry of object removed by batch delete
+type deletedObject struct {
+	Key string `xml:"Key"`
+}
+
+// deleteError is entry of object which batch delete failed to remove
+type deleteError struct {
+	Key     string `xml:"Key"`
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// deleteResult is body of response of S3 DeleteObjects request
+type deleteResult struct {
+	XMLName xml.Name        `xml:"DeleteResult"`
+	Deleted []deletedObject `xml:"Deleted"`
+	Errors  []deleteError   `xml:"Error"`
+}
+
+// isBatchDelete check if request is S3 DeleteObjects request (POST /bucket?delete)
+func isBatchDelete(req *http.Request, obj *object.FileObject) bool {
+	_, ok := req.URL.Query()["delete"]
+	return ok && obj.Key == ""
+}
+
+// handleBatchDelete removes up to 1000 objects of bucket given in XML body, result of each object is reported separately
+func (r *RequestProcessor) handleBatchDelete(req *http.Request, obj *object.FileObject) *response.Response {
+	defer req.Body.Close()
+	if req.Context().Value(middleware.S3AuthCtxKey) == nil {
+		return response.NewError(403, response.NewCodedError("AccessDenied", "batch delete requires signed request"))
+	}
+
+	if !isMethodAllowed(obj, "DELETE") {
+		return methodNotAllowed(obj)
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(req.Body, maxDeleteBodySize+1))
+	if err != nil {
+		return response.NewError(400, err)
+	}
+
+	if len(body) > maxDeleteBodySize {
+		return response.NewError(400, response.NewCodedError("MalformedXML", "body of delete request is too large"))
+	}
+
+	if errRes := verifyChecksums(req.Header, body); errRes != nil {
+		return errRes
+	}
+
+	deleteReq := deleteRequest{}
+	if err = xml.Unmarshal(body, &deleteReq); err != nil || len(deleteReq.Objects) == 0 || len(deleteReq.Objects) > maxDeleteObjects {
+		return response.NewError(400, response.NewCodedError("MalformedXML", "delete request should list from 1 to 1000 objects"))
+	}
+
+	errs := make([]*deleteError, len(deleteReq.Objects))
+	sem := make(chan struct{}, deleteConcurrency)
+	var wg sync.WaitGroup
+	for i := range deleteReq.Objects {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, key string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			errs[i] = r.deleteKey(req, obj, key)
+		}(i, deleteReq.Objects[i].Key)
+	}
+	wg.Wait()
+
+	result := deleteResult{}
+	for i, o := range deleteReq.Objects {
+		if errs[i] != nil {
+			result.Errors = append(result.Errors, *errs[i])
+		} else if !deleteReq.Quiet {
+			result.Deleted = append(result.Deleted, deletedObject{Key: o.Key})
+		}
+	}
+
+	monitoring.Report().Inc("request_type;type:batch_delete")
+	monitoring.Log().Info("Processor/handleBatchDelete", obj.LogData(zap.Int("objects", len(deleteReq.Objects)), zap.Int("errors", len(result.Errors)))...)
+	buf, err := xml.Marshal(result)
+	if err != nil {
+		return response.NewError(500, err)
+	}
+
+	res := response.NewBuf(200, append([]byte(xml.Header), buf...))
+	res.SetContentType("application/xml")
+	return res
+}
+
+// deleteKey removes single object of batch delete the same way as DELETE request, error is returned when it failed
+func (r *RequestProcessor) deleteKey(req *http.Request, bucketObj *object.FileObject, key string) *deleteError {
+	keyObj, err := object.NewFileObjectFromPath("/"+bucketObj.Bucket+"/"+strings.TrimPrefix(key, "/"), config.GetInstance())
+	if err != nil || keyObj.Key == "" {
+		return &deleteError{Key: key, Code: "InvalidArgument", Message: "invalid key"}
+	}
+	keyObj.FillWithRequest(req, bucketObj.Ctx)
+
+	var res *response.Response
+	if bucket, ok := config.GetInstance().Buckets[keyObj.Bucket]; ok && isTrashed(bucket, keyObj) {
+		res = response.NewNoContent(404)
+	} else {
+		res = r.handleDELETE(keyObj)
+	}
+	defer res.Close()
+
+	if res.StatusCode == 200 || res.StatusCode == 204 {
+		return nil
+	}
+
+	return &deleteError{Key: key, Code: response.S3ErrorCode(res.StatusCode), Message: http.StatusText(res.StatusCode)}
+}
@@ -0,0 +1,70 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/aldor007/mort/pkg/config"
+	"github.com/aldor007/mort/pkg/lock"
+	"github.com/aldor007/mort/pkg/middleware"
+	"github.com/aldor007/mort/pkg/object"
+	"github.com/aldor007/mort/pkg/response"
+	"github.com/aldor007/mort/pkg/throttler"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandleBatchDelete(t *testing.T) {
+	mortConfig := config.GetInstance()
+	err := mortConfig.Load("./benchmark/small.yml")
+	assert.Nil(t, err)
+	rp := NewRequestProcessor(mortConfig.Server, lock.NewMemoryLock(), throttler.NewBucketThrottler(10))
+
+	process := func(method, path string, body string) *response.Response {
+		req, _ := http.NewRequest(method, "http://mort"+path, strings.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), middleware.S3AuthCtxKey, true))
+		obj, err := object.NewFileObject(req.URL, mortConfig)
+		assert.Nil(t, err)
+		return rp.Process(req, obj)
+	}
+
+	for _, key := range []string{"/local/batch/a.txt", "/local/batch/b.txt"} {
+		res := process("PUT", key, "content")
+		assert.Equal(t, 200, res.StatusCode)
+	}
+
+	res := process("POST", "/local?delete", `<Delete><Object><Key>batch/a.txt</Key></Object><Object><Key>/batch/b.txt</Key></Object></Delete>`)
+	assert.Equal(t, 200, res.StatusCode)
+	body, _ := res.Body()
+	assert.Contains(t, string(body), "<Deleted><Key>batch/a.txt</Key></Deleted>")
+	assert.Contains(t, string(body), "<Deleted><Key>/batch/b.txt</Key></Deleted>")
+	assert.NotContains(t, string(body), "<Error>")
+
+	assert.Equal(t, 404, process("GET", "/local/batch/a.txt", "").StatusCode)
+	assert.Equal(t, 404, process("GET", "/local/batch/b.txt", "").StatusCode)
+
+	res = process("POST", "/local?delete", `<Delete><Quiet>true</Quiet><Object><Key>batch/a.txt</Key></Object></Delete>`)
+	assert.Equal(t, 200, res.StatusCode)
+	body, _ = res.Body()
+	assert.NotContains(t, string(body), "<Deleted>", "quiet mode should report only errors")
+
+	res = process("POST", "/local?delete", `<Delete></Delete>`)
+	assert.Equal(t, 400, res.StatusCode)
+
+	many := bytes.Buffer{}
+	many.WriteString("<Delete>")
+	for i := 0; i <= maxDeleteObjects; i++ {
+		many.WriteString("<Object><Key>batch/missing.txt</Key></Object>")
+	}
+	many.WriteString("</Delete>")
+	res = process("POST", "/local?delete", many.String())
+	assert.Equal(t, 400, res.StatusCode)
+
+	req, _ := http.NewRequest("POST", "http://mort/local?delete", strings.NewReader(`<Delete><Object><Key>batch/a.txt</Key></Object></Delete>`))
+	obj, err := object.NewFileObject(req.URL, mortConfig)
+	assert.Nil(t, err)
+	res = rp.Process(req, obj)
+	assert.Equal(t, 403, res.StatusCode, "batch delete without signature should be rejected")
+}
@@ -0,0 +1,129 @@
+package processor
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"net/http"
+
+	"github.com/aldor007/mort/pkg/response"
+)
+
+// Headers with checksums of uploads and metadata under which verified checksums are stored with object
+const (
+	headerContentMD5         = "Content-MD5"
+	headerChecksumSHA256     = "X-Amz-Checksum-Sha256"
+	headerMetaContentMD5     = "X-Amz-Meta-Content-Md5"
+	headerMetaChecksumSHA256 = "X-Amz-Meta-Checksum-Sha256"
+)
+
+// errBadDigest is returned by checksumReader at the end of body which doesn't match checksums
+var errBadDigest = errors.New("checksum doesn't match body")
+
+// hasChecksum check if client sent checksum of upload
+func hasChecksum(header http.Header) bool {
+	return header.Get(headerContentMD5) != "" || header.Get(headerChecksumSHA256) != ""
+}
+
+// stripChecksumMeta removes checksum metadata sent by client, only checksums verified by mort are stored with object
+func stripChecksumMeta(header http.Header) {
+	header.Del(headerMetaContentMD5)
+	header.Del(headerMetaChecksumSHA256)
+}
+
+// setChecksumMeta adds checksums sent by client to metadata of object which body was verified by checksumReader
+func setChecksumMeta(header http.Header) {
+	if value := header.Get(headerContentMD5); value != "" {
+		header.Set(headerMetaContentMD5, value)
+	}
+
+	if value := header.Get(headerChecksumSHA256); value != "" {
+		header.Set(headerMetaChecksumSHA256, value)
+	}
+}
+
+// verifyChecksums compare checksums sent by client with body of upload
+// Verified checksums are added to headers, so they are stored as metadata of object
+func verifyChecksums(header http.Header, body []byte) *response.Response {
+	md5Sum := md5.Sum(body)
+	sha256Sum := sha256.Sum256(body)
+	return verifySums(header, md5Sum[:], sha256Sum[:])
+}
+
+// verifySums compare checksums sent by client with sums computed by mort
+func verifySums(header http.Header, md5Sum, sha256Sum []byte) *response.Response {
+	if value := header.Get(headerContentMD5); value != "" {
+		if !checksumEqual(value, md5Sum) {
+			return response.NewError(400, response.NewCodedError("BadDigest", "Content-MD5 doesn't match body"))
+		}
+		header.Set(headerMetaContentMD5, value)
+	}
+
+	if value := header.Get(headerChecksumSHA256); value != "" {
+		if !checksumEqual(value, sha256Sum) {
+			return response.NewError(400, response.NewCodedError("BadDigest", "x-amz-checksum-sha256 doesn't match body"))
+		}
+		header.Set(headerMetaChecksumSHA256, value)
+	}
+
+	return nil
+}
+
+// checksumReader computes checksums of upload while it is read
+// At the end of body which doesn't match checksums it returns errBadDigest instead of io.EOF
+type checksumReader struct {
+	reader   io.Reader
+	header   http.Header
+	md5      hash.Hash
+	sha256   hash.Hash
+	mismatch *response.Response // error response when checksums don't match body
+}
+
+// newChecksumReader wraps body of upload with checksums in header
+func newChecksumReader(header http.Header, body io.Reader) *checksumReader {
+	c := &checksumReader{header: header, md5: md5.New(), sha256: sha256.New()}
+	c.reader = io.TeeReader(body, io.MultiWriter(c.md5, c.sha256))
+	return c
+}
+
+// Read reads body and verifies checksums when whole body was read
+func (c *checksumReader) Read(p []byte) (int, error) {
+	n, err := c.reader.Read(p)
+	if err == io.EOF {
+		if c.mismatch = verifySums(c.header.Clone(), c.md5.Sum(nil), c.sha256.Sum(nil)); c.mismatch != nil {
+			return n, errBadDigest
+		}
+	}
+
+	return n, err
+}
+
+// checksumEqual compare base64 encoded checksum with sum
+func checksumEqual(encoded string, sum []byte) bool {
+	expected, err := base64.StdEncoding.DecodeString(encoded)
+	return err == nil && bytes.Equal(expected, sum)
+}
+
+// checksumETag returns ETag of object from verified Content-MD5, empty string when upload didn't have it
+func checksumETag(header http.Header) string {
+	sum, err := base64.StdEncoding.DecodeString(header.Get(headerMetaContentMD5))
+	if err != nil || len(sum) == 0 {
+		return ""
+	}
+
+	return "\"" + hex.EncodeToString(sum) + "\""
+}
+
+// withChecksumETag set ETag of successful upload with verified Content-MD5
+func withChecksumETag(req *http.Request, res *response.Response) *response.Response {
+	if etag := checksumETag(req.Header); etag != "" && res.StatusCode == 200 {
+		res.Set("ETag", etag)
+	}
+
+	return res
+}
@@ -0,0 +1,103 @@
+package processor
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/aldor007/mort/pkg/config"
+	"github.com/aldor007/mort/pkg/lock"
+	"github.com/aldor007/mort/pkg/object"
+	"github.com/aldor007/mort/pkg/response"
+	"github.com/aldor007/mort/pkg/storage"
+	"github.com/aldor007/mort/pkg/throttler"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifyChecksums(t *testing.T) {
+	body := []byte("hello world")
+	header := http.Header{}
+	header.Set("Content-MD5", "XrY7u+Ae7tCTyyK7j1rNww==")
+	header.Set("x-amz-checksum-sha256", "uU0nuZNNPgilLlLX2n2r+sSE7+N6U4DukIj3rOLvzek=")
+
+	assert.Nil(t, verifyChecksums(header, body))
+	assert.Equal(t, "XrY7u+Ae7tCTyyK7j1rNww==", header.Get(headerMetaContentMD5))
+	assert.Equal(t, "uU0nuZNNPgilLlLX2n2r+sSE7+N6U4DukIj3rOLvzek=", header.Get(headerMetaChecksumSHA256))
+	assert.Equal(t, "\"5eb63bbbe01eeed093cb22bb8f5acdc3\"", checksumETag(header))
+
+	res := withChecksumETag(&http.Request{Header: header}, response.NewNoContent(200))
+	assert.Equal(t, "\"5eb63bbbe01eeed093cb22bb8f5acdc3\"", res.Headers.Get("ETag"))
+}
+
+func TestVerifyChecksumsMismatch(t *testing.T) {
+	header := http.Header{}
+	header.Set("Content-MD5", "XrY7u+Ae7tCTyyK7j1rNww==")
+
+	res := verifyChecksums(header, []byte("hello mort"))
+	assert.NotNil(t, res)
+	assert.Equal(t, 400, res.StatusCode)
+	assert.Equal(t, "", header.Get(headerMetaContentMD5))
+
+	res.FormatError(response.ErrorFormatS3, "req-1", "/key")
+	buf, _ := res.Body()
+	assert.Contains(t, string(buf), "<Code>BadDigest</Code>")
+
+	header = http.Header{}
+	header.Set("x-amz-checksum-sha256", "not base64")
+	res = verifyChecksums(header, []byte("hello world"))
+	assert.NotNil(t, res)
+	assert.Equal(t, 400, res.StatusCode)
+	assert.Equal(t, "", checksumETag(header))
+}
+
+func TestChecksumReader(t *testing.T) {
+	header := http.Header{}
+	header.Set("Content-MD5", "XrY7u+Ae7tCTyyK7j1rNww==")
+	header.Set(headerMetaChecksumSHA256, "sent by client")
+	stripChecksumMeta(header)
+	assert.Equal(t, "", header.Get(headerMetaChecksumSHA256), "checksum metadata of client shouldn't be stored")
+
+	body := newChecksumReader(header, strings.NewReader("hello world"))
+	buf, err := ioutil.ReadAll(body)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello world", string(buf))
+	assert.Nil(t, body.mismatch)
+
+	body = newChecksumReader(header, strings.NewReader("hello mort"))
+	_, err = ioutil.ReadAll(body)
+	assert.Equal(t, errBadDigest, err, "storage should get error at the end of body")
+	assert.NotNil(t, body.mismatch)
+	assert.Equal(t, 400, body.mismatch.StatusCode)
+}
+
+func TestPutVerifiedMismatchKeepsObject(t *testing.T) {
+	mortConfig := config.GetInstance()
+	err := mortConfig.Load("./benchmark/small.yml")
+	assert.Nil(t, err)
+	rp := NewRequestProcessor(mortConfig.Server, lock.NewMemoryLock(), throttler.NewBucketThrottler(10))
+
+	process := func(method string, md5 string, body []byte) *response.Response {
+		req, _ := http.NewRequest(method, "http://mort/local/checksum-test.txt", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "text/plain")
+		if md5 != "" {
+			req.Header.Set("Content-MD5", md5)
+		}
+		obj, err := object.NewFileObject(req.URL, mortConfig)
+		assert.Nil(t, err)
+		return rp.Process(req, obj)
+	}
+	defer process("DELETE", "", nil)
+
+	assert.Equal(t, 200, process("PUT", "XrY7u+Ae7tCTyyK7j1rNww==", []byte("hello world")).StatusCode)
+	assert.Equal(t, 400, process("PUT", "XrY7u+Ae7tCTyyK7j1rNww==", []byte("hello mort")).StatusCode)
+
+	obj, err := object.NewFileObjectFromPath("/local/checksum-test.txt", mortConfig)
+	assert.Nil(t, err)
+	res := storage.Get(obj)
+	assert.Equal(t, 200, res.StatusCode, "previous version should be kept")
+	buf, err := res.Body()
+	assert.Nil(t, err)
+	assert.Equal(t, "hello world", string(buf))
+}
@@ -0,0 +1,36 @@
+package processor
+
+import (
+	"context"
+	"time"
+)
+
+// detachedContext keeps values of parent context, but it isn't canceled with parent
+type detachedContext struct {
+	parent context.Context
+}
+
+// detachContext returns context with values of parent which isn't canceled when parent is canceled
+func detachContext(parent context.Context) context.Context {
+	return detachedContext{parent: parent}
+}
+
+// Deadline returns no deadline, deadline of parent is ignored
+func (d detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+// Done returns nil channel, so context is never canceled
+func (d detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+// Err returns nil, context is never canceled
+func (d detachedContext) Err() error {
+	return nil
+}
+
+// Value returns value of parent context
+func (d detachedContext) Value(key interface{}) interface{} {
+	return d.parent.Value(key)
+}
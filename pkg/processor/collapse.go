@@ -0,0 +1,83 @@
+package processor
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/aldor007/mort/pkg/middleware"
+	"github.com/aldor007/mort/pkg/object"
+	"github.com/aldor007/mort/pkg/response"
+)
+
+// maxSizeHints max number of remembered sizes of original objects
+const maxSizeHints = 10000
+
+// sizeHints remember size of original objects, so it is known before they are fetched again
+type sizeHints struct {
+	lock  sync.RWMutex
+	sizes map[string]int64
+}
+
+func newSizeHints() *sizeHints {
+	return &sizeHints{sizes: make(map[string]int64)}
+}
+
+// observe store size of object from successful response
+func (h *sizeHints) observe(key string, res *response.Response) {
+	if res.StatusCode != 200 || res.ContentLength < 0 {
+		return
+	}
+
+	h.lock.Lock()
+	if len(h.sizes) >= maxSizeHints {
+		// hot objects are remembered again on next request
+		h.sizes = make(map[string]int64)
+	}
+	h.sizes[key] = res.ContentLength
+	h.lock.Unlock()
+}
+
+// size returns last known size of object
+func (h *sizeHints) size(key string) (int64, bool) {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	size, ok := h.sizes[key]
+	return size, ok
+}
+
+// collapseKey returns key under which concurrent requests for obj are collapsed
+// Responses which differ by Vary (e.g. pre-compressed variants) are never shared
+func collapseKey(obj *object.FileObject) string {
+	key := obj.Key
+	if !obj.HasTransform() {
+		key = obj.Bucket + obj.Key + obj.Range
+	}
+
+	if obj.Vary != "" {
+		key += "#" + obj.Vary
+	}
+
+	return key
+}
+
+// collapseOriginal check if request for original object should be collapsed
+// Size of object is known only after it was fetched once, so first request is never collapsed
+func (r *RequestProcessor) collapseOriginal(obj *object.FileObject) bool {
+	cfg := r.serverConfig.Collapse
+	if !cfg.Originals || obj.Ctx.Value(middleware.S3AuthCtxKey) != nil {
+		// responses for S3 authorized requests differ from public ones
+		return false
+	}
+
+	if strings.HasPrefix(obj.Storage.Kind, "local") {
+		// local files are send to client directly with sendfile
+		return false
+	}
+
+	if cfg.MinSize <= 0 {
+		return true
+	}
+
+	size, ok := r.sizeHints.size(collapseKey(obj))
+	return ok && size >= cfg.MinSize
+}
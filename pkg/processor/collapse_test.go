@@ -0,0 +1,60 @@
+package processor
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aldor007/mort/pkg/config"
+	"github.com/aldor007/mort/pkg/middleware"
+	"github.com/aldor007/mort/pkg/object"
+	"github.com/aldor007/mort/pkg/response"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSizeHints(t *testing.T) {
+	hints := newSizeHints()
+
+	hints.observe("bucket/file", response.NewString(200, "content"))
+	hints.observe("bucket/missing", response.NewString(404, "not found"))
+
+	size, ok := hints.size("bucket/file")
+	assert.True(t, ok)
+	assert.Equal(t, int64(7), size)
+
+	_, ok = hints.size("bucket/missing")
+	assert.False(t, ok)
+}
+
+func TestCollapseKey(t *testing.T) {
+	obj := &object.FileObject{Bucket: "bucket", Key: "/file.jpg", Ctx: context.Background()}
+	plain := collapseKey(obj)
+
+	obj.Vary = "br"
+	assert.NotEqual(t, plain, collapseKey(obj), "variants should be collapsed separately")
+
+	obj.Transforms.Resize(100, 100, false, false, false)
+	transformed := collapseKey(obj)
+	obj.Vary = ""
+	assert.NotEqual(t, transformed, collapseKey(obj), "variants of transformed object should be collapsed separately")
+}
+
+func TestCollapseOriginal(t *testing.T) {
+	rp := RequestProcessor{sizeHints: newSizeHints()}
+	obj := &object.FileObject{Bucket: "bucket", Key: "/file.jpg", Ctx: context.Background()}
+
+	assert.False(t, rp.collapseOriginal(obj))
+
+	rp.serverConfig.Collapse = config.CollapseCfg{Originals: true, MinSize: 5}
+	// size is unknown before first request
+	assert.False(t, rp.collapseOriginal(obj))
+
+	rp.sizeHints.observe(collapseKey(obj), response.NewString(200, "content"))
+	assert.True(t, rp.collapseOriginal(obj))
+
+	obj.Storage.Kind = "local-meta"
+	assert.False(t, rp.collapseOriginal(obj))
+
+	obj.Storage.Kind = "s3"
+	obj.Ctx = context.WithValue(context.Background(), middleware.S3AuthCtxKey, true)
+	assert.False(t, rp.collapseOriginal(obj))
+}
@@ -0,0 +1,35 @@
+package processor
+
+import (
+	"time"
+
+	"github.com/aldor007/mort/pkg/engine"
+	"github.com/aldor007/mort/pkg/monitoring"
+	"github.com/aldor007/mort/pkg/object"
+	"github.com/aldor007/mort/pkg/response"
+	"github.com/aldor007/mort/pkg/transforms"
+)
+
+// reportCost report resources used by transform of object, pixels are counted once for source image even when it is processed in many passes
+func reportCost(obj *object.FileObject, engineName string, source []byte, mergedTrans []transforms.Transforms, elapsed time.Duration, res *response.Response) {
+	record := monitoring.CostRecord{
+		Time:      time.Now(),
+		RequestID: obj.RequestID,
+		Bucket:    obj.Bucket,
+		Key:       obj.Key,
+		Preset:    driftPreset(obj),
+		Engine:    engineName,
+		EngineMs:  float64(elapsed) / float64(time.Millisecond),
+		BytesOut:  res.ContentLength,
+	}
+
+	if width, height, err := engine.ImageSize(source); err == nil {
+		record.Pixels = int64(width) * int64(height)
+	}
+
+	for i := range mergedTrans {
+		record.Operations = append(record.Operations, mergedTrans[i].Operations()...)
+	}
+
+	monitoring.ReportCost(record)
+}
@@ -0,0 +1,88 @@
+package processor
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/aldor007/mort/pkg/config"
+	"github.com/aldor007/mort/pkg/middleware"
+	"github.com/aldor007/mort/pkg/monitoring"
+	"github.com/aldor007/mort/pkg/object"
+	"github.com/aldor007/mort/pkg/response"
+	"github.com/aldor007/mort/pkg/storage"
+	"go.uber.org/zap"
+)
+
+// ErrorFormat returns format of body of error responses for request
+// Requests of S3 API and form uploads get S3 XML errors, clients accepting JSON and buckets with errorFormat json get JSON errors
+func ErrorFormat(req *http.Request, obj *object.FileObject) string {
+	if req.Context().Value(middleware.S3AuthCtxKey) != nil || isFormUpload(req) {
+		return response.ErrorFormatS3
+	}
+
+	if strings.Contains(req.Header.Get("Accept"), "application/json") {
+		return response.ErrorFormatJSON
+	}
+
+	if obj != nil {
+		if bucket, ok := config.GetInstance().Buckets[obj.Bucket]; ok && bucket.ErrorFormat == response.ErrorFormatJSON {
+			return response.ErrorFormatJSON
+		}
+	}
+
+	return response.ErrorFormatNone
+}
+
+// errorDocument replace body of error response with error document of bucket configured for its status code
+// Documents are used only for requests of originals without other error format, responses with image body (e.g. placeholder) are left untouched
+func (r *RequestProcessor) errorDocument(req *http.Request, obj *object.FileObject, res *response.Response) *response.Response {
+	if res.StatusCode < 400 || obj.Debug || obj.HasTransform() || res.IsImage() || ErrorFormat(req, obj) != response.ErrorFormatNone {
+		return res
+	}
+
+	bucket, ok := config.GetInstance().Buckets[obj.Bucket]
+	if !ok {
+		return res
+	}
+
+	key, ok := bucket.ErrorDocuments[res.StatusCode]
+	if !ok && bucket.Website != nil && bucket.Website.ErrorDocument != "" && res.StatusCode < 500 {
+		// like S3 website, error document is served for client errors
+		key, ok = bucket.Website.ErrorDocument, true
+	}
+	if !ok {
+		return res
+	}
+
+	doc, err := object.NewFileObject(&url.URL{Path: "/" + obj.Bucket + key}, config.GetInstance())
+	if err != nil || doc.HasTransform() {
+		monitoring.Log().Warn("Processor/errorDocument invalid error document", obj.LogData(zap.String("document", key), zap.Error(err))...)
+		return res
+	}
+	doc.Ctx = obj.Ctx
+
+	docRes := r.withStorageTimeout(doc, func() *response.Response {
+		return storage.Get(doc)
+	})
+	if docRes.StatusCode != 200 {
+		monitoring.Log().Warn("Processor/errorDocument unable to get error document", obj.LogData(zap.String("document", key), zap.Int("sc", docRes.StatusCode))...)
+		docRes.Close()
+		return res
+	}
+
+	monitoring.Report().Inc("error_document_count;bucket:" + obj.Bucket + ",status:" + strconv.Itoa(res.StatusCode))
+	docRes.StatusCode = res.StatusCode
+	// document is body of error, so it can't be validated like object
+	docRes.Headers.Del("ETag")
+	docRes.Headers.Del("Last-Modified")
+	for name, values := range res.Headers {
+		if name != "Content-Type" && name != "Content-Length" && docRes.Headers.Get(name) == "" {
+			docRes.Headers[name] = values
+		}
+	}
+	res.Close()
+
+	return docRes
+}
@@ -0,0 +1,54 @@
+package processor
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/aldor007/mort/pkg/config"
+	"github.com/aldor007/mort/pkg/lock"
+	"github.com/aldor007/mort/pkg/object"
+	"github.com/aldor007/mort/pkg/response"
+	"github.com/aldor007/mort/pkg/throttler"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorDocument(t *testing.T) {
+	mortConfig := config.GetInstance()
+	err := mortConfig.Load("./benchmark/small.yml")
+	assert.Nil(t, err)
+
+	bucket := mortConfig.Buckets["local"]
+	bucket.ErrorDocuments = map[int]string{404: "/file.txt", 500: "/missing.html"}
+	mortConfig.Buckets["local"] = bucket
+	defer func() {
+		bucket.ErrorDocuments = nil
+		mortConfig.Buckets["local"] = bucket
+	}()
+
+	rp := NewRequestProcessor(mortConfig.Server, lock.NewMemoryLock(), throttler.NewBucketThrottler(10))
+	req, _ := http.NewRequest("GET", "http://mort/local/not-existing.txt", nil)
+	obj, err := object.NewFileObject(req.URL, mortConfig)
+	assert.Nil(t, err)
+
+	errRes := response.NewError(404, errors.New("not found"))
+	errRes.Set("Cache-Control", "max-age=60, public")
+	res := rp.errorDocument(req, obj, errRes)
+	assert.Equal(t, 404, res.StatusCode)
+	assert.Contains(t, res.Headers.Get("Content-Type"), "text/plain")
+	assert.Equal(t, "max-age=60, public", res.Headers.Get("Cache-Control"))
+	assert.Equal(t, "", res.Headers.Get("ETag"))
+	buf, err := res.Body()
+	assert.Nil(t, err)
+	assert.NotEmpty(t, buf)
+
+	errRes = response.NewError(500, errors.New("error"))
+	assert.Equal(t, errRes, rp.errorDocument(req, obj, errRes), "response should be untouched when document doesn't exist")
+
+	errRes = response.NewError(403, errors.New("forbidden"))
+	assert.Equal(t, errRes, rp.errorDocument(req, obj, errRes), "response should be untouched without document for status")
+
+	req.Header.Set("Accept", "application/json")
+	errRes = response.NewError(404, errors.New("not found"))
+	assert.Equal(t, errRes, rp.errorDocument(req, obj, errRes), "JSON errors should be preferred")
+}
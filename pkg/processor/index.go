@@ -0,0 +1,179 @@
+package processor
+
+import (
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aldor007/mort/pkg/config"
+	"github.com/aldor007/mort/pkg/middleware"
+	"github.com/aldor007/mort/pkg/monitoring"
+	"github.com/aldor007/mort/pkg/object"
+	"github.com/aldor007/mort/pkg/response"
+	"github.com/aldor007/mort/pkg/storage"
+	"go.uber.org/zap"
+)
+
+// autoindexTemplate renders listing of directory in html format
+var autoindexTemplate = template.Must(template.New("autoindex").Funcs(template.FuncMap{"escape": url.PathEscape}).Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Index of {{ .Path }}</title></head>
+<body>
+<h1>Index of {{ .Path }}</h1>
+<table>
+<tr><th>Name</th><th>Last modified</th><th>Size</th></tr>
+{{- if ne .Path "/" }}
+<tr><td><a href="../">../</a></td><td></td><td></td></tr>
+{{- end }}
+{{- range .Directories }}
+<tr><td><a href="{{ escape . }}/">{{ . }}/</a></td><td></td><td>-</td></tr>
+{{- end }}
+{{- range .Objects }}
+<tr><td><a href="{{ escape .Name }}">{{ .Name }}</a></td><td>{{ .LastModified.Format "2006-01-02 15:04:05" }}</td><td>{{ .Size }}</td></tr>
+{{- end }}
+</table>
+</body>
+</html>
+`))
+
+// autoindexObject single object in listing of directory
+type autoindexObject struct {
+	Name         string    `json:"name"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"lastModified"`
+}
+
+// autoindexListing listing of directory returned by autoindex
+type autoindexListing struct {
+	Path        string            `json:"path"`
+	Directories []string          `json:"directories"`
+	Objects     []autoindexObject `json:"objects"`
+}
+
+// indexConfig returns index configuration of bucket of object, nil for transforms and buckets without index
+// Website of bucket provides index configuration, when bucket doesn't have own
+func indexConfig(obj *object.FileObject) *config.IndexCfg {
+	bucket, ok := config.GetInstance().Buckets[obj.Bucket]
+	if !ok || obj.HasTransform() {
+		return nil
+	}
+
+	if bucket.Index == nil && bucket.Website != nil {
+		return bucket.Website.Index()
+	}
+
+	return bucket.Index
+}
+
+// findIndexDocument returns first index document which exists in directory dir, dir is key of object with optional trailing /
+func (r *RequestProcessor) findIndexDocument(obj *object.FileObject, index *config.IndexCfg, dir string) (string, *response.Response) {
+	for _, document := range index.Documents {
+		doc := obj.Copy()
+		doc.Ctx = obj.Ctx
+		doc.UpdateKey(strings.TrimPrefix(dir, obj.Key) + document)
+		res := r.withStorageTimeout(doc, func() *response.Response {
+			return storage.Head(doc)
+		})
+		res.Close()
+		if res.StatusCode == 200 {
+			return document, nil
+		}
+
+		if isTimeout(res) {
+			return "", res
+		}
+	}
+
+	return "", nil
+}
+
+// resolveIndex handles request of directory (key ending with /)
+// Key of object is changed to first existing index document and nil is returned, so document is served like any other object
+// Without index document listing of directory is returned when autoindex is enabled
+func (r *RequestProcessor) resolveIndex(obj *object.FileObject, index *config.IndexCfg) *response.Response {
+	document, errRes := r.findIndexDocument(obj, index, obj.Key)
+	if errRes != nil {
+		return errRes
+	}
+
+	if document != "" {
+		monitoring.Report().Inc("index_count;bucket:" + obj.Bucket + ",result:document")
+		obj.UpdateKey(document)
+		return nil
+	}
+
+	if index.Autoindex == "" {
+		return nil
+	}
+
+	files, dirs, err := storage.ListDir(obj, obj.Key, index.MaxKeys)
+	if err != nil {
+		return r.replyWithError(obj, 500, err)
+	}
+
+	if len(files) == 0 && len(dirs) == 0 && obj.Key != "/" {
+		// directory doesn't exist, request ends with 404 of storage
+		return nil
+	}
+
+	monitoring.Report().Inc("index_count;bucket:" + obj.Bucket + ",result:autoindex")
+	listing := autoindexListing{Path: obj.Key, Directories: dirs, Objects: make([]autoindexObject, 0, len(files))}
+	if listing.Directories == nil {
+		listing.Directories = []string{}
+	}
+	for _, file := range files {
+		listing.Objects = append(listing.Objects, autoindexObject{Name: file.Key[strings.LastIndex(file.Key, "/")+1:], Size: file.Size, LastModified: file.LastModified})
+	}
+
+	if index.Autoindex == "json" {
+		buf, err := json.Marshal(listing)
+		if err != nil {
+			return r.replyWithError(obj, 500, err)
+		}
+
+		res := response.NewBuf(200, buf)
+		res.SetContentType("application/json")
+		return res
+	}
+
+	var buf bytes.Buffer
+	if err := autoindexTemplate.Execute(&buf, listing); err != nil {
+		monitoring.Log().Error("Processor/resolveIndex unable to render autoindex", obj.LogData(zap.Error(err))...)
+		return r.replyWithError(obj, 500, err)
+	}
+
+	res := response.NewBuf(200, buf.Bytes())
+	res.SetContentType("text/html; charset=utf-8")
+	return res
+}
+
+// directoryRedirect returns redirect to directory (with trailing /) for request of missing object which is directory
+// with index document or autoindex, like web servers do, so relative links of index document work
+func (r *RequestProcessor) directoryRedirect(req *http.Request, obj *object.FileObject, index *config.IndexCfg) *response.Response {
+	dir := obj.Key + "/"
+	isDir := false
+	if document, _ := r.findIndexDocument(obj, index, dir); document != "" {
+		isDir = true
+	} else if index.Autoindex != "" {
+		files, dirs, err := storage.ListDir(obj, dir, index.MaxKeys)
+		isDir = err == nil && (len(files) != 0 || len(dirs) != 0)
+	}
+
+	if !isDir {
+		return nil
+	}
+
+	location := middleware.BucketPath(req, obj.Bucket) + obj.Key + "/"
+	if req.URL.RawQuery != "" {
+		location += "?" + req.URL.RawQuery
+	}
+
+	monitoring.Report().Inc("index_count;bucket:" + obj.Bucket + ",result:redirect")
+	res := response.NewNoContent(index.RedirectCode)
+	res.Set("Location", location)
+	return res
+}
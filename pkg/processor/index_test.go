@@ -0,0 +1,69 @@
+package processor
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/aldor007/mort/pkg/config"
+	"github.com/aldor007/mort/pkg/lock"
+	"github.com/aldor007/mort/pkg/object"
+	"github.com/aldor007/mort/pkg/throttler"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveIndex(t *testing.T) {
+	mortConfig := config.GetInstance()
+	err := mortConfig.Load("./benchmark/small.yml")
+	assert.Nil(t, err)
+
+	bucket := mortConfig.Buckets["local"]
+	bucket.Index = &config.IndexCfg{Documents: []string{"index.html", "file.txt"}, MaxKeys: 1000}
+	mortConfig.Buckets["local"] = bucket
+	defer func() {
+		bucket.Index = nil
+		mortConfig.Buckets["local"] = bucket
+	}()
+
+	rp := NewRequestProcessor(mortConfig.Server, lock.NewMemoryLock(), throttler.NewBucketThrottler(10))
+	req, _ := http.NewRequest("GET", "http://mort/local/", nil)
+	obj, err := object.NewFileObject(req.URL, mortConfig)
+	assert.Nil(t, err)
+
+	index := indexConfig(obj)
+	assert.NotNil(t, index)
+	assert.Nil(t, rp.resolveIndex(obj, index))
+	assert.Equal(t, "/file.txt", obj.Key, "first existing index document should be served")
+
+	bucket.Index.Documents = nil
+	bucket.Index.Autoindex = "json"
+	obj, err = object.NewFileObject(req.URL, mortConfig)
+	assert.Nil(t, err)
+	res := rp.resolveIndex(obj, index)
+	assert.NotNil(t, res)
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Equal(t, "application/json", res.Headers.Get("Content-Type"))
+
+	buf, err := res.Body()
+	assert.Nil(t, err)
+	var listing autoindexListing
+	assert.Nil(t, json.Unmarshal(buf, &listing))
+	assert.Equal(t, "/", listing.Path)
+	names := make([]string, 0, len(listing.Objects))
+	for _, o := range listing.Objects {
+		names = append(names, o.Name)
+	}
+	assert.Contains(t, names, "small.jpg")
+
+	bucket.Index.Autoindex = "html"
+	res = rp.resolveIndex(obj, index)
+	assert.NotNil(t, res)
+	assert.Equal(t, "text/html; charset=utf-8", res.Headers.Get("Content-Type"))
+	buf, _ = res.Body()
+	assert.Contains(t, string(buf), `<a href="small.jpg">small.jpg</a>`)
+
+	req, _ = http.NewRequest("GET", "http://mort/local/small.jpg-small", nil)
+	obj, err = object.NewFileObject(req.URL, mortConfig)
+	assert.Nil(t, err)
+	assert.Nil(t, indexConfig(obj), "transforms should not be indexed")
+}
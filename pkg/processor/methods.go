@@ -0,0 +1,52 @@
+package processor
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/aldor007/mort/pkg/config"
+	"github.com/aldor007/mort/pkg/object"
+	"github.com/aldor007/mort/pkg/response"
+)
+
+// HeaderAllow header with list of methods allowed for object
+const HeaderAllow = "Allow"
+
+var errMethodNotAllowed = errors.New("method not allowed") // error when method isn't allowed in bucket
+
+// allowedMethods returns methods allowed in bucket of object, OPTIONS is always allowed
+func allowedMethods(obj *object.FileObject) []string {
+	methods := config.SupportedMethods
+	if bucket, ok := config.GetInstance().Buckets[obj.Bucket]; ok && len(bucket.Methods) != 0 {
+		methods = bucket.Methods
+	}
+
+	allowed := make([]string, 0, len(methods)+1)
+	allowed = append(allowed, methods...)
+	return append(allowed, "OPTIONS")
+}
+
+// isMethodAllowed check if method is allowed in bucket of object
+func isMethodAllowed(obj *object.FileObject, method string) bool {
+	for _, m := range allowedMethods(obj) {
+		if m == method {
+			return true
+		}
+	}
+
+	return false
+}
+
+// handleOPTIONS returns methods allowed for object
+func handleOPTIONS(obj *object.FileObject) *response.Response {
+	res := response.NewNoContent(200)
+	res.Set(HeaderAllow, strings.Join(allowedMethods(obj), ", "))
+	return res
+}
+
+// methodNotAllowed returns 405 response with methods allowed for object
+func methodNotAllowed(obj *object.FileObject) *response.Response {
+	res := response.NewError(405, errMethodNotAllowed)
+	res.Set(HeaderAllow, strings.Join(allowedMethods(obj), ", "))
+	return res
+}
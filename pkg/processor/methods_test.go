@@ -0,0 +1,49 @@
+package processor
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/aldor007/mort/pkg/config"
+	"github.com/aldor007/mort/pkg/lock"
+	"github.com/aldor007/mort/pkg/object"
+	"github.com/aldor007/mort/pkg/throttler"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBucketMethods(t *testing.T) {
+	mortConfig := config.GetInstance()
+	err := mortConfig.Load("./benchmark/small.yml")
+	assert.Nil(t, err)
+
+	bucket := mortConfig.Buckets["local"]
+	bucket.Methods = []string{"GET", "HEAD"}
+	mortConfig.Buckets["local"] = bucket
+	defer func() {
+		bucket.Methods = nil
+		mortConfig.Buckets["local"] = bucket
+	}()
+
+	rp := NewRequestProcessor(mortConfig.Server, lock.NewMemoryLock(), throttler.NewBucketThrottler(10))
+
+	req, _ := http.NewRequest("OPTIONS", "http://mort/local/small.jpg", nil)
+	obj, err := object.NewFileObject(req.URL, mortConfig)
+	assert.Nil(t, err)
+	res := rp.Process(req, obj)
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Equal(t, "GET, HEAD, OPTIONS", res.Headers.Get(HeaderAllow))
+
+	req, _ = http.NewRequest("DELETE", "http://mort/local/small.jpg", nil)
+	obj, err = object.NewFileObject(req.URL, mortConfig)
+	assert.Nil(t, err)
+	res = rp.Process(req, obj)
+	assert.Equal(t, 405, res.StatusCode)
+	assert.Equal(t, "GET, HEAD, OPTIONS", res.Headers.Get(HeaderAllow))
+}
+
+func TestAllowedMethodsDefault(t *testing.T) {
+	obj := &object.FileObject{Bucket: "unknown"}
+	assert.Equal(t, []string{"GET", "HEAD", "PUT", "POST", "DELETE", "OPTIONS"}, allowedMethods(obj))
+	assert.True(t, isMethodAllowed(obj, "PUT"))
+	assert.False(t, isMethodAllowed(obj, "PATCH"))
+}
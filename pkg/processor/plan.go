@@ -0,0 +1,86 @@
+package processor
+
+import (
+	"encoding/json"
+
+	"github.com/aldor007/mort/pkg/object"
+	"github.com/aldor007/mort/pkg/response"
+	"github.com/aldor007/mort/pkg/transforms"
+)
+
+// planObject describe how object was parsed from request
+type planObject struct {
+	Path          string                 `json:"path"`
+	Bucket        string                 `json:"bucket"`
+	Key           string                 `json:"key"`
+	Preset        string                 `json:"preset,omitempty"`
+	Storage       string                 `json:"storage"`
+	StorageBucket string                 `json:"storageBucket,omitempty"`
+	PathPrefix    string                 `json:"pathPrefix,omitempty"`
+	Transforms    map[string]interface{} `json:"transforms,omitempty"`
+	Parent        *planObject            `json:"parent,omitempty"`
+}
+
+// plan is response for debug=plan requests
+type plan struct {
+	Object           planObject               `json:"object"`
+	MergedTransforms []map[string]interface{} `json:"mergedTransforms"`
+	CheckParent      bool                     `json:"checkParent"`
+	CacheKey         string                   `json:"cacheKey"`
+}
+
+// newPlanObject describe object, location in storage is shown only to admins
+func newPlanObject(obj *object.FileObject, admin bool) *planObject {
+	p := &planObject{
+		Bucket:  obj.Bucket,
+		Key:     obj.Key,
+		Preset:  obj.Preset,
+		Storage: obj.Storage.Kind,
+	}
+
+	if admin {
+		p.StorageBucket = obj.Storage.Bucket
+		p.PathPrefix = obj.Storage.PathPrefix
+	}
+
+	if obj.Uri != nil {
+		p.Path = obj.Uri.Path
+	}
+
+	if obj.HasTransform() {
+		p.Transforms = obj.Transforms.Describe()
+	}
+
+	if obj.HasParent() {
+		p.Parent = newPlanObject(obj.Parent, admin)
+	}
+
+	return p
+}
+
+// debugPlan returns description of transforms that will be performed for object without executing them
+// It is called after access checks, so it doesn't describe objects which client can't get
+func debugPlan(obj *object.FileObject) *response.Response {
+	p := plan{Object: *newPlanObject(obj, obj.DebugAdmin), CheckParent: obj.CheckParent, CacheKey: obj.GetResponseCacheKey()}
+
+	var transformsTab []transforms.Transforms
+	for currObj := obj; currObj.HasParent(); currObj = currObj.Parent {
+		if currObj.HasTransform() {
+			transformsTab = append(transformsTab, currObj.Transforms)
+		}
+	}
+
+	p.MergedTransforms = make([]map[string]interface{}, 0, len(transformsTab))
+	for _, t := range transforms.Merge(transformsTab) {
+		p.MergedTransforms = append(p.MergedTransforms, t.Describe())
+	}
+
+	buf, err := json.Marshal(p)
+	if err != nil {
+		return response.NewError(500, err)
+	}
+
+	res := response.NewBuf(200, buf)
+	res.SetContentType("application/json")
+	return res
+}
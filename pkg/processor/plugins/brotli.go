@@ -0,0 +1,15 @@
+//go:build cgo
+// +build cgo
+
+package plugins
+
+import (
+	"io"
+
+	brEnc "github.com/google/brotli/go/cbrotli"
+)
+
+// newBrotliWriter create writer which compress data with brotli of given quality
+var newBrotliWriter = func(w io.Writer, level int) io.WriteCloser {
+	return brEnc.NewWriter(w, brEnc.WriterOptions{Quality: level})
+}
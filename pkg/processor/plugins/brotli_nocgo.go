@@ -0,0 +1,9 @@
+//go:build !cgo
+// +build !cgo
+
+package plugins
+
+import "io"
+
+// newBrotliWriter is nil because brotli encoder requires cgo, responses are compressed only with gzip
+var newBrotliWriter func(w io.Writer, level int) io.WriteCloser
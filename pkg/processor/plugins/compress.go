@@ -5,7 +5,6 @@ import (
 	"github.com/aldor007/mort/pkg/helpers"
 	"github.com/aldor007/mort/pkg/object"
 	"github.com/aldor007/mort/pkg/response"
-	brEnc "github.com/google/brotli/go/cbrotli"
 	"io"
 	"net/http"
 	"strings"
@@ -15,13 +14,21 @@ func init() {
 	RegisterPlugin("compress", &CompressPlugin{})
 }
 
+// defaultCompressTypes content types compressed when types are not configured
+var defaultCompressTypes = []string{"text/*", "application/json", "application/javascript", "application/xml", "image/svg+xml"}
+
+// defaultMinSize smallest body which is compressed
+const defaultMinSize = 1000
+
 type compressConfig struct {
 	level   int
-	types   []string
+	types   []string // content types, text/* matches all text types
+	minSize int64
 	enabled bool
 }
 
-// CompressPlugin plugins that transform image to webp if web browser can handle that format
+// CompressPlugin encodes responses of compressible types with brotli or gzip, when client accepts them
+// Images (except svg) are never compressed, as their formats are compressed already
 type CompressPlugin struct {
 	brotli compressConfig
 	gzip   compressConfig
@@ -33,10 +40,10 @@ func parseConfig(cType *compressConfig, cfg interface{}) {
 	if types, ok := cfgKeys["types"]; ok {
 		typesArr := types.([]interface{})
 		for _, t := range typesArr {
-			cType.types = append(cType.types, t.(string))
+			cType.types = append(cType.types, strings.ToLower(t.(string)))
 		}
 	} else {
-		cType.types = []string{"text/html"}
+		cType.types = defaultCompressTypes
 	}
 
 	if cLevel, ok := cfgKeys["level"]; ok {
@@ -45,6 +52,12 @@ func parseConfig(cType *compressConfig, cfg interface{}) {
 		cType.level = 4
 	}
 
+	if minSize, ok := cfgKeys["minSize"]; ok {
+		cType.minSize = int64(minSize.(int))
+	} else {
+		cType.minSize = defaultMinSize
+	}
+
 	cType.enabled = true
 }
 
@@ -65,45 +78,76 @@ func (CompressPlugin) preProcess(obj *object.FileObject, req *http.Request) {
 
 }
 
-// PostProcess update vary header
+// matches check if content type of response is compressed by encoding
+func (c compressConfig) matches(contentType string) bool {
+	if !c.enabled {
+		return false
+	}
+
+	if strings.HasPrefix(contentType, "image/") && contentType != "image/svg+xml" {
+		return false
+	}
+
+	for _, supportedType := range c.types {
+		if supportedType == contentType || (strings.HasSuffix(supportedType, "/*") && strings.HasPrefix(contentType, supportedType[:len(supportedType)-1])) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// encodedETag returns ETag of encoded body, so caches and conditional requests don't mix encoded and identity bodies
+func encodedETag(etag, encoding string) string {
+	if strings.HasSuffix(etag, `"`) {
+		return etag[:len(etag)-1] + "-" + encoding + `"`
+	}
+
+	return etag + "-" + encoding
+}
+
+// PostProcess compress body of response and update Vary, Content-Encoding and ETag headers
 func (c CompressPlugin) postProcess(obj *object.FileObject, req *http.Request, res *response.Response) {
-	acceptEnc := req.Header.Get("Accept-Encoding")
-	contentType := res.Headers.Get("Content-Type")
-	if acceptEnc == "" || contentType == "" || helpers.IsRangeOrCondition(req) || (res.ContentLength < 1000 && res.ContentLength != -1) {
+	contentType := strings.ToLower(strings.TrimSpace(strings.Split(res.Headers.Get("Content-Type"), ";")[0]))
+	brotli := newBrotliWriter != nil && c.brotli.matches(contentType)
+	gzipped := c.gzip.matches(contentType)
+	if contentType == "" || (!brotli && !gzipped) || res.Headers.Get("Content-Encoding") != "" || res.StatusCode != 200 {
 		return
 	}
 
-	if c.brotli.enabled && strings.Contains(acceptEnc, "br") {
-		for _, supportedType := range c.brotli.types {
-			if contentType == supportedType {
-				res.Headers.Set("Content-Encoding", "br")
-				res.Headers.Add("Vary", "Accept-Encoding")
-				res.BodyTransformer(func(w io.Writer) io.WriteCloser {
-					br := brEnc.NewWriter(w, brEnc.WriterOptions{Quality: c.brotli.level})
-					return br
-				})
-				return
-			}
-		}
+	// response depends on Accept-Encoding even if this client doesn't accept any encoding
+	res.Headers.Add("Vary", "Accept-Encoding")
 
+	acceptEnc := req.Header.Get("Accept-Encoding")
+	if acceptEnc == "" || req.Header.Get("Range") != "" || req.Header.Get("If-Range") != "" {
+		return
 	}
 
-	if c.gzip.enabled && strings.Contains(acceptEnc, "gzip") {
-		for _, supportedType := range c.gzip.types {
-			if contentType == supportedType {
-				res.Headers.Set("Content-Encoding", "gzip")
-				res.Headers.Add("Vary", "Accept-Encoding")
-				res.BodyTransformer(func(w io.Writer) io.WriteCloser {
-					gzipW, err := gzip.NewWriterLevel(w, c.gzip.level)
-					if err != nil {
-						panic(err)
-					}
-
-					return gzipW
-				})
-				return
+	if brotli && helpers.AcceptsEncoding(acceptEnc, "br") && (res.ContentLength >= c.brotli.minSize || res.ContentLength == -1) {
+		setEncoding(res, "br")
+		res.BodyTransformer(func(w io.Writer) io.WriteCloser {
+			return newBrotliWriter(w, c.brotli.level)
+		})
+		return
+	}
+
+	if gzipped && helpers.AcceptsEncoding(acceptEnc, "gzip") && (res.ContentLength >= c.gzip.minSize || res.ContentLength == -1) {
+		setEncoding(res, "gzip")
+		res.BodyTransformer(func(w io.Writer) io.WriteCloser {
+			gzipW, err := gzip.NewWriterLevel(w, c.gzip.level)
+			if err != nil {
+				panic(err)
 			}
-		}
+
+			return gzipW
+		})
 	}
+}
 
+func setEncoding(res *response.Response, encoding string) {
+	res.Headers.Set("Content-Encoding", encoding)
+	res.Headers.Del("Content-Length")
+	if etag := res.Headers.Get("ETag"); etag != "" {
+		res.Headers.Set("ETag", encodedETag(etag, encoding))
+	}
 }
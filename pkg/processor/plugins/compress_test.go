@@ -1,3 +1,6 @@
+//go:build cgo
+// +build cgo
+
 package plugins
 
 import (
@@ -51,7 +54,9 @@ func TestCompressNoContent(t *testing.T) {
 
 	c.postProcess(nil, req, res)
 
-	assert.Equal(t, len(res.Headers), 1)
+	assert.Equal(t, len(res.Headers), 2)
+	assert.Equal(t, res.Headers.Get("Vary"), "Accept-Encoding")
+	assert.Equal(t, res.Headers.Get("Content-Encoding"), "")
 }
 
 func TestCompressTooSmallContent(t *testing.T) {
@@ -71,7 +76,9 @@ func TestCompressTooSmallContent(t *testing.T) {
 
 	c.postProcess(nil, req, res)
 
-	assert.Equal(t, len(res.Headers), 1)
+	assert.Equal(t, len(res.Headers), 2)
+	assert.Equal(t, res.Headers.Get("Vary"), "Accept-Encoding")
+	assert.Equal(t, res.Headers.Get("Content-Encoding"), "")
 }
 
 func TestCompressGzipImage(t *testing.T) {
@@ -213,7 +220,9 @@ func TestNotCompressOnRange(t *testing.T) {
 
 	c.postProcess(nil, req, res)
 
-	assert.Equal(t, len(res.Headers), 1)
+	assert.Equal(t, len(res.Headers), 2)
+	assert.Equal(t, res.Headers.Get("Vary"), "Accept-Encoding")
+	assert.Equal(t, res.Headers.Get("Content-Encoding"), "")
 }
 
 func TestCompressBrotliType(t *testing.T) {
@@ -296,3 +305,105 @@ func TestCompressDoBrImage(t *testing.T) {
 	assert.Equal(t, len(res.Headers), 3)
 	assert.Equal(t, res.Headers.Get("Content-Encoding"), "br")
 }
+
+func TestCompressNegotiation(t *testing.T) {
+	c := CompressPlugin{}
+	configStr := `
+    gzip:
+       level: 5
+       minSize: 100
+    brotli:
+       level: 4
+`
+	var config interface{}
+	yaml.Unmarshal([]byte(configStr), &config)
+	c.configure(config)
+
+	tests := []struct {
+		acceptEncoding string
+		contentType    string
+		encoding       string
+	}{
+		{"gzip, br", "application/json; charset=utf-8", "br"},
+		{"gzip, br;q=0", "application/json", "gzip"},
+		{"*;q=0.5", "text/css", "br"},
+		{"*, br;q=0", "text/css", "gzip"},
+		{"identity", "text/css", ""},
+		{"gzip", "image/svg+xml", "gzip"},
+		{"gzip, br", "image/png", ""},
+		{"gzip, br", "application/octet-stream", ""},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", "http://mort/local/file", nil)
+		req.Header.Add("Accept-Encoding", test.acceptEncoding)
+		res := response.NewBuf(200, make([]byte, 1200))
+		res.Headers.Set("Content-Type", test.contentType)
+
+		c.postProcess(nil, req, res)
+
+		assert.Equal(t, test.encoding, res.Headers.Get("Content-Encoding"), "%s %s", test.acceptEncoding, test.contentType)
+	}
+}
+
+func TestCompressMinSize(t *testing.T) {
+	c := CompressPlugin{}
+	configStr := `
+    gzip:
+       minSize: 5000
+`
+	var config interface{}
+	yaml.Unmarshal([]byte(configStr), &config)
+	c.configure(config)
+
+	req, _ := http.NewRequest("GET", "http://mort/local/file.json", nil)
+	req.Header.Add("Accept-Encoding", "gzip")
+	res := response.NewBuf(200, make([]byte, 1200))
+	res.Headers.Set("Content-Type", "application/json")
+
+	c.postProcess(nil, req, res)
+
+	assert.Equal(t, "", res.Headers.Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", res.Headers.Get("Vary"))
+}
+
+func TestCompressETag(t *testing.T) {
+	c := CompressPlugin{}
+	configStr := `
+    gzip:
+       level: 5
+`
+	var config interface{}
+	yaml.Unmarshal([]byte(configStr), &config)
+	c.configure(config)
+
+	req, _ := http.NewRequest("GET", "http://mort/local/file.json", nil)
+	req.Header.Add("Accept-Encoding", "gzip")
+	res := response.NewBuf(200, make([]byte, 1200))
+	res.Headers.Set("Content-Type", "application/json")
+	res.Headers.Set("ETag", `"abc"`)
+	res.Headers.Set("Content-Length", "1200")
+
+	c.postProcess(nil, req, res)
+
+	assert.Equal(t, `"abc-gzip"`, res.Headers.Get("ETag"))
+	assert.Equal(t, "", res.Headers.Get("Content-Length"))
+
+	req.Header.Set("If-None-Match", `"abc-gzip"`)
+	recorder := httptest.NewRecorder()
+	res.SendContent(req, recorder)
+	assert.Equal(t, 304, recorder.Code)
+	assert.Equal(t, 0, recorder.Body.Len())
+
+	res = response.NewBuf(200, make([]byte, 1200))
+	res.Headers.Set("Content-Type", "application/json")
+	res.Headers.Set("ETag", "abc")
+	c.postProcess(nil, req, res)
+	assert.Equal(t, "abc-gzip", res.Headers.Get("ETag"))
+
+	req.Header.Set("If-None-Match", `"abc"`)
+	recorder = httptest.NewRecorder()
+	res.SendContent(req, recorder)
+	assert.Equal(t, 200, recorder.Code)
+	assert.Equal(t, "gzip", recorder.Header().Get("Content-Encoding"))
+}
@@ -0,0 +1,193 @@
+package plugins
+
+import (
+	"errors"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"image"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+
+	"github.com/aldor007/mort/pkg/monitoring"
+	"github.com/aldor007/mort/pkg/object"
+	"github.com/aldor007/mort/pkg/response"
+	"github.com/traefik/yaegi/interp"
+	"github.com/traefik/yaegi/stdlib"
+	"go.uber.org/zap"
+)
+
+// scriptHooks functions defined by script of external plugin, functions which script doesn't define are nil
+// Scripts are Go source files interpreted by Yaegi, they can use only Go standard library so their hooks get values of standard types
+type scriptHooks struct {
+	configure     func(config map[string]interface{}) error                   // Configure is called once with "config" entry of plugin configuration
+	preProcess    func(req *http.Request)                                     // PreProcess is used before start of processing object
+	postProcess   func(req *http.Request, statusCode int, header http.Header) // PostProcess is used after end of processing object, it can change headers of response
+	postUpload    func(bucket, key string)                                    // PostUpload is used after object was successfully stored, it is run asynchronously
+	preStorage    func(req *http.Request, bucket, key string) string          // PreStorage is used before fetching object from storage, it returns key of object
+	postTransform func(bucket, key string, img image.Image) image.Image       // PostTransform is used after engine processed image and before it is encoded, it returns image of result
+	onError       func(req *http.Request, statusCode int, header http.Header) // OnError is used when response has error status code
+}
+
+// externalPlugin adapts hooks of script to Plugin, panics in plugin code are recovered so they don't break requests
+type externalPlugin struct {
+	name  string
+	hooks scriptHooks
+}
+
+// isExternal check if plugin config points to script
+func isExternal(config interface{}) (string, bool) {
+	cfg, ok := config.(map[interface{}]interface{})
+	if !ok {
+		return "", false
+	}
+
+	path, ok := cfg["path"].(string)
+	return path, ok && path != ""
+}
+
+// loadExternalPlugin interprets script and looks up its hooks, script has to define at least one of them
+func loadExternalPlugin(name string, path string) (*externalPlugin, error) {
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := parser.ParseFile(token.NewFileSet(), path, src, parser.PackageClauseOnly)
+	if err != nil {
+		return nil, err
+	}
+
+	i := interp.New(interp.Options{})
+	i.Use(stdlib.Symbols)
+	if _, err = i.Eval(string(src)); err != nil {
+		return nil, err
+	}
+
+	p := &externalPlugin{name: name}
+	hooks := map[string]interface{}{
+		"Configure":     &p.hooks.configure,
+		"PreProcess":    &p.hooks.preProcess,
+		"PostProcess":   &p.hooks.postProcess,
+		"PostUpload":    &p.hooks.postUpload,
+		"PreStorage":    &p.hooks.preStorage,
+		"PostTransform": &p.hooks.postTransform,
+		"OnError":       &p.hooks.onError,
+	}
+
+	found := 0
+	for hookName, hook := range hooks {
+		fn, err := i.Eval(file.Name.Name + "." + hookName)
+		if err != nil || fn.Kind() != reflect.Func {
+			// hook isn't defined
+			continue
+		}
+
+		target := reflect.ValueOf(hook).Elem()
+		if !fn.Type().AssignableTo(target.Type()) {
+			return nil, fmt.Errorf("function %s in %s should be %s", hookName, path, target.Type())
+		}
+
+		target.Set(fn)
+		found++
+	}
+
+	if found == 0 {
+		return nil, errors.New("script " + path + " doesn't define any hook")
+	}
+
+	return p, nil
+}
+
+// scriptConfig converts YAML config to types of standard library, keys of maps are strings
+func scriptConfig(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			m[fmt.Sprint(k)] = scriptConfig(item)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, item := range v {
+			l[i] = scriptConfig(item)
+		}
+		return l
+	}
+
+	return value
+}
+
+func (e *externalPlugin) configure(config interface{}) {
+	if e.hooks.configure == nil {
+		return
+	}
+
+	pluginConfig := map[string]interface{}{}
+	if cfg, ok := config.(map[interface{}]interface{}); ok {
+		if c, ok := scriptConfig(cfg["config"]).(map[string]interface{}); ok {
+			pluginConfig = c
+		}
+	}
+
+	if err := e.hooks.configure(pluginConfig); err != nil {
+		panic(fmt.Errorf("unable to configure plugin %s %s", e.name, err))
+	}
+}
+
+func (e *externalPlugin) preProcess(obj *object.FileObject, req *http.Request) {
+	if e.hooks.preProcess != nil {
+		defer e.recover("preProcess")
+		e.hooks.preProcess(req)
+	}
+}
+
+func (e *externalPlugin) postProcess(obj *object.FileObject, req *http.Request, res *response.Response) {
+	if e.hooks.postProcess != nil {
+		defer e.recover("postProcess")
+		e.hooks.postProcess(req, res.StatusCode, res.Headers)
+	}
+}
+
+func (e *externalPlugin) postUpload(obj *object.FileObject) {
+	if e.hooks.postUpload != nil {
+		defer e.recover("postUpload")
+		e.hooks.postUpload(obj.Bucket, obj.Key)
+	}
+}
+
+func (e *externalPlugin) preStorage(obj *object.FileObject, req *http.Request) {
+	if e.hooks.preStorage != nil {
+		defer e.recover("preStorage")
+		if key := e.hooks.preStorage(req, obj.Bucket, obj.Key); key != "" {
+			obj.Key = key
+		}
+	}
+}
+
+func (e *externalPlugin) postTransform(obj *object.FileObject, img image.Image) (result image.Image) {
+	// image is kept when hook panics
+	result = img
+	if e.hooks.postTransform != nil {
+		defer e.recover("postTransform")
+		result = e.hooks.postTransform(obj.Bucket, obj.Key, img)
+	}
+
+	return result
+}
+
+func (e *externalPlugin) onError(obj *object.FileObject, req *http.Request, res *response.Response) {
+	if e.hooks.onError != nil {
+		defer e.recover("onError")
+		e.hooks.onError(req, res.StatusCode, res.Headers)
+	}
+}
+
+func (e *externalPlugin) recover(phase string) {
+	if r := recover(); r != nil {
+		monitoring.Log().Error("Plugin panic", zap.String("plugin", e.name), zap.String("phase", phase), zap.Any("panic", r))
+		monitoring.Report().Inc("plugin_panic_count;plugin:" + e.name)
+	}
+}
@@ -0,0 +1,195 @@
+package plugins
+
+import (
+	"errors"
+	"image"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/aldor007/mort/pkg/object"
+	"github.com/aldor007/mort/pkg/response"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+const testScript = `package hello
+
+import (
+	"net/http"
+	"strings"
+)
+
+var greeting string
+
+func Configure(config map[string]interface{}) error {
+	greeting, _ = config["greeting"].(string)
+	return nil
+}
+
+func PostProcess(req *http.Request, statusCode int, header http.Header) {
+	header.Set("x-hello", greeting)
+}
+
+func PreStorage(req *http.Request, bucket, key string) string {
+	return strings.Replace(key, "/old/", "/new/", 1)
+}
+`
+
+func externalConfig(t *testing.T) map[interface{}]interface{} {
+	configStr := `
+path: "/tmp/plugin.go"
+config:
+    greeting: hello
+    sizes: [1, 2]
+`
+	var config map[interface{}]interface{}
+	err := yaml.Unmarshal([]byte(configStr), &config)
+	assert.Nil(t, err)
+	return config
+}
+
+func writeScript(t *testing.T, src string) string {
+	dir, err := ioutil.TempDir("", "mort-plugin")
+	assert.Nil(t, err)
+	t.Cleanup(func() {
+		os.RemoveAll(dir)
+	})
+
+	scriptPath := path.Join(dir, "plugin.go")
+	assert.Nil(t, ioutil.WriteFile(scriptPath, []byte(src), 0644))
+	return scriptPath
+}
+
+func TestExternalPlugin(t *testing.T) {
+	var config map[string]interface{}
+	uploaded := ""
+	p := &externalPlugin{name: "fake", hooks: scriptHooks{
+		configure: func(c map[string]interface{}) error {
+			config = c
+			return nil
+		},
+		postProcess: func(_ *http.Request, _ int, header http.Header) {
+			header.Set("x-external", "1")
+		},
+		postUpload: func(bucket, key string) {
+			uploaded = bucket + key
+		},
+		postTransform: func(bucket, key string, img image.Image) image.Image {
+			return image.NewGray(img.Bounds())
+		},
+		onError: func(_ *http.Request, statusCode int, header http.Header) {
+			header.Set("x-external-error", "1")
+		},
+	}}
+	p.configure(externalConfig(t))
+
+	assert.Equal(t, "hello", config["greeting"])
+	assert.Equal(t, []interface{}{1, 2}, config["sizes"])
+
+	obj := &object.FileObject{Bucket: "bucket", Key: "/image.jpg"}
+	req, _ := http.NewRequest("GET", "http://mort/bucket/image.jpg", nil)
+	res := response.NewNoContent(200)
+	p.preProcess(obj, req)
+	p.postProcess(obj, req, res)
+	p.postUpload(obj)
+	p.preStorage(obj, req)
+	img := p.postTransform(obj, image.NewRGBA(image.Rect(0, 0, 1, 1)))
+	p.onError(obj, req, res)
+
+	assert.Equal(t, "1", res.Headers.Get("x-external"))
+	assert.Equal(t, "1", res.Headers.Get("x-external-error"))
+	assert.Equal(t, "bucket/image.jpg", uploaded)
+	assert.Equal(t, "/image.jpg", obj.Key)
+	assert.IsType(t, &image.Gray{}, img)
+}
+
+func TestExternalPluginRecoversPanic(t *testing.T) {
+	p := &externalPlugin{name: "fake", hooks: scriptHooks{
+		preProcess: func(_ *http.Request) {
+			panic("pre")
+		},
+		postProcess: func(_ *http.Request, _ int, _ http.Header) {
+			panic("post")
+		},
+		postTransform: func(_, _ string, _ image.Image) image.Image {
+			panic("transform")
+		},
+	}}
+	img := image.NewRGBA(image.Rect(0, 0, 1, 1))
+
+	req, _ := http.NewRequest("GET", "http://mort/bucket/image.jpg", nil)
+	res := response.NewNoContent(200)
+	assert.NotPanics(t, func() {
+		p.preProcess(nil, req)
+		p.postProcess(nil, req, res)
+		assert.Equal(t, img, p.postTransform(&object.FileObject{}, img), "image should be kept when hook panics")
+	})
+}
+
+func TestExternalPluginConfigureError(t *testing.T) {
+	p := &externalPlugin{name: "fake", hooks: scriptHooks{
+		configure: func(_ map[string]interface{}) error {
+			return errors.New("invalid")
+		},
+	}}
+
+	assert.Panics(t, func() {
+		p.configure(externalConfig(t))
+	})
+}
+
+func TestLoadExternalPlugin(t *testing.T) {
+	p, err := loadExternalPlugin("hello", writeScript(t, testScript))
+	assert.Nil(t, err)
+	assert.Nil(t, p.hooks.preProcess, "hooks which script doesn't define should be skipped")
+
+	p.configure(externalConfig(t))
+	obj := &object.FileObject{Bucket: "bucket", Key: "/old/image.jpg"}
+	req, _ := http.NewRequest("GET", "http://mort/bucket/old/image.jpg", nil)
+	res := response.NewNoContent(200)
+	p.preStorage(obj, req)
+	p.postProcess(obj, req, res)
+
+	assert.Equal(t, "/new/image.jpg", obj.Key)
+	assert.Equal(t, "hello", res.Headers.Get("x-hello"))
+}
+
+func TestLoadExternalPluginInvalid(t *testing.T) {
+	_, err := loadExternalPlugin("hello", writeScript(t, "package hello\n\nfunc Helper() {}\n"))
+	assert.NotNil(t, err, "script without hooks should be rejected")
+
+	_, err = loadExternalPlugin("hello", writeScript(t, "package hello\n\nfunc PreProcess(key string) {}\n"))
+	assert.NotNil(t, err, "hook with other signature should be rejected")
+
+	_, err = loadExternalPlugin("hello", writeScript(t, "package hello\n\nfunc PreProcess( {}\n"))
+	assert.NotNil(t, err)
+}
+
+func TestNewPluginsManagerExternalMissing(t *testing.T) {
+	configStr := `
+    external:
+       path: "/not/existing/plugin.go"
+`
+
+	var config map[string]interface{}
+	err := yaml.Unmarshal([]byte(configStr), &config)
+	assert.Nil(t, err)
+
+	assert.Panics(t, func() {
+		NewPluginsManager(config)
+	})
+	_, ok := pluginsList["external"]
+	assert.False(t, ok)
+}
+
+func TestIsExternal(t *testing.T) {
+	scriptPath, ok := isExternal(externalConfig(t))
+	assert.True(t, ok)
+	assert.Equal(t, "/tmp/plugin.go", scriptPath)
+
+	_, ok = isExternal(map[interface{}]interface{}{"gzip": 1})
+	assert.False(t, ok)
+}
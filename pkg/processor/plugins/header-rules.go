@@ -0,0 +1,175 @@
+package plugins
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/aldor007/mort/pkg/monitoring"
+	"github.com/aldor007/mort/pkg/object"
+	"github.com/aldor007/mort/pkg/response"
+	"go.uber.org/zap"
+)
+
+func init() {
+	RegisterPlugin("headerRules", &HeaderRulesPlugin{})
+}
+
+// headerRule change transforms of request with matching header
+type headerRule struct {
+	header   string            // name of request header
+	value    string            // expected value of header (case insensitive), empty matches any non empty value
+	quality  int               // max quality of image, 0 when not changed
+	maxWidth int               // max width of image, 0 when not changed
+	format   string            // format of image, empty when not changed
+	presets  map[string]string // presets which should be replaced by other presets of bucket
+	buckets  []string          // buckets to which rule applies, empty means all buckets
+	id       string            // suffix added to key of object so variants are stored and cached separately
+}
+
+// matches check if object is in bucket of rule and request has header of rule
+func (h headerRule) matches(obj *object.FileObject, req *http.Request) bool {
+	if len(h.buckets) != 0 && !containsString(h.buckets, obj.Bucket) {
+		return false
+	}
+
+	value := strings.TrimSpace(req.Header.Get(h.header))
+	if h.value == "" {
+		return value != ""
+	}
+
+	return strings.EqualFold(value, h.value)
+}
+
+// HeaderRulesPlugin swap or augment transforms based on request headers (e.g. Save-Data, Sec-CH-UA-Mobile)
+// Headers used by rules are added to Vary of transformed images
+type HeaderRulesPlugin struct {
+	rules []headerRule
+	vary  []string
+}
+
+func (h *HeaderRulesPlugin) configure(config interface{}) {
+	rules, ok := config.([]interface{})
+	if !ok {
+		panic(errors.New("headerRules plugin requires list of rules"))
+	}
+
+	h.rules = make([]headerRule, 0, len(rules))
+	h.vary = nil
+	varied := make(map[string]bool)
+	for _, r := range rules {
+		cfg, ok := r.(map[interface{}]interface{})
+		if !ok {
+			panic(errors.New("headerRules plugin invalid rule"))
+		}
+
+		rule := headerRule{}
+		rule.header, _ = cfg["header"].(string)
+		if rule.header == "" {
+			panic(errors.New("headerRules plugin rule requires header"))
+		}
+		rule.header = http.CanonicalHeaderKey(rule.header)
+
+		switch value := cfg["value"].(type) {
+		case string:
+			rule.value = value
+		case int:
+			rule.value = strconv.Itoa(value)
+		case bool:
+			rule.value = strconv.FormatBool(value)
+		}
+		rule.quality, _ = cfg["quality"].(int)
+		rule.maxWidth, _ = cfg["maxWidth"].(int)
+		rule.format, _ = cfg["format"].(string)
+		if presets, ok := cfg["presets"].(map[interface{}]interface{}); ok {
+			rule.presets = make(map[string]string, len(presets))
+			for from, to := range presets {
+				rule.presets[from.(string)] = to.(string)
+			}
+		}
+
+		if buckets, ok := cfg["buckets"].([]interface{}); ok {
+			for _, bucket := range buckets {
+				rule.buckets = append(rule.buckets, fmt.Sprint(bucket))
+			}
+		}
+
+		if rule.quality == 0 && rule.maxWidth == 0 && rule.format == "" && len(rule.presets) == 0 {
+			panic(errors.New("headerRules plugin rule for " + rule.header + " doesn't change anything"))
+		}
+
+		hash := fnv.New32a()
+		hash.Write([]byte(rule.header + "=" + strings.ToLower(rule.value)))
+		rule.id = "-h" + strconv.FormatUint(uint64(hash.Sum32()), 36)
+
+		h.rules = append(h.rules, rule)
+		if !varied[rule.header] {
+			varied[rule.header] = true
+			h.vary = append(h.vary, rule.header)
+		}
+	}
+}
+
+// preProcess apply rules matching request to transforms of object
+func (h *HeaderRulesPlugin) preProcess(obj *object.FileObject, req *http.Request) {
+	if !obj.HasTransform() {
+		return
+	}
+
+	for _, rule := range h.rules {
+		if !rule.matches(obj, req) {
+			continue
+		}
+
+		preset := obj.Preset
+		hash := obj.Transforms.Hash().Sum64()
+
+		if to, ok := rule.presets[obj.Preset]; ok {
+			if err := obj.SwapPreset(to); err != nil {
+				monitoring.Log().Warn("HeaderRulesPlugin unable to swap preset", obj.LogData(zap.String("preset", to), zap.Error(err))...)
+				continue
+			}
+		}
+
+		if rule.maxWidth != 0 {
+			obj.Transforms.LimitWidth(rule.maxWidth)
+		}
+
+		if rule.quality != 0 {
+			if q := obj.Transforms.Params().Quality; q == 0 || q > rule.quality {
+				obj.Transforms.Quality(rule.quality)
+			}
+		}
+
+		if rule.format != "" {
+			obj.Transforms.Format(rule.format)
+		}
+
+		// rule which doesn't change result (e.g. quality is already lower) doesn't create new variant
+		if obj.Preset != preset || obj.Transforms.Hash().Sum64() != hash {
+			obj.UpdateKey(rule.id)
+		}
+	}
+}
+
+func containsString(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// postProcess add headers used by rules to Vary
+func (h *HeaderRulesPlugin) postProcess(obj *object.FileObject, req *http.Request, res *response.Response) {
+	if res.IsImage() && obj.HasTransform() {
+		for _, header := range h.vary {
+			res.Headers.Add("Vary", header)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package plugins
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/aldor007/mort/pkg/object"
+	"github.com/aldor007/mort/pkg/response"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+func headerRulesPlugin(t *testing.T) *HeaderRulesPlugin {
+	configStr := `
+- header: Save-Data
+  value: "on"
+  quality: 50
+- header: sec-ch-ua-mobile
+  value: "?1"
+  maxWidth: 400
+`
+	var config interface{}
+	err := yaml.Unmarshal([]byte(configStr), &config)
+	assert.Nil(t, err)
+
+	h := &HeaderRulesPlugin{}
+	h.configure(config)
+	return h
+}
+
+func headerRulesObject() *object.FileObject {
+	obj := &object.FileObject{Key: "/image.jpg"}
+	obj.Transforms.Resize(1000, 500, false, true, false)
+	obj.Transforms.Quality(80)
+	return obj
+}
+
+func TestHeaderRulesPlugin(t *testing.T) {
+	h := headerRulesPlugin(t)
+	req, _ := http.NewRequest("GET", "http://mort/local/image.jpg", nil)
+	req.Header.Set("Save-Data", "On")
+	req.Header.Set("Sec-CH-UA-Mobile", "?1")
+
+	obj := headerRulesObject()
+	h.preProcess(obj, req)
+
+	p := obj.Transforms.Params()
+	assert.Equal(t, 50, p.Quality)
+	assert.Equal(t, 400, p.Width)
+	assert.Equal(t, 200, p.Height)
+	assert.NotEqual(t, "/image.jpg", obj.Key)
+
+	res := response.NewNoContent(200)
+	res.Headers.Set("content-type", "image/jpeg")
+	h.postProcess(obj, req, res)
+
+	assert.Equal(t, []string{"Save-Data", "Sec-Ch-Ua-Mobile"}, res.Headers.Values("Vary"))
+}
+
+func TestHeaderRulesPluginNoMatch(t *testing.T) {
+	h := headerRulesPlugin(t)
+	req, _ := http.NewRequest("GET", "http://mort/local/image.jpg", nil)
+	req.Header.Set("Sec-CH-UA-Mobile", "?0")
+
+	obj := headerRulesObject()
+	h.preProcess(obj, req)
+
+	p := obj.Transforms.Params()
+	assert.Equal(t, 80, p.Quality)
+	assert.Equal(t, 1000, p.Width)
+	assert.Equal(t, "/image.jpg", obj.Key)
+
+	res := response.NewNoContent(200)
+	res.Headers.Set("content-type", "image/jpeg")
+	h.postProcess(obj, req, res)
+
+	assert.Equal(t, 2, len(res.Headers.Values("Vary")))
+}
+
+func TestHeaderRulesPluginInvalid(t *testing.T) {
+	h := &HeaderRulesPlugin{}
+	assert.Panics(t, func() {
+		h.configure([]interface{}{map[interface{}]interface{}{"header": "Save-Data"}})
+	})
+	assert.Panics(t, func() {
+		h.configure(map[interface{}]interface{}{})
+	})
+}
+
+func TestHeaderRulesPluginUnchanged(t *testing.T) {
+	h := headerRulesPlugin(t)
+	req, _ := http.NewRequest("GET", "http://mort/local/image.jpg", nil)
+	req.Header.Set("Save-Data", "on")
+
+	obj := headerRulesObject()
+	obj.Transforms.Quality(40)
+	h.preProcess(obj, req)
+
+	assert.Equal(t, 40, obj.Transforms.Params().Quality)
+	assert.Equal(t, "/image.jpg", obj.Key, "rule which doesn't change transforms shouldn't create variant")
+}
+
+func TestHeaderRulesPluginBuckets(t *testing.T) {
+	h := &HeaderRulesPlugin{}
+	h.configure([]interface{}{map[interface{}]interface{}{"header": "Save-Data", "quality": 50, "buckets": []interface{}{"media"}}})
+	req, _ := http.NewRequest("GET", "http://mort/local/image.jpg", nil)
+	req.Header.Set("Save-Data", "on")
+
+	obj := headerRulesObject()
+	obj.Bucket = "local"
+	h.preProcess(obj, req)
+	assert.Equal(t, 80, obj.Transforms.Params().Quality, "rule shouldn't apply to other buckets")
+	assert.Equal(t, "/image.jpg", obj.Key)
+
+	obj = headerRulesObject()
+	obj.Bucket = "media"
+	h.preProcess(obj, req)
+	assert.Equal(t, 50, obj.Transforms.Params().Quality)
+	assert.NotEqual(t, "/image.jpg", obj.Key)
+}
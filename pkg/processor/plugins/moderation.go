@@ -0,0 +1,178 @@
+package plugins
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/aldor007/mort/pkg/monitoring"
+	"github.com/aldor007/mort/pkg/object"
+	"github.com/aldor007/mort/pkg/response"
+	"github.com/aldor007/mort/pkg/storage"
+	"go.uber.org/zap"
+)
+
+const (
+	// HeaderModeration name of object metadata header with result of moderation
+	HeaderModeration = "x-amz-meta-moderation"
+	// ModerationQuarantined value of HeaderModeration for objects which should be served only as placeholder
+	ModerationQuarantined = "quarantined"
+
+	moderationFlag       = "flag"       // flagged object is only reported
+	moderationQuarantine = "quarantine" // flagged object is quarantined
+)
+
+func init() {
+	RegisterPlugin("moderation", &ModerationPlugin{})
+}
+
+// moderationResult response of moderation API
+type moderationResult struct {
+	Score float64 `json:"score"` // probability that image is NSFW (0-1)
+}
+
+// ModerationPlugin send uploaded images to external moderation (NSFW scoring) API
+// Images with score above threshold are flagged and depending on bucket policy quarantined
+type ModerationPlugin struct {
+	url       string
+	threshold float64
+	headers   map[string]string
+	policies  map[string]string // policy (flag, quarantine) per bucket, buckets without policy are not moderated
+	client    *http.Client
+}
+
+func (m *ModerationPlugin) configure(config interface{}) {
+	cfg := config.(map[interface{}]interface{})
+	url, ok := cfg["url"].(string)
+	if !ok || url == "" {
+		panic(errors.New("moderation plugin requires url"))
+	}
+
+	m.url = url
+	m.threshold = 0.8
+	switch threshold := cfg["threshold"].(type) {
+	case float64:
+		m.threshold = threshold
+	case int:
+		m.threshold = float64(threshold)
+	}
+
+	timeout := 10
+	if t, ok := cfg["timeout"].(int); ok {
+		timeout = t
+	}
+	m.client = &http.Client{Timeout: time.Duration(timeout) * time.Second}
+
+	m.headers = make(map[string]string)
+	if headers, ok := cfg["headers"].(map[interface{}]interface{}); ok {
+		for k, v := range headers {
+			m.headers[k.(string)] = v.(string)
+		}
+	}
+
+	m.policies = make(map[string]string)
+	if buckets, ok := cfg["buckets"].(map[interface{}]interface{}); ok {
+		for bucket, policy := range buckets {
+			switch policy {
+			case moderationFlag, moderationQuarantine:
+				m.policies[bucket.(string)] = policy.(string)
+			default:
+				panic(errors.New("moderation plugin unknown policy " + policy.(string)))
+			}
+		}
+	}
+}
+
+func (ModerationPlugin) preProcess(obj *object.FileObject, req *http.Request) {
+
+}
+
+func (ModerationPlugin) postProcess(obj *object.FileObject, req *http.Request, res *response.Response) {
+
+}
+
+// postUpload score uploaded image and quarantine it when bucket policy requires it
+func (m *ModerationPlugin) postUpload(obj *object.FileObject) {
+	policy, ok := m.policies[obj.Bucket]
+	if !ok || obj.HasTransform() {
+		return
+	}
+
+	res := storage.Get(obj)
+	defer res.Close()
+	if res.StatusCode != 200 || !res.IsImage() {
+		return
+	}
+
+	buf, err := res.Body()
+	if err != nil {
+		monitoring.Log().Warn("ModerationPlugin unable to read object", obj.LogData(zap.Error(err))...)
+		return
+	}
+
+	score, err := m.score(buf, res.Headers.Get(response.HeaderContentType))
+	if err != nil {
+		monitoring.Report().Inc("moderation_count;result:error")
+		monitoring.Log().Warn("ModerationPlugin unable to score image", obj.LogData(zap.Error(err))...)
+		return
+	}
+
+	if score < m.threshold {
+		monitoring.Report().Inc("moderation_count;result:ok")
+		return
+	}
+
+	monitoring.Report().Inc("moderation_count;result:flagged")
+	monitoring.Log().Warn("ModerationPlugin image flagged", obj.LogData(zap.Float64("score", score), zap.String("policy", policy))...)
+	if policy != moderationQuarantine {
+		return
+	}
+
+	// object is stored again with moderation flag in metadata
+	headers := res.Headers.Clone()
+	headers.Set(HeaderModeration, ModerationQuarantined)
+	setRes := storage.Set(obj, headers, int64(len(buf)), bytes.NewReader(buf))
+	if setRes.HasError() {
+		monitoring.Log().Error("ModerationPlugin unable to quarantine object", obj.LogData(zap.Error(setRes.Error()))...)
+	}
+}
+
+// score send image to moderation API and returns its NSFW score
+func (m *ModerationPlugin) score(buf []byte, contentType string) (float64, error) {
+	req, err := http.NewRequest("POST", m.url, bytes.NewReader(buf))
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Content-Type", contentType)
+	for k, v := range m.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return 0, errors.New("moderation api returned " + resp.Status)
+	}
+
+	result := moderationResult{}
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	return result.Score, err
+}
+
+// IsQuarantined check if object in response was quarantined by moderation
+func IsQuarantined(res *response.Response) bool {
+	return res.Headers.Get(HeaderModeration) == ModerationQuarantined
+}
+
+// QuarantinesBucket check if moderation plugin quarantines flagged objects of bucket
+func QuarantinesBucket(bucket string) bool {
+	m, ok := pluginsList["moderation"].(*ModerationPlugin)
+	return ok && m.policies[bucket] == moderationQuarantine
+}
@@ -0,0 +1,132 @@
+package plugins
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aldor007/mort/pkg/object"
+	"github.com/aldor007/mort/pkg/response"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+func moderationConfig(t *testing.T, configStr string) map[string]interface{} {
+	var config map[string]interface{}
+	err := yaml.Unmarshal([]byte(configStr), &config)
+	assert.Nil(t, err)
+	return config
+}
+
+func TestModerationConfigure(t *testing.T) {
+	config := moderationConfig(t, `
+    moderation:
+       url: "http://moderation/score"
+       threshold: 0.5
+       timeout: 3
+       headers:
+          Authorization: "Bearer token"
+       buckets:
+          media: quarantine
+          avatars: flag
+`)
+
+	NewPluginsManager(config)
+	m := pluginsList["moderation"].(*ModerationPlugin)
+
+	assert.Equal(t, "http://moderation/score", m.url)
+	assert.Equal(t, 0.5, m.threshold)
+	assert.Equal(t, "Bearer token", m.headers["Authorization"])
+	assert.Equal(t, moderationQuarantine, m.policies["media"])
+	assert.Equal(t, moderationFlag, m.policies["avatars"])
+
+	assert.True(t, QuarantinesBucket("media"))
+	assert.False(t, QuarantinesBucket("avatars"), "flagged objects aren't quarantined")
+	assert.False(t, QuarantinesBucket("other"))
+}
+
+func TestModerationConfigurePanic(t *testing.T) {
+	assert.Panics(t, func() {
+		NewPluginsManager(moderationConfig(t, `
+    moderation:
+       threshold: 0.5
+`))
+	})
+
+	assert.Panics(t, func() {
+		NewPluginsManager(moderationConfig(t, `
+    moderation:
+       url: "http://moderation/score"
+       buckets:
+          media: delete
+`))
+	})
+}
+
+func TestModerationScore(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		assert.Equal(t, "image/jpeg", req.Header.Get("Content-Type"))
+		assert.Equal(t, "Bearer token", req.Header.Get("Authorization"))
+		assert.Equal(t, "image", string(body))
+		w.Write([]byte(`{"score": 0.93}`))
+	}))
+	defer server.Close()
+
+	m := &ModerationPlugin{}
+	m.configure(map[interface{}]interface{}{"url": server.URL, "headers": map[interface{}]interface{}{"Authorization": "Bearer token"}})
+
+	score, err := m.score([]byte("image"), "image/jpeg")
+
+	assert.Nil(t, err)
+	assert.Equal(t, 0.93, score)
+}
+
+func TestModerationScoreError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(500)
+	}))
+	defer server.Close()
+
+	m := &ModerationPlugin{}
+	m.configure(map[interface{}]interface{}{"url": server.URL})
+
+	_, err := m.score([]byte("image"), "image/jpeg")
+
+	assert.NotNil(t, err)
+}
+
+type uploadPluginMock struct {
+	uploaded []string
+}
+
+func (*uploadPluginMock) configure(_ interface{}) {}
+
+func (*uploadPluginMock) preProcess(obj *object.FileObject, req *http.Request) {}
+
+func (*uploadPluginMock) postProcess(obj *object.FileObject, req *http.Request, res *response.Response) {
+}
+
+func (u *uploadPluginMock) postUpload(obj *object.FileObject) {
+	u.uploaded = append(u.uploaded, obj.Key)
+}
+
+func TestPluginsManager_PostUpload(t *testing.T) {
+	mock := &uploadPluginMock{}
+	RegisterPlugin("upload-mock", mock)
+	defer delete(pluginsList, "upload-mock")
+
+	pm := NewPluginsManager(map[string]interface{}{"upload-mock": nil, "webp": nil})
+	pm.PostUpload(&object.FileObject{Key: "/image.jpg"})
+
+	assert.Equal(t, []string{"/image.jpg"}, mock.uploaded)
+}
+
+func TestIsQuarantined(t *testing.T) {
+	res := response.NewNoContent(200)
+	assert.False(t, IsQuarantined(res))
+
+	res.Set(HeaderModeration, ModerationQuarantined)
+	assert.True(t, IsQuarantined(res))
+}
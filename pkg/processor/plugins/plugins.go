@@ -2,6 +2,8 @@ package plugins
 
 import (
 	"fmt"
+	"image"
+
 	"github.com/aldor007/mort/pkg/object"
 	"github.com/aldor007/mort/pkg/response"
 	"net/http"
@@ -17,6 +19,26 @@ type Plugin interface {
 	configure(config interface{})
 }
 
+// UploadPlugin is implemented by plugins that should be notified about uploaded objects
+type UploadPlugin interface {
+	postUpload(obj *object.FileObject) // PostUpload is used after object was successfully stored, it is run asynchronously
+}
+
+// StoragePlugin is implemented by plugins that should be run before object is fetched from storage
+type StoragePlugin interface {
+	preStorage(obj *object.FileObject, req *http.Request) // PreStorage is used before fetching object from storage, it can rewrite key of object
+}
+
+// TransformPlugin is implemented by plugins that should be run after image was transformed
+type TransformPlugin interface {
+	postTransform(obj *object.FileObject, img image.Image) image.Image // PostTransform is used after engine processed image and before result is encoded, returned image replaces result
+}
+
+// ErrorPlugin is implemented by plugins that should be run for error responses
+type ErrorPlugin interface {
+	onError(obj *object.FileObject, req *http.Request, res *response.Response) // OnError is used when response has error status code, before PostProcess
+}
+
 // PluginsManager process plugins
 type PluginsManager struct {
 	list []string
@@ -28,7 +50,16 @@ func NewPluginsManager(plugins map[string]interface{}) PluginsManager {
 	pm.list = make([]string, 0)
 	for pName, pConfig := range plugins {
 		if _, ok := pluginsList[pName]; !ok {
-			panic(fmt.Errorf("unknown plugin %s", pName))
+			path, external := isExternal(pConfig)
+			if !external {
+				panic(fmt.Errorf("unknown plugin %s", pName))
+			}
+
+			p, err := loadExternalPlugin(pName, path)
+			if err != nil {
+				panic(fmt.Errorf("unable to load plugin %s %s", pName, err))
+			}
+			RegisterPlugin(pName, p)
 		}
 
 		pluginsList[pName].configure(pConfig)
@@ -51,6 +82,57 @@ func (h PluginsManager) PostProcess(obj *object.FileObject, req *http.Request, r
 	}
 }
 
+// PostUpload run PostUpload functions of plugins that implement UploadPlugin
+func (h PluginsManager) PostUpload(obj *object.FileObject) {
+	for _, hook := range h.list {
+		if p, ok := pluginsList[hook].(UploadPlugin); ok {
+			p.postUpload(obj)
+		}
+	}
+}
+
+// PreStorage run PreStorage functions of plugins that implement StoragePlugin
+func (h PluginsManager) PreStorage(obj *object.FileObject, req *http.Request) {
+	for _, hook := range h.list {
+		if p, ok := pluginsList[hook].(StoragePlugin); ok {
+			p.preStorage(obj, req)
+		}
+	}
+}
+
+// HasTransformPlugins check if any plugin implements TransformPlugin
+func (h PluginsManager) HasTransformPlugins() bool {
+	for _, hook := range h.list {
+		if _, ok := pluginsList[hook].(TransformPlugin); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// PostTransform run PostTransform functions of plugins that implement TransformPlugin, each plugin gets image returned by previous one
+func (h PluginsManager) PostTransform(obj *object.FileObject, img image.Image) image.Image {
+	for _, hook := range h.list {
+		if p, ok := pluginsList[hook].(TransformPlugin); ok {
+			if result := p.postTransform(obj, img); result != nil {
+				img = result
+			}
+		}
+	}
+
+	return img
+}
+
+// OnError run OnError functions of plugins that implement ErrorPlugin
+func (h PluginsManager) OnError(obj *object.FileObject, req *http.Request, res *response.Response) {
+	for _, hook := range h.list {
+		if p, ok := pluginsList[hook].(ErrorPlugin); ok {
+			p.onError(obj, req, res)
+		}
+	}
+}
+
 // RegisterPlugin register plugin
 func RegisterPlugin(name string, fnc Plugin) {
 	pluginsList[name] = fnc
@@ -1,9 +1,11 @@
 package plugins
 
 import (
+	"github.com/aldor007/mort/pkg/object"
 	"github.com/aldor007/mort/pkg/response"
 	"github.com/stretchr/testify/assert"
 	"gopkg.in/yaml.v2"
+	"image"
 	"net/http"
 	"testing"
 )
@@ -73,3 +75,55 @@ func TestPluginsManager_PreProcess(t *testing.T) {
 	assert.Equal(t, res.Headers.Get("Content-Encoding"), "gzip")
 	assert.Equal(t, res.Headers.Get("Vary"), "Accept-Encoding")
 }
+
+type hooksPluginMock struct {
+	calls []string
+}
+
+func (*hooksPluginMock) configure(_ interface{}) {}
+
+func (h *hooksPluginMock) preProcess(obj *object.FileObject, req *http.Request) {
+	h.calls = append(h.calls, "preProcess")
+}
+
+func (h *hooksPluginMock) postProcess(obj *object.FileObject, req *http.Request, res *response.Response) {
+	h.calls = append(h.calls, "postProcess")
+}
+
+func (h *hooksPluginMock) preStorage(obj *object.FileObject, req *http.Request) {
+	obj.Key = "/rewritten.jpg"
+	h.calls = append(h.calls, "preStorage")
+}
+
+func (h *hooksPluginMock) postTransform(obj *object.FileObject, img image.Image) image.Image {
+	h.calls = append(h.calls, "postTransform")
+	return image.NewGray(img.Bounds())
+}
+
+func (h *hooksPluginMock) onError(obj *object.FileObject, req *http.Request, res *response.Response) {
+	res.Set("x-error", "1")
+	h.calls = append(h.calls, "onError")
+}
+
+func TestPluginsManager_Hooks(t *testing.T) {
+	mock := &hooksPluginMock{}
+	RegisterPlugin("hooks-mock", mock)
+	defer delete(pluginsList, "hooks-mock")
+
+	pm := NewPluginsManager(map[string]interface{}{"hooks-mock": nil, "webp": nil})
+	obj := &object.FileObject{Key: "/image.jpg"}
+	req, _ := http.NewRequest("GET", "http://mort/local/image.jpg", nil)
+	res := response.NewNoContent(404)
+
+	assert.True(t, pm.HasTransformPlugins())
+	assert.False(t, NewPluginsManager(map[string]interface{}{"webp": nil}).HasTransformPlugins())
+	pm.PreStorage(obj, req)
+	img := pm.PostTransform(obj, image.NewRGBA(image.Rect(0, 0, 2, 2)))
+	pm.OnError(obj, req, res)
+
+	assert.Equal(t, []string{"preStorage", "postTransform", "onError"}, mock.calls)
+	assert.Equal(t, "/rewritten.jpg", obj.Key)
+	assert.IsType(t, &image.Gray{}, img, "image returned by plugin should replace result")
+	assert.Equal(t, 2, img.Bounds().Dx())
+	assert.Equal(t, "1", res.Headers.Get("x-error"))
+}
@@ -0,0 +1,47 @@
+package processor
+
+import (
+	"sync"
+	"time"
+
+	"github.com/aldor007/mort/pkg/config"
+	"github.com/aldor007/mort/pkg/monitoring"
+	"github.com/aldor007/mort/pkg/throttler"
+)
+
+// bucketPools keeps separate pool of transforms for each bucket with pool configuration
+// Pools are created on first use, so they follow configuration of buckets loaded after creation of processor
+type bucketPools struct {
+	lock  sync.Mutex
+	pools map[string]*throttler.BucketThrottler
+}
+
+func newBucketPools() *bucketPools {
+	return &bucketPools{pools: make(map[string]*throttler.BucketThrottler)}
+}
+
+// get returns pool of bucket, nil is returned when bucket doesn't have its own pool
+func (b *bucketPools) get(bucketName string) *throttler.BucketThrottler {
+	if b == nil {
+		return nil
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if pool, ok := b.pools[bucketName]; ok {
+		return pool
+	}
+
+	var pool *throttler.BucketThrottler
+	if bucket, ok := config.GetInstance().Buckets[bucketName]; ok && bucket.Transform != nil && bucket.Transform.Pool != nil {
+		cfg := bucket.Transform.Pool
+		pool = throttler.NewBucketThrottlerBacklog(cfg.Size, cfg.Backlog, time.Duration(cfg.Timeout)*time.Second)
+		metric := "pool_queue_length;bucket:" + bucketName
+		pool.OnQueue(func(delta int) {
+			monitoring.Report().Gauge(metric, float64(delta))
+		})
+	}
+
+	b.pools[bucketName] = pool
+	return pool
+}
@@ -0,0 +1,38 @@
+package processor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aldor007/mort/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBucketPools(t *testing.T) {
+	mortConfig := config.GetInstance()
+	err := mortConfig.Load("./benchmark/small.yml")
+	assert.Nil(t, err)
+
+	transform := mortConfig.Buckets["local"].Transform
+	transform.Pool = &config.PoolCfg{Size: 1, Timeout: 1}
+	defer func() {
+		transform.Pool = nil
+	}()
+
+	pools := newBucketPools()
+	pool := pools.get("local")
+	assert.NotNil(t, pool)
+	assert.True(t, pool == pools.get("local"))
+
+	assert.True(t, pool.Take(context.Background()))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	assert.False(t, pool.Take(ctx))
+	pool.Release()
+
+	assert.Nil(t, pools.get("unknown"))
+
+	var disabled *bucketPools
+	assert.Nil(t, disabled.get("local"))
+}
@@ -0,0 +1,217 @@
+package processor
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aldor007/mort/pkg/config"
+	"github.com/aldor007/mort/pkg/monitoring"
+	"github.com/aldor007/mort/pkg/object"
+	"github.com/aldor007/mort/pkg/response"
+	"go.uber.org/zap"
+)
+
+const (
+	// maxPostFieldsSize max size of all form fields of POST upload except file
+	maxPostFieldsSize = 1 << 20
+	// maxPostFileSize max size of file of POST upload, when bucket doesn't limit size of uploads, file is buffered in memory
+	maxPostFileSize = 64 << 20
+)
+
+var (
+	errPostFieldsTooLarge = errors.New("form fields too large")
+	errPostMissingFile    = errors.New("missing file field")
+	errPostMissingKey     = errors.New("missing key field")
+	errPostNotForm        = errors.New("expected multipart/form-data upload")
+)
+
+// postHeaderFields are form fields which are stored as headers of uploaded object
+var postHeaderFields = []string{"cache-control", "content-disposition", "content-encoding", "expires"}
+
+// postResult is body of response for success_action_status 201
+type postResult struct {
+	XMLName  xml.Name `xml:"PostResponse"`
+	Location string   `xml:"Location"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	ETag     string   `xml:"ETag"`
+}
+
+// postFileLimit returns max size of file of POST upload to bucket
+func postFileLimit(bucket config.Bucket) int64 {
+	if bucket.Upload != nil && bucket.Upload.MaxSize > 0 {
+		return bucket.Upload.MaxSize
+	}
+
+	return maxPostFileSize
+}
+
+// isFormUpload check if request is browser form upload
+func isFormUpload(req *http.Request) bool {
+	return req.Method == "POST" && strings.HasPrefix(req.Header.Get("Content-Type"), "multipart/form-data")
+}
+
+// handlePOST store file from browser form upload, upload is authorized by S3 POST policy signed with key of bucket
+// Fields of form are read until file field, so file has to be last field of form
+func (r *RequestProcessor) handlePOST(req *http.Request, obj *object.FileObject) *response.Response {
+	defer req.Body.Close()
+	if !isFormUpload(req) {
+		return response.NewError(400, errPostNotForm)
+	}
+
+	fields, file, err := readPostForm(req)
+	if err != nil {
+		return response.NewError(400, err)
+	}
+
+	policy, err := verifyPostPolicy(config.GetInstance().Buckets[obj.Bucket], obj.Bucket, fields, time.Now())
+	if err != nil {
+		monitoring.Log().Warn("Processor/handlePOST policy rejected", obj.LogData(zap.Error(err))...)
+		return response.NewError(403, response.NewCodedError("AccessDenied", err.Error()))
+	}
+
+	key := strings.Replace(fields["key"], "${filename}", file.FileName(), -1)
+	if key == "" {
+		return response.NewError(400, errPostMissingKey)
+	}
+
+	keyObj, err := object.NewFileObjectFromPath("/"+obj.Bucket+"/"+strings.TrimPrefix(key, "/"), config.GetInstance())
+	if err != nil {
+		return response.NewError(400, err)
+	}
+	keyObj.FillWithRequest(req, obj.Ctx)
+
+	limit := postFileLimit(config.GetInstance().Buckets[obj.Bucket])
+	readLimit := limit
+	if policy.maxSize >= 0 && policy.maxSize < limit {
+		readLimit = policy.maxSize
+	}
+	buf, err := ioutil.ReadAll(io.LimitReader(file, readLimit+1))
+	if err != nil {
+		return response.NewError(400, err)
+	}
+
+	size := int64(len(buf))
+	if policy.maxSize >= 0 && size > policy.maxSize {
+		return response.NewError(400, response.NewCodedError("EntityTooLarge", "file exceeds content-length-range of policy"))
+	} else if size > limit {
+		return response.NewError(400, response.NewCodedError("EntityTooLarge", "file exceeds max size of upload"))
+	} else if size < policy.minSize {
+		return response.NewError(400, response.NewCodedError("EntityTooSmall", "file is smaller than content-length-range of policy"))
+	}
+
+	putReq := req.Clone(obj.Ctx)
+	putReq.Method = "PUT"
+	putReq.Header = make(http.Header)
+	contentType := fields["content-type"]
+	if contentType == "" {
+		contentType = file.Header.Get("Content-Type")
+	}
+	putReq.Header.Set("Content-Type", contentType)
+	for name, value := range fields {
+		if strings.HasPrefix(name, "x-amz-meta-") {
+			putReq.Header.Set(name, value)
+		}
+	}
+	for _, name := range postHeaderFields {
+		if value, ok := fields[name]; ok {
+			putReq.Header.Set(name, value)
+		}
+	}
+	putReq.Body = ioutil.NopCloser(bytes.NewReader(buf))
+	putReq.ContentLength = size
+
+	res := r.handlePUT(putReq, keyObj)
+	if res.StatusCode != 200 {
+		return res
+	}
+	res.Close()
+	r.deleteCachedResponses(keyObj)
+	r.postUpload(keyObj)
+	monitoring.Report().Inc("request_type;type:post_upload")
+
+	return postSuccess(fields, keyObj, res.Headers.Get("ETag"))
+}
+
+// readPostForm read form fields until file field, names of fields are lower case
+func readPostForm(req *http.Request) (map[string]string, *multipart.Part, error) {
+	reader, err := req.MultipartReader()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fields := make(map[string]string)
+	var size int64
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil, nil, errPostMissingFile
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if part.FormName() == "file" {
+			return fields, part, nil
+		}
+
+		value, err := ioutil.ReadAll(io.LimitReader(part, maxPostFieldsSize-size+1))
+		if err != nil {
+			return nil, nil, err
+		}
+
+		size += int64(len(value))
+		if size > maxPostFieldsSize {
+			return nil, nil, errPostFieldsTooLarge
+		}
+		fields[strings.ToLower(part.FormName())] = string(value)
+	}
+}
+
+// postSuccess returns response of successful upload according to success_action_redirect or success_action_status fields
+func postSuccess(fields map[string]string, obj *object.FileObject, etag string) *response.Response {
+	if redirect := fields["success_action_redirect"]; redirect != "" {
+		if location, err := url.Parse(redirect); err == nil {
+			query := location.Query()
+			query.Set("bucket", obj.Bucket)
+			query.Set("key", strings.TrimPrefix(obj.Key, "/"))
+			query.Set("etag", etag)
+			location.RawQuery = query.Encode()
+			res := response.NewNoContent(303)
+			res.Set("Location", location.String())
+			return res
+		}
+	}
+
+	status, _ := strconv.Atoi(fields["success_action_status"])
+	switch status {
+	case 200:
+		res := response.NewNoContent(200)
+		res.Set("ETag", etag)
+		return res
+	case 201:
+		location := "/" + obj.Bucket + obj.Key
+		buf, err := xml.Marshal(postResult{Location: location, Bucket: obj.Bucket, Key: strings.TrimPrefix(obj.Key, "/"), ETag: etag})
+		if err != nil {
+			return response.NewError(500, err)
+		}
+		res := response.NewBuf(201, append([]byte(xml.Header), buf...))
+		res.SetContentType("application/xml")
+		res.Set("ETag", etag)
+		res.Set("Location", location)
+		return res
+	default:
+		res := response.NewNoContent(204)
+		res.Set("ETag", etag)
+		return res
+	}
+}
@@ -0,0 +1,220 @@
+package processor
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+	"time"
+
+	"github.com/aldor007/mort/pkg/config"
+)
+
+const postAlgorithmV4 = "AWS4-HMAC-SHA256"
+
+var (
+	errPolicyMissing   = errors.New("missing policy")
+	errPolicyExpired   = errors.New("policy expired")
+	errPolicySignature = errors.New("invalid policy signature")
+)
+
+// postCondition is single condition of S3 POST policy
+type postCondition struct {
+	op    string // eq or starts-with
+	field string // lower case name of form field without $
+	value string
+}
+
+// postPolicy is decoded S3 POST policy
+type postPolicy struct {
+	expiration time.Time
+	conditions []postCondition
+	minSize    int64
+	maxSize    int64 // -1 when policy doesn't limit size
+}
+
+// postPolicyFields are form fields which don't have to be covered by conditions of policy
+var postPolicyFields = map[string]bool{
+	"policy":          true,
+	"x-amz-signature": true,
+	"signature":       true,
+	"awsaccesskeyid":  true,
+	"file":            true,
+}
+
+// parsePostPolicy decode policy from base64 JSON
+func parsePostPolicy(encoded string) (*postPolicy, error) {
+	buf, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	raw := struct {
+		Expiration string            `json:"expiration"`
+		Conditions []json.RawMessage `json:"conditions"`
+	}{}
+	if err = json.Unmarshal(buf, &raw); err != nil {
+		return nil, err
+	}
+
+	policy := &postPolicy{maxSize: -1}
+	policy.expiration, err = time.Parse(time.RFC3339, raw.Expiration)
+	if err != nil {
+		return nil, fmt.Errorf("invalid policy expiration %q", raw.Expiration)
+	}
+
+	for _, c := range raw.Conditions {
+		exact := map[string]interface{}{}
+		if json.Unmarshal(c, &exact) == nil {
+			for field, value := range exact {
+				policy.conditions = append(policy.conditions, postCondition{op: "eq", field: strings.ToLower(field), value: fmt.Sprint(value)})
+			}
+			continue
+		}
+
+		var list []interface{}
+		if err = json.Unmarshal(c, &list); err != nil || len(list) != 3 {
+			return nil, fmt.Errorf("invalid policy condition %s", c)
+		}
+
+		op, _ := list[0].(string)
+		op = strings.ToLower(op)
+		if op == "content-length-range" {
+			min, okMin := list[1].(float64)
+			max, okMax := list[2].(float64)
+			if !okMin || !okMax {
+				return nil, fmt.Errorf("invalid policy condition %s", c)
+			}
+			policy.minSize, policy.maxSize = int64(min), int64(max)
+			continue
+		}
+
+		field, _ := list[1].(string)
+		value, ok := list[2].(string)
+		if (op != "eq" && op != "starts-with") || !strings.HasPrefix(field, "$") || !ok {
+			return nil, fmt.Errorf("invalid policy condition %s", c)
+		}
+		policy.conditions = append(policy.conditions, postCondition{op: op, field: strings.ToLower(field[1:]), value: value})
+	}
+
+	return policy, nil
+}
+
+// check verify that form fields satisfy all conditions of policy and that all of fields are covered by conditions
+func (p *postPolicy) check(bucketName string, fields map[string]string) error {
+	covered := make(map[string]bool, len(p.conditions))
+	for _, c := range p.conditions {
+		value := fields[c.field]
+		if c.field == "bucket" {
+			value = bucketName
+		}
+
+		if (c.op == "eq" && value != c.value) || (c.op == "starts-with" && !strings.HasPrefix(value, c.value)) {
+			return fmt.Errorf("policy condition failed: %s", c.field)
+		}
+		covered[c.field] = true
+	}
+
+	for field := range fields {
+		if postPolicyFields[field] || strings.HasPrefix(field, "x-ignore-") || covered[field] {
+			continue
+		}
+
+		return fmt.Errorf("extra input field: %s", field)
+	}
+
+	return nil
+}
+
+// verifyPostPolicy check signature and expiration of policy and that form satisfy its conditions
+func verifyPostPolicy(bucket config.Bucket, bucketName string, fields map[string]string, now time.Time) (*postPolicy, error) {
+	if fields["policy"] == "" {
+		return nil, errPolicyMissing
+	}
+
+	if err := verifyPostSignature(bucket, fields); err != nil {
+		return nil, err
+	}
+
+	policy, err := parsePostPolicy(fields["policy"])
+	if err != nil {
+		return nil, err
+	}
+
+	if now.After(policy.expiration) {
+		return nil, errPolicyExpired
+	}
+
+	return policy, policy.check(bucketName, fields)
+}
+
+// verifyPostSignature check signature of policy in AWS v4 or v2 form
+func verifyPostSignature(bucket config.Bucket, fields map[string]string) error {
+	var expected, signature string
+	if fields["x-amz-algorithm"] == postAlgorithmV4 {
+		credential := strings.Split(fields["x-amz-credential"], "/")
+		if len(credential) != 5 || credential[3] != "s3" || credential[4] != "aws4_request" {
+			return errPolicySignature
+		}
+
+		secret := bucketSecret(bucket, credential[0])
+		if secret == "" {
+			return errPolicySignature
+		}
+
+		key := postSigningKey(secret, credential[1], credential[2])
+		expected = hex.EncodeToString(hmacSum(sha256.New, key, fields["policy"]))
+		signature = fields["x-amz-signature"]
+	} else {
+		secret := bucketSecret(bucket, fields["awsaccesskeyid"])
+		if secret == "" {
+			return errPolicySignature
+		}
+
+		expected = base64.StdEncoding.EncodeToString(hmacSum(sha1.New, []byte(secret), fields["policy"]))
+		signature = fields["signature"]
+	}
+
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(signature)) != 1 {
+		return errPolicySignature
+	}
+
+	return nil
+}
+
+// bucketSecret returns secret of access key of bucket, empty string is returned when bucket doesn't have the key
+func bucketSecret(bucket config.Bucket, accessKey string) string {
+	if accessKey == "" {
+		return ""
+	}
+
+	for _, key := range bucket.ResolvedKeys() {
+		if key.AccessKey == accessKey {
+			return key.SecretAccessKey
+		}
+	}
+
+	return ""
+}
+
+// postSigningKey derive AWS v4 signing key for S3 in given day and region
+func postSigningKey(secret, date, region string) []byte {
+	key := hmacSum(sha256.New, []byte("AWS4"+secret), date)
+	key = hmacSum(sha256.New, key, region)
+	key = hmacSum(sha256.New, key, "s3")
+	return hmacSum(sha256.New, key, "aws4_request")
+}
+
+// hmacSum returns HMAC of data with given key
+func hmacSum(h func() hash.Hash, key []byte, data string) []byte {
+	mac := hmac.New(h, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
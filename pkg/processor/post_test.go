@@ -0,0 +1,105 @@
+package processor
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aldor007/mort/pkg/config"
+	"github.com/aldor007/mort/pkg/lock"
+	"github.com/aldor007/mort/pkg/object"
+	"github.com/aldor007/mort/pkg/throttler"
+	"github.com/stretchr/testify/assert"
+)
+
+const postTestPolicy = `{"expiration": "%s", "conditions": [
+	{"bucket": "local"},
+	["starts-with", "$key", "post/"],
+	["content-length-range", 1, 10],
+	{"x-amz-algorithm": "AWS4-HMAC-SHA256"},
+	{"x-amz-credential": "acc/20300101/mort/s3/aws4_request"},
+	{"x-amz-date": "20300101T000000Z"},
+	["starts-with", "$Content-Type", "text/"]
+]}`
+
+func postRequest(t *testing.T, key, secret, body string) (*http.Request, *object.FileObject) {
+	policy := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf(postTestPolicy, time.Now().Add(time.Hour).UTC().Format(time.RFC3339))))
+	signature := hex.EncodeToString(hmacSum(sha256.New, postSigningKey(secret, "20300101", "mort"), policy))
+
+	form := bytes.Buffer{}
+	writer := multipart.NewWriter(&form)
+	writer.WriteField("key", key)
+	writer.WriteField("Content-Type", "text/plain")
+	writer.WriteField("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	writer.WriteField("X-Amz-Credential", "acc/20300101/mort/s3/aws4_request")
+	writer.WriteField("X-Amz-Date", "20300101T000000Z")
+	writer.WriteField("Policy", policy)
+	writer.WriteField("X-Amz-Signature", signature)
+	file, err := writer.CreateFormFile("file", "test.txt")
+	assert.Nil(t, err)
+	file.Write([]byte(body))
+	writer.Close()
+
+	req, _ := http.NewRequest("POST", "http://mort/local", &form)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	obj, err := object.NewFileObject(req.URL, config.GetInstance())
+	assert.Nil(t, err)
+	return req, obj
+}
+
+func TestHandlePOST(t *testing.T) {
+	mortConfig := config.GetInstance()
+	err := mortConfig.Load("./benchmark/small.yml")
+	assert.Nil(t, err)
+
+	bucket := mortConfig.Buckets["local"]
+	bucket.Keys = []config.S3Key{{AccessKey: "acc", SecretAccessKey: "sec"}}
+	mortConfig.Buckets["local"] = bucket
+	defer func() {
+		bucket.Keys = nil
+		mortConfig.Buckets["local"] = bucket
+	}()
+
+	rp := NewRequestProcessor(mortConfig.Server, lock.NewMemoryLock(), throttler.NewBucketThrottler(10))
+
+	req, obj := postRequest(t, "post/${filename}", "sec", "post-data")
+	res := rp.Process(req, obj)
+	assert.Equal(t, 204, res.StatusCode)
+
+	req, _ = http.NewRequest("GET", "http://mort/local/post/test.txt", nil)
+	obj, err = object.NewFileObject(req.URL, mortConfig)
+	assert.Nil(t, err)
+	res = rp.Process(req, obj)
+	assert.Equal(t, 200, res.StatusCode)
+	body, err := res.Body()
+	assert.Nil(t, err)
+	assert.Equal(t, "post-data", string(body))
+
+	req, _ = http.NewRequest("DELETE", "http://mort/local/post/test.txt", nil)
+	obj, err = object.NewFileObject(req.URL, mortConfig)
+	assert.Nil(t, err)
+	rp.Process(req, obj)
+
+	req, obj = postRequest(t, "post/invalid.txt", "invalid", "post-data")
+	res = rp.Process(req, obj)
+	assert.Equal(t, 403, res.StatusCode)
+
+	req, obj = postRequest(t, "other/test.txt", "sec", "post-data")
+	res = rp.Process(req, obj)
+	assert.Equal(t, 403, res.StatusCode)
+
+	req, obj = postRequest(t, "post/large.txt", "sec", "too-large-post-data")
+	res = rp.Process(req, obj)
+	assert.Equal(t, 400, res.StatusCode)
+}
+
+func TestPostFileLimit(t *testing.T) {
+	assert.Equal(t, int64(maxPostFileSize), postFileLimit(config.Bucket{}), "uploads without content-length-range should be limited")
+	assert.Equal(t, int64(100), postFileLimit(config.Bucket{Upload: &config.UploadPolicy{MaxSize: 100}}))
+}
@@ -0,0 +1,74 @@
+package processor
+
+import (
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/aldor007/mort/pkg/config"
+	"github.com/aldor007/mort/pkg/helpers"
+	"github.com/aldor007/mort/pkg/monitoring"
+	"github.com/aldor007/mort/pkg/object"
+	"github.com/aldor007/mort/pkg/response"
+	"github.com/aldor007/mort/pkg/storage"
+)
+
+// precompressedEncodings returns encodings of sidecar objects of bucket accepted by client, in order of preference of bucket
+// Only whole originals are served from sidecars
+func precompressedEncodings(req *http.Request, obj *object.FileObject) []string {
+	bucket, ok := config.GetInstance().Buckets[obj.Bucket]
+	if !ok || len(bucket.Precompressed) == 0 || obj.HasTransform() || obj.Range != "" || obj.Meta || obj.Palette != 0 {
+		return nil
+	}
+
+	acceptEnc := req.Header.Get("Accept-Encoding")
+	if acceptEnc == "" {
+		return nil
+	}
+
+	var encodings []string
+	for _, encoding := range bucket.Precompressed {
+		if helpers.AcceptsEncoding(acceptEnc, encoding) {
+			encodings = append(encodings, encoding)
+		}
+	}
+
+	return encodings
+}
+
+// precompressedVary returns part of cache key for responses of original which can be served from sidecars
+func precompressedVary(encodings []string) string {
+	return "encoding=" + strings.Join(encodings, ",")
+}
+
+// getPrecompressed returns the first existing sidecar object accepted by client, nil when there isn't any
+func (r *RequestProcessor) getPrecompressed(req *http.Request, obj *object.FileObject) *response.Response {
+	for _, encoding := range precompressedEncodings(req, obj) {
+		sidecar := obj.Copy()
+		sidecar.Ctx = obj.Ctx
+		sidecar.UpdateKey(config.PrecompressedExtensions[encoding])
+		res := r.withStorageTimeout(sidecar, func() *response.Response {
+			return storage.Get(sidecar)
+		})
+		if res.StatusCode != 200 {
+			res.Close()
+			if isTimeout(res) {
+				// storage is slow, so original is fetched without trying other sidecars
+				return nil
+			}
+			continue
+		}
+
+		monitoring.Report().Inc("precompressed_count;bucket:" + obj.Bucket + ",encoding:" + encoding)
+		res.Set("Content-Encoding", encoding)
+		res.Headers.Del("Content-Length")
+		if contentType := mime.TypeByExtension(path.Ext(obj.Key)); contentType != "" {
+			res.SetContentType(contentType)
+		}
+		res.Headers.Add("Vary", "Accept-Encoding")
+		return res
+	}
+
+	return nil
+}
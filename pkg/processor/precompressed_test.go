@@ -0,0 +1,69 @@
+package processor
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/aldor007/mort/pkg/config"
+	"github.com/aldor007/mort/pkg/lock"
+	"github.com/aldor007/mort/pkg/object"
+	"github.com/aldor007/mort/pkg/throttler"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrecompressedEncodings(t *testing.T) {
+	mortConfig := config.GetInstance()
+	err := mortConfig.Load("./benchmark/small.yml")
+	assert.Nil(t, err)
+
+	bucket := mortConfig.Buckets["local"]
+	bucket.Precompressed = []string{"br", "gzip"}
+	mortConfig.Buckets["local"] = bucket
+	defer func() {
+		bucket.Precompressed = nil
+		mortConfig.Buckets["local"] = bucket
+	}()
+
+	req, _ := http.NewRequest("GET", "http://mort/local/small.jpg", nil)
+	obj, err := object.NewFileObject(req.URL, mortConfig)
+	assert.Nil(t, err)
+	assert.Nil(t, precompressedEncodings(req, obj), "client without Accept-Encoding should get original")
+
+	req.Header.Set("Accept-Encoding", "gzip, deflate, br")
+	assert.Equal(t, []string{"br", "gzip"}, precompressedEncodings(req, obj))
+	assert.Equal(t, "encoding=br,gzip", precompressedVary(precompressedEncodings(req, obj)))
+
+	req.Header.Set("Accept-Encoding", "gzip, br;q=0")
+	assert.Equal(t, []string{"gzip"}, precompressedEncodings(req, obj))
+
+	obj.Range = "bytes=0-10"
+	assert.Nil(t, precompressedEncodings(req, obj), "range requests should get original")
+
+	req, _ = http.NewRequest("GET", "http://mort/local/small.jpg-small", nil)
+	req.Header.Set("Accept-Encoding", "br")
+	obj, err = object.NewFileObject(req.URL, mortConfig)
+	assert.Nil(t, err)
+	assert.Nil(t, precompressedEncodings(req, obj), "transforms should not use sidecars")
+}
+
+func TestGetPrecompressedMissingSidecar(t *testing.T) {
+	mortConfig := config.GetInstance()
+	err := mortConfig.Load("./benchmark/small.yml")
+	assert.Nil(t, err)
+
+	bucket := mortConfig.Buckets["local"]
+	bucket.Precompressed = []string{"br"}
+	mortConfig.Buckets["local"] = bucket
+	defer func() {
+		bucket.Precompressed = nil
+		mortConfig.Buckets["local"] = bucket
+	}()
+
+	req, _ := http.NewRequest("GET", "http://mort/local/small.jpg", nil)
+	req.Header.Set("Accept-Encoding", "br")
+	obj, err := object.NewFileObject(req.URL, mortConfig)
+	assert.Nil(t, err)
+
+	rp := NewRequestProcessor(mortConfig.Server, lock.NewMemoryLock(), throttler.NewBucketThrottler(10))
+	assert.Nil(t, rp.getPrecompressed(req, obj), "original should be served when sidecar doesn't exist")
+}
@@ -1,36 +1,77 @@
 package processor
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
+	"image"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"os"
+	"path"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/aldor007/mort/pkg/antivirus"
 	"github.com/aldor007/mort/pkg/cache"
 
 	"github.com/aldor007/mort/pkg/config"
 	"github.com/aldor007/mort/pkg/engine"
+	"github.com/aldor007/mort/pkg/lifecycle"
 	"github.com/aldor007/mort/pkg/lock"
 	"github.com/aldor007/mort/pkg/middleware"
 	"github.com/aldor007/mort/pkg/monitoring"
 	"github.com/aldor007/mort/pkg/object"
+	"github.com/aldor007/mort/pkg/phash"
 	"github.com/aldor007/mort/pkg/processor/plugins"
 	"github.com/aldor007/mort/pkg/response"
 	"github.com/aldor007/mort/pkg/storage"
 	"github.com/aldor007/mort/pkg/throttler"
 	"github.com/aldor007/mort/pkg/transforms"
+	"github.com/aldor007/mort/pkg/trash"
+	"github.com/aldor007/mort/pkg/worker"
 	"go.uber.org/zap"
 )
 
 const s3LocationStr = "<?xml version=\"1.0\" encoding=\"UTF-8\"?><LocationConstraint xmlns=\"http://s3.amazonaws.com/doc/2006-03-01/\">EU</LocationConstraint>"
 
+const (
+	defaultSimilarDistance = 10 // default max hamming distance of similar images
+	defaultSimilarLimit    = 10 // default max number of similar images in response
+)
+
+// maxPHashSize max size of image which is read to memory for computing perceptual hash
+const maxPHashSize = 64 << 20
+
+// HeaderPriority header in which client marks its request as batch (e.g. pre-generation of images)
+const HeaderPriority = "X-Mort-Priority"
+
 var (
 	errTimeout       = errors.New("timeout")         // error when timeout
 	errContextCancel = errors.New("context timeout") // error when context timeout
 	errThrottled     = errors.New("throttled")       // error when request throttled
+	errQuarantined   = errors.New("quarantined")     // error when object was quarantined by moderation
+
+	errScanTooLarge  = errors.New("upload exceeds max size of scanned uploads")     // error when upload of unknown length is too big to scan
+	errPHashTooLarge = errors.New("image is too big for computing perceptual hash") // error when image without stored hash is too big to read to memory
+
+	errCompositeTransform = errors.New("composite image can't be transformed") // error when composite key points to transformed object
+	errCompositeNotFound  = errors.New("composite image not found")            // error when composite image can't be fetched
+	errCompositeKey       = errors.New("invalid composite image key")          // error when composite key points outside of bucket
 )
 
+// pending tracks background writes to storage and response cache
+var pending sync.WaitGroup
+
+// WaitPending blocks until all background writes to storage and response cache are finished
+func WaitPending() {
+	pending.Wait()
+}
+
 // NewRequestProcessor create instance of request processor
 // It main component of mort it handle all of requests
 func NewRequestProcessor(serverConfig config.Server, l lock.Lock, throttler throttler.Throttler) RequestProcessor {
@@ -39,21 +80,46 @@ func NewRequestProcessor(serverConfig config.Server, l lock.Lock, throttler thro
 	rp.throttler = throttler
 	rp.processTimeout = time.Duration(serverConfig.RequestTimeout) * time.Second
 	rp.lockTimeout = time.Duration(serverConfig.LockTimeout) * time.Second
+	rp.storageTimeout = time.Duration(serverConfig.Timeouts.Storage) * time.Second
+	rp.transformTimeout = time.Duration(serverConfig.Timeouts.Transform) * time.Second
+	rp.clientCloseStatus = serverConfig.ClientClose.StatusCode
+	if rp.clientCloseStatus == 0 {
+		rp.clientCloseStatus = 499
+	}
+	rp.continueOnClose = serverConfig.ClientClose.Continue
 	rp.serverConfig = serverConfig
 	rp.plugins = plugins.NewPluginsManager(serverConfig.Plugins)
 	rp.responseCache = cache.Create(serverConfig.Cache)
+	rp.hashIndex = newHashIndex(serverConfig.PHashIndexFile)
+	rp.scanner = antivirus.New(serverConfig.Antivirus)
+	rp.sizeHints = newSizeHints()
+	rp.pools = newBucketPools()
+	rp.workers = worker.NewPool(serverConfig.Workers)
+	rp.quarantine = newQuarantine(serverConfig.Quarantine)
+	rp.bandwidth = newBandwidthLimiters(serverConfig.Bandwidth)
 	return rp
 }
 
 // RequestProcessor handle incoming requests
 type RequestProcessor struct {
-	collapse       lock.Lock              // interface used for request collapsing
-	throttler      throttler.Throttler    // interface used for rate limiting creating of new images
-	processTimeout time.Duration          // request processing timeout
-	lockTimeout    time.Duration          // lock timeout for collapsed request it equal processTimeout - 1 s
-	plugins        plugins.PluginsManager // plugins run plugins before some phases of requests processing
-	serverConfig   config.Server
-	responseCache  cache.ResponseCache
+	collapse          lock.Lock              // interface used for request collapsing
+	throttler         throttler.Throttler    // interface used for rate limiting creating of new images
+	processTimeout    time.Duration          // request processing timeout
+	lockTimeout       time.Duration          // lock timeout for collapsed request it equal processTimeout - 1 s
+	storageTimeout    time.Duration          // timeout of single storage operation, 0 - limited only by processTimeout
+	transformTimeout  time.Duration          // timeout of processing image by engine, 0 - limited only by processTimeout
+	clientCloseStatus int                    // status code of response when client closed connection
+	continueOnClose   bool                   // continue processing of request when client closed connection
+	plugins           plugins.PluginsManager // plugins run plugins before some phases of requests processing
+	serverConfig      config.Server
+	responseCache     cache.ResponseCache
+	hashIndex         phash.Index        // perceptual hashes of originals used for finding similar images
+	scanner           *antivirus.Clamd   // antivirus scanner of uploads, nil when disabled
+	sizeHints         *sizeHints         // last known sizes of originals used for collapsing
+	pools             *bucketPools       // pools of transforms of buckets, used before throttler
+	workers           *worker.Pool       // worker processes performing transforms, nil when they are performed in mort process
+	quarantine        *quarantine        // source images which repeatedly crashed engine, nil when disabled
+	bandwidth         *bandwidthLimiters // limits of bandwidth used for sending responses, global and of buckets
 }
 
 type requestMessage struct {
@@ -61,29 +127,75 @@ type requestMessage struct {
 	obj          *object.FileObject
 	request      *http.Request
 	cancel       chan struct{}
+	timeout      context.CancelFunc // release resources of context of processing, called when processing is finished
 }
 
 // Process handle incoming request and create response
 func (r *RequestProcessor) Process(req *http.Request, obj *object.FileObject) *response.Response {
 	pCtx := req.Context()
-	ctx, timeout := context.WithTimeout(pCtx, r.processTimeout)
+	if req.Header.Get(HeaderPriority) != "" {
+		// client can only lower priority of its request, interactive is default
+		pCtx = throttler.WithPriority(pCtx, throttler.ParsePriority(req.Header.Get(HeaderPriority)))
+	}
+	procCtx := pCtx
+	var clientDone <-chan struct{}
+	if r.continueOnClose {
+		// processing isn't canceled when client closes connection
+		procCtx = detachContext(pCtx)
+		clientDone = pCtx.Done()
+	}
+	ctx, timeout := context.WithTimeout(procCtx, r.processTimeout)
 	obj.FillWithRequest(req, ctx)
-	defer timeout()
+	obj.Vary = cache.VaryKey(req, r.serverConfig.Cache.Vary)
+	continued := false
+	defer func() {
+		if !continued {
+			timeout()
+		}
+	}()
 	r.plugins.PreProcess(obj, req)
+
 	msg := requestMessage{}
 	msg.request = req
 	msg.obj = obj
 	msg.responseChan = make(chan *response.Response)
 	msg.cancel = make(chan struct{}, 1)
+	msg.timeout = timeout
 
 	go r.processChan(ctx, msg)
 
 	select {
 	case <-ctx.Done():
 		close(msg.cancel)
-		monitoring.Log().Warn("Process timeout", obj.LogData(zap.String("error", "Context.timeout"))...)
-		return r.replyWithError(obj, 499, errContextCancel)
+		var res *response.Response
+		if pCtx.Err() == nil {
+			// client is still waiting, so it is request timeout not client cancel
+			res = r.timeoutResponse(obj, stageRequest, 504, errTimeout)
+		} else {
+			monitoring.Log().Warn("Process timeout", obj.LogData(zap.String("error", "Context.timeout"))...)
+			res = r.replyWithError(obj, r.clientCloseStatus, errContextCancel)
+		}
+		r.plugins.OnError(obj, req, res)
+		return res
+	case <-clientDone:
+		// result of processing is stored and cached, but it isn't sent to client
+		continued = true
+		close(msg.cancel)
+		monitoring.Log().Info("Client closed connection, processing continues in background", obj.LogData()...)
+		monitoring.Report().Inc("client_close_continued_count")
+		res := r.replyWithError(obj, r.clientCloseStatus, errContextCancel)
+		r.plugins.OnError(obj, req, res)
+		return res
 	case res := <-msg.responseChan:
+		if len(r.serverConfig.Cache.Vary) != 0 && (req.Method == "GET" || req.Method == "HEAD") {
+			cache.AddVary(res, r.serverConfig.Cache.Vary)
+		}
+		if res.StatusCode >= 400 {
+			r.plugins.OnError(obj, req, res)
+		}
+		if obj.Preset != "" && !obj.DebugPlan && req.Method == "GET" && res.StatusCode == 200 {
+			reportPresetUsage(obj, res)
+		}
 		r.plugins.PostProcess(obj, req, res)
 		return res
 	}
@@ -91,7 +203,8 @@ func (r *RequestProcessor) Process(req *http.Request, obj *object.FileObject) *r
 }
 
 func (r *RequestProcessor) processChan(ctx context.Context, msg requestMessage) {
-	res := r.process(msg.request, msg.obj)
+	defer msg.timeout()
+	res := r.errorDocument(msg.request, msg.obj, r.process(msg.request, msg.obj))
 	select {
 	case <-msg.cancel:
 		res.Close()
@@ -127,8 +240,12 @@ func (r *RequestProcessor) replyWithError(obj *object.FileObject, sc int, err er
 			parent := response.NewBuf(200, r.serverConfig.Placeholder.Buf)
 			transformsTab := []transforms.Transforms{obj.Transforms}
 
-			eng := engine.NewImageEngine(parent)
-			res, err := eng.Process(obj, transformsTab)
+			eng, err := engine.New(engine.DefaultEngine, parent)
+			if err != nil {
+				return
+			}
+
+			res, err := processSafe(eng, obj, transformsTab)
 			if err == nil {
 				res.StatusCode = sc
 				r.responseCache.Set(errorObject, updateHeaders(errorObject, res))
@@ -145,30 +262,135 @@ func (r *RequestProcessor) replyWithError(obj *object.FileObject, sc int, err er
 }
 
 func (r *RequestProcessor) process(req *http.Request, obj *object.FileObject) *response.Response {
+	if !isMethodAllowed(obj, req.Method) {
+		return methodNotAllowed(obj)
+	}
+
+	if bucket, ok := config.GetInstance().Buckets[obj.Bucket]; ok && isTrashed(bucket, obj) {
+		return response.NewNoContent(404)
+	}
+
+	hotlink := hotlinkRule(obj)
+	if res := checkAccess(req, obj, hotlink); res != nil {
+		return res
+	}
+
+	if obj.DebugPlan {
+		return debugPlan(obj)
+	}
 
 	switch req.Method {
+	case "OPTIONS":
+		return handleOPTIONS(obj)
 	case "GET", "HEAD":
+		website := websiteConfig(req, obj)
+		if website != nil {
+			if obj.Key == "" {
+				return websiteRoot(req, obj)
+			}
+
+			if res := websiteRedirect(req, obj, website, 0); res != nil {
+				return res
+			}
+		}
+
 		if obj.Key == "" {
 			return handleS3Get(req, obj)
 		}
 
+		index := indexConfig(obj)
+		if index != nil && strings.HasSuffix(obj.Key, "/") {
+			if res := r.resolveIndex(obj, index); res != nil {
+				return res
+			}
+		}
+
+		if obj.Similar {
+			return r.handleSimilar(req, obj)
+		}
+
+		if obj.Meta || obj.Palette != 0 {
+			// metadata and palette are computed from whole image
+			obj.Range = ""
+		}
+
+		if encodings := precompressedEncodings(req, obj); len(encodings) != 0 {
+			// sidecars accepted by client change response
+			if obj.Vary != "" {
+				obj.Vary += "&"
+			}
+			obj.Vary += precompressedVary(encodings)
+		}
+
+		if r.isParentQuarantined(obj) {
+			return r.quarantineResponse()
+		}
+
+		flags := middleware.FeatureFlagsFromContext(obj.Ctx)
+		// forced render would be answered with cached result of previous one
+		forceRender := flags.Has(middleware.FlagForceRender) && obj.HasTransform()
 		// todo Cache layer should be protected by memory lock.
-		res, err := r.responseCache.Get(obj)
-		if err == nil {
-			return res
+		if !flags.Has(middleware.FlagBypassCache) && !forceRender && obj.Profile == "" {
+			lookupStart := time.Now()
+			res, err := r.responseCache.Get(obj)
+			monitoring.StagesFromContext(obj.Ctx).Since(monitoring.StageCacheLookup, lookupStart)
+			if err == nil {
+				lifecycle.Touch(obj)
+				return res
+			}
 		}
 
-		if obj.HasTransform() {
+		var res *response.Response
+		if obj.Profile != "" {
+			// profiled transform isn't shared with other requests
+			res = updateHeaders(obj, r.handleGET(req, obj))
+		} else if obj.HasTransform() {
 			res = updateHeaders(obj, r.collapseGET(req, obj))
+		} else if r.collapseOriginal(obj) {
+			res = updateHeaders(obj, r.collapseGET(req, obj))
+			r.indexHash(obj, res)
 		} else {
 			res = updateHeaders(obj, r.handleGET(req, obj))
+			r.sizeHints.observe(collapseKey(obj), res)
+			r.indexHash(obj, res)
+		}
+
+		if obj.Meta {
+			res = updateHeaders(obj, metadataResponse(obj, res))
+		} else if obj.Palette != 0 {
+			res = updateHeaders(obj, paletteResponse(obj, res))
+		}
+
+		if res.StatusCode == 404 && index != nil && !strings.HasSuffix(obj.Key, "/") {
+			if redirect := r.directoryRedirect(req, obj, index); redirect != nil {
+				res.Close()
+				res = updateHeaders(obj, redirect)
+			}
+		}
+
+		if res.StatusCode >= 400 && website != nil {
+			if redirect := websiteRedirect(req, obj, website, res.StatusCode); redirect != nil {
+				res.Close()
+				res = updateHeaders(obj, redirect)
+			}
+		}
+
+		if hotlink != nil {
+			cache.AddVary(res, hotlinkVary)
 		}
+		setCacheStatus(res)
 
-		if res.IsCacheable() && res.ContentLength != -1 && res.ContentLength < r.serverConfig.Cache.MaxCacheItemSize {
-			resCpy, err := res.Copy()
+		negativeTTL := negativeCacheTTL(obj, res)
+		if !flags.Has(middleware.FlagBypassCache) && obj.Profile == "" && (res.IsCacheable() || negativeTTL > 0) && !res.IsFile() && res.ContentLength != -1 && res.ContentLength < r.serverConfig.Cache.MaxCacheItemSize {
+			resCpy, err := res.Share()
 			objCpy := obj.Copy()
 			if err == nil {
+				if negativeTTL > 0 {
+					resCpy.SetTTL(negativeTTL)
+				}
+				pending.Add(1)
 				go func() {
+					defer pending.Done()
 					resCpy.Body()
 					err = r.responseCache.Set(objCpy, resCpy)
 					if err != nil {
@@ -180,49 +402,429 @@ func (r *RequestProcessor) process(req *http.Request, obj *object.FileObject) *r
 
 		return res
 	case "PUT":
-		go r.responseCache.Delete(obj)
-		return handlePUT(req, obj)
+		go r.deleteCachedResponses(obj)
+		if isMetadataUpdate(req) {
+			res := r.handleMetadataUpdate(req, obj)
+			r.deleteCachedResponses(obj)
+			return res
+		}
+
+		res := r.handlePUT(req, obj)
+		if res.StatusCode == 200 {
+			// negative cache entry could be created while upload was in progress
+			r.deleteCachedResponses(obj)
+			r.postUpload(obj)
+		}
+		return res
+	case "POST":
+		if isBatchDelete(req, obj) {
+			return r.handleBatchDelete(req, obj)
+		}
+		return r.handlePOST(req, obj)
 	case "DELETE":
-		go r.responseCache.Delete(obj)
-		return storage.Delete(obj)
+		return r.handleDELETE(obj)
 
 	default:
-		return response.NewError(405, errors.New("method not allowed"))
+		return methodNotAllowed(obj)
+	}
+
+}
+
+// reportPresetUsage record request for image transformed by preset and size of response
+func reportPresetUsage(obj *object.FileObject, res *response.Response) {
+	labels := "bucket:" + obj.Bucket + ",preset:" + obj.Preset
+	monitoring.Report().Inc("preset_request_count;" + labels)
+	if res.ContentLength >= 0 {
+		monitoring.Report().Histogram("preset_response_size;"+labels, float64(res.ContentLength))
+	}
+}
+
+// negativeCacheTTL returns time in seconds for which not found response should be cached, 0 when it shouldn't be cached
+func negativeCacheTTL(obj *object.FileObject, res *response.Response) int {
+	if res.StatusCode != 404 && res.StatusCode != 403 {
+		return 0
 	}
 
+	bucket, ok := config.GetInstance().Buckets[obj.Bucket]
+	if !ok {
+		return 0
+	}
+
+	return bucket.NegativeCacheTTL
 }
 
-func handlePUT(req *http.Request, obj *object.FileObject) *response.Response {
+func (r *RequestProcessor) handlePUT(req *http.Request, obj *object.FileObject) *response.Response {
 	defer req.Body.Close()
-	return storage.Set(obj, req.Header, req.ContentLength, req.Body)
+	if errRes := validateUpload(req, obj); errRes != nil {
+		return errRes
+	}
+
+	stripChecksumMeta(req.Header)
+	contentType := req.Header.Get("Content-Type")
+	scan := r.scanner != nil && r.scanner.ShouldScan(contentType, req.ContentLength)
+	bucket, ok := config.GetInstance().Buckets[obj.Bucket]
+	computeHash := ok && bucket.PHash && strings.HasPrefix(contentType, "image/")
+	verify := hasChecksum(req.Header)
+	if !scan && !computeHash {
+		if verify {
+			return r.putVerified(req, obj)
+		}
+		return storage.Set(obj, req.Header, req.ContentLength, req.Body)
+	}
+
+	var buf []byte
+	var err error
+	if scan {
+		var errRes *response.Response
+		buf, errRes = r.scanUpload(req, obj)
+		if errRes != nil {
+			return errRes
+		}
+	} else {
+		buf, err = ioutil.ReadAll(io.LimitReader(req.Body, maxPHashSize+1))
+		if err != nil {
+			return response.NewError(400, err)
+		}
+
+		if len(buf) > maxPHashSize {
+			// image is too big to be hashed in memory, it is streamed to storage without hash
+			monitoring.Log().Warn("Processor/handlePUT upload too big for perceptual hash", obj.LogData(zap.Int64("size", req.ContentLength))...)
+			req.Body = ioutil.NopCloser(io.MultiReader(bytes.NewReader(buf), req.Body))
+			if verify {
+				return r.putVerified(req, obj)
+			}
+			return storage.Set(obj, req.Header, req.ContentLength, req.Body)
+		}
+	}
+
+	if verify {
+		if errRes := verifyChecksums(req.Header, buf); errRes != nil {
+			monitoring.Log().Warn("Processor/handlePUT checksum mismatch", obj.LogData()...)
+			return errRes
+		}
+	}
+
+	if !computeHash {
+		return withChecksumETag(req, storage.Set(obj, req.Header, int64(len(buf)), bytes.NewReader(buf)))
+	}
+
+	// perceptual hash is computed before upload and stored in object metadata
+	hash, err := engine.PHash(buf)
+	if err != nil {
+		monitoring.Log().Warn("Processor/handlePUT unable to compute perceptual hash", obj.LogData(zap.Error(err))...)
+		return withChecksumETag(req, storage.Set(obj, req.Header, int64(len(buf)), bytes.NewReader(buf)))
+	}
+
+	req.Header.Set(phash.HeaderPHash, phash.Format(hash))
+	res := storage.Set(obj, req.Header, int64(len(buf)), bytes.NewReader(buf))
+	if res.StatusCode == 200 {
+		r.hashIndex.Add(obj.Bucket, obj.Key, hash)
+	}
+
+	return withChecksumETag(req, res)
+}
+
+// putVerified spools upload with checksums to temporary file and stores it only when body matches checksums
+// Storage never gets body which doesn't match, so previous version of object is kept
+func (r *RequestProcessor) putVerified(req *http.Request, obj *object.FileObject) *response.Response {
+	tmp, err := ioutil.TempFile("", "mort-upload-")
+	if err != nil {
+		return response.NewError(500, err)
+	}
+	defer func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}()
+
+	body := newChecksumReader(req.Header, req.Body)
+	size, err := io.Copy(tmp, body)
+	if body.mismatch != nil {
+		monitoring.Log().Warn("Processor/handlePUT checksum mismatch", obj.LogData()...)
+		return body.mismatch
+	}
+	if err != nil {
+		return response.NewError(400, err)
+	}
+
+	if _, err = tmp.Seek(0, io.SeekStart); err != nil {
+		return response.NewError(500, err)
+	}
+
+	setChecksumMeta(req.Header)
+	return withChecksumETag(req, storage.Set(obj, req.Header, size, tmp))
+}
+
+// scanUpload stream upload through antivirus and returns its body when it is clean
+func (r *RequestProcessor) scanUpload(req *http.Request, obj *object.FileObject) ([]byte, *response.Response) {
+	body := bytes.Buffer{}
+	var reader io.Reader = req.Body
+	maxSize := r.scanner.MaxSize()
+	if maxSize > 0 {
+		// upload of unknown length is buffered only up to limit
+		reader = io.LimitReader(req.Body, maxSize+1)
+	}
+
+	result, err := r.scanner.Scan(obj.Ctx, io.TeeReader(reader, &body))
+	if err == nil && maxSize > 0 && int64(body.Len()) > maxSize {
+		monitoring.Log().Warn("Processor/scanUpload upload exceeds max size of scanned uploads", obj.LogData(zap.Int64("maxSize", maxSize))...)
+		return nil, response.NewError(413, errScanTooLarge)
+	}
+
+	if err != nil {
+		monitoring.Report().Inc("antivirus_count;result:error")
+		monitoring.Log().Warn("Processor/scanUpload unable to scan upload", obj.LogData(zap.Error(err))...)
+		if !r.scanner.FailOpen {
+			return nil, response.NewError(503, err)
+		}
+
+		// read part of upload which wasn't sent to scanner
+		if _, err = io.Copy(&body, reader); err != nil {
+			return nil, response.NewError(400, err)
+		}
+
+		if maxSize > 0 && int64(body.Len()) > maxSize {
+			return nil, response.NewError(413, errScanTooLarge)
+		}
+
+		return body.Bytes(), nil
+	}
+
+	if result.Infected {
+		monitoring.Report().Inc("antivirus_count;result:infected")
+		monitoring.Log().Warn("Processor/scanUpload infected upload", obj.LogData(zap.String("signature", result.Signature))...)
+		return nil, response.NewError(422, errors.New("infected file "+result.Signature))
+	}
+
+	monitoring.Report().Inc("antivirus_count;result:clean")
+	return body.Bytes(), nil
+}
+
+// postUpload run upload hooks of plugins in background
+func (r *RequestProcessor) postUpload(obj *object.FileObject) {
+	objCpy := obj.Copy()
+	pending.Add(1)
+	go func() {
+		defer pending.Done()
+		r.plugins.PostUpload(objCpy)
+		// plugins may change stored object
+		r.deleteCachedResponses(objCpy)
+	}()
+}
+
+// deleteCachedResponses removes from response cache object and its metadata and palettes which have own keys
+func (r *RequestProcessor) deleteCachedResponses(obj *object.FileObject) {
+	derived := *obj
+	derived.Meta, derived.Palette = false, 0
+	r.responseCache.Delete(&derived)
+
+	derived.Meta = true
+	r.responseCache.Delete(&derived)
+
+	derived.Meta = false
+	for n := 1; n <= engine.MaxPaletteColors; n++ {
+		derived.Palette = n
+		r.responseCache.Delete(&derived)
+	}
+}
+
+// handleDELETE removes object from storage and its cached responses
+func (r *RequestProcessor) handleDELETE(obj *object.FileObject) *response.Response {
+	go r.deleteCachedResponses(obj)
+	r.hashIndex.Delete(obj.Bucket, obj.Key)
+	// transformed images can be generated again, so only originals are moved to trash
+	bucket, ok := config.GetInstance().Buckets[obj.Bucket]
+	if ok && bucket.SoftDelete != nil && !obj.HasParent() {
+		return trash.Move(obj, bucket)
+	}
+
+	return storage.Delete(obj)
+}
+
+// isTrashed check if object or its parent is in trash of bucket, objects in trash are available only through trash API
+func isTrashed(bucket config.Bucket, obj *object.FileObject) bool {
+	for o := obj; o != nil; o = o.Parent {
+		if trash.IsTrashed(bucket, o.Key) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isQuarantined check if object was quarantined by moderation, requests authorized with S3 keys can access it
+func isQuarantined(obj *object.FileObject, res *response.Response) bool {
+	return plugins.IsQuarantined(res) && obj.Ctx.Value(middleware.S3AuthCtxKey) == nil
+}
+
+// isParentQuarantined check if original of transformed object was quarantined by moderation
+// Derivatives stored or cached before quarantine don't carry moderation header, so in buckets with quarantine policy original is checked on each request
+func (r *RequestProcessor) isParentQuarantined(obj *object.FileObject) bool {
+	if !obj.HasParent() || obj.Ctx.Value(middleware.S3AuthCtxKey) != nil || !plugins.QuarantinesBucket(obj.Bucket) {
+		return false
+	}
+
+	original := obj.Parent
+	for original.HasParent() {
+		original = original.Parent
+	}
+
+	res := r.withStorageTimeout(obj, func() *response.Response {
+		return storage.Head(original)
+	})
+	defer res.Close()
+	return res.StatusCode == 200 && plugins.IsQuarantined(res)
+}
+
+// quarantineResponse returns placeholder which is served instead of quarantined objects
+func (r *RequestProcessor) quarantineResponse() *response.Response {
+	monitoring.Report().Inc("request_type;type:quarantined")
+	if r.serverConfig.PlaceholderStr == "" {
+		return response.NewError(451, errQuarantined)
+	}
+
+	res := response.NewBuf(200, r.serverConfig.Placeholder.Buf)
+	res.SetContentType(r.serverConfig.Placeholder.ContentType)
+	return res
+}
+
+// newHashIndex create index of perceptual hashes, it is saved to file when path is set
+func newHashIndex(path string) phash.Index {
+	if path == "" {
+		return phash.NewMemoryIndex()
+	}
+
+	index, err := phash.NewFileIndex(path)
+	if err != nil {
+		monitoring.Log().Error("Processor unable to load index of perceptual hashes", zap.String("path", path), zap.Error(err))
+		return phash.NewMemoryIndex()
+	}
+
+	return index
+}
+
+// indexHash add perceptual hash stored in metadata of original to index
+// It fills index for originals uploaded before restart
+func (r *RequestProcessor) indexHash(obj *object.FileObject, res *response.Response) {
+	if obj.HasTransform() || res.StatusCode != 200 {
+		return
+	}
+
+	if hash, err := phash.Parse(res.Headers.Get(phash.HeaderPHash)); err == nil {
+		r.hashIndex.Add(obj.Bucket, obj.Key, hash)
+	}
+}
+
+// handleSimilar returns JSON with originals similar to requested one
+func (r *RequestProcessor) handleSimilar(req *http.Request, obj *object.FileObject) *response.Response {
+	query := req.URL.Query()
+	maxDistance, limit := defaultSimilarDistance, defaultSimilarLimit
+	if v, err := strconv.Atoi(query.Get("distance")); err == nil && v >= 0 {
+		maxDistance = v
+	}
+	if v, err := strconv.Atoi(query.Get("limit")); err == nil && v > 0 {
+		limit = v
+	}
+
+	// hash is computed from whole image
+	obj.Range = ""
+	res := r.handleGET(req, obj)
+	defer res.Close()
+	if res.StatusCode != 200 || !res.IsImage() {
+		return res
+	}
+
+	hash, err := phash.Parse(res.Headers.Get(phash.HeaderPHash))
+	if err != nil {
+		if res.ContentLength > maxPHashSize {
+			return response.NewError(413, errPHashTooLarge)
+		}
+
+		buf, err := res.Body()
+		if err != nil {
+			return response.NewError(500, err)
+		}
+
+		hash, err = engine.PHash(buf)
+		if err != nil {
+			monitoring.Log().Warn("Processor/handleSimilar unable to compute perceptual hash", obj.LogData(zap.Error(err))...)
+			return response.NewError(400, err)
+		}
+	}
+
+	if !obj.HasTransform() {
+		r.hashIndex.Add(obj.Bucket, obj.Key, hash)
+	}
+
+	matches := make([]phash.Match, 0, limit)
+	for _, m := range r.hashIndex.Nearest(obj.Bucket, hash, maxDistance, limit+1) {
+		if m.Key != obj.Key && len(matches) < limit {
+			matches = append(matches, m)
+		}
+	}
+
+	body, err := json.Marshal(map[string]interface{}{"key": obj.Key, "hash": phash.Format(hash), "matches": matches})
+	if err != nil {
+		return response.NewError(500, err)
+	}
+
+	monitoring.Report().Inc("request_type;type:similar")
+	similarRes := response.NewBuf(200, body)
+	similarRes.SetContentType("application/json")
+	// index changes with every upload
+	similarRes.Set("Cache-Control", "no-cache")
+	return similarRes
+}
+
+// setCacheStatus informs client whether response was created for its request
+// Responses from cache or of other collapsed request have status set already
+func setCacheStatus(res *response.Response) {
+	if res.Headers.Get(cache.HeaderCache) != "" {
+		return
+	}
+
+	if res.Headers.Get(storage.HeaderDiskCache) == "stale" {
+		res.Set(cache.HeaderCache, cache.StatusStale)
+	} else {
+		res.Set(cache.HeaderCache, cache.StatusMiss)
+	}
 }
 
 func (r *RequestProcessor) collapseGET(req *http.Request, obj *object.FileObject) *response.Response {
 	ctx := obj.Ctx
-	lockResult, locked := r.collapse.Lock(obj.Key)
+	key := collapseKey(obj)
+	lockResult, locked := r.collapse.Lock(key)
 	if locked {
 		monitoring.Log().Info("Lock acquired", obj.LogData()...)
 		res := r.handleGET(req, obj)
-		r.collapse.NotifyAndRelease(obj.Key, res)
+		if !obj.HasTransform() {
+			r.sizeHints.observe(key, res)
+		}
+		r.collapse.NotifyAndRelease(key, res)
 		return res
 	}
 
 	monitoring.Report().Inc("collapsed_count")
 	monitoring.Log().Info("Lock not acquired", obj.LogData()...)
+	stages := monitoring.StagesFromContext(ctx)
+	waitStart := time.Now()
 	timer := time.NewTimer(r.lockTimeout)
 
 	for {
 
 		select {
 		case <-ctx.Done():
+			stages.Since(monitoring.StageLockWait, waitStart)
 			lockResult.Cancel <- true
 			return r.replyWithError(obj, 504, errContextCancel)
 		case res, ok := <-lockResult.ResponseChan:
+			stages.Since(monitoring.StageLockWait, waitStart)
 			if !ok {
 				return r.handleGET(req, obj)
 			}
+			res.Set(cache.HeaderCache, cache.StatusCollapsed)
 			return res
 		case <-timer.C:
+			stages.Since(monitoring.StageLockWait, waitStart)
 			lockResult.Cancel <- true
 			if cacheRes, err := r.responseCache.Get(obj); err == nil {
 				return cacheRes
@@ -237,6 +839,11 @@ func (r *RequestProcessor) collapseGET(req *http.Request, obj *object.FileObject
 // nolint: gocyclo
 func (r *RequestProcessor) handleGET(req *http.Request, obj *object.FileObject) *response.Response {
 	ctx := obj.Ctx
+	r.plugins.PreStorage(obj, req)
+
+	if res := r.getPrecompressed(req, obj); res != nil {
+		return res
+	}
 
 	currObj := obj
 	var parentObj *object.FileObject
@@ -256,11 +863,23 @@ func (r *RequestProcessor) handleGET(req *http.Request, obj *object.FileObject)
 		}
 	}
 
+	if parentObj != nil && obj.HasTransform() && (middleware.FeatureFlagsFromContext(ctx).Has(middleware.FlagForceRender) || obj.Profile != "") {
+		monitoring.Log().Info("Force render requested", obj.LogData()...)
+		if obj.CheckParent {
+			parentRes = r.withStorageTimeout(obj, func() *response.Response {
+				return storage.Head(parentObj)
+			})
+		}
+		return r.handleNotFound(obj, parentObj, transformsTab, parentRes, response.NewNoContent(404))
+	}
+
 	resChan := make(chan *response.Response, 1)
 	parentChan := make(chan *response.Response, 1)
 
 	go func(o *object.FileObject) {
-		resp := storage.Get(o)
+		resp := r.withStorageTimeout(o, func() *response.Response {
+			return storage.Get(o)
+		})
 		// Ensure before passing the response that the context is not canceled.
 		// In such case Close the response.
 		// Passing the data to respChan and checking ctx.Done cannot be
@@ -286,7 +905,9 @@ func (r *RequestProcessor) handleGET(req *http.Request, obj *object.FileObject)
 			select {
 			case <-ctx.Done():
 				return
-			case parentChan <- storage.Head(p):
+			case parentChan <- r.withStorageTimeout(p, func() *response.Response {
+				return storage.Head(p)
+			}):
 				return
 			}
 		}(parentObj)
@@ -295,7 +916,7 @@ func (r *RequestProcessor) handleGET(req *http.Request, obj *object.FileObject)
 	for {
 		select {
 		case <-ctx.Done():
-			return r.replyWithError(obj, 499, errContextCancel)
+			return r.replyWithError(obj, r.clientCloseStatus, errContextCancel)
 		case res = <-resChan:
 			if obj.CheckParent && parentObj != nil && (parentRes == nil || parentRes.StatusCode == 0) {
 				go func() {
@@ -317,17 +938,24 @@ func (r *RequestProcessor) handleGET(req *http.Request, obj *object.FileObject)
 				monitoring.Report().Inc("request_type;type:download")
 
 				if res.StatusCode > 199 && res.StatusCode < 299 {
+					if isQuarantined(obj, res) {
+						res.Close()
+						return r.quarantineResponse()
+					}
+
+					lifecycle.Touch(obj)
+
 					if obj.CheckParent && parentObj != nil && parentRes.StatusCode == 200 {
-						return res
+						return sanitizeSVG(obj, res)
 					}
 
-					return res
+					return sanitizeSVG(obj, res)
 				}
 
 				return res
 			}
 		case parentRes = <-parentChan:
-			if parentRes.StatusCode == 404 {
+			if parentRes.StatusCode == 404 || isTimeout(parentRes) {
 				return parentRes
 			}
 		}
@@ -343,22 +971,36 @@ func (r *RequestProcessor) handleNotFound(obj, parentObj *object.FileObject, tra
 	}
 
 	if !obj.CheckParent {
-		parentRes = storage.Head(parentObj)
+		parentRes = r.withStorageTimeout(obj, func() *response.Response {
+			return storage.Head(parentObj)
+		})
 	}
 
-	if parentRes.HasError() {
+	if isTimeout(parentRes) {
+		return parentRes
+	} else if parentRes.HasError() {
 		return r.replyWithError(obj, parentRes.StatusCode, parentRes.Error())
 	} else if parentRes.StatusCode == 404 {
 		monitoring.Log().Warn("Missing parent for object", obj.LogData()...)
 		return parentRes
 	}
 	parentRes.Close()
-	if parentRes.StatusCode != 200 || !parentRes.IsImage() {
+	if parentRes.StatusCode != 200 || !(parentRes.IsImage() || strings.HasPrefix(parentRes.Headers.Get(response.HeaderContentType), engine.PDFContentType)) {
 		// monitoring.Log().Warn("Not performing transforms", obj.LogData(zap.Int("parent.sc", parentRes.StatusCode),
 		// 	zap.String("parent.ContentType", parentRes.Headers.Get(response.HeaderContentType)), zap.Error(parentRes.Error()))...)
 		return res
 	}
-	parentRes = storage.Get(parentObj)
+	parentRes = r.withStorageTimeout(obj, func() *response.Response {
+		return storage.Get(parentObj)
+	})
+	if isTimeout(parentRes) {
+		return parentRes
+	}
+	if isQuarantined(obj, parentRes) {
+		parentRes.Close()
+		return r.quarantineResponse()
+	}
+
 	if obj.HasTransform() {
 		// processImage returns new response so both parentRes must be closed
 		defer parentRes.Close()
@@ -401,42 +1043,367 @@ func handleS3Get(req *http.Request, obj *object.FileObject) *response.Response {
 
 func (r *RequestProcessor) processImage(obj *object.FileObject, parent *response.Response, transformsTab []transforms.Transforms) *response.Response {
 	monitoring.Report().Inc("request_type;type:transform")
-	ctx := obj.Ctx
-	taked := r.throttler.Take(ctx)
-	if !taked {
-		monitoring.Log().Warn("Processor/processImage", obj.LogData(zap.String("error", "throttled"))...)
-		monitoring.Report().Inc("throttled_count")
-		return r.replyWithError(obj, 503, errThrottled)
+	source := sourceKey(obj)
+	if r.quarantine.contains(source) {
+		monitoring.Log().Warn("Processor/processImage source image is quarantined", obj.LogData(zap.String("source", source))...)
+		monitoring.Report().Inc("quarantine_count;event:rejected")
+		return r.replyWithError(obj, 422, errEngineQuarantined)
+	}
+
+	// header of source image is checked, so decompression bombs are never decoded
+	var memory int64
+	buf, errBody := parent.Body()
+	if errBody == nil {
+		if err := engine.CheckLimits(buf, r.serverConfig.ImageLimits); err != nil {
+			monitoring.Log().Warn("Processor/processImage source image exceeds limits", obj.LogData(zap.Error(err))...)
+			monitoring.Report().Inc("image_limit_count")
+			return response.NewError(err.(engine.LimitError).StatusCode, err)
+		}
+		memory = engine.EstimateMemory(buf)
 	}
-	defer r.throttler.Release()
 
 	transformsLen := len(transformsTab)
 	mergedTrans := transforms.Merge(transformsTab)
 	mergedLen := len(mergedTrans)
 
-	monitoring.Log().Info("Performing transforms", obj.LogData(zap.Int("transformsLen", transformsLen), zap.Int("mergedLen", mergedLen))...)
-	eng := engine.NewImageEngine(parent)
-	res, err := eng.Process(obj, mergedTrans)
+	// source smaller than requested size is served without upscaling
+	if errBody == nil && mergedLen == 1 {
+		if width, height, err := engine.ImageSize(buf); err == nil && mergedTrans[0].SkipEnlargement(width, height) {
+			monitoring.Report().Inc("request_type;type:skip_enlargement")
+			if len(mergedTrans[0].Operations()) == 0 {
+				return skipTransform(obj, parent, buf, mergedTrans)
+			}
+		}
+	}
+
+	ctx := obj.Ctx
+	// resources are released when engine finishes, which may be after return when transform timed out
+	var releases []func()
+	detached := false
+	defer func() {
+		if !detached {
+			releaseAll(releases)
+		}
+	}()
+	if pool := r.pools.get(obj.Bucket); pool != nil {
+		if !pool.Take(ctx) {
+			monitoring.Log().Warn("Processor/processImage", obj.LogData(zap.String("error", "bucket pool throttled"))...)
+			monitoring.Report().Inc("pool_throttled_count;bucket:" + obj.Bucket)
+			return r.throttledResponse(obj, pool)
+		}
+		releases = append(releases, pool.Release)
+	}
+
+	taked := r.take(ctx, memory)
+	if !taked {
+		monitoring.Log().Warn("Processor/processImage", obj.LogData(zap.String("error", "throttled"), zap.Int64("memory", memory),
+			zap.Stringer("priority", throttler.PriorityFromContext(ctx)))...)
+		monitoring.Report().Inc("throttled_count")
+		return r.throttledResponse(obj, r.throttler)
+	}
+	releases = append(releases, func() {
+		r.release(memory)
+	})
+
+	engineName := selectEngine(obj, parent)
+	if err := engine.Check(engineName, mergedTrans); err != nil {
+		monitoring.Log().Warn("Processor/processImage", obj.LogData(zap.String("engine", engineName), zap.Error(err))...)
+		monitoring.Report().Inc("engine_unsupported_count;engine:" + engineName)
+		return response.NewError(400, err)
+	}
+
+	if errRes := r.loadComposites(obj, mergedTrans); errRes != nil {
+		return errRes
+	}
+
+	hooked := r.plugins.HasTransformPlugins()
+	if hooked {
+		// plugins get decoded result of engine before it is encoded
+		obj.Ctx = engine.WithTransformHook(obj.Ctx, func(img image.Image) image.Image {
+			return r.plugins.PostTransform(obj, img)
+		})
+	}
+
+	var eng engine.Engine
+	var err error
+	if r.workers != nil && obj.Profile == "" && !hooked {
+		// crash of engine on malformed image kills only worker
+		eng = r.workers.Engine(engineName, parent)
+	} else if eng, err = engine.New(engineName, parent); err != nil {
+		return response.NewError(500, err)
+	}
+	eng = metadataEngine{Engine: eng, parent: parent}
+	var profiled profiledEngine
+	if obj.Profile != "" {
+		// profile is captured in mort process, so transform isn't sent to worker
+		profiled = newProfiledEngine(eng, obj.Profile)
+		eng = profiled
+	}
+
+	monitoring.Log().Info("Performing transforms", obj.LogData(zap.Int("transformsLen", transformsLen), zap.Int("mergedLen", mergedLen), zap.String("engine", engineName))...)
+	start := time.Now()
+	stages := monitoring.StagesFromContext(obj.Ctx)
+	encodeBefore := stages.Get(monitoring.StageEncode)
+	res, err := r.runEngine(obj, eng, mergedTrans, func() {
+		releaseAll(releases)
+	})
+	// encoding is measured by engine, so it is excluded from transform
+	stages.Add(monitoring.StageTransform, time.Since(start)-(stages.Get(monitoring.StageEncode)-encodeBefore))
+	if isEngineFailure(err) && r.quarantine.failed(source) {
+		monitoring.Log().Warn("Processor/processImage source image quarantined", obj.LogData(zap.String("source", source), zap.Error(err))...)
+		monitoring.Report().Inc("quarantine_count;event:added")
+	}
+	if err == errTransformTimeout {
+		detached = true
+		res = r.timeoutResponse(obj, stageTransform, 503, errTransformTimeout)
+		res.Set("Retry-After", strconv.Itoa(r.serverConfig.Throttler.RetryAfter))
+		return res
+	}
 	if err != nil {
 		errRes := response.NewError(400, err)
 		errRes.SetTransforms(mergedTrans)
 		return errRes
 	}
 	res.SetTransforms(mergedTrans)
+	elapsed := time.Since(start)
+	monitoring.Drift().Observe(obj.Bucket, driftPreset(obj), res.ContentLength, elapsed)
+	if errBody == nil {
+		reportCost(obj, engineName, buf, mergedTrans, elapsed, res)
+	}
 
 	if err := storeProcessedImage(res, obj); err != nil {
 		monitoring.Log().Warn("Processor/processImage", obj.LogData(zap.Error(err))...)
 	}
 
+	if obj.Profile != "" {
+		return r.profileResponse(obj, profiled, res)
+	}
+
 	return res
 }
 
+// loadComposites fetch images placed over result of transforms from bucket of object
+func (r *RequestProcessor) loadComposites(obj *object.FileObject, mergedTrans []transforms.Transforms) *response.Response {
+	for i := range mergedTrans {
+		key := mergedTrans[i].CompositeKey()
+		if key == "" {
+			continue
+		}
+
+		if !strings.HasPrefix(key, "/") || path.Clean(key) != key {
+			return response.NewError(400, errCompositeKey)
+		}
+
+		compositeObj, err := object.NewFileObjectFromPath("/"+obj.Bucket+key, config.GetInstance())
+		if err != nil {
+			return response.NewError(400, err)
+		}
+
+		if compositeObj.HasTransform() {
+			return response.NewError(400, errCompositeTransform)
+		}
+
+		res := r.withStorageTimeout(obj, func() *response.Response {
+			return storage.Get(compositeObj)
+		})
+		if isTimeout(res) {
+			return res
+		}
+		if res.StatusCode != 200 {
+			res.Close()
+			monitoring.Log().Warn("Processor/loadComposites unable to fetch image", obj.LogData(zap.String("composite", key), zap.Int("sc", res.StatusCode))...)
+			return response.NewError(400, errCompositeNotFound)
+		}
+
+		buf, err := res.Body()
+		res.Close()
+		if err != nil {
+			return response.NewError(500, err)
+		}
+
+		if err := engine.CheckLimits(buf, r.serverConfig.ImageLimits); err != nil {
+			monitoring.Log().Warn("Processor/loadComposites image exceeds limits", obj.LogData(zap.String("composite", key), zap.Error(err))...)
+			return response.NewError(err.(engine.LimitError).StatusCode, err)
+		}
+
+		img, _, err := image.Decode(bytes.NewReader(buf))
+		if err != nil {
+			monitoring.Log().Warn("Processor/loadComposites unable to decode image", obj.LogData(zap.String("composite", key), zap.Error(err))...)
+			return response.NewError(400, err)
+		}
+
+		mergedTrans[i].SetCompositeImage(img)
+	}
+
+	return nil
+}
+
+// skipTransform returns source image as result of transform which doesn't change it
+// It is stored in result storage so next requests don't need source image
+func skipTransform(obj *object.FileObject, parent *response.Response, buf []byte, mergedTrans []transforms.Transforms) *response.Response {
+	res := response.NewBuf(200, buf)
+	res.SetContentType(parent.Headers.Get(response.HeaderContentType))
+	res.SetTransforms(mergedTrans)
+	propagateMetadata(obj, parent, res)
+	if err := storeProcessedImage(res, obj); err != nil {
+		monitoring.Log().Warn("Processor/skipTransform", obj.LogData(zap.Error(err))...)
+	}
+
+	return res
+}
+
+// take acquire throttler token, memory aware throttler reserves estimated memory of transform
+func (r *RequestProcessor) take(ctx context.Context, memory int64) bool {
+	if t, ok := r.throttler.(throttler.MemoryAware); ok && memory > 0 {
+		return t.TakeMemory(ctx, memory)
+	}
+
+	return r.throttler.Take(ctx)
+}
+
+// release return token taken by take
+func (r *RequestProcessor) release(memory int64) {
+	if t, ok := r.throttler.(throttler.MemoryAware); ok && memory > 0 {
+		t.ReleaseMemory(memory)
+		return
+	}
+
+	r.throttler.Release()
+}
+
+// sanitizeSVG replace body of original svg image with sanitized version
+// Requests authorized with S3 keys receive unmodified object
+func sanitizeSVG(obj *object.FileObject, res *response.Response) *response.Response {
+	if obj.HasTransform() || obj.Range != "" || res.StatusCode != 200 || !strings.HasPrefix(res.Headers.Get(response.HeaderContentType), engine.SVGContentType) {
+		return res
+	}
+
+	if obj.Ctx.Value(middleware.S3AuthCtxKey) != nil {
+		return res
+	}
+
+	defer res.Close()
+	buf, err := res.Body()
+	if err != nil {
+		return response.NewError(500, err)
+	}
+
+	clean, err := engine.SanitizeSVG(buf)
+	if err != nil {
+		monitoring.Log().Warn("Processor/sanitizeSVG invalid svg", obj.LogData(zap.Error(err))...)
+		return response.NewError(422, err)
+	}
+
+	sanitized := response.NewBuf(res.StatusCode, clean)
+	sanitized.Headers = res.Headers.Clone()
+	sanitized.Headers.Del("Content-Length")
+	if etag := sanitized.Headers.Get("ETag"); etag != "" {
+		sanitized.Headers.Set("ETag", sanitizedETag(etag))
+	}
+	return sanitized
+}
+
+// sanitizedETag returns ETag of sanitized svg, it contains version of sanitizer so clients revalidate
+// images sanitized by older version
+func sanitizedETag(etag string) string {
+	prefix := ""
+	if strings.HasPrefix(etag, "W/") {
+		prefix = "W/"
+	}
+
+	return prefix + `"` + strings.Trim(strings.TrimPrefix(etag, "W/"), `"`) + "-svg" + engine.SVGSanitizerVersion + `"`
+}
+
+// metadataResponse replace image in response with JSON describing it
+func metadataResponse(obj *object.FileObject, res *response.Response) *response.Response {
+	if res.StatusCode != 200 || !res.IsImage() {
+		return res
+	}
+
+	defer res.Close()
+	buf, err := res.Body()
+	if err != nil {
+		return response.NewError(500, err)
+	}
+
+	withGPS := false
+	if bucket, ok := config.GetInstance().Buckets[obj.Bucket]; ok {
+		withGPS = bucket.ExposeGPS
+	}
+
+	metaRes, err := engine.NewMetadataResponse(buf, withGPS)
+	if err != nil {
+		monitoring.Log().Warn("Processor/metadataResponse unable to read metadata", obj.LogData(zap.Error(err))...)
+		return metaRes
+	}
+
+	monitoring.Report().Inc("request_type;type:metadata")
+	if lastModified := res.Headers.Get("Last-Modified"); lastModified != "" {
+		metaRes.Set("Last-Modified", lastModified)
+	}
+
+	return metaRes
+}
+
+// paletteResponse replace image in response with JSON containing its dominant colors
+func paletteResponse(obj *object.FileObject, res *response.Response) *response.Response {
+	if res.StatusCode != 200 || !res.IsImage() {
+		return res
+	}
+
+	defer res.Close()
+	buf, err := res.Body()
+	if err != nil {
+		return response.NewError(500, err)
+	}
+
+	paletteRes, err := engine.NewPaletteResponse(buf, obj.Palette)
+	if err != nil {
+		monitoring.Log().Warn("Processor/paletteResponse unable to extract palette", obj.LogData(zap.Error(err))...)
+		return paletteRes
+	}
+
+	monitoring.Report().Inc("request_type;type:palette")
+	return paletteRes
+}
+
+// selectEngine returns name of image engine configured for bucket and content type of parent
+func selectEngine(obj *object.FileObject, parent *response.Response) string {
+	bucket, ok := config.GetInstance().Buckets[obj.Bucket]
+	if !ok || bucket.Transform == nil {
+		return engine.DefaultEngine
+	}
+
+	contentType := strings.TrimSpace(strings.Split(parent.Headers.Get(response.HeaderContentType), ";")[0])
+	if name, ok := bucket.Transform.Engines[contentType]; ok {
+		return name
+	}
+
+	if bucket.Transform.Engine != "" {
+		return bucket.Transform.Engine
+	}
+
+	return engine.DefaultEngine
+}
+
+// driftPreset returns name of preset under which transform results are tracked by drift detector
+func driftPreset(obj *object.FileObject) string {
+	if obj.Preset != "" {
+		return obj.Preset
+	}
+
+	return "query"
+}
+
 func storeProcessedImage(res *response.Response, obj *object.FileObject) error {
-	resCpy, err := res.Copy()
+	// engine result is buffered so storage reads the same bytes as client without copying them
+	resCpy, err := res.Share()
 	if err != nil {
 		return err
 	}
+	pending.Add(1)
 	go func(objS object.FileObject, resS *response.Response) {
+		defer pending.Done()
 		storage.Set(&objS, resS.Headers, resS.ContentLength, resS.Stream())
 		resS.Close()
 	}(*obj, resCpy)
@@ -3,7 +3,9 @@ package processor
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"github.com/aldor007/mort/pkg/config"
+	"github.com/aldor007/mort/pkg/engine"
 	"github.com/aldor007/mort/pkg/lock"
 	"github.com/aldor007/mort/pkg/middleware"
 	"github.com/aldor007/mort/pkg/object"
@@ -133,6 +135,37 @@ func TestContextTimeout(t *testing.T) {
 	assert.Equal(t, res.StatusCode, 499)
 }
 
+func TestContextTimeoutStatusCode(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://mort/local/small.jpg?width=5", nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	req = req.WithContext(ctx)
+	cancel()
+
+	mortConfig := config.Config{}
+	err := mortConfig.Load("./benchmark/small.yml")
+	assert.Nil(t, err)
+
+	obj, err := object.NewFileObject(req.URL, &mortConfig)
+	assert.Nil(t, err)
+
+	mortConfig.Server.ClientClose.StatusCode = 444
+	rp := NewRequestProcessor(mortConfig.Server, lock.NewMemoryLock(), throttler.NewBucketThrottler(0))
+	res := rp.Process(req, obj)
+
+	assert.Equal(t, res.StatusCode, 444)
+}
+
+func TestDetachContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(throttler.WithPriority(context.Background(), throttler.PriorityBatch))
+	detached := detachContext(ctx)
+	cancel()
+
+	assert.NotNil(t, ctx.Err())
+	assert.Nil(t, detached.Err())
+	assert.Nil(t, detached.Done())
+	assert.Equal(t, throttler.PriorityBatch, throttler.PriorityFromContext(detached))
+}
+
 func TestCollapse(t *testing.T) {
 	req, _ := http.NewRequest("GET", "http://mort/local/small.jpg?width=54", nil)
 	req2, _ := http.NewRequest("GET", "http://mort/local/small.jpg?width=54", nil)
@@ -363,3 +396,219 @@ func BenchmarkNewRequestProcessorNopLock(b *testing.B) {
 	}
 
 }
+
+func TestDebugPlan(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://mort/local/small.jpg-m?debug=plan", nil)
+
+	mortConfig := config.Config{}
+	err := mortConfig.Load("./benchmark/small.yml")
+	assert.Nil(t, err)
+
+	obj, err := object.NewFileObject(req.URL, &mortConfig)
+	assert.Nil(t, err)
+	obj.Debug = true
+	obj.DebugPlan = true
+
+	rp := NewRequestProcessor(mortConfig.Server, lock.NewMemoryLock(), throttler.NewBucketThrottler(10))
+	res := rp.Process(req, obj)
+
+	assert.Equal(t, res.StatusCode, 200)
+	assert.Equal(t, res.Headers.Get("content-type"), "application/json")
+
+	body, err := res.Body()
+	assert.Nil(t, err)
+
+	p := plan{}
+	assert.Nil(t, json.Unmarshal(body, &p))
+	assert.Equal(t, p.Object.Bucket, "local")
+	assert.Equal(t, p.Object.Preset, "m")
+	assert.Equal(t, p.Object.Parent.Key, "/small.jpg")
+	assert.Equal(t, len(p.MergedTransforms), 1)
+	assert.Equal(t, p.MergedTransforms[0]["quality"], 75.)
+	assert.Equal(t, p.CacheKey, obj.GetResponseCacheKey())
+	assert.Equal(t, p.Object.StorageBucket, "")
+	assert.Equal(t, p.Object.PathPrefix, "")
+
+	obj.DebugAdmin = true
+	res = rp.Process(req, obj)
+	assert.Equal(t, res.StatusCode, 200)
+	body, err = res.Body()
+	assert.Nil(t, err)
+	p = plan{}
+	assert.Nil(t, json.Unmarshal(body, &p))
+	assert.Equal(t, p.Object.Storage, obj.Storage.Kind)
+	assert.Equal(t, p.Object.StorageBucket, obj.Storage.Bucket)
+}
+
+func TestDebugPlanAccessChecked(t *testing.T) {
+	mortConfig := config.GetInstance()
+	err := mortConfig.Load("./benchmark/small.yml")
+	assert.Nil(t, err)
+
+	bucket := mortConfig.Buckets["local"]
+	bucket.SoftDelete = &config.SoftDeleteCfg{Prefix: "/.trash"}
+	bucket.Access = &config.AccessCfg{Hotlink: &config.HotlinkCfg{Referers: []string{"example.com"}}}
+	mortConfig.Buckets["local"] = bucket
+	defer func() {
+		bucket.SoftDelete = nil
+		bucket.Access = nil
+		mortConfig.Buckets["local"] = bucket
+	}()
+
+	rp := NewRequestProcessor(mortConfig.Server, lock.NewMemoryLock(), throttler.NewBucketThrottler(10))
+
+	req, _ := http.NewRequest("GET", "http://mort/local/.trash/small.jpg?debug=plan", nil)
+	req.Header.Set("Referer", "https://example.com/")
+	obj, err := object.NewFileObject(req.URL, mortConfig)
+	assert.Nil(t, err)
+	obj.DebugPlan = true
+	obj.DebugAdmin = true
+	res := rp.Process(req, obj)
+	assert.Equal(t, 404, res.StatusCode)
+
+	req, _ = http.NewRequest("GET", "http://mort/local/small.jpg-m?debug=plan", nil)
+	req.Header.Set("Referer", "https://evil.com/")
+	obj, err = object.NewFileObject(req.URL, mortConfig)
+	assert.Nil(t, err)
+	obj.DebugPlan = true
+	res = rp.Process(req, obj)
+	assert.Equal(t, 403, res.StatusCode)
+}
+
+func TestMetadata(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://mort/local/small.jpg-m?meta=true", nil)
+
+	mortConfig := config.Config{}
+	err := mortConfig.Load("./benchmark/small.yml")
+	assert.Nil(t, err)
+
+	obj, err := object.NewFileObject(req.URL, &mortConfig)
+	assert.Nil(t, err)
+	obj.Meta = true
+
+	rp := NewRequestProcessor(mortConfig.Server, lock.NewMemoryLock(), throttler.NewBucketThrottler(10))
+	res := rp.Process(req, obj)
+
+	assert.Equal(t, res.StatusCode, 200)
+	assert.Equal(t, res.Headers.Get("content-type"), "application/json")
+
+	body, err := res.Body()
+	assert.Nil(t, err)
+
+	meta := engine.ImageMetadata{}
+	assert.Nil(t, json.Unmarshal(body, &meta))
+	assert.Equal(t, meta.Width, 100)
+	assert.Equal(t, meta.Height, 100)
+	assert.Equal(t, meta.Format, "jpeg")
+}
+
+func TestDeleteCachedResponses(t *testing.T) {
+	mortConfig := config.Config{}
+	err := mortConfig.Load("./benchmark/small.yml")
+	assert.Nil(t, err)
+
+	obj, err := object.NewFileObjectFromPath("/local/small.jpg", &mortConfig)
+	assert.Nil(t, err)
+	rp := NewRequestProcessor(mortConfig.Server, lock.NewMemoryLock(), throttler.NewBucketThrottler(10))
+
+	cached := []*object.FileObject{obj}
+	metaObj := *obj
+	metaObj.Meta = true
+	paletteObj := *obj
+	paletteObj.Palette = 5
+	cached = append(cached, &metaObj, &paletteObj)
+	for _, o := range cached {
+		res := response.NewString(200, "cached")
+		res.Set("Cache-Control", "max-age=60")
+		assert.Nil(t, rp.responseCache.Set(o, res))
+		_, err = rp.responseCache.Get(o)
+		assert.Nil(t, err)
+	}
+
+	rp.deleteCachedResponses(obj)
+	for _, o := range cached {
+		_, err = rp.responseCache.Get(o)
+		assert.NotNil(t, err, o.GetResponseCacheKey())
+	}
+}
+
+func TestForceRenderSkipsResponseCache(t *testing.T) {
+	mortConfig := config.Config{}
+	err := mortConfig.Load("./benchmark/small.yml")
+	assert.Nil(t, err)
+	rp := NewRequestProcessor(mortConfig.Server, lock.NewMemoryLock(), throttler.NewBucketThrottler(10))
+
+	req, _ := http.NewRequest("GET", "http://mort/local/small.jpg-m", nil)
+	obj, err := object.NewFileObject(req.URL, &mortConfig)
+	assert.Nil(t, err)
+	cached := response.NewString(200, "cached")
+	cached.Set("Cache-Control", "max-age=60")
+	assert.Nil(t, rp.responseCache.Set(obj, cached))
+
+	res := rp.Process(req, obj)
+	body, _ := res.Body()
+	assert.Equal(t, "cached", string(body))
+
+	req = req.WithContext(context.WithValue(req.Context(), middleware.FeatureFlagsCtxKey, middleware.FeatureFlags{middleware.FlagForceRender: true}))
+	obj, err = object.NewFileObject(req.URL, &mortConfig)
+	assert.Nil(t, err)
+	res = rp.Process(req, obj)
+	assert.Equal(t, 200, res.StatusCode)
+	body, _ = res.Body()
+	assert.NotEqual(t, "cached", string(body), "forced render shouldn't be served from response cache")
+}
+
+func TestPalette(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://mort/local/small.jpg?palette=3", nil)
+
+	mortConfig := config.Config{}
+	err := mortConfig.Load("./benchmark/small.yml")
+	assert.Nil(t, err)
+
+	obj, err := object.NewFileObject(req.URL, &mortConfig)
+	assert.Nil(t, err)
+	obj.Palette = 3
+
+	rp := NewRequestProcessor(mortConfig.Server, lock.NewMemoryLock(), throttler.NewBucketThrottler(10))
+	res := rp.Process(req, obj)
+
+	assert.Equal(t, res.StatusCode, 200)
+	assert.Equal(t, res.Headers.Get("content-type"), "application/json")
+
+	body, err := res.Body()
+	assert.Nil(t, err)
+
+	palette := struct {
+		Colors []engine.PaletteColor `json:"colors"`
+	}{}
+	assert.Nil(t, json.Unmarshal(body, &palette))
+	assert.True(t, len(palette.Colors) > 0 && len(palette.Colors) <= 3)
+}
+
+func TestNegativeCacheTTL(t *testing.T) {
+	mortConfig := config.GetInstance()
+	err := mortConfig.Load("./benchmark/small.yml")
+	assert.Nil(t, err)
+
+	bucket := mortConfig.Buckets["local"]
+	bucket.NegativeCacheTTL = 30
+	mortConfig.Buckets["local"] = bucket
+	defer func() {
+		bucket.NegativeCacheTTL = 0
+		mortConfig.Buckets["local"] = bucket
+	}()
+
+	obj := &object.FileObject{Bucket: "local", Key: "/missing.jpg"}
+
+	assert.Equal(t, 30, negativeCacheTTL(obj, response.NewString(404, "not found")))
+	assert.Equal(t, 30, negativeCacheTTL(obj, response.NewString(403, "forbidden")))
+	assert.Equal(t, 0, negativeCacheTTL(obj, response.NewString(500, "error")))
+
+	obj.Bucket = "unknown"
+	assert.Equal(t, 0, negativeCacheTTL(obj, response.NewString(404, "not found")))
+}
+
+func TestSanitizedETag(t *testing.T) {
+	assert.Equal(t, `"abc-svg`+engine.SVGSanitizerVersion+`"`, sanitizedETag(`"abc"`))
+	assert.Equal(t, `W/"abc-svg`+engine.SVGSanitizerVersion+`"`, sanitizedETag(`W/"abc"`))
+}
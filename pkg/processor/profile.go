@@ -0,0 +1,104 @@
+package processor
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"runtime/pprof"
+	"strconv"
+	"time"
+
+	"github.com/aldor007/mort/pkg/engine"
+	"github.com/aldor007/mort/pkg/monitoring"
+	"github.com/aldor007/mort/pkg/object"
+	"github.com/aldor007/mort/pkg/response"
+	"github.com/aldor007/mort/pkg/transforms"
+	"go.uber.org/zap"
+)
+
+// HeaderProfile header with name of file in which profile of transform was stored
+const HeaderProfile = "X-Mort-Profile"
+
+// kinds of profiles of transform
+const (
+	ProfileCPU  = "cpu"  // cpu profile of process captured during transform
+	ProfileHeap = "heap" // heap profile of process taken right after transform, while its result is in memory
+)
+
+var errProfileBusy = errors.New("other cpu profile is in progress")
+
+// cpuProfileLock only one cpu profile can be captured by process at once
+var cpuProfileLock = make(chan struct{}, 1)
+
+// IsProfileKind check if kind is valid kind of profile
+func IsProfileKind(kind string) bool {
+	return kind == ProfileCPU || kind == ProfileHeap
+}
+
+// profiledEngine capture pprof profile of transform performed by engine
+type profiledEngine struct {
+	engine.Engine
+	kind    string
+	profile *bytes.Buffer
+}
+
+func newProfiledEngine(eng engine.Engine, kind string) profiledEngine {
+	return profiledEngine{Engine: eng, kind: kind, profile: &bytes.Buffer{}}
+}
+
+// Process perform transforms and write profile of it to buffer
+// Profile covers whole process, so transforms of concurrent requests are included
+func (e profiledEngine) Process(obj *object.FileObject, trans []transforms.Transforms) (*response.Response, error) {
+	if e.kind == ProfileHeap {
+		res, err := e.Engine.Process(obj, trans)
+		if errProfile := pprof.Lookup("heap").WriteTo(e.profile, 0); errProfile != nil {
+			monitoring.Log().Warn("Processor/profiledEngine unable to write heap profile", obj.LogData(zap.Error(errProfile))...)
+		}
+		return res, err
+	}
+
+	select {
+	case cpuProfileLock <- struct{}{}:
+		defer func() { <-cpuProfileLock }()
+	default:
+		return response.NewError(409, errProfileBusy), errProfileBusy
+	}
+
+	if err := pprof.StartCPUProfile(e.profile); err != nil {
+		// profiling was started by someone else (e.g. pprof endpoint)
+		return response.NewError(409, err), err
+	}
+	res, err := e.Engine.Process(obj, trans)
+	pprof.StopCPUProfile()
+	return res, err
+}
+
+// profileResponse returns profile of transform of object or stores it in profile directory
+// When profile is stored, result of transform is returned with name of file in header
+func (r *RequestProcessor) profileResponse(obj *object.FileObject, eng profiledEngine, res *response.Response) *response.Response {
+	monitoring.Report().Inc("profile_count;kind:" + eng.kind)
+	name := strconv.FormatInt(time.Now().UnixNano(), 10) + "-" + eng.kind + ".pprof"
+	if obj.RequestID != "" {
+		// request id comes from client, so it can't point outside of profile directory
+		name = filepath.Base(obj.RequestID + "-" + eng.kind + ".pprof")
+	}
+
+	if r.serverConfig.ProfileDir != "" {
+		if err := ioutil.WriteFile(filepath.Join(r.serverConfig.ProfileDir, name), eng.profile.Bytes(), 0644); err != nil {
+			monitoring.Log().Warn("Processor/profileResponse unable to store profile", obj.LogData(zap.Error(err))...)
+			return response.NewError(500, err)
+		}
+		monitoring.Log().Info("Processor/profileResponse profile stored", obj.LogData(zap.String("profile", name))...)
+		res.Set(HeaderProfile, name)
+		res.Set("Cache-Control", "no-store")
+		return res
+	}
+
+	res.Close()
+	profileRes := response.NewBuf(200, eng.profile.Bytes())
+	profileRes.SetContentType("application/octet-stream")
+	profileRes.Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	profileRes.Set("Cache-Control", "no-store")
+	return profileRes
+}
@@ -0,0 +1,67 @@
+package processor
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/aldor007/mort/pkg/config"
+	"github.com/aldor007/mort/pkg/object"
+	"github.com/aldor007/mort/pkg/response"
+	"github.com/aldor007/mort/pkg/transforms"
+	"github.com/stretchr/testify/assert"
+)
+
+type busyEngine struct{}
+
+func (busyEngine) Process(_ *object.FileObject, _ []transforms.Transforms) (*response.Response, error) {
+	sum := 0
+	for i := 0; i < 1e7; i++ {
+		sum += i % 7
+	}
+	return response.NewBuf(200, []byte("image")), nil
+}
+
+func TestProfiledEngine(t *testing.T) {
+	obj := timeoutsObject(t)
+	obj.RequestID = "req-1"
+
+	for _, kind := range []string{ProfileCPU, ProfileHeap} {
+		eng := newProfiledEngine(busyEngine{}, kind)
+		res, err := eng.Process(obj, nil)
+		assert.Nil(t, err)
+
+		rp := RequestProcessor{}
+		profileRes := rp.profileResponse(obj, eng, res)
+		assert.Equal(t, 200, profileRes.StatusCode)
+		assert.Equal(t, "application/octet-stream", profileRes.Headers.Get("Content-Type"))
+		assert.Equal(t, `attachment; filename="req-1-`+kind+`.pprof"`, profileRes.Headers.Get("Content-Disposition"))
+
+		body, _ := profileRes.Body()
+		assert.NotEmpty(t, body, "profile %s should be captured", kind)
+	}
+
+	assert.True(t, IsProfileKind(ProfileCPU))
+	assert.False(t, IsProfileKind("goroutine"))
+}
+
+func TestProfiledEngineStored(t *testing.T) {
+	obj := timeoutsObject(t)
+	obj.RequestID = "../req-2"
+	dir := t.TempDir()
+
+	eng := newProfiledEngine(busyEngine{}, ProfileCPU)
+	res, err := eng.Process(obj, nil)
+	assert.Nil(t, err)
+
+	rp := RequestProcessor{serverConfig: config.Server{ProfileDir: dir}}
+	res = rp.profileResponse(obj, eng, res)
+	assert.Equal(t, "req-2-cpu.pprof", res.Headers.Get(HeaderProfile))
+
+	body, _ := res.Body()
+	assert.Equal(t, []byte("image"), body, "image should be returned when profile is stored")
+
+	profile, err := ioutil.ReadFile(filepath.Join(dir, "req-2-cpu.pprof"))
+	assert.Nil(t, err)
+	assert.NotEmpty(t, profile)
+}
@@ -0,0 +1,128 @@
+package processor
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/aldor007/mort/pkg/config"
+	"github.com/aldor007/mort/pkg/engine"
+	"github.com/aldor007/mort/pkg/monitoring"
+	"github.com/aldor007/mort/pkg/object"
+	"github.com/aldor007/mort/pkg/response"
+	"github.com/aldor007/mort/pkg/transforms"
+	"github.com/aldor007/mort/pkg/worker"
+	"go.uber.org/zap"
+)
+
+var (
+	errEnginePanic       = errors.New("engine panicked during transform")                       // error when engine panicked
+	errEngineQuarantined = errors.New("image is quarantined after repeated failures of engine") // error when source image is quarantined
+)
+
+// quarantineEntry failures of engine on single source image
+type quarantineEntry struct {
+	failures    int
+	quarantined bool
+	expires     time.Time // end of counting failures or of quarantine
+}
+
+// quarantine keeps source images which repeatedly crashed or timed out engine
+// Requests for transforms of quarantined image fail fast instead of crashing engine again
+type quarantine struct {
+	threshold int
+	ttl       time.Duration
+	lock      sync.Mutex
+	entries   map[string]*quarantineEntry
+}
+
+// newQuarantine create quarantine, it returns nil when quarantine is disabled
+func newQuarantine(cfg config.QuarantineCfg) *quarantine {
+	if cfg.Threshold <= 0 {
+		return nil
+	}
+
+	return &quarantine{threshold: cfg.Threshold, ttl: time.Duration(cfg.TTL) * time.Second, entries: make(map[string]*quarantineEntry)}
+}
+
+// contains check if source image is quarantined
+func (q *quarantine) contains(source string) bool {
+	if q == nil {
+		return false
+	}
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	e, ok := q.entries[source]
+	if !ok {
+		return false
+	}
+
+	if time.Now().After(e.expires) {
+		delete(q.entries, source)
+		return false
+	}
+
+	return e.quarantined
+}
+
+// failed record failure of engine on source image, it returns true when image was quarantined
+func (q *quarantine) failed(source string) bool {
+	if q == nil {
+		return false
+	}
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	now := time.Now()
+	// failures are rare, so expired entries are removed here
+	for key, e := range q.entries {
+		if now.After(e.expires) {
+			delete(q.entries, key)
+		}
+	}
+
+	e, ok := q.entries[source]
+	if !ok {
+		e = &quarantineEntry{expires: now.Add(q.ttl)}
+		q.entries[source] = e
+	}
+
+	e.failures++
+	if !e.quarantined && e.failures >= q.threshold {
+		e.quarantined = true
+		e.expires = now.Add(q.ttl)
+		return true
+	}
+
+	return false
+}
+
+// isEngineFailure check if error of engine means that source image may crash engine
+func isEngineFailure(err error) bool {
+	return err == errEnginePanic || err == errTransformTimeout || err == worker.ErrWorkerCrashed
+}
+
+// sourceKey returns identifier of source image of transformed object
+func sourceKey(obj *object.FileObject) string {
+	for obj.HasParent() {
+		obj = obj.Parent
+	}
+
+	return obj.Bucket + obj.Key
+}
+
+// processSafe perform transforms with engine, panic of engine is returned as errEnginePanic
+func processSafe(eng engine.Engine, obj *object.FileObject, trans []transforms.Transforms) (res *response.Response, err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			monitoring.Log().Error("Processor/processSafe engine panicked", obj.LogData(zap.String("panic", fmt.Sprint(rec)), zap.ByteString("stack", debug.Stack()))...)
+			monitoring.Report().Inc("engine_panic_count")
+			res, err = response.NewError(500, errEnginePanic), errEnginePanic
+		}
+	}()
+
+	return eng.Process(obj, trans)
+}
@@ -0,0 +1,58 @@
+package processor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aldor007/mort/pkg/config"
+	"github.com/aldor007/mort/pkg/object"
+	"github.com/aldor007/mort/pkg/response"
+	"github.com/aldor007/mort/pkg/transforms"
+	"github.com/stretchr/testify/assert"
+)
+
+type panicEngine struct{}
+
+func (panicEngine) Process(_ *object.FileObject, _ []transforms.Transforms) (*response.Response, error) {
+	panic("corrupted image")
+}
+
+func TestProcessSafe(t *testing.T) {
+	obj := timeoutsObject(t)
+
+	res, err := processSafe(panicEngine{}, obj, nil)
+	assert.Equal(t, errEnginePanic, err)
+	assert.Equal(t, 500, res.StatusCode)
+
+	rp := RequestProcessor{transformTimeout: time.Second}
+	_, err = rp.runEngine(obj, panicEngine{}, nil, func() {})
+	assert.Equal(t, errEnginePanic, err, "panic in engine run with timeout shouldn't crash process")
+}
+
+func TestQuarantine(t *testing.T) {
+	q := newQuarantine(config.QuarantineCfg{Threshold: 2, TTL: 1})
+
+	assert.False(t, q.failed("bucket/bomb.png"))
+	assert.False(t, q.contains("bucket/bomb.png"), "image should be quarantined only after threshold")
+	assert.True(t, q.failed("bucket/bomb.png"))
+	assert.True(t, q.contains("bucket/bomb.png"))
+	assert.False(t, q.contains("bucket/other.png"))
+
+	time.Sleep(time.Millisecond * 1100)
+	assert.False(t, q.contains("bucket/bomb.png"), "quarantine should expire after ttl")
+
+	var disabled *quarantine
+	assert.Nil(t, newQuarantine(config.QuarantineCfg{Threshold: -1}))
+	assert.False(t, disabled.failed("bucket/bomb.png"))
+	assert.False(t, disabled.contains("bucket/bomb.png"))
+}
+
+func TestSourceKey(t *testing.T) {
+	obj := timeoutsObject(t)
+	parent := &object.FileObject{Bucket: "local", Key: "/original.jpg"}
+	obj.Parent = parent
+
+	assert.Equal(t, "local/original.jpg", sourceKey(obj))
+	assert.True(t, isEngineFailure(errTransformTimeout))
+	assert.False(t, isEngineFailure(errEngineQuarantined))
+}
@@ -0,0 +1,44 @@
+package processor
+
+import (
+	"strconv"
+
+	"github.com/aldor007/mort/pkg/object"
+	"github.com/aldor007/mort/pkg/response"
+	"github.com/aldor007/mort/pkg/throttler"
+)
+
+// throttledResponse returns response for request rejected by throttler
+// Retry-After and X-RateLimit-* headers are computed from state of throttler when it reports it
+func (r *RequestProcessor) throttledResponse(obj *object.FileObject, t interface{}) *response.Response {
+	sc := r.serverConfig.Throttler.StatusCode
+	if sc == 0 {
+		sc = 503
+	}
+
+	res := r.replyWithError(obj, sc, errThrottled)
+	retry := r.serverConfig.Throttler.RetryAfter
+	if reporter, ok := t.(throttler.StateReporter); ok {
+		state := reporter.State()
+		retry = retryAfter(retry, state)
+		res.Set("X-RateLimit-Limit", strconv.FormatInt(state.Limit, 10))
+		res.Set("X-RateLimit-Remaining", strconv.FormatInt(state.Remaining, 10))
+		res.Set("X-RateLimit-Reset", strconv.Itoa(retry))
+	}
+	res.Set("Retry-After", strconv.Itoa(retry))
+	return res
+}
+
+// retryAfter scale base Retry-After from base to 2 * base with fill of queue of throttler
+func retryAfter(base int, state throttler.State) int {
+	if state.Backlog <= 0 || state.Queued <= 0 {
+		return base
+	}
+
+	queued := state.Queued
+	if queued > state.Backlog {
+		queued = state.Backlog
+	}
+
+	return base + (base*queued+state.Backlog-1)/state.Backlog
+}
@@ -0,0 +1,33 @@
+package processor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aldor007/mort/pkg/config"
+	"github.com/aldor007/mort/pkg/throttler"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryAfter(t *testing.T) {
+	assert.Equal(t, 5, retryAfter(5, throttler.State{}))
+	assert.Equal(t, 5, retryAfter(5, throttler.State{Backlog: 10}))
+	assert.Equal(t, 6, retryAfter(5, throttler.State{Queued: 1, Backlog: 10}))
+	assert.Equal(t, 10, retryAfter(5, throttler.State{Queued: 10, Backlog: 10}))
+	assert.Equal(t, 10, retryAfter(5, throttler.State{Queued: 12, Backlog: 10}))
+}
+
+func TestThrottledResponse(t *testing.T) {
+	obj := timeoutsObject(t)
+	rp := RequestProcessor{serverConfig: config.Server{Throttler: config.ThrottlerCfg{RetryAfter: 5, StatusCode: 429}}}
+
+	res := rp.throttledResponse(obj, throttler.NewBucketThrottlerBacklog(2, 0, time.Second))
+	assert.Equal(t, 429, res.StatusCode)
+	assert.Equal(t, "5", res.Headers.Get("Retry-After"))
+	assert.Equal(t, "2", res.Headers.Get("X-RateLimit-Limit"))
+	assert.Equal(t, "2", res.Headers.Get("X-RateLimit-Remaining"))
+
+	res = rp.throttledResponse(obj, throttler.NewNopThrottler())
+	assert.Equal(t, "5", res.Headers.Get("Retry-After"))
+	assert.Equal(t, "", res.Headers.Get("X-RateLimit-Limit"))
+}
@@ -0,0 +1,122 @@
+package processor
+
+import (
+	"errors"
+	"time"
+
+	"github.com/aldor007/mort/pkg/engine"
+	"github.com/aldor007/mort/pkg/monitoring"
+	"github.com/aldor007/mort/pkg/object"
+	"github.com/aldor007/mort/pkg/response"
+	"github.com/aldor007/mort/pkg/transforms"
+	"go.uber.org/zap"
+)
+
+// HeaderTimeout header which tells which stage of request timed out (storage, transform or request)
+const HeaderTimeout = "X-Mort-Timeout"
+
+// stages of request with own timeout
+const (
+	stageStorage   = "storage"
+	stageTransform = "transform"
+	stageRequest   = "request"
+)
+
+var (
+	errStorageTimeout   = errors.New("storage timeout")   // error when storage operation timeout
+	errTransformTimeout = errors.New("transform timeout") // error when engine processing timeout
+)
+
+// timeoutResponse returns error response for request which timed out in given stage
+func (r *RequestProcessor) timeoutResponse(obj *object.FileObject, stage string, sc int, err error) *response.Response {
+	monitoring.Log().Warn("Processor timeout", obj.LogData(zap.String("stage", stage))...)
+	monitoring.Report().Inc("timeout_count;stage:" + stage)
+	res := r.replyWithError(obj, sc, err)
+	res.Set(HeaderTimeout, stage)
+	return res
+}
+
+// isTimeout check if response is result of timeout of any stage
+func isTimeout(res *response.Response) bool {
+	return res.Headers.Get(HeaderTimeout) != ""
+}
+
+// withStorageTimeout run storage operation limited by storage timeout
+// Response of operation finished after timeout is closed
+func (r *RequestProcessor) withStorageTimeout(obj *object.FileObject, fn func() *response.Response) *response.Response {
+	defer monitoring.StagesFromContext(obj.Ctx).Since(monitoring.StageStorage, time.Now())
+	if r.storageTimeout <= 0 {
+		return fn()
+	}
+
+	resChan := make(chan *response.Response)
+	done := make(chan struct{})
+	go func() {
+		res := fn()
+		select {
+		case <-done:
+			res.Close()
+		case resChan <- res:
+		}
+	}()
+
+	timer := time.NewTimer(r.storageTimeout)
+	defer timer.Stop()
+	select {
+	case res := <-resChan:
+		return res
+	case <-timer.C:
+		close(done)
+		return r.timeoutResponse(obj, stageStorage, 504, errStorageTimeout)
+	}
+}
+
+// engineResult is result of processing image by engine
+type engineResult struct {
+	res *response.Response
+	err error
+}
+
+// runEngine process image limited by transform timeout, errTransformTimeout is returned when engine didn't finish in time
+// Engine can't be interrupted, so after timeout it runs in background, its result is stored for next requests
+// and onDetached is called when it is done
+func (r *RequestProcessor) runEngine(obj *object.FileObject, eng engine.Engine, mergedTrans []transforms.Transforms, onDetached func()) (*response.Response, error) {
+	if r.transformTimeout <= 0 {
+		return processSafe(eng, obj, mergedTrans)
+	}
+
+	resultChan := make(chan engineResult, 1)
+	go func() {
+		res, err := processSafe(eng, obj, mergedTrans)
+		resultChan <- engineResult{res, err}
+	}()
+
+	timer := time.NewTimer(r.transformTimeout)
+	defer timer.Stop()
+	select {
+	case result := <-resultChan:
+		return result.res, result.err
+	case <-timer.C:
+		pending.Add(1)
+		go func() {
+			defer pending.Done()
+			defer onDetached()
+			result := <-resultChan
+			if result.err != nil {
+				return
+			}
+			result.res.SetTransforms(mergedTrans)
+			if err := storeProcessedImage(result.res, obj); err != nil {
+				monitoring.Log().Warn("Processor/runEngine", obj.LogData(zap.Error(err))...)
+			}
+		}()
+		return nil, errTransformTimeout
+	}
+}
+
+// releaseAll calls release functions in reverse order
+func releaseAll(releases []func()) {
+	for i := len(releases) - 1; i >= 0; i-- {
+		releases[i]()
+	}
+}
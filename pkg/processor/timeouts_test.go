@@ -0,0 +1,76 @@
+package processor
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aldor007/mort/pkg/config"
+	"github.com/aldor007/mort/pkg/object"
+	"github.com/aldor007/mort/pkg/response"
+	"github.com/aldor007/mort/pkg/transforms"
+	"github.com/stretchr/testify/assert"
+)
+
+type slowEngine struct {
+	delay time.Duration
+}
+
+func (s slowEngine) Process(_ *object.FileObject, _ []transforms.Transforms) (*response.Response, error) {
+	time.Sleep(s.delay)
+	return nil, errors.New("slow engine")
+}
+
+func timeoutsObject(t *testing.T) *object.FileObject {
+	mortConfig := config.Config{}
+	err := mortConfig.Load("./benchmark/small.yml")
+	assert.Nil(t, err)
+
+	req, _ := http.NewRequest("GET", "http://mort/local/small.jpg", nil)
+	obj, err := object.NewFileObject(req.URL, &mortConfig)
+	assert.Nil(t, err)
+	return obj
+}
+
+func TestWithStorageTimeout(t *testing.T) {
+	obj := timeoutsObject(t)
+	rp := RequestProcessor{storageTimeout: time.Millisecond * 20}
+
+	res := rp.withStorageTimeout(obj, func() *response.Response {
+		return response.NewNoContent(200)
+	})
+	assert.Equal(t, 200, res.StatusCode)
+	assert.False(t, isTimeout(res))
+
+	res = rp.withStorageTimeout(obj, func() *response.Response {
+		time.Sleep(time.Millisecond * 100)
+		return response.NewNoContent(200)
+	})
+	assert.Equal(t, 504, res.StatusCode)
+	assert.Equal(t, stageStorage, res.Headers.Get(HeaderTimeout))
+	assert.True(t, isTimeout(res))
+}
+
+func TestRunEngineTimeout(t *testing.T) {
+	obj := timeoutsObject(t)
+	rp := RequestProcessor{transformTimeout: time.Millisecond * 20}
+
+	detached := make(chan struct{})
+	_, err := rp.runEngine(obj, slowEngine{delay: time.Millisecond * 100}, nil, func() {
+		close(detached)
+	})
+	assert.Equal(t, errTransformTimeout, err)
+
+	select {
+	case <-detached:
+	case <-time.After(time.Second):
+		t.Fatal("resources of timed out engine not released")
+	}
+
+	_, err = rp.runEngine(obj, slowEngine{}, nil, func() {
+		t.Fatal("engine finished in time shouldn't be detached")
+	})
+	assert.NotNil(t, err)
+	assert.NotEqual(t, errTransformTimeout, err)
+}
@@ -0,0 +1,120 @@
+package processor
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/aldor007/mort/pkg/config"
+	"github.com/aldor007/mort/pkg/engine"
+	"github.com/aldor007/mort/pkg/helpers"
+	"github.com/aldor007/mort/pkg/monitoring"
+	"github.com/aldor007/mort/pkg/object"
+	"github.com/aldor007/mort/pkg/response"
+	"go.uber.org/zap"
+)
+
+// maxUploadHeaderSize max number of bytes read from upload to find image dimensions
+const maxUploadHeaderSize = 1 << 20
+
+// contentTypeAllowed check if content type matches one of allowed, wildcards like image/* are supported
+func contentTypeAllowed(allowed []string, contentType string) bool {
+	if i := strings.Index(contentType, ";"); i != -1 {
+		contentType = contentType[:i]
+	}
+	contentType = strings.ToLower(strings.TrimSpace(contentType))
+
+	for _, a := range allowed {
+		a = strings.ToLower(a)
+		if a == "*/*" || a == contentType {
+			return true
+		}
+		if strings.HasSuffix(a, "/*") && strings.HasPrefix(contentType, a[:len(a)-1]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// rejectUpload create error response for upload violating bucket policy
+func rejectUpload(obj *object.FileObject, statusCode int, err error) *response.Response {
+	monitoring.Log().Warn("Processor/validateUpload upload rejected", obj.LogData(zap.Int("statusCode", statusCode), zap.Error(err))...)
+	return response.NewError(statusCode, err)
+}
+
+// isImageUpload check if upload is an image by its content type or by signature of image formats known to engine
+func isImageUpload(contentType string, head []byte) bool {
+	return strings.HasPrefix(strings.ToLower(contentType), "image/") || engine.IsImage(head)
+}
+
+// validateUpload check upload against policy of bucket before it is stored
+// Only header of image is read to find its dimensions, body of request is replaced so read data is not lost
+func validateUpload(req *http.Request, obj *object.FileObject) *response.Response {
+	bucket, ok := config.GetInstance().Buckets[obj.Bucket]
+	if !ok || bucket.Upload == nil {
+		return nil
+	}
+
+	policy := bucket.Upload
+	contentType := req.Header.Get("Content-Type")
+	if len(policy.AllowedContentTypes) > 0 && !contentTypeAllowed(policy.AllowedContentTypes, contentType) {
+		return rejectUpload(obj, 415, fmt.Errorf("content type %q is not allowed", contentType))
+	}
+
+	if policy.MaxSize > 0 {
+		if req.ContentLength > policy.MaxSize {
+			return rejectUpload(obj, 413, fmt.Errorf("upload exceeds max size of %d bytes", policy.MaxSize))
+		}
+
+		if req.ContentLength < 0 {
+			// unknown length, upload is buffered so partial object is never stored
+			buf, err := ioutil.ReadAll(io.LimitReader(req.Body, policy.MaxSize+1))
+			if err != nil {
+				return response.NewError(400, err)
+			}
+
+			if int64(len(buf)) > policy.MaxSize {
+				return rejectUpload(obj, 413, fmt.Errorf("upload exceeds max size of %d bytes", policy.MaxSize))
+			}
+
+			req.Body = ioutil.NopCloser(bytes.NewReader(buf))
+			req.ContentLength = int64(len(buf))
+		}
+	}
+
+	if policy.MaxWidth <= 0 && policy.MaxHeight <= 0 && policy.MaxMegapixels <= 0 {
+		return nil
+	}
+
+	head := &bytes.Buffer{}
+	width, height, _, err := helpers.ImageDimensions(io.TeeReader(io.LimitReader(req.Body, maxUploadHeaderSize), head))
+	req.Body = ioutil.NopCloser(io.MultiReader(head, req.Body))
+	if err == helpers.ErrUnknownImageFormat {
+		if !isImageUpload(contentType, head.Bytes()) {
+			return nil
+		}
+		// dimensions of other image formats (e.g. TIFF, HEIF, AVIF) can't be read from header so they can't be verified against limits
+		return rejectUpload(obj, 422, fmt.Errorf("dimensions of image %s can't be verified", contentType))
+	} else if err != nil {
+		return rejectUpload(obj, 422, err)
+	}
+
+	if policy.MaxWidth > 0 && width > policy.MaxWidth {
+		return rejectUpload(obj, 422, fmt.Errorf("image width %d exceeds max width %d", width, policy.MaxWidth))
+	}
+
+	if policy.MaxHeight > 0 && height > policy.MaxHeight {
+		return rejectUpload(obj, 422, fmt.Errorf("image height %d exceeds max height %d", height, policy.MaxHeight))
+	}
+
+	if policy.MaxMegapixels > 0 && float64(width)*float64(height) > policy.MaxMegapixels*1e6 {
+		return rejectUpload(obj, 422, errors.New("image exceeds max number of megapixels"))
+	}
+
+	return nil
+}
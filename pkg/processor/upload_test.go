@@ -0,0 +1,95 @@
+package processor
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/aldor007/mort/pkg/config"
+	"github.com/aldor007/mort/pkg/object"
+	"github.com/stretchr/testify/assert"
+)
+
+func uploadRequest(t *testing.T, policy *config.UploadPolicy, contentType string, body []byte) (*http.Request, *object.FileObject) {
+	mortConfig := config.GetInstance()
+	err := mortConfig.Load("./benchmark/small.yml")
+	assert.Nil(t, err)
+
+	bucket := mortConfig.Buckets["local"]
+	bucket.Upload = policy
+	mortConfig.Buckets["local"] = bucket
+
+	req, _ := http.NewRequest("PUT", "http://mort/local/upload-test.png", bytes.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+
+	obj, err := object.NewFileObject(req.URL, mortConfig)
+	assert.Nil(t, err)
+
+	return req, obj
+}
+
+func testPNG(width, height int) []byte {
+	buf := bytes.Buffer{}
+	png.Encode(&buf, image.NewGray(image.Rect(0, 0, width, height)))
+	return buf.Bytes()
+}
+
+func TestContentTypeAllowed(t *testing.T) {
+	allowed := []string{"image/*", "application/pdf"}
+
+	assert.True(t, contentTypeAllowed(allowed, "image/png"))
+	assert.True(t, contentTypeAllowed(allowed, "application/pdf; charset=binary"))
+	assert.False(t, contentTypeAllowed(allowed, "text/html"))
+	assert.False(t, contentTypeAllowed(allowed, ""))
+}
+
+func TestValidateUpload(t *testing.T) {
+	body := testPNG(200, 100)
+	req, obj := uploadRequest(t, &config.UploadPolicy{AllowedContentTypes: []string{"image/*"}, MaxWidth: 300, MaxMegapixels: 1}, "image/png", body)
+
+	assert.Nil(t, validateUpload(req, obj))
+
+	// read header must be restored in body
+	result, err := ioutil.ReadAll(req.Body)
+	assert.Nil(t, err)
+	assert.Equal(t, body, result)
+}
+
+func TestValidateUploadRejected(t *testing.T) {
+	body := testPNG(200, 100)
+
+	req, obj := uploadRequest(t, &config.UploadPolicy{AllowedContentTypes: []string{"image/*"}}, "text/html", body)
+	assert.Equal(t, 415, validateUpload(req, obj).StatusCode)
+
+	req, obj = uploadRequest(t, &config.UploadPolicy{MaxSize: 10}, "image/png", body)
+	assert.Equal(t, 413, validateUpload(req, obj).StatusCode)
+
+	req, obj = uploadRequest(t, &config.UploadPolicy{MaxSize: 10}, "image/png", body)
+	req.ContentLength = -1
+	assert.Equal(t, 413, validateUpload(req, obj).StatusCode)
+
+	req, obj = uploadRequest(t, &config.UploadPolicy{MaxHeight: 50}, "image/png", body)
+	assert.Equal(t, 422, validateUpload(req, obj).StatusCode)
+
+	req, obj = uploadRequest(t, &config.UploadPolicy{MaxMegapixels: 0.01}, "image/png", body)
+	assert.Equal(t, 422, validateUpload(req, obj).StatusCode)
+
+	// tiff dimensions can't be read from header
+	tiff := append([]byte("II*\x00\x08\x00\x00\x00"), make([]byte, 16)...)
+	req, obj = uploadRequest(t, &config.UploadPolicy{MaxMegapixels: 1}, "image/tiff", tiff)
+	assert.Equal(t, 422, validateUpload(req, obj).StatusCode)
+
+	req, obj = uploadRequest(t, &config.UploadPolicy{MaxMegapixels: 1}, "application/octet-stream", tiff)
+	assert.Equal(t, 422, validateUpload(req, obj).StatusCode)
+
+	req, obj = uploadRequest(t, &config.UploadPolicy{MaxMegapixels: 1}, "text/plain", []byte("not an image"))
+	assert.Nil(t, validateUpload(req, obj))
+
+	mortConfig := config.GetInstance()
+	bucket := mortConfig.Buckets["local"]
+	bucket.Upload = nil
+	mortConfig.Buckets["local"] = bucket
+}
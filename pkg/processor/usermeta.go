@@ -0,0 +1,171 @@
+package processor
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aldor007/mort/pkg/config"
+	"github.com/aldor007/mort/pkg/engine"
+	"github.com/aldor007/mort/pkg/monitoring"
+	"github.com/aldor007/mort/pkg/object"
+	"github.com/aldor007/mort/pkg/phash"
+	"github.com/aldor007/mort/pkg/processor/plugins"
+	"github.com/aldor007/mort/pkg/response"
+	"github.com/aldor007/mort/pkg/storage"
+	"github.com/aldor007/mort/pkg/transforms"
+)
+
+// Headers of S3 copy request, copy of object onto itself replaces its metadata
+const (
+	headerCopySource        = "X-Amz-Copy-Source"
+	headerMetadataDirective = "X-Amz-Metadata-Directive"
+)
+
+// userMetadataPrefix is prefix of headers with user metadata of object
+const userMetadataPrefix = "X-Amz-Meta-"
+
+// systemMetadata is metadata set by mort which describes content of object
+// It is kept when user metadata is replaced and it isn't copied to transformed images
+var systemMetadata = []string{phash.HeaderPHash, headerMetaContentMD5, headerMetaChecksumSHA256, plugins.HeaderModeration}
+
+// copyResult is body of response for S3 copy request
+type copyResult struct {
+	XMLName      xml.Name `xml:"CopyObjectResult"`
+	LastModified string   `xml:"LastModified"`
+	ETag         string   `xml:"ETag"`
+}
+
+// isUserMetadata check if header is user metadata which can be changed by client
+func isUserMetadata(name string) bool {
+	name = http.CanonicalHeaderKey(name)
+	if !strings.HasPrefix(name, userMetadataPrefix) {
+		return false
+	}
+
+	for _, system := range systemMetadata {
+		if http.CanonicalHeaderKey(system) == name {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isMetadataUpdate check if PUT is S3 copy request, which is supported only for replacing metadata of object
+func isMetadataUpdate(req *http.Request) bool {
+	return req.Header.Get(headerCopySource) != ""
+}
+
+// handleMetadataUpdate replace user metadata of object with metadata from request without upload of content
+// Storage copies object onto itself with new metadata, see storage.UpdateMetadata
+func (r *RequestProcessor) handleMetadataUpdate(req *http.Request, obj *object.FileObject) *response.Response {
+	defer req.Body.Close()
+	source, err := url.PathUnescape(strings.SplitN(req.Header.Get(headerCopySource), "?", 2)[0])
+	if err != nil {
+		return response.NewError(400, err)
+	}
+
+	if strings.TrimPrefix(source, "/") != obj.Bucket+obj.Key {
+		return response.NewError(501, response.NewCodedError("NotImplemented", "copy between objects isn't supported, only metadata of object can be replaced"))
+	}
+
+	if !strings.EqualFold(req.Header.Get(headerMetadataDirective), "REPLACE") {
+		return response.NewError(400, response.NewCodedError("InvalidRequest", "copy of object onto itself requires x-amz-metadata-directive REPLACE"))
+	}
+
+	current := r.withStorageTimeout(obj, func() *response.Response {
+		return storage.Head(obj)
+	})
+	if current.StatusCode != 200 {
+		return current
+	}
+	current.Close()
+
+	setRes := storage.UpdateMetadata(obj, replaceMetadata(req.Header, current.Headers))
+	if setRes.StatusCode != 200 {
+		return setRes
+	}
+	setRes.Close()
+	monitoring.Report().Inc("request_type;type:metadata_update")
+
+	head := storage.Head(obj)
+	defer head.Close()
+	result := copyResult{ETag: head.Headers.Get("ETag"), LastModified: time.Now().UTC().Format(time.RFC3339)}
+	if lastMod, err := http.ParseTime(head.Headers.Get("Last-Modified")); err == nil {
+		result.LastModified = lastMod.UTC().Format(time.RFC3339)
+	}
+
+	body, err := xml.Marshal(result)
+	if err != nil {
+		return response.NewError(500, err)
+	}
+
+	res := response.NewBuf(200, append([]byte(xml.Header), body...))
+	res.SetContentType("application/xml")
+	return res
+}
+
+// replaceMetadata returns headers of object with user metadata and content headers from request
+// and metadata describing content taken from current object
+func replaceMetadata(reqHeaders http.Header, current http.Header) http.Header {
+	headers := make(http.Header)
+	for name, values := range reqHeaders {
+		if isUserMetadata(name) {
+			headers[http.CanonicalHeaderKey(name)] = values
+		}
+	}
+
+	for _, name := range systemMetadata {
+		if value := current.Get(name); value != "" {
+			headers.Set(name, value)
+		}
+	}
+
+	contentType := reqHeaders.Get("Content-Type")
+	if contentType == "" {
+		contentType = current.Get("Content-Type")
+	}
+	headers.Set("Content-Type", contentType)
+	for _, name := range postHeaderFields {
+		if value := reqHeaders.Get(name); value != "" {
+			headers.Set(name, value)
+		}
+	}
+
+	return headers
+}
+
+// propagateMetadata copy user metadata of original to transformed image when bucket is configured to do so
+// Metadata set by engine isn't overwritten
+func propagateMetadata(obj *object.FileObject, parent *response.Response, res *response.Response) {
+	bucket, ok := config.GetInstance().Buckets[obj.Bucket]
+	if !ok || !bucket.PropagateMetadata {
+		return
+	}
+
+	for name, values := range parent.Headers {
+		if isUserMetadata(name) && res.Headers.Get(name) == "" && len(values) != 0 {
+			res.Set(name, values[0])
+		}
+	}
+}
+
+// metadataEngine is engine which result gets metadata of original, it is used also for results of engines
+// finished after transform timeout
+type metadataEngine struct {
+	engine.Engine
+	parent *response.Response
+}
+
+// Process perform transforms and copy metadata of original to result
+func (e metadataEngine) Process(obj *object.FileObject, trans []transforms.Transforms) (*response.Response, error) {
+	res, err := e.Engine.Process(obj, trans)
+	if err == nil {
+		propagateMetadata(obj, e.parent, res)
+	}
+
+	return res, err
+}
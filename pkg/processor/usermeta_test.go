@@ -0,0 +1,102 @@
+package processor
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+
+	"github.com/aldor007/mort/pkg/config"
+	"github.com/aldor007/mort/pkg/lock"
+	"github.com/aldor007/mort/pkg/object"
+	"github.com/aldor007/mort/pkg/response"
+	"github.com/aldor007/mort/pkg/throttler"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplaceMetadata(t *testing.T) {
+	reqHeaders := http.Header{}
+	reqHeaders.Set("x-amz-meta-author", "new")
+	reqHeaders.Set("x-amz-meta-phash", "0000")
+	reqHeaders.Set("Cache-Control", "max-age=60")
+	current := http.Header{}
+	current.Set("x-amz-meta-author", "old")
+	current.Set("x-amz-meta-title", "old")
+	current.Set("x-amz-meta-phash", "ffff")
+	current.Set("Content-Type", "image/jpeg")
+
+	headers := replaceMetadata(reqHeaders, current)
+	assert.Equal(t, "new", headers.Get("x-amz-meta-author"))
+	assert.Equal(t, "", headers.Get("x-amz-meta-title"))
+	assert.Equal(t, "ffff", headers.Get("x-amz-meta-phash"), "metadata describing content should be kept")
+	assert.Equal(t, "image/jpeg", headers.Get("Content-Type"))
+	assert.Equal(t, "max-age=60", headers.Get("Cache-Control"))
+}
+
+func TestPropagateMetadata(t *testing.T) {
+	mortConfig := config.GetInstance()
+	err := mortConfig.Load("./benchmark/small.yml")
+	assert.Nil(t, err)
+	obj := timeoutsObject(t)
+
+	parent := response.NewNoContent(200)
+	parent.Set("x-amz-meta-author", "mort")
+	parent.Set("x-amz-meta-public-width", "100")
+	parent.Set("x-amz-meta-phash", "ffff")
+
+	res := response.NewNoContent(200)
+	propagateMetadata(obj, parent, res)
+	assert.Equal(t, "", res.Headers.Get("x-amz-meta-author"), "metadata should be propagated only when enabled")
+
+	bucket := mortConfig.Buckets["local"]
+	bucket.PropagateMetadata = true
+	mortConfig.Buckets["local"] = bucket
+	defer func() {
+		bucket.PropagateMetadata = false
+		mortConfig.Buckets["local"] = bucket
+	}()
+
+	res.Set("x-amz-meta-public-width", "50")
+	propagateMetadata(obj, parent, res)
+	assert.Equal(t, "mort", res.Headers.Get("x-amz-meta-author"))
+	assert.Equal(t, "50", res.Headers.Get("x-amz-meta-public-width"))
+	assert.Equal(t, "", res.Headers.Get("x-amz-meta-phash"))
+}
+
+func TestHandleMetadataUpdate(t *testing.T) {
+	mortConfig := config.GetInstance()
+	err := mortConfig.Load("./benchmark/small.yml")
+	assert.Nil(t, err)
+	rp := NewRequestProcessor(mortConfig.Server, lock.NewMemoryLock(), throttler.NewBucketThrottler(10))
+
+	process := func(method string, headers map[string]string, body []byte) *response.Response {
+		req, _ := http.NewRequest(method, "http://mort/local/metadata-test.txt", bytes.NewReader(body))
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		obj, err := object.NewFileObject(req.URL, mortConfig)
+		assert.Nil(t, err)
+		return rp.Process(req, obj)
+	}
+	defer process("DELETE", nil, nil)
+
+	res := process("PUT", map[string]string{"Content-Type": "text/plain", "x-amz-meta-author": "old"}, []byte("content"))
+	assert.Equal(t, 200, res.StatusCode)
+
+	res = process("PUT", map[string]string{"x-amz-copy-source": "/local/metadata-test.txt"}, nil)
+	assert.Equal(t, 400, res.StatusCode, "copy onto itself requires REPLACE directive")
+
+	res = process("PUT", map[string]string{"x-amz-copy-source": "/local/other.txt", "x-amz-metadata-directive": "REPLACE"}, nil)
+	assert.Equal(t, 501, res.StatusCode)
+
+	res = process("PUT", map[string]string{"x-amz-copy-source": "local/metadata-test.txt", "x-amz-metadata-directive": "REPLACE", "x-amz-meta-author": "new"}, nil)
+	assert.Equal(t, 200, res.StatusCode)
+	body, _ := res.Body()
+	assert.Contains(t, string(body), "<CopyObjectResult>")
+
+	res = process("GET", nil, nil)
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Equal(t, "new", res.Headers.Get("x-amz-meta-author"))
+	assert.Equal(t, "text/plain", res.Headers.Get("Content-Type"))
+	body, _ = res.Body()
+	assert.Equal(t, "content", string(body))
+}
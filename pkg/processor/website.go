@@ -0,0 +1,90 @@
+package processor
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/aldor007/mort/pkg/config"
+	"github.com/aldor007/mort/pkg/middleware"
+	"github.com/aldor007/mort/pkg/monitoring"
+	"github.com/aldor007/mort/pkg/object"
+	"github.com/aldor007/mort/pkg/response"
+)
+
+// websiteConfig returns website configuration of bucket of object, nil for buckets without website and requests of S3 API
+func websiteConfig(req *http.Request, obj *object.FileObject) *config.WebsiteCfg {
+	if req.Context().Value(middleware.S3AuthCtxKey) != nil {
+		return nil
+	}
+
+	bucket, ok := config.GetInstance().Buckets[obj.Bucket]
+	if !ok {
+		return nil
+	}
+
+	return bucket.Website
+}
+
+// websiteKey returns key of request without leading /, as it is used in routing rules
+// Key is taken from path of request, so transformed images are redirected like originals
+func websiteKey(obj *object.FileObject) string {
+	if obj.Uri == nil {
+		return strings.TrimPrefix(obj.Key, "/")
+	}
+
+	return strings.TrimPrefix(strings.TrimPrefix(obj.Uri.Path, "/"+obj.Bucket), "/")
+}
+
+// requestProtocol returns protocol used by client, X-Forwarded-Proto is kept only for requests of trusted proxies
+func requestProtocol(req *http.Request) string {
+	if req.TLS != nil || req.Header.Get("X-Forwarded-Proto") == "https" {
+		return "https"
+	}
+
+	return "http"
+}
+
+// websiteRedirect returns redirect of first routing rule of website matching request
+// statusCode is status of response of request, 0 before request is processed
+func websiteRedirect(req *http.Request, obj *object.FileObject, website *config.WebsiteCfg, statusCode int) *response.Response {
+	key := websiteKey(obj)
+	for _, rule := range website.RoutingRules {
+		if !rule.Matches(key, statusCode) {
+			continue
+		}
+
+		redirect := rule.Redirect
+		location := middleware.BucketPath(req, obj.Bucket) + "/" + rule.RedirectKey(key)
+		if redirect.HostName != "" {
+			// other host serves website, so path doesn't contain bucket
+			location = "/" + rule.RedirectKey(key)
+			protocol := redirect.Protocol
+			if protocol == "" {
+				protocol = requestProtocol(req)
+			}
+			location = protocol + "://" + redirect.HostName + location
+		} else if redirect.Protocol != "" {
+			location = redirect.Protocol + "://" + req.Host + location
+		}
+
+		monitoring.Report().Inc("website_redirect_count;bucket:" + obj.Bucket + ",code:" + strconv.Itoa(redirect.HTTPRedirectCode))
+		res := response.NewNoContent(redirect.HTTPRedirectCode)
+		res.Set("Location", location)
+		return res
+	}
+
+	return nil
+}
+
+// websiteRoot returns redirect of request of bucket without key to root of website
+func websiteRoot(req *http.Request, obj *object.FileObject) *response.Response {
+	location := middleware.BucketPath(req, obj.Bucket) + "/"
+	if req.URL.RawQuery != "" {
+		location += "?" + req.URL.RawQuery
+	}
+
+	res := response.NewNoContent(302)
+	res.Set("Location", location)
+	return res
+}
@@ -0,0 +1,57 @@
+package processor
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/aldor007/mort/pkg/config"
+	"github.com/aldor007/mort/pkg/object"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebsiteRedirect(t *testing.T) {
+	mortConfig := config.GetInstance()
+	err := mortConfig.Load("./benchmark/small.yml")
+	assert.Nil(t, err)
+
+	website := &config.WebsiteCfg{
+		RoutingRules: []config.RoutingRule{
+			{Condition: config.RoutingCondition{KeyPrefixEquals: "docs/"}, Redirect: config.RoutingRedirect{ReplaceKeyPrefixWith: "documents/", HTTPRedirectCode: 301}},
+			{Condition: config.RoutingCondition{KeyPrefixEquals: "old/"}, Redirect: config.RoutingRedirect{HostName: "archive.example.com", HTTPRedirectCode: 302}},
+			{Condition: config.RoutingCondition{HTTPErrorCodeReturnedEquals: 404}, Redirect: config.RoutingRedirect{Protocol: "https", ReplaceKeyWith: "missing.html", HTTPRedirectCode: 302}},
+		},
+	}
+
+	req, _ := http.NewRequest("GET", "http://mort/local/docs/guide.html", nil)
+	obj, err := object.NewFileObject(req.URL, mortConfig)
+	assert.Nil(t, err)
+	res := websiteRedirect(req, obj, website, 0)
+	assert.NotNil(t, res)
+	assert.Equal(t, 301, res.StatusCode)
+	assert.Equal(t, "/local/documents/guide.html", res.Headers.Get("Location"))
+	assert.Nil(t, websiteRedirect(req, obj, website, 500), "rules with key condition should be checked before processing")
+
+	req, _ = http.NewRequest("GET", "http://mort/local/old/photo.jpg", nil)
+	obj, err = object.NewFileObject(req.URL, mortConfig)
+	assert.Nil(t, err)
+	res = websiteRedirect(req, obj, website, 0)
+	assert.NotNil(t, res)
+	assert.Equal(t, 302, res.StatusCode)
+	assert.Equal(t, "http://archive.example.com/old/photo.jpg", res.Headers.Get("Location"))
+
+	req, _ = http.NewRequest("GET", "http://mort/local/small.jpg", nil)
+	req.Host = "mort"
+	obj, err = object.NewFileObject(req.URL, mortConfig)
+	assert.Nil(t, err)
+	assert.Nil(t, websiteRedirect(req, obj, website, 0))
+	res = websiteRedirect(req, obj, website, 404)
+	assert.NotNil(t, res)
+	assert.Equal(t, "https://mort/local/missing.html", res.Headers.Get("Location"))
+}
+
+func TestWebsiteRoot(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://mort/local?lang=en", nil)
+	res := websiteRoot(req, &object.FileObject{Bucket: "local"})
+	assert.Equal(t, 302, res.StatusCode)
+	assert.Equal(t, "/local/?lang=en", res.Headers.Get("Location"))
+}
@@ -0,0 +1,191 @@
+// Package proxyproto implements listener of connections which start with PROXY protocol (v1 or v2) header
+// sent by load balancers, so address of client is known behind them
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aldor007/mort/pkg/monitoring"
+	"go.uber.org/zap"
+)
+
+// v2Signature starts header of PROXY protocol v2
+var v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// v1MaxLen max length of header of PROXY protocol v1 with CRLF
+const v1MaxLen = 107
+
+var errInvalidHeader = errors.New("invalid PROXY protocol header")
+
+// Listener wraps listener, its connections return address of client from PROXY protocol header as remote address
+// Header is read on first read or call of RemoteAddr, so slow client doesn't block accepting of connections
+type Listener struct {
+	net.Listener
+	trusted       func(ip net.IP) bool
+	headerTimeout time.Duration
+}
+
+// NewListener create listener which reads PROXY protocol header from connections of trusted sources
+// Connections of other sources are used without header, no TCP source is trusted when trusted is nil
+// Connections of unix sockets are trusted, as access to them is limited by permissions of socket
+func NewListener(ln net.Listener, trusted func(ip net.IP) bool, headerTimeout time.Duration) *Listener {
+	return &Listener{Listener: ln, trusted: trusted, headerTimeout: headerTimeout}
+}
+
+// Accept waits for next connection
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok && (l.trusted == nil || !l.trusted(addr.IP)) {
+		return conn, nil
+	}
+
+	return &Conn{Conn: conn, reader: bufio.NewReader(conn), headerTimeout: l.headerTimeout}, nil
+}
+
+// Conn is connection which starts with PROXY protocol header
+type Conn struct {
+	net.Conn
+	reader        *bufio.Reader
+	headerTimeout time.Duration
+	once          sync.Once
+	remoteAddr    net.Addr
+	err           error
+}
+
+func (c *Conn) readHeader() {
+	if c.headerTimeout > 0 {
+		c.Conn.SetReadDeadline(time.Now().Add(c.headerTimeout))
+		defer c.Conn.SetReadDeadline(time.Time{})
+	}
+
+	c.remoteAddr, c.err = ReadHeader(c.reader)
+	if c.err != nil {
+		monitoring.Log().Warn("ProxyProto invalid header", zap.String("remoteAddr", c.Conn.RemoteAddr().String()), zap.Error(c.err))
+		monitoring.Report().Inc("proxy_protocol_error_count")
+	}
+}
+
+// Read reads data of connection after header
+func (c *Conn) Read(b []byte) (int, error) {
+	c.once.Do(c.readHeader)
+	if c.err != nil {
+		return 0, c.err
+	}
+
+	return c.reader.Read(b)
+}
+
+// RemoteAddr returns address of client from header, address of connection is returned when header doesn't contain it (LOCAL or UNKNOWN)
+func (c *Conn) RemoteAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.remoteAddr != nil {
+		return c.remoteAddr
+	}
+
+	return c.Conn.RemoteAddr()
+}
+
+// ReadHeader reads PROXY protocol header of version 1 or 2 and returns address of source
+// Address is nil for health checks of proxy (LOCAL command, UNKNOWN protocol)
+func ReadHeader(r *bufio.Reader) (net.Addr, error) {
+	start, err := r.Peek(len(v2Signature))
+	if err == nil && bytes.Equal(start, v2Signature) {
+		return readV2(r)
+	}
+
+	start, err = r.Peek(6)
+	if err != nil || string(start) != "PROXY " {
+		return nil, errInvalidHeader
+	}
+
+	return readV1(r)
+}
+
+func readV1(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for len(line) < v1MaxLen {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errInvalidHeader
+	}
+
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, errInvalidHeader
+	}
+
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.Atoi(fields[4])
+	if ip == nil || err != nil || port < 0 || port > 65535 {
+		return nil, errInvalidHeader
+	}
+
+	return &net.TCPAddr{IP: ip, Port: port}, nil
+}
+
+func readV2(r *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+
+	if header[12]>>4 != 2 {
+		return nil, fmt.Errorf("unsupported PROXY protocol version %d", header[12]>>4)
+	}
+
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+
+	command := header[12] & 0x0f
+	if command == 0 {
+		// LOCAL - connection of proxy itself, e.g. health check
+		return nil, nil
+	} else if command != 1 {
+		return nil, errInvalidHeader
+	}
+
+	switch header[13] >> 4 {
+	case 1: // IPv4
+		if len(payload) < 12 {
+			return nil, errInvalidHeader
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:4]), Port: int(binary.BigEndian.Uint16(payload[8:10]))}, nil
+	case 2: // IPv6
+		if len(payload) < 36 {
+			return nil, errInvalidHeader
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:16]), Port: int(binary.BigEndian.Uint16(payload[32:34]))}, nil
+	default:
+		// unix sockets and unspecified family don't have address of client
+		return nil, nil
+	}
+}
@@ -0,0 +1,126 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadHeaderV1(t *testing.T) {
+	r := bufio.NewReader(bytes.NewBufferString("PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\nGET / HTTP/1.1\r\n"))
+
+	addr, err := ReadHeader(r)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "192.168.0.1:56324", addr.String())
+	rest, _ := ioutil.ReadAll(r)
+	assert.Equal(t, "GET / HTTP/1.1\r\n", string(rest))
+}
+
+func TestReadHeaderV1Unknown(t *testing.T) {
+	addr, err := ReadHeader(bufio.NewReader(bytes.NewBufferString("PROXY UNKNOWN\r\n")))
+
+	assert.Nil(t, err)
+	assert.Nil(t, addr)
+}
+
+func TestReadHeaderV2(t *testing.T) {
+	header := append([]byte{}, v2Signature...)
+	header = append(header, 0x21, 0x11, 0, 12, 10, 0, 0, 1, 10, 0, 0, 2, 0x1f, 0x90, 0x01, 0xbb)
+	r := bufio.NewReader(bytes.NewBuffer(append(header, []byte("GET")...)))
+
+	addr, err := ReadHeader(r)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "10.0.0.1:8080", addr.String())
+	rest, _ := ioutil.ReadAll(r)
+	assert.Equal(t, "GET", string(rest))
+}
+
+func TestReadHeaderV2Local(t *testing.T) {
+	header := append([]byte{}, v2Signature...)
+	header = append(header, 0x20, 0x00, 0, 0)
+
+	addr, err := ReadHeader(bufio.NewReader(bytes.NewBuffer(header)))
+
+	assert.Nil(t, err)
+	assert.Nil(t, addr)
+}
+
+func TestReadHeaderInvalid(t *testing.T) {
+	_, err := ReadHeader(bufio.NewReader(bytes.NewBufferString("GET / HTTP/1.1\r\n")))
+
+	assert.NotNil(t, err)
+}
+
+func TestListener(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer ln.Close()
+
+	go func() {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			return
+		}
+		conn.Write([]byte("PROXY TCP6 2001:db8::1 2001:db8::2 1234 80\r\nping"))
+		conn.Close()
+	}()
+
+	conn, err := NewListener(ln, func(ip net.IP) bool { return ip.IsLoopback() }, time.Second).Accept()
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	assert.Equal(t, "[2001:db8::1]:1234", conn.RemoteAddr().String())
+	body, _ := ioutil.ReadAll(conn)
+	assert.Equal(t, "ping", string(body))
+}
+
+func TestListenerUntrusted(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer ln.Close()
+
+	go func() {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			return
+		}
+		conn.Write([]byte("ping"))
+		conn.Close()
+	}()
+
+	conn, err := NewListener(ln, func(ip net.IP) bool { return false }, time.Second).Accept()
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	assert.Equal(t, "127.0.0.1", conn.RemoteAddr().(*net.TCPAddr).IP.String())
+	body, _ := ioutil.ReadAll(conn)
+	assert.Equal(t, "ping", string(body))
+}
+
+func TestListenerWithoutTrusted(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer ln.Close()
+
+	go func() {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			return
+		}
+		conn.Write([]byte("PROXY TCP4 1.2.3.4 10.0.0.1 1234 80\r\n"))
+		conn.Close()
+	}()
+
+	conn, err := NewListener(ln, nil, time.Second).Accept()
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	assert.Equal(t, "127.0.0.1", conn.RemoteAddr().(*net.TCPAddr).IP.String(), "client shouldn't choose own address")
+}
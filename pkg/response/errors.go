@@ -0,0 +1,131 @@
+package response
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"strings"
+)
+
+// Formats of body of error responses
+const (
+	ErrorFormatNone = ""     // empty body, message is included only in debug mode
+	ErrorFormatJSON = "json" // JSON body with code, message, requestId and key
+	ErrorFormatS3   = "s3"   // S3 XML error, used for requests of S3 API
+)
+
+// ErrorBody is body of error response in JSON format
+type ErrorBody struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"requestId,omitempty"`
+	Key       string `json:"key,omitempty"`
+}
+
+// s3ErrorBody is body of error response in S3 XML format
+type s3ErrorBody struct {
+	XMLName   xml.Name `xml:"Error"`
+	Code      string   `xml:"Code"`
+	Message   string   `xml:"Message"`
+	Key       string   `xml:"Key,omitempty"`
+	RequestID string   `xml:"RequestId"`
+}
+
+// errorCodes are codes of errors for status codes which differ from status text
+var errorCodes = map[int]string{
+	400: "InvalidRequest",
+	403: "AccessDenied",
+	413: "EntityTooLarge",
+	429: "SlowDown",
+	499: "ClientClosedRequest",
+	500: "InternalError",
+	503: "SlowDown",
+	504: "Timeout",
+}
+
+// s3ErrorCodes are codes of S3 API errors which differ from other codes
+var s3ErrorCodes = map[int]string{
+	400: "InvalidArgument",
+	401: "AccessDenied",
+	404: "NoSuchKey",
+	412: "PreconditionFailed",
+	504: "RequestTimeout",
+}
+
+// codedError is error with own code in error responses
+type codedError struct {
+	code    string
+	message string
+}
+
+func (e codedError) Error() string {
+	return e.message
+}
+
+// NewCodedError create error with code used in error responses instead of code of status (e.g. SignatureDoesNotMatch)
+// Message of coded error is returned to client also outside of debug mode
+func NewCodedError(code, message string) error {
+	return codedError{code: code, message: message}
+}
+
+// S3ErrorCode returns code of S3 API error with given status code (e.g. NoSuchKey for 404)
+func S3ErrorCode(statusCode int) string {
+	if code, ok := s3ErrorCodes[statusCode]; ok {
+		return code
+	}
+
+	return ErrorCode(statusCode)
+}
+
+// ErrorCode returns machine readable code of error with given status code (e.g. NotFound for 404)
+func ErrorCode(statusCode int) string {
+	if code, ok := errorCodes[statusCode]; ok {
+		return code
+	}
+
+	return strings.Replace(http.StatusText(statusCode), " ", "", -1)
+}
+
+// FormatError replace body of error response with body in given format
+// Responses with image body (e.g. placeholder) are left untouched
+func (r *Response) FormatError(format, requestID, key string) *Response {
+	if format == ErrorFormatNone || r.StatusCode < 400 || r.IsImage() {
+		return r
+	}
+
+	body := ErrorBody{Code: ErrorCode(r.StatusCode), Message: http.StatusText(r.StatusCode), RequestID: requestID, Key: key}
+	if format == ErrorFormatS3 {
+		body.Code = S3ErrorCode(r.StatusCode)
+	}
+
+	if coded, ok := r.errorValue.(codedError); ok {
+		body.Code = coded.code
+		body.Message = coded.message
+	} else if r.debug && r.errorValue != nil {
+		body.Message = r.errorValue.Error()
+	}
+
+	var buf []byte
+	var err error
+	contentType := "application/json"
+	if format == ErrorFormatS3 {
+		contentType = "application/xml"
+		buf, err = xml.Marshal(s3ErrorBody{Code: body.Code, Message: body.Message, Key: strings.TrimPrefix(body.Key, "/"), RequestID: body.RequestID})
+		buf = append([]byte(xml.Header), buf...)
+	} else {
+		buf, err = json.Marshal(body)
+	}
+
+	if err != nil {
+		return r
+	}
+
+	r.Close()
+	r.setBodyBytes(buf)
+	r.SetContentType(contentType)
+	if format == ErrorFormatS3 && requestID != "" {
+		// S3 clients read request id from header
+		r.Set("x-amz-request-id", requestID)
+	}
+	return r
+}
@@ -16,6 +16,7 @@ import (
 	"io"
 	"io/ioutil"
 	"net/http"
+	"os"
 	"strings"
 	"time"
 )
@@ -130,7 +131,7 @@ func (r *Response) Body() ([]byte, error) {
 		return nil, errors.New("empty body")
 	}
 
-	body, err := ioutil.ReadAll(r.reader)
+	body, err := readAll(r.reader, r.ContentLength)
 	r.reader.Close()
 	r.reader = nil
 	r.setBodyBytes(body)
@@ -219,12 +220,46 @@ func (r *Response) Error() error {
 	return r.errorValue
 }
 
-// Send write response to client using streaming
-func (r *Response) Send(w http.ResponseWriter) error {
+// writeHeaders copy headers of response to writer, all values of header are sent (e.g. Vary added by many components)
+func (r *Response) writeHeaders(w http.ResponseWriter) {
 	for headerName, headerValue := range r.Headers {
-		w.Header().Set(headerName, headerValue[0])
+		w.Header().Del(headerName)
+		for _, value := range headerValue {
+			w.Header().Add(headerName, value)
+		}
+	}
+}
+
+// notModified check if response matches If-None-Match or, when it is missing, If-Modified-Since of request
+func (r *Response) notModified(req *http.Request) bool {
+	if inm := req.Header.Get("If-None-Match"); inm != "" {
+		etag := strings.TrimPrefix(r.Headers.Get("ETag"), "W/")
+		if etag == "" {
+			return false
+		}
+
+		for _, candidate := range strings.Split(inm, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+				return true
+			}
+		}
+		return false
 	}
 
+	ims, err := http.ParseTime(req.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+
+	lastMod, err := http.ParseTime(r.Headers.Get("Last-Modified"))
+	return err == nil && !lastMod.After(ims)
+}
+
+// Send write response to client using streaming
+func (r *Response) Send(w http.ResponseWriter) error {
+	r.writeHeaders(w)
+
 	defer r.Close()
 	w.WriteHeader(r.StatusCode)
 
@@ -252,19 +287,35 @@ func (r *Response) Send(w http.ResponseWriter) error {
 // In this function we don't need to use transformer because it don't serve whole body
 // It is used for range and condition requests
 func (r *Response) SendContent(req *http.Request, w http.ResponseWriter) error {
+	if r.transformer != nil {
+		// encoded body can't be served by ServeContent, range requests are never encoded
+		if r.StatusCode == 200 && r.notModified(req) {
+			defer r.Close()
+			r.writeHeaders(w)
+			w.WriteHeader(304)
+			return nil
+		}
+		return r.Send(w)
+	}
+
 	// ServerContent will modified status code so to it we should pass only 200 response
-	if r.StatusCode != 200 || r.bodySeeker == nil || helpers.IsRangeOrCondition(req) == false {
+	// Local files are always served by it as then body is written to client using sendfile
+	direct := r.IsFile()
+	if r.StatusCode != 200 || r.bodySeeker == nil || (helpers.IsRangeOrCondition(req) == false && direct == false) {
 		return r.Send(w)
 	}
 
 	defer r.Close()
-	for headerName, headerValue := range r.Headers {
-		w.Header().Set(headerName, headerValue[0])
-	}
+	r.writeHeaders(w)
 
 	lastMod, err := time.Parse(http.TimeFormat, r.Headers.Get("Last-Modified"))
 	if err != nil {
 		lastMod = time.Now()
+		if file, ok := r.bodySeeker.(*os.File); ok {
+			if stat, err := file.Stat(); err == nil {
+				lastMod = stat.ModTime()
+			}
+		}
 	}
 
 	http.ServeContent(w, req, "", lastMod, r.bodySeeker)
@@ -276,6 +327,12 @@ func (r *Response) IsCacheable() bool {
 	return r.StatusCode > 199 && r.StatusCode < 299 && r.cachable
 }
 
+// SetTTL set time to live of response in cache regardless of its Cache-Control header
+func (r *Response) SetTTL(ttl int) {
+	r.ttl = ttl
+	r.cachable = ttl > 0
+}
+
 func (r *Response) GetTTL() int {
 	r.parseCacheHeaders()
 	return r.ttl
@@ -324,6 +381,46 @@ func (r *Response) Copy() (*Response, error) {
 
 }
 
+// Share create response copy with headers that reuses buffered body instead of copying it.
+// Body of buffered response is never modified in place so it can be safely read by many consumers
+// (client, cache, storage) at once. For not buffered response it fallbacks to Copy
+func (r *Response) Share() (*Response, error) {
+	if r == nil {
+		return nil, nil
+	}
+
+	if r.body == nil {
+		return r.Copy()
+	}
+
+	c := Response{StatusCode: r.StatusCode, ContentLength: r.ContentLength, debug: r.debug, errorValue: r.errorValue}
+	c.Headers = r.Headers.Clone()
+	c.trans = make([]transforms.Transforms, len(r.trans))
+	copy(c.trans, r.trans)
+	c.setBodyBytes(r.body)
+	return &c, nil
+}
+
+// maxPreallocSize max size of buffer allocated upfront for body, Content-Length comes from storage or client
+// so it can't be trusted, bigger bodies grow buffer while they are read
+const maxPreallocSize = 16 << 20
+
+// readAll reads whole reader content, when size is known buffer is allocated once
+// instead of growing it while reading
+func readAll(reader io.Reader, size int64) ([]byte, error) {
+	if size <= 0 {
+		return ioutil.ReadAll(reader)
+	}
+
+	if size > maxPreallocSize {
+		size = maxPreallocSize
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, size+bytes.MinRead))
+	_, err := buf.ReadFrom(reader)
+	return buf.Bytes(), err
+}
+
 // CopyWithStream should be used with not buffered response that contain stream.
 // It tries to duplicate response stream for multiple readers.
 func (r *Response) CopyWithStream() (*Response, error) {
@@ -353,6 +450,8 @@ func (r *Response) CopyWithStream() (*Response, error) {
 	c.resStream = r.resStream
 	c.hasParent = true
 	r.reader = ioutil.NopCloser(io.TeeReader(r.bodyReader, r.resStream))
+	// body have to be read through tee so copies receive it
+	r.bodySeeker = nil
 
 	return &c, nil
 
@@ -406,6 +505,16 @@ func (r *Response) IsBuffered() bool {
 	return r.body != nil
 }
 
+// IsFile check if response body is not buffered stream of local file
+func (r *Response) IsFile() bool {
+	if r.body != nil || r.reader == nil || r.hasParent {
+		return false
+	}
+
+	_, ok := r.bodySeeker.(*os.File)
+	return ok
+}
+
 // IsImage check if response is image
 func (r *Response) IsImage() bool {
 	return strings.Contains(r.Headers.Get(HeaderContentType), "image/")
@@ -13,6 +13,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"testing"
 )
 
@@ -33,6 +34,50 @@ func TestResponse_Copy(t *testing.T) {
 	assert.Equal(t, len(buf1), 1000, "buffors from response should have equal length")
 }
 
+func TestReadAll(t *testing.T) {
+	body, err := readAll(bytes.NewReader([]byte("body")), 4)
+	assert.Nil(t, err)
+	assert.Equal(t, "body", string(body))
+
+	// declared length isn't trusted
+	body, err = readAll(bytes.NewReader([]byte("body")), 1<<40)
+	assert.Nil(t, err)
+	assert.Equal(t, "body", string(body))
+	assert.True(t, cap(body) <= maxPreallocSize+bytes.MinRead)
+}
+
+func TestResponse_Share(t *testing.T) {
+	buf := []byte("shared body")
+	res := NewBuf(200, buf)
+	res.Set("x-header", "1")
+	resCpy, err := res.Share()
+	assert.Nil(t, err, "Should not return error when sharing")
+
+	resCpy.Set("x-header", "2")
+	assert.Equal(t, res.Headers.Get("x-header"), "1", "headers should be copied")
+
+	buf1, _ := res.Body()
+	buf2, err := resCpy.Body()
+	assert.Nil(t, err, "Should not return error when reading body")
+	assert.Equal(t, &buf1[0], &buf2[0], "body should not be copied")
+
+	read1, _ := ioutil.ReadAll(res.Stream())
+	read2, _ := ioutil.ReadAll(resCpy.Stream())
+	assert.Equal(t, read1, read2, "both responses should be readable")
+}
+
+func TestResponse_ShareStream(t *testing.T) {
+	buf := make([]byte, 1000)
+	res := New(200, ioutil.NopCloser(bytes.NewReader(buf)))
+	res.ContentLength = 1000
+	resCpy, err := res.Share()
+	assert.Nil(t, err, "Should not return error when sharing")
+
+	body, err := resCpy.Body()
+	assert.Nil(t, err, "Should not return error when reading body")
+	assert.Equal(t, len(body), 1000)
+}
+
 func TestNew(t *testing.T) {
 	buf := make([]byte, 1000)
 	reader := ioutil.NopCloser(bytes.NewReader(buf))
@@ -175,6 +220,45 @@ func TestResponse_SendContentNotRangeOrCondition(t *testing.T) {
 	assert.Equal(t, len(body), 1000)
 }
 
+func TestResponse_SendContentFile(t *testing.T) {
+	file, err := ioutil.TempFile("", "mort-response")
+	assert.Nil(t, err)
+	defer os.Remove(file.Name())
+	file.Write([]byte("0123456789"))
+	file.Seek(0, io.SeekStart)
+
+	res := New(200, file)
+	res.SetContentType("text/plain")
+	assert.True(t, res.IsFile())
+
+	req, _ := http.NewRequest("GET", "/bucket/local.txt", nil)
+	recorder := httptest.NewRecorder()
+	res.SendContent(req, recorder)
+
+	result := recorder.Result()
+	assert.Equal(t, result.StatusCode, 200)
+	assert.Equal(t, result.Header.Get("Content-Length"), "10")
+	assert.NotEqual(t, result.Header.Get("Last-Modified"), "")
+	body, _ := ioutil.ReadAll(result.Body)
+	assert.Equal(t, string(body), "0123456789")
+}
+
+func TestResponse_IsFile(t *testing.T) {
+	assert.False(t, NewString(200, "body").IsFile())
+	assert.False(t, New(200, ioutil.NopCloser(bytes.NewReader([]byte("body")))).IsFile())
+
+	file, err := ioutil.TempFile("", "mort-response")
+	assert.Nil(t, err)
+	defer os.Remove(file.Name())
+
+	res := New(200, file)
+	resCpy, err := res.CopyWithStream()
+	assert.Nil(t, err)
+	assert.False(t, res.IsFile(), "stream shared with copies should not be send directly")
+	assert.False(t, resCpy.IsFile())
+	res.Close()
+}
+
 func TestResponse_BodyTransformer(t *testing.T) {
 	buf := make([]byte, 1000)
 	res := New(200, ioutil.NopCloser(bytes.NewReader(buf)))
@@ -231,6 +315,17 @@ func TestResponse_IsCachable(t *testing.T) {
 	assert.Equal(t, res.GetTTL(), 600)
 }
 
+func TestResponse_SetTTL(t *testing.T) {
+	res := NewString(404, "not found")
+	res.Headers.Set("cache-control", "max-age=600")
+
+	res.SetTTL(30)
+
+	assert.True(t, res.cachable)
+	assert.Equal(t, res.GetTTL(), 30)
+	assert.False(t, res.IsCacheable())
+}
+
 func TestResponse_DecodeMsgpack(t *testing.T) {
 	res := NewString(400, "testuje")
 	res.Headers.Set("etag", "md5")
@@ -309,3 +404,44 @@ func BenchmarkNewCopyWithStream(b *testing.B) {
 		}
 	}
 }
+
+func TestResponse_FormatError(t *testing.T) {
+	res := NewError(404, errors.New("item not found")).FormatError(ErrorFormatJSON, "req-1", "/image.jpg")
+	body, err := res.Body()
+	assert.Nil(t, err)
+	assert.Equal(t, "application/json", res.Headers.Get(HeaderContentType))
+	assert.Equal(t, `{"code":"NotFound","message":"Not Found","requestId":"req-1","key":"/image.jpg"}`, string(body))
+
+	res = NewString(503, "throttled").FormatError(ErrorFormatS3, "req-2", "/image.jpg")
+	body, err = res.Body()
+	assert.Nil(t, err)
+	assert.Equal(t, "application/xml", res.Headers.Get(HeaderContentType))
+	assert.Contains(t, string(body), "<Error><Code>SlowDown</Code><Message>Service Unavailable</Message><Key>image.jpg</Key><RequestId>req-2</RequestId></Error>")
+
+	res = NewError(500, errors.New("storage down")).SetDebug(&object.FileObject{Debug: true}).FormatError(ErrorFormatJSON, "", "")
+	body, err = res.Body()
+	assert.Nil(t, err)
+	assert.Equal(t, `{"code":"InternalError","message":"storage down"}`, string(body))
+
+	placeholder := NewBuf(404, []byte("image")).SetContentType("image/png")
+	assert.Equal(t, "image", string(placeholder.FormatError(ErrorFormatJSON, "", "").body))
+
+	none := NewError(404, errors.New("item not found")).FormatError(ErrorFormatNone, "", "")
+	assert.Equal(t, int64(0), none.ContentLength)
+}
+
+func TestResponse_FormatErrorS3(t *testing.T) {
+	res := NewString(404, "{\"error\":\"item not found\"}").FormatError(ErrorFormatS3, "req-1", "/image.jpg")
+	body, err := res.Body()
+	assert.Nil(t, err)
+	assert.Contains(t, string(body), "<Code>NoSuchKey</Code>")
+	assert.Equal(t, "req-1", res.Headers.Get("x-amz-request-id"))
+
+	res = NewError(403, NewCodedError("SignatureDoesNotMatch", "request signature does not match")).FormatError(ErrorFormatS3, "", "")
+	body, err = res.Body()
+	assert.Nil(t, err)
+	assert.Contains(t, string(body), "<Code>SignatureDoesNotMatch</Code><Message>request signature does not match</Message>")
+
+	assert.Equal(t, "NotFound", ErrorCode(404))
+	assert.Equal(t, "SlowDown", S3ErrorCode(503))
+}
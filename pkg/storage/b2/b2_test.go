@@ -0,0 +1,304 @@
+package b2
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aldor007/stow"
+	"github.com/stretchr/testify/assert"
+)
+
+// testServer is minimal in memory B2 API server
+type testServer struct {
+	*httptest.Server
+	lock       sync.Mutex
+	files      map[string][]byte
+	info       map[string]map[string]string
+	parts      map[string]map[int][]byte
+	token      int
+	failures   map[string]int // number of 429 responses returned for given operation
+	authorized int
+	calls      map[string]int
+}
+
+func newTestServer(t *testing.T) *testServer {
+	s := &testServer{files: map[string][]byte{}, info: map[string]map[string]string{}, parts: map[string]map[int][]byte{}, failures: map[string]int{}, calls: map[string]int{}}
+	s.Server = httptest.NewServer(s)
+	t.Cleanup(s.Close)
+
+	retryBackoff = time.Millisecond
+	return s
+}
+
+func (s *testServer) location(t *testing.T, partSize string) stow.Location {
+	l, err := stow.Dial(Kind, stow.ConfigMap{ConfigAccountID: "account", ConfigApplicationKey: "key", ConfigAuthURL: s.URL, ConfigPartSize: partSize})
+	assert.Nil(t, err)
+	return l
+}
+
+func (s *testServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	writeJSON := func(status int, v interface{}) {
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(v)
+	}
+
+	operation := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+	s.calls[operation]++
+	if s.failures[operation] > 0 {
+		s.failures[operation]--
+		w.Header().Set("Retry-After", "0")
+		writeJSON(429, map[string]interface{}{"status": 429, "code": "too_many_requests", "message": "slow down"})
+		return
+	}
+
+	if operation == "b2_authorize_account" {
+		user, pass, _ := r.BasicAuth()
+		if user != "account" || pass != "key" {
+			writeJSON(401, map[string]interface{}{"status": 401, "code": "unauthorized", "message": "bad key"})
+			return
+		}
+		s.authorized++
+		s.token++
+		writeJSON(200, map[string]interface{}{"accountId": "account", "authorizationToken": "token" + strconv.Itoa(s.token), "apiUrl": s.URL, "downloadUrl": s.URL, "recommendedPartSize": 100})
+		return
+	}
+
+	if r.Header.Get("Authorization") != "token"+strconv.Itoa(s.token) {
+		writeJSON(401, map[string]interface{}{"status": 401, "code": "expired_auth_token", "message": "expired"})
+		return
+	}
+
+	var in map[string]interface{}
+	if r.Method == "POST" && !strings.HasPrefix(r.URL.Path, "/upload") {
+		json.NewDecoder(r.Body).Decode(&in)
+	}
+
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/file/bucket/"):
+		name := strings.TrimPrefix(r.URL.Path, "/file/bucket/")
+		body, ok := s.files[name]
+		if !ok {
+			writeJSON(404, map[string]interface{}{"status": 404, "code": "not_found", "message": "not found"})
+			return
+		}
+		w.Header().Set("X-Bz-File-Id", "id-"+name)
+		w.Header().Set("X-Bz-Upload-Timestamp", "1577977445000")
+		w.Header().Set("Content-Type", "image/jpeg")
+		for k, v := range s.info[name] {
+			w.Header().Set("X-Bz-Info-"+k, v)
+		}
+		var start, end int
+		if n, _ := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end); n == 2 {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(body)))
+			w.Header().Set("Content-Length", strconv.Itoa(end-start+1))
+			w.WriteHeader(206)
+			w.Write(body[start : end+1])
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.Write(body)
+	case operation == "b2_list_buckets":
+		buckets := []map[string]string{{"bucketId": "bucket-id", "bucketName": "bucket"}}
+		if name, ok := in["bucketName"]; ok && name != "bucket" {
+			buckets = nil
+		}
+		writeJSON(200, map[string]interface{}{"buckets": buckets})
+	case operation == "b2_get_upload_url", operation == "b2_get_upload_part_url":
+		target := "/upload"
+		if fileID, ok := in["fileId"].(string); ok {
+			target = "/upload_part/" + fileID
+		}
+		writeJSON(200, map[string]string{"uploadUrl": s.URL + target, "authorizationToken": "token" + strconv.Itoa(s.token)})
+	case r.URL.Path == "/upload":
+		body, _ := ioutil.ReadAll(r.Body)
+		name := r.Header.Get("X-Bz-File-Name")
+		s.files[name] = body
+		s.info[name] = map[string]string{}
+		for k, v := range r.Header {
+			if strings.HasPrefix(k, "X-Bz-Info-") {
+				s.info[name][strings.TrimPrefix(k, "X-Bz-Info-")] = v[0]
+			}
+		}
+		writeJSON(200, map[string]interface{}{"fileId": "id-" + name, "fileName": name, "contentLength": len(body), "contentSha1": r.Header.Get("X-Bz-Content-Sha1"), "action": "upload"})
+	case strings.HasPrefix(r.URL.Path, "/upload_part/"):
+		fileID := strings.TrimPrefix(r.URL.Path, "/upload_part/")
+		body, _ := ioutil.ReadAll(r.Body)
+		if sha1Hex(body) != r.Header.Get("X-Bz-Content-Sha1") {
+			writeJSON(400, map[string]interface{}{"status": 400, "code": "bad_request", "message": "checksum"})
+			return
+		}
+		partNumber, _ := strconv.Atoi(r.Header.Get("X-Bz-Part-Number"))
+		s.parts[fileID][partNumber] = body
+		writeJSON(200, map[string]interface{}{})
+	case operation == "b2_start_large_file":
+		name := in["fileName"].(string)
+		s.parts["large-"+name] = map[int][]byte{}
+		writeJSON(200, map[string]interface{}{"fileId": "large-" + name, "fileName": name})
+	case operation == "b2_finish_large_file":
+		fileID := in["fileId"].(string)
+		name := strings.TrimPrefix(fileID, "large-")
+		var body []byte
+		for i := 1; i <= len(s.parts[fileID]); i++ {
+			body = append(body, s.parts[fileID][i]...)
+		}
+		s.files[name] = body
+		writeJSON(200, map[string]interface{}{"fileId": fileID, "fileName": name, "contentLength": len(body), "contentSha1": "none", "action": "upload"})
+	case operation == "b2_list_file_names":
+		prefix, _ := in["prefix"].(string)
+		start, _ := in["startFileName"].(string)
+		count := int(in["maxFileCount"].(float64))
+		var names []string
+		seen := map[string]bool{}
+		for name := range s.files {
+			if !strings.HasPrefix(name, prefix) {
+				continue
+			}
+			if i := strings.Index(name[len(prefix):], "/"); i != -1 {
+				name = name[:len(prefix)+i+1]
+			}
+			if !seen[name] && name >= start {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		var next *string
+		if len(names) > count {
+			next = &names[count]
+			names = names[:count]
+		}
+		files := []map[string]interface{}{}
+		for _, name := range names {
+			action := "upload"
+			if strings.HasSuffix(name, "/") {
+				action = "folder"
+			}
+			files = append(files, map[string]interface{}{"fileName": name, "contentLength": len(s.files[name]), "action": action})
+		}
+		writeJSON(200, map[string]interface{}{"files": files, "nextFileName": next})
+	case operation == "b2_delete_file_version":
+		delete(s.files, in["fileName"].(string))
+		writeJSON(200, map[string]interface{}{})
+	default:
+		writeJSON(400, map[string]interface{}{"status": 400, "code": "bad_request", "message": "unknown " + r.URL.Path})
+	}
+}
+
+func TestContainer(t *testing.T) {
+	s := newTestServer(t)
+	l := s.location(t, "")
+
+	_, err := l.Container("missing")
+	assert.Equal(t, stow.ErrNotFound, err)
+
+	c, err := l.Container("bucket")
+	assert.Nil(t, err)
+
+	_, err = c.Item("missing.jpg")
+	assert.Equal(t, stow.ErrNotFound, err)
+
+	item, err := c.Put("/dir/a.jpg", bytes.NewReader([]byte("0123456789")), 10, map[string]interface{}{"content-type": "image/jpeg", "x-amz-meta-author": "jan kowalski"})
+	assert.Nil(t, err)
+	etag, _ := item.ETag()
+	assert.Equal(t, sha1Hex([]byte("0123456789")), etag)
+
+	item, err = c.Item("dir/a.jpg")
+	assert.Nil(t, err)
+	size, _ := item.Size()
+	assert.Equal(t, int64(10), size)
+	lastMod, _ := item.LastMod()
+	assert.Equal(t, time.Date(2020, 1, 2, 15, 4, 5, 0, time.UTC), lastMod)
+	meta, _ := item.Metadata()
+	assert.Equal(t, "image/jpeg", meta["content-type"])
+	assert.Equal(t, "jan kowalski", meta["x-amz-meta-author"])
+
+	r, err := item.OpenParams(map[string]interface{}{"range": "bytes=2-4"})
+	assert.Nil(t, err)
+	body, _ := ioutil.ReadAll(r)
+	r.Close()
+	assert.Equal(t, "234", string(body))
+	contentRange, _ := item.ContentRange()
+	assert.Equal(t, "bytes 2-4/10", contentRange.ContentRange)
+	assert.Equal(t, int64(3), contentRange.ContentLength)
+
+	c.Put("dir/b.jpg", bytes.NewReader([]byte("b")), 1, nil)
+	c.Put("dir/sub/c.jpg", bytes.NewReader([]byte("c")), 1, nil)
+	items, cursor, err := c.Items("dir/", "", 2)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(items))
+	assert.Equal(t, "dir/b.jpg", cursor)
+
+	items, cursor, err = c.Items("dir/", cursor, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, "", cursor)
+	assert.Equal(t, 1, len(items))
+	assert.Equal(t, "dir/sub/", items[0].ID())
+	meta, _ = items[0].Metadata()
+	assert.Equal(t, true, meta["is_dir"])
+
+	assert.Nil(t, c.RemoveItem("dir/a.jpg"))
+	assert.Equal(t, stow.ErrNotFound, c.RemoveItem("dir/a.jpg"))
+}
+
+func TestLargeFile(t *testing.T) {
+	s := newTestServer(t)
+	l := s.location(t, "4")
+	c, _ := l.Container("bucket")
+
+	content := []byte("0123456789")
+	item, err := c.Put("large.bin", bytes.NewReader(content), int64(len(content)), nil)
+	assert.Nil(t, err)
+	assert.Equal(t, content, s.files["large.bin"])
+	assert.Equal(t, 3, len(s.parts["large-large.bin"]))
+	etag, _ := item.ETag()
+	assert.Equal(t, "large-large.bin", etag)
+
+	// exactly one part is uploaded as normal file
+	_, err = c.Put("small.bin", bytes.NewReader([]byte("0123")), 4, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("0123"), s.files["small.bin"])
+	assert.Nil(t, s.parts["large-small.bin"])
+}
+
+func TestRetry(t *testing.T) {
+	s := newTestServer(t)
+	l := s.location(t, "")
+	c, _ := l.Container("bucket")
+
+	s.failures["upload"] = 2
+	_, err := c.Put("a.jpg", bytes.NewReader([]byte("a")), 1, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, s.calls["b2_get_upload_url"], "new upload url should be requested for each attempt")
+
+	// expired token is renewed
+	s.token++
+	_, err = c.Item("a.jpg")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, s.authorized)
+
+	s.failures["a.jpg"] = defaultMaxAttempts
+	_, err = c.Item("a.jpg")
+	assert.NotNil(t, err)
+	assert.Equal(t, 429, err.(*apiError).Status)
+}
+
+func TestConfig(t *testing.T) {
+	_, err := stow.Dial(Kind, stow.ConfigMap{ConfigAccountID: "account"})
+	assert.NotNil(t, err)
+
+	s := newTestServer(t)
+	_, err = stow.Dial(Kind, stow.ConfigMap{ConfigAccountID: "account", ConfigApplicationKey: "wrong", ConfigAuthURL: s.URL})
+	assert.NotNil(t, err)
+}
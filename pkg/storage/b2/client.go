@@ -0,0 +1,338 @@
+package b2
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aldor007/stow"
+)
+
+// retryBackoff is delay before first retry, it is doubled for next ones
+var retryBackoff = time.Second
+
+// maxRetryBackoff is max delay between retries
+var maxRetryBackoff = 64 * time.Second
+
+// client calls B2 native API
+type client struct {
+	accountID      string
+	applicationKey string
+	authURL        string
+	partSize       int64
+	attempts       int
+	http           *http.Client
+
+	lock sync.Mutex
+	auth authorization
+}
+
+// authorization is response of b2_authorize_account
+type authorization struct {
+	AccountID               string `json:"accountId"`
+	AuthorizationToken      string `json:"authorizationToken"`
+	APIURL                  string `json:"apiUrl"`
+	DownloadURL             string `json:"downloadUrl"`
+	RecommendedPartSize     int64  `json:"recommendedPartSize"`
+	AbsoluteMinimumPartSize int64  `json:"absoluteMinimumPartSize"`
+}
+
+// apiError is error returned by B2 API
+type apiError struct {
+	Status     int    `json:"status"`
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+	retryAfter time.Duration
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("b2: %d %s %s", e.Status, e.Code, e.Message)
+}
+
+// retryable check if request should be repeated, B2 returns 408, 429 and 503 when client should back off
+func (e *apiError) retryable() bool {
+	return e.Status == http.StatusRequestTimeout || e.Status == http.StatusTooManyRequests || e.Status >= 500
+}
+
+// expiredAuth check if authorization token has to be renewed, responses to HEAD requests don't contain code
+func (e *apiError) expiredAuth() bool {
+	return e.Status == http.StatusUnauthorized && (e.Code == "expired_auth_token" || e.Code == "bad_auth_token" || e.Code == "")
+}
+
+// file describes file stored in B2
+type file struct {
+	FileID          string            `json:"fileId"`
+	FileName        string            `json:"fileName"`
+	ContentLength   int64             `json:"contentLength"`
+	ContentType     string            `json:"contentType"`
+	ContentSha1     string            `json:"contentSha1"`
+	FileInfo        map[string]string `json:"fileInfo"`
+	Action          string            `json:"action"`
+	UploadTimestamp int64             `json:"uploadTimestamp"`
+}
+
+// authorization returns cached authorization or authorizes account
+func (c *client) authorization() (authorization, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.auth.AuthorizationToken != "" {
+		return c.auth, nil
+	}
+
+	req, err := http.NewRequest("GET", c.authURL+"/b2api/v2/b2_authorize_account", nil)
+	if err != nil {
+		return authorization{}, err
+	}
+	req.SetBasicAuth(c.accountID, c.applicationKey)
+
+	res, err := c.http.Do(req)
+	if err != nil {
+		return authorization{}, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return authorization{}, readError(res)
+	}
+
+	var auth authorization
+	if err = json.NewDecoder(res.Body).Decode(&auth); err != nil {
+		return authorization{}, err
+	}
+
+	c.auth = auth
+	return auth, nil
+}
+
+// invalidate removes cached authorization when it wasn't already renewed
+func (c *client) invalidate(token string) {
+	c.lock.Lock()
+	if c.auth.AuthorizationToken == token {
+		c.auth = authorization{}
+	}
+	c.lock.Unlock()
+}
+
+// readError decodes error from response and closes its body
+func readError(res *http.Response) *apiError {
+	defer res.Body.Close()
+	e := &apiError{}
+	// HEAD responses and some proxies don't return JSON body
+	json.NewDecoder(res.Body).Decode(e)
+	e.Status = res.StatusCode
+	if seconds, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil {
+		e.retryAfter = time.Duration(seconds) * time.Second
+	}
+
+	return e
+}
+
+// backoff returns delay before given retry
+func backoff(retry int) time.Duration {
+	d := retryBackoff << uint(retry-1)
+	if d > maxRetryBackoff || d <= 0 {
+		return maxRetryBackoff
+	}
+
+	return d
+}
+
+// do sends request created by newRequest, it is repeated with backoff on network errors, 408, 429 and 5xx
+// and with new token when authorization expired. Not found errors are returned as stow.ErrNotFound
+func (c *client) do(newRequest func(a authorization) (*http.Request, error)) (*http.Response, error) {
+	var lastErr error
+	var delay time.Duration
+	for attempt := 0; attempt < c.attempts; attempt++ {
+		if delay > 0 {
+			time.Sleep(delay)
+			delay = 0
+		}
+
+		auth, err := c.authorization()
+		if err != nil {
+			return nil, err
+		}
+
+		req, err := newRequest(auth)
+		if err != nil {
+			return nil, err
+		}
+
+		res, err := c.http.Do(req)
+		if err != nil {
+			lastErr = err
+			delay = backoff(attempt + 1)
+			continue
+		}
+
+		if res.StatusCode < 400 {
+			return res, nil
+		}
+
+		e := readError(res)
+		switch {
+		case e.Status == http.StatusNotFound || e.Code == "file_not_present" || e.Code == "no_such_file":
+			return nil, stow.ErrNotFound
+		case e.expiredAuth():
+			c.invalidate(auth.AuthorizationToken)
+		case e.retryable():
+			delay = e.retryAfter
+			if delay == 0 {
+				delay = backoff(attempt + 1)
+			}
+		default:
+			return nil, e
+		}
+		lastErr = e
+	}
+
+	return nil, lastErr
+}
+
+// call executes API operation, in is encoded as JSON body and response is decoded to out
+func (c *client) call(operation string, in interface{}, out interface{}) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+
+	res, err := c.do(func(a authorization) (*http.Request, error) {
+		req, err := http.NewRequest("POST", a.APIURL+"/b2api/v2/"+operation, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", a.AuthorizationToken)
+		return req, nil
+	})
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if out == nil {
+		_, err = io.Copy(ioutil.Discard, res.Body)
+		return err
+	}
+
+	return json.NewDecoder(res.Body).Decode(out)
+}
+
+// escapeName encodes file name for use in url and X-Bz-File-Name header
+func escapeName(name string) string {
+	parts := strings.Split(name, "/")
+	for i, p := range parts {
+		parts[i] = url.PathEscape(p)
+	}
+
+	return strings.Join(parts, "/")
+}
+
+// bucketID returns id of bucket of given name
+func (c *client) bucketID(name string) (string, error) {
+	auth, err := c.authorization()
+	if err != nil {
+		return "", err
+	}
+
+	var res struct {
+		Buckets []struct {
+			BucketID   string `json:"bucketId"`
+			BucketName string `json:"bucketName"`
+		} `json:"buckets"`
+	}
+	if err = c.call("b2_list_buckets", map[string]string{"accountId": auth.AccountID, "bucketName": name}, &res); err != nil {
+		return "", err
+	}
+
+	for _, b := range res.Buckets {
+		if b.BucketName == name {
+			return b.BucketID, nil
+		}
+	}
+
+	return "", stow.ErrNotFound
+}
+
+// download requests file using b2_download_file_by_name, method should be GET or HEAD
+func (c *client) download(method string, bucket string, name string, rangeValue string) (*http.Response, error) {
+	return c.do(func(a authorization) (*http.Request, error) {
+		req, err := http.NewRequest(method, a.DownloadURL+"/file/"+url.PathEscape(bucket)+"/"+escapeName(name), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", a.AuthorizationToken)
+		if rangeValue != "" {
+			req.Header.Set("Range", rangeValue)
+		}
+		return req, nil
+	})
+}
+
+// fileFromHeaders reads description of file from headers of download response
+func fileFromHeaders(name string, h http.Header, contentLength int64) file {
+	f := file{
+		FileID:        h.Get("X-Bz-File-Id"),
+		FileName:      name,
+		ContentLength: contentLength,
+		ContentType:   h.Get("Content-Type"),
+		ContentSha1:   h.Get("X-Bz-Content-Sha1"),
+		FileInfo:      make(map[string]string),
+		Action:        "upload",
+	}
+	f.UploadTimestamp, _ = strconv.ParseInt(h.Get("X-Bz-Upload-Timestamp"), 10, 64)
+	for k, v := range h {
+		if strings.HasPrefix(k, "X-Bz-Info-") {
+			value, err := url.PathUnescape(v[0])
+			if err != nil {
+				value = v[0]
+			}
+			f.FileInfo[strings.ToLower(strings.TrimPrefix(k, "X-Bz-Info-"))] = value
+		}
+	}
+
+	return f
+}
+
+// stat returns information about file
+func (c *client) stat(bucket string, name string) (file, error) {
+	res, err := c.download("HEAD", bucket, name, "")
+	if err != nil {
+		return file{}, err
+	}
+	res.Body.Close()
+
+	return fileFromHeaders(name, res.Header, res.ContentLength), nil
+}
+
+// list returns files and folders (with action "folder") which names starts with prefix
+func (c *client) list(bucketID string, prefix string, start string, count int) ([]file, *string, error) {
+	in := map[string]interface{}{"bucketId": bucketID, "prefix": prefix, "delimiter": "/", "maxFileCount": count}
+	if start != "" {
+		in["startFileName"] = start
+	}
+
+	var res struct {
+		Files        []file  `json:"files"`
+		NextFileName *string `json:"nextFileName"`
+	}
+	err := c.call("b2_list_file_names", in, &res)
+	return res.Files, res.NextFileName, err
+}
+
+// remove deletes latest version of file
+func (c *client) remove(bucket string, name string) error {
+	f, err := c.stat(bucket, name)
+	if err != nil {
+		return err
+	}
+
+	return c.call("b2_delete_file_version", map[string]string{"fileName": name, "fileId": f.FileID}, nil)
+}
@@ -0,0 +1,96 @@
+// Package b2 implements stow location working on Backblaze B2 using its native API
+package b2
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/aldor007/stow"
+)
+
+// Kind represents the name of the location/storage type.
+const Kind = "b2-native"
+
+const (
+	// ConfigAccountID is id of account or application key
+	ConfigAccountID = "account_id"
+
+	// ConfigApplicationKey is application key
+	ConfigApplicationKey = "application_key"
+
+	// ConfigAuthURL is url of authorization endpoint, it should be changed only for testing
+	ConfigAuthURL = "auth_url"
+
+	// ConfigPartSize is size in bytes of parts of large files, by default size recommended by B2 is used
+	ConfigPartSize = "part_size"
+
+	// ConfigMaxAttempts is number of attempts made for requests failing with 408, 429 or 5xx
+	ConfigMaxAttempts = "max_attempts"
+)
+
+const (
+	defaultAuthURL     = "https://api.backblazeb2.com"
+	defaultMaxAttempts = 5
+)
+
+func init() {
+	validatefn := func(config stow.Config) error {
+		_, err := newClient(config)
+		return err
+	}
+
+	makefn := func(config stow.Config) (stow.Location, error) {
+		c, err := newClient(config)
+		if err != nil {
+			return nil, err
+		}
+
+		// check if credentials are valid
+		if _, err = c.authorization(); err != nil {
+			return nil, err
+		}
+
+		return &location{client: c}, nil
+	}
+
+	kindfn := func(u *url.URL) bool {
+		return u.Scheme == Kind
+	}
+
+	stow.Register(Kind, makefn, kindfn, validatefn)
+}
+
+// newClient parses location configuration
+func newClient(config stow.Config) (*client, error) {
+	c := &client{authURL: defaultAuthURL, attempts: defaultMaxAttempts, http: &http.Client{}}
+	c.accountID, _ = config.Config(ConfigAccountID)
+	c.applicationKey, _ = config.Config(ConfigApplicationKey)
+	if c.accountID == "" || c.applicationKey == "" {
+		return nil, errors.New("missing account id or application key")
+	}
+
+	if v, ok := config.Config(ConfigAuthURL); ok && v != "" {
+		c.authURL = strings.TrimSuffix(v, "/")
+	}
+
+	if v, ok := config.Config(ConfigPartSize); ok && v != "" {
+		size, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || size <= 0 {
+			return nil, errors.New("invalid part_size")
+		}
+		c.partSize = size
+	}
+
+	if v, ok := config.Config(ConfigMaxAttempts); ok && v != "" {
+		attempts, err := strconv.Atoi(v)
+		if err != nil || attempts <= 0 {
+			return nil, errors.New("invalid max_attempts")
+		}
+		c.attempts = attempts
+	}
+
+	return c, nil
+}
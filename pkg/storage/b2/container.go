@@ -0,0 +1,102 @@
+package b2
+
+import (
+	"io"
+	"strings"
+
+	"github.com/aldor007/stow"
+)
+
+// maxListCount is max number of files returned by single b2_list_file_names call
+const maxListCount = 1000
+
+// container is B2 bucket
+type container struct {
+	name     string
+	id       string
+	location *location
+}
+
+// ID returns name of bucket
+func (c *container) ID() string {
+	return c.name
+}
+
+// Name returns name of bucket
+func (c *container) Name() string {
+	return c.name
+}
+
+// Item returns information about file, it is read from headers of HEAD request
+func (c *container) Item(id string) (stow.Item, error) {
+	name := strings.TrimPrefix(id, "/")
+	f, err := c.location.client.stat(c.name, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return newItem(c, f), nil
+}
+
+// Items returns files and folders which names starts with prefix, listing isn't recursive
+// Cursor is name of last returned item
+func (c *container) Items(prefix, cursor string, count int) ([]stow.Item, string, error) {
+	listCount := count + 1
+	if count <= 0 || listCount > maxListCount {
+		listCount = maxListCount
+	}
+
+	files, next, err := c.location.client.list(c.id, strings.TrimPrefix(prefix, "/"), cursor, listCount)
+	if err != nil {
+		return nil, "", err
+	}
+
+	items := make([]stow.Item, 0, len(files))
+	for _, f := range files {
+		// start file name is inclusive
+		if cursor != "" && f.FileName <= cursor {
+			continue
+		}
+
+		if count > 0 && len(items) == count {
+			return items, items[len(items)-1].ID(), nil
+		}
+		items = append(items, newItem(c, f))
+	}
+
+	if next != nil && len(items) > 0 {
+		return items, items[len(items)-1].ID(), nil
+	}
+
+	return items, "", nil
+}
+
+// RemoveItem deletes latest version of file
+func (c *container) RemoveItem(id string) error {
+	return c.location.client.remove(c.name, strings.TrimPrefix(id, "/"))
+}
+
+// Put uploads file, content-type is sent as file content type and other metadata as file info
+func (c *container) Put(name string, r io.Reader, size int64, metadata map[string]interface{}) (stow.Item, error) {
+	var contentType string
+	info := make(map[string]string, len(metadata))
+	for k, v := range metadata {
+		value, ok := v.(string)
+		if !ok {
+			continue
+		}
+
+		if strings.ToLower(k) == "content-type" {
+			contentType = value
+		} else {
+			info[strings.ToLower(k)] = value
+		}
+	}
+
+	f, err := c.location.client.upload(c.id, strings.TrimPrefix(name, "/"), r, contentType, info)
+	if err != nil {
+		return nil, err
+	}
+
+	return newItem(c, f), nil
+}
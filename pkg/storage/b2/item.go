@@ -0,0 +1,120 @@
+package b2
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aldor007/stow"
+)
+
+// item is file (or folder when listed) in B2 bucket
+type item struct {
+	container *container
+	file      file
+	rangeData stow.ContentRangeData
+}
+
+func newItem(c *container, f file) *item {
+	return &item{container: c, file: f}
+}
+
+// ID returns name of file
+func (i *item) ID() string {
+	return i.file.FileName
+}
+
+// Name returns name of file
+func (i *item) Name() string {
+	return i.file.FileName
+}
+
+// URL returns url of file in form b2-native://bucket/path
+func (i *item) URL() *url.URL {
+	return &url.URL{Scheme: Kind, Host: i.container.name, Path: "/" + i.file.FileName}
+}
+
+// Size returns size of file
+func (i *item) Size() (int64, error) {
+	return i.file.ContentLength, nil
+}
+
+// LastMod returns time of last modification given by uploader in src_last_modified_millis or time of upload
+func (i *item) LastMod() (time.Time, error) {
+	millis := i.file.UploadTimestamp
+	if v, err := strconv.ParseInt(i.file.FileInfo["src_last_modified_millis"], 10, 64); err == nil {
+		millis = v
+	}
+
+	return time.Unix(0, millis*int64(time.Millisecond)).UTC(), nil
+}
+
+// ETag returns SHA1 of file, large files don't have it so their id is used
+func (i *item) ETag() (string, error) {
+	sum := strings.TrimPrefix(i.file.ContentSha1, "unverified:")
+	if sum == "" || sum == "none" {
+		return i.file.FileID, nil
+	}
+
+	return sum, nil
+}
+
+// Metadata returns file info with content type
+func (i *item) Metadata() (map[string]interface{}, error) {
+	metadata := make(map[string]interface{}, len(i.file.FileInfo)+2)
+	for k, v := range i.file.FileInfo {
+		metadata[k] = v
+	}
+
+	metadata["is_dir"] = i.file.Action == "folder"
+	if i.file.ContentType != "" {
+		metadata["content-type"] = i.file.ContentType
+	}
+
+	return metadata, nil
+}
+
+// Open returns content of file
+func (i *item) Open() (io.ReadCloser, error) {
+	res, err := i.container.location.client.download("GET", i.container.name, i.file.FileName, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return res.Body, nil
+}
+
+// OpenParams returns content of file, it supports "range" param with value of Range header
+func (i *item) OpenParams(p map[string]interface{}) (io.ReadCloser, error) {
+	rangeValue, ok := p["range"].(string)
+	if !ok || rangeValue == "" {
+		return i.Open()
+	}
+
+	res, err := i.container.location.client.download("GET", i.container.name, i.file.FileName, rangeValue)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode == http.StatusPartialContent {
+		i.rangeData = stow.ContentRangeData{
+			ContentRange:  res.Header.Get("Content-Range"),
+			ContentLength: res.ContentLength,
+		}
+	}
+
+	return res.Body, nil
+}
+
+// ContentRange returns information about range opened with OpenParams
+func (i *item) ContentRange() (stow.ContentRangeData, error) {
+	if i.rangeData.ContentRange == "" {
+		return stow.ContentRangeData{}, errors.New("response is not a range")
+	}
+
+	return i.rangeData, nil
+}
@@ -0,0 +1,81 @@
+package b2
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+
+	"github.com/aldor007/stow"
+)
+
+// location is B2 account, containers are its buckets
+type location struct {
+	client *client
+}
+
+// HasRanges returns true as ranges are sent to B2 in Range header
+func (l *location) HasRanges() bool {
+	return true
+}
+
+// Close does nothing
+func (l *location) Close() error {
+	return nil
+}
+
+// CreateContainer is not supported, buckets have to be created in B2
+func (l *location) CreateContainer(name string) (stow.Container, error) {
+	return nil, errors.New("b2: creating buckets is not supported")
+}
+
+// Containers returns buckets of account
+func (l *location) Containers(prefix, cursor string, count int) ([]stow.Container, string, error) {
+	auth, err := l.client.authorization()
+	if err != nil {
+		return nil, "", err
+	}
+
+	var res struct {
+		Buckets []struct {
+			BucketID   string `json:"bucketId"`
+			BucketName string `json:"bucketName"`
+		} `json:"buckets"`
+	}
+	if err = l.client.call("b2_list_buckets", map[string]string{"accountId": auth.AccountID}, &res); err != nil {
+		return nil, "", err
+	}
+
+	var containers []stow.Container
+	for _, b := range res.Buckets {
+		if strings.HasPrefix(b.BucketName, prefix) {
+			containers = append(containers, &container{name: b.BucketName, id: b.BucketID, location: l})
+		}
+	}
+
+	return containers, "", nil
+}
+
+// Container returns bucket of given name
+func (l *location) Container(id string) (stow.Container, error) {
+	bucketID, err := l.client.bucketID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &container{name: id, id: bucketID, location: l}, nil
+}
+
+// RemoveContainer is not supported
+func (l *location) RemoveContainer(id string) error {
+	return errors.New("b2: removing buckets is not supported")
+}
+
+// ItemByURL returns item for url in form b2-native://bucket/path
+func (l *location) ItemByURL(u *url.URL) (stow.Item, error) {
+	c, err := l.Container(u.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.Item(u.Path)
+}
@@ -0,0 +1,191 @@
+package b2
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// uploadURL is response of b2_get_upload_url and b2_get_upload_part_url
+type uploadURL struct {
+	UploadURL          string `json:"uploadUrl"`
+	AuthorizationToken string `json:"authorizationToken"`
+}
+
+// readPart reads next part of content, empty part means that content was read fully
+func readPart(r io.Reader, size int64) ([]byte, error) {
+	var buf bytes.Buffer
+	_, err := io.CopyN(&buf, r, size)
+	if err == io.EOF {
+		err = nil
+	}
+
+	return buf.Bytes(), err
+}
+
+func sha1Hex(body []byte) string {
+	sum := sha1.Sum(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// getPartSize returns size of parts of large file
+func (c *client) getPartSize() (int64, error) {
+	if c.partSize > 0 {
+		return c.partSize, nil
+	}
+
+	auth, err := c.authorization()
+	if err != nil {
+		return 0, err
+	}
+
+	return auth.RecommendedPartSize, nil
+}
+
+// upload stores content of r, content fitting in single part is sent with b2_upload_file
+// bigger one is uploaded in parts using large file API
+func (c *client) upload(bucketID string, name string, r io.Reader, contentType string, info map[string]string) (file, error) {
+	partSize, err := c.getPartSize()
+	if err != nil {
+		return file{}, err
+	}
+
+	first, err := readPart(r, partSize)
+	if err != nil {
+		return file{}, err
+	}
+
+	// B2 requires at least 2 parts for large file
+	var second []byte
+	if int64(len(first)) == partSize {
+		if second, err = readPart(r, partSize); err != nil {
+			return file{}, err
+		}
+	}
+
+	if len(second) == 0 {
+		return c.uploadFile(bucketID, name, first, contentType, info)
+	}
+
+	return c.uploadLargeFile(bucketID, name, r, partSize, [][]byte{first, second}, contentType, info)
+}
+
+// uploadFile sends content in single request, upload url is requested again for each attempt as B2 requires
+func (c *client) uploadFile(bucketID string, name string, body []byte, contentType string, info map[string]string) (file, error) {
+	if contentType == "" {
+		contentType = "b2/x-auto"
+	}
+
+	sum := sha1Hex(body)
+	res, err := c.do(func(_ authorization) (*http.Request, error) {
+		var target uploadURL
+		if err := c.call("b2_get_upload_url", map[string]string{"bucketId": bucketID}, &target); err != nil {
+			return nil, err
+		}
+
+		req, err := http.NewRequest("POST", target.UploadURL, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+
+		req.Header.Set("Authorization", target.AuthorizationToken)
+		req.Header.Set("X-Bz-File-Name", escapeName(name))
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("X-Bz-Content-Sha1", sum)
+		for k, v := range info {
+			req.Header.Set("X-Bz-Info-"+k, url.PathEscape(v))
+		}
+		return req, nil
+	})
+	if err != nil {
+		return file{}, err
+	}
+	defer res.Body.Close()
+
+	var f file
+	err = json.NewDecoder(res.Body).Decode(&f)
+	return f, err
+}
+
+// uploadLargeFile uploads content in parts, first parts are already read from r
+// unfinished file is canceled on error
+func (c *client) uploadLargeFile(bucketID string, name string, r io.Reader, partSize int64, parts [][]byte, contentType string, info map[string]string) (file, error) {
+	if contentType == "" {
+		contentType = "b2/x-auto"
+	}
+
+	var started file
+	err := c.call("b2_start_large_file", map[string]interface{}{"bucketId": bucketID, "fileName": name, "contentType": contentType, "fileInfo": info}, &started)
+	if err != nil {
+		return file{}, err
+	}
+
+	f, err := c.uploadParts(started.FileID, r, partSize, parts)
+	if err != nil {
+		c.call("b2_cancel_large_file", map[string]string{"fileId": started.FileID}, nil)
+		return file{}, err
+	}
+
+	return f, nil
+}
+
+func (c *client) uploadParts(fileID string, r io.Reader, partSize int64, parts [][]byte) (file, error) {
+	var sums []string
+	for partNumber := 1; ; partNumber++ {
+		var part []byte
+		if len(parts) > 0 {
+			part, parts = parts[0], parts[1:]
+		} else {
+			var err error
+			if part, err = readPart(r, partSize); err != nil {
+				return file{}, err
+			}
+		}
+
+		if len(part) == 0 {
+			break
+		}
+
+		sum, err := c.uploadPart(fileID, partNumber, part)
+		if err != nil {
+			return file{}, err
+		}
+		sums = append(sums, sum)
+	}
+
+	var f file
+	err := c.call("b2_finish_large_file", map[string]interface{}{"fileId": fileID, "partSha1Array": sums}, &f)
+	return f, err
+}
+
+// uploadPart sends single part of large file and returns its checksum
+func (c *client) uploadPart(fileID string, partNumber int, body []byte) (string, error) {
+	sum := sha1Hex(body)
+	res, err := c.do(func(_ authorization) (*http.Request, error) {
+		var target uploadURL
+		if err := c.call("b2_get_upload_part_url", map[string]string{"fileId": fileID}, &target); err != nil {
+			return nil, err
+		}
+
+		req, err := http.NewRequest("POST", target.UploadURL, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+
+		req.Header.Set("Authorization", target.AuthorizationToken)
+		req.Header.Set("X-Bz-Part-Number", strconv.Itoa(partNumber))
+		req.Header.Set("X-Bz-Content-Sha1", sum)
+		return req, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	res.Body.Close()
+
+	return sum, nil
+}
@@ -0,0 +1,308 @@
+package storage
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aldor007/mort/pkg/config"
+	"github.com/aldor007/mort/pkg/monitoring"
+	"github.com/aldor007/mort/pkg/object"
+	"github.com/aldor007/mort/pkg/response"
+	"go.uber.org/zap"
+)
+
+// HeaderDiskCache name of header informing if object was served from local disk cache
+const HeaderDiskCache = "x-mort-disk-cache"
+
+// diskCacheMetaExt extension of file with headers of cached object
+const diskCacheMetaExt = ".json"
+
+// diskCacheMeta headers of cached object stored next to its content
+type diskCacheMeta struct {
+	Headers       http.Header `json:"headers"`
+	ContentLength int64       `json:"contentLength"`
+}
+
+type diskCacheEntry struct {
+	name string
+	size int64
+}
+
+// diskCache keeps recently fetched objects of storage in local directory
+// Least recently used objects are removed when size of cached objects exceeds max size
+type diskCache struct {
+	dir     string
+	maxSize int64
+
+	lock    sync.Mutex
+	lru     *list.List // front is most recently used
+	entries map[string]*list.Element
+	size    int64
+}
+
+// diskCaches disk caches by storage hash
+var diskCaches = struct {
+	sync.Mutex
+	byHash map[string]*diskCache
+}{byHash: make(map[string]*diskCache)}
+
+// getDiskCache returns disk cache of storage, objects cached by previous process are loaded from its directory
+func getDiskCache(storageCfg config.Storage) *diskCache {
+	diskCaches.Lock()
+	defer diskCaches.Unlock()
+	if c, ok := diskCaches.byHash[storageCfg.Hash]; ok {
+		return c
+	}
+
+	c := newDiskCache(storageCfg.DiskCache.Path, storageCfg.DiskCache.MaxSizeMB<<20)
+	diskCaches.byHash[storageCfg.Hash] = c
+	return c
+}
+
+func newDiskCache(dir string, maxSize int64) *diskCache {
+	c := &diskCache{dir: dir, maxSize: maxSize, lru: list.New(), entries: make(map[string]*list.Element)}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		monitoring.Log().Warn("Storage/diskCache unable to create directory", zap.String("dir", dir), zap.Error(err))
+		return c
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		monitoring.Log().Warn("Storage/diskCache unable to read directory", zap.String("dir", dir), zap.Error(err))
+		return c
+	}
+
+	// time of modification is updated on every hit, so it is time of last use
+	sort.Slice(files, func(a, b int) bool {
+		return files[a].ModTime().After(files[b].ModTime())
+	})
+
+	for _, f := range files {
+		if strings.Contains(f.Name(), ".tmp") {
+			// leftover of interrupted write
+			os.Remove(filepath.Join(dir, f.Name()))
+			continue
+		}
+
+		if f.IsDir() || strings.Contains(f.Name(), ".") {
+			continue
+		}
+		c.entries[f.Name()] = c.lru.PushBack(&diskCacheEntry{name: f.Name(), size: f.Size()})
+		c.size += f.Size()
+	}
+	c.evict()
+
+	return c
+}
+
+// name returns name of file in which object is cached
+func (c *diskCache) name(obj *object.FileObject) string {
+	sum := sha256.Sum256([]byte(obj.Storage.Hash + "/" + obj.Bucket + obj.Key))
+	return hex.EncodeToString(sum[:])
+}
+
+// get returns cached object or nil when object isn't in cache
+func (c *diskCache) get(obj *object.FileObject) *response.Response {
+	name := c.name(obj)
+	c.lock.Lock()
+	elem, ok := c.entries[name]
+	if ok {
+		c.lru.MoveToFront(elem)
+	}
+	c.lock.Unlock()
+	if !ok {
+		return nil
+	}
+
+	filePath := filepath.Join(c.dir, name)
+	data, err := ioutil.ReadFile(filePath + diskCacheMetaExt)
+	if err != nil {
+		c.remove(name)
+		return nil
+	}
+
+	meta := diskCacheMeta{}
+	if err := json.Unmarshal(data, &meta); err != nil {
+		c.remove(name)
+		return nil
+	}
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		c.remove(name)
+		return nil
+	}
+
+	now := time.Now()
+	os.Chtimes(filePath, now, now)
+
+	res := response.New(200, f)
+	if meta.Headers != nil {
+		res.Headers = meta.Headers
+	}
+	res.ContentLength = meta.ContentLength
+	return res
+}
+
+// put stores object in cache, it is called with copy of response which content is read from its stream
+func (c *diskCache) put(obj *object.FileObject, res *response.Response) error {
+	name := c.name(obj)
+	filePath := filepath.Join(c.dir, name)
+	tmp, err := ioutil.TempFile(c.dir, name+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	size, err := io.Copy(tmp, res.Stream())
+	tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	meta, err := json.Marshal(diskCacheMeta{Headers: res.Headers, ContentLength: size})
+	if err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(filePath+diskCacheMetaExt, meta, 0644); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), filePath); err != nil {
+		return err
+	}
+
+	c.lock.Lock()
+	if elem, ok := c.entries[name]; ok {
+		c.size -= elem.Value.(*diskCacheEntry).size
+		c.lru.Remove(elem)
+	}
+	c.entries[name] = c.lru.PushFront(&diskCacheEntry{name: name, size: size})
+	c.size += size
+	c.evict()
+	c.lock.Unlock()
+	return nil
+}
+
+// invalidate removes object from cache, it is called when object is changed or deleted
+func (c *diskCache) invalidate(obj *object.FileObject) {
+	c.remove(c.name(obj))
+}
+
+func (c *diskCache) remove(name string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if elem, ok := c.entries[name]; ok {
+		c.size -= elem.Value.(*diskCacheEntry).size
+		c.lru.Remove(elem)
+		delete(c.entries, name)
+	}
+	c.removeFiles(name)
+}
+
+// evict removes least recently used objects above max size, it has to be called with lock
+func (c *diskCache) evict() {
+	for c.size > c.maxSize {
+		elem := c.lru.Back()
+		if elem == nil {
+			return
+		}
+
+		entry := elem.Value.(*diskCacheEntry)
+		c.lru.Remove(elem)
+		delete(c.entries, entry.name)
+		c.size -= entry.size
+		c.removeFiles(entry.name)
+	}
+}
+
+func (c *diskCache) removeFiles(name string) {
+	filePath := filepath.Join(c.dir, name)
+	os.Remove(filePath)
+	os.Remove(filePath + diskCacheMetaExt)
+}
+
+// validatorsMatch check if cached object has the same validators as object in storage
+// ETag is compared when both have it, otherwise time of last modification is compared
+func validatorsMatch(cached, current http.Header) bool {
+	if etag := cached.Get("ETag"); etag != "" && current.Get("ETag") != "" {
+		return etag == current.Get("ETag")
+	}
+
+	lastMod := cached.Get("Last-Modified")
+	return lastMod != "" && lastMod == current.Get("Last-Modified")
+}
+
+// revalidate check with HEAD request to storage if cached object wasn't changed, which works as conditional GET for all storages
+// It returns cached response when object is unchanged or storage fails, and nil when object has to be fetched again
+func (c *diskCache) revalidate(obj *object.FileObject, cached *response.Response) *response.Response {
+	headRes := Head(obj)
+	headRes.Close()
+	switch {
+	case headRes.StatusCode == 200 && validatorsMatch(cached.Headers, headRes.Headers):
+		monitoring.Report().Inc("disk_cache_count;result:revalidated")
+		cached.Set(HeaderDiskCache, "revalidated")
+		return cached
+	case headRes.StatusCode >= 500:
+		// stale object is better than error
+		monitoring.Report().Inc("disk_cache_count;result:stale")
+		cached.Set(HeaderDiskCache, "stale")
+		return cached
+	}
+
+	cached.Close()
+	c.invalidate(obj)
+	return nil
+}
+
+// getDiskCached fetch object from local disk cache, when it is missing there it is fetched from storage
+// and stored on disk in background while it is sent to client
+func getDiskCached(obj *object.FileObject, fetch func(o *object.FileObject) *response.Response) *response.Response {
+	c := getDiskCache(obj.Storage)
+	if res := c.get(obj); res != nil {
+		if !obj.Storage.DiskCache.Revalidate {
+			monitoring.Report().Inc("disk_cache_count;result:hit")
+			res.Set(HeaderDiskCache, "hit")
+			return res
+		}
+
+		if res = c.revalidate(obj, res); res != nil {
+			return res
+		}
+	}
+
+	monitoring.Report().Inc("disk_cache_count;result:miss")
+	res := fetch(obj)
+	res.Set(HeaderDiskCache, "miss")
+	// partial content and objects bigger than whole cache aren't stored
+	if res.StatusCode != 200 || obj.Range != "" || res.ContentLength <= 0 || res.ContentLength > c.maxSize {
+		return res
+	}
+
+	resCpy, err := res.CopyWithStream()
+	if err != nil {
+		monitoring.Log().Warn("Storage/getDiskCached unable to copy response", obj.LogData(zap.Error(err))...)
+		return res
+	}
+
+	go func() {
+		defer resCpy.Close()
+		if err := c.put(obj, resCpy); err != nil {
+			monitoring.Log().Warn("Storage/getDiskCached unable to store object on disk", obj.LogData(zap.Error(err))...)
+		}
+	}()
+
+	return res
+}
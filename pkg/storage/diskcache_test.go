@@ -0,0 +1,161 @@
+package storage
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aldor007/mort/pkg/config"
+	"github.com/aldor007/mort/pkg/object"
+	"github.com/aldor007/mort/pkg/response"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetDiskCached(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mort-disk-cache")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	mortConfig := config.Config{}
+	err = mortConfig.LoadFromString(`
+buckets:
+    disk-cache:
+        storages:
+            basic:
+                kind: "memory"
+                diskCache:
+                    path: "` + dir + `"
+`)
+	assert.Nil(t, err)
+
+	obj, err := object.NewFileObjectFromPath("/disk-cache/file", &mortConfig)
+	assert.Nil(t, err)
+
+	body := []byte("origin body")
+	res := Set(obj, http.Header{"Content-Type": []string{"text/plain"}}, int64(len(body)), bytes.NewReader(body))
+	assert.Equal(t, 200, res.StatusCode)
+
+	res = Get(obj)
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Equal(t, "miss", res.Headers.Get(HeaderDiskCache))
+	buf, _ := ioutil.ReadAll(res.Stream())
+	assert.Equal(t, "origin body", string(buf))
+	res.Close()
+
+	c := getDiskCache(obj.Storage)
+	for i := 0; i < 100; i++ {
+		if res := c.get(obj); res != nil {
+			res.Close()
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	client, _ := getClient(obj)
+	assert.Nil(t, client.container.RemoveItem(getKey(obj)))
+	res = Get(obj)
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Equal(t, "hit", res.Headers.Get(HeaderDiskCache))
+	assert.Equal(t, "text/plain", res.Headers.Get("Content-Type"))
+	assert.True(t, res.IsFile(), "cached object should be served from file")
+	buf, _ = ioutil.ReadAll(res.Stream())
+	assert.Equal(t, "origin body", string(buf))
+	res.Close()
+
+	assert.Equal(t, 200, Delete(obj).StatusCode)
+	assert.Nil(t, c.get(obj), "deleted object should be removed from cache")
+}
+
+func TestDiskCacheEviction(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mort-disk-cache")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	c := newDiskCache(dir, 20)
+	store := func(key string) *object.FileObject {
+		obj := &object.FileObject{Bucket: "eviction", Key: key, Storage: config.Storage{Hash: "eviction"}}
+		buf := make([]byte, 8)
+		err := c.put(obj, response.NewBuf(200, buf))
+		assert.Nil(t, err)
+		return obj
+	}
+
+	first := store("/first")
+	second := store("/second")
+	res := c.get(first)
+	assert.NotNil(t, res)
+	res.Close()
+	third := store("/third")
+
+	assert.NotNil(t, c.get(first), "recently used object should be kept")
+	assert.Nil(t, c.get(second), "least recently used object should be removed")
+	assert.NotNil(t, c.get(third))
+	assert.Equal(t, int64(16), c.size)
+
+	reloaded := newDiskCache(dir, 20)
+	assert.Equal(t, int64(16), reloaded.size, "cached objects should be loaded from directory")
+}
+
+func TestGetDiskCachedRevalidate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mort-disk-cache")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	mortConfig := config.Config{}
+	err = mortConfig.LoadFromString(`
+buckets:
+    disk-cache-revalidate:
+        storages:
+            basic:
+                kind: "memory"
+                diskCache:
+                    path: "` + dir + `"
+                    revalidate: true
+`)
+	assert.Nil(t, err)
+
+	obj, err := object.NewFileObjectFromPath("/disk-cache-revalidate/file", &mortConfig)
+	assert.Nil(t, err)
+
+	body := []byte("first")
+	Set(obj, http.Header{}, int64(len(body)), bytes.NewReader(body)).Close()
+	res := Get(obj)
+	ioutil.ReadAll(res.Stream())
+	res.Close()
+
+	c := getDiskCache(obj.Storage)
+	for i := 0; i < 100; i++ {
+		if res := c.get(obj); res != nil {
+			res.Close()
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	res = Get(obj)
+	assert.Equal(t, "revalidated", res.Headers.Get(HeaderDiskCache))
+	res.Close()
+
+	// object changed directly in storage
+	client, _ := getClient(obj)
+	body = []byte("second")
+	_, err = client.container.Put(getKey(obj), bytes.NewReader(body), int64(len(body)), nil)
+	assert.Nil(t, err)
+
+	res = Get(obj)
+	assert.Equal(t, "miss", res.Headers.Get(HeaderDiskCache))
+	buf, _ := ioutil.ReadAll(res.Stream())
+	assert.Equal(t, "second", string(buf))
+	res.Close()
+}
+
+func TestValidatorsMatch(t *testing.T) {
+	assert.True(t, validatorsMatch(http.Header{"Etag": []string{"a"}}, http.Header{"Etag": []string{"a"}}))
+	assert.False(t, validatorsMatch(http.Header{"Etag": []string{"a"}}, http.Header{"Etag": []string{"b"}}))
+	lastMod := http.Header{"Last-Modified": []string{"Mon, 02 Jan 2006 15:04:05 GMT"}}
+	assert.True(t, validatorsMatch(lastMod, lastMod))
+	assert.False(t, validatorsMatch(http.Header{}, http.Header{}))
+}
@@ -0,0 +1,551 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/aldor007/mort/pkg/config"
+	"github.com/aldor007/stow"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+	"github.com/karlseguin/ccache"
+)
+
+// Encrypted object starts with magic, content is encrypted with random data key of object. Data key wrapped
+// by KMS or configured key is kept in metadata of object.
+// Content is split into chunks of encryptionChunkSize, each of them is sealed separately with AES-GCM
+// so object can be encrypted and decrypted without buffering it whole.
+const (
+	encryptionMagic      = "MORTAES2"
+	encryptionKeyIDSize  = 4
+	encryptionChunkSize  = 64 * 1024
+	encryptionTagSize    = 16
+	encryptionNonceSize  = 12
+	encryptionDataKey    = 32
+	encryptionHeaderSize = len(encryptionMagic)
+	// dataKeyMetadata is name of metadata entry with wrapped data key, its value has prefix of wrapping method
+	dataKeyMetadata = "mort-data-key"
+	localKeyPrefix  = "local:"
+	kmsKeyPrefix    = "kms:"
+	// data keys unwrapped by KMS are cached, so reading of object doesn't call KMS every time
+	kmsCacheSize = 10000
+	kmsCacheTTL  = time.Hour
+)
+
+var errNotEncrypted = errors.New("object is not encrypted")
+var errUnknownKey = errors.New("object is encrypted with unknown key")
+var errNoDataKey = errors.New("encrypted object has no data key in metadata")
+var errNoMetadataUpdate = errors.New("storage can't update metadata of object")
+
+// encryptionKeys generates data keys of objects and unwraps them
+// New data keys are generated by KMS when it is configured, otherwise they are random and wrapped with current key
+type encryptionKeys struct {
+	current          []byte
+	keys             map[string]cipher.AEAD
+	kms              kmsiface.KMSAPI
+	kmsKeyID         string
+	kmsCache         *ccache.Cache
+	allowUnencrypted bool
+	metadataKey      string // name of metadata entry with data key for kind of storage
+}
+
+func keyID(key []byte) []byte {
+	sum := sha256.Sum256(key)
+	return sum[:encryptionKeyIDSize]
+}
+
+func newEncryptionKeys(cfg config.EncryptionCfg, kind string) (*encryptionKeys, error) {
+	e := &encryptionKeys{keys: make(map[string]cipher.AEAD), allowUnencrypted: cfg.AllowUnencrypted, metadataKey: dataKeyName(kind)}
+	encodedKeys := cfg.PreviousKeys
+	if cfg.Key != "" {
+		encodedKeys = append([]string{cfg.Key}, encodedKeys...)
+	}
+
+	for i, encoded := range encodedKeys {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, err
+		}
+
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, err
+		}
+
+		id := keyID(key)
+		if i == 0 && cfg.Key != "" {
+			e.current = id
+		}
+		e.keys[string(id)] = aead
+	}
+
+	if cfg.KMS != nil {
+		awsConfig := aws.NewConfig()
+		if cfg.KMS.Region != "" {
+			awsConfig = awsConfig.WithRegion(cfg.KMS.Region)
+		}
+
+		sess, err := session.NewSession(awsConfig)
+		if err != nil {
+			return nil, err
+		}
+
+		e.kms = kms.New(sess)
+		e.kmsKeyID = cfg.KMS.KeyID
+		e.kmsCache = ccache.New(ccache.Configure().MaxSize(kmsCacheSize))
+	}
+
+	return e, nil
+}
+
+// dataKeyName returns name of metadata entry with data key, s3 adapters add prefix of user metadata by themselves
+func dataKeyName(kind string) string {
+	if kind == "s3" || kind == "s3-fixed" {
+		return dataKeyMetadata
+	}
+
+	return "x-amz-meta-" + dataKeyMetadata
+}
+
+func isDataKeyName(name string) bool {
+	name = strings.ToLower(name)
+	return name == dataKeyMetadata || name == "x-amz-meta-"+dataKeyMetadata
+}
+
+// wrappedDataKey returns data key stored in metadata of object
+func wrappedDataKey(metadata map[string]interface{}) string {
+	for k, v := range metadata {
+		if isDataKeyName(k) {
+			wrapped, _ := v.(string)
+			return wrapped
+		}
+	}
+
+	return ""
+}
+
+// withDataKey returns copy of metadata with given data key, data key sent by client is dropped
+func withDataKey(metadata map[string]interface{}, name string, wrapped string) map[string]interface{} {
+	result := make(map[string]interface{}, len(metadata)+1)
+	for k, v := range metadata {
+		if !isDataKeyName(k) {
+			result[k] = v
+		}
+	}
+
+	if wrapped != "" {
+		result[name] = wrapped
+	}
+	return result
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// generateDataKey returns new data key and its wrapped form which is stored in metadata
+func (e *encryptionKeys) generateDataKey() ([]byte, string, error) {
+	if e.kms != nil {
+		out, err := e.kms.GenerateDataKey(&kms.GenerateDataKeyInput{KeyId: aws.String(e.kmsKeyID), KeySpec: aws.String(kms.DataKeySpecAes256)})
+		if err != nil {
+			return nil, "", err
+		}
+
+		return out.Plaintext, kmsKeyPrefix + base64.StdEncoding.EncodeToString(out.CiphertextBlob), nil
+	}
+
+	dataKey := make([]byte, encryptionDataKey)
+	nonce := make([]byte, encryptionNonceSize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, "", err
+	}
+
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, "", err
+	}
+
+	// wrapped key: id of key, nonce, encrypted data key with tag
+	wrapped := append(append([]byte{}, e.current...), nonce...)
+	wrapped = e.keys[string(e.current)].Seal(wrapped, nonce, dataKey, e.current)
+	return dataKey, localKeyPrefix + base64.StdEncoding.EncodeToString(wrapped), nil
+}
+
+// unwrapDataKey returns data key from its wrapped form
+func (e *encryptionKeys) unwrapDataKey(wrapped string) ([]byte, error) {
+	switch {
+	case strings.HasPrefix(wrapped, kmsKeyPrefix):
+		if e.kms == nil {
+			return nil, errUnknownKey
+		}
+
+		if item := e.kmsCache.Get(wrapped); item != nil && !item.Expired() {
+			return item.Value().([]byte), nil
+		}
+
+		blob, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(wrapped, kmsKeyPrefix))
+		if err != nil {
+			return nil, err
+		}
+
+		out, err := e.kms.Decrypt(&kms.DecryptInput{CiphertextBlob: blob})
+		if err != nil {
+			return nil, err
+		}
+
+		e.kmsCache.Set(wrapped, out.Plaintext, kmsCacheTTL)
+		return out.Plaintext, nil
+	case strings.HasPrefix(wrapped, localKeyPrefix):
+		buf, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(wrapped, localKeyPrefix))
+		if err != nil {
+			return nil, err
+		}
+
+		if len(buf) < encryptionKeyIDSize+encryptionNonceSize {
+			return nil, errUnknownKey
+		}
+
+		aead, ok := e.keys[string(buf[:encryptionKeyIDSize])]
+		if !ok {
+			return nil, errUnknownKey
+		}
+
+		nonce := buf[encryptionKeyIDSize : encryptionKeyIDSize+encryptionNonceSize]
+		return aead.Open(nil, nonce, buf[encryptionKeyIDSize+encryptionNonceSize:], buf[:encryptionKeyIDSize])
+	}
+
+	return nil, errUnknownKey
+}
+
+// chunkNonce returns nonce for chunk, last chunk is marked so truncation of object is detected
+func chunkNonce(counter uint64, last bool) []byte {
+	nonce := make([]byte, encryptionNonceSize)
+	binary.BigEndian.PutUint64(nonce, counter)
+	if last {
+		nonce[encryptionNonceSize-1] = 1
+	}
+	return nonce
+}
+
+// encryptedSize returns size of encrypted object for given size of content
+func encryptedSize(size int64) int64 {
+	if size < 0 {
+		return size
+	}
+
+	chunks := (size + encryptionChunkSize - 1) / encryptionChunkSize
+	if chunks == 0 {
+		chunks = 1
+	}
+
+	return int64(encryptionHeaderSize) + size + chunks*encryptionTagSize
+}
+
+// decryptedSize returns size of content for given size of encrypted object
+func decryptedSize(size int64) int64 {
+	if size < int64(encryptionHeaderSize+encryptionTagSize) {
+		return size
+	}
+
+	size -= int64(encryptionHeaderSize)
+	chunks := (size + encryptionChunkSize + encryptionTagSize - 1) / (encryptionChunkSize + encryptionTagSize)
+	return size - chunks*encryptionTagSize
+}
+
+// encrypt returns reader with encrypted content of r and wrapped data key, new data key is generated for each object
+func (e *encryptionKeys) encrypt(r io.Reader) (io.Reader, string, error) {
+	dataKey, wrapped, err := e.generateDataKey()
+	if err != nil {
+		return nil, "", err
+	}
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return &encryptReader{r: bufio.NewReader(r), aead: aead, out: []byte(encryptionMagic), plain: make([]byte, encryptionChunkSize)}, wrapped, nil
+}
+
+// decrypt returns reader with decrypted content of r, wrapped is data key from metadata of object
+func (e *encryptionKeys) decrypt(r io.ReadCloser, wrapped string) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	header, err := br.Peek(encryptionHeaderSize)
+	if err != nil && err != io.EOF {
+		r.Close()
+		return nil, err
+	}
+
+	if len(header) < encryptionHeaderSize || !bytes.HasPrefix(header, []byte(encryptionMagic)) {
+		if e.allowUnencrypted {
+			return &bufferedReadCloser{Reader: br, closer: r}, nil
+		}
+
+		r.Close()
+		return nil, errNotEncrypted
+	}
+
+	if wrapped == "" {
+		r.Close()
+		return nil, errNoDataKey
+	}
+
+	dataKey, err := e.unwrapDataKey(wrapped)
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+
+	br.Discard(encryptionHeaderSize)
+	return &decryptReader{r: br, closer: r, aead: aead, buf: make([]byte, encryptionChunkSize+encryptionTagSize)}, nil
+}
+
+// encryptReader seals content of r chunk by chunk
+type encryptReader struct {
+	r       *bufio.Reader
+	aead    cipher.AEAD
+	counter uint64
+	plain   []byte
+	out     []byte
+	done    bool
+}
+
+func (e *encryptReader) Read(p []byte) (int, error) {
+	for len(e.out) == 0 {
+		if e.done {
+			return 0, io.EOF
+		}
+
+		n, err := io.ReadFull(e.r, e.plain)
+		last := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err == nil {
+			_, errPeek := e.r.Peek(1)
+			last = errPeek == io.EOF
+		} else if !last {
+			return 0, err
+		}
+
+		e.out = e.aead.Seal(e.out[:0], chunkNonce(e.counter, last), e.plain[:n], nil)
+		e.counter++
+		e.done = last
+	}
+
+	n := copy(p, e.out)
+	e.out = e.out[n:]
+	return n, nil
+}
+
+// decryptReader opens chunks of encrypted content
+type decryptReader struct {
+	r       *bufio.Reader
+	closer  io.Closer
+	aead    cipher.AEAD
+	counter uint64
+	buf     []byte
+	plain   []byte
+	done    bool
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+
+		n, err := io.ReadFull(d.r, d.buf)
+		last := err == io.ErrUnexpectedEOF
+		if err == nil {
+			_, errPeek := d.r.Peek(1)
+			last = errPeek == io.EOF
+		} else if !last {
+			if err == io.EOF {
+				// object ended before last chunk
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+
+		d.plain, err = d.aead.Open(d.buf[:0], chunkNonce(d.counter, last), d.buf[:n], nil)
+		if err != nil {
+			return 0, err
+		}
+		d.counter++
+		d.done = last
+	}
+
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+func (d *decryptReader) Close() error {
+	return d.closer.Close()
+}
+
+type bufferedReadCloser struct {
+	*bufio.Reader
+	closer io.Closer
+}
+
+func (b *bufferedReadCloser) Close() error {
+	return b.closer.Close()
+}
+
+// encryptedLocation disables ranges as encrypted objects have to be read from beginning
+type encryptedLocation struct {
+	stow.Location
+}
+
+// HasRanges returns false
+func (l *encryptedLocation) HasRanges() bool {
+	return false
+}
+
+// encryptedContainer encrypts objects on Put and decrypts them on read
+type encryptedContainer struct {
+	stow.Container
+	keys *encryptionKeys
+}
+
+// Item returns item which content is decrypted
+func (c *encryptedContainer) Item(id string) (stow.Item, error) {
+	item, err := c.Container.Item(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &encryptedItem{Item: item, keys: c.keys}, nil
+}
+
+// Items returns items with sizes of decrypted content
+func (c *encryptedContainer) Items(prefix, cursor string, count int) ([]stow.Item, string, error) {
+	items, next, err := c.Container.Items(prefix, cursor, count)
+	for i, item := range items {
+		items[i] = &encryptedItem{Item: item, keys: c.keys}
+	}
+
+	return items, next, err
+}
+
+// Put encrypts content of r and stores it
+func (c *encryptedContainer) Put(name string, r io.Reader, size int64, metadata map[string]interface{}) (stow.Item, error) {
+	encrypted, wrapped, err := c.keys.encrypt(r)
+	if err != nil {
+		return nil, err
+	}
+
+	item, err := c.Container.Put(name, encrypted, encryptedSize(size), withDataKey(metadata, c.keys.metadataKey, wrapped))
+	if err != nil {
+		return nil, err
+	}
+
+	return &encryptedItem{Item: item, keys: c.keys}, nil
+}
+
+// UpdateMetadata replaces metadata of object when wrapped container is able to do it without transfer of content
+// Content stays encrypted, so data key is kept in new metadata. Other containers get object stored again through Put
+func (c *encryptedContainer) UpdateMetadata(id string, metadata map[string]interface{}) error {
+	updater, ok := c.Container.(metadataUpdater)
+	if !ok {
+		return errNoMetadataUpdate
+	}
+
+	item, err := c.Container.Item(id)
+	if err != nil {
+		return err
+	}
+
+	current, err := item.Metadata()
+	if err != nil {
+		return err
+	}
+
+	return updater.UpdateMetadata(id, withDataKey(metadata, c.keys.metadataKey, wrappedDataKey(current)))
+}
+
+// encryptedItem decrypts content of item
+type encryptedItem struct {
+	stow.Item
+	keys *encryptionKeys
+}
+
+// Size returns size of decrypted content
+func (i *encryptedItem) Size() (int64, error) {
+	size, err := i.Item.Size()
+	if err != nil {
+		return size, err
+	}
+
+	return decryptedSize(size), nil
+}
+
+// Metadata returns metadata of object without its data key
+func (i *encryptedItem) Metadata() (map[string]interface{}, error) {
+	metadata, err := i.Item.Metadata()
+	if err != nil || wrappedDataKey(metadata) == "" {
+		return metadata, err
+	}
+
+	return withDataKey(metadata, "", ""), nil
+}
+
+// Open returns decrypted content
+func (i *encryptedItem) Open() (io.ReadCloser, error) {
+	metadata, err := i.Item.Metadata()
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := i.Item.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	return i.keys.decrypt(r, wrappedDataKey(metadata))
+}
+
+// OpenParams ignores params as ranges aren't supported for encrypted objects
+func (i *encryptedItem) OpenParams(_ map[string]interface{}) (io.ReadCloser, error) {
+	return i.Open()
+}
+
+// ContentRange returns error as ranges aren't supported for encrypted objects
+func (i *encryptedItem) ContentRange() (stow.ContentRangeData, error) {
+	return stow.ContentRangeData{}, errors.New("response is not a range")
+}
+
+// withEncryption wraps storage client when storage has encryption configured
+func withEncryption(instance storageClient, kind string, cfg *config.EncryptionCfg) (storageClient, error) {
+	if cfg == nil {
+		return instance, nil
+	}
+
+	keys, err := newEncryptionKeys(*cfg, kind)
+	if err != nil {
+		return storageClient{}, err
+	}
+
+	return storageClient{container: &encryptedContainer{Container: instance.container, keys: keys}, client: &encryptedLocation{Location: instance.client}}, nil
+}
@@ -0,0 +1,194 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"testing"
+
+	"github.com/aldor007/mort/pkg/config"
+	memoryStorage "github.com/aldor007/mort/pkg/storage/memory"
+	"github.com/aldor007/stow"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+	"github.com/stretchr/testify/assert"
+)
+
+func testEncryptedClient(t *testing.T, cfg config.EncryptionCfg) (storageClient, stow.Container) {
+	location, err := stow.Dial(memoryStorage.Kind, stow.ConfigMap{})
+	assert.Nil(t, err)
+	container, _ := location.Container("bucket")
+	instance, err := withEncryption(storageClient{container, location}, memoryStorage.Kind, &cfg)
+	assert.Nil(t, err)
+	return instance, container
+}
+
+func testKey(b byte) string {
+	return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, 32))
+}
+
+func TestEncryption(t *testing.T) {
+	instance, raw := testEncryptedClient(t, config.EncryptionCfg{Key: testKey(1)})
+	assert.False(t, instance.client.HasRanges())
+
+	for _, size := range []int{0, 10, encryptionChunkSize, 2*encryptionChunkSize + 7} {
+		content := bytes.Repeat([]byte("a"), size)
+		_, err := instance.container.Put("file", bytes.NewReader(content), int64(size), nil)
+		assert.Nil(t, err)
+
+		rawItem, _ := raw.Item("file")
+		rawSize, _ := rawItem.Size()
+		assert.Equal(t, encryptedSize(int64(size)), rawSize)
+		r, _ := rawItem.Open()
+		stored, _ := ioutil.ReadAll(r)
+		assert.False(t, size > 0 && bytes.Contains(stored, content[:size/2]), "content should be encrypted")
+
+		item, err := instance.container.Item("file")
+		assert.Nil(t, err)
+		itemSize, _ := item.Size()
+		assert.Equal(t, int64(size), itemSize)
+		r, err = item.Open()
+		assert.Nil(t, err)
+		body, err := ioutil.ReadAll(r)
+		assert.Nil(t, err)
+		assert.Equal(t, content, body)
+	}
+}
+
+func TestEncryptionTampered(t *testing.T) {
+	instance, raw := testEncryptedClient(t, config.EncryptionCfg{Key: testKey(1)})
+	content := bytes.Repeat([]byte("a"), encryptionChunkSize+10)
+	instance.container.Put("file", bytes.NewReader(content), int64(len(content)), nil)
+
+	rawItem, _ := raw.Item("file")
+	metadata, _ := rawItem.Metadata()
+	r, _ := rawItem.Open()
+	stored, _ := ioutil.ReadAll(r)
+
+	// truncated after first chunk
+	raw.Put("truncated", bytes.NewReader(stored[:encryptionHeaderSize+encryptionChunkSize+encryptionTagSize]), 0, metadata)
+	item, _ := instance.container.Item("truncated")
+	r, err := item.Open()
+	assert.Nil(t, err)
+	_, err = ioutil.ReadAll(r)
+	assert.NotNil(t, err)
+
+	modified := append([]byte{}, stored...)
+	modified[len(modified)-1] ^= 1
+	raw.Put("modified", bytes.NewReader(modified), 0, metadata)
+	item, _ = instance.container.Item("modified")
+	r, _ = item.Open()
+	_, err = ioutil.ReadAll(r)
+	assert.NotNil(t, err)
+
+	raw.Put("no-key", bytes.NewReader(stored), 0, nil)
+	item, _ = instance.container.Item("no-key")
+	_, err = item.Open()
+	assert.Equal(t, errNoDataKey, err)
+}
+
+func TestEncryptionKeys(t *testing.T) {
+	old, raw := testEncryptedClient(t, config.EncryptionCfg{Key: testKey(1)})
+	old.container.Put("old", bytes.NewReader([]byte("old")), 3, nil)
+	raw.Put("plain", bytes.NewReader([]byte("plain")), 5, nil)
+
+	rotated, err := withEncryption(storageClient{raw, nil}, memoryStorage.Kind, &config.EncryptionCfg{Key: testKey(2), PreviousKeys: []string{testKey(1)}})
+	assert.Nil(t, err)
+	item, _ := rotated.container.Item("old")
+	r, err := item.Open()
+	assert.Nil(t, err)
+	body, _ := ioutil.ReadAll(r)
+	assert.Equal(t, "old", string(body))
+
+	item, _ = rotated.container.Item("plain")
+	_, err = item.Open()
+	assert.Equal(t, errNotEncrypted, err)
+
+	other, _ := withEncryption(storageClient{raw, nil}, memoryStorage.Kind, &config.EncryptionCfg{Key: testKey(3), AllowUnencrypted: true})
+	item, _ = other.container.Item("old")
+	_, err = item.Open()
+	assert.Equal(t, errUnknownKey, err)
+
+	item, _ = other.container.Item("plain")
+	r, err = item.Open()
+	assert.Nil(t, err)
+	body, _ = ioutil.ReadAll(r)
+	assert.Equal(t, "plain", string(body))
+}
+
+type fakeKMS struct {
+	kmsiface.KMSAPI
+	decrypts int
+}
+
+func (f *fakeKMS) GenerateDataKey(input *kms.GenerateDataKeyInput) (*kms.GenerateDataKeyOutput, error) {
+	key := bytes.Repeat([]byte{7}, 32)
+	return &kms.GenerateDataKeyOutput{KeyId: input.KeyId, Plaintext: key, CiphertextBlob: append([]byte("wrapped-"), key...)}, nil
+}
+
+func (f *fakeKMS) Decrypt(input *kms.DecryptInput) (*kms.DecryptOutput, error) {
+	f.decrypts++
+	return &kms.DecryptOutput{Plaintext: bytes.TrimPrefix(input.CiphertextBlob, []byte("wrapped-"))}, nil
+}
+
+func TestEncryptionKMS(t *testing.T) {
+	instance, raw := testEncryptedClient(t, config.EncryptionCfg{KMS: &config.KMSCfg{KeyID: "alias/mort", Region: "eu-west-1"}, PreviousKeys: []string{testKey(1)}})
+	fake := &fakeKMS{}
+	instance.container.(*encryptedContainer).keys.kms = fake
+
+	_, err := instance.container.Put("file", bytes.NewReader([]byte("content")), 7, map[string]interface{}{"x-amz-meta-author": "mort"})
+	assert.Nil(t, err)
+
+	rawItem, _ := raw.Item("file")
+	rawMetadata, _ := rawItem.Metadata()
+	assert.Equal(t, "kms:"+base64.StdEncoding.EncodeToString(append([]byte("wrapped-"), bytes.Repeat([]byte{7}, 32)...)), rawMetadata["x-amz-meta-mort-data-key"])
+
+	item, _ := instance.container.Item("file")
+	metadata, _ := item.Metadata()
+	assert.Equal(t, "mort", metadata["x-amz-meta-author"])
+	assert.Nil(t, metadata["x-amz-meta-mort-data-key"], "data key shouldn't be visible outside of storage")
+
+	for i := 0; i < 2; i++ {
+		r, err := item.Open()
+		assert.Nil(t, err)
+		body, _ := ioutil.ReadAll(r)
+		assert.Equal(t, "content", string(body))
+	}
+	assert.Equal(t, 1, fake.decrypts, "unwrapped data key should be cached")
+
+	// objects with data key wrapped by previous local key are still readable
+	old, _ := testEncryptedClient(t, config.EncryptionCfg{Key: testKey(1)})
+	old.container.Put("old", bytes.NewReader([]byte("old")), 3, nil)
+	oldItem, _ := old.container.(*encryptedContainer).Container.Item("old")
+	r, _ := oldItem.Open()
+	stored, _ := ioutil.ReadAll(r)
+	oldMetadata, _ := oldItem.Metadata()
+	raw.Put("old", bytes.NewReader(stored), int64(len(stored)), oldMetadata)
+
+	item, _ = instance.container.Item("old")
+	r, err = item.Open()
+	assert.Nil(t, err)
+	body, _ := ioutil.ReadAll(r)
+	assert.Equal(t, "old", string(body))
+}
+
+func TestEncryptionUpdateMetadata(t *testing.T) {
+	location, _ := stow.Dial(memoryStorage.Kind, stow.ConfigMap{})
+	raw, _ := location.Container("bucket")
+	instance, err := withEncryption(storageClient{withMetadataSidecar("ftp", raw), location}, "ftp", &config.EncryptionCfg{Key: testKey(1)})
+	assert.Nil(t, err)
+
+	_, err = instance.container.Put("file", bytes.NewReader([]byte("content")), 7, nil)
+	assert.Nil(t, err)
+
+	updater := instance.container.(metadataUpdater)
+	assert.Nil(t, updater.UpdateMetadata("file", map[string]interface{}{"x-amz-meta-author": "mort", "x-amz-meta-mort-data-key": "local:forged"}))
+
+	item, _ := instance.container.Item("file")
+	metadata, _ := item.Metadata()
+	assert.Equal(t, "mort", metadata["x-amz-meta-author"])
+	r, err := item.Open()
+	assert.Nil(t, err)
+	body, _ := ioutil.ReadAll(r)
+	assert.Equal(t, "content", string(body), "data key should be kept when metadata is replaced")
+}
@@ -0,0 +1,134 @@
+// Package ftp implements stow location working on FTP and FTPS servers
+package ftp
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/aldor007/stow"
+)
+
+// Kind represents the name of the location/storage type.
+const Kind = "ftp"
+
+const (
+	// ConfigAddress is address of FTP server (host:port)
+	ConfigAddress = "address"
+
+	// ConfigUsername is name of FTP user, anonymous is used when empty
+	ConfigUsername = "username"
+
+	// ConfigPassword is password of FTP user
+	ConfigPassword = "password"
+
+	// ConfigTLS enables FTPS, "explicit" upgrades connection with AUTH TLS, "implicit" uses TLS from start
+	ConfigTLS = "tls"
+
+	// ConfigInsecureSkipVerify disables verification of server certificate when set to "true"
+	ConfigInsecureSkipVerify = "insecure_skip_verify"
+
+	// ConfigTimeout is timeout of network operations in seconds
+	ConfigTimeout = "timeout"
+
+	// ConfigMaxIdleConns is number of idle connections kept for reuse
+	ConfigMaxIdleConns = "max_idle_conns"
+)
+
+const (
+	tlsExplicit = "explicit"
+	tlsImplicit = "implicit"
+)
+
+const (
+	defaultTimeout      = 30 * time.Second
+	defaultMaxIdleConns = 4
+)
+
+func init() {
+	validatefn := func(config stow.Config) error {
+		_, err := newDialer(config)
+		return err
+	}
+
+	makefn := func(config stow.Config) (stow.Location, error) {
+		d, err := newDialer(config)
+		if err != nil {
+			return nil, err
+		}
+
+		maxIdle := defaultMaxIdleConns
+		if v, ok := config.Config(ConfigMaxIdleConns); ok && v != "" {
+			maxIdle, err = strconv.Atoi(v)
+			if err != nil {
+				return nil, errors.New("invalid max_idle_conns")
+			}
+		}
+
+		l := &location{dialer: d, idle: make(chan *conn, maxIdle)}
+		// check if server is reachable and credentials are valid
+		c, err := l.get()
+		if err != nil {
+			return nil, err
+		}
+		l.put(c)
+
+		return l, nil
+	}
+
+	kindfn := func(u *url.URL) bool {
+		return u.Scheme == Kind
+	}
+
+	stow.Register(Kind, makefn, kindfn, validatefn)
+}
+
+// newDialer parses location configuration
+func newDialer(config stow.Config) (*dialer, error) {
+	address, ok := config.Config(ConfigAddress)
+	if !ok || address == "" {
+		return nil, errors.New("missing address")
+	}
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, errors.New("invalid address, expected host:port")
+	}
+
+	d := &dialer{address: address, timeout: defaultTimeout}
+	d.username, _ = config.Config(ConfigUsername)
+	d.password, _ = config.Config(ConfigPassword)
+	if d.username == "" {
+		d.username = "anonymous"
+		d.password = "anonymous"
+	}
+
+	if v, ok := config.Config(ConfigTimeout); ok && v != "" {
+		seconds, err := strconv.Atoi(v)
+		if err != nil || seconds <= 0 {
+			return nil, errors.New("invalid timeout")
+		}
+		d.timeout = time.Duration(seconds) * time.Second
+	}
+
+	mode, _ := config.Config(ConfigTLS)
+	switch mode {
+	case "":
+	case tlsExplicit, tlsImplicit:
+		skipVerify, _ := config.Config(ConfigInsecureSkipVerify)
+		d.tlsMode = mode
+		d.tlsConfig = &tls.Config{
+			ServerName:         host,
+			InsecureSkipVerify: skipVerify == "true",
+			// data connections have to reuse session of control connection on most of servers
+			ClientSessionCache: tls.NewLRUClientSessionCache(0),
+		}
+	default:
+		return nil, errors.New("invalid tls mode, expected explicit or implicit")
+	}
+
+	return d, nil
+}
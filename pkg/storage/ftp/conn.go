@@ -0,0 +1,456 @@
+package ftp
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	errNotFound    = errors.New("file not found")                   // returned when server responds that file doesn't exist
+	errInvalidName = errors.New("name contains control characters") // returned for names which would inject FTP commands
+)
+
+// dialer creates new connections to FTP server
+type dialer struct {
+	address   string
+	username  string
+	password  string
+	timeout   time.Duration
+	tlsMode   string
+	tlsConfig *tls.Config
+}
+
+// conn is control connection to FTP server, it can't be used concurrently
+type conn struct {
+	dialer  *dialer
+	netConn net.Conn
+	text    *textproto.Conn
+	broken  bool
+}
+
+// entry describes file or directory on FTP server
+type entry struct {
+	name    string
+	size    int64
+	modTime time.Time
+	isDir   bool
+}
+
+// dial opens control connection and logs in
+func (d *dialer) dial() (*conn, error) {
+	netConn, err := net.DialTimeout("tcp", d.address, d.timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	if d.tlsMode == tlsImplicit {
+		netConn = tls.Client(netConn, d.tlsConfig)
+	}
+
+	c := &conn{dialer: d, netConn: netConn, text: textproto.NewConn(netConn)}
+	if err = c.login(); err != nil {
+		c.close()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (c *conn) login() error {
+	c.netConn.SetDeadline(time.Now().Add(c.dialer.timeout))
+	defer c.netConn.SetDeadline(time.Time{})
+
+	if _, _, err := c.text.ReadResponse(220); err != nil {
+		return err
+	}
+
+	if c.dialer.tlsMode == tlsExplicit {
+		if _, err := c.cmd(234, "AUTH TLS"); err != nil {
+			return err
+		}
+		c.netConn = tls.Client(c.netConn, c.dialer.tlsConfig)
+		c.text = textproto.NewConn(c.netConn)
+	}
+
+	code, _, err := c.cmdAny("USER %s", c.dialer.username)
+	if err != nil {
+		return err
+	}
+
+	if code == 331 {
+		if _, err = c.cmd(230, "PASS %s", c.dialer.password); err != nil {
+			return err
+		}
+	} else if code != 230 {
+		return fmt.Errorf("ftp: login failed with code %d", code)
+	}
+
+	if c.dialer.tlsMode != "" {
+		if _, err = c.cmd(200, "PBSZ 0"); err != nil {
+			return err
+		}
+
+		if _, err = c.cmd(200, "PROT P"); err != nil {
+			return err
+		}
+	}
+
+	_, err = c.cmd(200, "TYPE I")
+	return err
+}
+
+// hasControlChars check if name contains CR, LF, NUL or other control characters
+func hasControlChars(name string) bool {
+	for _, r := range name {
+		if r < 0x20 || r == 0x7f {
+			return true
+		}
+	}
+
+	return false
+}
+
+// cmdAny sends command and returns code of server response
+// Arguments with control characters are rejected, so keys can't end command and inject next one
+func (c *conn) cmdAny(format string, args ...interface{}) (int, string, error) {
+	for _, arg := range args {
+		if s, ok := arg.(string); ok && hasControlChars(s) {
+			return 0, "", errInvalidName
+		}
+	}
+
+	id, err := c.text.Cmd(format, args...)
+	if err != nil {
+		c.broken = true
+		return 0, "", err
+	}
+
+	c.text.StartResponse(id)
+	defer c.text.EndResponse(id)
+	code, msg, err := c.text.ReadResponse(0)
+	if err != nil {
+		c.broken = true
+	}
+	return code, msg, err
+}
+
+// cmd sends command and checks if server responded with expected code
+func (c *conn) cmd(expectCode int, format string, args ...interface{}) (string, error) {
+	code, msg, err := c.cmdAny(format, args...)
+	if err != nil {
+		return "", err
+	}
+
+	return msg, checkCode(code, msg, expectCode)
+}
+
+func checkCode(code int, msg string, expectCode int) error {
+	if code == expectCode {
+		return nil
+	}
+
+	if code == 550 {
+		return errNotFound
+	}
+
+	return &textproto.Error{Code: code, Msg: msg}
+}
+
+// dataConn opens passive data connection
+func (c *conn) dataConn() (net.Conn, error) {
+	host, _, _ := net.SplitHostPort(c.dialer.address)
+	var port int
+	code, msg, err := c.cmdAny("EPSV")
+	if err != nil {
+		return nil, err
+	}
+
+	if code == 229 {
+		// 229 Entering Extended Passive Mode (|||port|)
+		start := strings.Index(msg, "(")
+		end := strings.LastIndex(msg, ")")
+		if start == -1 || end < start {
+			return nil, fmt.Errorf("ftp: invalid EPSV response %s", msg)
+		}
+		parts := strings.Split(msg[start+1:end], "|")
+		if len(parts) != 5 {
+			return nil, fmt.Errorf("ftp: invalid EPSV response %s", msg)
+		}
+		port, err = strconv.Atoi(parts[3])
+	} else {
+		port, err = c.pasv()
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	// address returned in PASV response is ignored as it is often wrong behind NAT
+	dataConn, err := net.DialTimeout("tcp", net.JoinHostPort(host, strconv.Itoa(port)), c.dialer.timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.dialer.tlsMode != "" {
+		dataConn = tls.Client(dataConn, c.dialer.tlsConfig)
+	}
+
+	return dataConn, nil
+}
+
+// pasv enters passive mode and returns data port
+func (c *conn) pasv() (int, error) {
+	// 227 Entering Passive Mode (h1,h2,h3,h4,p1,p2)
+	msg, err := c.cmd(227, "PASV")
+	if err != nil {
+		return 0, err
+	}
+
+	start := strings.Index(msg, "(")
+	end := strings.LastIndex(msg, ")")
+	if start == -1 || end < start {
+		return 0, fmt.Errorf("ftp: invalid PASV response %s", msg)
+	}
+
+	parts := strings.Split(msg[start+1:end], ",")
+	if len(parts) != 6 {
+		return 0, fmt.Errorf("ftp: invalid PASV response %s", msg)
+	}
+
+	p1, err1 := strconv.Atoi(parts[4])
+	p2, err2 := strconv.Atoi(parts[5])
+	if err1 != nil || err2 != nil {
+		return 0, fmt.Errorf("ftp: invalid PASV response %s", msg)
+	}
+
+	return p1<<8 | p2, nil
+}
+
+// transfer opens data connection and sends command which uses it
+func (c *conn) transfer(format string, args ...interface{}) (net.Conn, error) {
+	dataConn, err := c.dataConn()
+	if err != nil {
+		return nil, err
+	}
+
+	code, msg, err := c.cmdAny(format, args...)
+	if err != nil {
+		dataConn.Close()
+		return nil, err
+	}
+
+	if code < 100 || code >= 200 {
+		dataConn.Close()
+		return nil, checkCode(code, msg, 150)
+	}
+
+	return dataConn, nil
+}
+
+// finish reads response closing data transfer
+func (c *conn) finish() error {
+	c.netConn.SetReadDeadline(time.Now().Add(c.dialer.timeout))
+	defer c.netConn.SetReadDeadline(time.Time{})
+	code, msg, err := c.text.ReadResponse(0)
+	if err != nil {
+		c.broken = true
+		return err
+	}
+
+	if code != 226 && code != 250 {
+		return checkCode(code, msg, 226)
+	}
+
+	return nil
+}
+
+// size returns size of file
+func (c *conn) size(path string) (int64, error) {
+	msg, err := c.cmd(213, "SIZE %s", path)
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseInt(strings.TrimSpace(msg), 10, 64)
+}
+
+// modTime returns time of last modification of file
+func (c *conn) modTime(path string) (time.Time, error) {
+	msg, err := c.cmd(213, "MDTM %s", path)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return parseTime(strings.TrimSpace(msg))
+}
+
+// stat returns information about file using SIZE and MDTM commands
+func (c *conn) stat(path string) (entry, error) {
+	size, err := c.size(path)
+	if err != nil {
+		return entry{}, err
+	}
+
+	modTime, err := c.modTime(path)
+	if err != nil {
+		return entry{}, err
+	}
+
+	return entry{name: path, size: size, modTime: modTime}, nil
+}
+
+// isDir check if path is existing directory
+func (c *conn) isDir(path string) (bool, error) {
+	code, msg, err := c.cmdAny("CWD %s", path)
+	if err != nil {
+		return false, err
+	}
+
+	if code == 550 {
+		return false, nil
+	}
+
+	if code != 250 {
+		return false, checkCode(code, msg, 250)
+	}
+
+	_, err = c.cmd(250, "CWD /")
+	return true, err
+}
+
+// mkdirAll creates directory with all missing parents
+func (c *conn) mkdirAll(path string) error {
+	dir := ""
+	for _, part := range strings.Split(strings.Trim(path, "/"), "/") {
+		if part == "" {
+			continue
+		}
+		dir = dir + "/" + part
+		code, msg, err := c.cmdAny("MKD %s", dir)
+		if err != nil {
+			return err
+		}
+
+		// 550 is returned when directory already exists
+		if code != 257 && code != 550 {
+			return checkCode(code, msg, 257)
+		}
+	}
+
+	return nil
+}
+
+// list returns entries of directory using MLSD command
+func (c *conn) list(path string) ([]entry, error) {
+	dataConn, err := c.transfer("MLSD %s", path)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []entry
+	scanner := bufio.NewScanner(dataConn)
+	for scanner.Scan() {
+		e, ok := parseMLSD(scanner.Text())
+		if ok {
+			entries = append(entries, e)
+		}
+	}
+	dataConn.Close()
+
+	if err = scanner.Err(); err != nil {
+		c.broken = true
+		return nil, err
+	}
+
+	return entries, c.finish()
+}
+
+// retrieve opens file for reading starting at offset
+func (c *conn) retrieve(path string, offset int64) (net.Conn, error) {
+	if offset > 0 {
+		if _, err := c.cmd(350, "REST %d", offset); err != nil {
+			return nil, err
+		}
+	}
+
+	return c.transfer("RETR %s", path)
+}
+
+// store uploads content of r to file
+func (c *conn) store(path string, r io.Reader) error {
+	dataConn, err := c.transfer("STOR %s", path)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(dataConn, r)
+	dataConn.Close()
+	if err != nil {
+		c.broken = true
+		return err
+	}
+
+	return c.finish()
+}
+
+// remove deletes file
+func (c *conn) remove(path string) error {
+	_, err := c.cmd(250, "DELE %s", path)
+	return err
+}
+
+func (c *conn) close() error {
+	if !c.broken {
+		c.text.Cmd("QUIT")
+	}
+	return c.text.Close()
+}
+
+// parseMLSD parses single line of MLSD response e.g. "type=file;size=1024;modify=20200102150405; name.jpg"
+func parseMLSD(line string) (entry, bool) {
+	i := strings.Index(line, " ")
+	if i == -1 {
+		return entry{}, false
+	}
+
+	e := entry{name: line[i+1:]}
+	for _, fact := range strings.Split(line[:i], ";") {
+		kv := strings.SplitN(fact, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+
+		switch strings.ToLower(kv[0]) {
+		case "type":
+			switch strings.ToLower(kv[1]) {
+			case "dir":
+				e.isDir = true
+			case "cdir", "pdir":
+				return entry{}, false
+			}
+		case "size":
+			e.size, _ = strconv.ParseInt(kv[1], 10, 64)
+		case "modify":
+			e.modTime, _ = parseTime(kv[1])
+		}
+	}
+
+	return e, true
+}
+
+// parseTime parses time in format used by MDTM and MLSD (YYYYMMDDhhmmss[.sss])
+func parseTime(value string) (time.Time, error) {
+	if i := strings.Index(value, "."); i != -1 {
+		value = value[:i]
+	}
+
+	return time.ParseInLocation("20060102150405", value, time.UTC)
+}
@@ -0,0 +1,140 @@
+package ftp
+
+import (
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aldor007/stow"
+)
+
+// container is directory on FTP server
+type container struct {
+	name     string
+	location *location
+}
+
+// ID returns name of container
+func (c *container) ID() string {
+	return c.name
+}
+
+// Name returns name of container
+func (c *container) Name() string {
+	return c.name
+}
+
+// filePath returns absolute path of item on server
+func (c *container) filePath(id string) string {
+	return path.Join("/", c.name, id)
+}
+
+// Item returns information about file, they are read using SIZE and MDTM commands
+func (c *container) Item(id string) (stow.Item, error) {
+	var e entry
+	err := c.location.do(func(conn *conn) (err error) {
+		e, err = conn.stat(c.filePath(id))
+		return err
+	})
+	if err == errNotFound {
+		return nil, stow.ErrNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return newItem(c, strings.TrimPrefix(id, "/"), e), nil
+}
+
+// Items returns content of directory given in prefix (or parent directory of prefix) using MLSD command
+// Listing isn't recursive, directories are returned as items
+func (c *container) Items(prefix, cursor string, count int) ([]stow.Item, string, error) {
+	prefix = strings.TrimPrefix(prefix, "/")
+	dir, namePrefix := prefix, ""
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		var isDir bool
+		err := c.location.do(func(conn *conn) (err error) {
+			isDir, err = conn.isDir(c.filePath(prefix))
+			return err
+		})
+		if err != nil {
+			return nil, "", err
+		}
+
+		if !isDir {
+			dir, namePrefix = path.Split(prefix)
+		}
+	}
+
+	var entries []entry
+	err := c.location.do(func(conn *conn) (err error) {
+		entries, err = conn.list(c.filePath(dir))
+		return err
+	})
+	if err == errNotFound {
+		return []stow.Item{}, "", nil
+	}
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].name < entries[j].name
+	})
+
+	items := make([]stow.Item, 0, len(entries))
+	for _, e := range entries {
+		id := path.Join(dir, e.name)
+		if !strings.HasPrefix(e.name, namePrefix) || (cursor != "" && id <= cursor) {
+			continue
+		}
+
+		if count > 0 && len(items) == count {
+			return items, items[len(items)-1].ID(), nil
+		}
+		items = append(items, newItem(c, id, e))
+	}
+
+	return items, "", nil
+}
+
+// RemoveItem deletes file
+func (c *container) RemoveItem(id string) error {
+	err := c.location.do(func(conn *conn) error {
+		return conn.remove(c.filePath(id))
+	})
+	if err == errNotFound {
+		return stow.ErrNotFound
+	}
+
+	return err
+}
+
+// Put uploads file, missing directories are created
+// FTP doesn't support metadata so it is dropped
+func (c *container) Put(name string, r io.Reader, size int64, metadata map[string]interface{}) (stow.Item, error) {
+	filePath := c.filePath(name)
+	err := c.location.do(func(conn *conn) error {
+		return conn.mkdirAll(path.Dir(filePath))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// body can be read only once, so upload isn't repeated on new connection
+	conn, err := c.location.get()
+	if err != nil {
+		return nil, err
+	}
+	defer c.location.put(conn)
+
+	if err = conn.store(filePath, r); err != nil {
+		return nil, err
+	}
+
+	return newItem(c, strings.TrimPrefix(name, "/"), entry{name: name, size: size, modTime: time.Now().UTC()}), nil
+}
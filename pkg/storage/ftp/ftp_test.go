@@ -0,0 +1,330 @@
+package ftp
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aldor007/stow"
+	"github.com/stretchr/testify/assert"
+)
+
+// testServer is minimal in memory FTP server supporting commands used by location
+type testServer struct {
+	listener net.Listener
+	lock     sync.Mutex
+	files    map[string][]byte
+	dirs     map[string]bool
+	modTime  time.Time
+}
+
+func newTestServer(t *testing.T) *testServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+
+	s := &testServer{listener: listener, files: map[string][]byte{}, dirs: map[string]bool{"/": true}, modTime: time.Date(2020, 1, 2, 15, 4, 5, 0, time.UTC)}
+	go func() {
+		for {
+			c, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(c)
+		}
+	}()
+
+	t.Cleanup(func() {
+		listener.Close()
+	})
+	return s
+}
+
+func (s *testServer) addFile(filePath string, body []byte) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.files[filePath] = body
+	for dir := path.Dir(filePath); dir != "/"; dir = path.Dir(dir) {
+		s.dirs[dir] = true
+	}
+}
+
+func (s *testServer) serve(c net.Conn) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+	reply := func(code int, msg string) {
+		fmt.Fprintf(c, "%d %s\r\n", code, msg)
+	}
+
+	var data net.Listener
+	var offset int64
+	accept := func() net.Conn {
+		dataConn, err := data.Accept()
+		data.Close()
+		if err != nil {
+			return nil
+		}
+		return dataConn
+	}
+
+	reply(220, "ready")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		cmd, arg := line, ""
+		if i := strings.Index(line, " "); i != -1 {
+			cmd, arg = line[:i], line[i+1:]
+		}
+
+		s.lock.Lock()
+		body, isFile := s.files[arg]
+		isDir := s.dirs[arg]
+		s.lock.Unlock()
+
+		switch cmd {
+		case "USER":
+			reply(331, "password required")
+		case "PASS":
+			if arg != "secret" {
+				reply(530, "login incorrect")
+				continue
+			}
+			reply(230, "logged in")
+		case "TYPE":
+			reply(200, "ok")
+		case "EPSV":
+			data, _ = net.Listen("tcp", "127.0.0.1:0")
+			reply(229, fmt.Sprintf("Entering Extended Passive Mode (|||%d|)", data.Addr().(*net.TCPAddr).Port))
+		case "SIZE":
+			if !isFile {
+				reply(550, "not found")
+				continue
+			}
+			reply(213, strconv.Itoa(len(body)))
+		case "MDTM":
+			if !isFile {
+				reply(550, "not found")
+				continue
+			}
+			reply(213, s.modTime.Format("20060102150405"))
+		case "CWD":
+			if !isDir {
+				reply(550, "not found")
+				continue
+			}
+			reply(250, "ok")
+		case "MKD":
+			s.lock.Lock()
+			s.dirs[arg] = true
+			s.lock.Unlock()
+			reply(257, "created")
+		case "REST":
+			offset, _ = strconv.ParseInt(arg, 10, 64)
+			reply(350, "restarting")
+		case "RETR":
+			if !isFile {
+				data.Close()
+				reply(550, "not found")
+				continue
+			}
+			reply(150, "opening")
+			dataConn := accept()
+			dataConn.Write(body[offset:])
+			dataConn.Close()
+			offset = 0
+			reply(226, "done")
+		case "STOR":
+			reply(150, "opening")
+			dataConn := accept()
+			buf, _ := ioutil.ReadAll(dataConn)
+			dataConn.Close()
+			s.addFile(arg, buf)
+			reply(226, "done")
+		case "DELE":
+			if !isFile {
+				reply(550, "not found")
+				continue
+			}
+			s.lock.Lock()
+			delete(s.files, arg)
+			s.lock.Unlock()
+			reply(250, "deleted")
+		case "MLSD":
+			if !isDir {
+				data.Close()
+				reply(550, "not found")
+				continue
+			}
+			reply(150, "opening")
+			dataConn := accept()
+			s.lock.Lock()
+			var lines []string
+			lines = append(lines, "type=cdir; "+arg)
+			for name, body := range s.files {
+				if path.Dir(name) == arg {
+					lines = append(lines, fmt.Sprintf("type=file;size=%d;modify=%s; %s", len(body), s.modTime.Format("20060102150405"), path.Base(name)))
+				}
+			}
+			for name := range s.dirs {
+				if name != "/" && path.Dir(name) == arg {
+					lines = append(lines, "type=dir; "+path.Base(name))
+				}
+			}
+			s.lock.Unlock()
+			sort.Strings(lines)
+			for _, l := range lines {
+				fmt.Fprintf(dataConn, "%s\r\n", l)
+			}
+			dataConn.Close()
+			reply(226, "done")
+		case "QUIT":
+			reply(221, "bye")
+			return
+		default:
+			reply(502, "not implemented")
+		}
+	}
+}
+
+func testLocation(t *testing.T, s *testServer) stow.Location {
+	l, err := stow.Dial(Kind, stow.ConfigMap{
+		ConfigAddress:  s.listener.Addr().String(),
+		ConfigUsername: "mort",
+		ConfigPassword: "secret",
+	})
+	assert.Nil(t, err)
+	return l
+}
+
+func TestLocation(t *testing.T) {
+	s := newTestServer(t)
+	s.addFile("/bucket/dir/file.jpg", []byte("0123456789"))
+	l := testLocation(t, s)
+	defer l.Close()
+
+	_, err := l.Container("missing")
+	assert.Equal(t, stow.ErrNotFound, err)
+
+	c, err := l.Container("bucket")
+	assert.Nil(t, err)
+
+	_, err = c.Item("dir/missing.jpg")
+	assert.Equal(t, stow.ErrNotFound, err)
+
+	item, err := c.Item("dir/file.jpg")
+	assert.Nil(t, err)
+	size, _ := item.Size()
+	assert.Equal(t, int64(10), size)
+	lastMod, _ := item.LastMod()
+	assert.Equal(t, s.modTime, lastMod)
+
+	r, err := item.Open()
+	assert.Nil(t, err)
+	body, _ := ioutil.ReadAll(r)
+	r.Close()
+	assert.Equal(t, "0123456789", string(body))
+
+	r, err = item.OpenParams(map[string]interface{}{"range": "bytes=2-4"})
+	assert.Nil(t, err)
+	body, _ = ioutil.ReadAll(r)
+	r.Close()
+	assert.Equal(t, "234", string(body))
+	contentRange, _ := item.ContentRange()
+	assert.Equal(t, "bytes 2-4/10", contentRange.ContentRange)
+}
+
+func TestContainerPutAndList(t *testing.T) {
+	s := newTestServer(t)
+	s.addFile("/bucket/a.jpg", []byte("a"))
+	l := testLocation(t, s)
+	defer l.Close()
+
+	c, err := l.Container("bucket")
+	assert.Nil(t, err)
+
+	_, err = c.Put("new/b.jpg", bytes.NewReader([]byte("bb")), 2, nil)
+	assert.Nil(t, err)
+
+	item, err := c.Item("new/b.jpg")
+	assert.Nil(t, err)
+	size, _ := item.Size()
+	assert.Equal(t, int64(2), size)
+
+	items, _, err := c.Items("", "", 10)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(items))
+	assert.Equal(t, "a.jpg", items[0].ID())
+	assert.Equal(t, "new", items[1].ID())
+	meta, _ := items[1].Metadata()
+	assert.Equal(t, true, meta["is_dir"])
+
+	items, cursor, err := c.Items("new/", "", 10)
+	assert.Nil(t, err)
+	assert.Equal(t, "", cursor)
+	assert.Equal(t, 1, len(items))
+	assert.Equal(t, "new/b.jpg", items[0].ID())
+
+	assert.Nil(t, c.RemoveItem("new/b.jpg"))
+	assert.Equal(t, stow.ErrNotFound, c.RemoveItem("new/b.jpg"))
+}
+
+func TestContainerInvalidName(t *testing.T) {
+	s := newTestServer(t)
+	s.addFile("/bucket/a.jpg", []byte("a"))
+	l := testLocation(t, s)
+	defer l.Close()
+
+	c, err := l.Container("bucket")
+	assert.Nil(t, err)
+
+	_, err = c.Put("b.jpg\r\nDELE /bucket/a.jpg", bytes.NewReader([]byte("bb")), 2, nil)
+	assert.NotNil(t, err)
+
+	_, err = c.Item("a.jpg\x00")
+	assert.NotNil(t, err)
+
+	item, err := c.Item("a.jpg")
+	assert.Nil(t, err, "command shouldn't be injected")
+	size, _ := item.Size()
+	assert.Equal(t, int64(1), size)
+}
+
+func TestParseRange(t *testing.T) {
+	start, end, err := parseRange("bytes=0-", 10)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), start)
+	assert.Equal(t, int64(9), end)
+
+	start, end, err = parseRange("bytes=-3", 10)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(7), start)
+	assert.Equal(t, int64(9), end)
+
+	_, _, err = parseRange("bytes=20-30", 10)
+	assert.NotNil(t, err)
+
+	_, _, err = parseRange("bytes=0-1,3-4", 10)
+	assert.NotNil(t, err)
+}
+
+func TestParseMLSD(t *testing.T) {
+	e, ok := parseMLSD("type=file;size=1024;modify=20200102150405.123; name with space.jpg")
+	assert.True(t, ok)
+	assert.Equal(t, "name with space.jpg", e.name)
+	assert.Equal(t, int64(1024), e.size)
+	assert.Equal(t, time.Date(2020, 1, 2, 15, 4, 5, 0, time.UTC), e.modTime)
+
+	_, ok = parseMLSD("type=pdir; ..")
+	assert.False(t, ok)
+}
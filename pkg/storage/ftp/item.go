@@ -0,0 +1,184 @@
+package ftp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aldor007/stow"
+)
+
+// item is file (or directory when listed) on FTP server
+type item struct {
+	container *container
+	id        string
+	entry     entry
+	rangeData stow.ContentRangeData
+}
+
+func newItem(c *container, id string, e entry) *item {
+	return &item{container: c, id: id, entry: e}
+}
+
+// ID returns path of file in container
+func (i *item) ID() string {
+	return i.id
+}
+
+// Name returns path of file in container
+func (i *item) Name() string {
+	return i.id
+}
+
+// URL returns url of file in form ftp://host/container/path
+func (i *item) URL() *url.URL {
+	return &url.URL{Scheme: Kind, Host: i.container.location.dialer.address, Path: i.container.filePath(i.id)}
+}
+
+// Size returns size of file
+func (i *item) Size() (int64, error) {
+	return i.entry.size, nil
+}
+
+// LastMod returns time of last modification of file
+func (i *item) LastMod() (time.Time, error) {
+	return i.entry.modTime, nil
+}
+
+// ETag returns tag computed from modification time and size as FTP doesn't provide checksums
+func (i *item) ETag() (string, error) {
+	return fmt.Sprintf("%x-%x", i.entry.modTime.Unix(), i.entry.size), nil
+}
+
+// Metadata returns only information if item is directory as FTP doesn't support metadata
+func (i *item) Metadata() (map[string]interface{}, error) {
+	return map[string]interface{}{"is_dir": i.entry.isDir}, nil
+}
+
+// Open opens file for reading, connection to server is kept until reader is closed
+func (i *item) Open() (io.ReadCloser, error) {
+	return i.open(0, -1)
+}
+
+// OpenParams opens file for reading, it supports "range" param with value in form bytes=start-end
+func (i *item) OpenParams(p map[string]interface{}) (io.ReadCloser, error) {
+	rangeValue, ok := p["range"].(string)
+	if !ok || rangeValue == "" {
+		return i.Open()
+	}
+
+	start, end, err := parseRange(rangeValue, i.entry.size)
+	if err != nil {
+		return nil, err
+	}
+
+	i.rangeData = stow.ContentRangeData{
+		ContentRange:  fmt.Sprintf("bytes %d-%d/%d", start, end, i.entry.size),
+		ContentLength: end - start + 1,
+	}
+	return i.open(start, end-start+1)
+}
+
+// ContentRange returns information about range opened with OpenParams
+func (i *item) ContentRange() (stow.ContentRangeData, error) {
+	if i.rangeData.ContentRange == "" {
+		return stow.ContentRangeData{}, errors.New("response is not a range")
+	}
+
+	return i.rangeData, nil
+}
+
+func (i *item) open(offset, length int64) (io.ReadCloser, error) {
+	l := i.container.location
+	c, err := l.get()
+	if err != nil {
+		return nil, err
+	}
+
+	dataConn, err := c.retrieve(i.container.filePath(i.id), offset)
+	if err != nil {
+		l.put(c)
+		if err == errNotFound {
+			return nil, stow.ErrNotFound
+		}
+		return nil, err
+	}
+
+	var r io.Reader = dataConn
+	if length >= 0 {
+		r = io.LimitReader(dataConn, length)
+	}
+
+	return &fileReader{Reader: r, dataConn: dataConn, conn: c, location: l}, nil
+}
+
+// fileReader reads file from data connection, on close control connection is returned to pool
+type fileReader struct {
+	io.Reader
+	dataConn net.Conn
+	conn     *conn
+	location *location
+	closed   bool
+}
+
+func (r *fileReader) Close() error {
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	r.dataConn.Close()
+	// server responds with error when transfer is aborted, but connection can be still used
+	r.conn.finish()
+	r.location.put(r.conn)
+	return nil
+}
+
+// parseRange parses value of range header, it returns positions of first and last byte
+func parseRange(value string, size int64) (int64, int64, error) {
+	spec := strings.TrimPrefix(value, "bytes=")
+	if spec == value || strings.Contains(spec, ",") {
+		return 0, 0, fmt.Errorf("ftp: unsupported range %s", value)
+	}
+
+	parts := strings.SplitN(spec, "-", 2)
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("ftp: invalid range %s", value)
+	}
+
+	var start, end int64
+	var err error
+	if parts[0] == "" {
+		// suffix range - last n bytes
+		n, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil || n <= 0 {
+			return 0, 0, fmt.Errorf("ftp: invalid range %s", value)
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, size - 1, nil
+	}
+
+	start, err = strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return 0, 0, fmt.Errorf("ftp: invalid range %s", value)
+	}
+
+	end = size - 1
+	if parts[1] != "" {
+		end, err = strconv.ParseInt(parts[1], 10, 64)
+		if err != nil || end < start {
+			return 0, 0, fmt.Errorf("ftp: invalid range %s", value)
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+
+	return start, end, nil
+}
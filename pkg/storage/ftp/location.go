@@ -0,0 +1,142 @@
+package ftp
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+
+	"github.com/aldor007/stow"
+)
+
+// location is FTP server, containers are top level directories on it
+type location struct {
+	dialer *dialer
+	idle   chan *conn
+}
+
+// get returns idle connection or opens new one
+func (l *location) get() (*conn, error) {
+	select {
+	case c := <-l.idle:
+		return c, nil
+	default:
+		return l.dialer.dial()
+	}
+}
+
+// put returns connection to pool of idle connections, broken connections are closed
+func (l *location) put(c *conn) {
+	if c.broken {
+		c.close()
+		return
+	}
+
+	select {
+	case l.idle <- c:
+	default:
+		c.close()
+	}
+}
+
+// do executes fn on connection from pool, when idle connection was closed by server operation is repeated on new one
+func (l *location) do(fn func(c *conn) error) error {
+	c, err := l.get()
+	if err != nil {
+		return err
+	}
+
+	err = fn(c)
+	if c.broken {
+		c.close()
+		if c, err = l.dialer.dial(); err != nil {
+			return err
+		}
+		err = fn(c)
+	}
+
+	l.put(c)
+	return err
+}
+
+// HasRanges returns true as ranges are handled with REST command
+func (l *location) HasRanges() bool {
+	return true
+}
+
+// Close closes all idle connections
+func (l *location) Close() error {
+	for {
+		select {
+		case c := <-l.idle:
+			c.close()
+		default:
+			return nil
+		}
+	}
+}
+
+// CreateContainer creates directory on server
+func (l *location) CreateContainer(name string) (stow.Container, error) {
+	err := l.do(func(c *conn) error {
+		return c.mkdirAll(name)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &container{name: name, location: l}, nil
+}
+
+// Containers returns top level directories
+func (l *location) Containers(prefix, cursor string, count int) ([]stow.Container, string, error) {
+	var entries []entry
+	err := l.do(func(c *conn) (err error) {
+		entries, err = c.list("/")
+		return err
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	var containers []stow.Container
+	for _, e := range entries {
+		if e.isDir && strings.HasPrefix(e.name, prefix) {
+			containers = append(containers, &container{name: e.name, location: l})
+		}
+	}
+
+	return containers, "", nil
+}
+
+// Container returns container if directory of given name exists
+func (l *location) Container(id string) (stow.Container, error) {
+	var exists bool
+	err := l.do(func(c *conn) (err error) {
+		exists, err = c.isDir("/" + id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !exists {
+		return nil, stow.ErrNotFound
+	}
+
+	return &container{name: id, location: l}, nil
+}
+
+// RemoveContainer is not supported
+func (l *location) RemoveContainer(id string) error {
+	return errors.New("ftp: removing containers is not supported")
+}
+
+// ItemByURL returns item for url in form ftp://host/container/path
+func (l *location) ItemByURL(u *url.URL) (stow.Item, error) {
+	parts := strings.SplitN(strings.TrimPrefix(u.Path, "/"), "/", 2)
+	if len(parts) != 2 {
+		return nil, stow.ErrNotFound
+	}
+
+	return (&container{name: parts[0], location: l}).Item(parts[1])
+}
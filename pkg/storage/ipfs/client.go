@@ -0,0 +1,296 @@
+package ipfs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aldor007/stow"
+)
+
+// errNoAPI is returned when operation requires API but only gateway is configured
+var errNoAPI = errors.New("ipfs: operation requires api url")
+
+// base58 is alphabet used by CIDv0
+const base58 = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// base32 is alphabet used by CIDv1 in default (lower case) encoding
+const base32 = "abcdefghijklmnopqrstuvwxyz234567"
+
+// client calls IPFS node API and gateway
+type client struct {
+	api     string
+	gateway string
+	root    string
+	pin     bool
+	timeout time.Duration
+	http    *http.Client
+}
+
+// apiError is error returned by IPFS API
+type apiError struct {
+	Message string
+	Code    int
+}
+
+func (e *apiError) Error() string {
+	return "ipfs: " + e.Message
+}
+
+// stat describes file or directory
+type stat struct {
+	Hash        string
+	Size        int64
+	Type        string // "file" or "directory"
+	contentType string
+}
+
+// entry is element of directory listing, Type is 1 for directories
+type entry struct {
+	Name string
+	Type int
+	Size int64
+	Hash string
+}
+
+// isCID check if value looks like CID (v0 in base58 or v1 in base32)
+func isCID(value string) bool {
+	if len(value) == 46 && strings.HasPrefix(value, "Qm") {
+		return strings.Trim(value, base58) == ""
+	}
+
+	if len(value) > 50 && value[0] == 'b' {
+		return strings.Trim(value[1:], base32) == ""
+	}
+
+	return false
+}
+
+// isImmutable check if path points to content addressed object
+func isImmutable(p string) bool {
+	return strings.HasPrefix(p, "/ipfs/")
+}
+
+// isNotFound check if error message returned by API means that object doesn't exist
+func isNotFound(msg string) bool {
+	return strings.Contains(msg, "does not exist") || strings.Contains(msg, "not found") || strings.Contains(msg, "no link named")
+}
+
+// useGateway check if path should be read from gateway
+func (c *client) useGateway(p string) bool {
+	return c.gateway != "" && isImmutable(p)
+}
+
+// call executes API command, response body has to be closed by caller
+func (c *client) call(ctx context.Context, cmd string, args url.Values, body io.Reader, contentType string) (*http.Response, error) {
+	if c.api == "" {
+		return nil, errNoAPI
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.api+"/api/v0/"+cmd+"?"+args.Encode(), body)
+	if err != nil {
+		return nil, err
+	}
+
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	res, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		defer res.Body.Close()
+		e := &apiError{}
+		if err = json.NewDecoder(res.Body).Decode(e); err != nil || e.Message == "" {
+			return nil, fmt.Errorf("ipfs: unexpected status %d for %s", res.StatusCode, cmd)
+		}
+
+		if isNotFound(e.Message) {
+			return nil, stow.ErrNotFound
+		}
+
+		return nil, e
+	}
+
+	return res, nil
+}
+
+// callJSON executes API command with timeout and decodes its response to v
+func (c *client) callJSON(cmd string, args url.Values, v interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	res, err := c.call(ctx, cmd, args, nil, "")
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if v == nil {
+		_, err = io.Copy(ioutil.Discard, res.Body)
+		return err
+	}
+
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// gatewayRequest sends request for path to gateway
+func (c *client) gatewayRequest(ctx context.Context, method string, p string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.gateway+p, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		return res, nil
+	case http.StatusNotFound, http.StatusGone:
+		res.Body.Close()
+		return nil, stow.ErrNotFound
+	default:
+		res.Body.Close()
+		return nil, fmt.Errorf("ipfs: unexpected gateway status %d", res.StatusCode)
+	}
+}
+
+// stat returns information about object
+func (c *client) stat(p string) (stat, error) {
+	if c.useGateway(p) {
+		ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+		defer cancel()
+		res, err := c.gatewayRequest(ctx, "HEAD", p)
+		if err != nil {
+			return stat{}, err
+		}
+		res.Body.Close()
+
+		return stat{
+			Hash:        strings.Trim(res.Header.Get("Etag"), `"`),
+			Size:        res.ContentLength,
+			Type:        "file",
+			contentType: res.Header.Get("Content-Type"),
+		}, nil
+	}
+
+	if c.api == "" {
+		// without API only gateway can be used, so there is nothing more to look at
+		return stat{}, stow.ErrNotFound
+	}
+
+	var s stat
+	err := c.callJSON("files/stat", url.Values{"arg": {p}}, &s)
+	return s, err
+}
+
+// read opens content of file
+func (c *client) read(p string) (io.ReadCloser, error) {
+	if c.useGateway(p) {
+		res, err := c.gatewayRequest(context.Background(), "GET", p)
+		if err != nil {
+			return nil, err
+		}
+		return res.Body, nil
+	}
+
+	cmd := "files/read"
+	if isImmutable(p) {
+		cmd = "cat"
+	}
+
+	res, err := c.call(context.Background(), cmd, url.Values{"arg": {p}}, nil, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return res.Body, nil
+}
+
+// write stores content of r in MFS, missing directories are created
+func (c *client) write(p string, r io.Reader) error {
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		part, err := mw.CreateFormFile("file", path.Base(p))
+		if err == nil {
+			_, err = io.Copy(part, r)
+		}
+		if err == nil {
+			err = mw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	args := url.Values{"arg": {p}, "create": {"true"}, "parents": {"true"}, "truncate": {"true"}}
+	res, err := c.call(context.Background(), "files/write", args, pr, mw.FormDataContentType())
+	if err != nil {
+		// unblock writing goroutine
+		pr.CloseWithError(err)
+		return err
+	}
+	defer res.Body.Close()
+
+	_, err = io.Copy(ioutil.Discard, res.Body)
+	return err
+}
+
+// list returns content of directory
+func (c *client) list(p string) ([]entry, error) {
+	if isImmutable(p) {
+		var res struct {
+			Objects []struct {
+				Links []entry
+			}
+		}
+		if err := c.callJSON("ls", url.Values{"arg": {p}}, &res); err != nil {
+			return nil, err
+		}
+
+		var entries []entry
+		for _, o := range res.Objects {
+			entries = append(entries, o.Links...)
+		}
+		return entries, nil
+	}
+
+	var res struct {
+		Entries []entry
+	}
+	err := c.callJSON("files/ls", url.Values{"arg": {p}, "long": {"true"}}, &res)
+	return res.Entries, err
+}
+
+// mkdir creates MFS directory with missing parents
+func (c *client) mkdir(p string) error {
+	return c.callJSON("files/mkdir", url.Values{"arg": {p}, "parents": {"true"}}, nil)
+}
+
+// remove deletes file or directory from MFS
+func (c *client) remove(p string, recursive bool) error {
+	args := url.Values{"arg": {p}}
+	if recursive {
+		args.Set("recursive", "true")
+	}
+
+	return c.callJSON("files/rm", args, nil)
+}
+
+// pinAdd pins object of given CID
+func (c *client) pinAdd(cid string) error {
+	return c.callJSON("pin/add", url.Values{"arg": {cid}}, nil)
+}
@@ -0,0 +1,103 @@
+// Package ipfs implements stow location working on IPFS node using its HTTP API and (optionally) a gateway
+package ipfs
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aldor007/stow"
+)
+
+// Kind represents the name of the location/storage type.
+const Kind = "ipfs"
+
+const (
+	// ConfigAPI is url of IPFS node HTTP API (e.g. http://127.0.0.1:5001), without it location is read only
+	ConfigAPI = "api"
+
+	// ConfigGateway is url of IPFS gateway used for reading objects addressed by CID
+	ConfigGateway = "gateway"
+
+	// ConfigRoot is directory in MFS (mutable file system of node) in which containers are created
+	ConfigRoot = "root"
+
+	// ConfigPin enables pinning of stored objects when set to "true"
+	ConfigPin = "pin"
+
+	// ConfigTimeout is timeout of API calls (except reading content) in seconds
+	ConfigTimeout = "timeout"
+)
+
+const (
+	defaultRoot    = "/mort"
+	defaultTimeout = 30 * time.Second
+)
+
+func init() {
+	validatefn := func(config stow.Config) error {
+		_, err := newClient(config)
+		return err
+	}
+
+	makefn := func(config stow.Config) (stow.Location, error) {
+		c, err := newClient(config)
+		if err != nil {
+			return nil, err
+		}
+
+		return &location{client: c}, nil
+	}
+
+	kindfn := func(u *url.URL) bool {
+		return u.Scheme == Kind
+	}
+
+	stow.Register(Kind, makefn, kindfn, validatefn)
+}
+
+// newClient parses location configuration
+func newClient(config stow.Config) (*client, error) {
+	c := &client{root: defaultRoot, timeout: defaultTimeout, http: &http.Client{}}
+	c.api, _ = config.Config(ConfigAPI)
+	c.gateway, _ = config.Config(ConfigGateway)
+	c.api = strings.TrimSuffix(c.api, "/")
+	c.gateway = strings.TrimSuffix(c.gateway, "/")
+	if c.api == "" && c.gateway == "" {
+		return nil, errors.New("missing api or gateway url")
+	}
+
+	for _, u := range []string{c.api, c.gateway} {
+		if u == "" {
+			continue
+		}
+
+		if parsed, err := url.Parse(u); err != nil || parsed.Host == "" {
+			return nil, errors.New("invalid url " + u)
+		}
+	}
+
+	if root, ok := config.Config(ConfigRoot); ok && root != "" {
+		c.root = path.Join("/", root)
+	}
+
+	pin, _ := config.Config(ConfigPin)
+	c.pin = pin == "true"
+	if c.pin && c.api == "" {
+		return nil, errors.New("pinning requires api url")
+	}
+
+	if v, ok := config.Config(ConfigTimeout); ok && v != "" {
+		seconds, err := strconv.Atoi(v)
+		if err != nil || seconds <= 0 {
+			return nil, errors.New("invalid timeout")
+		}
+		c.timeout = time.Duration(seconds) * time.Second
+	}
+
+	return c, nil
+}
@@ -0,0 +1,140 @@
+package ipfs
+
+import (
+	"errors"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/aldor007/stow"
+)
+
+// errImmutable is returned on attempt to modify content addressed object
+var errImmutable = errors.New("ipfs: objects addressed by CID can't be modified")
+
+// container is MFS directory, objects which key starts with CID are read directly from IPFS
+type container struct {
+	name     string
+	location *location
+}
+
+// ID returns name of container
+func (c *container) ID() string {
+	return c.name
+}
+
+// Name returns name of container
+func (c *container) Name() string {
+	return c.name
+}
+
+// ipfsPath returns IPFS path of object, keys starting with CID are resolved as /ipfs/<cid>/... paths
+// other are stored in MFS
+func (c *container) ipfsPath(id string) string {
+	id = strings.TrimPrefix(id, "/")
+	if isCID(strings.SplitN(id, "/", 2)[0]) {
+		return "/ipfs/" + id
+	}
+
+	return path.Join(c.location.client.root, c.name, id)
+}
+
+// Item returns information about object
+func (c *container) Item(id string) (stow.Item, error) {
+	s, err := c.location.client.stat(c.ipfsPath(id))
+	if err != nil {
+		return nil, err
+	}
+
+	return newItem(c, strings.TrimPrefix(id, "/"), s), nil
+}
+
+// Items returns content of directory given in prefix (or parent directory of prefix)
+// Listing isn't recursive, directories are returned as items
+func (c *container) Items(prefix, cursor string, count int) ([]stow.Item, string, error) {
+	client := c.location.client
+	prefix = strings.TrimPrefix(prefix, "/")
+	dir, namePrefix := prefix, ""
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		s, err := client.stat(c.ipfsPath(prefix))
+		if err != nil && err != stow.ErrNotFound {
+			return nil, "", err
+		}
+
+		if err != nil || s.Type != "directory" {
+			dir, namePrefix = path.Split(prefix)
+		}
+	}
+
+	entries, err := client.list(c.ipfsPath(dir))
+	if err == stow.ErrNotFound {
+		return []stow.Item{}, "", nil
+	}
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name < entries[j].Name
+	})
+
+	items := make([]stow.Item, 0, len(entries))
+	for _, e := range entries {
+		id := path.Join(dir, e.Name)
+		if !strings.HasPrefix(e.Name, namePrefix) || (cursor != "" && id <= cursor) {
+			continue
+		}
+
+		if count > 0 && len(items) == count {
+			return items, items[len(items)-1].ID(), nil
+		}
+
+		s := stat{Hash: e.Hash, Size: e.Size, Type: "file"}
+		if e.Type == 1 {
+			s.Type = "directory"
+		}
+		items = append(items, newItem(c, id, s))
+	}
+
+	return items, "", nil
+}
+
+// RemoveItem deletes object from MFS
+// Pins aren't removed as the same content can be stored under other key
+func (c *container) RemoveItem(id string) error {
+	p := c.ipfsPath(id)
+	if isImmutable(p) {
+		return errImmutable
+	}
+
+	return c.location.client.remove(p, false)
+}
+
+// Put stores object in MFS and pins it when location is configured to do so
+// IPFS doesn't support metadata so it is dropped
+func (c *container) Put(name string, r io.Reader, size int64, metadata map[string]interface{}) (stow.Item, error) {
+	client := c.location.client
+	p := c.ipfsPath(name)
+	if isImmutable(p) {
+		return nil, errImmutable
+	}
+
+	if err := client.write(p, r); err != nil {
+		return nil, err
+	}
+
+	s, err := client.stat(p)
+	if err != nil {
+		return nil, err
+	}
+
+	if client.pin {
+		if err = client.pinAdd(s.Hash); err != nil {
+			return nil, err
+		}
+	}
+
+	return newItem(c, strings.TrimPrefix(name, "/"), s), nil
+}
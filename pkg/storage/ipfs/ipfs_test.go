@@ -0,0 +1,242 @@
+package ipfs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aldor007/stow"
+	"github.com/stretchr/testify/assert"
+)
+
+const testCID = "QmT78zSuBmuS4z925WZfrqQ1qHaJ56DQaTfyMUF7F8ff5o"
+
+// testNode is minimal in memory IPFS node supporting API commands used by location and gateway
+type testNode struct {
+	lock   sync.Mutex
+	files  map[string][]byte
+	pinned map[string]bool
+}
+
+func newTestNode(t *testing.T) (*testNode, *httptest.Server) {
+	n := &testNode{files: map[string][]byte{"/ipfs/" + testCID + "/cat.jpg": []byte("cat")}, pinned: map[string]bool{}}
+	s := httptest.NewServer(n)
+	t.Cleanup(s.Close)
+	return n, s
+}
+
+func fakeCID(body []byte) string {
+	sum := sha256.Sum256(body)
+	cid := []byte("Qm")
+	for i := 0; len(cid) < 46; i++ {
+		cid = append(cid, base58[sum[i%len(sum)]%58])
+	}
+	return string(cid)
+}
+
+func (n *testNode) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if strings.HasPrefix(r.URL.Path, "/ipfs/") {
+		body, ok := n.files[r.URL.Path]
+		if !ok {
+			w.WriteHeader(404)
+			return
+		}
+		w.Header().Set("Etag", `"`+fakeCID(body)+`"`)
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write(body)
+		return
+	}
+
+	if r.Method != "POST" {
+		w.WriteHeader(405)
+		return
+	}
+
+	arg := r.URL.Query().Get("arg")
+	notFound := func() {
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]interface{}{"Message": "file does not exist", "Code": 0, "Type": "error"})
+	}
+
+	switch strings.TrimPrefix(r.URL.Path, "/api/v0/") {
+	case "files/stat":
+		if body, ok := n.files[arg]; ok {
+			json.NewEncoder(w).Encode(map[string]interface{}{"Hash": fakeCID(body), "Size": len(body), "Type": "file"})
+			return
+		}
+		for name := range n.files {
+			if strings.HasPrefix(name, arg+"/") {
+				json.NewEncoder(w).Encode(map[string]interface{}{"Hash": fakeCID([]byte(arg)), "Type": "directory"})
+				return
+			}
+		}
+		notFound()
+	case "files/read", "cat":
+		body, ok := n.files[arg]
+		if !ok {
+			notFound()
+			return
+		}
+		w.Write(body)
+	case "files/write":
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			w.WriteHeader(400)
+			return
+		}
+		body, _ := ioutil.ReadAll(file)
+		n.files[arg] = body
+	case "files/rm":
+		if _, ok := n.files[arg]; !ok {
+			notFound()
+			return
+		}
+		delete(n.files, arg)
+	case "files/mkdir":
+	case "files/ls":
+		entries := []map[string]interface{}{}
+		seen := map[string]bool{}
+		for name, body := range n.files {
+			if !strings.HasPrefix(name, arg+"/") {
+				continue
+			}
+			rest := strings.SplitN(strings.TrimPrefix(name, arg+"/"), "/", 2)
+			if seen[rest[0]] {
+				continue
+			}
+			seen[rest[0]] = true
+			if len(rest) == 2 {
+				entries = append(entries, map[string]interface{}{"Name": rest[0], "Type": 1})
+			} else {
+				entries = append(entries, map[string]interface{}{"Name": rest[0], "Type": 0, "Size": len(body), "Hash": fakeCID(body)})
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"Entries": entries})
+	case "pin/add":
+		n.pinned[arg] = true
+		json.NewEncoder(w).Encode(map[string]interface{}{"Pins": []string{arg}})
+	default:
+		w.WriteHeader(404)
+	}
+}
+
+func TestContainer(t *testing.T) {
+	n, s := newTestNode(t)
+	l, err := stow.Dial(Kind, stow.ConfigMap{ConfigAPI: s.URL, ConfigPin: "true"})
+	assert.Nil(t, err)
+
+	c, err := l.Container("bucket")
+	assert.Nil(t, err)
+
+	_, err = c.Item("missing.jpg")
+	assert.Equal(t, stow.ErrNotFound, err)
+
+	item, err := c.Put("/dir/a.jpg", bytes.NewReader([]byte("aa")), 2, nil)
+	assert.Nil(t, err)
+	cid, _ := item.ETag()
+	assert.Equal(t, fakeCID([]byte("aa")), cid)
+	assert.True(t, n.pinned[cid])
+	assert.Equal(t, []byte("aa"), n.files["/mort/bucket/dir/a.jpg"])
+
+	item, err = c.Item("dir/a.jpg")
+	assert.Nil(t, err)
+	size, _ := item.Size()
+	assert.Equal(t, int64(2), size)
+	meta, _ := item.Metadata()
+	assert.Equal(t, cid, meta["x-ipfs-cid"])
+	r, err := item.Open()
+	assert.Nil(t, err)
+	body, _ := ioutil.ReadAll(r)
+	r.Close()
+	assert.Equal(t, "aa", string(body))
+
+	c.Put("dir/b.jpg", bytes.NewReader([]byte("b")), 1, nil)
+	items, cursor, err := c.Items("dir/", "", 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(items))
+	assert.Equal(t, "dir/a.jpg", items[0].ID())
+	assert.Equal(t, "dir/a.jpg", cursor)
+
+	items, _, err = c.Items("d", "", 10)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(items))
+	meta, _ = items[0].Metadata()
+	assert.Equal(t, true, meta["is_dir"])
+
+	assert.Nil(t, c.RemoveItem("dir/a.jpg"))
+	assert.Equal(t, stow.ErrNotFound, c.RemoveItem("dir/a.jpg"))
+}
+
+func TestContainerCID(t *testing.T) {
+	_, s := newTestNode(t)
+	l, err := stow.Dial(Kind, stow.ConfigMap{ConfigAPI: s.URL})
+	assert.Nil(t, err)
+	c, _ := l.Container("bucket")
+
+	item, err := c.Item(path.Join("/", testCID, "cat.jpg"))
+	assert.Nil(t, err)
+	r, err := item.Open()
+	assert.Nil(t, err)
+	body, _ := ioutil.ReadAll(r)
+	r.Close()
+	assert.Equal(t, "cat", string(body))
+
+	_, err = c.Put(testCID+"/dog.jpg", bytes.NewReader([]byte("dog")), 3, nil)
+	assert.Equal(t, errImmutable, err)
+	assert.Equal(t, errImmutable, c.RemoveItem(testCID+"/cat.jpg"))
+}
+
+func TestGateway(t *testing.T) {
+	_, s := newTestNode(t)
+	l, err := stow.Dial(Kind, stow.ConfigMap{ConfigGateway: s.URL})
+	assert.Nil(t, err)
+	c, _ := l.Container("bucket")
+
+	item, err := c.Item(testCID + "/cat.jpg")
+	assert.Nil(t, err)
+	meta, _ := item.Metadata()
+	assert.Equal(t, "image/jpeg", meta["content-type"])
+	etag, _ := item.ETag()
+	assert.Equal(t, fakeCID([]byte("cat")), etag)
+	r, err := item.Open()
+	assert.Nil(t, err)
+	body, _ := ioutil.ReadAll(r)
+	r.Close()
+	assert.Equal(t, "cat", string(body))
+
+	_, err = c.Item(testCID + "/missing.jpg")
+	assert.Equal(t, stow.ErrNotFound, err)
+
+	_, err = c.Item("not-cid.jpg")
+	assert.Equal(t, stow.ErrNotFound, err)
+
+	_, err = c.Put("a.jpg", bytes.NewReader([]byte("a")), 1, nil)
+	assert.Equal(t, errNoAPI, err)
+}
+
+func TestConfig(t *testing.T) {
+	_, err := stow.Dial(Kind, stow.ConfigMap{})
+	assert.NotNil(t, err)
+
+	_, err = stow.Dial(Kind, stow.ConfigMap{ConfigGateway: "https://ipfs.io", ConfigPin: "true"})
+	assert.NotNil(t, err)
+
+	_, err = stow.Dial(Kind, stow.ConfigMap{ConfigAPI: "not url"})
+	assert.NotNil(t, err)
+}
+
+func TestIsCID(t *testing.T) {
+	assert.True(t, isCID(testCID))
+	assert.True(t, isCID("bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi"))
+	assert.False(t, isCID("image.jpg"))
+	assert.False(t, isCID("Qm0000000000000000000000000000000000000000000000"))
+}
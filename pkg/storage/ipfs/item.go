@@ -0,0 +1,82 @@
+package ipfs
+
+import (
+	"errors"
+	"io"
+	"net/url"
+	"time"
+
+	"github.com/aldor007/stow"
+)
+
+// item is file (or directory when listed) in IPFS
+type item struct {
+	container *container
+	id        string
+	stat      stat
+	modTime   time.Time
+}
+
+func newItem(c *container, id string, s stat) *item {
+	// IPFS doesn't track modification time so time of lookup is used
+	return &item{container: c, id: id, stat: s, modTime: time.Now().UTC()}
+}
+
+// ID returns key of object in container
+func (i *item) ID() string {
+	return i.id
+}
+
+// Name returns key of object in container
+func (i *item) Name() string {
+	return i.id
+}
+
+// URL returns url of object in form ipfs://container/path
+func (i *item) URL() *url.URL {
+	return &url.URL{Scheme: Kind, Host: i.container.name, Path: "/" + i.id}
+}
+
+// Size returns size of object
+func (i *item) Size() (int64, error) {
+	return i.stat.Size, nil
+}
+
+// LastMod returns time when object was looked up
+func (i *item) LastMod() (time.Time, error) {
+	return i.modTime, nil
+}
+
+// ETag returns CID of object, it changes only when content changes
+func (i *item) ETag() (string, error) {
+	return i.stat.Hash, nil
+}
+
+// Metadata returns CID of object in x-ipfs-cid key and content type when object was read from gateway
+func (i *item) Metadata() (map[string]interface{}, error) {
+	metadata := map[string]interface{}{"is_dir": i.stat.Type == "directory"}
+	if i.stat.Hash != "" {
+		metadata["x-ipfs-cid"] = i.stat.Hash
+	}
+
+	if i.stat.contentType != "" {
+		metadata["content-type"] = i.stat.contentType
+	}
+
+	return metadata, nil
+}
+
+// Open returns content of object
+func (i *item) Open() (io.ReadCloser, error) {
+	return i.container.location.client.read(i.container.ipfsPath(i.id))
+}
+
+// OpenParams ignores params as ranges aren't handled by location
+func (i *item) OpenParams(_ map[string]interface{}) (io.ReadCloser, error) {
+	return i.Open()
+}
+
+// ContentRange returns error as ranges aren't handled by location
+func (i *item) ContentRange() (stow.ContentRangeData, error) {
+	return stow.ContentRangeData{}, errors.New("response is not a range")
+}
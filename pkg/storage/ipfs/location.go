@@ -0,0 +1,72 @@
+package ipfs
+
+import (
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/aldor007/stow"
+)
+
+// location is IPFS node, containers are directories in its MFS
+type location struct {
+	client *client
+}
+
+// HasRanges returns false, content is always read as a whole
+func (l *location) HasRanges() bool {
+	return false
+}
+
+// Close does nothing
+func (l *location) Close() error {
+	return nil
+}
+
+// CreateContainer creates MFS directory, when only gateway is configured container is read only
+func (l *location) CreateContainer(name string) (stow.Container, error) {
+	if l.client.api != "" {
+		if err := l.client.mkdir(path.Join(l.client.root, name)); err != nil {
+			return nil, err
+		}
+	}
+
+	return &container{name: name, location: l}, nil
+}
+
+// Containers returns MFS directories in root
+func (l *location) Containers(prefix, cursor string, count int) ([]stow.Container, string, error) {
+	entries, err := l.client.list(l.client.root)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var containers []stow.Container
+	for _, e := range entries {
+		if e.Type == 1 && strings.HasPrefix(e.Name, prefix) {
+			containers = append(containers, &container{name: e.Name, location: l})
+		}
+	}
+
+	return containers, "", nil
+}
+
+// Container returns container of given name, MFS directory is created on first write
+func (l *location) Container(id string) (stow.Container, error) {
+	return &container{name: id, location: l}, nil
+}
+
+// RemoveContainer removes MFS directory with its content
+func (l *location) RemoveContainer(id string) error {
+	return l.client.remove(path.Join(l.client.root, id), true)
+}
+
+// ItemByURL returns item for url in form ipfs://container/path
+func (l *location) ItemByURL(u *url.URL) (stow.Item, error) {
+	c, err := l.Container(u.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.Item(u.Path)
+}
@@ -0,0 +1,304 @@
+// Package memory implements stow location keeping objects in memory, it is intended for tests and demos
+package memory
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aldor007/stow"
+)
+
+// Kind represents the name of the location/storage type.
+const Kind = "memory"
+
+// ConfigSeed is optional JSON encoded map of object key to path of local file which content is loaded to every container
+const ConfigSeed = "seed"
+
+func init() {
+	validatefn := func(config stow.Config) error {
+		_, err := readSeed(config)
+		return err
+	}
+
+	makefn := func(config stow.Config) (stow.Location, error) {
+		seed, err := readSeed(config)
+		if err != nil {
+			return nil, err
+		}
+
+		return &location{seed: seed, containers: make(map[string]*container)}, nil
+	}
+
+	kindfn := func(u *url.URL) bool {
+		return u.Scheme == Kind
+	}
+
+	stow.Register(Kind, makefn, kindfn, validatefn)
+}
+
+// readSeed loads content of files listed in seed config
+func readSeed(config stow.Config) (map[string][]byte, error) {
+	value, ok := config.Config(ConfigSeed)
+	if !ok || value == "" {
+		return nil, nil
+	}
+
+	var files map[string]string
+	if err := json.Unmarshal([]byte(value), &files); err != nil {
+		return nil, fmt.Errorf("memory: invalid seed %s", err)
+	}
+
+	seed := make(map[string][]byte, len(files))
+	for key, filePath := range files {
+		buf, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			return nil, fmt.Errorf("memory: unable to read seed file %s", err)
+		}
+		seed[strings.TrimPrefix(key, "/")] = buf
+	}
+
+	return seed, nil
+}
+
+// location keeps containers in memory, containers are created on first use
+type location struct {
+	lock       sync.Mutex
+	seed       map[string][]byte
+	containers map[string]*container
+}
+
+// HasRanges returns false, ranges are handled by client as opened items are seekable
+func (l *location) HasRanges() bool {
+	return false
+}
+
+// Close does nothing
+func (l *location) Close() error {
+	return nil
+}
+
+// CreateContainer returns container of given name creating it when it doesn't exist
+func (l *location) CreateContainer(name string) (stow.Container, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	c, ok := l.containers[name]
+	if !ok {
+		c = &container{name: name, items: make(map[string]*item, len(l.seed))}
+		for key, buf := range l.seed {
+			c.items[key] = newItem(c, key, buf, nil)
+		}
+		l.containers[name] = c
+	}
+
+	return c, nil
+}
+
+// Containers returns list of containers
+func (l *location) Containers(prefix, cursor string, count int) ([]stow.Container, string, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	containers := make([]stow.Container, 0, len(l.containers))
+	for name, c := range l.containers {
+		if strings.HasPrefix(name, prefix) {
+			containers = append(containers, c)
+		}
+	}
+
+	return containers, "", nil
+}
+
+// Container returns container of given name, missing containers are created
+func (l *location) Container(id string) (stow.Container, error) {
+	return l.CreateContainer(id)
+}
+
+// RemoveContainer removes container with its content
+func (l *location) RemoveContainer(id string) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if _, ok := l.containers[id]; !ok {
+		return stow.ErrNotFound
+	}
+
+	delete(l.containers, id)
+	return nil
+}
+
+// ItemByURL returns item for url in form memory://container/path
+func (l *location) ItemByURL(u *url.URL) (stow.Item, error) {
+	c, err := l.Container(u.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.Item(u.Path)
+}
+
+// container keeps items in map
+type container struct {
+	lock  sync.RWMutex
+	name  string
+	items map[string]*item
+}
+
+// ID returns name of container
+func (c *container) ID() string {
+	return c.name
+}
+
+// Name returns name of container
+func (c *container) Name() string {
+	return c.name
+}
+
+// Item returns item of given key
+func (c *container) Item(id string) (stow.Item, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	i, ok := c.items[strings.TrimPrefix(id, "/")]
+	if !ok {
+		return nil, stow.ErrNotFound
+	}
+
+	return i, nil
+}
+
+// Items returns items with given prefix sorted by key, cursor is key of last returned item
+func (c *container) Items(prefix, cursor string, count int) ([]stow.Item, string, error) {
+	prefix = strings.TrimPrefix(prefix, "/")
+	c.lock.RLock()
+	keys := make([]string, 0, len(c.items))
+	for key := range c.items {
+		if strings.HasPrefix(key, prefix) && key > cursor {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	items := make([]stow.Item, 0, len(keys))
+	for _, key := range keys {
+		if count > 0 && len(items) == count {
+			c.lock.RUnlock()
+			return items, items[len(items)-1].ID(), nil
+		}
+		items = append(items, c.items[key])
+	}
+	c.lock.RUnlock()
+
+	return items, "", nil
+}
+
+// RemoveItem deletes item
+func (c *container) RemoveItem(id string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	id = strings.TrimPrefix(id, "/")
+	if _, ok := c.items[id]; !ok {
+		return stow.ErrNotFound
+	}
+
+	delete(c.items, id)
+	return nil
+}
+
+// Put stores content of r under given key
+func (c *container) Put(name string, r io.Reader, size int64, metadata map[string]interface{}) (stow.Item, error) {
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	name = strings.TrimPrefix(name, "/")
+	i := newItem(c, name, buf, metadata)
+	c.lock.Lock()
+	c.items[name] = i
+	c.lock.Unlock()
+	return i, nil
+}
+
+// item is object kept in memory, its content is never modified
+type item struct {
+	container *container
+	id        string
+	body      []byte
+	metadata  map[string]interface{}
+	modTime   time.Time
+}
+
+func newItem(c *container, id string, body []byte, metadata map[string]interface{}) *item {
+	meta := make(map[string]interface{}, len(metadata)+1)
+	for k, v := range metadata {
+		meta[k] = v
+	}
+	// empty item without this flag is treated as directory
+	meta["is_dir"] = false
+
+	return &item{container: c, id: id, body: body, metadata: meta, modTime: time.Now().UTC()}
+}
+
+// ID returns key of item
+func (i *item) ID() string {
+	return i.id
+}
+
+// Name returns key of item
+func (i *item) Name() string {
+	return i.id
+}
+
+// URL returns url of item in form memory://container/path
+func (i *item) URL() *url.URL {
+	return &url.URL{Scheme: Kind, Host: i.container.name, Path: "/" + i.id}
+}
+
+// Size returns size of item content
+func (i *item) Size() (int64, error) {
+	return int64(len(i.body)), nil
+}
+
+// Open returns seekable reader of item content
+func (i *item) Open() (io.ReadCloser, error) {
+	return readSeekCloser{bytes.NewReader(i.body)}, nil
+}
+
+// OpenParams ignores params as ranges aren't handled by location
+func (i *item) OpenParams(_ map[string]interface{}) (io.ReadCloser, error) {
+	return i.Open()
+}
+
+// ContentRange returns error as ranges aren't handled by location
+func (i *item) ContentRange() (stow.ContentRangeData, error) {
+	return stow.ContentRangeData{}, errors.New("response is not a range")
+}
+
+// ETag returns tag based on time of creation and size of item
+func (i *item) ETag() (string, error) {
+	return fmt.Sprintf("%x-%x", i.modTime.UnixNano(), len(i.body)), nil
+}
+
+// LastMod returns time when item was stored
+func (i *item) LastMod() (time.Time, error) {
+	return i.modTime, nil
+}
+
+// Metadata returns metadata given when item was stored
+func (i *item) Metadata() (map[string]interface{}, error) {
+	return i.metadata, nil
+}
+
+type readSeekCloser struct {
+	*bytes.Reader
+}
+
+func (readSeekCloser) Close() error {
+	return nil
+}
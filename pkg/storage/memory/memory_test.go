@@ -0,0 +1,84 @@
+package memory
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/aldor007/stow"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContainer(t *testing.T) {
+	l, err := stow.Dial(Kind, stow.ConfigMap{})
+	assert.Nil(t, err)
+
+	c, err := l.Container("bucket")
+	assert.Nil(t, err)
+
+	_, err = c.Item("missing")
+	assert.Equal(t, stow.ErrNotFound, err)
+
+	_, err = c.Put("/dir/b.jpg", bytes.NewReader([]byte("bb")), 2, map[string]interface{}{"content-type": "image/jpeg"})
+	assert.Nil(t, err)
+	_, err = c.Put("dir/a.jpg", bytes.NewReader([]byte("a")), 1, nil)
+	assert.Nil(t, err)
+	_, err = c.Put("other.jpg", bytes.NewReader([]byte("o")), 1, nil)
+	assert.Nil(t, err)
+
+	item, err := c.Item("/dir/b.jpg")
+	assert.Nil(t, err)
+	size, _ := item.Size()
+	assert.Equal(t, int64(2), size)
+	meta, _ := item.Metadata()
+	assert.Equal(t, "image/jpeg", meta["content-type"])
+
+	r, err := item.Open()
+	assert.Nil(t, err)
+	_, seekable := r.(io.ReadSeeker)
+	assert.True(t, seekable, "content should be seekable so ranges can be served")
+	body, _ := ioutil.ReadAll(r)
+	assert.Equal(t, "bb", string(body))
+
+	items, cursor, err := c.Items("dir/", "", 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(items))
+	assert.Equal(t, "dir/a.jpg", items[0].ID())
+	assert.Equal(t, "dir/a.jpg", cursor)
+
+	items, cursor, err = c.Items("dir/", cursor, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, "dir/b.jpg", items[0].ID())
+
+	assert.Nil(t, c.RemoveItem("dir/b.jpg"))
+	assert.Equal(t, stow.ErrNotFound, c.RemoveItem("dir/b.jpg"))
+
+	same, _ := l.Container("bucket")
+	items, _, _ = same.Items("", "", 0)
+	assert.Equal(t, 2, len(items), "container should keep its content")
+}
+
+func TestSeed(t *testing.T) {
+	file, err := ioutil.TempFile("", "mort-memory")
+	assert.Nil(t, err)
+	defer os.Remove(file.Name())
+	file.Write([]byte("seeded"))
+	file.Close()
+
+	seed, _ := json.Marshal(map[string]string{"/img/seed.jpg": file.Name()})
+	l, err := stow.Dial(Kind, stow.ConfigMap{ConfigSeed: string(seed)})
+	assert.Nil(t, err)
+
+	c, _ := l.Container("bucket")
+	item, err := c.Item("img/seed.jpg")
+	assert.Nil(t, err)
+	r, _ := item.Open()
+	body, _ := ioutil.ReadAll(r)
+	assert.Equal(t, "seeded", string(body))
+
+	_, err = stow.Dial(Kind, stow.ConfigMap{ConfigSeed: `{"a.jpg": "/not/existing"}`})
+	assert.NotNil(t, err)
+}
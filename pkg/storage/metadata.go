@@ -0,0 +1,279 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/aldor007/mort/pkg/monitoring"
+	"github.com/aldor007/mort/pkg/object"
+	"github.com/aldor007/mort/pkg/response"
+	"github.com/aldor007/stow"
+	"go.uber.org/zap"
+)
+
+// metadataSuffix is suffix of key of sidecar object in which metadata of object is stored
+const metadataSuffix = ".mort-meta.json"
+
+// errSidecarKey is returned when client tries to store object under key of sidecar
+var errSidecarKey = errors.New("keys ending with " + metadataSuffix + " are reserved for metadata")
+
+// metadataUpdater is implemented by containers which replace metadata of object without transfer of its content
+type metadataUpdater interface {
+	UpdateMetadata(id string, metadata map[string]interface{}) error
+}
+
+// UpdateMetadata replace metadata of object, storages which support it copy object onto itself on server side
+// Object in other storages is stored again with the same content
+func UpdateMetadata(obj *object.FileObject, metaHeaders http.Header) *response.Response {
+	if isSharded(obj) {
+		return UpdateMetadata(shardObject(obj), metaHeaders)
+	}
+
+	if obj.Storage.DiskCache != nil {
+		getDiskCache(obj.Storage).invalidate(obj)
+	}
+
+	if obj.Storage.MirrorStorage != nil {
+		defer invalidateMirror(obj)
+	}
+
+	return call(obj, "update_metadata", false, func() *response.Response {
+		return updateMetadata(obj, metaHeaders)
+	})
+}
+
+func updateMetadata(obj *object.FileObject, metaHeaders http.Header) *response.Response {
+	instance, err := copyClient(obj)
+	if err != nil {
+		monitoring.Log().Warn("Storage/UpdateMetadata create client", obj.LogData(zap.Int("statusCode", 503), zap.Error(err))...)
+		return response.NewError(503, err)
+	}
+
+	updater, ok := instance.container.(metadataUpdater)
+	if !ok {
+		return rewriteObject(obj, metaHeaders)
+	}
+
+	inc(obj, "update_metadata")
+	metadata := make(http.Header, len(metaHeaders)+len(obj.Storage.Headers))
+	for k, v := range metaHeaders {
+		metadata[k] = v
+	}
+	for k, v := range obj.Storage.Headers {
+		metadata.Set(k, v)
+	}
+
+	err = updater.UpdateMetadata(getKey(obj), prepareMetadata(obj, metadata))
+	if err == errNoMetadataUpdate {
+		return rewriteObject(obj, metaHeaders)
+	}
+
+	if err != nil {
+		if err == stow.ErrNotFound {
+			return response.NewString(404, notFound)
+		}
+
+		monitoring.Log().Warn("Storage/UpdateMetadata cannot update", obj.LogData(zap.Int("statusCode", 500), zap.Error(err))...)
+		return response.NewError(500, err)
+	}
+
+	res := response.NewNoContent(200)
+	res.SetContentType(metaHeaders.Get("Content-Type"))
+	return res
+}
+
+// copyClient returns client of storage which is able to copy object on server side
+// stow s3 adapter can't copy objects, so s3-fixed adapter which accepts the same configuration is used for s3
+func copyClient(obj *object.FileObject) (storageClient, error) {
+	if obj.Storage.Kind != "s3" {
+		return getClient(obj)
+	}
+
+	objCpy := *obj
+	objCpy.Storage.Kind = "s3-fixed"
+	objCpy.Storage.Hash += "-copy"
+	return getClient(&objCpy)
+}
+
+// rewriteObject store object again with the same content and new metadata, content is buffered as object is overwritten while it is read
+func rewriteObject(obj *object.FileObject, metaHeaders http.Header) *response.Response {
+	current := get(obj)
+	if current.StatusCode != 200 {
+		return current
+	}
+
+	buf, err := current.Body()
+	current.Close()
+	if err != nil {
+		return response.NewError(500, err)
+	}
+
+	return set(obj, metaHeaders, int64(len(buf)), bytes.NewReader(buf))
+}
+
+// sidecarKinds are kinds of storage which drop metadata, for them metadata is stored in sidecar object next to object
+var sidecarKinds = map[string]bool{
+	"ftp":  true,
+	"ipfs": true,
+}
+
+// withMetadataSidecar wrap container of storage which doesn't support metadata
+func withMetadataSidecar(kind string, container stow.Container) stow.Container {
+	if !sidecarKinds[kind] {
+		return container
+	}
+
+	return &sidecarContainer{Container: container}
+}
+
+// sidecarContainer stores metadata of objects as JSON in sidecar objects
+type sidecarContainer struct {
+	stow.Container
+}
+
+// Item returns item with metadata read from its sidecar, sidecars aren't accessible as objects
+func (c *sidecarContainer) Item(id string) (stow.Item, error) {
+	if strings.HasSuffix(id, metadataSuffix) {
+		return nil, stow.ErrNotFound
+	}
+
+	item, err := c.Container.Item(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sidecarItem{Item: item, container: c.Container, id: id}, nil
+}
+
+// Items returns items without sidecars, so page may have less items than requested
+func (c *sidecarContainer) Items(prefix, cursor string, count int) ([]stow.Item, string, error) {
+	items, next, err := c.Container.Items(prefix, cursor, count)
+	filtered := items[:0]
+	for _, item := range items {
+		if !strings.HasSuffix(item.ID(), metadataSuffix) {
+			filtered = append(filtered, item)
+		}
+	}
+
+	return filtered, next, err
+}
+
+// Put stores object and its metadata in sidecar, sidecar of object stored without metadata is removed
+func (c *sidecarContainer) Put(name string, r io.Reader, size int64, metadata map[string]interface{}) (stow.Item, error) {
+	if strings.HasSuffix(name, metadataSuffix) {
+		return nil, errSidecarKey
+	}
+
+	item, err := c.Container.Put(name, r, size, metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = c.writeSidecar(name, metadata); err != nil {
+		return nil, err
+	}
+
+	return &sidecarItem{Item: item, container: c.Container, id: name, metadata: metadata}, nil
+}
+
+// UpdateMetadata replaces sidecar of object, content of object isn't changed
+func (c *sidecarContainer) UpdateMetadata(id string, metadata map[string]interface{}) error {
+	if _, err := c.Item(id); err != nil {
+		return err
+	}
+
+	return c.writeSidecar(id, metadata)
+}
+
+// writeSidecar stores metadata of object in sidecar, sidecar is removed when metadata is empty
+func (c *sidecarContainer) writeSidecar(id string, metadata map[string]interface{}) error {
+	if len(metadata) == 0 {
+		c.removeSidecar(id)
+		return nil
+	}
+
+	buf, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.Container.Put(id+metadataSuffix, bytes.NewReader(buf), int64(len(buf)), nil)
+	return err
+}
+
+// RemoveItem removes object with its sidecar
+func (c *sidecarContainer) RemoveItem(id string) error {
+	if strings.HasSuffix(id, metadataSuffix) {
+		return stow.ErrNotFound
+	}
+
+	if err := c.Container.RemoveItem(id); err != nil {
+		return err
+	}
+
+	c.removeSidecar(id)
+	return nil
+}
+
+// removeSidecar removes sidecar of object, missing sidecar isn't an error
+func (c *sidecarContainer) removeSidecar(id string) {
+	if _, err := c.Container.Item(id + metadataSuffix); err == nil {
+		c.Container.RemoveItem(id + metadataSuffix)
+	}
+}
+
+// sidecarItem returns metadata of item merged with metadata from its sidecar
+type sidecarItem struct {
+	stow.Item
+	container stow.Container
+	id        string
+	metadata  map[string]interface{}
+}
+
+// Metadata returns metadata of item, sidecar is read on first call
+func (i *sidecarItem) Metadata() (map[string]interface{}, error) {
+	metadata, err := i.Item.Metadata()
+	if err != nil {
+		return nil, err
+	}
+
+	if i.metadata == nil {
+		i.metadata, err = readSidecar(i.container, i.id)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	merged := make(map[string]interface{}, len(metadata)+len(i.metadata))
+	for k, v := range metadata {
+		merged[k] = v
+	}
+	for k, v := range i.metadata {
+		merged[k] = v
+	}
+
+	return merged, nil
+}
+
+// readSidecar returns metadata stored in sidecar of object, object without sidecar has empty metadata
+func readSidecar(container stow.Container, id string) (map[string]interface{}, error) {
+	item, err := container.Item(id + metadataSuffix)
+	if err == stow.ErrNotFound {
+		return map[string]interface{}{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	r, err := item.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	metadata := map[string]interface{}{}
+	return metadata, json.NewDecoder(r).Decode(&metadata)
+}
@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"bytes"
+	"testing"
+
+	memoryStorage "github.com/aldor007/mort/pkg/storage/memory"
+	"github.com/aldor007/stow"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetadataSidecar(t *testing.T) {
+	location, err := stow.Dial(memoryStorage.Kind, stow.ConfigMap{})
+	assert.Nil(t, err)
+	raw, _ := location.Container("sidecar")
+	container := withMetadataSidecar("ftp", raw)
+
+	_, err = container.Put("dir/file.jpg", bytes.NewReader([]byte("a")), 1, map[string]interface{}{"x-amz-meta-author": "mort"})
+	assert.Nil(t, err)
+
+	_, err = raw.Item("dir/file.jpg" + metadataSuffix)
+	assert.Nil(t, err, "metadata should be stored in sidecar")
+
+	item, err := container.Item("dir/file.jpg")
+	assert.Nil(t, err)
+	metadata, err := item.Metadata()
+	assert.Nil(t, err)
+	assert.Equal(t, "mort", metadata["x-amz-meta-author"])
+	assert.Equal(t, false, metadata["is_dir"])
+
+	items, _, err := container.Items("dir/", "", 10)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(items))
+	assert.Equal(t, "dir/file.jpg", items[0].ID())
+
+	_, err = container.Put("dir/file.jpg", bytes.NewReader([]byte("b")), 1, nil)
+	assert.Nil(t, err)
+	_, err = raw.Item("dir/file.jpg" + metadataSuffix)
+	assert.Equal(t, stow.ErrNotFound, err, "sidecar of object without metadata should be removed")
+
+	container.Put("dir/file.jpg", bytes.NewReader([]byte("c")), 1, map[string]interface{}{"x-amz-meta-author": "mort"})
+	assert.Nil(t, container.RemoveItem("dir/file.jpg"))
+	_, err = raw.Item("dir/file.jpg" + metadataSuffix)
+	assert.Equal(t, stow.ErrNotFound, err)
+}
+
+func TestMetadataSidecarHidden(t *testing.T) {
+	location, _ := stow.Dial(memoryStorage.Kind, stow.ConfigMap{})
+	raw, _ := location.Container("hidden")
+	container := withMetadataSidecar("ftp", raw)
+
+	_, err := container.Put("file.jpg", bytes.NewReader([]byte("a")), 1, map[string]interface{}{"x-amz-meta-author": "mort"})
+	assert.Nil(t, err)
+
+	_, err = container.Item("file.jpg" + metadataSuffix)
+	assert.Equal(t, stow.ErrNotFound, err, "sidecar shouldn't be accessible as object")
+	_, err = container.Put("file.jpg"+metadataSuffix, bytes.NewReader([]byte("{}")), 2, nil)
+	assert.Equal(t, errSidecarKey, err)
+	assert.Equal(t, stow.ErrNotFound, container.RemoveItem("file.jpg"+metadataSuffix))
+
+	updater := container.(metadataUpdater)
+	assert.Nil(t, updater.UpdateMetadata("file.jpg", map[string]interface{}{"x-amz-meta-author": "other"}))
+	item, _ := container.Item("file.jpg")
+	metadata, _ := item.Metadata()
+	assert.Equal(t, "other", metadata["x-amz-meta-author"])
+	assert.Equal(t, stow.ErrNotFound, updater.UpdateMetadata("missing.jpg", map[string]interface{}{"x-amz-meta-author": "other"}))
+}
+
+func TestMetadataSidecarKinds(t *testing.T) {
+	location, _ := stow.Dial(memoryStorage.Kind, stow.ConfigMap{})
+	raw, _ := location.Container("kinds")
+	assert.Equal(t, raw, withMetadataSidecar("s3", raw))
+	assert.Equal(t, raw, withMetadataSidecar("local-meta", raw))
+}
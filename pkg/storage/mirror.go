@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"github.com/aldor007/mort/pkg/monitoring"
+	"github.com/aldor007/mort/pkg/object"
+	"github.com/aldor007/mort/pkg/response"
+	"go.uber.org/zap"
+)
+
+// HeaderMirror name of header informing if object was served from mirror storage
+const HeaderMirror = "x-mort-mirror"
+
+// mirrorObject returns copy of obj pointing to mirror storage
+func mirrorObject(obj *object.FileObject) *object.FileObject {
+	mirrorObj := *obj
+	mirrorObj.Storage = *obj.Storage.MirrorStorage
+	return &mirrorObj
+}
+
+// getMirrored fetch object from mirror storage, when it is missing there it is fetched from origin
+// and copied to mirror in background while it is sent to client
+func getMirrored(obj *object.FileObject, fetch func(o *object.FileObject) *response.Response) *response.Response {
+	mirrorObj := mirrorObject(obj)
+	res := Get(mirrorObj)
+	if res.StatusCode == 200 || res.StatusCode == 206 {
+		res.Set(HeaderMirror, "hit")
+		return res
+	}
+	res.Close()
+
+	res = fetch(obj)
+	res.Set(HeaderMirror, "miss")
+	// partial content can't be mirrored
+	if res.StatusCode != 200 || obj.Range != "" {
+		return res
+	}
+
+	resCpy, err := res.CopyWithStream()
+	if err != nil {
+		monitoring.Log().Warn("Storage/getMirrored unable to copy response", obj.LogData(zap.Error(err))...)
+		return res
+	}
+
+	monitoring.Report().Inc("storage_mirror_count;storage:" + obj.Storage.Kind)
+	go func() {
+		defer resCpy.Close()
+		mirrorRes := Set(mirrorObj, resCpy.Headers, resCpy.ContentLength, resCpy.Stream())
+		if mirrorRes.StatusCode != 200 {
+			monitoring.Log().Warn("Storage/getMirrored unable to store object in mirror", mirrorObj.LogData(zap.Int("statusCode", mirrorRes.StatusCode), zap.Error(mirrorRes.Error()))...)
+		}
+	}()
+
+	return res
+}
+
+// headMirrored check object in mirror storage and fallbacks to origin when it is missing
+func headMirrored(obj *object.FileObject, fetch func(o *object.FileObject) *response.Response) *response.Response {
+	res := Head(mirrorObject(obj))
+	if res.StatusCode == 200 {
+		res.Set(HeaderMirror, "hit")
+		return res
+	}
+	res.Close()
+
+	res = fetch(obj)
+	res.Set(HeaderMirror, "miss")
+	return res
+}
+
+// invalidateMirror remove object from mirror storage after it was changed or removed in origin, so stale copy isn't served
+func invalidateMirror(obj *object.FileObject) {
+	mirrorObj := mirrorObject(obj)
+	res := Delete(mirrorObj)
+	res.Close()
+	if res.StatusCode != 200 {
+		monitoring.Log().Warn("Storage/invalidateMirror unable to remove object from mirror", mirrorObj.LogData(zap.Int("statusCode", res.StatusCode), zap.Error(res.Error()))...)
+	}
+}
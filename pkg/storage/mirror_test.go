@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aldor007/mort/pkg/config"
+	"github.com/aldor007/mort/pkg/object"
+	"github.com/stretchr/testify/assert"
+)
+
+const mirrorConfig = `
+buckets:
+    mirror:
+        storages:
+            basic:
+                kind: "memory"
+                mirror: "shield"
+            shield:
+                kind: "memory"
+`
+
+func TestGetMirrored(t *testing.T) {
+	mortConfig := config.Config{}
+	err := mortConfig.LoadFromString(mirrorConfig)
+	assert.Nil(t, err)
+
+	obj, err := object.NewFileObjectFromPath("/mirror/file", &mortConfig)
+	assert.Nil(t, err)
+
+	body := []byte("origin body")
+	res := Set(obj, http.Header{}, int64(len(body)), bytes.NewReader(body))
+	assert.Equal(t, 200, res.StatusCode)
+
+	res = Get(obj)
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Equal(t, "miss", res.Headers.Get(HeaderMirror))
+	buf, _ := ioutil.ReadAll(res.Stream())
+	assert.Equal(t, "origin body", string(buf))
+	res.Close()
+
+	mirrorObj := mirrorObject(obj)
+	for i := 0; i < 100; i++ {
+		if Head(mirrorObj).StatusCode == 200 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	client, _ := getClient(obj)
+	assert.Nil(t, client.container.RemoveItem(getKey(obj)))
+	res = Get(obj)
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Equal(t, "hit", res.Headers.Get(HeaderMirror))
+	buf, _ = ioutil.ReadAll(res.Stream())
+	assert.Equal(t, "origin body", string(buf))
+	res.Close()
+
+	body = []byte("new body")
+	res = Set(obj, http.Header{}, int64(len(body)), bytes.NewReader(body))
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Equal(t, 404, Head(mirrorObj).StatusCode, "changed object should be removed from mirror")
+
+	res = Get(obj)
+	assert.Equal(t, "miss", res.Headers.Get(HeaderMirror))
+	buf, _ = ioutil.ReadAll(res.Stream())
+	assert.Equal(t, "new body", string(buf))
+	res.Close()
+	for i := 0; i < 100; i++ {
+		if Head(mirrorObj).StatusCode == 200 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	res = Delete(obj)
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Equal(t, 404, Head(mirrorObj).StatusCode, "removed object should be removed from mirror")
+}
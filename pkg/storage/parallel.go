@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/aldor007/mort/pkg/config"
+	"github.com/aldor007/stow"
+)
+
+var errParallelClosed = errors.New("parallel reader closed")
+
+// openPart opens range of object, every part is opened with its own item as items keep state of last range
+type openPart func(start, end int64) (io.ReadCloser, error)
+
+type partResult struct {
+	buf []byte
+	err error
+}
+
+// parallelReader reads object with concurrent ranged requests and returns its parts in order
+// At most concurrency parts are fetched or buffered at once
+type parallelReader struct {
+	parts   []chan partResult
+	current []byte
+	next    int
+	slots   chan struct{}
+	done    chan struct{}
+	once    sync.Once
+}
+
+func newParallelReader(size int64, cfg config.ParallelGetCfg, open openPart) *parallelReader {
+	partSize := cfg.PartSizeMB << 20
+	count := int((size + partSize - 1) / partSize)
+	r := &parallelReader{
+		parts: make([]chan partResult, count),
+		slots: make(chan struct{}, cfg.Concurrency),
+		done:  make(chan struct{}),
+	}
+	for i := range r.parts {
+		r.parts[i] = make(chan partResult, 1)
+	}
+
+	go func() {
+		for i := range r.parts {
+			select {
+			case r.slots <- struct{}{}:
+			case <-r.done:
+				return
+			}
+
+			start := int64(i) * partSize
+			end := start + partSize - 1
+			if end >= size {
+				end = size - 1
+			}
+			go r.fetch(i, start, end, open)
+		}
+	}()
+
+	return r
+}
+
+func (r *parallelReader) fetch(i int, start, end int64, open openPart) {
+	body, err := open(start, end)
+	if err != nil {
+		r.parts[i] <- partResult{err: err}
+		return
+	}
+	defer body.Close()
+
+	buf := make([]byte, end-start+1)
+	_, err = io.ReadFull(body, buf)
+	r.parts[i] <- partResult{buf: buf, err: err}
+}
+
+// Read returns content of parts in order, it waits for part which is still fetched
+func (r *parallelReader) Read(p []byte) (int, error) {
+	for len(r.current) == 0 {
+		if r.next == len(r.parts) {
+			return 0, io.EOF
+		}
+
+		select {
+		case part := <-r.parts[r.next]:
+			if part.err != nil {
+				return 0, fmt.Errorf("unable to read part %d: %w", r.next, part.err)
+			}
+			r.current = part.buf
+			r.next++
+			// part is consumed, so next one can be fetched
+			<-r.slots
+		case <-r.done:
+			return 0, errParallelClosed
+		}
+	}
+
+	n := copy(p, r.current)
+	r.current = r.current[n:]
+	return n, nil
+}
+
+// Close stops fetching of next parts
+func (r *parallelReader) Close() error {
+	r.once.Do(func() {
+		close(r.done)
+	})
+	return nil
+}
+
+// openParallel opens item with parallel ranged requests when storage supports ranges and item is bigger than threshold
+// It returns nil when item should be read with single request
+func openParallel(instance storageClient, cfg *config.ParallelGetCfg, key string, item stow.Item) io.ReadCloser {
+	if cfg == nil || !instance.client.HasRanges() {
+		return nil
+	}
+
+	size, err := item.Size()
+	if err != nil || size < cfg.ThresholdMB<<20 {
+		return nil
+	}
+
+	return newParallelReader(size, *cfg, func(start, end int64) (io.ReadCloser, error) {
+		partItem, err := instance.container.Item(key)
+		if err != nil {
+			return nil, err
+		}
+
+		params := map[string]interface{}{"range": fmt.Sprintf("bytes=%d-%d", start, end)}
+		return partItem.OpenParams(params)
+	})
+}
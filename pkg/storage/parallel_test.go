@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"sync/atomic"
+	"testing"
+
+	"github.com/aldor007/mort/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParallelReader(t *testing.T) {
+	data := make([]byte, 5<<20+123)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+
+	var opened, running, maxRunning int32
+	open := func(start, end int64) (io.ReadCloser, error) {
+		atomic.AddInt32(&opened, 1)
+		if n := atomic.AddInt32(&running, 1); n > atomic.LoadInt32(&maxRunning) {
+			atomic.StoreInt32(&maxRunning, n)
+		}
+		defer atomic.AddInt32(&running, -1)
+		return ioutil.NopCloser(bytes.NewReader(data[start : end+1])), nil
+	}
+
+	r := newParallelReader(int64(len(data)), config.ParallelGetCfg{PartSizeMB: 1, Concurrency: 2}, open)
+	buf, err := ioutil.ReadAll(r)
+	assert.Nil(t, err)
+	assert.Equal(t, data, buf)
+	assert.Equal(t, int32(6), opened)
+	assert.True(t, maxRunning <= 2)
+	assert.Nil(t, r.Close())
+}
+
+func TestParallelReaderError(t *testing.T) {
+	errPart := errors.New("part error")
+	open := func(start, end int64) (io.ReadCloser, error) {
+		if start > 0 {
+			return nil, errPart
+		}
+		return ioutil.NopCloser(bytes.NewReader(make([]byte, end-start+1))), nil
+	}
+
+	r := newParallelReader(3<<20, config.ParallelGetCfg{PartSizeMB: 1, Concurrency: 4}, open)
+	_, err := ioutil.ReadAll(r)
+	assert.True(t, errors.Is(err, errPart))
+	r.Close()
+}
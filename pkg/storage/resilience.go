@@ -0,0 +1,204 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aldor007/mort/pkg/config"
+	"github.com/aldor007/mort/pkg/monitoring"
+	"github.com/aldor007/mort/pkg/object"
+	"github.com/aldor007/mort/pkg/response"
+	"go.uber.org/zap"
+)
+
+var errCircuitOpen = errors.New("storage circuit breaker is open")
+
+// HeaderOrigin name of header with number of origin from which object was fetched (0 - primary storage)
+const HeaderOrigin = "x-mort-origin"
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// circuitBreaker stops sending requests to storage after number of consecutive failures
+// After cooldown single request is let through, when it succeed breaker is closed again
+type circuitBreaker struct {
+	lock      sync.Mutex
+	name      string
+	threshold int
+	cooldown  time.Duration
+	state     breakerState
+	failures  int
+	openedAt  time.Time
+}
+
+var breakers = make(map[string]*circuitBreaker)
+var breakersLock sync.Mutex
+
+func newCircuitBreaker(name string, cfg config.CircuitBreakerCfg) *circuitBreaker {
+	return &circuitBreaker{name: name, threshold: cfg.Threshold, cooldown: time.Duration(cfg.Cooldown) * time.Second}
+}
+
+// getBreaker returns circuit breaker of storage or nil if it isn't configured
+func getBreaker(storageCfg config.Storage) *circuitBreaker {
+	if storageCfg.CircuitBreaker == nil {
+		return nil
+	}
+
+	breakersLock.Lock()
+	defer breakersLock.Unlock()
+	b, ok := breakers[storageCfg.Hash]
+	if !ok {
+		b = newCircuitBreaker(storageCfg.Hash, *storageCfg.CircuitBreaker)
+		breakers[storageCfg.Hash] = b
+	}
+
+	return b
+}
+
+// allow check if request can be send to storage
+func (b *circuitBreaker) allow() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	switch b.state {
+	case breakerClosed:
+		return true
+	case breakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.setState(breakerHalfOpen)
+		return true
+	default:
+		// other request is checking if storage works
+		return false
+	}
+}
+
+// report result of request to storage
+func (b *circuitBreaker) report(success bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if success {
+		b.failures = 0
+		b.setState(breakerClosed)
+		return
+	}
+
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= b.threshold {
+		b.openedAt = time.Now()
+		b.setState(breakerOpen)
+	}
+}
+
+func (b *circuitBreaker) setState(state breakerState) {
+	if state == b.state {
+		return
+	}
+
+	if state == breakerOpen {
+		monitoring.Log().Warn("Storage circuit breaker opened", zap.String("storage", b.name), zap.Int("failures", b.failures))
+	}
+	monitoring.Report().Gauge("storage_breaker_state;storage:"+b.name, float64(state-b.state))
+	b.state = state
+}
+
+// backoff returns delay before next attempt
+func backoff(cfg *config.RetryCfg, attempt int) time.Duration {
+	delay := time.Duration(cfg.Backoff) * time.Millisecond << uint(attempt-1)
+	maxDelay := time.Duration(cfg.MaxBackoff) * time.Millisecond
+	if delay > maxDelay || delay <= 0 {
+		return maxDelay
+	}
+
+	return delay
+}
+
+// wait sleeps for given time, it returns false when context is done before
+func wait(ctx context.Context, d time.Duration) bool {
+	if ctx == nil {
+		time.Sleep(d)
+		return true
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// call executes storage operation guarded by circuit breaker of storage
+// Idempotent operations which failed with 5xx are retried with exponential backoff
+func call(obj *object.FileObject, method string, idempotent bool, op func() *response.Response) *response.Response {
+	breaker := getBreaker(obj.Storage)
+	if breaker != nil && !breaker.allow() {
+		monitoring.Log().Info("Storage circuit breaker rejected request", obj.LogData(zap.String("method", method))...)
+		return response.NewError(503, errCircuitOpen)
+	}
+
+	attempts := 1
+	if idempotent && obj.Storage.Retry != nil {
+		attempts = obj.Storage.Retry.Attempts
+	}
+
+	var res *response.Response
+	for attempt := 1; ; attempt++ {
+		res = op()
+		if res.StatusCode < 500 || attempt >= attempts {
+			break
+		}
+
+		monitoring.Log().Info("Storage retry", obj.LogData(zap.String("method", method), zap.Int("attempt", attempt), zap.Int("statusCode", res.StatusCode))...)
+		monitoring.Report().Inc("storage_retry_count;method:" + method + ",storage:" + obj.Storage.Kind)
+		if !wait(obj.Ctx, backoff(obj.Storage.Retry, attempt)) {
+			break
+		}
+		res.Close()
+	}
+
+	if breaker != nil {
+		breaker.report(res.StatusCode < 500)
+	}
+
+	return res
+}
+
+// failover executes read operation on storage and when it fails with 5xx on next failover origins in order
+func failover(obj *object.FileObject, op func(o *object.FileObject) *response.Response) *response.Response {
+	res := op(obj)
+	if len(obj.Storage.Failover) == 0 {
+		return res
+	}
+
+	origin := 0
+	for i, storageCfg := range obj.Storage.Failover {
+		if res.StatusCode < 500 || (obj.Ctx != nil && obj.Ctx.Err() != nil) {
+			break
+		}
+
+		monitoring.Log().Warn("Storage failover", obj.LogData(zap.Int("origin", i+1), zap.Int("statusCode", res.StatusCode))...)
+		monitoring.Report().Inc("storage_failover_count;storage:" + obj.Storage.Kind)
+		res.Close()
+		originObj := *obj
+		originObj.Storage = storageCfg
+		res = op(&originObj)
+		origin = i + 1
+	}
+
+	res.Set(HeaderOrigin, strconv.Itoa(origin))
+	return res
+}
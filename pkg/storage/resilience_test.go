@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aldor007/mort/pkg/config"
+	"github.com/aldor007/mort/pkg/object"
+	"github.com/aldor007/mort/pkg/response"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	b := newCircuitBreaker("test", config.CircuitBreakerCfg{Threshold: 2, Cooldown: 1})
+
+	assert.True(t, b.allow())
+	b.report(false)
+	assert.True(t, b.allow(), "breaker should be closed below threshold")
+	b.report(false)
+	assert.False(t, b.allow(), "breaker should be opened after threshold")
+
+	b.openedAt = time.Now().Add(-2 * time.Second)
+	assert.True(t, b.allow(), "single request should be let through after cooldown")
+	assert.False(t, b.allow(), "only one request should check storage")
+	b.report(false)
+	assert.False(t, b.allow(), "failed check should open breaker again")
+
+	b.openedAt = time.Now().Add(-2 * time.Second)
+	assert.True(t, b.allow())
+	b.report(true)
+	assert.True(t, b.allow(), "successful check should close breaker")
+	assert.True(t, b.allow())
+}
+
+func TestBackoff(t *testing.T) {
+	cfg := &config.RetryCfg{Attempts: 5, Backoff: 100, MaxBackoff: 300}
+
+	assert.Equal(t, 100*time.Millisecond, backoff(cfg, 1))
+	assert.Equal(t, 200*time.Millisecond, backoff(cfg, 2))
+	assert.Equal(t, 300*time.Millisecond, backoff(cfg, 3))
+	assert.Equal(t, 300*time.Millisecond, backoff(cfg, 60))
+}
+
+func TestCallRetry(t *testing.T) {
+	obj := &object.FileObject{Bucket: "retry", Key: "/file", Ctx: context.Background()}
+	obj.Storage = config.Storage{Kind: "http", Hash: "retry-call", Retry: &config.RetryCfg{Attempts: 3, Backoff: 1, MaxBackoff: 1}}
+
+	calls := 0
+	res := call(obj, "get", true, func() *response.Response {
+		calls++
+		if calls < 3 {
+			return response.NewString(500, "error")
+		}
+		return response.NewString(200, "ok")
+	})
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Equal(t, 3, calls)
+
+	calls = 0
+	res = call(obj, "set", false, func() *response.Response {
+		calls++
+		return response.NewString(500, "error")
+	})
+	assert.Equal(t, 500, res.StatusCode)
+	assert.Equal(t, 1, calls, "not idempotent operation should not be retried")
+
+	calls = 0
+	res = call(obj, "get", true, func() *response.Response {
+		calls++
+		return response.NewString(404, "not found")
+	})
+	assert.Equal(t, 404, res.StatusCode)
+	assert.Equal(t, 1, calls, "client errors should not be retried")
+}
+
+func TestCallCircuitBreaker(t *testing.T) {
+	obj := &object.FileObject{Bucket: "breaker", Key: "/file", Ctx: context.Background()}
+	obj.Storage = config.Storage{Kind: "http", Hash: "breaker-call", CircuitBreaker: &config.CircuitBreakerCfg{Threshold: 1, Cooldown: 60}}
+
+	calls := 0
+	op := func() *response.Response {
+		calls++
+		return response.NewString(503, "down")
+	}
+
+	assert.Equal(t, 503, call(obj, "get", true, op).StatusCode)
+	res := call(obj, "get", true, op)
+	assert.Equal(t, 503, res.StatusCode)
+	assert.Equal(t, 1, calls, "storage should not be called when breaker is open")
+}
+
+func TestFailover(t *testing.T) {
+	obj := &object.FileObject{Bucket: "failover", Key: "/file", Ctx: context.Background()}
+	obj.Storage = config.Storage{Kind: "http", Url: "primary", Failover: []config.Storage{{Kind: "http", Url: "secondary"}, {Kind: "http", Url: "tertiary"}}}
+
+	var called []string
+	res := failover(obj, func(o *object.FileObject) *response.Response {
+		called = append(called, o.Storage.Url)
+		if o.Storage.Url == "tertiary" {
+			return response.NewString(200, "ok")
+		}
+		return response.NewString(503, "down")
+	})
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Equal(t, []string{"primary", "secondary", "tertiary"}, called)
+	assert.Equal(t, "2", res.Headers.Get(HeaderOrigin))
+
+	called = nil
+	res = failover(obj, func(o *object.FileObject) *response.Response {
+		called = append(called, o.Storage.Url)
+		return response.NewString(404, "not found")
+	})
+	assert.Equal(t, 404, res.StatusCode)
+	assert.Equal(t, []string{"primary"}, called, "only server errors should cause failover")
+	assert.Equal(t, "0", res.Headers.Get(HeaderOrigin))
+}
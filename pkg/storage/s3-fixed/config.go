@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"net/http/httptrace"
 	"net/url"
+	"sync"
 	"time"
 
 	"github.com/aldor007/stow"
@@ -48,8 +49,19 @@ const (
 	// ConfigDisableSSL is optional config value for disabling SSL support on custom endpoints
 	// Its default value is "false", to disable SSL set it to "true".
 	ConfigDisableSSL = "disable_ssl"
+
+	// ConfigTransport is optional name of http.RoundTripper registered with RegisterTransport
+	// which should be used instead of default one
+	ConfigTransport = "transport"
 )
 
+var transports sync.Map
+
+// RegisterTransport makes transport available under given name for locations created with ConfigTransport
+func RegisterTransport(name string, transport http.RoundTripper) {
+	transports.Store(name, transport)
+}
+
 const EnableHTTPTracing = false
 
 // transport is an http.RoundTripper that keeps track of the in-flight
@@ -180,6 +192,11 @@ func newS3Client(config stow.Config) (client *s3.S3, endpoint string, err error)
 		MaxIdleConnsPerHost: 50,
 		IdleConnTimeout: 60 * time.Second,
 	})
+	if name, ok := config.Config(ConfigTransport); ok && name != "" {
+		if t, ok := transports.Load(name); ok {
+			transport = t.(http.RoundTripper)
+		}
+	}
 	if EnableHTTPTracing {
 		transport = &tracingTransport{
 			transport: transport,
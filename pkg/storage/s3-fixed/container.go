@@ -2,6 +2,7 @@ package s3_fixed
 
 import (
 	"io"
+	"net/url"
 	"strings"
 	"sync"
 
@@ -169,6 +170,40 @@ func (c *container) Put(name string, r io.Reader, size int64, metadata map[strin
 	return newItem, nil
 }
 
+// UpdateMetadata replaces metadata of object by copying object onto itself, content isn't transferred
+func (c *container) UpdateMetadata(id string, metadata map[string]interface{}) error {
+	mdPrepped, s3Data, err := prepMetadata(metadata)
+	if err != nil {
+		return errors.Wrap(err, "UpdateMetadata, preparing metadata")
+	}
+
+	params := &s3.CopyObjectInput{
+		Bucket:             aws.String(c.name),
+		Key:                aws.String(id),
+		CopySource:         aws.String(url.PathEscape(c.name + "/" + id)),
+		MetadataDirective:  aws.String(s3.MetadataDirectiveReplace),
+		Metadata:           mdPrepped,
+		ContentType:        s3Data.contentType,
+		CacheControl:       s3Data.cacheControl,
+		ContentDisposition: s3Data.contentDisposition,
+		StorageClass:       s3Data.storageClass,
+		ACL:                s3Data.cannedAcl,
+	}
+	if s3Data.tags != nil {
+		params.Tagging = s3Data.tags
+		params.TaggingDirective = aws.String(s3.TaggingDirectiveReplace)
+	}
+
+	if _, err = c.client.CopyObject(params); err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+			return stow.ErrNotFound
+		}
+		return errors.Wrap(err, "UpdateMetadata, copying object")
+	}
+
+	return nil
+}
+
 // Region returns a string representing the region/availability zone of the container.
 func (c *container) Region() string {
 	return c.region
@@ -0,0 +1,143 @@
+package storage
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aldor007/mort/pkg/config"
+	"github.com/aldor007/mort/pkg/object"
+	"github.com/spaolacci/murmur3"
+)
+
+// shardReplicas number of points of each shard on hash ring, more points give more even distribution of keys
+const shardReplicas = 128
+
+var errShardedList = errors.New("listing of sharded storage in S3 format is not supported")
+
+// hashRing maps keys to shards with consistent hashing, so adding or removing shard moves only keys of that shard
+type hashRing struct {
+	points []uint64
+	shards []int
+}
+
+// rings cache of hash rings of sharded storages
+var rings = struct {
+	sync.RWMutex
+	byHash map[string]*hashRing
+}{byHash: make(map[string]*hashRing)}
+
+// shardID returns identity of shard based on its location, so position of shard in configuration doesn't matter
+func shardID(s config.Storage) string {
+	return strings.Join([]string{s.Kind, s.Url, s.Endpoint, s.Address, s.Bucket, s.RootPath, strings.Trim(s.PathPrefix, "/")}, "|")
+}
+
+func newHashRing(shards []config.Storage) *hashRing {
+	r := &hashRing{}
+	type point struct {
+		hash  uint64
+		shard int
+	}
+	points := make([]point, 0, len(shards)*shardReplicas)
+	for i, s := range shards {
+		id := shardID(s)
+		for replica := 0; replica < shardReplicas; replica++ {
+			points = append(points, point{murmur3.Sum64([]byte(id + "#" + strconv.Itoa(replica))), i})
+		}
+	}
+
+	sort.Slice(points, func(a, b int) bool {
+		return points[a].hash < points[b].hash
+	})
+
+	r.points = make([]uint64, len(points))
+	r.shards = make([]int, len(points))
+	for i, p := range points {
+		r.points[i] = p.hash
+		r.shards[i] = p.shard
+	}
+
+	return r
+}
+
+// shard returns index of shard owning key
+func (r *hashRing) shard(key string) int {
+	h := murmur3.Sum64([]byte(key))
+	i := sort.Search(len(r.points), func(i int) bool {
+		return r.points[i] >= h
+	})
+	if i == len(r.points) {
+		i = 0
+	}
+
+	return r.shards[i]
+}
+
+func getRing(storageCfg config.Storage) *hashRing {
+	rings.RLock()
+	r, ok := rings.byHash[storageCfg.Hash]
+	rings.RUnlock()
+	if ok {
+		return r
+	}
+
+	r = newHashRing(storageCfg.Shards)
+	rings.Lock()
+	rings.byHash[storageCfg.Hash] = r
+	rings.Unlock()
+	return r
+}
+
+// ShardIndex returns index of shard of sharded storage which owns object with given key
+func ShardIndex(storageCfg config.Storage, key string) int {
+	return getRing(storageCfg).shard(strings.TrimPrefix(key, "/"))
+}
+
+// isSharded check if object is in sharded storage
+func isSharded(obj *object.FileObject) bool {
+	return obj.Storage.Kind == "sharded"
+}
+
+// shardObject returns copy of obj pointing to shard which owns it
+func shardObject(obj *object.FileObject) *object.FileObject {
+	shardObj := *obj
+	shardObj.Storage = obj.Storage.Shards[ShardIndex(obj.Storage, obj.Key)]
+	return &shardObj
+}
+
+// listShards returns page of objects from shards one after another
+// Marker contains index of listed shard and marker of that shard
+func listShards(obj *object.FileObject, prefix string, marker string, maxKeys int) ([]Item, string, error) {
+	shard, shardMarker := 0, ""
+	if marker != "" {
+		i := strings.Index(marker, ":")
+		if i == -1 {
+			return nil, "", errors.New("invalid marker of sharded storage")
+		}
+
+		var err error
+		if shard, err = strconv.Atoi(marker[:i]); err != nil || shard < 0 || shard >= len(obj.Storage.Shards) {
+			return nil, "", errors.New("invalid marker of sharded storage")
+		}
+		shardMarker = marker[i+1:]
+	}
+
+	shardObj := *obj
+	shardObj.Storage = obj.Storage.Shards[shard]
+	items, nextMarker, err := ListItems(&shardObj, prefix, shardMarker, maxKeys)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if nextMarker != "" && nextMarker != shardMarker {
+		return items, strconv.Itoa(shard) + ":" + nextMarker, nil
+	}
+
+	if shard+1 < len(obj.Storage.Shards) {
+		return items, strconv.Itoa(shard+1) + ":", nil
+	}
+
+	return items, "", nil
+}
@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/aldor007/mort/pkg/config"
+	"github.com/aldor007/mort/pkg/object"
+	"github.com/stretchr/testify/assert"
+)
+
+const shardConfig = `
+buckets:
+    sharded:
+        storages:
+            basic:
+                kind: "sharded"
+                shards:
+                    - kind: "memory"
+                      pathPrefix: "first"
+                    - kind: "memory"
+                      pathPrefix: "second"
+                    - kind: "memory"
+                      pathPrefix: "third"
+`
+
+func TestShardIndex(t *testing.T) {
+	shards := []config.Storage{{Kind: "memory", PathPrefix: "a"}, {Kind: "memory", PathPrefix: "b"}, {Kind: "memory", PathPrefix: "c"}}
+	storageCfg := config.Storage{Kind: "sharded", Shards: shards, Hash: "shard-index"}
+	grown := config.Storage{Kind: "sharded", Shards: append([]config.Storage{{Kind: "memory", PathPrefix: "d"}}, shards...), Hash: "shard-index-grown"}
+
+	counts := make([]int, len(shards))
+	moved := 0
+	for i := 0; i < 3000; i++ {
+		key := "/image" + strconv.Itoa(i)
+		shard := ShardIndex(storageCfg, key)
+		assert.Equal(t, shard, ShardIndex(storageCfg, key), "shard of key should be stable")
+		counts[shard]++
+
+		if grownShard := ShardIndex(grown, key); grownShard != shard+1 {
+			assert.Equal(t, 0, grownShard, "key can be moved only to new shard")
+			moved++
+		}
+	}
+
+	for _, c := range counts {
+		assert.InDelta(t, 1000, c, 250, "keys should be distributed evenly")
+	}
+	assert.InDelta(t, 750, moved, 250, "about 1/N of keys should be moved to new shard")
+}
+
+func TestShardedStorage(t *testing.T) {
+	mortConfig := config.Config{}
+	err := mortConfig.LoadFromString(shardConfig)
+	assert.Nil(t, err)
+
+	for i := 0; i < 20; i++ {
+		obj, err := object.NewFileObjectFromPath("/sharded/file"+strconv.Itoa(i), &mortConfig)
+		assert.Nil(t, err)
+
+		res := Set(obj, http.Header{}, 4, bytes.NewReader([]byte("data")))
+		assert.Equal(t, 200, res.StatusCode)
+		assert.Equal(t, 200, Get(obj).StatusCode)
+
+		shardObj := *obj
+		shardObj.Storage = obj.Storage.Shards[ShardIndex(obj.Storage, obj.Key)]
+		assert.Equal(t, 200, Head(&shardObj).StatusCode, "object should be stored in owning shard")
+	}
+
+	listObj, _ := object.NewFileObjectFromPath("/sharded", &mortConfig)
+	var keys []string
+	marker := ""
+	for {
+		page, nextMarker, err := ListKeys(listObj, "", marker, 5)
+		assert.Nil(t, err)
+		keys = append(keys, page...)
+		if nextMarker == "" {
+			break
+		}
+		marker = nextMarker
+	}
+	assert.Len(t, keys, 20)
+
+	for _, invalid := range []string{"-1:file", "3:", "x:file", "file"} {
+		_, _, err = ListKeys(listObj, "", invalid, 5)
+		assert.NotNil(t, err, invalid)
+	}
+
+	assert.Equal(t, 501, List(listObj, 1000, "", "", "").StatusCode)
+
+	obj, _ := object.NewFileObjectFromPath("/sharded/file1", &mortConfig)
+	assert.Equal(t, 200, Delete(obj).StatusCode)
+	assert.Equal(t, 404, Head(obj).StatusCode)
+}
@@ -1,6 +1,8 @@
 package storage
 
 import (
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"encoding/xml"
 	"fmt"
@@ -12,13 +14,20 @@ import (
 	"mime"
 	"net/http"
 	"path"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/aldor007/mort/pkg/config"
 	"github.com/aldor007/mort/pkg/monitoring"
 	"github.com/aldor007/mort/pkg/object"
 	"github.com/aldor007/mort/pkg/response"
+	b2Native "github.com/aldor007/mort/pkg/storage/b2"
+	ftpStorage "github.com/aldor007/mort/pkg/storage/ftp"
+	ipfsStorage "github.com/aldor007/mort/pkg/storage/ipfs"
+	memoryStorage "github.com/aldor007/mort/pkg/storage/memory"
+	s3Fixed "github.com/aldor007/mort/pkg/storage/s3-fixed"
 	b2Storage "github.com/aldor007/stow/b2"
 	_ "github.com/aldor007/stow/noop"
 	s3Storage "github.com/aldor007/stow/s3"
@@ -27,6 +36,31 @@ import (
 
 const notFound = "{\"error\":\"item not found\"}"
 
+// imageExtensions content types of image formats which are not known to mime package
+var imageExtensions = map[string]string{
+	".heic": "image/heic",
+	".heif": "image/heif",
+	".avif": "image/avif",
+	".dng":  "image/x-adobe-dng",
+	".cr2":  "image/x-canon-cr2",
+	".nef":  "image/x-nikon-nef",
+	".arw":  "image/x-sony-arw",
+	".orf":  "image/x-olympus-orf",
+	".rw2":  "image/x-panasonic-rw2",
+	".raf":  "image/x-fuji-raf",
+}
+
+func init() {
+	for ext, contentType := range imageExtensions {
+		if mime.TypeByExtension(ext) == "" {
+			mime.AddExtensionType(ext, contentType)
+		}
+	}
+
+	// clients are created again with rotated credentials
+	config.OnSecretsChange(resetClients)
+}
+
 // storageClient struct that contain location and container
 type storageClient struct {
 	container stow.Container
@@ -53,8 +87,42 @@ var storageCache = make(map[string]storageClient)
 // storageCacheLock lock for writing to storageCache
 var storageCacheLock = sync.RWMutex{}
 
+// resetClients removes cached storage clients
+func resetClients() {
+	storageCacheLock.Lock()
+	storageCache = make(map[string]storageClient)
+	storageCacheLock.Unlock()
+}
+
 // Get retrieve obj from given storage and returns its wrapped in response
 func Get(obj *object.FileObject) *response.Response {
+	if isSharded(obj) {
+		return Get(shardObject(obj))
+	}
+
+	fetch := func(obj *object.FileObject) *response.Response {
+		return failover(obj, func(o *object.FileObject) *response.Response {
+			return call(o, "get", true, func() *response.Response {
+				return get(o)
+			})
+		})
+	}
+
+	if obj.Storage.MirrorStorage != nil {
+		origin := fetch
+		fetch = func(obj *object.FileObject) *response.Response {
+			return getMirrored(obj, origin)
+		}
+	}
+
+	if obj.Storage.DiskCache != nil && obj.Range == "" {
+		return getDiskCached(obj, fetch)
+	}
+
+	return fetch(obj)
+}
+
+func get(obj *object.FileObject) *response.Response {
 	inc(obj, "get")
 	metric := "storage_time;method:get,storage:" + obj.Storage.Kind
 	t := monitoring.Report().Timer(metric)
@@ -92,6 +160,9 @@ func Get(obj *object.FileObject) *response.Response {
 		params["range"] = obj.Range
 		responseStream, err = item.OpenParams(params)
 		resData.statusCode = 206
+	} else if parallel := openParallel(instance, obj.Storage.ParallelGet, key, item); parallel != nil {
+		responseStream = parallel
+		resData.statusCode = 200
 	} else {
 		responseStream, err = item.Open()
 		resData.statusCode = 200
@@ -106,6 +177,26 @@ func Get(obj *object.FileObject) *response.Response {
 
 // Head retrieve obj from given storage and returns its wrapped in response (but only headers, content of object is omitted)
 func Head(obj *object.FileObject) *response.Response {
+	if isSharded(obj) {
+		return Head(shardObject(obj))
+	}
+
+	fetch := func(obj *object.FileObject) *response.Response {
+		return failover(obj, func(o *object.FileObject) *response.Response {
+			return call(o, "head", true, func() *response.Response {
+				return head(o)
+			})
+		})
+	}
+
+	if obj.Storage.MirrorStorage != nil {
+		return headMirrored(obj, fetch)
+	}
+
+	return fetch(obj)
+}
+
+func head(obj *object.FileObject) *response.Response {
 	inc(obj, "head")
 	metric := "storage_time;method:head,storage:" + obj.Storage.Kind
 	t := monitoring.Report().Timer(metric)
@@ -134,7 +225,26 @@ func Head(obj *object.FileObject) *response.Response {
 }
 
 // Set create object on storage wit given body and headers
+// Body can be read only once so Set is never retried
 func Set(obj *object.FileObject, metaHeaders http.Header, contentLen int64, body io.Reader) *response.Response {
+	if isSharded(obj) {
+		return Set(shardObject(obj), metaHeaders, contentLen, body)
+	}
+
+	if obj.Storage.DiskCache != nil {
+		getDiskCache(obj.Storage).invalidate(obj)
+	}
+
+	if obj.Storage.MirrorStorage != nil {
+		defer invalidateMirror(obj)
+	}
+
+	return call(obj, "set", false, func() *response.Response {
+		return set(obj, metaHeaders, contentLen, body)
+	})
+}
+
+func set(obj *object.FileObject, metaHeaders http.Header, contentLen int64, body io.Reader) *response.Response {
 	inc(obj, "set")
 	metric := "storage_time;method:set,storage:" + obj.Storage.Kind
 	t := monitoring.Report().Timer(metric)
@@ -177,6 +287,18 @@ func Set(obj *object.FileObject, metaHeaders http.Header, contentLen int64, body
 
 // Delete remove object from given storage
 func Delete(obj *object.FileObject) *response.Response {
+	if isSharded(obj) {
+		return Delete(shardObject(obj))
+	}
+
+	if obj.Storage.DiskCache != nil {
+		getDiskCache(obj.Storage).invalidate(obj)
+	}
+
+	if obj.Storage.MirrorStorage != nil {
+		defer invalidateMirror(obj)
+	}
+
 	inc(obj, "delete")
 	metric := "storage_time;method:delete,storage:" + obj.Storage.Kind
 	t := monitoring.Report().Timer(metric)
@@ -207,6 +329,10 @@ func Delete(obj *object.FileObject) *response.Response {
 // List returns list of object in given path in S3 format
 // nolint: gocyclo
 func List(obj *object.FileObject, maxKeys int, _ string, prefix string, marker string) *response.Response {
+	if isSharded(obj) {
+		return response.NewError(501, errShardedList)
+	}
+
 	instance, err := getClient(obj)
 	client := instance.container
 	if err != nil {
@@ -313,6 +439,124 @@ func List(obj *object.FileObject, maxKeys int, _ string, prefix string, marker s
 	return res
 }
 
+// Item describe single object returned by ListItems
+type Item struct {
+	Key          string    // key of object without storage path prefix
+	Size         int64     // size of object in bytes
+	LastModified time.Time // time of last modification of object
+}
+
+// ListItems returns objects (without directories) from storage of obj which keys starts with prefix
+// It returns marker for next page, empty marker means that there are no more objects
+// Objects of sharded storage are listed shard after shard
+func ListItems(obj *object.FileObject, prefix string, marker string, maxKeys int) ([]Item, string, error) {
+	if isSharded(obj) {
+		return listShards(obj, prefix, marker, maxKeys)
+	}
+
+	instance, err := getClient(obj)
+	if err != nil {
+		monitoring.Log().Warn("Storage/ListItems", obj.LogData(zap.Error(err))...)
+		return nil, "", err
+	}
+
+	storagePrefix := strings.TrimPrefix(obj.Storage.PathPrefix, "/")
+	items, resultMarker, err := instance.container.Items(path.Join(storagePrefix, prefix), marker, maxKeys)
+	if err != nil {
+		monitoring.Log().Warn("Storage/ListItems", obj.LogData(zap.Error(err))...)
+		return nil, "", err
+	}
+
+	result := make([]Item, 0, len(items))
+	for _, item := range items {
+		itemID := item.ID()
+		if isDir(item) || strings.HasSuffix(itemID, "/") {
+			continue
+		}
+
+		key := strings.TrimPrefix(strings.TrimPrefix(itemID, "/"), storagePrefix)
+		size, _ := item.Size()
+		lastMod, _ := item.LastMod()
+		result = append(result, Item{Key: strings.TrimPrefix(key, "/"), Size: size, LastModified: lastMod})
+	}
+
+	return result, resultMarker, nil
+}
+
+// ListKeys returns keys of objects (without directories) from storage of obj which starts with prefix
+// It returns marker for next page, empty marker means that there are no more objects
+func ListKeys(obj *object.FileObject, prefix string, marker string, maxKeys int) ([]string, string, error) {
+	items, resultMarker, err := ListItems(obj, prefix, marker, maxKeys)
+	if err != nil {
+		return nil, "", err
+	}
+
+	keys := make([]string, 0, len(items))
+	for _, item := range items {
+		keys = append(keys, item.Key)
+	}
+
+	return keys, resultMarker, nil
+}
+
+// ListDir returns objects and names of sub-directories placed directly in directory dir of storage of obj
+// Storages which list objects recursively are supported, sub-directories are then taken from keys of nested objects
+func ListDir(obj *object.FileObject, dir string, maxKeys int) ([]Item, []string, error) {
+	if isSharded(obj) {
+		return nil, nil, errShardedList
+	}
+
+	instance, err := getClient(obj)
+	if err != nil {
+		monitoring.Log().Warn("Storage/ListDir", obj.LogData(zap.Error(err))...)
+		return nil, nil, err
+	}
+
+	dir = strings.Trim(dir, "/")
+	storagePrefix := strings.TrimPrefix(obj.Storage.PathPrefix, "/")
+	dirPath := strings.Trim(path.Join(storagePrefix, dir), "/")
+	items, _, err := instance.container.Items(dirPath, "", maxKeys)
+	if err != nil {
+		monitoring.Log().Warn("Storage/ListDir", obj.LogData(zap.Error(err))...)
+		return nil, nil, err
+	}
+
+	var files []Item
+	var dirs []string
+	seenDirs := make(map[string]bool)
+	for _, item := range items {
+		name := strings.TrimPrefix(item.ID(), "/")
+		if dirPath != "" {
+			// prefix of storage matches also siblings of directory, e.g. docs2 for docs
+			if !strings.HasPrefix(name, dirPath+"/") {
+				continue
+			}
+			name = name[len(dirPath)+1:]
+		}
+
+		if name == "" {
+			continue
+		}
+
+		if i := strings.Index(name, "/"); i != -1 || isDir(item) {
+			if i != -1 {
+				name = name[:i]
+			}
+			if !seenDirs[name] {
+				seenDirs[name] = true
+				dirs = append(dirs, name)
+			}
+			continue
+		}
+
+		size, _ := item.Size()
+		lastMod, _ := item.LastMod()
+		files = append(files, Item{Key: path.Join(dir, name), Size: size, LastModified: lastMod})
+	}
+
+	return files, dirs, nil
+}
+
 func getClient(obj *object.FileObject) (storageClient, error) {
 	storageCacheLock.RLock()
 	storageCfg := obj.Storage
@@ -327,6 +571,11 @@ func getClient(obj *object.FileObject) (storageClient, error) {
 		return c, nil
 	}
 
+	storageCfg, errSecret := storageCfg.WithSecrets()
+	if errSecret != nil {
+		return storageClient{}, errSecret
+	}
+
 	var config stow.Config
 	var client stow.Location
 
@@ -359,10 +608,60 @@ func getClient(obj *object.FileObject) (storageClient, error) {
 			b2Storage.ConfigAccountID:      storageCfg.Account,
 			b2Storage.ConfigApplicationKey: storageCfg.Key,
 		}
+		if storageCfg.Native {
+			config = stow.ConfigMap{
+				b2Native.ConfigAccountID:      storageCfg.Account,
+				b2Native.ConfigApplicationKey: storageCfg.Key,
+				b2Native.ConfigAuthURL:        storageCfg.Endpoint,
+			}
+		}
+	case "memory":
+		seed, _ := json.Marshal(storageCfg.Seed)
+		config = stow.ConfigMap{
+			memoryStorage.ConfigSeed: string(seed),
+		}
+	case "ipfs":
+		config = stow.ConfigMap{
+			ipfsStorage.ConfigAPI:     storageCfg.Url,
+			ipfsStorage.ConfigGateway: storageCfg.Gateway,
+			ipfsStorage.ConfigRoot:    storageCfg.RootPath,
+			ipfsStorage.ConfigPin:     strconv.FormatBool(storageCfg.Pin),
+		}
+	case "ftp":
+		config = stow.ConfigMap{
+			ftpStorage.ConfigAddress:            storageCfg.Address,
+			ftpStorage.ConfigUsername:           storageCfg.Username,
+			ftpStorage.ConfigPassword:           storageCfg.Password,
+			ftpStorage.ConfigTLS:                storageCfg.TLS,
+			ftpStorage.ConfigInsecureSkipVerify: strconv.FormatBool(storageCfg.InsecureSkipVerify),
+		}
+
+	}
+
+	kind := storageCfg.Kind
+	if kind == "b2" && storageCfg.Native {
+		kind = b2Native.Kind
+	}
 
+	if storageCfg.Transport != nil {
+		switch kind {
+		case "s3", "s3-fixed":
+			// stow s3 adapter can't use own transport, s3-fixed accepts the same configuration
+			kind = "s3-fixed"
+			transport, err := newTransport(storageCfg.Kind, *storageCfg.Transport)
+			if err != nil {
+				return storageClient{}, err
+			}
+			s3Fixed.RegisterTransport(storageCfg.Hash, transport)
+			config.(stow.ConfigMap)[s3Fixed.ConfigTransport] = storageCfg.Hash
+		case "http":
+			if err := registerHostTransport(storageCfg.Url, *storageCfg.Transport); err != nil {
+				return storageClient{}, err
+			}
+		}
 	}
 
-	client, err := stow.Dial(storageCfg.Kind, config)
+	client, err := stow.Dial(kind, config)
 	if err != nil {
 		monitoring.Log().Info("Storage/getClient", zap.String("kind", storageCfg.Kind), zap.Error(err))
 		return storageClient{}, err
@@ -379,19 +678,23 @@ func getClient(obj *object.FileObject) (storageClient, error) {
 
 	if err != nil {
 		monitoring.Log().Info("Storage/getClient container get error", zap.String("kind", storageCfg.Kind), zap.String("bucket", bucketName), zap.Error(err))
-		if err == stow.ErrNotFound && strings.HasPrefix(storageCfg.Kind, "local") {
-			container, err = client.CreateContainer(obj.Bucket)
-			if err != nil {
-				return storageClient{}, err
-			}
-			storageInstance := storageClient{container, client}
-			return storageInstance, nil
+		if err != stow.ErrNotFound || !strings.HasPrefix(storageCfg.Kind, "local") {
+			return storageClient{}, err
 		}
 
+		// created container is wrapped and cached the same way as existing one
+		container, err = client.CreateContainer(bucketName)
+		if err != nil {
+			return storageClient{}, err
+		}
+	}
+
+	container = withMetadataSidecar(storageCfg.Kind, container)
+	storageInstance, err := withEncryption(storageClient{container, client}, storageCfg.Kind, storageCfg.Encryption)
+	if err != nil {
 		return storageClient{}, err
 	}
 
-	storageInstance := storageClient{container, client}
 	storageCache[storageCfg.Hash] = storageInstance
 	return storageInstance, nil
 }
@@ -425,6 +728,13 @@ func prepareResponse(obj *object.FileObject, resData responseData) *response.Res
 		return response.NewError(500, err)
 	}
 
+	// Content-MD5 verified on upload is used as ETag, the same way as S3 does
+	if contentMD5, ok := metadata["x-amz-meta-content-md5"].(string); ok && obj.Storage.Kind != "s3" {
+		if sum, err := base64.StdEncoding.DecodeString(contentMD5); err == nil {
+			etag = "\"" + hex.EncodeToString(sum) + "\""
+		}
+	}
+
 	lastMod, err := item.LastMod()
 	if err != nil {
 		monitoring.Log().Warn("Storage/prepareResponse read lastmod error", obj.LogData(zap.Int("statusCode", 500), zap.Error(err))...)
@@ -249,3 +249,23 @@ func BenchmarkHead(b *testing.B) {
 		Head(obj)
 	}
 }
+
+func TestGetClientCreatedContainerCached(t *testing.T) {
+	rootPath, err := ioutil.TempDir("", "mort-created")
+	assert.Nil(t, err)
+	defer os.RemoveAll(rootPath)
+
+	obj := &object.FileObject{Bucket: "created", Key: "/file",
+		Storage: config.Storage{Kind: "local-meta", RootPath: rootPath, Hash: "created-container-test"}}
+	client, err := getClient(obj)
+	assert.Nil(t, err)
+	assert.NotNil(t, client.container)
+
+	storageCacheLock.RLock()
+	_, cached := storageCache[obj.Storage.Hash]
+	storageCacheLock.RUnlock()
+	assert.True(t, cached, "client of created container should be cached")
+
+	_, err = os.Stat(filepath.Join(rootPath, "created"))
+	assert.Nil(t, err)
+}
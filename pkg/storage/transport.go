@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aldor007/mort/pkg/config"
+	"github.com/aldor007/mort/pkg/monitoring"
+	"go.uber.org/zap"
+)
+
+var (
+	storageTransports     *hostTransports // transports of http storages
+	storageTransportsOnce sync.Once       // protects http.DefaultTransport from being replaced twice
+)
+
+// poolTransport reports usage of connection pool of storage
+type poolTransport struct {
+	kind      string
+	transport http.RoundTripper
+}
+
+// RoundTrip executes request and reports if connection for it was reused or new one was opened
+func (t *poolTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			monitoring.Report().Inc("storage_conn_count;storage:" + t.kind + ",reused:" + strconv.FormatBool(info.Reused))
+		},
+	}
+
+	return t.transport.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+}
+
+// newTransport creates HTTP transport with connection pool configured according to cfg
+func newTransport(kind string, cfg config.TransportCfg) (http.RoundTripper, error) {
+	transport := &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout:   time.Duration(cfg.DialTimeout) * time.Second,
+			KeepAlive: time.Duration(cfg.KeepAlive) * time.Second,
+		}).DialContext,
+		Proxy:                 http.ProxyFromEnvironment,
+		MaxIdleConns:          cfg.MaxIdleConns,
+		MaxIdleConnsPerHost:   cfg.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       cfg.MaxConnsPerHost,
+		IdleConnTimeout:       time.Duration(cfg.IdleConnTimeout) * time.Second,
+		TLSHandshakeTimeout:   time.Duration(cfg.TLSHandshakeTimeout) * time.Second,
+		ResponseHeaderTimeout: time.Duration(cfg.ResponseHeaderTimeout) * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+
+	if cfg.InsecureSkipVerify {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+
+	if cfg.Proxy != "" {
+		proxyURL, err := url.Parse(cfg.Proxy)
+		if err != nil {
+			return nil, err
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	return &poolTransport{kind: kind, transport: transport}, nil
+}
+
+// hostTransports routes requests to transports of http storages by host of request
+// stow http adapter doesn't accept own http client, so router is installed as http.DefaultTransport once
+// and requests to other hosts use previous default transport unchanged
+type hostTransports struct {
+	fallback   http.RoundTripper
+	transports sync.Map // host -> http.RoundTripper
+}
+
+// RoundTrip executes request with transport of storage with host of request
+func (h *hostTransports) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t, ok := h.transports.Load(req.URL.Host); ok {
+		return t.(http.RoundTripper).RoundTrip(req)
+	}
+
+	return h.fallback.RoundTrip(req)
+}
+
+// register uses transport for requests to host, first transport of host is kept
+func (h *hostTransports) register(host string, transport http.RoundTripper) bool {
+	_, loaded := h.transports.LoadOrStore(host, transport)
+	return !loaded
+}
+
+// registerHostTransport configures transport of http storage with given url, only requests to its host are affected
+func registerHostTransport(storageURL string, cfg config.TransportCfg) error {
+	u, err := url.Parse(storageURL)
+	if err != nil {
+		return err
+	}
+
+	storageTransportsOnce.Do(func() {
+		storageTransports = &hostTransports{fallback: http.DefaultTransport}
+		http.DefaultTransport = storageTransports
+	})
+
+	transport, err := newTransport("http", cfg)
+	if err != nil {
+		return err
+	}
+
+	if !storageTransports.register(u.Host, transport) {
+		monitoring.Log().Warn("Storage/registerHostTransport transport of host already configured, settings are ignored", zap.String("host", u.Host))
+	}
+
+	return nil
+}
@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aldor007/mort/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	transport, err := newTransport("http", config.TransportCfg{MaxIdleConns: 10, MaxIdleConnsPerHost: 2, IdleConnTimeout: 60, DialTimeout: 1})
+	assert.Nil(t, err)
+
+	client := http.Client{Transport: transport}
+	for i := 0; i < 2; i++ {
+		res, err := client.Get(server.URL)
+		assert.Nil(t, err)
+		body, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		assert.Equal(t, "ok", string(body))
+	}
+}
+
+func TestNewTransportProxy(t *testing.T) {
+	_, err := newTransport("http", config.TransportCfg{Proxy: "http://proxy:3128"})
+	assert.Nil(t, err)
+
+	_, err = newTransport("http", config.TransportCfg{Proxy: "http://proxy\n"})
+	assert.NotNil(t, err)
+}
+
+func TestHostTransports(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	fallback := &countingTransport{transport: http.DefaultTransport}
+	storage := &countingTransport{transport: http.DefaultTransport}
+	h := &hostTransports{fallback: fallback}
+	assert.True(t, h.register("storage.local", storage))
+	assert.False(t, h.register("storage.local", fallback), "first transport of host should be kept")
+
+	client := http.Client{Transport: h}
+	res, err := client.Get(server.URL)
+	assert.Nil(t, err)
+	res.Body.Close()
+	assert.Equal(t, 1, fallback.count, "requests to other hosts should use default transport")
+	assert.Equal(t, 0, storage.count)
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	req.URL.Host = "storage.local"
+	storage.transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: 200, Body: ioutil.NopCloser(strings.NewReader("storage")), Request: r}, nil
+	})
+	res, err = client.Do(req)
+	assert.Nil(t, err)
+	res.Body.Close()
+	assert.Equal(t, 1, storage.count)
+}
+
+type countingTransport struct {
+	transport http.RoundTripper
+	count     int
+}
+
+func (c *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	c.count++
+	return c.transport.RoundTrip(req)
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
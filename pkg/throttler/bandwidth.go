@@ -0,0 +1,82 @@
+package throttler
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Bandwidth is token-bucket limiter of bytes sent per second
+// All methods are safe to call on nil Bandwidth, which doesn't limit anything
+type Bandwidth struct {
+	rate   float64 // bytes per second
+	burst  float64 // max number of bytes sent at once after idle period
+	lock   sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// NewBandwidth create limiter of rate bytes per second, it returns nil when rate isn't positive
+// Burst defaults to rate
+func NewBandwidth(rate, burst int64) *Bandwidth {
+	if rate <= 0 {
+		return nil
+	}
+
+	if burst <= 0 {
+		burst = rate
+	}
+
+	return &Bandwidth{rate: float64(rate), burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// Burst returns max number of bytes which should be sent at once
+func (b *Bandwidth) Burst() int {
+	if b == nil {
+		return 0
+	}
+
+	return int(b.burst)
+}
+
+// reserve take n bytes from bucket and returns time after which they can be sent
+// Bucket may go below zero, so senders waiting in parallel share bandwidth in order of reservations
+func (b *Bandwidth) reserve(n int) time.Duration {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// Wait blocks until n bytes can be sent or context is done
+// It returns time spent on waiting
+func (b *Bandwidth) Wait(ctx context.Context, n int) (time.Duration, error) {
+	if b == nil {
+		return 0, nil
+	}
+
+	delay := b.reserve(n)
+	if delay <= 0 {
+		return 0, nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return delay, nil
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
@@ -0,0 +1,44 @@
+package throttler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewBandwidthDisabled(t *testing.T) {
+	b := NewBandwidth(0, 100)
+	assert.Nil(t, b)
+
+	waited, err := b.Wait(context.Background(), 1<<20)
+	assert.Nil(t, err)
+	assert.Equal(t, time.Duration(0), waited)
+	assert.Equal(t, 0, b.Burst())
+}
+
+func TestBandwidthWait(t *testing.T) {
+	b := NewBandwidth(1000, 0)
+	assert.Equal(t, 1000, b.Burst(), "burst should default to rate")
+
+	waited, err := b.Wait(context.Background(), 1000)
+	assert.Nil(t, err)
+	assert.Equal(t, time.Duration(0), waited, "burst should be sent without waiting")
+
+	start := time.Now()
+	waited, err = b.Wait(context.Background(), 100)
+	assert.Nil(t, err)
+	assert.True(t, waited > 50*time.Millisecond)
+	assert.True(t, time.Since(start) >= waited)
+}
+
+func TestBandwidthWaitCanceled(t *testing.T) {
+	b := NewBandwidth(10, 10)
+	b.Wait(context.Background(), 10)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := b.Wait(ctx, 10)
+	assert.Equal(t, context.Canceled, err)
+}
@@ -10,6 +10,7 @@ type BucketThrottler struct {
 	tokens         chan struct{}
 	backlogTokens  chan struct{}
 	backlogTimeout time.Duration
+	onQueue        func(delta int) // called when request starts (1) and stops (-1) waiting for token
 }
 
 // NewBucketThrottler create a new instance of BucketThrottler which limit
@@ -36,19 +37,39 @@ func NewBucketThrottlerBacklog(limit int, backlog int, timeout time.Duration) *B
 	return t
 }
 
+// OnQueue set function notified about changes of number of requests waiting for token
+// It has to be set before throttler is used
+func (t *BucketThrottler) OnQueue(fn func(delta int)) {
+	t.onQueue = fn
+}
+
 // Take retrieve a token from bucket
 func (t *BucketThrottler) Take(ctx context.Context) bool {
 	select {
 	case <-ctx.Done():
 		return false
 	case btok := <-t.backlogTokens:
-		timer := time.NewTimer(t.backlogTimeout)
-
 		defer func() {
 			t.backlogTokens <- btok
 		}()
 
 		select {
+		case <-t.tokens:
+			return true
+		default:
+		}
+
+		if t.onQueue != nil {
+			t.onQueue(1)
+			defer t.onQueue(-1)
+		}
+
+		timer := time.NewTimer(t.backlogTimeout)
+		defer timer.Stop()
+
+		select {
+		case <-ctx.Done():
+			return false
 		case <-timer.C:
 			return false
 		case <-t.tokens:
@@ -59,6 +80,17 @@ func (t *BucketThrottler) Take(ctx context.Context) bool {
 	}
 }
 
+// State returns number of free tokens and requests waiting for them
+// Requests which are taking token at the moment are counted as queued
+func (t *BucketThrottler) State() State {
+	return State{
+		Limit:     int64(cap(t.tokens)),
+		Remaining: int64(len(t.tokens)),
+		Queued:    cap(t.backlogTokens) - len(t.backlogTokens),
+		Backlog:   cap(t.backlogTokens) - cap(t.tokens),
+	}
+}
+
 // Release return toke to bucket
 func (t *BucketThrottler) Release() {
 	t.tokens <- struct{}{}
@@ -31,3 +31,49 @@ func TestNewBucketThrottlerBucket(t *testing.T) {
 	token = th.Take(ctx)
 	assert.True(t, token)
 }
+
+func TestBucketThrottlerQueue(t *testing.T) {
+	th := NewBucketThrottlerBacklog(1, 0, time.Second)
+	var queued, maxQueued int
+	th.OnQueue(func(delta int) {
+		queued += delta
+		if queued > maxQueued {
+			maxQueued = queued
+		}
+	})
+	ctx := context.Background()
+	assert.True(t, th.Take(ctx))
+
+	result := make(chan bool)
+	go func() {
+		result <- th.Take(ctx)
+	}()
+	time.Sleep(time.Millisecond * 10)
+	// backlog is full
+	assert.False(t, th.Take(ctx))
+
+	th.Release()
+	assert.True(t, <-result)
+	assert.Equal(t, 0, queued)
+	assert.Equal(t, 1, maxQueued)
+}
+
+func TestBucketThrottlerCancel(t *testing.T) {
+	th := NewBucketThrottlerBacklog(1, 1, time.Second)
+	assert.True(t, th.Take(context.Background()))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	assert.False(t, th.Take(ctx))
+}
+
+func TestBucketThrottlerState(t *testing.T) {
+	th := NewBucketThrottlerBacklog(2, 3, time.Second)
+	assert.True(t, th.Take(context.Background()))
+
+	state := th.State()
+	assert.Equal(t, int64(2), state.Limit)
+	assert.Equal(t, int64(1), state.Remaining)
+	assert.Equal(t, 0, state.Queued)
+	assert.Equal(t, 3, state.Backlog)
+}
@@ -0,0 +1,183 @@
+package throttler
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// defaultTransformMemory memory reserved by Take when size of transform is unknown
+const defaultTransformMemory = 64 << 20
+
+// MemoryAware is throttler which admits work according to its estimated memory usage
+type MemoryAware interface {
+	TakeMemory(ctx context.Context, size int64) (taken bool) // TakeMemory reserve size bytes of budget, when false request have been throttled
+	ReleaseMemory(size int64)                                // ReleaseMemory return size bytes to budget
+}
+
+type memoryWaiter struct {
+	size     int64
+	priority Priority
+	ready    chan struct{}
+	evicted  chan struct{} // closed when waiter was removed from queue by request with higher priority
+}
+
+// MemoryThrottler is admission controller which keeps estimated memory of concurrent transforms in global budget
+// Requests which don't fit in budget wait in FIFO queue, when queue is full or wait times out they are rejected
+// Interactive requests are queued before batch ones and when queue is full they take place of the last batch request
+type MemoryThrottler struct {
+	lock    sync.Mutex
+	budget  int64
+	used    int64
+	backlog int
+	timeout time.Duration
+	waiters []*memoryWaiter
+}
+
+// NewMemoryThrottler create a new instance of MemoryThrottler with budget in bytes
+func NewMemoryThrottler(budget int64, backlog int, timeout time.Duration) *MemoryThrottler {
+	return &MemoryThrottler{
+		budget:  budget,
+		backlog: backlog,
+		timeout: timeout,
+	}
+}
+
+// clamp ensure that transform bigger than budget can still run alone
+func (t *MemoryThrottler) clamp(size int64) int64 {
+	if size > t.budget {
+		return t.budget
+	}
+
+	if size < 1 {
+		return 1
+	}
+
+	return size
+}
+
+// Take reserve default amount of memory
+func (t *MemoryThrottler) Take(ctx context.Context) bool {
+	return t.TakeMemory(ctx, defaultTransformMemory)
+}
+
+// Release return default amount of memory
+func (t *MemoryThrottler) Release() {
+	t.ReleaseMemory(defaultTransformMemory)
+}
+
+// TakeMemory reserve size bytes of budget waiting for it in queue when needed
+func (t *MemoryThrottler) TakeMemory(ctx context.Context, size int64) bool {
+	size = t.clamp(size)
+	t.lock.Lock()
+	if len(t.waiters) == 0 && t.used+size <= t.budget {
+		t.used += size
+		t.lock.Unlock()
+		return true
+	}
+
+	priority := PriorityFromContext(ctx)
+	if len(t.waiters) >= t.backlog && !t.evict(priority) {
+		t.lock.Unlock()
+		return false
+	}
+
+	w := &memoryWaiter{size: size, priority: priority, ready: make(chan struct{}), evicted: make(chan struct{})}
+	t.enqueue(w)
+	t.lock.Unlock()
+
+	timer := time.NewTimer(t.timeout)
+	defer timer.Stop()
+
+	select {
+	case <-w.ready:
+		return true
+	case <-w.evicted:
+		return false
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	select {
+	case <-w.ready:
+		// memory was granted while waiter was giving up
+		t.used -= w.size
+	default:
+		for i, waiter := range t.waiters {
+			if waiter == w {
+				t.waiters = append(t.waiters[:i], t.waiters[i+1:]...)
+				break
+			}
+		}
+	}
+	t.wake()
+
+	return false
+}
+
+// ReleaseMemory return size bytes to budget and admit waiting requests
+func (t *MemoryThrottler) ReleaseMemory(size int64) {
+	t.lock.Lock()
+	t.used -= t.clamp(size)
+	t.wake()
+	t.lock.Unlock()
+}
+
+// Used returns memory currently reserved by transforms
+func (t *MemoryThrottler) Used() int64 {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.used
+}
+
+// State returns usage of memory budget in MB
+func (t *MemoryThrottler) State() State {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	remaining := t.budget - t.used
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	return State{Limit: t.budget >> 20, Remaining: remaining >> 20, Queued: len(t.waiters), Backlog: t.backlog}
+}
+
+// enqueue add waiter after the last waiter with the same or higher priority, lock must be held
+func (t *MemoryThrottler) enqueue(w *memoryWaiter) {
+	i := len(t.waiters)
+	for i > 0 && t.waiters[i-1].priority > w.priority {
+		i--
+	}
+
+	t.waiters = append(t.waiters, nil)
+	copy(t.waiters[i+1:], t.waiters[i:])
+	t.waiters[i] = w
+}
+
+// evict remove the last waiter from queue when it has lower priority than given one, lock must be held
+func (t *MemoryThrottler) evict(priority Priority) bool {
+	if len(t.waiters) == 0 {
+		return false
+	}
+
+	last := t.waiters[len(t.waiters)-1]
+	if last.priority <= priority {
+		return false
+	}
+
+	t.waiters = t.waiters[:len(t.waiters)-1]
+	close(last.evicted)
+	return true
+}
+
+// wake admit waiters from head of queue while they fit in budget, lock must be held
+func (t *MemoryThrottler) wake() {
+	for len(t.waiters) > 0 && t.used+t.waiters[0].size <= t.budget {
+		w := t.waiters[0]
+		t.used += w.size
+		t.waiters = t.waiters[1:]
+		close(w.ready)
+	}
+}
@@ -0,0 +1,121 @@
+package throttler
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestMemoryThrottler(t *testing.T) {
+	th := NewMemoryThrottler(100, 0, time.Millisecond*10)
+	ctx := context.Background()
+
+	assert.True(t, th.TakeMemory(ctx, 60))
+	assert.True(t, th.TakeMemory(ctx, 40))
+	assert.False(t, th.TakeMemory(ctx, 10))
+
+	th.ReleaseMemory(40)
+	assert.True(t, th.TakeMemory(ctx, 10))
+	assert.Equal(t, int64(70), th.Used())
+}
+
+func TestMemoryThrottlerQueue(t *testing.T) {
+	th := NewMemoryThrottler(100, 1, time.Second)
+	ctx := context.Background()
+
+	assert.True(t, th.TakeMemory(ctx, 100))
+
+	result := make(chan bool)
+	go func() {
+		result <- th.TakeMemory(ctx, 50)
+	}()
+
+	time.Sleep(time.Millisecond * 10)
+	// queue is full
+	assert.False(t, th.TakeMemory(ctx, 50))
+
+	th.ReleaseMemory(100)
+	assert.True(t, <-result)
+	assert.Equal(t, int64(50), th.Used())
+}
+
+func TestMemoryThrottlerTimeout(t *testing.T) {
+	th := NewMemoryThrottler(100, 1, time.Millisecond*10)
+	ctx := context.Background()
+
+	assert.True(t, th.TakeMemory(ctx, 80))
+	assert.False(t, th.TakeMemory(ctx, 50))
+
+	th.ReleaseMemory(80)
+	assert.Equal(t, int64(0), th.Used())
+}
+
+func TestMemoryThrottlerBiggerThanBudget(t *testing.T) {
+	th := NewMemoryThrottler(100, 0, time.Millisecond*10)
+	ctx := context.Background()
+
+	assert.True(t, th.TakeMemory(ctx, 1000))
+	th.ReleaseMemory(1000)
+	assert.Equal(t, int64(0), th.Used())
+}
+
+func TestMemoryThrottlerPriority(t *testing.T) {
+	th := NewMemoryThrottler(100, 2, time.Second)
+	ctx := context.Background()
+	assert.True(t, th.TakeMemory(ctx, 100))
+
+	batch := make(chan bool)
+	go func() {
+		batch <- th.TakeMemory(WithPriority(ctx, PriorityBatch), 100)
+	}()
+	time.Sleep(time.Millisecond * 10)
+
+	interactive := make(chan bool)
+	go func() {
+		interactive <- th.TakeMemory(ctx, 100)
+	}()
+	time.Sleep(time.Millisecond * 10)
+
+	// interactive request is admitted before batch one which waits longer
+	th.ReleaseMemory(100)
+	assert.True(t, <-interactive)
+	th.ReleaseMemory(100)
+	assert.True(t, <-batch)
+}
+
+func TestMemoryThrottlerEvictBatch(t *testing.T) {
+	th := NewMemoryThrottler(100, 1, time.Second)
+	ctx := context.Background()
+	assert.True(t, th.TakeMemory(ctx, 100))
+
+	batch := make(chan bool)
+	go func() {
+		batch <- th.TakeMemory(WithPriority(ctx, PriorityBatch), 50)
+	}()
+	time.Sleep(time.Millisecond * 10)
+
+	// queue is full, batch request can't evict other batch request
+	assert.False(t, th.TakeMemory(WithPriority(ctx, PriorityBatch), 50))
+
+	interactive := make(chan bool)
+	go func() {
+		interactive <- th.TakeMemory(ctx, 50)
+	}()
+	assert.False(t, <-batch)
+
+	th.ReleaseMemory(100)
+	assert.True(t, <-interactive)
+	assert.Equal(t, int64(50), th.Used())
+}
+
+func TestMemoryThrottlerState(t *testing.T) {
+	th := NewMemoryThrottler(100<<20, 2, time.Second)
+	assert.True(t, th.TakeMemory(context.Background(), 80<<20))
+
+	state := th.State()
+	assert.Equal(t, int64(100), state.Limit)
+	assert.Equal(t, int64(20), state.Remaining)
+	assert.Equal(t, 0, state.Queued)
+	assert.Equal(t, 2, state.Backlog)
+}
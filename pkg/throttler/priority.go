@@ -0,0 +1,77 @@
+package throttler
+
+import (
+	"context"
+	"time"
+)
+
+// batchRetryInterval time after which background work throttled by throttler tries again
+const batchRetryInterval = 100 * time.Millisecond
+
+// Priority class of request used when waiting for capacity
+type Priority int
+
+const (
+	// PriorityInteractive requests of users, it is default priority
+	PriorityInteractive Priority = iota
+	// PriorityBatch background requests (e.g. warmup or pre-generation), they wait until interactive requests are admitted
+	PriorityBatch
+)
+
+type priorityContext string
+
+// priorityCtxKey key under which priority is stored in context
+var priorityCtxKey priorityContext = "priority"
+
+// String returns name of priority
+func (p Priority) String() string {
+	if p == PriorityBatch {
+		return "batch"
+	}
+
+	return "interactive"
+}
+
+// ParsePriority returns priority of given name, unknown names are treated as interactive
+func ParsePriority(name string) Priority {
+	if name == "batch" {
+		return PriorityBatch
+	}
+
+	return PriorityInteractive
+}
+
+// WithPriority returns context with given priority
+func WithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityCtxKey, p)
+}
+
+// TakeBatch take capacity of throttler for background work (e.g. lifecycle or sync) with batch priority, so interactive requests are admitted first
+// Throttled work is retried until capacity is taken, false is returned only when ctx is done
+func TakeBatch(ctx context.Context, t Throttler) bool {
+	ctx = WithPriority(ctx, PriorityBatch)
+	for {
+		if t.Take(ctx) {
+			return true
+		}
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(batchRetryInterval):
+		}
+	}
+}
+
+// PriorityFromContext returns priority stored in context, interactive is returned when it isn't set
+func PriorityFromContext(ctx context.Context) Priority {
+	if ctx == nil {
+		return PriorityInteractive
+	}
+
+	if p, ok := ctx.Value(priorityCtxKey).(Priority); ok {
+		return p
+	}
+
+	return PriorityInteractive
+}
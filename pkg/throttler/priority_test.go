@@ -0,0 +1,34 @@
+package throttler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPriority(t *testing.T) {
+	ctx := context.Background()
+	assert.Equal(t, PriorityInteractive, PriorityFromContext(ctx))
+	assert.Equal(t, PriorityBatch, PriorityFromContext(WithPriority(ctx, PriorityBatch)))
+
+	assert.Equal(t, PriorityBatch, ParsePriority("batch"))
+	assert.Equal(t, PriorityInteractive, ParsePriority("urgent"))
+	assert.Equal(t, "batch", PriorityBatch.String())
+}
+
+func TestTakeBatch(t *testing.T) {
+	th := NewMemoryThrottler(10, 0, time.Millisecond)
+	assert.True(t, TakeBatch(context.Background(), th))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.False(t, TakeBatch(ctx, th), "batch work should wait until capacity is released")
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		th.Release()
+	}()
+	assert.True(t, TakeBatch(context.Background(), th))
+}
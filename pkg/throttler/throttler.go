@@ -14,6 +14,19 @@ type Throttler interface {
 	Release()                              // Release returns token to pool
 }
 
+// State describe current usage of throttler, it is used for rate limit headers of throttled responses
+type State struct {
+	Limit     int64 // capacity of throttler in its units (MB of memory budget or number of slots)
+	Remaining int64 // free capacity
+	Queued    int   // number of requests waiting for capacity
+	Backlog   int   // max number of waiting requests
+}
+
+// StateReporter is throttler which can report its current usage
+type StateReporter interface {
+	State() State
+}
+
 // NopThrottler is always return that you can perform given operation
 type NopThrottler struct {
 }
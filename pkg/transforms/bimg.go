@@ -0,0 +1,247 @@
+//go:build cgo
+// +build cgo
+
+package transforms
+
+import (
+	"math"
+	"strings"
+
+	"gopkg.in/h2non/bimg.v1"
+)
+
+// Types of transform parameters are types of bimg so options can be passed to libvips without conversion
+type (
+	ImageType      = bimg.ImageType
+	Angle          = bimg.Angle
+	Interpretation = bimg.Interpretation
+	Gravity        = bimg.Gravity
+)
+
+const (
+	formatUnknown = bimg.UNKNOWN
+	formatJPEG    = bimg.JPEG
+	formatWEBP    = bimg.WEBP
+	formatPNG     = bimg.PNG
+	formatGIF     = bimg.GIF
+	formatPDF     = bimg.PDF
+	formatSVG     = bimg.SVG
+	formatHEIF    = bimg.HEIF
+	formatAVIF    = bimg.AVIF
+)
+
+const (
+	angle0   = bimg.D0
+	angle90  = bimg.D90
+	angle180 = bimg.D180
+	angle270 = bimg.D270
+)
+
+const (
+	interpretationBW   = bimg.InterpretationBW
+	interpretationSRGB = bimg.InterpretationSRGB
+)
+
+const (
+	gravityCentre = bimg.GravityCentre
+	gravityNorth  = bimg.GravityNorth
+	gravityEast   = bimg.GravityEast
+	gravitySouth  = bimg.GravitySouth
+	gravityWest   = bimg.GravityWest
+	gravitySmart  = bimg.GravitySmart
+)
+
+// NewImageInfo create new ImageInfo object from bimg metadata
+func NewImageInfo(metadata bimg.ImageMetadata, format string) ImageInfo {
+	return ImageInfo{width: metadata.Size.Width, height: metadata.Size.Height, format: format, orientation: metadata.Orientation}
+}
+
+// BimgOptions return complete options for bimg lib
+func (t *Transforms) BimgOptions(imageInfo ImageInfo) ([]bimg.Options, error) {
+	var opts []bimg.Options
+	if area, ok := t.GravityArea(imageInfo); ok {
+		// area with aspect ratio of crop is extracted first, so crop is only resize
+		opts = append(opts, bimg.Options{Top: area.Min.Y, Left: area.Min.X, AreaWidth: area.Dx(), AreaHeight: area.Dy(), Quality: 100})
+	}
+
+	if t.fill && t.width > 0 && t.height > 0 {
+		ar := float64(t.width) / float64(t.height)
+		b := bimg.Options{
+			Crop: true,
+		}
+		if ar > 1 {
+			b.Width = imageInfo.width
+			b.Height = int(float64(imageInfo.width) / ar)
+		} else {
+			b.Height = imageInfo.height
+			b.Width = int(float64(imageInfo.height) * ar)
+		}
+		// log.Printf("FILL %v w=%v h=%v iw=%v ih=%v\n", ar, b.Width, b.Height, imageInfo.width, imageInfo.height)
+		opts = append(opts, b)
+	}
+
+	if t.preserveAspectRatio && t.width != 0 && t.height != 0 {
+		verticalRatio := imageInfo.width / t.width
+		horizontalRatio := imageInfo.height / t.height
+		if verticalRatio < horizontalRatio {
+			t.width = 0
+		} else {
+			t.height = 0
+		}
+	}
+
+	b := bimg.Options{
+		Width:         t.width,
+		Height:        t.height,
+		Top:           t.top,
+		Left:          t.left,
+		AreaHeight:    t.areaHeight,
+		AreaWidth:     t.areaWidth,
+		Enlarge:       t.enlarge,
+		Crop:          t.crop,
+		Embed:         t.embed,
+		Interlace:     t.interlace,
+		Quality:       t.quality,
+		Compression:   t.compression,
+		Speed:         t.speed,
+		StripMetadata: t.stripMetadata,
+		GaussianBlur: bimg.GaussianBlur{
+			Sigma:   t.blur.sigma,
+			MinAmpl: t.blur.minAmpl,
+		},
+		Rotate: t.rotate,
+	}
+
+	if t.sharpen.sigma != 0 {
+		// libvips sharpens only lightness, amount 1 is libvips default slope and threshold is scaled to L* range
+		b.Sharpen = bimg.Sharpen{
+			Radius: int(math.Max(1, math.Round(t.sharpen.sigma))),
+			X1:     t.sharpen.threshold * 100,
+			Y2:     10,
+			Y3:     20,
+			M1:     0,
+			M2:     t.sharpen.amount * 3,
+		}
+	}
+
+	if t.gravity != 0 {
+		b.Gravity = t.gravity
+	}
+
+	if t.FormatStr != "" {
+		b.Type = t.format
+	}
+
+	if t.interpretation != 0 {
+		b.Interpretation = t.interpretation
+	}
+
+	if t.SelectiveStrip() {
+		// libvips can only strip everything, selective stripping of jpeg and png is done by engine after encoding
+		format := t.FormatStr
+		if format == "" {
+			format = imageInfo.format
+		}
+
+		switch strings.ToLower(format) {
+		case "jpeg", "jpg", "png":
+			b.StripMetadata = false
+		}
+	}
+
+	if t.autoQuality != 0 {
+		b.Quality = AutoQualityReference
+	}
+
+	switch t.colorProfile {
+	case ColorProfileSRGB:
+		if t.interpretation == 0 {
+			b.Interpretation = interpretationSRGB
+		}
+		b.OutputICC = "srgb"
+	case ColorProfileKeep:
+		// libvips strip removes ICC profile too, so for jpeg and png it is kept by selective strip
+		b.NoProfile = false
+	}
+
+	if t.watermark.image != "" {
+		// fetch image
+		buf, err := t.watermark.fetchImage()
+		if err != nil {
+			return opts, err
+		}
+
+		// calculate correct image dimensions
+		width := imageInfo.width
+		height := imageInfo.height
+
+		if t.width != 0 && t.height != 0 {
+			width = t.width
+			height = t.height
+		} else if t.width != 0 {
+			width = t.width
+			height = t.width * height / imageInfo.width
+		} else if t.height != 0 {
+			height = t.height
+			width = t.height * width / imageInfo.height
+		}
+
+		top, left := t.watermark.calculatePostion(width, height)
+
+		b.WatermarkImage = bimg.WatermarkImage{
+			Left:    left,
+			Top:     top,
+			Buf:     buf,
+			Opacity: t.watermark.opacity,
+		}
+	}
+
+	opts = append(opts, b)
+
+	if t.autoCropHeight != 0 || t.autoCropWidth != 0 {
+		bAutoCrop := bimg.Options{}
+		bAutoCrop.Left, bAutoCrop.Top, bAutoCrop.AreaWidth, bAutoCrop.AreaHeight = t.calculateAutoCrop(imageInfo)
+		if t.width != 0 || t.height != 0 {
+			if t.width > t.areaWidth || t.height > t.areaHeight {
+				opts = append(opts, bAutoCrop, bimg.Options{Width: t.autoCropWidth, Height: t.autoCropHeight, Crop: true, Gravity: gravityCentre})
+			} else {
+
+				opts = append([]bimg.Options{bAutoCrop, bimg.Options{Width: t.autoCropWidth, Height: t.autoCropHeight, Crop: true, Gravity: gravityCentre}}, opts...)
+			}
+
+		} else {
+			opts = append(opts, bAutoCrop, bimg.Options{Width: t.autoCropWidth, Height: t.autoCropHeight, Crop: true, Gravity: gravityCentre})
+		}
+	}
+
+	if t.HasEffects() || t.NeedsEncoder(imageInfo) {
+		// effects and encoder options are applied by engine to lossless image, it is encoded to requested format with EncodeOptions
+		for i := range opts {
+			opts[i].Type = formatPNG
+		}
+	}
+
+	return opts, nil
+}
+
+// EncodeOptions return options for bimg lib which only encode image according to transform
+// They are used when image is processed by engine after libvips operations
+func (t *Transforms) EncodeOptions(imageInfo ImageInfo) bimg.Options {
+	b := bimg.Options{
+		Quality:     t.quality,
+		Interlace:   t.interlace,
+		Compression: t.compression,
+		Speed:       t.speed,
+		Type:        t.format,
+	}
+
+	if t.FormatStr == "" {
+		b.Type, _ = imageFormat(strings.ToLower(imageInfo.format))
+	}
+
+	if t.autoQuality != 0 {
+		b.Quality = AutoQualityReference
+	}
+
+	return b
+}
@@ -0,0 +1,164 @@
+package transforms
+
+import (
+	"bytes"
+	"encoding/gob"
+	"image"
+	"image/color"
+	"image/png"
+)
+
+// transformsState exported copy of Transforms used for encoding it, e.g. for sending it to worker process
+type transformsState struct {
+	Height              int
+	Width               int
+	AreaHeight          int
+	AreaWidth           int
+	Quality             int
+	Compression         int
+	Zoom                int
+	Top                 int
+	Left                int
+	Crop                bool
+	Enlarge             bool
+	Embed               bool
+	Fill                bool
+	Flip                bool
+	Flop                bool
+	Force               bool
+	NoAutoRotate        bool
+	NoProfile           bool
+	Interlace           bool
+	StripMetadata       bool
+	Trim                bool
+	PreserveAspectRatio bool
+	Rotate              Angle
+	Interpretation      Interpretation
+	Gravity             Gravity
+	GravityName         string
+	GravityOffset       *image.Point
+	BlurSigma           float64
+	BlurMinAmpl         float64
+	Sharpen             [4]float64 // radius, sigma, amount, threshold
+	Format              ImageType
+	FormatStr           string
+
+	WatermarkImage   string
+	WatermarkOpacity float32
+	WatermarkXPos    string
+	WatermarkYPos    string
+
+	NotEmpty bool
+	NoMerge  bool
+
+	AutoCropWidth  int
+	AutoCropHeight int
+
+	Page               int
+	ColorProfile       string
+	AutoQuality        float64
+	MaxBytes           int
+	StripMode          string
+	KeepICC            bool
+	Speed              int
+	ChromaSubsampling  string
+	Effort             int
+	WithoutEnlargement bool
+	EnlargementSet     bool
+	TrimTolerance      int
+	TrimBackground     *color.RGBA
+
+	Duotone        *DuotoneParams
+	Overlay        *OverlayParams
+	Composite      *CompositeParams // without image, which is encoded as png in CompositeImage
+	CompositeImage []byte
+	Pixelate       *PixelateParams
+
+	Version   int
+	TransHash uint64
+}
+
+// GobEncode encode transforms with all its parameters
+// Loaded composite image is encoded as png
+func (t Transforms) GobEncode() ([]byte, error) {
+	s := transformsState{
+		Height: t.height, Width: t.width, AreaHeight: t.areaHeight, AreaWidth: t.areaWidth,
+		Quality: t.quality, Compression: t.compression, Zoom: t.zoom, Top: t.top, Left: t.left,
+		Crop: t.crop, Enlarge: t.enlarge, Embed: t.embed, Fill: t.fill, Flip: t.flip, Flop: t.flop, Force: t.force,
+		NoAutoRotate: t.noAutoRotate, NoProfile: t.noProfile, Interlace: t.interlace, StripMetadata: t.stripMetadata,
+		Trim: t.trim, PreserveAspectRatio: t.preserveAspectRatio,
+		Rotate: t.rotate, Interpretation: t.interpretation, Gravity: t.gravity, GravityName: t.gravityName, GravityOffset: t.gravityOffset,
+		BlurSigma: t.blur.sigma, BlurMinAmpl: t.blur.minAmpl,
+		Sharpen: [4]float64{t.sharpen.radius, t.sharpen.sigma, t.sharpen.amount, t.sharpen.threshold},
+		Format:  t.format, FormatStr: t.FormatStr,
+		WatermarkImage: t.watermark.image, WatermarkOpacity: t.watermark.opacity, WatermarkXPos: t.watermark.xPos, WatermarkYPos: t.watermark.yPos,
+		NotEmpty: t.NotEmpty, NoMerge: t.NoMerge,
+		AutoCropWidth: t.autoCropWidth, AutoCropHeight: t.autoCropHeight,
+		Page: t.page, ColorProfile: t.colorProfile, AutoQuality: t.autoQuality, MaxBytes: t.maxBytes,
+		StripMode: t.stripMode, KeepICC: t.keepICC, Speed: t.speed,
+		ChromaSubsampling: t.chromaSubsampling, Effort: t.effort,
+		WithoutEnlargement: t.withoutEnlargement, EnlargementSet: t.enlargementSet,
+		TrimTolerance: t.trimTolerance, TrimBackground: t.trimBackground,
+		Duotone: t.duotone, Overlay: t.overlay, Pixelate: t.pixelate,
+		Version:   t.version,
+		TransHash: t.transHash.value(),
+	}
+
+	if t.composite != nil {
+		c := *t.composite
+		if c.Image != nil {
+			buf := &bytes.Buffer{}
+			if err := png.Encode(buf, c.Image); err != nil {
+				return nil, err
+			}
+			s.CompositeImage = buf.Bytes()
+		}
+		c.Image = nil
+		s.Composite = &c
+	}
+
+	buf := &bytes.Buffer{}
+	err := gob.NewEncoder(buf).Encode(s)
+	return buf.Bytes(), err
+}
+
+// GobDecode decode transforms encoded by GobEncode
+func (t *Transforms) GobDecode(data []byte) error {
+	s := transformsState{}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&s); err != nil {
+		return err
+	}
+
+	*t = Transforms{
+		height: s.Height, width: s.Width, areaHeight: s.AreaHeight, areaWidth: s.AreaWidth,
+		quality: s.Quality, compression: s.Compression, zoom: s.Zoom, top: s.Top, left: s.Left,
+		crop: s.Crop, enlarge: s.Enlarge, embed: s.Embed, fill: s.Fill, flip: s.Flip, flop: s.Flop, force: s.Force,
+		noAutoRotate: s.NoAutoRotate, noProfile: s.NoProfile, interlace: s.Interlace, stripMetadata: s.StripMetadata,
+		trim: s.Trim, preserveAspectRatio: s.PreserveAspectRatio,
+		rotate: s.Rotate, interpretation: s.Interpretation, gravity: s.Gravity, gravityName: s.GravityName, gravityOffset: s.GravityOffset,
+		blur:    blur{sigma: s.BlurSigma, minAmpl: s.BlurMinAmpl},
+		sharpen: sharpen{radius: s.Sharpen[0], sigma: s.Sharpen[1], amount: s.Sharpen[2], threshold: s.Sharpen[3]},
+		format:  s.Format, FormatStr: s.FormatStr,
+		watermark: watermark{image: s.WatermarkImage, opacity: s.WatermarkOpacity, xPos: s.WatermarkXPos, yPos: s.WatermarkYPos},
+		NotEmpty:  s.NotEmpty, NoMerge: s.NoMerge,
+		autoCropWidth: s.AutoCropWidth, autoCropHeight: s.AutoCropHeight,
+		page: s.Page, colorProfile: s.ColorProfile, autoQuality: s.AutoQuality, maxBytes: s.MaxBytes,
+		stripMode: s.StripMode, keepICC: s.KeepICC, speed: s.Speed,
+		chromaSubsampling: s.ChromaSubsampling, effort: s.Effort,
+		withoutEnlargement: s.WithoutEnlargement, enlargementSet: s.EnlargementSet,
+		trimTolerance: s.TrimTolerance, trimBackground: s.TrimBackground,
+		duotone: s.Duotone, overlay: s.Overlay, composite: s.Composite, pixelate: s.Pixelate,
+		version:   s.Version,
+		transHash: fnvI64(s.TransHash),
+	}
+
+	if s.Composite != nil && len(s.CompositeImage) != 0 {
+		img, err := png.Decode(bytes.NewReader(s.CompositeImage))
+		if err != nil {
+			return err
+		}
+		t.composite.Image = img
+	}
+
+	return nil
+}
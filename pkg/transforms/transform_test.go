@@ -1,8 +1,15 @@
+//go:build cgo
+// +build cgo
+
 package transforms
 
 import (
+	"bytes"
+	"encoding/gob"
 	"github.com/stretchr/testify/assert"
 	"gopkg.in/h2non/bimg.v1"
+	"image"
+	"image/color"
 	"strconv"
 	"testing"
 )
@@ -66,6 +73,23 @@ func TestTransformsResize(t *testing.T) {
 	assert.NotEqual(t, hashStr, hashStr2)
 }
 
+func TestTransformsVersion(t *testing.T) {
+	trans := Transforms{}
+	trans.Resize(5, 100, true, false, false)
+	trans.SetVersion(0)
+
+	hashStr := strconv.FormatUint(uint64(trans.Hash().Sum64()), 16)
+	assert.Equal(t, "3c9adb04ba75bd9c", hashStr, "version 0 shouldn't change hash")
+
+	trans.SetVersion(1)
+	hashStr1 := strconv.FormatUint(uint64(trans.Hash().Sum64()), 16)
+	assert.NotEqual(t, hashStr, hashStr1)
+
+	trans.SetVersion(2)
+	hashStr2 := strconv.FormatUint(uint64(trans.Hash().Sum64()), 16)
+	assert.NotEqual(t, hashStr1, hashStr2)
+}
+
 func TestTransformsCrop(t *testing.T) {
 	trans := Transforms{}
 	trans.Crop(11, 12, "smart", false, false)
@@ -145,6 +169,35 @@ func TestTransformsStripMetadata(t *testing.T) {
 	assert.Equal(t, "34ff3721dee2880c", hashStr)
 }
 
+func TestTransformsStripMetadataMode(t *testing.T) {
+	trans := Transforms{}
+	err := trans.StripMetadataMode(StripGPS, false)
+	assert.Nil(t, err)
+	assert.True(t, trans.NotEmpty)
+	assert.True(t, trans.SelectiveStrip())
+
+	optsArr, err := trans.BimgOptions(ImageInfo{format: "jpeg"})
+	assert.Nil(t, err)
+	assert.False(t, optsArr[0].StripMetadata)
+	assert.Equal(t, StripGPS, trans.Params().StripMode)
+
+	optsArr, err = trans.BimgOptions(ImageInfo{format: "webp"})
+	assert.Nil(t, err)
+	assert.True(t, optsArr[0].StripMetadata)
+
+	all := Transforms{}
+	all.StripMetadataMode(StripAll, false)
+	assert.False(t, all.SelectiveStrip())
+	assert.NotEqual(t, all.Hash().Sum64(), trans.Hash().Sum64())
+
+	keepICC := Transforms{}
+	keepICC.StripMetadataMode(StripAll, true)
+	assert.True(t, keepICC.SelectiveStrip())
+	assert.True(t, keepICC.Params().KeepICC)
+
+	assert.NotNil(t, trans.StripMetadataMode("location", false))
+}
+
 func TestTransformsFormat(t *testing.T) {
 	trans := Transforms{}
 	trans.Format("jpeg")
@@ -180,12 +233,113 @@ func TestTransformsFormat(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, trans.FormatStr, "pdf")
 
+	err = trans.Format("heic")
+
+	assert.Nil(t, err)
+	assert.Equal(t, trans.format, bimg.HEIF)
+
+	err = trans.Format("avif")
+
+	assert.Nil(t, err)
+	assert.Equal(t, trans.format, bimg.AVIF)
+
 	err = trans.Format("pdfaa")
 
 	assert.NotNil(t, err)
 
 }
 
+func TestTransformsColorProfile(t *testing.T) {
+	trans := Transforms{}
+	err := trans.ColorProfile("srgb")
+
+	assert.Nil(t, err)
+	assert.True(t, trans.NotEmpty)
+
+	optsArr, err := trans.BimgOptions(ImageInfo{})
+	assert.Nil(t, err)
+	assert.Equal(t, bimg.InterpretationSRGB, optsArr[0].Interpretation)
+	assert.Equal(t, "srgb", optsArr[0].OutputICC)
+
+	trans2 := Transforms{}
+	trans2.StripMetadata()
+	trans2.ColorProfile("keep")
+
+	optsArr, err = trans2.BimgOptions(ImageInfo{format: "jpeg"})
+	assert.Nil(t, err)
+	assert.False(t, optsArr[0].StripMetadata, "jpeg should be stripped selectively to keep ICC profile")
+	assert.True(t, trans2.SelectiveStrip())
+	assert.Equal(t, StripAll, trans2.Params().StripMode)
+	assert.True(t, trans2.Params().KeepICC)
+	assert.NotEqual(t, trans.Hash().Sum64(), trans2.Hash().Sum64())
+
+	optsArr, err = trans2.BimgOptions(ImageInfo{format: "webp"})
+	assert.Nil(t, err)
+	assert.True(t, optsArr[0].StripMetadata, "EXIF should be stripped even when ICC profile can't be kept")
+
+	assert.NotNil(t, trans.ColorProfile("cmyk"))
+}
+
+func TestTransformsAutoQuality(t *testing.T) {
+	trans := Transforms{}
+	err := trans.AutoQuality(0)
+
+	assert.Nil(t, err)
+	assert.Equal(t, DefaultQualityTarget, trans.Params().AutoQuality)
+
+	optsArr, err := trans.BimgOptions(ImageInfo{})
+	assert.Nil(t, err)
+	assert.Equal(t, AutoQualityReference, optsArr[0].Quality)
+
+	assert.NotNil(t, trans.AutoQuality(1.5))
+}
+
+func TestTransformsMaxBytes(t *testing.T) {
+	trans := Transforms{}
+
+	assert.NotNil(t, trans.MaxBytes(0))
+	assert.Nil(t, trans.MaxBytes(1024))
+	assert.True(t, trans.NotEmpty)
+	assert.Equal(t, 1024, trans.Params().MaxBytes)
+	assert.Equal(t, []string{"maxBytes"}, trans.Operations())
+}
+
+func TestTransformsEncoderDefaults(t *testing.T) {
+	trans := Transforms{}
+	trans.Compression(9)
+	trans.EncoderDefaults(true, 6, 4, "", 0)
+
+	optsArr, err := trans.BimgOptions(ImageInfo{})
+	assert.Nil(t, err)
+	assert.True(t, optsArr[0].Interlace)
+	assert.Equal(t, 9, optsArr[0].Compression)
+	assert.Equal(t, 4, optsArr[0].Speed)
+
+	trans2 := Transforms{}
+	trans2.Compression(9)
+	assert.NotEqual(t, trans.Hash().Sum64(), trans2.Hash().Sum64())
+	assert.NotNil(t, trans2.Compression(10))
+}
+
+func TestTransformsEncoderOptions(t *testing.T) {
+	trans := Transforms{}
+	trans.Effort(5)
+	trans.EncoderDefaults(false, 0, 0, ChromaSubsampling444, 2)
+
+	assert.Equal(t, ChromaSubsampling444, trans.Params().Chroma)
+	assert.Equal(t, 5, trans.Params().Effort)
+	assert.True(t, trans.NeedsEncoder(ImageInfo{format: "jpeg"}))
+	assert.False(t, trans.NeedsEncoder(ImageInfo{format: "png"}))
+
+	optsArr, err := trans.BimgOptions(ImageInfo{format: "jpeg"})
+	assert.Nil(t, err)
+	assert.Equal(t, bimg.PNG, optsArr[0].Type, "image should be encoded by engine")
+	assert.Equal(t, bimg.JPEG, trans.EncodeOptions(ImageInfo{format: "jpeg"}).Type)
+
+	assert.NotNil(t, trans.ChromaSubsampling("4:2:2"))
+	assert.NotNil(t, trans.Effort(7))
+}
+
 func TestTransformsGrayscale(t *testing.T) {
 	trans := Transforms{}
 	trans.Grayscale()
@@ -380,3 +534,286 @@ func TestTransforms_ResizeCropAuto(t *testing.T) {
 	hashStr := strconv.FormatUint(uint64(trans.Hash().Sum64()), 16)
 	assert.Equal(t, "a9476be4baa3fb94", hashStr)
 }
+
+func TestTransformsLimitWidth(t *testing.T) {
+	trans := Transforms{}
+	trans.Resize(1000, 500, false, true, false)
+	hash := trans.Hash().Sum64()
+
+	assert.Nil(t, trans.LimitWidth(400))
+
+	p := trans.Params()
+	assert.Equal(t, 400, p.Width)
+	assert.Equal(t, 200, p.Height)
+	assert.NotEqual(t, hash, trans.Hash().Sum64())
+
+	small := Transforms{}
+	small.Resize(300, 0, false, true, false)
+	assert.Nil(t, small.LimitWidth(400))
+	assert.Equal(t, 300, small.Params().Width)
+}
+
+func TestTransformsSkipEnlargement(t *testing.T) {
+	trans := Transforms{}
+	trans.Resize(1000, 0, false, true, false)
+	assert.False(t, trans.SkipEnlargement(800, 600))
+
+	trans.EnlargementDefault(true)
+	assert.False(t, trans.SkipEnlargement(1200, 900))
+	assert.True(t, trans.SkipEnlargement(800, 600))
+	assert.Equal(t, 0, trans.Params().Width)
+	assert.Equal(t, 0, len(trans.Operations()))
+
+	crop := Transforms{}
+	crop.Crop(500, 500, "smart", false, false)
+	crop.Quality(80)
+	crop.WithoutEnlargement(true)
+	assert.False(t, crop.SkipEnlargement(800, 400))
+	assert.True(t, crop.SkipEnlargement(400, 400))
+	assert.Equal(t, []string{"quality"}, crop.Operations())
+
+	explicit := Transforms{}
+	explicit.Resize(1000, 0, false, true, false)
+	explicit.WithoutEnlargement(false)
+	explicit.EnlargementDefault(true)
+	assert.False(t, explicit.SkipEnlargement(800, 600))
+}
+
+func TestTransformsCropGravity(t *testing.T) {
+	trans := Transforms{}
+	trans.Crop(100, 100, "centre", false, false)
+	assert.Equal(t, "", trans.Params().Gravity)
+
+	attention := Transforms{}
+	attention.Crop(100, 100, "attention", false, false)
+	smart := Transforms{}
+	smart.Crop(100, 100, "smart", false, false)
+	assert.Equal(t, "smart", attention.Params().Gravity)
+	assert.Equal(t, smart.Hash().Sum64(), attention.Hash().Sum64())
+
+	northeast := Transforms{}
+	northeast.Crop(100, 50, "northeast", false, false)
+	assert.Equal(t, "northeast", northeast.Params().Gravity)
+	assert.NotEqual(t, trans.Hash().Sum64(), northeast.Hash().Sum64())
+
+	area, ok := northeast.GravityArea(ImageInfo{width: 400, height: 400})
+	assert.True(t, ok)
+	assert.Equal(t, image.Rect(0, 0, 400, 200), area)
+
+	southwest := Transforms{}
+	southwest.Crop(100, 100, "southwest", false, false)
+	area, _ = southwest.GravityArea(ImageInfo{width: 400, height: 200})
+	assert.Equal(t, image.Rect(0, 0, 200, 200), area)
+
+	southeast := Transforms{}
+	southeast.Crop(50, 100, "southeast", false, false)
+	area, _ = southeast.GravityArea(ImageInfo{width: 400, height: 400})
+	assert.Equal(t, image.Rect(200, 0, 400, 400), area)
+
+	optsArr, err := southeast.BimgOptions(ImageInfo{width: 400, height: 400})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(optsArr))
+	assert.Equal(t, 200, optsArr[0].Left)
+	assert.Equal(t, 200, optsArr[0].AreaWidth)
+
+	_, ok = smart.GravityArea(ImageInfo{width: 400, height: 400})
+	assert.False(t, ok)
+}
+
+func TestTransformsEntropyGravity(t *testing.T) {
+	trans := Transforms{}
+	trans.Crop(100, 100, "entropy", false, false)
+	assert.True(t, trans.NeedsGravityOffset())
+
+	trans.SetGravityOffset(500, 0)
+	assert.False(t, trans.NeedsGravityOffset())
+
+	area, ok := trans.GravityArea(ImageInfo{width: 400, height: 200})
+	assert.True(t, ok)
+	assert.Equal(t, image.Rect(200, 0, 400, 200), area)
+}
+
+func TestTransformsTrim(t *testing.T) {
+	trans := Transforms{}
+	assert.Nil(t, trans.Trim(10, ""))
+	assert.True(t, trans.NotEmpty)
+	assert.Equal(t, map[string]interface{}{"tolerance": 10}, trans.Describe()["trim"])
+
+	withBg := Transforms{}
+	assert.Nil(t, withBg.Trim(10, "#fff"))
+	assert.Equal(t, map[string]interface{}{"tolerance": 10, "background": "#ffffff"}, withBg.Describe()["trim"])
+	assert.NotEqual(t, trans.Hash().Sum64(), withBg.Hash().Sum64())
+
+	p := withBg.Params()
+	assert.True(t, p.Trim)
+	assert.Equal(t, color.RGBA{R: 255, G: 255, B: 255, A: 255}, *p.TrimBackground)
+
+	merged := Transforms{}
+	merged.Resize(100, 0, false, false, false)
+	assert.Nil(t, merged.Merge(withBg))
+	assert.True(t, merged.Params().Trim)
+	assert.Equal(t, 100, merged.Params().Width)
+
+	assert.NotNil(t, trans.Trim(256, ""))
+	assert.NotNil(t, trans.Trim(10, "#ffff"))
+	assert.NotNil(t, trans.Trim(10, "white"))
+}
+
+func TestParseColor(t *testing.T) {
+	c, err := parseColor("#10203080")
+	assert.Nil(t, err)
+	assert.Equal(t, color.RGBA{R: 0x10, G: 0x20, B: 0x30, A: 0x80}, c)
+	assert.Equal(t, "#10203080", formatColor(c))
+
+	c, err = parseColor("0a0")
+	assert.Nil(t, err)
+	assert.Equal(t, "#00aa00", formatColor(c))
+}
+
+func TestTransformsSharpen(t *testing.T) {
+	trans := Transforms{}
+	assert.Nil(t, trans.Sharpen(0, 1.5, 2, 0.05))
+	assert.True(t, trans.NotEmpty)
+
+	optsArr, err := trans.BimgOptions(ImageInfo{width: 400, height: 400})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, optsArr[0].Sharpen.Radius)
+	assert.Equal(t, 6., optsArr[0].Sharpen.M2)
+	assert.Equal(t, 5., optsArr[0].Sharpen.X1)
+
+	assert.Equal(t, &SharpenParams{Sigma: 1.5, Amount: 2, Threshold: 0.05}, trans.Params().Sharpen)
+	assert.Equal(t, []string{"sharpen"}, trans.Operations())
+
+	other := Transforms{}
+	other.Sharpen(0, 1, 1, 0)
+	assert.NotEqual(t, trans.Hash().Sum64(), other.Hash().Sum64())
+
+	merged := Transforms{}
+	merged.Resize(100, 0, false, false, false)
+	assert.Nil(t, merged.Merge(other))
+	assert.Equal(t, 1., merged.Params().Sharpen.Sigma)
+
+	assert.NotNil(t, trans.Sharpen(0, 0, 1, 0))
+	assert.NotNil(t, trans.Sharpen(0, 1, 0, 0))
+	assert.NotNil(t, trans.Sharpen(0, 1, 1, 2))
+}
+
+func TestTransformsEffects(t *testing.T) {
+	trans := Transforms{}
+	trans.Resize(100, 0, false, false, false)
+	assert.Nil(t, trans.Duotone("#000", "#ff8800"))
+	assert.Nil(t, trans.Overlay("#ffffff", 0.3, ""))
+	assert.True(t, trans.HasEffects())
+
+	d := trans.Describe()
+	assert.Equal(t, map[string]interface{}{"shadow": "#000000", "highlight": "#ff8800"}, d["duotone"])
+	assert.Equal(t, map[string]interface{}{"color": "#ffffff", "opacity": 0.3, "mode": BlendNormal}, d["overlay"])
+
+	optsArr, err := trans.BimgOptions(ImageInfo{width: 400, height: 400, format: "jpeg"})
+	assert.Nil(t, err)
+	assert.Equal(t, bimg.PNG, optsArr[0].Type)
+	assert.Equal(t, bimg.JPEG, trans.EncodeOptions(ImageInfo{format: "jpeg"}).Type)
+
+	trans.Format("webp")
+	trans.Quality(70)
+	enc := trans.EncodeOptions(ImageInfo{format: "jpeg"})
+	assert.Equal(t, bimg.WEBP, enc.Type)
+	assert.Equal(t, 70, enc.Quality)
+
+	multiply := Transforms{}
+	multiply.Overlay("#ffffff", 0.3, BlendMultiply)
+	other := Transforms{}
+	other.Overlay("#ffffff", 0.3, BlendScreen)
+	assert.NotEqual(t, multiply.Hash().Sum64(), other.Hash().Sum64())
+
+	assert.NotNil(t, trans.Duotone("#000", ""))
+	assert.NotNil(t, trans.Overlay("#fff", 0, ""))
+	assert.NotNil(t, trans.Overlay("#fff", 0.5, "dodge"))
+
+	empty := Transforms{}
+	assert.False(t, empty.HasEffects())
+}
+
+func TestTransformsComposite(t *testing.T) {
+	trans := Transforms{}
+	assert.Nil(t, trans.Composite("badges/new.png", "top-right", 50, 0.8, BlendScreen))
+	assert.True(t, trans.HasEffects())
+	assert.Equal(t, "/badges/new.png", trans.CompositeKey())
+	assert.Equal(t, map[string]interface{}{"image": "/badges/new.png", "position": "top-right", "width": 50, "opacity": 0.8,
+		"mode": BlendScreen}, trans.Describe()["composite"])
+
+	other := Transforms{}
+	other.Composite("/badges/sale.png", "top-right", 50, 0.8, BlendScreen)
+	assert.NotEqual(t, trans.Hash().Sum64(), other.Hash().Sum64())
+
+	merged := Transforms{}
+	merged.Resize(100, 0, false, false, false)
+	assert.Nil(t, merged.Merge(trans))
+	merged.SetCompositeImage(image.NewGray(image.Rect(0, 0, 1, 1)))
+	assert.NotNil(t, merged.Params().Composite.Image)
+	assert.Nil(t, trans.Params().Composite.Image, "image shouldn't be set on merged transform")
+
+	c := CompositeParams{Position: "bottom-center"}
+	left, top := c.Offset(100, 80, 20, 10)
+	assert.Equal(t, 40, left)
+	assert.Equal(t, 70, top)
+
+	c.Position = "5, 7"
+	left, top = c.Offset(100, 80, 20, 10)
+	assert.Equal(t, 5, left)
+	assert.Equal(t, 7, top)
+
+	empty := Transforms{}
+	assert.Equal(t, "", empty.CompositeKey())
+	assert.NotNil(t, trans.Composite("", "top-right", 0, 1, ""))
+	assert.NotNil(t, trans.Composite("a.png", "middle", 0, 1, ""))
+	assert.NotNil(t, trans.Composite("a.png", "1,x", 0, 1, ""))
+	assert.NotNil(t, trans.Composite("a.png", "top-left", -1, 1, ""))
+	assert.NotNil(t, trans.Composite("a.png", "top-left", 0, 1.5, ""))
+	assert.NotNil(t, trans.Composite("../../etc/passwd", "top-left", 0, 1, ""), "composite image can't be outside of bucket")
+	assert.NotNil(t, trans.Composite("badges/../../a.png", "top-left", 0, 1, ""))
+
+	cleaned := Transforms{}
+	assert.Nil(t, cleaned.Composite("badges//./new.png", "top-left", 0, 1, ""))
+	assert.Equal(t, "/badges/new.png", cleaned.CompositeKey())
+}
+
+func TestTransformsPixelate(t *testing.T) {
+	trans := Transforms{}
+	assert.Nil(t, trans.Pixelate(10, []string{"1,2,30,40", PixelateFaces}))
+	assert.Equal(t, map[string]interface{}{"blockSize": 10, "regions": []string{"1,2,30,40", PixelateFaces}}, trans.Describe()["pixelate"])
+
+	p := trans.Params().Pixelate
+	assert.Equal(t, []image.Rectangle{image.Rect(1, 2, 31, 42)}, p.Regions)
+	assert.True(t, p.Faces)
+
+	whole := Transforms{}
+	whole.Pixelate(10, nil)
+	assert.NotEqual(t, trans.Hash().Sum64(), whole.Hash().Sum64())
+	assert.False(t, whole.Params().Pixelate.Faces)
+
+	assert.NotNil(t, trans.Pixelate(1, nil))
+	assert.NotNil(t, trans.Pixelate(10, []string{"1,2,3"}))
+	assert.NotNil(t, trans.Pixelate(10, []string{"1,2,0,4"}))
+	assert.NotNil(t, trans.Pixelate(10, []string{"-1,2,3,4"}))
+}
+
+func TestTransformsGobEncoding(t *testing.T) {
+	trans := Transforms{}
+	trans.Crop(100, 50, "smart", false, false)
+	trans.Quality(80)
+	trans.Blur(2, 0)
+	trans.Trim(10, "ffffff")
+	assert.Nil(t, trans.Composite("badges/new.png", "top-right", 50, 0.8, BlendScreen))
+	trans.SetCompositeImage(image.NewGray(image.Rect(0, 0, 2, 2)))
+
+	buf := &bytes.Buffer{}
+	assert.Nil(t, gob.NewEncoder(buf).Encode([]Transforms{trans}))
+
+	var decoded []Transforms
+	assert.Nil(t, gob.NewDecoder(buf).Decode(&decoded))
+	assert.Len(t, decoded, 1)
+	assert.Equal(t, trans.Hash().Sum64(), decoded[0].Hash().Sum64())
+	assert.Equal(t, trans.Describe(), decoded[0].Describe())
+	assert.Equal(t, image.Rect(0, 0, 2, 2), decoded[0].Params().Composite.Image.Bounds())
+}
@@ -4,13 +4,18 @@ import (
 	"encoding/binary"
 	"errors"
 	"hash"
+	"hash/fnv"
+	"image"
+	"image/color"
+	"path"
+	"sort"
+	"strconv"
 	"strings"
 
 	"math"
 
 	"github.com/aldor007/mort/pkg/helpers"
 	"github.com/spaolacci/murmur3"
-	"gopkg.in/h2non/bimg.v1"
 )
 
 var watermarkPosX = map[string]float32{
@@ -25,20 +30,59 @@ var watermarkPosY = map[string]float32{
 	"bottom": 2. / 3.,
 }
 
-var cropGravity = map[string]bimg.Gravity{
-	"center": bimg.GravityCentre,
-	"north":  bimg.GravityNorth,
-	"west":   bimg.GravityWest,
-	"east":   bimg.GravityEast,
-	"south":  bimg.GravitySouth,
-	"smart":  bimg.GravitySmart,
+// compositeAlignX and compositeAlignY map position of composite to number of halves of free space before image
+var compositeAlignX = map[string]int{
+	"left":   0,
+	"center": 1,
+	"right":  2,
+}
+
+var compositeAlignY = map[string]int{
+	"top":    0,
+	"center": 1,
+	"bottom": 2,
 }
 
+var cropGravity = map[string]Gravity{
+	"center": gravityCentre,
+	"north":  gravityNorth,
+	"west":   gravityWest,
+	"east":   gravityEast,
+	"south":  gravitySouth,
+	"smart":  gravitySmart,
+}
+
+// gravityAliases maps alternative names of gravity to names used by mort
+var gravityAliases = map[string]string{
+	"centre":    "center",
+	"attention": "smart", // libvips smart crop looks for features which draw human attention
+}
+
+// areaGravity are gravities which libvips can't apply during crop, area with aspect ratio of crop is extracted before resize
+// value is used in hash of transform
+var areaGravity = map[string]uint64{
+	"northeast": 1,
+	"northwest": 2,
+	"southeast": 3,
+	"southwest": 4,
+	"entropy":   5,
+}
+
+// GravityEntropy name of gravity choosing area with the highest entropy (the most details)
+const GravityEntropy = "entropy"
+
 type blur struct {
 	sigma   float64
 	minAmpl float64
 }
 
+type sharpen struct {
+	radius    float64
+	sigma     float64
+	amount    float64
+	threshold float64
+}
+
 type watermark struct {
 	image   string
 	opacity float32
@@ -46,11 +90,11 @@ type watermark struct {
 	yPos    string
 }
 
-var angleMap = map[int]bimg.Angle{
-	0: bimg.D0,
-	1: bimg.D90,
-	2: bimg.D180,
-	3: bimg.D270,
+var angleMap = map[int]Angle{
+	0: angle0,
+	1: angle90,
+	2: angle180,
+	3: angle270,
 }
 var prime64 = 1099511628211
 
@@ -64,6 +108,72 @@ func (w watermark) calculatePostion(width, height int) (top int, left int) {
 	return
 }
 
+// DefaultQualityTarget default SSIM score required by auto quality
+const DefaultQualityTarget = 0.985
+
+// AutoQualityReference quality used for encoding reference image when auto quality is enabled
+const AutoQualityReference = 95
+
+const (
+	// ColorProfileSRGB convert image to sRGB color space
+	ColorProfileSRGB = "srgb"
+	// ColorProfileKeep preserve ICC profile of source image
+	ColorProfileKeep = "keep"
+)
+
+const (
+	// StripAll remove all metadata from image
+	StripAll = "all"
+	// StripGPS remove only GPS location from EXIF
+	StripGPS = "gps"
+	// StripKeepCopyright remove all metadata except copyright, artist and orientation
+	StripKeepCopyright = "keepCopyright"
+)
+
+const (
+	// ChromaSubsampling444 encode JPEG without chroma subsampling
+	ChromaSubsampling444 = "4:4:4"
+	// ChromaSubsampling420 encode JPEG with chroma subsampling even for high quality
+	ChromaSubsampling420 = "4:2:0"
+)
+
+// DefaultTrimTolerance is tolerance of trim used when it isn't given
+const DefaultTrimTolerance = 10
+
+// PixelateFaces is region of pixelate which is replaced by faces found by face detector
+const PixelateFaces = "faces"
+
+// DefaultPixelateBlockSize is size of pixelate block in px used when it isn't given
+const DefaultPixelateBlockSize = 16
+
+// DefaultSharpenSigma and DefaultSharpenAmount are used when sharpen params aren't given
+const (
+	DefaultSharpenSigma  = 1.0
+	DefaultSharpenAmount = 1.0
+)
+
+// Blend modes of color overlay
+const (
+	BlendNormal    = "normal"
+	BlendMultiply  = "multiply"
+	BlendScreen    = "screen"
+	BlendOverlay   = "overlay"
+	BlendSoftLight = "softLight"
+	BlendDarken    = "darken"
+	BlendLighten   = "lighten"
+)
+
+// blendModes maps blend mode to its identifier used in hash
+var blendModes = map[string]uint64{
+	BlendNormal:    1,
+	BlendMultiply:  2,
+	BlendScreen:    3,
+	BlendOverlay:   4,
+	BlendSoftLight: 5,
+	BlendDarken:    6,
+	BlendLighten:   7,
+}
+
 // ImageInfo holds information about image
 type ImageInfo struct {
 	width       int    // width of image in px
@@ -72,11 +182,6 @@ type ImageInfo struct {
 	orientation int
 }
 
-// NewImageInfo create new ImageInfo object from bimg metadata
-func NewImageInfo(metadata bimg.ImageMetadata, format string) ImageInfo {
-	return ImageInfo{width: metadata.Size.Width, height: metadata.Size.Height, format: format, orientation: metadata.Orientation}
-}
-
 // Transforms struct hold information about what operations should be performed on image
 type Transforms struct {
 	height              int
@@ -101,11 +206,14 @@ type Transforms struct {
 	stripMetadata       bool
 	trim                bool
 	preserveAspectRatio bool
-	rotate              bimg.Angle
-	interpretation      bimg.Interpretation
-	gravity             bimg.Gravity
+	rotate              Angle
+	interpretation      Interpretation
+	gravity             Gravity
+	gravityName         string
+	gravityOffset       *image.Point // top left corner of area chosen by engine for entropy gravity
 	blur                blur
-	format              bimg.ImageType
+	sharpen             sharpen
+	format              ImageType
 	FormatStr           string
 
 	watermark watermark
@@ -116,6 +224,34 @@ type Transforms struct {
 	autoCropWidth  int
 	autoCropHeight int
 
+	page int // page of multi page document (pdf) counted from 1
+
+	colorProfile string // "srgb" convert to sRGB, "keep" preserve source ICC profile
+
+	autoQuality float64 // target similarity (SSIM) used for choosing quality, 0 when disabled
+
+	maxBytes int // max size of result in bytes, 0 when not limited
+
+	stripMode string // one of Strip* modes, empty means StripAll
+	keepICC   bool   // keep ICC profile when stripping metadata
+
+	speed int // encoder speed for heif/avif, 0 means libvips default
+
+	chromaSubsampling string // chroma subsampling of jpeg (4:4:4 or 4:2:0), empty means libvips default
+	effort            int    // encoder effort of webp, 0 means libvips default
+
+	withoutEnlargement bool // resize larger than source is skipped
+	enlargementSet     bool // withoutEnlargement was set explicitly, bucket default is not applied
+
+	trimTolerance  int         // max difference of channel from background which is still treated as border
+	trimBackground *color.RGBA // color of border, nil when it is detected from top left pixel
+
+	duotone   *DuotoneParams   // shadows and highlights colors, nil when not set
+	overlay   *OverlayParams   // flat color blended with image, nil when not set
+	composite *CompositeParams // image from bucket placed over result, nil when not set
+	pixelate  *PixelateParams  // regions of source which are pixelated, nil when not set
+
+	version   int // version of transforms of bucket, part of hash when it isn't 0
 	transHash fnvI64
 }
 
@@ -148,6 +284,105 @@ func (t *Transforms) Resize(width, height int, enlarge bool, preserveAspectRatio
 	return nil
 }
 
+// LimitWidth reduce width of resize to maxWidth, height is scaled proportionally
+// Transforms without resize or with smaller width aren't changed
+func (t *Transforms) LimitWidth(maxWidth int) error {
+	if maxWidth <= 0 || t.width <= maxWidth {
+		return nil
+	}
+
+	height := t.height
+	if height != 0 {
+		height = height * maxWidth / t.width
+	}
+
+	return t.Resize(maxWidth, height, t.enlarge, t.preserveAspectRatio, t.fill)
+}
+
+// gravityLabel returns name of crop gravity, empty for default (center) gravity
+func (t *Transforms) gravityLabel() string {
+	if t.gravityName != "" && t.gravityName != "center" {
+		return t.gravityName
+	}
+
+	for name, g := range cropGravity {
+		if t.gravity != 0 && g == t.gravity {
+			return name
+		}
+	}
+
+	return ""
+}
+
+// NeedsGravityOffset check if engine has to choose area of crop (entropy gravity)
+func (t *Transforms) NeedsGravityOffset() bool {
+	return t.crop && t.gravityName == GravityEntropy && t.gravityOffset == nil
+}
+
+// SetGravityOffset set top left corner of area extracted from source image before resize
+func (t *Transforms) SetGravityOffset(left, top int) {
+	t.gravityOffset = &image.Point{X: left, Y: top}
+}
+
+// GravityArea returns area of source image with aspect ratio of crop which is kept for gravities that libvips can't apply
+// ok is false when crop doesn't use such gravity
+func (t *Transforms) GravityArea(imageInfo ImageInfo) (image.Rectangle, bool) {
+	if _, ok := areaGravity[t.gravityName]; !ok || !t.crop || t.width == 0 || t.height == 0 || imageInfo.width == 0 || imageInfo.height == 0 {
+		return image.Rectangle{}, false
+	}
+
+	return gravityArea(t.gravityName, t.gravityOffset, imageInfo.width, imageInfo.height, t.width, t.height), true
+}
+
+// gravityArea returns the largest area of width x height image with aspect ratio of cropWidth x cropHeight placed according to gravity
+func gravityArea(gravity string, offset *image.Point, width, height, cropWidth, cropHeight int) image.Rectangle {
+	areaWidth, areaHeight := width, height
+	if width*cropHeight > height*cropWidth {
+		areaWidth = height * cropWidth / cropHeight
+	} else {
+		areaHeight = width * cropHeight / cropWidth
+	}
+
+	if areaWidth < 1 {
+		areaWidth = 1
+	}
+	if areaHeight < 1 {
+		areaHeight = 1
+	}
+
+	left, top := (width-areaWidth)/2, (height-areaHeight)/2
+	if strings.HasPrefix(gravity, "north") {
+		top = 0
+	}
+	if strings.HasPrefix(gravity, "south") {
+		top = height - areaHeight
+	}
+	if strings.HasSuffix(gravity, "west") {
+		left = 0
+	}
+	if strings.HasSuffix(gravity, "east") {
+		left = width - areaWidth
+	}
+
+	if offset != nil {
+		left, top = offset.X, offset.Y
+		if left > width-areaWidth {
+			left = width - areaWidth
+		}
+		if top > height-areaHeight {
+			top = height - areaHeight
+		}
+		if left < 0 {
+			left = 0
+		}
+		if top < 0 {
+			top = 0
+		}
+	}
+
+	return image.Rect(left, top, left+areaWidth, top+areaHeight)
+}
+
 // Extract area from image with given properties
 func (t *Transforms) Extract(top, left, width, height int) error {
 	t.top = top
@@ -172,13 +407,26 @@ func (t *Transforms) Crop(width, height int, gravity string, enlarge, embed bool
 	t.crop = true
 	t.embed = embed
 	t.NotEmpty = true
+	if alias, ok := gravityAliases[gravity]; ok {
+		gravity = alias
+	}
+
+	t.gravityOffset = nil
 	if g, ok := cropGravity[gravity]; ok {
 		t.gravity = g
+		t.gravityName = gravity
+	} else if _, ok := areaGravity[gravity]; ok {
+		t.gravity = gravityCentre
+		t.gravityName = gravity
 	} else {
-		t.gravity = bimg.GravitySmart
+		t.gravity = gravitySmart
+		t.gravityName = "smart"
 	}
 
 	t.transHash.write(1212, uint64(t.width)*5, uint64(t.height), uint64(t.gravity))
+	if g, ok := areaGravity[t.gravityName]; ok {
+		t.transHash.write(1213, g)
+	}
 	if t.embed {
 		t.transHash.write(3333)
 	}
@@ -217,6 +465,151 @@ func (t *Transforms) Quality(quality int) error {
 	return nil
 }
 
+// AutoQuality enable choosing the lowest quality for which result is similar to reference in given degree
+// target is minimal SSIM score (0-1), 0 means default target of engine
+func (t *Transforms) AutoQuality(target float64) error {
+	if target < 0 || target >= 1 {
+		return errors.New("invalid quality target")
+	}
+
+	t.autoQuality = target
+	if target == 0 {
+		t.autoQuality = DefaultQualityTarget
+	}
+
+	t.NotEmpty = true
+	t.transHash.write(1402, uint64(t.autoQuality*10000))
+	return nil
+}
+
+// MaxBytes limit size of result, image is re-encoded with lower quality until it fits in limit
+func (t *Transforms) MaxBytes(maxBytes int) error {
+	if maxBytes <= 0 {
+		return errors.New("invalid max bytes")
+	}
+
+	t.maxBytes = maxBytes
+	t.NotEmpty = true
+	t.transHash.write(1403, uint64(maxBytes))
+	return nil
+}
+
+// Compression set zlib compression level of PNG
+func (t *Transforms) Compression(level int) error {
+	if level < 0 || level > 9 {
+		return errors.New("invalid compression level")
+	}
+
+	t.compression = level
+	t.NotEmpty = true
+	t.transHash.write(1501, uint64(level))
+	return nil
+}
+
+// Speed set encoder speed of HEIF/AVIF
+func (t *Transforms) Speed(speed int) error {
+	if speed < 0 || speed > 9 {
+		return errors.New("invalid speed")
+	}
+
+	t.speed = speed
+	t.NotEmpty = true
+	t.transHash.write(1502, uint64(speed))
+	return nil
+}
+
+// ChromaSubsampling set chroma subsampling of JPEG, "4:4:4" disables subsampling and "4:2:0" forces it
+func (t *Transforms) ChromaSubsampling(mode string) error {
+	switch mode {
+	case ChromaSubsampling444:
+		t.transHash.write(1503, 444)
+	case ChromaSubsampling420:
+		t.transHash.write(1503, 420)
+	default:
+		return errors.New("invalid chroma subsampling")
+	}
+
+	t.chromaSubsampling = mode
+	t.NotEmpty = true
+	return nil
+}
+
+// Effort set encoder effort of WebP, higher is slower and gives smaller files
+func (t *Transforms) Effort(effort int) error {
+	if effort < 0 || effort > 6 {
+		return errors.New("invalid effort")
+	}
+
+	t.effort = effort
+	t.NotEmpty = true
+	t.transHash.write(1504, uint64(effort))
+	return nil
+}
+
+// EncoderDefaults set encoder options that are not set by transform itself
+// progressive enable progressive JPEG / interlaced PNG, compression is zlib level of PNG and speed is heif/avif encoder speed
+// chromaSubsampling is subsampling of JPEG and effort is WebP encoder effort
+func (t *Transforms) EncoderDefaults(progressive bool, compression, speed int, chromaSubsampling string, effort int) {
+	if progressive && !t.interlace {
+		t.interlace = true
+		t.transHash.write(1311, 71)
+	}
+
+	if compression != 0 && t.compression == 0 {
+		t.Compression(compression)
+	}
+
+	if speed != 0 && t.speed == 0 {
+		t.Speed(speed)
+	}
+
+	if chromaSubsampling != "" && t.chromaSubsampling == "" {
+		t.ChromaSubsampling(chromaSubsampling)
+	}
+
+	if effort != 0 && t.effort == 0 {
+		t.Effort(effort)
+	}
+}
+
+// WithoutEnlargement disable upscaling of images, when source is smaller than requested size it is used in its size
+func (t *Transforms) WithoutEnlargement(enabled bool) {
+	t.withoutEnlargement = enabled
+	t.enlargementSet = true
+	if enabled {
+		t.transHash.write(1601)
+	}
+}
+
+// EnlargementDefault set withoutEnlargement if transform doesn't set it itself
+func (t *Transforms) EnlargementDefault(withoutEnlargement bool) {
+	if withoutEnlargement && !t.enlargementSet {
+		t.WithoutEnlargement(true)
+	}
+}
+
+// SkipEnlargement removes resize or crop when enlargement is disabled and requested size isn't smaller than source
+// It returns true when resize was removed
+func (t *Transforms) SkipEnlargement(sourceWidth, sourceHeight int) bool {
+	if !t.withoutEnlargement || (t.width == 0 && t.height == 0) || sourceWidth == 0 || sourceHeight == 0 {
+		return false
+	}
+
+	if (t.width != 0 && t.width < sourceWidth) || (t.height != 0 && t.height < sourceHeight) {
+		return false
+	}
+
+	t.width = 0
+	t.height = 0
+	t.crop = false
+	t.gravity = 0
+	t.gravityName = ""
+	t.embed = false
+	t.enlarge = false
+	t.fill = false
+	return true
+}
+
 // StripMetadata remove EXIF from image
 func (t *Transforms) StripMetadata() error {
 	t.stripMetadata = true
@@ -225,6 +618,41 @@ func (t *Transforms) StripMetadata() error {
 	return nil
 }
 
+// StripMetadataMode remove metadata from image selectively
+// mode is one of StripAll, StripGPS, StripKeepCopyright and keepICC preserves ICC profile
+func (t *Transforms) StripMetadataMode(mode string, keepICC bool) error {
+	switch mode {
+	case StripAll:
+		t.transHash.write(1999, 1)
+	case StripGPS:
+		t.transHash.write(1999, 2)
+	case StripKeepCopyright:
+		t.transHash.write(1999, 3)
+	default:
+		return errors.New("unknown strip mode " + mode)
+	}
+
+	if keepICC {
+		t.transHash.write(1999, 4)
+	}
+
+	t.stripMetadata = true
+	t.stripMode = mode
+	t.keepICC = keepICC
+	t.NotEmpty = true
+	return nil
+}
+
+// SelectiveStrip inform if metadata is stripped selectively by image engine instead of libvips
+func (t *Transforms) SelectiveStrip() bool {
+	return t.stripMetadata && (t.keepsICC() || (t.stripMode != "" && t.stripMode != StripAll))
+}
+
+// keepsICC inform if ICC profile is kept when metadata is stripped, colorProfile keep implies it
+func (t *Transforms) keepsICC() bool {
+	return t.keepICC || t.colorProfile == ColorProfileKeep
+}
+
 // Blur blur whole image
 func (t *Transforms) Blur(sigma, minAmpl float64) error {
 	t.NotEmpty = true
@@ -234,15 +662,225 @@ func (t *Transforms) Blur(sigma, minAmpl float64) error {
 	return nil
 }
 
+// Trim remove uniform border of image, pixels which differ from background by no more than tolerance (0-255) are trimmed
+// When background is empty color of top left pixel is used
+func (t *Transforms) Trim(tolerance int, background string) error {
+	if tolerance < 0 || tolerance > 255 {
+		return errors.New("invalid trim tolerance")
+	}
+
+	var bg *color.RGBA
+	if background != "" {
+		c, err := parseColor(background)
+		if err != nil {
+			return err
+		}
+		bg = &c
+		t.transHash.write(61019, uint64(tolerance), 1, uint64(c.R), uint64(c.G), uint64(c.B), uint64(c.A))
+	} else {
+		t.transHash.write(61019, uint64(tolerance))
+	}
+
+	t.trim = true
+	t.trimTolerance = tolerance
+	t.trimBackground = bg
+	t.NotEmpty = true
+	return nil
+}
+
+// Sharpen sharpen image using unsharp mask
+// sigma is standard deviation of gaussian, radius of mask in px (0 means derived from sigma), amount is strength of sharpening
+// and threshold (0-1) is minimal difference from blurred image which is sharpened
+func (t *Transforms) Sharpen(radius, sigma, amount, threshold float64) error {
+	if sigma <= 0 || radius < 0 || amount <= 0 || threshold < 0 || threshold > 1 {
+		return errors.New("invalid sharpen params")
+	}
+
+	t.NotEmpty = true
+	t.sharpen = sharpen{radius: radius, sigma: sigma, amount: amount, threshold: threshold}
+	t.transHash.write(27457, uint64(radius*1000), uint64(sigma*1000), uint64(amount*1000), uint64(threshold*1000))
+	return nil
+}
+
+// Duotone map luminance of image to gradient between shadow and highlight colors
+func (t *Transforms) Duotone(shadow, highlight string) error {
+	s, err := parseColor(shadow)
+	if err != nil {
+		return err
+	}
+
+	h, err := parseColor(highlight)
+	if err != nil {
+		return err
+	}
+
+	t.NotEmpty = true
+	t.duotone = &DuotoneParams{Shadow: s, Highlight: h}
+	t.transHash.write(73019, uint64(s.R), uint64(s.G), uint64(s.B), uint64(s.A), uint64(h.R), uint64(h.G), uint64(h.B), uint64(h.A))
+	return nil
+}
+
+// Overlay blend flat color with image using given blend mode, opacity is in range (0, 1]
+// When mode is empty normal mode is used
+func (t *Transforms) Overlay(overlayColor string, opacity float64, mode string) error {
+	c, err := parseColor(overlayColor)
+	if err != nil {
+		return err
+	}
+
+	if opacity <= 0 || opacity > 1 {
+		return errors.New("invalid overlay opacity")
+	}
+
+	if mode == "" {
+		mode = BlendNormal
+	}
+
+	modeID, ok := blendModes[mode]
+	if !ok {
+		return errors.New("unknown blend mode " + mode)
+	}
+
+	t.NotEmpty = true
+	t.overlay = &OverlayParams{Color: c, Opacity: opacity, Mode: mode}
+	t.transHash.write(73031, uint64(c.R), uint64(c.G), uint64(c.B), uint64(c.A), uint64(opacity*1000), modeID)
+	return nil
+}
+
+// Composite place other image from the same bucket over result
+// position is one of watermark positions (e.g. "bottom-right") or offset in px in form "left,top",
+// width is width of placed image in px (0 keeps its size), opacity is in range (0, 1] and mode is one of Blend* modes
+func (t *Transforms) Composite(imageKey, position string, width int, opacity float64, mode string) error {
+	if imageKey == "" || position == "" {
+		return errors.New("missing required params image or position")
+	}
+
+	if _, _, err := compositeOffset(position, 0, 0, 0, 0); err != nil {
+		return err
+	}
+
+	if width < 0 {
+		return errors.New("invalid composite width")
+	}
+
+	if opacity <= 0 || opacity > 1 {
+		return errors.New("invalid composite opacity")
+	}
+
+	if mode == "" {
+		mode = BlendNormal
+	}
+
+	modeID, ok := blendModes[mode]
+	if !ok {
+		return errors.New("unknown blend mode " + mode)
+	}
+
+	// key is used in storage of bucket, so it can't point outside of bucket
+	if strings.Contains("/"+imageKey+"/", "/../") {
+		return errors.New("invalid composite image")
+	}
+	imageKey = path.Clean("/" + imageKey)
+
+	t.NotEmpty = true
+	t.composite = &CompositeParams{Key: imageKey, Position: position, Width: width, Opacity: opacity, Mode: mode}
+	t.transHash.write(73043, stringHash(imageKey), stringHash(position), uint64(width), uint64(opacity*1000), modeID)
+	return nil
+}
+
+// CompositeKey returns key of image which should be placed over result or empty string
+func (t *Transforms) CompositeKey() string {
+	if t.composite == nil {
+		return ""
+	}
+
+	return t.composite.Key
+}
+
+// SetCompositeImage set decoded image which is placed over result, it is loaded from storage before processing
+func (t *Transforms) SetCompositeImage(img image.Image) {
+	if t.composite != nil {
+		c := *t.composite
+		c.Image = img
+		t.composite = &c
+	}
+}
+
+// Pixelate replace blocks of blockSize px in regions of source image with their average color
+// Region is given as "x,y,width,height" in px of source image or PixelateFaces, whole image is pixelated when no regions are given
+func (t *Transforms) Pixelate(blockSize int, regions []string) error {
+	if blockSize < 2 {
+		return errors.New("invalid pixelate block size")
+	}
+
+	p := &PixelateParams{BlockSize: blockSize}
+	hashData := []uint64{83017, uint64(blockSize)}
+	for _, region := range regions {
+		region = strings.TrimSpace(region)
+		if region == PixelateFaces {
+			p.Faces = true
+			hashData = append(hashData, 1)
+			continue
+		}
+
+		r, err := parseRegion(region)
+		if err != nil {
+			return err
+		}
+
+		p.Regions = append(p.Regions, r)
+		hashData = append(hashData, uint64(r.Min.X), uint64(r.Min.Y), uint64(r.Dx()), uint64(r.Dy()))
+	}
+
+	t.NotEmpty = true
+	t.pixelate = p
+	t.transHash.write(hashData...)
+	return nil
+}
+
+// HasEffects inform if transform has color effects which are not supported by libvips and are applied by engine
+func (t *Transforms) HasEffects() bool {
+	return t.duotone != nil || t.overlay != nil || t.composite != nil
+}
+
+// NeedsEncoder check if result has to be encoded with options which libvips binding doesn't support
+// Chroma subsampling is used only for JPEG and effort only for WebP results
+func (t *Transforms) NeedsEncoder(imageInfo ImageInfo) bool {
+	format := t.FormatStr
+	if format == "" {
+		format = imageInfo.format
+	}
+
+	switch strings.ToLower(format) {
+	case "jpeg", "jpg":
+		return t.chromaSubsampling != ""
+	case "webp":
+		return t.effort != 0
+	}
+
+	return false
+}
+
 // Hash return unique transform identifier
+// Each operation contributes to hash only when it is set, with its identifier and parameters, so new operations
+// don't change hash of existing transforms. Hash of version 0 is the same as hash of transforms without version
 func (t *Transforms) Hash() hash.Hash64 {
 	hashValue := murmur3.New64WithSeed(20171108)
 	transHashB := make([]byte, 8)
 	binary.LittleEndian.PutUint64(transHashB, t.transHash.value())
 	hashValue.Write(transHashB)
+	if t.version != 0 {
+		binary.LittleEndian.PutUint64(transHashB, uint64(t.version))
+		hashValue.Write(transHashB)
+	}
 	return hashValue
 }
 
+// SetVersion set version of transforms, changing version changes hash, so transformed images are generated again
+func (t *Transforms) SetVersion(version int) {
+	t.version = version
+}
+
 // Format change image format
 func (t *Transforms) Format(format string) error {
 	t.NotEmpty = true
@@ -287,7 +925,7 @@ func (t *Transforms) Watermark(image string, position string, opacity float32) e
 
 // Grayscale convert image to B&W
 func (t *Transforms) Grayscale() {
-	t.interpretation = bimg.InterpretationBW
+	t.interpretation = interpretationBW
 	t.transHash.write(32309)
 	t.NotEmpty = true
 }
@@ -305,6 +943,34 @@ func (t *Transforms) Rotate(angle int) error {
 	return errors.New("wrong angle")
 }
 
+// ColorProfile set handling of ICC profile, "srgb" converts image to sRGB and "keep" preserves source profile
+func (t *Transforms) ColorProfile(mode string) error {
+	switch mode {
+	case ColorProfileSRGB:
+		t.transHash.write(52711, 1)
+	case ColorProfileKeep:
+		t.transHash.write(52711, 2)
+	default:
+		return errors.New("unknown color profile " + mode)
+	}
+
+	t.colorProfile = mode
+	t.NotEmpty = true
+	return nil
+}
+
+// Page select page of multi page document (pdf) which should be rendered, pages are counted from 1
+func (t *Transforms) Page(page int) error {
+	if page < 1 {
+		return errors.New("invalid page")
+	}
+
+	t.page = page
+	t.NotEmpty = true
+	t.transHash.write(41077, uint64(page))
+	return nil
+}
+
 // Merge append transformation from other object
 func (t *Transforms) Merge(other Transforms) error {
 	if other.NoMerge == true || t.NoMerge == true {
@@ -352,8 +1018,10 @@ func (t *Transforms) Merge(other Transforms) error {
 	t.top = other.top
 	t.left = other.left
 
-	if other.gravity != 0 {
+	if other.gravity != 0 || other.gravityName != "" {
 		t.gravity = other.gravity
+		t.gravityName = other.gravityName
+		t.gravityOffset = other.gravityOffset
 	}
 
 	if other.blur.minAmpl != 0 {
@@ -364,6 +1032,26 @@ func (t *Transforms) Merge(other Transforms) error {
 		t.blur.sigma = t.blur.sigma + other.blur.sigma
 	}
 
+	if other.sharpen.sigma != 0 {
+		t.sharpen = other.sharpen
+	}
+
+	if other.duotone != nil {
+		t.duotone = other.duotone
+	}
+
+	if other.overlay != nil {
+		t.overlay = other.overlay
+	}
+
+	if other.composite != nil {
+		t.composite = other.composite
+	}
+
+	if other.pixelate != nil {
+		t.pixelate = other.pixelate
+	}
+
 	if other.interlace {
 		t.interlace = other.interlace
 	}
@@ -372,6 +1060,30 @@ func (t *Transforms) Merge(other Transforms) error {
 		t.quality = other.quality
 	}
 
+	if other.autoQuality != 0 {
+		t.autoQuality = other.autoQuality
+	}
+
+	if other.maxBytes != 0 {
+		t.maxBytes = other.maxBytes
+	}
+
+	if other.compression != 0 {
+		t.compression = other.compression
+	}
+
+	if other.speed != 0 {
+		t.speed = other.speed
+	}
+
+	if other.chromaSubsampling != "" {
+		t.chromaSubsampling = other.chromaSubsampling
+	}
+
+	if other.effort != 0 {
+		t.effort = other.effort
+	}
+
 	if other.format != 0 {
 		t.format = other.format
 		t.FormatStr = other.FormatStr
@@ -379,6 +1091,27 @@ func (t *Transforms) Merge(other Transforms) error {
 
 	if other.stripMetadata {
 		t.stripMetadata = other.stripMetadata
+		t.stripMode = other.stripMode
+		t.keepICC = other.keepICC
+	}
+
+	if other.page != 0 {
+		t.page = other.page
+	}
+
+	if other.colorProfile != "" {
+		t.colorProfile = other.colorProfile
+	}
+
+	if other.trim {
+		t.trim = other.trim
+		t.trimTolerance = other.trimTolerance
+		t.trimBackground = other.trimBackground
+	}
+
+	if other.enlargementSet {
+		t.withoutEnlargement = other.withoutEnlargement
+		t.enlargementSet = true
 	}
 
 	t.transHash.write(other.transHash.value())
@@ -418,23 +1151,123 @@ func Merge(transformsTab []Transforms) []Transforms {
 	return result
 }
 
-func imageFormat(format string) (bimg.ImageType, error) {
+func imageFormat(format string) (ImageType, error) {
 	switch format {
 	case "jpeg", "jpg":
-		return bimg.JPEG, nil
+		return formatJPEG, nil
 	case "webp":
-		return bimg.WEBP, nil
+		return formatWEBP, nil
 	case "png":
-		return bimg.PNG, nil
+		return formatPNG, nil
 	case "gif":
-		return bimg.GIF, nil
+		return formatGIF, nil
 	case "svg":
-		return bimg.SVG, nil
+		return formatSVG, nil
 	case "pdf":
-		return bimg.PDF, nil
+		return formatPDF, nil
+	case "heif", "heic":
+		return formatHEIF, nil
+	case "avif":
+		return formatAVIF, nil
 	default:
-		return bimg.UNKNOWN, errors.New("Unknown format " + format)
+		return formatUnknown, errors.New("Unknown format " + format)
+	}
+}
+
+// compositeOffset returns top left corner of image of size placedWidth x placedHeight placed on image of size width x height
+// Named positions align image to edges or center, "left,top" gives offset in px
+func compositeOffset(position string, width, height, placedWidth, placedHeight int) (int, int, error) {
+	if i := strings.Index(position, ","); i != -1 {
+		left, errLeft := strconv.Atoi(strings.TrimSpace(position[:i]))
+		top, errTop := strconv.Atoi(strings.TrimSpace(position[i+1:]))
+		if errLeft != nil || errTop != nil {
+			return 0, 0, errors.New("invalid position given")
+		}
+
+		return left, top, nil
 	}
+
+	p := strings.Split(position, "-")
+	if len(p) != 2 {
+		return 0, 0, errors.New("invalid position given")
+	}
+
+	y, okY := compositeAlignY[p[0]]
+	x, okX := compositeAlignX[p[1]]
+	if !okY || !okX {
+		return 0, 0, errors.New("invalid position given")
+	}
+
+	return (width - placedWidth) * x / 2, (height - placedHeight) * y / 2, nil
+}
+
+// parseRegion parse region in form "x,y,width,height"
+func parseRegion(region string) (image.Rectangle, error) {
+	parts := strings.Split(region, ",")
+	if len(parts) != 4 {
+		return image.Rectangle{}, errors.New("invalid region " + region)
+	}
+
+	var v [4]int
+	for i, part := range parts {
+		n, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || n < 0 {
+			return image.Rectangle{}, errors.New("invalid region " + region)
+		}
+		v[i] = n
+	}
+
+	if v[2] == 0 || v[3] == 0 {
+		return image.Rectangle{}, errors.New("invalid region " + region)
+	}
+
+	return image.Rect(v[0], v[1], v[0]+v[2], v[1]+v[3]), nil
+}
+
+// formatRegion returns region in form "x,y,width,height"
+func formatRegion(r image.Rectangle) string {
+	return strconv.Itoa(r.Min.X) + "," + strconv.Itoa(r.Min.Y) + "," + strconv.Itoa(r.Dx()) + "," + strconv.Itoa(r.Dy())
+}
+
+// stringHash returns FNV hash of string
+func stringHash(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	return h.Sum64()
+}
+
+// parseColor parse hex color in form #rgb, #rrggbb or #rrggbbaa, leading # is optional
+func parseColor(value string) (color.RGBA, error) {
+	hex := strings.TrimPrefix(value, "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+
+	if len(hex) == 6 {
+		hex += "ff"
+	}
+
+	if len(hex) != 8 {
+		return color.RGBA{}, errors.New("invalid color " + value)
+	}
+
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return color.RGBA{}, errors.New("invalid color " + value)
+	}
+
+	return color.RGBA{R: uint8(v >> 24), G: uint8(v >> 16), B: uint8(v >> 8), A: uint8(v)}, nil
+}
+
+// formatColor return color in form #rrggbb, alpha is appended only when color is not opaque
+func formatColor(c color.RGBA) string {
+	v := uint64(c.R)<<16 | uint64(c.G)<<8 | uint64(c.B)
+	s := strconv.FormatUint(v|1<<24, 16)[1:]
+	if c.A != 0xff {
+		s += strconv.FormatUint(uint64(c.A)|1<<8, 16)[1:]
+	}
+
+	return "#" + s
 }
 
 func (t *Transforms) calculateAutoCrop(info ImageInfo) (int, int, int, int) {
@@ -464,118 +1297,289 @@ func (t *Transforms) calculateAutoCrop(info ImageInfo) (int, int, int, int) {
 	return int(cropX), int(cropY), int(cropWidth), int(cropHeight)
 }
 
-// BimgOptions return complete options for bimg lib
-func (t *Transforms) BimgOptions(imageInfo ImageInfo) ([]bimg.Options, error) {
-	var opts []bimg.Options
-	if t.fill && t.width > 0 && t.height > 0 {
-		ar := float64(t.width) / float64(t.height)
-		b := bimg.Options{
-			Crop: true,
-		}
-		if ar > 1 {
-			b.Width = imageInfo.width
-			b.Height = int(float64(imageInfo.width) / ar)
-		} else {
-			b.Height = imageInfo.height
-			b.Width = int(float64(imageInfo.height) * ar)
-		}
-		// log.Printf("FILL %v w=%v h=%v iw=%v ih=%v\n", ar, b.Width, b.Height, imageInfo.width, imageInfo.height)
-		opts = append(opts, b)
+// Describe returns description of operations that will be performed on image
+// It is used only for debug purpose
+func (t *Transforms) Describe() map[string]interface{} {
+	d := make(map[string]interface{})
+	if t.crop {
+		d["crop"] = map[string]interface{}{"width": t.width, "height": t.height, "enlarge": t.enlarge, "embed": t.embed}
+	} else if t.width != 0 || t.height != 0 {
+		d["resize"] = map[string]interface{}{"width": t.width, "height": t.height, "enlarge": t.enlarge,
+			"preserveAspectRatio": t.preserveAspectRatio, "fill": t.fill}
+	}
+
+	if t.areaWidth != 0 || t.areaHeight != 0 {
+		d["extract"] = map[string]interface{}{"width": t.areaWidth, "height": t.areaHeight, "top": t.top, "left": t.left}
 	}
 
-	if t.preserveAspectRatio && t.width != 0 && t.height != 0 {
-		verticalRatio := imageInfo.width / t.width
-		horizontalRatio := imageInfo.height / t.height
-		if verticalRatio < horizontalRatio {
-			t.width = 0
-		} else {
-			t.height = 0
+	if t.trim {
+		trim := map[string]interface{}{"tolerance": t.trimTolerance}
+		if t.trimBackground != nil {
+			trim["background"] = formatColor(*t.trimBackground)
 		}
+		d["trim"] = trim
+	}
+
+	if t.autoCropWidth != 0 || t.autoCropHeight != 0 {
+		d["resizeCropAuto"] = map[string]interface{}{"width": t.autoCropWidth, "height": t.autoCropHeight}
+	}
+
+	if name := t.gravityLabel(); name != "" {
+		d["gravity"] = name
+	}
+
+	if t.quality != 0 {
+		d["quality"] = t.quality
+	}
+
+	if t.autoQuality != 0 {
+		d["autoQuality"] = t.autoQuality
+	}
+
+	if t.maxBytes != 0 {
+		d["maxBytes"] = t.maxBytes
+	}
+
+	if t.compression != 0 {
+		d["compression"] = t.compression
 	}
 
-	b := bimg.Options{
-		Width:         t.width,
-		Height:        t.height,
-		Top:           t.top,
-		Left:          t.left,
-		AreaHeight:    t.areaHeight,
-		AreaWidth:     t.areaWidth,
-		Enlarge:       t.enlarge,
-		Crop:          t.crop,
-		Embed:         t.embed,
-		Interlace:     t.interlace,
-		Quality:       t.quality,
-		StripMetadata: t.stripMetadata,
-		GaussianBlur: bimg.GaussianBlur{
-			Sigma:   t.blur.sigma,
-			MinAmpl: t.blur.minAmpl,
-		},
-		Rotate: t.rotate,
+	if t.speed != 0 {
+		d["speed"] = t.speed
 	}
 
-	if t.gravity != 0 {
-		b.Gravity = t.gravity
+	if t.chromaSubsampling != "" {
+		d["chromaSubsampling"] = t.chromaSubsampling
+	}
+
+	if t.effort != 0 {
+		d["effort"] = t.effort
 	}
 
 	if t.FormatStr != "" {
-		b.Type = t.format
+		d["format"] = t.FormatStr
 	}
 
-	if t.interpretation != 0 {
-		b.Interpretation = t.interpretation
+	if t.interlace {
+		d["interlace"] = true
 	}
 
-	if t.watermark.image != "" {
-		// fetch image
-		buf, err := t.watermark.fetchImage()
-		if err != nil {
-			return opts, err
-		}
+	if t.SelectiveStrip() {
+		d["strip"] = map[string]interface{}{"mode": t.stripMode, "keepICC": t.keepICC}
+	} else if t.stripMetadata {
+		d["strip"] = true
+	}
+
+	if t.blur.sigma != 0 || t.blur.minAmpl != 0 {
+		d["blur"] = map[string]interface{}{"sigma": t.blur.sigma, "minAmpl": t.blur.minAmpl}
+	}
+
+	if t.sharpen.sigma != 0 {
+		d["sharpen"] = map[string]interface{}{"radius": t.sharpen.radius, "sigma": t.sharpen.sigma, "amount": t.sharpen.amount,
+			"threshold": t.sharpen.threshold}
+	}
+
+	if t.duotone != nil {
+		d["duotone"] = map[string]interface{}{"shadow": formatColor(t.duotone.Shadow), "highlight": formatColor(t.duotone.Highlight)}
+	}
+
+	if t.overlay != nil {
+		d["overlay"] = map[string]interface{}{"color": formatColor(t.overlay.Color), "opacity": t.overlay.Opacity, "mode": t.overlay.Mode}
+	}
 
-		// calculate correct image dimensions
-		width := imageInfo.width
-		height := imageInfo.height
-
-		if t.width != 0 && t.height != 0 {
-			width = t.width
-			height = t.height
-		} else if t.width != 0 {
-			width = t.width
-			height = t.width * height / imageInfo.width
-		} else if t.height != 0 {
-			height = t.height
-			width = t.height * width / imageInfo.height
+	if p := t.pixelate; p != nil {
+		regions := make([]string, 0, len(p.Regions)+1)
+		for _, r := range p.Regions {
+			regions = append(regions, formatRegion(r))
+		}
+		if p.Faces {
+			regions = append(regions, PixelateFaces)
 		}
+		d["pixelate"] = map[string]interface{}{"blockSize": p.BlockSize, "regions": regions}
+	}
 
-		top, left := t.watermark.calculatePostion(width, height)
+	if c := t.composite; c != nil {
+		d["composite"] = map[string]interface{}{"image": c.Key, "position": c.Position, "width": c.Width, "opacity": c.Opacity, "mode": c.Mode}
+	}
+
+	if t.watermark.image != "" {
+		d["watermark"] = map[string]interface{}{"image": t.watermark.image, "position": t.watermark.yPos + "-" + t.watermark.xPos,
+			"opacity": t.watermark.opacity}
+	}
+
+	if t.interpretation == interpretationBW {
+		d["grayscale"] = true
+	}
+
+	if t.rotate != 0 {
+		d["rotate"] = int(t.rotate)
+	}
+
+	if t.page != 0 {
+		d["page"] = t.page
+	}
 
-		b.WatermarkImage = bimg.WatermarkImage{
-			Left:    left,
-			Top:     top,
-			Buf:     buf,
-			Opacity: t.watermark.opacity,
+	if t.colorProfile != "" {
+		d["colorProfile"] = t.colorProfile
+	}
+
+	d["hash"] = strconv.FormatUint(t.Hash().Sum64(), 16)
+	return d
+}
+
+// Operations returns sorted list of names of operations performed by transform
+// Names are the same as keys returned by Describe
+func (t *Transforms) Operations() []string {
+	d := t.Describe()
+	ops := make([]string, 0, len(d))
+	for name := range d {
+		if name != "hash" {
+			ops = append(ops, name)
 		}
 	}
 
-	opts = append(opts, b)
+	sort.Strings(ops)
+	return ops
+}
 
-	if t.autoCropHeight != 0 || t.autoCropWidth != 0 {
-		bAutoCrop := bimg.Options{}
-		bAutoCrop.Left, bAutoCrop.Top, bAutoCrop.AreaWidth, bAutoCrop.AreaHeight = t.calculateAutoCrop(imageInfo)
-		if t.width != 0 || t.height != 0 {
-			if t.width > t.areaWidth || t.height > t.areaHeight {
-				opts = append(opts, bAutoCrop, bimg.Options{Width: t.autoCropWidth, Height: t.autoCropHeight, Crop: true, Gravity: bimg.GravityCentre})
-			} else {
+// Params holds transform parameters in engine independent form
+// It is used by image engines that don't use libvips
+type Params struct {
+	Width       int
+	Height      int
+	Crop        bool
+	Enlarge     bool
+	Gravity     string
+	Extract     *image.Rectangle // area which should be extracted from image
+	Quality     int
+	Format      string
+	Blur        float64
+	Grayscale   bool
+	Rotate      int // angle in degrees
+	Strip       bool
+	StripMode   string // selective strip mode, empty when everything is stripped
+	KeepICC     bool   // keep ICC profile when stripping metadata
+	Interlace   bool
+	Page        int     // page of document counted from 1, 0 when not set
+	Profile     string  // handling of ICC profile (srgb, keep)
+	AutoQuality float64 // target SSIM score, 0 when quality is not chosen automatically
+	MaxBytes    int     // max size of result in bytes, 0 when not limited
+	Compression int     // zlib compression level of PNG
+	Speed       int     // encoder speed of HEIF/AVIF
+	Chroma      string  // chroma subsampling of JPEG (4:4:4, 4:2:0)
+	Effort      int     // encoder effort of WebP
+
+	Trim           bool        // uniform border should be removed before other operations
+	TrimTolerance  int         // max difference of channel (0-255) from background
+	TrimBackground *color.RGBA // color of border, nil when it should be detected
+
+	Sharpen   *SharpenParams   // unsharp mask, nil when image isn't sharpened
+	Duotone   *DuotoneParams   // applied after all other operations, nil when not set
+	Overlay   *OverlayParams   // applied after duotone, nil when not set
+	Composite *CompositeParams // applied after overlay, nil when not set
+	Pixelate  *PixelateParams  // applied to source before all other operations, nil when not set
+}
 
-				opts = append([]bimg.Options{bAutoCrop, bimg.Options{Width: t.autoCropWidth, Height: t.autoCropHeight, Crop: true, Gravity: bimg.GravityCentre}}, opts...)
-			}
+// PixelateParams holds regions of source image which should be pixelated
+type PixelateParams struct {
+	BlockSize int
+	Regions   []image.Rectangle // whole image is pixelated when there are no regions and Faces is false
+	Faces     bool              // faces found by face detector are pixelated
+}
+
+// DuotoneParams holds colors to which darkest and lightest pixels are mapped
+type DuotoneParams struct {
+	Shadow    color.RGBA
+	Highlight color.RGBA
+}
+
+// OverlayParams holds parameters of flat color overlay
+type OverlayParams struct {
+	Color   color.RGBA
+	Opacity float64 // 0-1
+	Mode    string  // one of Blend* modes
+}
+
+// CompositeParams holds parameters of image placed over result
+type CompositeParams struct {
+	Key      string      // key of image in bucket of transformed object
+	Image    image.Image // decoded image, nil until it is loaded from storage
+	Position string
+	Width    int // width of placed image, 0 when image keeps its size
+	Opacity  float64
+	Mode     string // one of Blend* modes
+}
+
+// Offset returns top left corner of image of size placedWidth x placedHeight placed on image of size width x height
+func (c CompositeParams) Offset(width, height, placedWidth, placedHeight int) (int, int) {
+	left, top, _ := compositeOffset(c.Position, width, height, placedWidth, placedHeight)
+	return left, top
+}
+
+// SharpenParams holds parameters of unsharp mask
+type SharpenParams struct {
+	Radius    float64 // radius of mask in px, 0 when it should be derived from sigma
+	Sigma     float64
+	Amount    float64
+	Threshold float64 // 0-1
+}
+
+// Params returns engine independent parameters of transform
+func (t *Transforms) Params() Params {
+	p := Params{
+		Width:       t.width,
+		Height:      t.height,
+		Crop:        t.crop,
+		Enlarge:     t.enlarge,
+		Quality:     t.quality,
+		Format:      t.FormatStr,
+		Blur:        t.blur.sigma,
+		Grayscale:   t.interpretation == interpretationBW,
+		Rotate:      int(t.rotate),
+		Strip:       t.stripMetadata,
+		KeepICC:     t.keepsICC(),
+		Interlace:   t.interlace,
+		Page:        t.page,
+		Profile:     t.colorProfile,
+		AutoQuality: t.autoQuality,
+		MaxBytes:    t.maxBytes,
+		Compression: t.compression,
+		Speed:       t.speed,
+		Chroma:      t.chromaSubsampling,
+		Effort:      t.effort,
+		Gravity:     t.gravityLabel(),
+
+		Trim:           t.trim,
+		TrimTolerance:  t.trimTolerance,
+		TrimBackground: t.trimBackground,
+	}
+
+	if t.SelectiveStrip() {
+		p.StripMode = t.stripMode
+		if p.StripMode == "" {
+			p.StripMode = StripAll
+		}
+	}
+
+	p.Duotone = t.duotone
+	p.Overlay = t.overlay
+	p.Composite = t.composite
+	p.Pixelate = t.pixelate
+
+	if t.sharpen.sigma != 0 {
+		p.Sharpen = &SharpenParams{Radius: t.sharpen.radius, Sigma: t.sharpen.sigma, Amount: t.sharpen.amount, Threshold: t.sharpen.threshold}
+	}
 
-		} else {
-			opts = append(opts, bAutoCrop, bimg.Options{Width: t.autoCropWidth, Height: t.autoCropHeight, Crop: true, Gravity: bimg.GravityCentre})
+	if t.areaWidth != 0 || t.areaHeight != 0 {
+		top := t.top
+		if top < 0 {
+			top = 0
 		}
+		r := image.Rect(t.left, top, t.left+t.areaWidth, top+t.areaHeight)
+		p.Extract = &r
 	}
 
-	return opts, nil
+	return p
 }
 
 //  FNV  for uint64
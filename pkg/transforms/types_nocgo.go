@@ -0,0 +1,47 @@
+//go:build !cgo
+// +build !cgo
+
+package transforms
+
+// Types of transform parameters, without cgo they mirror values of bimg types so encoded transforms are the same for both builds
+type (
+	ImageType      int
+	Angle          int
+	Interpretation int
+	Gravity        int
+)
+
+const (
+	formatUnknown ImageType = iota
+	formatJPEG
+	formatWEBP
+	formatPNG
+	formatTIFF
+	formatGIF
+	formatPDF
+	formatSVG
+	formatMagick
+	formatHEIF
+	formatAVIF
+)
+
+const (
+	angle0   Angle = 0
+	angle90  Angle = 90
+	angle180 Angle = 180
+	angle270 Angle = 270
+)
+
+const (
+	interpretationBW   Interpretation = 1
+	interpretationSRGB Interpretation = 22
+)
+
+const (
+	gravityCentre Gravity = iota
+	gravityNorth
+	gravityEast
+	gravitySouth
+	gravityWest
+	gravitySmart
+)
@@ -0,0 +1,99 @@
+package trash
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/aldor007/mort/pkg/config"
+	"github.com/aldor007/mort/pkg/middleware"
+	"github.com/aldor007/mort/pkg/monitoring"
+	"github.com/aldor007/mort/pkg/response"
+	"go.uber.org/zap"
+)
+
+// listResult is body of response with objects in trash
+type listResult struct {
+	Items  []Entry `json:"items"`
+	Marker string  `json:"marker,omitempty"`
+}
+
+// API allows to list and restore objects in trash of buckets
+type API struct {
+	config *config.Config
+	cfg    config.TrashCfg
+	auth   *middleware.AdminAuth
+}
+
+// NewAPI create trash API for given configuration
+func NewAPI(mortConfig *config.Config) *API {
+	return &API{
+		config: mortConfig,
+		cfg:    mortConfig.Server.Trash,
+		auth:   middleware.NewAdminAuthMiddleware(config.AdminCfg{Token: mortConfig.Server.Trash.Token}),
+	}
+}
+
+// Enabled check if API is configured
+func (a *API) Enabled() bool {
+	return a.cfg.Token != ""
+}
+
+// ServeHTTP handle requests of API
+// GET /trash/{bucket} returns objects in trash of bucket (paginated with marker query param)
+// POST /trash/{bucket}/{key} restore object with its transformed images
+func (a *API) ServeHTTP(resWriter http.ResponseWriter, req *http.Request) {
+	if !a.auth.Authorized(req) {
+		response.NewNoContent(401).Send(resWriter)
+		return
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(strings.TrimPrefix(req.URL.Path, "/trash"), "/"), "/", 2)
+	bucket, ok := a.config.Buckets[parts[0]]
+	if !ok || bucket.SoftDelete == nil {
+		response.NewNoContent(404).Send(resWriter)
+		return
+	}
+
+	if len(parts) == 1 || parts[1] == "" {
+		if req.Method != http.MethodGet {
+			response.NewNoContent(405).Send(resWriter)
+			return
+		}
+
+		entries, marker, err := List(bucket, req.URL.Query().Get("marker"))
+		if err != nil {
+			response.NewError(503, err).Send(resWriter)
+			return
+		}
+
+		buf, err := json.Marshal(listResult{Items: entries, Marker: marker})
+		if err != nil {
+			response.NewError(500, err).Send(resWriter)
+			return
+		}
+
+		response.NewBuf(200, buf).SetContentType("application/json").Send(resWriter)
+		return
+	}
+
+	if req.Method != http.MethodPost {
+		response.NewNoContent(405).Send(resWriter)
+		return
+	}
+
+	key := "/" + parts[1]
+	res := Restore(bucket, key)
+	defer res.Close()
+	if res.HasError() {
+		response.NewString(res.StatusCode, res.Error().Error()).Send(resWriter)
+		return
+	} else if res.StatusCode != 200 {
+		response.NewNoContent(res.StatusCode).Send(resWriter)
+		return
+	}
+
+	monitoring.Log().Info("Trash/API object restored", zap.String("bucket", bucket.Name), zap.String("key", key),
+		zap.String("req.remoteAddr", req.RemoteAddr))
+	response.NewNoContent(200).Send(resWriter)
+}
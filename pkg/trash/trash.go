@@ -0,0 +1,294 @@
+// Package trash implements soft delete of objects
+// Deleted objects are moved to trash prefix of bucket, from which they can be restored until they expire
+package trash
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aldor007/mort/pkg/config"
+	"github.com/aldor007/mort/pkg/monitoring"
+	"github.com/aldor007/mort/pkg/object"
+	"github.com/aldor007/mort/pkg/response"
+	"github.com/aldor007/mort/pkg/storage"
+	"go.uber.org/zap"
+)
+
+// listPageSize number of objects fetched from storage in single list request
+const listPageSize = 1000
+
+var (
+	errNotInTrash = errors.New("object isn't in trash")
+	errExists     = errors.New("object with the same key exists")
+)
+
+// Entry describe object in trash
+type Entry struct {
+	Key       string    `json:"key"` // key of object before it was deleted
+	Size      int64     `json:"size"`
+	DeletedAt time.Time `json:"deletedAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+func newObject(bucketName, key string, storageCfg config.Storage) *object.FileObject {
+	return &object.FileObject{Uri: &url.URL{Path: "/" + bucketName + key}, Bucket: bucketName, Key: key, Storage: storageCfg}
+}
+
+// IsTrashed check if key is in trash of bucket, objects in trash can be accessed only by trash API
+func IsTrashed(bucket config.Bucket, key string) bool {
+	if bucket.SoftDelete == nil {
+		return false
+	}
+
+	prefix := bucket.SoftDelete.Prefix
+	return key == prefix || strings.HasPrefix(key, prefix+"/")
+}
+
+// Move moves object to trash of bucket, its transformed images are moved as well when bucket is configured to do so
+// Missing object isn't an error, the same as for DELETE
+func Move(obj *object.FileObject, bucket config.Bucket) *response.Response {
+	cfg := bucket.SoftDelete
+	res := move(obj, cfg.Prefix+obj.Key)
+	if res.StatusCode == 404 {
+		res.Close()
+		return response.NewNoContent(200)
+	}
+
+	if res.StatusCode != 200 {
+		return res
+	}
+
+	if cfg.Derivatives {
+		prefix := object.DerivativesPrefix(obj.Key)
+		if err := moveAll(bucket.Name, bucket.Storages.Result(bucket.Transform.ResultStorage), prefix, cfg.Prefix+prefix); err != nil {
+			monitoring.Log().Warn("Trash/Move unable to move transformed images", obj.LogData(zap.Error(err))...)
+		}
+	}
+
+	monitoring.Report().Inc("trash_count;action:move")
+	return res
+}
+
+// Restore moves object with given key from trash of bucket back, together with its transformed images
+// Object isn't restored when other object with the same key was stored after deletion
+func Restore(bucket config.Bucket, key string) *response.Response {
+	cfg := bucket.SoftDelete
+	basic := bucket.Storages.Basic()
+	head := storage.Head(newObject(bucket.Name, key, basic))
+	head.Close()
+	if head.StatusCode == 200 {
+		return response.NewError(409, errExists)
+	}
+
+	res := move(newObject(bucket.Name, cfg.Prefix+key, basic), key)
+	if res.StatusCode == 404 {
+		res.Close()
+		return response.NewError(404, errNotInTrash)
+	}
+
+	if res.StatusCode != 200 {
+		return res
+	}
+
+	if cfg.Derivatives {
+		prefix := object.DerivativesPrefix(key)
+		if err := moveAll(bucket.Name, bucket.Storages.Result(bucket.Transform.ResultStorage), cfg.Prefix+prefix, prefix); err != nil {
+			monitoring.Log().Warn("Trash/Restore unable to restore transformed images", zap.String("bucket", bucket.Name), zap.String("key", key), zap.Error(err))
+		}
+	}
+
+	monitoring.Report().Inc("trash_count;action:restore")
+	return res
+}
+
+// List returns page of objects in trash of bucket and marker of next page, empty marker means that there are no more objects
+func List(bucket config.Bucket, marker string) ([]Entry, string, error) {
+	cfg := bucket.SoftDelete
+	listObj := newObject(bucket.Name, "", bucket.Storages.Basic())
+	items, nextMarker, err := storage.ListItems(listObj, strings.TrimPrefix(cfg.Prefix, "/"), marker, listPageSize)
+	if err != nil {
+		return nil, "", err
+	}
+
+	entries := make([]Entry, 0, len(items))
+	ttl := time.Duration(cfg.TTL) * time.Second
+	for _, item := range items {
+		key := "/" + item.Key
+		if !IsTrashed(bucket, key) {
+			continue
+		}
+
+		entries = append(entries, Entry{Key: strings.TrimPrefix(key, cfg.Prefix), Size: item.Size, DeletedAt: item.LastModified, ExpiresAt: item.LastModified.Add(ttl)})
+	}
+
+	if nextMarker == marker {
+		nextMarker = ""
+	}
+
+	return entries, nextMarker, nil
+}
+
+// move copy object to other key in the same storage and removes it
+func move(src *object.FileObject, dstKey string) *response.Response {
+	res := storage.Get(src)
+	if res.StatusCode != 200 {
+		return res
+	}
+
+	setRes := storage.Set(newObject(src.Bucket, dstKey, src.Storage), res.Headers.Clone(), res.ContentLength, res.Stream())
+	res.Close()
+	if setRes.StatusCode != 200 {
+		return setRes
+	}
+	setRes.Close()
+
+	return storage.Delete(src)
+}
+
+// moveAll moves all objects which keys start with srcPrefix to dstPrefix
+func moveAll(bucketName string, storageCfg config.Storage, srcPrefix, dstPrefix string) error {
+	listObj := newObject(bucketName, "", storageCfg)
+	marker := ""
+	for {
+		items, nextMarker, err := storage.ListItems(listObj, strings.TrimPrefix(srcPrefix, "/"), marker, listPageSize)
+		if err != nil {
+			return err
+		}
+
+		for _, item := range items {
+			key := "/" + item.Key
+			if !strings.HasPrefix(key, srcPrefix) {
+				continue
+			}
+
+			res := move(newObject(bucketName, key, storageCfg), dstPrefix+strings.TrimPrefix(key, srcPrefix))
+			res.Close()
+			if res.StatusCode != 200 {
+				return fmt.Errorf("unable to move %s, status code %d", key, res.StatusCode)
+			}
+		}
+
+		if nextMarker == "" || nextMarker == marker {
+			return nil
+		}
+		marker = nextMarker
+	}
+}
+
+// Cleaner periodically removes expired objects from trash of buckets
+type Cleaner struct {
+	config *config.Config
+	stop   chan struct{}
+	wg     sync.WaitGroup
+	now    func() time.Time
+}
+
+// NewCleaner create cleaner of trash of buckets with soft delete
+func NewCleaner(cfg *config.Config) *Cleaner {
+	return &Cleaner{
+		config: cfg,
+		stop:   make(chan struct{}),
+		now:    time.Now,
+	}
+}
+
+// Start run cleaner in background when any bucket has soft delete
+func (c *Cleaner) Start() {
+	for _, bucket := range c.config.Buckets {
+		if bucket.SoftDelete != nil {
+			c.wg.Add(1)
+			go c.loop(time.Duration(c.config.Server.Trash.Interval) * time.Second)
+			return
+		}
+	}
+}
+
+// Stop wait for cleaner to finish
+func (c *Cleaner) Stop() {
+	close(c.stop)
+	c.wg.Wait()
+}
+
+func (c *Cleaner) loop(interval time.Duration) {
+	defer c.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			for name, bucket := range c.config.Buckets {
+				if bucket.SoftDelete == nil {
+					continue
+				}
+
+				removed, err := c.Clean(name)
+				if err != nil {
+					monitoring.Log().Warn("Trash/Cleaner unable to clean trash", zap.String("bucket", name), zap.Error(err))
+				} else {
+					monitoring.Log().Info("Trash/Cleaner trash cleaned", zap.String("bucket", name), zap.Int("removed", removed))
+				}
+			}
+		}
+	}
+}
+
+// Clean removes expired objects from trash of bucket and returns number of removed objects
+func (c *Cleaner) Clean(bucketName string) (int, error) {
+	bucket, ok := c.config.Buckets[bucketName]
+	if !ok || bucket.SoftDelete == nil {
+		return 0, nil
+	}
+
+	cfg := bucket.SoftDelete
+	expiration := c.now().Add(-time.Duration(cfg.TTL) * time.Second)
+	removed, err := removeExpired(bucketName, bucket.Storages.Basic(), cfg.Prefix, expiration)
+	if err != nil || !cfg.Derivatives {
+		return removed, err
+	}
+
+	_, err = removeExpired(bucketName, bucket.Storages.Result(bucket.Transform.ResultStorage), cfg.Prefix, expiration)
+	return removed, err
+}
+
+// removeExpired removes objects with given prefix modified before expiration and returns number of removed objects
+func removeExpired(bucketName string, storageCfg config.Storage, prefix string, expiration time.Time) (int, error) {
+	listObj := newObject(bucketName, "", storageCfg)
+	removed := 0
+	marker := ""
+	for {
+		items, nextMarker, err := storage.ListItems(listObj, strings.TrimPrefix(prefix, "/"), marker, listPageSize)
+		if err != nil {
+			return removed, err
+		}
+
+		for _, item := range items {
+			key := "/" + item.Key
+			if !strings.HasPrefix(key, prefix+"/") || !item.LastModified.Before(expiration) {
+				continue
+			}
+
+			obj := newObject(bucketName, key, storageCfg)
+			res := storage.Delete(obj)
+			res.Close()
+			if res.StatusCode != 200 {
+				monitoring.Log().Warn("Trash/Cleaner unable to remove object", obj.LogData(zap.Int("statusCode", res.StatusCode), zap.Error(res.Error()))...)
+				continue
+			}
+
+			removed++
+			monitoring.Report().Inc("trash_count;action:expire")
+		}
+
+		if nextMarker == "" || nextMarker == marker {
+			return removed, nil
+		}
+		marker = nextMarker
+	}
+}
@@ -0,0 +1,159 @@
+package trash
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aldor007/mort/pkg/config"
+	"github.com/aldor007/mort/pkg/object"
+	"github.com/aldor007/mort/pkg/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+const testConfig = `
+server:
+    trash:
+        token: "secret"
+buckets:
+    %s:
+        softDelete:
+            ttl: 3600
+            derivatives: true
+        transform:
+            path: "\\/(?P<presetName>[a-z0-9_]+)\\/(?P<parent>.*)"
+            kind: "presets"
+            resultKey: "hashParent"
+            presets:
+                small:
+                    filters:
+                        thumbnail:
+                            width: 150
+        storages:
+            basic:
+                kind: "local-meta"
+                rootPath: "%s/originals"
+            transform:
+                kind: "local-meta"
+                rootPath: "%s/derivatives"
+`
+
+// testBucket create bucket with soft delete in temporary directory
+// Storage clients are cached by bucket name, so every test should use other bucket
+func testBucket(t *testing.T, bucketName string) (*config.Config, config.Bucket) {
+	dir, err := ioutil.TempDir("", "mort-trash")
+	assert.Nil(t, err)
+	t.Cleanup(func() {
+		os.RemoveAll(dir)
+	})
+	os.MkdirAll(dir+"/originals", 0755)
+	os.MkdirAll(dir+"/derivatives", 0755)
+
+	mortConfig := &config.Config{}
+	err = mortConfig.LoadFromString(fmt.Sprintf(testConfig, bucketName, dir, dir))
+	assert.Nil(t, err)
+	return mortConfig, mortConfig.Buckets[bucketName]
+}
+
+func store(t *testing.T, bucketName, key string, storageCfg config.Storage) *object.FileObject {
+	obj := newObject(bucketName, key, storageCfg)
+	res := storage.Set(obj, http.Header{}, 4, bytes.NewReader([]byte("data")))
+	assert.Equal(t, 200, res.StatusCode)
+	return obj
+}
+
+func TestMoveAndRestore(t *testing.T) {
+	_, bucket := testBucket(t, "move")
+	assert.Equal(t, "/.trash", bucket.SoftDelete.Prefix)
+	original := store(t, "move", "/dir/photo.jpg", bucket.Storages.Basic())
+	derivative := store(t, "move", object.DerivativesPrefix("/dir/photo.jpg")+"abc", bucket.Storages.Transform())
+
+	res := Move(original, bucket)
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Equal(t, 404, storage.Head(original).StatusCode)
+	assert.Equal(t, 404, storage.Head(derivative).StatusCode)
+	assert.True(t, IsTrashed(bucket, "/.trash/dir/photo.jpg"))
+	assert.False(t, IsTrashed(bucket, "/dir/photo.jpg"))
+
+	entries, _, err := List(bucket, "")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(entries))
+	assert.Equal(t, "/dir/photo.jpg", entries[0].Key)
+	assert.Equal(t, int64(4), entries[0].Size)
+
+	res = Move(original, bucket)
+	assert.Equal(t, 200, res.StatusCode, "missing object should be deleted without error")
+
+	res = Restore(bucket, "/dir/photo.jpg")
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Equal(t, 200, storage.Head(original).StatusCode)
+	assert.Equal(t, 200, storage.Head(derivative).StatusCode)
+
+	res = Restore(bucket, "/dir/photo.jpg")
+	assert.Equal(t, 409, res.StatusCode)
+
+	res = Restore(bucket, "/missing.jpg")
+	assert.Equal(t, 404, res.StatusCode)
+}
+
+func TestCleanerClean(t *testing.T) {
+	mortConfig, bucket := testBucket(t, "clean")
+	original := store(t, "clean", "/photo.jpg", bucket.Storages.Basic())
+	Move(original, bucket)
+
+	cleaner := NewCleaner(mortConfig)
+	removed, err := cleaner.Clean("clean")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, removed)
+
+	cleaner.now = func() time.Time {
+		return time.Now().Add(2 * time.Hour)
+	}
+	removed, err = cleaner.Clean("clean")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, removed)
+
+	res := Restore(bucket, "/photo.jpg")
+	assert.Equal(t, 404, res.StatusCode)
+}
+
+func TestAPI(t *testing.T) {
+	mortConfig, bucket := testBucket(t, "api")
+	original := store(t, "api", "/photo.jpg", bucket.Storages.Basic())
+	Move(original, bucket)
+
+	api := NewAPI(mortConfig)
+	assert.True(t, api.Enabled())
+
+	send := func(method, path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		recorder := httptest.NewRecorder()
+		api.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	assert.Equal(t, 401, send("GET", "/trash/api", "").Code)
+	assert.Equal(t, 404, send("GET", "/trash/unknown", "secret").Code)
+
+	recorder := send("GET", "/trash/api", "secret")
+	assert.Equal(t, 200, recorder.Code)
+	result := listResult{}
+	assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &result))
+	assert.Equal(t, 1, len(result.Items))
+	assert.Equal(t, "/photo.jpg", result.Items[0].Key)
+
+	assert.Equal(t, 405, send("DELETE", "/trash/api/photo.jpg", "secret").Code)
+	assert.Equal(t, 200, send("POST", "/trash/api/photo.jpg", "secret").Code)
+	assert.Equal(t, 200, storage.Head(original).StatusCode)
+	assert.Equal(t, 401, send("POST", "/trash/api/photo.jpg", "other").Code)
+	assert.Equal(t, 409, send("POST", "/trash/api/photo.jpg", "secret").Code)
+}
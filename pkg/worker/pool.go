@@ -0,0 +1,209 @@
+package worker
+
+import (
+	"context"
+	"encoding/gob"
+	"errors"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/aldor007/mort/pkg/config"
+	"github.com/aldor007/mort/pkg/engine"
+	"github.com/aldor007/mort/pkg/monitoring"
+	"github.com/aldor007/mort/pkg/object"
+	"github.com/aldor007/mort/pkg/response"
+	"github.com/aldor007/mort/pkg/transforms"
+	"go.uber.org/zap"
+)
+
+// ErrWorkerCrashed returned when worker process died or timed out during transform
+var ErrWorkerCrashed = errors.New("worker process crashed during transform")
+
+// startTimeout max time of waiting for socket of started worker
+const startTimeout = 5 * time.Second
+
+// process is running worker process with connection to it
+type process struct {
+	cmd  *exec.Cmd
+	conn net.Conn
+	enc  *gob.Encoder
+	dec  *gob.Decoder
+	jobs int
+}
+
+// Pool supervise worker processes, every worker performs one job at once
+// Worker which crashed is replaced by new process, so malformed image doesn't kill mort
+type Pool struct {
+	command   []string // command starting worker, socket path is appended to it
+	socketDir string
+	maxJobs   int
+	timeout   time.Duration
+	slots     chan *process // idle workers, nil when worker has to be started
+	started   int64         // number of started workers, used in names of sockets
+}
+
+// NewPool create pool of worker processes running mort executable with worker subcommand
+// It returns nil when workers are not configured, processes are started on first use
+func NewPool(cfg config.WorkersCfg) *Pool {
+	if cfg.Count <= 0 {
+		return nil
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		monitoring.Log().Error("Worker/NewPool unable to find mort executable", zap.Error(err))
+		return nil
+	}
+
+	return newPool(cfg, []string{executable, "worker", "-socket"})
+}
+
+func newPool(cfg config.WorkersCfg, command []string) *Pool {
+	p := &Pool{command: command, socketDir: cfg.SocketDir, maxJobs: cfg.MaxJobs, timeout: time.Duration(cfg.Timeout) * time.Second,
+		slots: make(chan *process, cfg.Count)}
+	for i := 0; i < cfg.Count; i++ {
+		p.slots <- nil
+	}
+
+	return p
+}
+
+// start run new worker process and connect to it
+func (p *Pool) start() (*process, error) {
+	n := atomic.AddInt64(&p.started, 1)
+	socketPath := filepath.Join(p.socketDir, "mort-worker-"+strconv.Itoa(os.Getpid())+"-"+strconv.FormatInt(n, 10)+".sock")
+
+	args := append(append([]string{}, p.command[1:]...), socketPath)
+	cmd := exec.Command(p.command[0], args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(startTimeout)
+	for {
+		conn, err := net.Dial("unix", socketPath)
+		if err == nil {
+			monitoring.Report().Inc("worker_count;event:start")
+			return &process{cmd: cmd, conn: conn, enc: gob.NewEncoder(conn), dec: gob.NewDecoder(conn)}, nil
+		}
+
+		if time.Now().After(deadline) {
+			cmd.Process.Kill()
+			cmd.Wait()
+			return nil, err
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// stop close connection to worker, so it exits, or kill it when it is stuck
+func (w *process) stop(kill bool) {
+	w.conn.Close()
+	if kill {
+		w.cmd.Process.Kill()
+	}
+	go w.cmd.Wait()
+}
+
+// Run send job to idle worker and wait for its result
+func (p *Pool) Run(ctx context.Context, job Job) (Result, error) {
+	var w *process
+	select {
+	case w = <-p.slots:
+	case <-ctx.Done():
+		return Result{}, ctx.Err()
+	}
+
+	if w == nil {
+		var err error
+		if w, err = p.start(); err != nil {
+			p.slots <- nil
+			monitoring.Log().Error("Worker/Run unable to start worker", zap.Error(err))
+			return Result{}, err
+		}
+	}
+
+	w.conn.SetDeadline(time.Now().Add(p.timeout))
+	result := Result{}
+	err := w.enc.Encode(job)
+	if err == nil {
+		err = w.dec.Decode(&result)
+	}
+	if err != nil {
+		// worker died (e.g. segfault in decoder) or didn't finish in time
+		monitoring.Log().Error("Worker/Run worker crashed", zap.String("uri", job.Uri), zap.Int("pid", w.cmd.Process.Pid), zap.Error(err))
+		monitoring.Report().Inc("worker_count;event:crash")
+		w.stop(true)
+		p.slots <- nil
+		return Result{}, ErrWorkerCrashed
+	}
+
+	w.jobs++
+	if p.maxJobs > 0 && w.jobs >= p.maxJobs {
+		// memory of long running worker is released by replacing it
+		monitoring.Report().Inc("worker_count;event:recycle")
+		w.stop(false)
+		w = nil
+	}
+	p.slots <- w
+
+	return result, nil
+}
+
+// Close stops all workers, it waits for workers which are performing jobs
+func (p *Pool) Close() {
+	for i := 0; i < cap(p.slots); i++ {
+		if w := <-p.slots; w != nil {
+			w.stop(false)
+		}
+	}
+}
+
+// Engine returns engine performing transforms of parent in worker process
+func (p *Pool) Engine(name string, parent *response.Response) engine.Engine {
+	return poolEngine{pool: p, name: name, parent: parent}
+}
+
+type poolEngine struct {
+	pool   *Pool
+	name   string
+	parent *response.Response
+}
+
+// Process send parent and transforms to worker and returns its result
+func (e poolEngine) Process(obj *object.FileObject, trans []transforms.Transforms) (*response.Response, error) {
+	body, err := e.parent.Body()
+	if err != nil {
+		return response.NewError(500, err), err
+	}
+
+	ctx := obj.Ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	job := Job{Engine: e.name, Uri: obj.Uri.String(), Bucket: obj.Bucket, Key: obj.Key, Headers: e.parent.Headers, Body: body, Transforms: trans}
+	result, err := e.pool.Run(ctx, job)
+	if err != nil {
+		return response.NewError(500, err), err
+	}
+
+	if result.Error != "" {
+		err = errors.New(result.Error)
+		return response.NewError(500, err), err
+	}
+
+	res := response.NewBuf(result.StatusCode, result.Body)
+	for name, values := range result.Headers {
+		res.Headers[name] = values
+	}
+
+	return res, nil
+}
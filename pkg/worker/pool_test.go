@@ -0,0 +1,107 @@
+package worker
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/aldor007/mort/pkg/config"
+	"github.com/aldor007/mort/pkg/engine"
+	"github.com/aldor007/mort/pkg/object"
+	"github.com/aldor007/mort/pkg/response"
+	"github.com/aldor007/mort/pkg/transforms"
+	"github.com/stretchr/testify/assert"
+)
+
+type echoEngine struct {
+	parent *response.Response
+}
+
+func (e echoEngine) Process(obj *object.FileObject, trans []transforms.Transforms) (*response.Response, error) {
+	body, _ := e.parent.Body()
+	res := response.NewBuf(200, body)
+	res.Set("X-Worker-Pid", strconv.Itoa(os.Getpid()))
+	return res, nil
+}
+
+type crashEngine struct{}
+
+func (crashEngine) Process(obj *object.FileObject, trans []transforms.Transforms) (*response.Response, error) {
+	// simulates segfault of decoder
+	os.Exit(3)
+	return nil, nil
+}
+
+func init() {
+	engine.RegisterEngine("test-echo", engine.Capabilities{}, func(parent *response.Response) engine.Engine {
+		return echoEngine{parent: parent}
+	})
+	engine.RegisterEngine("test-crash", engine.Capabilities{}, func(parent *response.Response) engine.Engine {
+		return crashEngine{}
+	})
+}
+
+// TestHelperWorker is not real test, it is run as worker process by pools in tests
+func TestHelperWorker(t *testing.T) {
+	if os.Getenv("MORT_TEST_WORKER") != "1" {
+		return
+	}
+
+	Serve(os.Args[len(os.Args)-1])
+	os.Exit(0)
+}
+
+func testPool(t *testing.T, maxJobs int) *Pool {
+	os.Setenv("MORT_TEST_WORKER", "1")
+	p := newPool(config.WorkersCfg{Count: 1, MaxJobs: maxJobs, SocketDir: t.TempDir(), Timeout: 10}, []string{os.Args[0], "-test.run=TestHelperWorker", "--"})
+	t.Cleanup(func() {
+		p.Close()
+		os.Unsetenv("MORT_TEST_WORKER")
+	})
+	return p
+}
+
+func testObject() *object.FileObject {
+	return &object.FileObject{Uri: &url.URL{Path: "/bucket/image.png"}, Bucket: "bucket", Key: "/image.png", Ctx: context.Background()}
+}
+
+func TestPoolEngine(t *testing.T) {
+	p := testPool(t, 0)
+	parent := response.NewBuf(200, []byte("image"))
+	parent.SetContentType("image/png")
+
+	res, err := p.Engine("test-echo", parent).Process(testObject(), []transforms.Transforms{transforms.New()})
+	assert.Nil(t, err)
+	assert.Equal(t, 200, res.StatusCode)
+
+	body, _ := res.Body()
+	assert.Equal(t, []byte("image"), body)
+	assert.NotEqual(t, strconv.Itoa(os.Getpid()), res.Headers.Get("X-Worker-Pid"), "transform should be performed in other process")
+}
+
+func TestPoolCrash(t *testing.T) {
+	p := testPool(t, 0)
+
+	_, err := p.Engine("test-crash", response.NewBuf(200, []byte("bomb"))).Process(testObject(), nil)
+	assert.Equal(t, ErrWorkerCrashed, err)
+
+	res, err := p.Engine("test-echo", response.NewBuf(200, []byte("image"))).Process(testObject(), nil)
+	assert.Nil(t, err, "crashed worker should be replaced")
+	assert.Equal(t, 200, res.StatusCode)
+}
+
+func TestPoolRecycle(t *testing.T) {
+	p := testPool(t, 2)
+
+	pids := make([]string, 0, 3)
+	for i := 0; i < 3; i++ {
+		result, err := p.Run(context.Background(), Job{Engine: "test-echo", Uri: "/bucket/image.png"})
+		assert.Nil(t, err)
+		pids = append(pids, result.Headers.Get("X-Worker-Pid"))
+	}
+
+	assert.Equal(t, pids[0], pids[1])
+	assert.NotEqual(t, pids[1], pids[2], "worker should be replaced after maxJobs")
+}
@@ -0,0 +1,101 @@
+package worker
+
+import (
+	"context"
+	"encoding/gob"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/aldor007/mort/pkg/engine"
+	"github.com/aldor007/mort/pkg/object"
+	"github.com/aldor007/mort/pkg/response"
+	"github.com/aldor007/mort/pkg/transforms"
+)
+
+// Job is transform sent to worker process
+type Job struct {
+	Engine     string
+	Uri        string
+	Bucket     string
+	Key        string
+	Headers    http.Header // headers of source image
+	Body       []byte      // content of source image
+	Transforms []transforms.Transforms
+}
+
+// Result is response of worker process to job
+type Result struct {
+	StatusCode int
+	Headers    http.Header
+	Body       []byte
+	Error      string // error of engine, empty when transform succeeded
+}
+
+// Serve listen on unix socket for connection of supervisor and performs its jobs one after another
+// It returns when supervisor closes connection
+func Serve(socketPath string) error {
+	os.Remove(socketPath)
+	ln, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return err
+	}
+
+	conn, err := ln.Accept()
+	// only supervisor which started worker is served
+	ln.Close()
+	os.Remove(socketPath)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	dec := gob.NewDecoder(conn)
+	enc := gob.NewEncoder(conn)
+	for {
+		job := Job{}
+		if err := dec.Decode(&job); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if err := enc.Encode(runJob(job)); err != nil {
+			return err
+		}
+	}
+}
+
+// runJob perform transforms of job with engine
+func runJob(job Job) Result {
+	parent := response.NewBuf(200, job.Body)
+	for name, values := range job.Headers {
+		parent.Headers[name] = values
+	}
+
+	eng, err := engine.New(job.Engine, parent)
+	if err != nil {
+		return Result{StatusCode: 500, Error: err.Error()}
+	}
+
+	uri, err := url.Parse(job.Uri)
+	if err != nil {
+		return Result{StatusCode: 500, Error: err.Error()}
+	}
+
+	obj := &object.FileObject{Uri: uri, Bucket: job.Bucket, Key: job.Key, Ctx: context.Background()}
+	res, err := eng.Process(obj, job.Transforms)
+	if err != nil {
+		return Result{StatusCode: 500, Error: err.Error()}
+	}
+	defer res.Close()
+
+	body, err := res.Body()
+	if err != nil {
+		return Result{StatusCode: 500, Error: err.Error()}
+	}
+
+	return Result{StatusCode: res.StatusCode, Headers: res.Headers, Body: body}
+}